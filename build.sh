@@ -1,3 +1,4 @@
 #!/bin/bash
+# Set TAGS to leave modules out, e.g. TAGS="nodashboard nosinks" ./build.sh
 export GOPATH=$PWD
-go build -o bin/caplog main
\ No newline at end of file
+go build -tags "$TAGS" -ldflags "-X main.version=$(git describe --always --dirty 2>/dev/null || echo dev)" -o bin/caplog main
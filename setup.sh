@@ -1,3 +1,4 @@
 #!/bin/bash
 export GOPATH=$PWD
 go get -u github.com/google/gopacket
+go get -u github.com/fsnotify/fsnotify
@@ -0,0 +1,222 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package asn looks up the autonomous system (AS) that announces an IP
+// address, from a MaxMind-format database (such as GeoLite2-ASN.mmdb) or a
+// Routeviews prefix-to-AS dump (routeviews-rv2-*.pfx2as, gzipped or not).
+package asn
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Info describes an autonomous system.
+type Info struct {
+	Number uint32
+	Org    string `json:",omitempty"` // empty if unknown
+}
+
+// String formats the AS like "AS13335 CLOUDFLARENET".
+func (i Info) String() string {
+	s := "AS" + strconv.FormatUint(uint64(i.Number), 10)
+	if i.Org != "" {
+		s += " " + i.Org
+	}
+	return s
+}
+
+// Table looks up the AS of addresses.
+type Table interface {
+	// Lookup returns the AS announcing the address, if known.
+	Lookup(ip net.IP) (Info, bool)
+}
+
+// Open loads a table from a MaxMind database or a pfx2as dump. If names
+// isn't empty, it is a file of AS names, one "number name" per line (like
+// https://ftp.ripe.net/ripe/asnames/asn.txt), that fills in the names the
+// table lacks; pfx2as dumps have none.
+func Open(path, names string) (Table, error) {
+	b, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Table
+	if bytes.Contains(b, metadataMarker) {
+		t, err = newMMDB(b)
+	} else {
+		t, err = parsePfx2as(bytes.NewReader(b))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if names == "" {
+		return t, nil
+	}
+	nb, err := readFile(names)
+	if err != nil {
+		return nil, err
+	}
+	n, err := parseNames(bytes.NewReader(nb))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", names, err)
+	}
+	return named{t, n}, nil
+}
+
+// readFile reads a file, gunzipping it if need be.
+func readFile(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 2 || b[0] != 0x1f || b[1] != 0x8b {
+		return b, nil
+	}
+	z, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	defer z.Close()
+	b, err = ioutil.ReadAll(z)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return b, nil
+}
+
+// named fills in the names missing from a table.
+type named struct {
+	Table
+	names map[uint32]string
+}
+
+func (n named) Lookup(ip net.IP) (Info, bool) {
+	i, ok := n.Table.Lookup(ip)
+	if ok && i.Org == "" {
+		i.Org = n.names[i.Number]
+	}
+	return i, ok
+}
+
+// parseNames reads "number name" lines. The number may start with "AS".
+func parseNames(r io.Reader) (map[uint32]string, error) {
+	names := make(map[uint32]string)
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		f := strings.TrimSpace(s.Text())
+		if f == "" || f[0] == '#' {
+			continue
+		}
+		num, name := f, ""
+		if i := strings.IndexAny(f, " \t"); i >= 0 {
+			num, name = f[:i], strings.TrimSpace(f[i:])
+		}
+		n, err := strconv.ParseUint(strings.TrimPrefix(num, "AS"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad AS number %q", line, num)
+		}
+		names[uint32(n)] = name
+	}
+	return names, s.Err()
+}
+
+// prefixTable is a pfx2as dump: the AS of each prefix, by prefix length, for
+// longest-prefix matching.
+type prefixTable struct {
+	byLen   map[int]map[[16]byte]uint32
+	lengths []int // the lengths in byLen, longest first
+}
+
+// parsePfx2as reads "prefix<tab>length<tab>AS" lines. Multi-origin prefixes
+// ("701_702") and AS sets ("{701,702}") are taken as their first AS.
+func parsePfx2as(r io.Reader) (*prefixTable, error) {
+	t := &prefixTable{byLen: make(map[int]map[[16]byte]uint32)}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		f := strings.Fields(s.Text())
+		if len(f) == 0 || f[0][0] == '#' {
+			continue
+		}
+		if len(f) != 3 {
+			return nil, fmt.Errorf("line %d: want prefix, length and AS", line)
+		}
+		ip := net.ParseIP(f[0])
+		bits, err := strconv.Atoi(f[1])
+		if ip == nil || err != nil || bits < 0 || bits > 128 || (ip.To4() != nil && bits > 32) {
+			return nil, fmt.Errorf("line %d: bad prefix %s/%s", line, f[0], f[1])
+		}
+		as := strings.TrimLeft(f[2], "{")
+		if i := strings.IndexAny(as, "_,}"); i >= 0 {
+			as = as[:i]
+		}
+		n, err := strconv.ParseUint(as, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad AS %q", line, f[2])
+		}
+		if ip.To4() != nil {
+			bits += 96 // IPv4-mapped
+		}
+		m := t.byLen[bits]
+		if m == nil {
+			m = make(map[[16]byte]uint32)
+			t.byLen[bits] = m
+			t.lengths = append(t.lengths, bits)
+		}
+		m[maskKey(ip, bits)] = uint32(n)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(t.lengths)))
+	return t, nil
+}
+
+// maskKey is the 16-byte form of ip with all but the first bits cleared.
+func maskKey(ip net.IP, bits int) [16]byte {
+	var k [16]byte
+	copy(k[:], ip.To16())
+	for i := range k {
+		switch {
+		case bits >= 8:
+			bits -= 8
+		case bits > 0:
+			k[i] &= ^byte(0xff >> uint(bits))
+			bits = 0
+		default:
+			k[i] = 0
+		}
+	}
+	return k
+}
+
+func (t *prefixTable) Lookup(ip net.IP) (Info, bool) {
+	if ip.To16() == nil {
+		return Info{}, false
+	}
+	for _, bits := range t.lengths {
+		if n, ok := t.byLen[bits][maskKey(ip, bits)]; ok {
+			return Info{Number: n}, true
+		}
+	}
+	return Info{}, false
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asn
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPfx2as = `1.0.0.0	24	13335
+8.0.0.0	8	3356
+8.8.8.0	24	15169
+9.9.9.0	24	19281_42
+64.0.0.0	10	{701,702}
+2606:4700::	32	13335
+`
+
+func TestPrefixTable(t *testing.T) {
+	tab, err := parsePfx2as(strings.NewReader(testPfx2as))
+	if err != nil {
+		t.Fatalf("parsePfx2as: %v", err)
+	}
+	tests := []struct {
+		ip   string
+		want uint32
+	}{
+		{"1.0.0.1", 13335},
+		{"1.0.1.1", 0},
+		{"8.8.8.8", 15169}, // the longest prefix
+		{"8.8.4.4", 3356},
+		{"9.9.9.9", 19281},
+		{"64.1.2.3", 701},
+		{"2606:4700::1111", 13335},
+		{"2001:db8::1", 0},
+	}
+	for _, test := range tests {
+		got, ok := tab.Lookup(net.ParseIP(test.ip))
+		if got.Number != test.want || ok != (test.want != 0) {
+			t.Errorf("Lookup(%s): got %v, %v, want AS%d", test.ip, got, ok, test.want)
+		}
+	}
+}
+
+func TestParsePfx2asErrors(t *testing.T) {
+	for _, in := range []string{
+		"1.0.0.0\t24\n",
+		"1.0.0.0\t33\t13335\n",
+		"nonsense\t24\t13335\n",
+		"1.0.0.0\t24\tAS13335\n",
+	} {
+		if _, err := parsePfx2as(strings.NewReader(in)); err == nil {
+			t.Errorf("parsePfx2as(%q): got no error, want one", in)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var gz bytes.Buffer
+	z := gzip.NewWriter(&gz)
+	z.Write([]byte(testPfx2as))
+	z.Close()
+	pfx2as := filepath.Join(dir, "routeviews.pfx2as.gz")
+	names := filepath.Join(dir, "asn.txt")
+	w := newMMDBWriter(6, 28)
+	w.insert("8.8.8.0/24", asRecord(15169, ""))
+	mmdb := filepath.Join(dir, "GeoLite2-ASN.mmdb")
+	for path, b := range map[string][]byte{
+		pfx2as: gz.Bytes(),
+		names:  []byte("# comment\n13335 CLOUDFLARENET, US\nAS15169 GOOGLE, US\n"),
+		mmdb:   w.bytes(),
+	} {
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path, names, ip string
+		want            Info
+	}{
+		{pfx2as, "", "1.0.0.1", Info{13335, ""}},
+		{pfx2as, names, "1.0.0.1", Info{13335, "CLOUDFLARENET, US"}},
+		{pfx2as, names, "8.8.4.4", Info{3356, ""}},
+		{mmdb, names, "8.8.8.8", Info{15169, "GOOGLE, US"}},
+	}
+	for _, test := range tests {
+		tab, err := Open(test.path, test.names)
+		if err != nil {
+			t.Errorf("Open(%s, %q): %v", test.path, test.names, err)
+			continue
+		}
+		if got, _ := tab.Lookup(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("Open(%s, %q).Lookup(%s): got %v, want %v", test.path, test.names, test.ip, got, test.want)
+		}
+	}
+	if _, err := Open(filepath.Join(dir, "missing"), ""); err == nil {
+		t.Error("Open(missing): got no error, want one")
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asn
+
+// This file reads MaxMind DB files, as described at
+// https://maxmind.github.io/MaxMind-DB/. Only what an ASN database needs is
+// supported: the search tree, and decoding maps, strings and integers.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// metadataMarker precedes the metadata, at the end of the file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSeparator is the gap between the search tree and the data section.
+const dataSeparator = 16
+
+var errCorrupt = errors.New("corrupt MaxMind database")
+
+// mmdb is a MaxMind database held in memory.
+type mmdb struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint32
+	recordSize uint32 // bits: 24, 28 or 32
+	ipv4Start  uint32 // the node for ::/96, where IPv4 addresses are found
+	ipVersion  uint64
+}
+
+func newMMDB(b []byte) (*mmdb, error) {
+	i := bytes.LastIndex(b, metadataMarker)
+	if i < 0 {
+		return nil, errCorrupt
+	}
+	meta := b[i+len(metadataMarker):]
+	v, _, err := decode(meta, 0)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %v", err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("metadata: not a map")
+	}
+	nodes, _ := m["node_count"].(uint64)
+	size, _ := m["record_size"].(uint64)
+	version, _ := m["ip_version"].(uint64)
+	if size != 24 && size != 28 && size != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", size)
+	}
+	if version != 4 && version != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", version)
+	}
+	treeSize := nodes * size / 4
+	if treeSize+dataSeparator > uint64(i) {
+		return nil, errCorrupt
+	}
+	db := &mmdb{
+		tree:       b[:treeSize],
+		data:       b[treeSize+dataSeparator : i],
+		nodeCount:  uint32(nodes),
+		recordSize: uint32(size),
+		ipVersion:  version,
+	}
+	if version == 6 {
+		for n := 0; n < 96 && db.ipv4Start < db.nodeCount; n++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node.
+func (db *mmdb) record(node, bit uint32) uint32 {
+	switch db.recordSize {
+	case 24:
+		b := db.tree[node*6+bit*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		b := db.tree[node*7:]
+		if bit == 0 {
+			return uint32(b[3]>>4)<<24 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		b := db.tree[node*8+bit*4:]
+		return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	}
+}
+
+// find walks the tree for ip, returning the data offset of its record.
+func (db *mmdb) find(ip net.IP) (uint32, bool) {
+	node := uint32(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		node = db.ipv4Start
+	} else if ip = ip.To16(); ip == nil || db.ipVersion == 4 {
+		return 0, false
+	}
+	for i := 0; i < len(ip)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, uint32(ip[i/8]>>uint(7-i%8))&1)
+	}
+	if node <= db.nodeCount {
+		return 0, false // not found (or a corrupt loop)
+	}
+	return node - db.nodeCount - dataSeparator, true
+}
+
+func (db *mmdb) Lookup(ip net.IP) (Info, bool) {
+	off, ok := db.find(ip)
+	if !ok {
+		return Info{}, false
+	}
+	v, _, err := decode(db.data, int(off))
+	if err != nil {
+		return Info{}, false
+	}
+	m, _ := v.(map[string]interface{})
+	n, ok := m["autonomous_system_number"].(uint64)
+	if !ok {
+		return Info{}, false
+	}
+	org, _ := m["autonomous_system_organization"].(string)
+	return Info{Number: uint32(n), Org: org}, true
+}
+
+// Data field types.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEnd
+	typeBool
+	typeFloat
+)
+
+// decode decodes the field at off in b, a data section (pointers are
+// relative to its start). It returns the field and the offset after it.
+// Maps become map[string]interface{}, arrays []interface{}, unsigned
+// integers uint64, and other types nil.
+func decode(b []byte, off int) (interface{}, int, error) {
+	if off >= len(b) {
+		return nil, 0, errCorrupt
+	}
+	ctrl := b[off]
+	off++
+	typ := int(ctrl >> 5)
+	if typ == typePointer {
+		n := int(ctrl>>3) & 3
+		if off+n+1 > len(b) {
+			return nil, 0, errCorrupt
+		}
+		p := int(ctrl & 7)
+		if n == 3 {
+			p = 0
+		}
+		for _, c := range b[off : off+n+1] {
+			p = p<<8 | int(c)
+		}
+		p += [...]int{0, 2048, 526336, 0}[n]
+		v, _, err := decode(b, p)
+		return v, off + n + 1, err
+	}
+	if typ == typeExtended {
+		if off >= len(b) {
+			return nil, 0, errCorrupt
+		}
+		typ = 7 + int(b[off])
+		off++
+	}
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if off+n > len(b) {
+			return nil, 0, errCorrupt
+		}
+		size = 0
+		for _, c := range b[off : off+n] {
+			size = size<<8 | int(c)
+		}
+		size += [...]int{0, 29, 285, 65821}[n]
+		off += n
+	}
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			k, next, err := decode(b, off)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errCorrupt
+			}
+			if m[key], off, err = decode(b, next); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, off, nil
+	case typeArray:
+		a := make([]interface{}, size)
+		for i := range a {
+			var err error
+			if a[i], off, err = decode(b, off); err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, off, nil
+	case typeBool:
+		return size != 0, off, nil
+	}
+	if off+size > len(b) {
+		return nil, 0, errCorrupt
+	}
+	v := b[off : off+size]
+	switch typ {
+	case typeString:
+		return string(v), off + size, nil
+	case typeUint16, typeUint32, typeUint64:
+		var n uint64
+		for _, c := range v {
+			n = n<<8 | uint64(c)
+		}
+		return n, off + size, nil
+	}
+	return nil, off + size, nil
+}
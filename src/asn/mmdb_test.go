@@ -0,0 +1,180 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asn
+
+import (
+	"net"
+	"testing"
+)
+
+// mmdbWriter builds small MaxMind databases for tests.
+type mmdbWriter struct {
+	ipVersion, recordSize int
+	nodes                 [][2]int // node index, empty (-1), or leaf (-2 - data offset)
+	data                  []byte
+}
+
+func newMMDBWriter(ipVersion, recordSize int) *mmdbWriter {
+	return &mmdbWriter{ipVersion: ipVersion, recordSize: recordSize, nodes: [][2]int{{-1, -1}}}
+}
+
+// encode appends a map, string or uint32 to b.
+func encode(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		b = append(b, typeMap<<5|byte(len(v)))
+		for k, e := range v {
+			b = encode(encode(b, k), e)
+		}
+	case string:
+		if len(v) < 29 {
+			b = append(b, typeString<<5|byte(len(v)))
+		} else {
+			b = append(b, typeString<<5|29, byte(len(v)-29))
+		}
+		b = append(b, v...)
+	case uint32:
+		b = append(b, typeUint32<<5|4, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		panic("can't encode")
+	}
+	return b
+}
+
+// insert adds a record for a prefix, which must not overlap another.
+func (w *mmdbWriter) insert(cidr string, rec map[string]interface{}) {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	ip := n.IP
+	bits, _ := n.Mask.Size()
+	if w.ipVersion == 6 {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = append(make(net.IP, 12), ip4...) // ::/96
+			bits += 96
+		}
+	}
+	leaf := -2 - len(w.data)
+	w.data = encode(w.data, rec)
+	node := 0
+	for i := 0; i < bits; i++ {
+		bit := int(ip[i/8]>>uint(7-i%8)) & 1
+		if i == bits-1 {
+			w.nodes[node][bit] = leaf
+			break
+		}
+		if w.nodes[node][bit] < 0 {
+			w.nodes[node][bit] = len(w.nodes)
+			w.nodes = append(w.nodes, [2]int{-1, -1})
+		}
+		node = w.nodes[node][bit]
+	}
+}
+
+// bytes returns the database.
+func (w *mmdbWriter) bytes() []byte {
+	count := len(w.nodes)
+	var b []byte
+	for _, n := range w.nodes {
+		var r [2]uint32
+		for i, c := range n {
+			switch {
+			case c == -1:
+				r[i] = uint32(count)
+			case c < -1:
+				r[i] = uint32(count + dataSeparator + (-2 - c))
+			default:
+				r[i] = uint32(c)
+			}
+		}
+		switch w.recordSize {
+		case 24:
+			b = append(b, byte(r[0]>>16), byte(r[0]>>8), byte(r[0]), byte(r[1]>>16), byte(r[1]>>8), byte(r[1]))
+		case 28:
+			b = append(b, byte(r[0]>>16), byte(r[0]>>8), byte(r[0]), byte(r[0]>>24)<<4|byte(r[1]>>24), byte(r[1]>>16), byte(r[1]>>8), byte(r[1]))
+		case 32:
+			b = append(b, byte(r[0]>>24), byte(r[0]>>16), byte(r[0]>>8), byte(r[0]), byte(r[1]>>24), byte(r[1]>>16), byte(r[1]>>8), byte(r[1]))
+		}
+	}
+	b = append(b, make([]byte, dataSeparator)...)
+	b = append(b, w.data...)
+	b = append(b, metadataMarker...)
+	return encode(b, map[string]interface{}{
+		"node_count":    uint32(count),
+		"record_size":   uint32(w.recordSize),
+		"ip_version":    uint32(w.ipVersion),
+		"database_type": "GeoLite2-ASN",
+	})
+}
+
+func asRecord(n uint32, org string) map[string]interface{} {
+	return map[string]interface{}{
+		"autonomous_system_number":       n,
+		"autonomous_system_organization": org,
+	}
+}
+
+func TestMMDBLookup(t *testing.T) {
+	for _, version := range []int{4, 6} {
+		for _, size := range []int{24, 28, 32} {
+			w := newMMDBWriter(version, size)
+			w.insert("1.1.1.0/24", asRecord(13335, "CLOUDFLARENET"))
+			w.insert("8.8.8.0/24", asRecord(15169, "GOOGLE"))
+			if version == 6 {
+				w.insert("2606:4700::/32", asRecord(13335, "CLOUDFLARENET"))
+			}
+			db, err := newMMDB(w.bytes())
+			if err != nil {
+				t.Fatalf("v%d, %d bits: newMMDB: %v", version, size, err)
+			}
+			tests := []struct {
+				ip   string
+				want Info
+				ok   bool
+			}{
+				{"1.1.1.1", Info{13335, "CLOUDFLARENET"}, true},
+				{"8.8.4.4", Info{}, false},
+				{"8.8.8.8", Info{15169, "GOOGLE"}, true},
+				{"2606:4700::1111", Info{13335, "CLOUDFLARENET"}, version == 6},
+				{"2001:db8::1", Info{}, false},
+			}
+			for _, test := range tests {
+				want := test.want
+				if !test.ok {
+					want = Info{}
+				}
+				got, ok := db.Lookup(net.ParseIP(test.ip))
+				if got != want || ok != test.ok {
+					t.Errorf("v%d, %d bits: Lookup(%s): got %v, %v, want %v, %v", version, size, test.ip, got, ok, want, test.ok)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	for _, b := range [][]byte{
+		{},
+		{typeString<<5 | 5, 'a'},
+		{typeMap<<5 | 1, typeString<<5 | 1, 'k'},
+		{typePointer<<5 | 3<<3},
+		{typeMap<<5 | 1, typeUint32<<5 | 1, 1, typeUint32 << 5},
+	} {
+		if v, _, err := decode(b, 0); err == nil {
+			t.Errorf("decode(%x): got %v, want an error", b, v)
+		}
+	}
+}
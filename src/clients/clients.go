@@ -0,0 +1,69 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package clients reads the client lists of network controllers (UniFi and
+// OpenWrt), so caplog's devices have names and wireless details on networks
+// they manage.
+package clients
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"packets"
+)
+
+// Source is a controller's list of clients.
+type Source interface {
+	// Clients returns the clients the controller knows. LastSeen is set
+	// for those it says are connected.
+	Clients(ctx context.Context) ([]packets.Device, error)
+}
+
+// DefaultInterval is how often Poll reads the list if interval is zero.
+const DefaultInterval = time.Minute
+
+// Poll reads the list from the source now and then every interval, calling
+// f with each client, until ctx is done.
+func Poll(ctx context.Context, src Source, interval time.Duration, f func(packets.Device)) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	failing := false
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		devs, err := src.Clients(ctx)
+		if err != nil {
+			if !failing {
+				log.Printf("clients: %v", err)
+				failing = true
+			}
+		} else {
+			if failing {
+				log.Print("clients: reading the client list again")
+				failing = false
+			}
+			for _, d := range devs {
+				f(d)
+			}
+		}
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"packets"
+)
+
+func TestUniFi(t *testing.T) {
+	logins := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/login":
+			logins++
+			http.SetCookie(w, &http.Cookie{Name: "unifises", Value: "s"})
+			return
+		case "/api/s/default/stat/sta":
+			if _, err := r.Cookie("unifises"); err != nil {
+				http.Error(w, "login first", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"meta":{"rc":"ok"},"data":[
+				{"mac":"00:11:22:33:44:55","hostname":"android-1234","name":"Phone","ip":"192.168.1.10","is_wired":false,"essid":"home","ap_mac":"f0:9f:c2:00:00:01","signal":-52,"last_seen":1456833600},
+				{"mac":"66:77:88:99:AA:BB","hostname":"printer","ip":"192.168.1.20","is_wired":true,"last_seen":1456833500}
+			]}`)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	u := &UniFi{URL: srv.URL + "/", Username: "admin", Password: "secret"}
+	got, err := u.Clients(context.Background())
+	if err != nil {
+		t.Fatalf("Clients: %v", err)
+	}
+	want := []packets.Device{
+		{MAC: "00:11:22:33:44:55", Name: "Phone", IPs: []string{"192.168.1.10"}, LastSeen: time.Unix(1456833600, 0),
+			Wireless: &packets.Wireless{SSID: "home", AP: "f0:9f:c2:00:00:01", Signal: -52}},
+		{MAC: "66:77:88:99:aa:bb", Name: "printer", IPs: []string{"192.168.1.20"}, LastSeen: time.Unix(1456833500, 0)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Clients:\ngot  %+v\nwant %+v", got, want)
+	}
+	if _, err := u.Clients(context.Background()); err != nil {
+		t.Fatalf("Clients again: %v", err)
+	}
+	if logins != 1 {
+		t.Errorf("logins: got %d, want 1", logins)
+	}
+}
+
+func TestOpenWrt(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Params) != 4 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var session, object, method string
+		json.Unmarshal(req.Params[0], &session)
+		json.Unmarshal(req.Params[1], &object)
+		json.Unmarshal(req.Params[2], &method)
+		if object != "session" && session != "abc" {
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":[6]}`)
+			return
+		}
+		var result string
+		switch object + " " + method {
+		case "session login":
+			result = `{"ubus_rpc_session":"abc"}`
+		case "luci-rpc getHostHints":
+			result = `{"00:11:22:33:44:55":{"name":"phone","ipaddrs":["192.168.1.10"],"ip6addrs":["fd00::10"]},"66:77:88:99:AA:BB":{"ipaddrs":["192.168.1.20"]}}`
+		case "iwinfo devices":
+			result = `{"devices":["wlan0"]}`
+		case "iwinfo info":
+			result = `{"ssid":"home"}`
+		case "iwinfo assoclist":
+			result = `{"results":[{"mac":"00:11:22:33:44:55","signal":-60}]}`
+		default:
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"Object not found"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":[0,%s]}`, result)
+	}))
+	defer srv.Close()
+
+	o := &OpenWrt{URL: srv.URL, Username: "root", Password: "secret", session: "expired"}
+	got, err := o.Clients(context.Background())
+	if err != nil {
+		t.Fatalf("Clients: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Clients: got %+v, want 2 devices", got)
+	}
+	phone, printer := got[0], got[1]
+	if phone.LastSeen.IsZero() {
+		t.Error("phone: LastSeen not set, want connected")
+	}
+	phone.LastSeen = time.Time{}
+	wantPhone := packets.Device{MAC: "00:11:22:33:44:55", Name: "phone", IPs: []string{"192.168.1.10", "fd00::10"},
+		Wireless: &packets.Wireless{SSID: "home", AP: "wlan0", Signal: -60}}
+	if !reflect.DeepEqual(phone, wantPhone) {
+		t.Errorf("phone:\ngot  %+v\nwant %+v", phone, wantPhone)
+	}
+	wantPrinter := packets.Device{MAC: "66:77:88:99:aa:bb", IPs: []string{"192.168.1.20"}}
+	if !reflect.DeepEqual(printer, wantPrinter) {
+		t.Errorf("printer:\ngot  %+v\nwant %+v", printer, wantPrinter)
+	}
+}
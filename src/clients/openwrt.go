@@ -0,0 +1,221 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+// This file reads the clients of an OpenWrt router, through ubus's JSON-RPC
+// interface (as used by LuCI).
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"packets"
+)
+
+const (
+	// ubusNoSession is the session ID to log in with.
+	ubusNoSession = "00000000000000000000000000000000"
+
+	// ubusPermissionDenied is the status when the session has expired.
+	ubusPermissionDenied = 6
+)
+
+// OpenWrt reads the clients of an OpenWrt router: the names and addresses
+// LuCI knows (from DHCP leases and the neighbour table), and the stations
+// associated with each wireless interface. The user needs read access to
+// luci-rpc and iwinfo, which root has.
+type OpenWrt struct {
+	URL                string // e.g. http://192.168.1.1/; ubus is at /ubus
+	Username, Password string
+
+	mu      sync.Mutex
+	session string
+}
+
+// call calls a ubus method, decoding its result into v. o.mu must be held.
+func (o *OpenWrt) call(ctx context.Context, session, object, method string, args, v interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "call",
+		"params":  []interface{}{session, object, method, args},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(o.URL, "/")+"/ubus", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OpenWrt: %s", resp.Status)
+	}
+	// The result is [status] or [status, data].
+	var r struct {
+		Result []json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("OpenWrt: %v", err)
+	}
+	if r.Error != nil {
+		return &ubusError{object, method, r.Error.Message, 0}
+	}
+	var status int
+	if len(r.Result) > 0 {
+		if err := json.Unmarshal(r.Result[0], &status); err != nil {
+			return fmt.Errorf("OpenWrt %s %s: %v", object, method, err)
+		}
+	}
+	if status != 0 {
+		return &ubusError{object, method, "", status}
+	}
+	if len(r.Result) < 2 || v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(r.Result[1], v); err != nil {
+		return fmt.Errorf("OpenWrt %s %s: %v", object, method, err)
+	}
+	return nil
+}
+
+// ubusError is a failed call.
+type ubusError struct {
+	object, method, message string
+	status                  int
+}
+
+func (e *ubusError) Error() string {
+	if e.message != "" {
+		return fmt.Sprintf("OpenWrt %s %s: %s", e.object, e.method, e.message)
+	}
+	return fmt.Sprintf("OpenWrt %s %s: status %d", e.object, e.method, e.status)
+}
+
+// sessionCall is call with the session, logging in first if need be, and
+// again if the session has expired. o.mu must be held.
+func (o *OpenWrt) sessionCall(ctx context.Context, object, method string, args, v interface{}) error {
+	for retry := 0; ; retry++ {
+		if o.session == "" {
+			var login struct {
+				Session string `json:"ubus_rpc_session"`
+			}
+			err := o.call(ctx, ubusNoSession, "session", "login", map[string]string{
+				"username": o.Username,
+				"password": o.Password,
+			}, &login)
+			if err != nil {
+				return err
+			}
+			o.session = login.Session
+		}
+		err := o.call(ctx, o.session, object, method, args, v)
+		if ue, ok := err.(*ubusError); ok && ue.status == ubusPermissionDenied && retry == 0 {
+			o.session = ""
+			continue
+		}
+		return err
+	}
+}
+
+// Clients returns the clients LuCI knows. Those associated with a wireless
+// interface are connected.
+func (o *OpenWrt) Clients(ctx context.Context) ([]packets.Device, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var hints map[string]struct {
+		Name     string   `json:"name"`
+		IPAddrs  []string `json:"ipaddrs"`
+		IP6Addrs []string `json:"ip6addrs"`
+	}
+	if err := o.sessionCall(ctx, "luci-rpc", "getHostHints", struct{}{}, &hints); err != nil {
+		return nil, err
+	}
+	devs := make(map[string]*packets.Device)
+	for mac, h := range hints {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			continue
+		}
+		devs[hw.String()] = &packets.Device{
+			MAC:  hw.String(),
+			Name: h.Name,
+			IPs:  append(append([]string(nil), h.IPAddrs...), h.IP6Addrs...),
+		}
+	}
+
+	var ifaces struct {
+		Devices []string `json:"devices"`
+	}
+	if err := o.sessionCall(ctx, "iwinfo", "devices", struct{}{}, &ifaces); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, iface := range ifaces.Devices {
+		args := map[string]string{"device": iface}
+		var info struct {
+			SSID string `json:"ssid"`
+		}
+		if err := o.sessionCall(ctx, "iwinfo", "info", args, &info); err != nil {
+			return nil, err
+		}
+		var assoc struct {
+			Results []struct {
+				MAC    string `json:"mac"`
+				Signal int    `json:"signal"`
+			} `json:"results"`
+		}
+		if err := o.sessionCall(ctx, "iwinfo", "assoclist", args, &assoc); err != nil {
+			return nil, err
+		}
+		for _, a := range assoc.Results {
+			hw, err := net.ParseMAC(a.MAC)
+			if err != nil {
+				continue
+			}
+			d := devs[hw.String()]
+			if d == nil {
+				d = &packets.Device{MAC: hw.String()}
+				devs[d.MAC] = d
+			}
+			d.LastSeen = now
+			d.Wireless = &packets.Wireless{SSID: info.SSID, AP: iface, Signal: a.Signal}
+		}
+	}
+
+	list := make([]packets.Device, 0, len(devs))
+	for _, d := range devs {
+		list = append(list, *d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].MAC < list[j].MAC })
+	return list, nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clients
+
+// This file reads the clients of a UniFi Network controller, through its
+// (unofficial) API.
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"sync"
+	"time"
+
+	"packets"
+)
+
+// UniFi reads the clients of a UniFi controller, or of a UniFi OS console
+// (such as a Dream Machine), which serves the same API under another path.
+type UniFi struct {
+	URL                string // e.g. https://unifi.local:8443/
+	Site               string // if empty, "default"
+	Username, Password string
+
+	// Insecure skips verifying the controller's certificate, which is
+	// self-signed unless one has been installed.
+	Insecure bool
+
+	mu     sync.Mutex
+	client *http.Client // with the session cookie
+	prefix string       // before /api/s/..., "/proxy/network" on UniFi OS
+}
+
+// login starts a session. u.mu must be held.
+func (u *UniFi) login(ctx context.Context) error {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+	u.client = &http.Client{Jar: jar}
+	if u.Insecure {
+		u.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	body, err := json.Marshal(map[string]string{"username": u.Username, "password": u.Password})
+	if err != nil {
+		return err
+	}
+	base := strings.TrimRight(u.URL, "/")
+	// UniFi OS first, then the standalone controller.
+	for _, l := range []struct{ path, prefix string }{
+		{"/api/auth/login", "/proxy/network"},
+		{"/api/login", ""},
+	} {
+		req, err := http.NewRequest("POST", base+l.path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := u.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			u.prefix = l.prefix
+			return nil
+		case http.StatusNotFound:
+			continue
+		}
+		return fmt.Errorf("UniFi login: %s", resp.Status)
+	}
+	return fmt.Errorf("UniFi login: no login API at %s", u.URL)
+}
+
+// unifiClient is a client in stat/sta.
+type unifiClient struct {
+	MAC      string `json:"mac"`
+	Name     string `json:"name"` // set in the controller
+	Hostname string `json:"hostname"`
+	IP       string `json:"ip"`
+	IsWired  bool   `json:"is_wired"`
+	ESSID    string `json:"essid"`
+	APMAC    string `json:"ap_mac"`
+	Signal   int    `json:"signal"`
+	LastSeen int64  `json:"last_seen"`
+}
+
+// Clients returns the connected clients.
+func (u *UniFi) Clients(ctx context.Context) ([]packets.Device, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	site := u.Site
+	if site == "" {
+		site = "default"
+	}
+	var resp *http.Response
+	for retry := 0; ; retry++ {
+		if u.client == nil {
+			if err := u.login(ctx); err != nil {
+				u.client = nil
+				return nil, err
+			}
+		}
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s%s/api/s/%s/stat/sta", strings.TrimRight(u.URL, "/"), u.prefix, site), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = u.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || retry > 0 {
+			break
+		}
+		// The session expired.
+		resp.Body.Close()
+		u.client = nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("UniFi: %s", resp.Status)
+	}
+	var body struct {
+		Data []unifiClient `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("UniFi: %v", err)
+	}
+	return unifiDevices(body.Data), nil
+}
+
+// unifiDevices converts the clients.
+func unifiDevices(cs []unifiClient) []packets.Device {
+	var devs []packets.Device
+	for _, c := range cs {
+		hw, err := net.ParseMAC(c.MAC)
+		if err != nil {
+			continue
+		}
+		d := packets.Device{MAC: hw.String(), Name: c.Name}
+		if d.Name == "" {
+			d.Name = c.Hostname
+		}
+		if c.IP != "" {
+			d.IPs = []string{c.IP}
+		}
+		if c.LastSeen != 0 {
+			d.LastSeen = time.Unix(c.LastSeen, 0)
+		}
+		if !c.IsWired {
+			d.Wireless = &packets.Wireless{SSID: c.ESSID, AP: c.APMAC, Signal: c.Signal}
+		}
+		devs = append(devs, d)
+	}
+	return devs
+}
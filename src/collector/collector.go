@@ -0,0 +1,488 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package collector merges records sent by other caplog instances (probes)
+// into this one's dashboard and outputs.
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"events"
+	"flowpb"
+	"mtls"
+	"packets"
+	"vars"
+)
+
+const (
+	// maxBatchSize bounds the request body.
+	maxBatchSize = 64 << 20
+
+	// skewSamples is how many recent batches the skew is estimated from.
+	skewSamples = 32
+
+	// DefaultMaxSkew is the default skew beyond which a probe is flagged.
+	DefaultMaxSkew = 2 * time.Second
+)
+
+// Collector receives batches from probes at /api/ingest. Each probe's clock
+// may be off, so its packets' timestamps are corrected by its estimated
+// skew before they are merged. Each probe's batches are merged in sequence
+// order, asking it to send again any that go missing.
+type Collector struct {
+	// conns is the number of connections to the TLS listener, accessed
+	// atomically. It is first, to be aligned for atomic access on 32-bit
+	// platforms.
+	conns int64
+
+	// Account returns the accounting function for a probe's packets.
+	Account func(probe string) func(*packets.Metadata)
+
+	// Sink, if set, is given each corrected batch.
+	Sink packets.Sink
+
+	// Flows, if set, is given the corrected flow records in each batch.
+	Flows func([]packets.Flow)
+
+	// MaxSkew is the skew beyond which a probe is flagged. If zero,
+	// DefaultMaxSkew is used.
+	MaxSkew time.Duration
+
+	// TLS, if set, is the config of the listener ListenTLS serves
+	// /api/ingest on, which then isn't registered on the main server.
+	TLS *tls.Config
+
+	// ReplayWindow is how long a gap in a probe's batches is waited on
+	// before the batches after it are merged anyway, and MaxHeld is the
+	// most batches held per probe meanwhile. If zero, DefaultReplayWindow
+	// and DefaultMaxHeld are used.
+	ReplayWindow time.Duration
+	MaxHeld      int
+
+	mu     sync.Mutex
+	probes map[string]*probe
+}
+
+type probe struct {
+	account func(*packets.Metadata)
+
+	// mu guards the rest, and is held while the probe's batches are
+	// merged, so they are merged in order.
+	mu  sync.Mutex
+	seq sequencer
+
+	// samples are recent (sent - received) times. The network only adds
+	// delay, so each is at most the true skew; the largest is the best
+	// estimate.
+	samples [skewSamples]time.Duration
+	n       int
+
+	batches, packets uint64
+	lastSeq          uint64
+	lastSeen         time.Time
+	skewed           bool
+	key              string // the pin of its TLS key, if it used one
+
+	// reg is its last registration, received at registered. The rates are
+	// per second, between its last two registrations.
+	reg                  Registration
+	registered           time.Time
+	packetRate, dropRate float64
+}
+
+// skew estimates how far ahead of ours the probe's clock is.
+func (p *probe) skew() time.Duration {
+	n := p.n
+	if n > skewSamples {
+		n = skewSamples
+	}
+	if n == 0 {
+		return 0
+	}
+	max := p.samples[0]
+	for _, s := range p.samples[1:n] {
+		if s > max {
+			max = s
+		}
+	}
+	return max
+}
+
+// ProbeStatus describes a probe, for /api/probes.
+type ProbeStatus struct {
+	ID           string
+	Skew         float64 // seconds the probe's clock is ahead (negative: behind)
+	Skewed       bool    // |Skew| is more than the maximum
+	Batches      uint64
+	Packets      uint64
+	LastSequence uint64
+	LastSeen     time.Time
+	Key          string `json:",omitempty"` // the pin of its TLS key
+
+	// Held batches wait for the Missing ones before them; Lag is how long
+	// (in seconds) the oldest has waited. Lost batches were given up on,
+	// and Completeness is the fraction of batches merged rather than lost.
+	Held             int
+	Missing          uint64
+	Lost             uint64
+	Duplicates       uint64
+	ReplaysRequested uint64
+	Lag              float64
+	Completeness     float64
+
+	// From its registration, received at Registered. Captured and Dropped
+	// are the packets it read and dropped since it Started, and the rates
+	// are per second, between its last two registrations.
+	Location   string `json:",omitempty"`
+	Version    string `json:",omitempty"`
+	Interfaces []string
+	Started    time.Time
+	Registered time.Time
+	Captured   uint64
+	Dropped    uint64
+	PacketRate float64
+	DropRate   float64
+
+	// LastContact is its last batch or registration. Status is "ok",
+	// "stale", "skewed", "dropping" or "lagging".
+	LastContact time.Time
+	Status      string
+}
+
+// Ingest merges a batch received at recv, once the batches before it have
+// been.
+func (c *Collector) Ingest(b *flowpb.Batch, recv time.Time) error {
+	_, err := c.ingest(b, recv, "")
+	return err
+}
+
+// ingest is Ingest for a batch sent with the TLS key with the pin (or "").
+// It returns the ReplayHeader value asking for missing batches, if any.
+func (c *Collector) ingest(b *flowpb.Batch, recv time.Time, key string) (string, error) {
+	if b.ProbeID == "" {
+		return "", fmt.Errorf("batch has no probe_id")
+	}
+	// The send time is best, but older probes don't set it. The newest
+	// packet also can't be later than the send time, so it will do.
+	var sent int64
+	if b.SentNs != 0 {
+		sent = b.SentNs
+	} else {
+		for i := range b.Packets {
+			if b.Packets[i].TimestampNs > sent {
+				sent = b.Packets[i].TimestampNs
+			}
+		}
+	}
+
+	p := c.probe(b.ProbeID)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if sent != 0 {
+		p.samples[p.n%skewSamples] = time.Unix(0, sent).Sub(recv)
+		p.n++
+	}
+	skew := p.skew()
+	max := c.MaxSkew
+	if max == 0 {
+		max = DefaultMaxSkew
+	}
+	skewed := skew > max || skew < -max
+	if skewed != p.skewed {
+		e := events.Event{
+			Type:     "probe-skew",
+			Severity: events.Warning,
+			Message:  fmt.Sprintf("probe %s clock is off by %v", b.ProbeID, skew),
+			Fields:   map[string]string{"probe": b.ProbeID, "skew": skew.String()},
+		}
+		if !skewed {
+			e.Type = "probe-skew-ok"
+			e.Severity = events.Notice
+			e.Message = fmt.Sprintf("probe %s clock is back within %v", b.ProbeID, max)
+		}
+		log.Printf("collector: %s", e.Message)
+		events.Publish(e)
+		p.skewed = skewed
+	}
+	p.setKey(b.ProbeID, key)
+	p.batches++
+	p.packets += uint64(len(b.Packets))
+	p.lastSeq = b.Sequence
+	p.lastSeen = recv
+
+	data := batch{packets: make([]packets.Metadata, len(b.Packets))}
+	for i := range b.Packets {
+		data.packets[i] = b.Packets[i].ToMetadata()
+		data.packets[i].Timestamp = data.packets[i].Timestamp.Add(-skew)
+	}
+	if len(b.Flows) > 0 {
+		data.flows = make([]packets.Flow, len(b.Flows))
+		for i := range b.Flows {
+			f := &data.flows[i]
+			*f = b.Flows[i].ToFlow()
+			f.Start, f.End = f.Start.Add(-skew), f.End.Add(-skew)
+		}
+	}
+	window, maxHeld := c.replayLimits()
+	c.merge(p, p.seq.place(b.ProbeID, b.Sequence, b.SequenceEpoch, data, recv, window, maxHeld))
+	return p.seq.ask(recv, window), nil
+}
+
+// probe returns the probe with the ID, adding it if it is new.
+func (c *Collector) probe(id string) *probe {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.probes == nil {
+		c.probes = make(map[string]*probe)
+	}
+	p := c.probes[id]
+	if p == nil {
+		p = new(probe)
+		if c.Account != nil {
+			p.account = c.Account(id)
+		}
+		c.probes[id] = p
+	}
+	return p
+}
+
+// setKey records the pin of the TLS key the probe used (or "" if it didn't),
+// publishing an event if it changed. p.mu must be held.
+func (p *probe) setKey(id, key string) {
+	if key == "" || key == p.key {
+		return
+	}
+	if p.key != "" {
+		e := events.Event{
+			Type:     "probe-key-changed",
+			Severity: events.Notice,
+			Message:  fmt.Sprintf("probe %s is using a new TLS key", id),
+			Fields:   map[string]string{"probe": id, "old": p.key, "new": key},
+		}
+		log.Printf("collector: %s", e.Message)
+		events.Publish(e)
+	}
+	p.key = key
+}
+
+// replayLimits returns the replay window and most batches held.
+func (c *Collector) replayLimits() (time.Duration, int) {
+	window, maxHeld := c.ReplayWindow, c.MaxHeld
+	if window == 0 {
+		window = DefaultReplayWindow
+	}
+	if maxHeld == 0 {
+		maxHeld = DefaultMaxHeld
+	}
+	return window, maxHeld
+}
+
+// merge accounts for the batches, writes their packets to the sink and
+// hands on their flow records. p.mu must be held.
+func (c *Collector) merge(p *probe, batches []batch) {
+	for _, b := range batches {
+		data := b.packets
+		if p.account != nil {
+			for i := range data {
+				p.account(&data[i])
+			}
+		}
+		if c.Sink != nil && len(data) > 0 {
+			if err := c.Sink.Write(context.Background(), data); err != nil {
+				log.Printf("collector: %v", err)
+			}
+		}
+		if c.Flows != nil && len(b.flows) > 0 {
+			c.Flows(b.flows)
+		}
+	}
+}
+
+// Expire gives up on the gaps that have been waited on for the replay
+// window as of now, merging the batches held after them. It should be called
+// regularly, so that a probe that goes quiet after a gap isn't held forever.
+func (c *Collector) Expire(now time.Time) {
+	window, maxHeld := c.replayLimits()
+	for id, p := range c.probeMap() {
+		p.mu.Lock()
+		c.merge(p, p.seq.release(id, now, window, maxHeld))
+		p.mu.Unlock()
+	}
+}
+
+// probeMap returns a copy of the probes, by ID.
+func (c *Collector) probeMap() map[string]*probe {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]*probe, len(c.probes))
+	for id, p := range c.probes {
+		m[id] = p
+	}
+	return m
+}
+
+// Probes returns the status of every probe heard from, by ID.
+func (c *Collector) Probes() []ProbeStatus {
+	now := time.Now()
+	probes := c.probeMap()
+	ps := make([]ProbeStatus, 0, len(probes))
+	for id, p := range probes {
+		p.mu.Lock()
+		ps = append(ps, ProbeStatus{
+			ID:               id,
+			Skew:             p.skew().Seconds(),
+			Skewed:           p.skewed,
+			Batches:          p.batches,
+			Packets:          p.packets,
+			LastSequence:     p.lastSeq,
+			LastSeen:         p.lastSeen,
+			Key:              p.key,
+			Held:             len(p.seq.held),
+			Missing:          p.seq.missingCount(),
+			Lost:             p.seq.lost,
+			Duplicates:       p.seq.duplicates,
+			ReplaysRequested: p.seq.replays,
+			Lag:              p.seq.lag(now).Seconds(),
+			Completeness:     p.seq.completeness(),
+			Location:         p.reg.Location,
+			Version:          p.reg.Version,
+			Interfaces:       p.reg.Interfaces,
+			Started:          p.reg.Started,
+			Registered:       p.registered,
+			Captured:         p.reg.Packets,
+			Dropped:          p.reg.Dropped,
+			PacketRate:       p.packetRate,
+			DropRate:         p.dropRate,
+			LastContact:      p.lastSeen,
+		})
+		p.mu.Unlock()
+		s := &ps[len(ps)-1]
+		if s.Registered.After(s.LastContact) {
+			s.LastContact = s.Registered
+		}
+		s.Status = s.status(now)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].ID < ps[j].ID })
+	return ps
+}
+
+// ingestHandler accepts a caplog.v1.Batch protobuf as a POST body.
+func (c *Collector) ingestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST a caplog.v1.Batch", http.StatusMethodNotAllowed)
+		return
+	}
+	recv := time.Now()
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var b flowpb.Batch
+	if err := b.Unmarshal(body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var key string
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		key = mtls.Pin(r.TLS.PeerCertificates[0])
+	}
+	replay, err := c.ingest(&b, recv, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if replay != "" {
+		w.Header().Set(flowpb.ReplayHeader, replay)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Collector) probesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.Probes()); err != nil {
+		log.Print("probes failed to write:", err)
+	}
+}
+
+// RegisterHandlers registers /api/probes and /fleet, and /api/ingest and
+// /api/register unless they are served with TLS.
+func (c *Collector) RegisterHandlers() {
+	if c.TLS == nil {
+		http.HandleFunc("/api/ingest", c.ingestHandler)
+		http.HandleFunc("/api/register", c.registerHandler)
+	}
+	http.HandleFunc("/api/probes", c.probesHandler)
+	http.HandleFunc("/fleet", c.fleetHandler)
+}
+
+// RegisterVars registers the batches held, lost, duplicated and asked for
+// again, across all probes.
+func (c *Collector) RegisterVars() {
+	sum := func(f func(*sequencer) uint64) func() uint64 {
+		return func() uint64 {
+			var n uint64
+			for _, p := range c.probeMap() {
+				p.mu.Lock()
+				n += f(&p.seq)
+				p.mu.Unlock()
+			}
+			return n
+		}
+	}
+	vars.Register("collector-held", vars.Uint64Eval(sum(func(s *sequencer) uint64 { return uint64(len(s.held)) })).String)
+	vars.Register("collector-missing", vars.Uint64Eval(sum((*sequencer).missingCount)).String)
+	vars.Register("collector-lost-batches", vars.Uint64Eval(sum(func(s *sequencer) uint64 { return s.lost })).String)
+	vars.Register("collector-duplicate-batches", vars.Uint64Eval(sum(func(s *sequencer) uint64 { return s.duplicates })).String)
+	vars.Register("collector-replays-requested", vars.Uint64Eval(sum(func(s *sequencer) uint64 { return s.replays })).String)
+}
+
+// ListenTLS serves /api/ingest and /api/register on addr with c.TLS, until
+// it fails.
+func (c *Collector) ListenTLS(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ingest", c.ingestHandler)
+	mux.HandleFunc("/api/register", c.registerHandler)
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   mux,
+		TLSConfig: c.TLS,
+		ConnState: func(_ net.Conn, s http.ConnState) {
+			switch s {
+			case http.StateNew:
+				atomic.AddInt64(&c.conns, 1)
+			case http.StateClosed, http.StateHijacked:
+				atomic.AddInt64(&c.conns, -1)
+			}
+		},
+	}
+	return srv.ListenAndServeTLS("", "")
+}
+
+// Connections returns the number of connections to the TLS listener.
+func (c *Collector) Connections() int64 {
+	return atomic.LoadInt64(&c.conns)
+}
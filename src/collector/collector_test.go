@@ -0,0 +1,280 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"flowpb"
+	"packets"
+)
+
+func TestIngestCorrectsSkew(t *testing.T) {
+	var got []packets.Metadata
+	c := &Collector{
+		Sink: packets.SinkFunc(func(_ context.Context, data []packets.Metadata) error {
+			got = append(got, data...)
+			return nil
+		}),
+	}
+	recv := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	ahead := 10 * time.Second
+
+	// The probe's clock is 10s ahead, and batches take 0-50ms to arrive.
+	for i, delay := range []time.Duration{50, 0, 20} {
+		at := recv.Add(time.Duration(i) * time.Second)
+		sent := at.Add(ahead).Add(-delay * time.Millisecond)
+		b := &flowpb.Batch{
+			ProbeID:  "far",
+			Sequence: uint64(i + 1),
+			SentNs:   sent.UnixNano(),
+			Packets:  []flowpb.Metadata{{TimestampNs: sent.Add(-time.Second).UnixNano(), Size: 60}},
+		}
+		if err := c.Ingest(b, at); err != nil {
+			t.Fatalf("Ingest: %v", err)
+		}
+	}
+
+	ps := c.Probes()
+	if len(ps) != 1 {
+		t.Fatalf("Probes: got %v, want 1 probe", ps)
+	}
+	if p := ps[0]; p.ID != "far" || !p.Skewed || p.Skew != ahead.Seconds() || p.Batches != 3 || p.LastSequence != 3 {
+		t.Errorf("Probes()[0]: got %+v, want far, skewed by 10s, 3 batches", p)
+	}
+	// The packet in the zero-delay batch was sent 1s before it, by our clock.
+	if want := recv.Add(time.Second).Add(-time.Second); !got[1].Timestamp.Equal(want) {
+		t.Errorf("corrected timestamp: got %v, want %v", got[1].Timestamp, want)
+	}
+}
+
+func TestIngestWithinThreshold(t *testing.T) {
+	c := &Collector{MaxSkew: time.Second}
+	recv := time.Now()
+	b := &flowpb.Batch{ProbeID: "near", SentNs: recv.Add(300 * time.Millisecond).UnixNano()}
+	if err := c.Ingest(b, recv); err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+	if ps := c.Probes(); ps[0].Skewed {
+		t.Errorf("probe skewed by 300ms flagged with a 1s threshold: %+v", ps[0])
+	}
+	if err := c.Ingest(&flowpb.Batch{}, recv); err == nil {
+		t.Error("Ingest without a probe ID: got nil error, want error")
+	}
+}
+
+func TestIngestRecordsKey(t *testing.T) {
+	c := &Collector{}
+	recv := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, key := range []string{"old", "", "new"} {
+		b := &flowpb.Batch{ProbeID: "p", Sequence: uint64(i + 1)}
+		if _, err := c.ingest(b, recv, key); err != nil {
+			t.Fatalf("ingest: %v", err)
+		}
+		// A batch sent without TLS doesn't forget the key.
+		if want := map[string]string{"old": "old", "": "old", "new": "new"}[key]; c.Probes()[0].Key != want {
+			t.Errorf("after a batch with key %q: got %q, want %q", key, c.Probes()[0].Key, want)
+		}
+	}
+}
+
+func TestIngestInOrder(t *testing.T) {
+	var got []uint64
+	c := &Collector{
+		ReplayWindow: 10 * time.Second,
+		Sink: packets.SinkFunc(func(_ context.Context, data []packets.Metadata) error {
+			for _, m := range data {
+				got = append(got, m.Size)
+			}
+			return nil
+		}),
+	}
+	recv := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	epoch := uint64(recv.UnixNano())
+	send := func(seq uint64, at time.Time) string {
+		t.Helper()
+		b := &flowpb.Batch{ProbeID: "p", Sequence: seq, SequenceEpoch: epoch, Packets: []flowpb.Metadata{{Size: seq}}}
+		replay, err := c.ingest(b, at, "")
+		if err != nil {
+			t.Fatalf("ingest: %v", err)
+		}
+		return replay
+	}
+
+	// 2 goes missing, so 3 and 4 are held, and 2 is asked for once.
+	send(1, recv)
+	if replay := send(3, recv); replay != "2" {
+		t.Errorf("replay after 3: got %q, want %q", replay, "2")
+	}
+	if replay := send(4, recv.Add(time.Second)); replay != "" {
+		t.Errorf("replay after 4: got %q, asked again too soon", replay)
+	}
+	if p := c.Probes()[0]; p.Held != 2 || p.Missing != 1 || p.ReplaysRequested != 1 {
+		t.Errorf("with 2 missing: got %+v, want 2 held, 1 missing, 1 replay", p)
+	}
+	send(2, recv.Add(2*time.Second))
+	send(2, recv.Add(2*time.Second))
+	if want := []uint64{1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged %v, want %v", got, want)
+	}
+
+	// 5 never comes, so 6 is merged once the window is up.
+	send(6, recv.Add(3*time.Second))
+	c.Expire(recv.Add(12 * time.Second))
+	if want := []uint64{1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged %v before the window was up, want %v", got, want)
+	}
+	c.Expire(recv.Add(13 * time.Second))
+	if want := []uint64{1, 2, 3, 4, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged %v, want %v", got, want)
+	}
+	p := c.Probes()[0]
+	if p.Held != 0 || p.Lost != 1 || p.Duplicates != 1 || p.Completeness != 5.0/6 {
+		t.Errorf("after the window: got %+v, want none held, 1 lost, 1 duplicate", p)
+	}
+
+	// The probe restarts without its sequence.
+	epoch++
+	send(1, recv.Add(14*time.Second))
+	if want := []uint64{1, 2, 3, 4, 6, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after a restart: merged %v, want %v", got, want)
+	}
+}
+
+func TestIngestReplayedFirstBatch(t *testing.T) {
+	recv := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		first  uint64 // the first batch the collector sees
+		epochs []uint64
+		want   []uint64
+	}{
+		{"no epochs", 1, []uint64{0, 0, 0, 0}, []uint64{1, 2, 3}},
+		{"same epoch", 1, []uint64{5, 5, 5, 5}, []uint64{1, 2, 3}},
+		{"new epoch", 1, []uint64{5, 5, 5, 6}, []uint64{1, 2, 3, 1}},
+		{"old epoch", 1, []uint64{5, 5, 5, 4}, []uint64{1, 2, 3}},
+		{"no epochs, started late", 2, []uint64{0, 0, 0, 0}, []uint64{2, 3, 4, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []uint64
+			c := &Collector{
+				Sink: packets.SinkFunc(func(_ context.Context, data []packets.Metadata) error {
+					for _, m := range data {
+						got = append(got, m.Size)
+					}
+					return nil
+				}),
+			}
+			// Three batches in order, then batch 1 again.
+			seqs := []uint64{test.first, test.first + 1, test.first + 2, 1}
+			for i, seq := range seqs {
+				b := &flowpb.Batch{ProbeID: "p", Sequence: seq, SequenceEpoch: test.epochs[i], Packets: []flowpb.Metadata{{Size: seq}}}
+				if _, err := c.ingest(b, recv, ""); err != nil {
+					t.Fatalf("ingest: %v", err)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("merged %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIngestFlows(t *testing.T) {
+	var got []packets.Flow
+	c := &Collector{Flows: func(recs []packets.Flow) { got = append(got, recs...) }}
+	recv := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	ahead := 10 * time.Second
+	start := recv.Add(-time.Minute)
+	send := func(seq uint64) {
+		t.Helper()
+		b := &flowpb.Batch{
+			ProbeID:  "p",
+			Sequence: seq,
+			SentNs:   recv.Add(ahead).UnixNano(),
+			Flows: []flowpb.Flow{{
+				StartNs: start.Add(ahead).UnixNano(), EndNs: recv.Add(ahead).UnixNano(),
+				SrcPort: uint32(seq), Protocol: 17, PacketsUp: 1, BytesUp: 60,
+			}},
+		}
+		if err := c.Ingest(b, recv); err != nil {
+			t.Fatalf("Ingest: %v", err)
+		}
+	}
+	// Flow records are put in order with the packets, and corrected.
+	send(1)
+	send(3)
+	send(2)
+	if len(got) != 3 {
+		t.Fatalf("got %d records, want 3", len(got))
+	}
+	for i, f := range got {
+		if f.SrcPort != uint16(i+1) || !f.Start.Equal(start) || !f.End.Equal(recv) || f.SrcBytes != 60 {
+			t.Errorf("record %d: got %+v, want port %d from %v to %v", i, f, i+1, start, recv)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	c := &Collector{}
+	start := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	reg := func(at time.Time, started time.Time, packets, dropped uint64) {
+		t.Helper()
+		r := &Registration{ID: "p", Location: "attic", Version: "v1", Interfaces: []string{"br0"}, Started: started, Packets: packets, Dropped: dropped}
+		if err := c.Register(r, at); err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+	}
+	reg(start, start, 0, 0)
+	reg(start.Add(time.Minute), start, 6000, 60)
+	p := c.Probes()[0]
+	if p.PacketRate != 100 || p.DropRate != 1 || p.Captured != 6000 || p.Location != "attic" || !p.LastContact.Equal(start.Add(time.Minute)) {
+		t.Errorf("after two registrations: got %+v, want 100 packets/s, 1 drop/s", p)
+	}
+	if p.Status != "stale" {
+		t.Errorf("status of a probe not heard from since 2015: got %q, want stale", p.Status)
+	}
+
+	// A restart resets the rates.
+	reg(start.Add(2*time.Minute), start.Add(90*time.Second), 10, 0)
+	if p := c.Probes()[0]; p.PacketRate != 0 || p.DropRate != 0 {
+		t.Errorf("after a restart: got rates %v, %v, want 0", p.PacketRate, p.DropRate)
+	}
+	if err := c.Register(&Registration{}, start); err == nil {
+		t.Error("Register without an ID: got nil error, want error")
+	}
+}
+
+func TestProbeStatus(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		s    ProbeStatus
+		want string
+	}{
+		{ProbeStatus{LastContact: now}, "ok"},
+		{ProbeStatus{LastContact: now.Add(-StaleAfter - time.Second), Skewed: true}, "stale"},
+		{ProbeStatus{LastContact: now, Skewed: true, DropRate: 1}, "skewed"},
+		{ProbeStatus{LastContact: now, DropRate: 1, Held: 1}, "dropping"},
+		{ProbeStatus{LastContact: now, Held: 1}, "lagging"},
+	} {
+		if got := test.s.status(now); got != test.want {
+			t.Errorf("status(%+v) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
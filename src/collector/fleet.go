@@ -0,0 +1,158 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+// This file takes the registrations probes send to say what they are, and
+// serves /fleet, an overview of the probes.
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"events"
+	"mtls"
+)
+
+const (
+	// RegisterInterval is how often probes register.
+	RegisterInterval = time.Minute
+
+	// StaleAfter is how long after the last contact a probe is stale.
+	StaleAfter = 3 * RegisterInterval
+
+	// maxRegistrationSize bounds the request body.
+	maxRegistrationSize = 64 << 10
+
+	// TODO: Have a templates dir installed somewhere sensible.
+	fleetTemplateFile = "src/collector/fleet.html"
+)
+
+// Registration is what a probe says about itself, POSTed as JSON to
+// /api/register when it starts and every RegisterInterval after.
+type Registration struct {
+	ID         string
+	Location   string `json:",omitempty"`
+	Version    string
+	Interfaces []string
+	Started    time.Time
+	Packets    uint64 // read since Started
+	Dropped    uint64 // dropped by the kernel or interfaces since Started
+}
+
+// Register records a registration received at recv.
+func (c *Collector) Register(r *Registration, recv time.Time) error {
+	return c.register(r, recv, "")
+}
+
+// register is Register for a registration sent with the TLS key with the
+// pin (or "").
+func (c *Collector) register(r *Registration, recv time.Time, key string) error {
+	if r.ID == "" {
+		return fmt.Errorf("registration has no ID")
+	}
+	p := c.probe(r.ID)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setKey(r.ID, key)
+	prev, at := p.reg, p.registered
+	if r.Started.Equal(prev.Started) && r.Packets >= prev.Packets && r.Dropped >= prev.Dropped {
+		if secs := recv.Sub(at).Seconds(); secs > 0 {
+			p.packetRate = float64(r.Packets-prev.Packets) / secs
+			p.dropRate = float64(r.Dropped-prev.Dropped) / secs
+		}
+	} else {
+		// It is new, or restarted.
+		p.packetRate, p.dropRate = 0, 0
+		e := events.Event{
+			Type:     "probe-registered",
+			Severity: events.Notice,
+			Message:  fmt.Sprintf("probe %s (%s) registered, capturing %v", r.ID, r.Version, r.Interfaces),
+			Fields:   map[string]string{"probe": r.ID, "version": r.Version, "location": r.Location},
+		}
+		log.Printf("collector: %s", e.Message)
+		events.Publish(e)
+	}
+	p.reg = *r
+	p.registered = recv
+	return nil
+}
+
+// status sums up a probe's status as of now: "stale" if it hasn't been
+// heard from in StaleAfter, "skewed", "dropping" packets, "lagging" while
+// batches are held for missing ones, or else "ok".
+func (s *ProbeStatus) status(now time.Time) string {
+	switch {
+	case now.Sub(s.LastContact) > StaleAfter:
+		return "stale"
+	case s.Skewed:
+		return "skewed"
+	case s.DropRate > 0:
+		return "dropping"
+	case s.Held > 0:
+		return "lagging"
+	}
+	return "ok"
+}
+
+// registerHandler accepts a Registration as a JSON POST body.
+func (c *Collector) registerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST a registration", http.StatusMethodNotAllowed)
+		return
+	}
+	recv := time.Now()
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRegistrationSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var reg Registration
+	if err := json.Unmarshal(body, &reg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var key string
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		key = mtls.Pin(r.TLS.PeerCertificates[0])
+	}
+	if err := c.register(&reg, recv, key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fleetHandler serves an overview of the probes.
+func (c *Collector) fleetHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	funcs := template.FuncMap{
+		"ago": func(t time.Time) string { return now.Sub(t).Round(time.Second).String() },
+	}
+	// Load the template each call, like the dashboard.
+	t, err := template.New("fleet.html").Funcs(funcs).ParseFiles(fleetTemplateFile)
+	if err != nil {
+		log.Print("fleet template failed to parse:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := t.Execute(w, c.Probes()); err != nil {
+		log.Print("fleet template failed to write:", err)
+	}
+}
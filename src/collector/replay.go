@@ -0,0 +1,226 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+// This file puts each probe's batches back in order before they are merged.
+// A batch that arrives after a gap in the sequence numbers is held, and the
+// probe is asked to send the missing batches again, which it can if it kept
+// them (see sinks.CollectorWriter). A gap that isn't filled within the
+// replay window is given up on: its batches are counted as lost, and the
+// held batches are merged.
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"events"
+	"flowpb"
+	"packets"
+)
+
+const (
+	// DefaultReplayWindow is the default time a gap is waited on.
+	DefaultReplayWindow = 30 * time.Second
+
+	// DefaultMaxHeld is the default number of batches held per probe.
+	DefaultMaxHeld = 1000
+
+	// maxReplayRanges bounds the ranges asked for in one response.
+	maxReplayRanges = 16
+)
+
+// batch is the records of a batch, skew corrected.
+type batch struct {
+	packets []packets.Metadata
+	flows   []packets.Flow
+}
+
+// held is a batch waiting for the ones before it.
+type held struct {
+	data batch
+	recv time.Time
+}
+
+// sequencer tracks the sequence numbers of one probe's batches.
+type sequencer struct {
+	next  uint64 // expected next; 0 until the first numbered batch
+	first uint64 // the first sequence number seen in this epoch
+	epoch uint64 // the probe's sequence epoch; 0 if it doesn't send one
+	held  map[uint64]held
+	asked time.Time // when missing batches were last asked for
+
+	merged, lost, duplicates, replays uint64
+}
+
+// place adds a batch with sequence number seq in the given epoch, received
+// at recv, and returns the batches that can now be merged, in order.
+// Unnumbered batches (from older probes) are merged as they come.
+func (s *sequencer) place(id string, seq, epoch uint64, data batch, recv time.Time, window time.Duration, maxHeld int) []batch {
+	var ready []batch
+	switch {
+	case seq == 0:
+		s.merged++
+		return []batch{data}
+	case s.next == 0:
+		// The first batch since we started.
+		s.next, s.first, s.epoch = seq, seq, epoch
+	case epoch != 0 && s.epoch != 0 && epoch < s.epoch:
+		// A late batch from before the probe restarted.
+		s.duplicates++
+		return nil
+	case epoch != 0 && s.epoch != 0 && epoch > s.epoch:
+		// The probe restarted its numbering. Whatever it hadn't sent
+		// before is gone.
+		ready = s.release(id, recv, 0, 0)
+		s.next, s.first, s.epoch = 1, 1, epoch
+	case (epoch == 0 || s.epoch == 0) && seq == 1 && s.next > 1 && s.first > 1:
+		// Batch 1, which we haven't merged, and no epochs to go by: the
+		// probe must have restarted without its sequence.
+		ready = s.release(id, recv, 0, 0)
+		s.next, s.first = 1, 1
+	case seq < s.next:
+		s.duplicates++
+		return nil
+	}
+	if s.epoch == 0 {
+		// The probe has started sending epochs.
+		s.epoch = epoch
+	}
+	if _, ok := s.held[seq]; ok {
+		s.duplicates++
+		return ready
+	}
+	if s.held == nil {
+		s.held = make(map[uint64]held)
+	}
+	s.held[seq] = held{data, recv}
+	return append(ready, s.release(id, recv, window, maxHeld)...)
+}
+
+// release returns the held batches that are next in sequence. A gap is
+// given up on if the batch after it has been held for window, or more than
+// maxHeld batches are held.
+func (s *sequencer) release(id string, now time.Time, window time.Duration, maxHeld int) []batch {
+	var ready []batch
+	for len(s.held) > 0 {
+		if h, ok := s.held[s.next]; ok {
+			ready = append(ready, h.data)
+			delete(s.held, s.next)
+			s.next++
+			s.merged++
+			continue
+		}
+		first, _ := s.oldest()
+		if now.Sub(first.recv) < window && len(s.held) <= maxHeld {
+			break
+		}
+		after := s.lowest()
+		s.lost += after - s.next
+		e := events.Event{
+			Type:     "probe-gap",
+			Severity: events.Warning,
+			Message:  fmt.Sprintf("probe %s: batches %d-%d lost", id, s.next, after-1),
+			Fields:   map[string]string{"probe": id, "from": fmt.Sprint(s.next), "to": fmt.Sprint(after - 1)},
+		}
+		log.Printf("collector: %s", e.Message)
+		events.Publish(e)
+		s.next = after
+	}
+	return ready
+}
+
+// lowest returns the lowest held sequence number. There must be one.
+func (s *sequencer) lowest() uint64 {
+	var min uint64
+	for seq := range s.held {
+		if min == 0 || seq < min {
+			min = seq
+		}
+	}
+	return min
+}
+
+// oldest returns the held batch that arrived first.
+func (s *sequencer) oldest() (held, bool) {
+	var first held
+	ok := false
+	for _, h := range s.held {
+		if !ok || h.recv.Before(first.recv) {
+			first, ok = h, true
+		}
+	}
+	return first, ok
+}
+
+// missing returns the sequence numbers of the gaps before held batches.
+func (s *sequencer) missing() []flowpb.Range {
+	seqs := make([]uint64, 0, len(s.held))
+	for seq := range s.held {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	var rs []flowpb.Range
+	from := s.next
+	for _, seq := range seqs {
+		if seq > from {
+			rs = append(rs, flowpb.Range{From: from, To: seq - 1})
+		}
+		from = seq + 1
+	}
+	return rs
+}
+
+// missingCount returns the number of batches in the gaps.
+func (s *sequencer) missingCount() uint64 {
+	var n uint64
+	for _, r := range s.missing() {
+		n += r.To - r.From + 1
+	}
+	return n
+}
+
+// ask returns the ReplayHeader value asking for the missing batches, or ""
+// if there are none or they were asked for less than a quarter of the window
+// ago.
+func (s *sequencer) ask(now time.Time, window time.Duration) string {
+	if len(s.held) == 0 || now.Sub(s.asked) < window/4 {
+		return ""
+	}
+	rs := s.missing()
+	if len(rs) > maxReplayRanges {
+		rs = rs[:maxReplayRanges]
+	}
+	s.asked = now
+	s.replays++
+	return flowpb.FormatRanges(rs)
+}
+
+// lag returns how long the oldest held batch has waited.
+func (s *sequencer) lag(now time.Time) time.Duration {
+	if h, ok := s.oldest(); ok {
+		return now.Sub(h.recv)
+	}
+	return 0
+}
+
+// completeness returns the fraction of batches merged rather than lost.
+func (s *sequencer) completeness() float64 {
+	if s.merged+s.lost == 0 {
+		return 1
+	}
+	return float64(s.merged) / float64(s.merged+s.lost)
+}
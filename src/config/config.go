@@ -0,0 +1,915 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config holds caplog's settings, which are read from a JSON file.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that is written as a string ("30s") in JSON.
+type Duration struct {
+	time.Duration
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// HTTP configures the user interface and API server.
+type HTTP struct {
+	Port        int      `json:"port"`
+	CORSOrigins []string `json:"cors_origins,omitempty"`
+	Panels      string   `json:"panels,omitempty"`
+	Language    string   `json:"language,omitempty"`
+}
+
+// Outputs configures where packet metadata is written. Empty means disabled.
+type Outputs struct {
+	Influx   string `json:"influx,omitempty"`
+	Telegraf string `json:"telegraf,omitempty"`
+	Victoria string `json:"victoria,omitempty"`
+	QuestDB  string `json:"questdb,omitempty"`
+	File     string `json:"file,omitempty"`
+
+	// InfluxUser and InfluxDB are the username and database for Influx
+	// (both "caplog" if empty). The password or token isn't kept in the
+	// config file, see InfluxPassword.
+	InfluxUser string `json:"influx_user,omitempty"`
+	InfluxDB   string `json:"influx_db,omitempty"`
+
+	// InfluxPassword and InfluxToken authenticate to Influx and InfluxV2.
+	// They come from flags or the environment, so they stay out of config
+	// files.
+	InfluxPassword string `json:"-"`
+	InfluxToken    string `json:"-"`
+
+	// InfluxV2 writes line protocol to InfluxDB 1.8+ or 2.x. (Influx is
+	// the JSON API of InfluxDB 0.8.)
+	InfluxV2 InfluxV2 `json:"influx_v2"`
+
+	// BigQuery streams flow records into a BigQuery table.
+	BigQuery BigQuery `json:"bigquery"`
+
+	// Archive uploads packets in compressed batch files to object storage.
+	Archive Archive `json:"archive"`
+
+	// ZeekConn is a file to append connection records to, in the format
+	// of Zeek's conn.log. ZeekFormat is "tsv" (the default) or "json".
+	ZeekConn   string `json:"zeek_conn,omitempty"`
+	ZeekFormat string `json:"zeek_format,omitempty"`
+
+	// Collector is the base URL of a caplog collector to send to.
+	// CollectorTLS, if set, is used for mutual TLS with it (the URL must
+	// then be https).
+	Collector    string `json:"collector,omitempty"`
+	CollectorTLS TLS    `json:"collector_tls"`
+
+	// CollectorKeep is how many of the batches sent to the collector are
+	// kept (in the spool directory), to send again if it asks. Batches are
+	// only kept if SpoolDir or FailedSpoolDir is set.
+	CollectorKeep int `json:"collector_keep"`
+
+	// SpoolDir, if set, keeps each output's queue on disk (in a
+	// subdirectory named after the output) rather than in memory.
+	SpoolDir string `json:"spool_dir,omitempty"`
+
+	// FailedSpoolDir, if set (and SpoolDir isn't), keeps queues in memory,
+	// but sets aside buffers that fail to write on disk (in a subdirectory
+	// named after the output), to write once the output is back.
+	FailedSpoolDir string `json:"failed_spool_dir,omitempty"`
+
+	// SpoolMaxMB caps the disk space of each output's spool. Beyond it,
+	// the oldest buffers are dropped. 0 means no limit.
+	SpoolMaxMB int `json:"spool_max_mb"`
+
+	// TimeFormat sets the timestamp format of outputs, by output name:
+	// "ns", "us", "ms", "s" or "rfc3339". Each output has its own default,
+	// and supports only the formats its destination understands.
+	TimeFormat map[string]string `json:"time_format,omitempty"`
+
+	// QueueBudget, if set, is a number of buffers shared between the
+	// outputs' in-memory queues, instead of each having queue_length of its
+	// own. Once it is used up, the queue furthest over its weighted share
+	// drops its oldest buffer, so a slow output can't starve the others.
+	// Queues kept in spool_dir take no part.
+	QueueBudget int `json:"queue_budget"`
+
+	// Weights sets the outputs' shares of QueueBudget, by output name.
+	// Outputs not named have a weight of 1.
+	Weights map[string]int `json:"weights,omitempty"`
+}
+
+// RemoteWrite configures pushing aggregates via Prometheus remote write.
+// Secrets come from the environment, not the config.
+type RemoteWrite struct {
+	URL      string   `json:"url,omitempty"`
+	Interval Duration `json:"interval"`
+	User     string   `json:"user,omitempty"`
+	Instance string   `json:"instance,omitempty"`
+}
+
+// Rollup keeps totals along a dimension (see Dimension) for each Interval,
+// e.g. per device per day, for the last Keep intervals. Rollups are saved in
+// StateDir, if set.
+type Rollup struct {
+	Dimension
+	Interval Duration `json:"interval"`
+	Keep     int      `json:"keep"`
+}
+
+// Enrichment configures the enrichers run on each packet.
+// Dimension is an extra breakdown of traffic, such as by destination port
+// range, or by device group and application.
+type Dimension struct {
+	Name string `json:"name"`
+
+	// By lists the fields that make up each key, e.g. ["device_group",
+	// "app"]. See the README for the fields.
+	By []string `json:"by"`
+
+	// PortBuckets are the lower bounds of the port ranges for the
+	// *_port_bucket fields, e.g. [0, 1024, 49152].
+	PortBuckets []int `json:"port_buckets,omitempty"`
+
+	// Groups maps group names to CIDRs, for the device_group field.
+	Groups map[string][]string `json:"groups,omitempty"`
+
+	// MergeFamilies counts IPv4 and IPv6 traffic to the same name
+	// together, with totals for each family.
+	MergeFamilies bool `json:"merge_families,omitempty"`
+}
+
+type Enrichment struct {
+	// Order lists the enrichers to run, in order. Empty means all of them.
+	Order []string `json:"order,omitempty"`
+
+	// Budget is how long enrichment may take per packet; enrichers still to
+	// run after that are skipped. 0 means no limit.
+	Budget Duration `json:"budget"`
+}
+
+// InfluxV2 configures writing line protocol to the /api/v2/write endpoint
+// of InfluxDB 2.x (or 1.8+, with Bucket "database/retention-policy"). The
+// token is Outputs.InfluxToken; for 1.8 it is "username:password".
+type InfluxV2 struct {
+	URL    string `json:"url,omitempty"`
+	Org    string `json:"org,omitempty"`
+	Bucket string `json:"bucket,omitempty"`
+}
+
+// BigQuery configures streaming flow records into a BigQuery table, with the
+// Storage Write API. It is on if Dataset is set.
+type BigQuery struct {
+	// Project defaults to the service account's.
+	Project string `json:"project,omitempty"`
+	Dataset string `json:"dataset,omitempty"`
+	Table   string `json:"table,omitempty"` // "flows" if empty
+
+	// Credentials is the service account's JSON key file. If empty,
+	// $GOOGLE_APPLICATION_CREDENTIALS is used.
+	Credentials string `json:"credentials,omitempty"`
+}
+
+// Archive uploads packets to S3, GCS or another S3-compatible store (such as
+// MinIO), as gzipped JSON Lines objects, partitioned by time.
+type Archive struct {
+	// URL is where objects go: s3://bucket/prefix or gs://bucket/prefix.
+	URL string `json:"url,omitempty"`
+
+	// Endpoint is the base URL of an S3-compatible store, addressed
+	// path-style (e.g. http://minio:9000). Empty means AWS.
+	Endpoint string `json:"endpoint,omitempty"`
+	Region   string `json:"region,omitempty"` // "us-east-1" if empty
+
+	// Layout is the Go time layout of the partition directories, in UTC
+	// ("dt=2006-01-02/hour=15" if empty).
+	Layout string `json:"layout,omitempty"`
+
+	// Interval is how long an object collects packets before it is
+	// uploaded, and MaxMB how big (compressed) it can get first.
+	Interval Duration `json:"interval"`
+	MaxMB    int      `json:"max_mb"`
+
+	// Credentials is the service account's JSON key file, for GCS. If
+	// empty, $GOOGLE_APPLICATION_CREDENTIALS is used. S3 keys come from
+	// $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY (and
+	// $AWS_SESSION_TOKEN), so they stay out of config files.
+	Credentials string `json:"credentials,omitempty"`
+}
+
+// SNMP configures the SNMPv2c agent.
+type SNMP struct {
+	// Listen is the UDP address to answer on, e.g. ":161". Empty means
+	// off.
+	Listen    string `json:"listen,omitempty"`
+	Community string `json:"community"`
+}
+
+// Events configures where caplog's events (probe clock skew, outputs
+// failing, CPU throttling...) are sent.
+type Events struct {
+	Syslog Syslog `json:"syslog"`
+
+	// File, if set, is a file to append events to, one per line.
+	File string `json:"file,omitempty"`
+
+	// FileFormat is "cef" or "leef" for File; the default is JSON.
+	FileFormat string `json:"file_format,omitempty"`
+}
+
+// Syslog configures sending events to a syslog server.
+type Syslog struct {
+	// URL is udp://host[:port], tcp://host[:port] or tls://host[:port].
+	// Empty means off.
+	URL string `json:"url,omitempty"`
+
+	// Facility is the syslog facility name.
+	Facility string `json:"facility"`
+
+	// Severities overrides the severity name of events, by event type.
+	Severities map[string]string `json:"severities,omitempty"`
+
+	// Format is "cef" or "leef" to send the message in that format, for
+	// SIEMs. The default is plain RFC 5424, with the fields as
+	// structured data.
+	Format string `json:"format,omitempty"`
+}
+
+// DNSServer configures reading the query log of the local DNS server.
+// The password (Pi-hole's API token, or AdGuard Home's password) comes
+// from the environment, not the config.
+type DNSServer struct {
+	// Type is "pihole" or "adguard". Empty means off.
+	Type string `json:"type,omitempty"`
+
+	// URL is the server's web interface, e.g. http://pi.hole/.
+	URL string `json:"url,omitempty"`
+
+	// User is the AdGuard Home username.
+	User string `json:"user,omitempty"`
+
+	// Interval is how often the log is read.
+	Interval Duration `json:"interval"`
+}
+
+// Warmup configures naming addresses at startup, so that the first minutes
+// after a restart aren't full of bare addresses.
+type Warmup struct {
+	// Names are files of names to import, as exported from
+	// /api/names/export. With a state_dir, the names saved there at
+	// shutdown are imported too.
+	Names []string `json:"names,omitempty"`
+
+	// DNSServerLog is how far back to read the dns_server's query log at
+	// startup. 0 means only queries made after startup are read.
+	DNSServerLog Duration `json:"dns_server_log"`
+}
+
+// Suricata configures reading alerts from Suricata.
+type Suricata struct {
+	// EVE is the path of Suricata's EVE JSON log (eve.json). Empty means
+	// off.
+	EVE string `json:"eve,omitempty"`
+}
+
+// Mirror configures re-emitting captured packets for another analyzer
+// (ntopng, tcpdump, Zeek...), so that it needn't capture on the interface
+// too. Changes take a restart.
+type Mirror struct {
+	// Socket is a unix socket whose clients are each sent a pcap stream of
+	// the mirrored packets. Empty means none.
+	Socket string `json:"socket,omitempty"`
+
+	// Tun is a tun device (Linux only) to write the mirrored IP packets
+	// to, created if it doesn't exist. Empty means none.
+	Tun string `json:"tun,omitempty"`
+
+	// Filter is a BPF expression selecting the packets mirrored, out of
+	// those captured. Empty means all of them.
+	Filter string `json:"filter,omitempty"`
+}
+
+// ASN configures labelling remote addresses with their autonomous system.
+type ASN struct {
+	// DB is a MaxMind ASN database (GeoLite2-ASN.mmdb), or a Routeviews
+	// pfx2as dump (gzipped or not). Empty means off.
+	DB string `json:"db,omitempty"`
+
+	// Names is a file of "number name" lines naming the ASes, for dumps
+	// that don't, like https://ftp.ripe.net/ripe/asnames/asn.txt.
+	Names string `json:"names,omitempty"`
+}
+
+// Flows configures grouping packets into flows, and storing a record of
+// each (see packets.FlowTable).
+type Flows struct {
+	// File is a file to append the records to, as JSON lines. Empty means
+	// off.
+	File string `json:"file,omitempty"`
+
+	// IdleTimeout is how long a flow can be quiet before it ends.
+	// ActiveTimeout is how long a flow can last before a record of it is
+	// stored, and a new one begun. They apply to every user of the
+	// records: the file, outputs.zeek_conn and the dashboard.
+	IdleTimeout   Duration `json:"idle_timeout"`
+	ActiveTimeout Duration `json:"active_timeout"`
+}
+
+// Streams configures reassembling TCP streams, and storing a summary of
+// each.
+type Streams struct {
+	// File is a file to append the summaries to, as JSON lines. Empty means
+	// off.
+	File string `json:"file,omitempty"`
+
+	// IdleTimeout is how long a stream can be quiet before it is
+	// summarised.
+	IdleTimeout Duration `json:"idle_timeout"`
+}
+
+// OUI configures naming the vendors of the devices on the LAN.
+type OUI struct {
+	// DB is the IEEE's list of MAC address assignments (oui.csv or
+	// oui.txt), or Wireshark's manuf file, gzipped or not. Empty means off.
+	DB string `json:"db,omitempty"`
+
+	// URL, if set, is downloaded to DB when DB is missing or older than
+	// MaxAge, e.g. https://standards-oui.ieee.org/oui/oui.csv.
+	URL    string   `json:"url,omitempty"`
+	MaxAge Duration `json:"max_age"`
+}
+
+// Controller configures reading client names from a network controller. The
+// password comes from the environment (CAPLOG_CONTROLLER_PASSWORD), not the
+// config.
+type Controller struct {
+	// Type is "unifi" or "openwrt". Empty means off.
+	Type string `json:"type,omitempty"`
+
+	// URL is the controller's web interface, e.g. https://unifi.local:8443/.
+	URL string `json:"url,omitempty"`
+
+	User string `json:"user,omitempty"`
+
+	// Site is the UniFi site, if not "default".
+	Site string `json:"site,omitempty"`
+
+	// Insecure skips verifying the UniFi controller's certificate.
+	Insecure bool `json:"insecure,omitempty"`
+
+	// Interval is how often the client list is read.
+	Interval Duration `json:"interval"`
+}
+
+// WAN configures finding the router's public address, whose traffic then
+// counts as local.
+type WAN struct {
+	// Detect finds it from the traffic, when capturing outside the NAT.
+	Detect bool `json:"detect,omitempty"`
+
+	// CheckURL is fetched every Interval to find it. The response is just
+	// the address, as from https://api.ipify.org/.
+	CheckURL string   `json:"check_url,omitempty"`
+	Interval Duration `json:"interval"`
+}
+
+// HomeAssistant configures the Home Assistant sensors. The access token
+// comes from the environment (CAPLOG_HASS_TOKEN), not the config.
+type HomeAssistant struct {
+	Enabled bool `json:"enabled,omitempty"`
+
+	// URL is Home Assistant's base URL. If empty, the sensors are only
+	// served at /api/hass.
+	URL string `json:"url,omitempty"`
+
+	Interval      Duration `json:"interval"`
+	OnlineTimeout Duration `json:"online_timeout"`
+
+	// Devices are the known devices' names, by MAC address.
+	Devices map[string]string `json:"devices,omitempty"`
+}
+
+// History configures the recent history kept for /api/query.
+type History struct {
+	Resolution Duration `json:"resolution"`
+	Retention  Duration `json:"retention"`
+}
+
+// Collector configures receiving from other caplogs (probes).
+type Collector struct {
+	Enabled bool `json:"enabled,omitempty"`
+
+	// MaxSkew is how far off a probe's clock can be before it is flagged.
+	MaxSkew Duration `json:"max_skew"`
+
+	// ReplayWindow is how long a gap in a probe's batches is waited on,
+	// asking the probe to send them again, before they are counted as lost.
+	// MaxHeld is the most batches held per probe meanwhile.
+	ReplayWindow Duration `json:"replay_window"`
+	MaxHeld      int      `json:"max_held"`
+
+	// Listen, if set, is the address of a separate listener for
+	// /api/ingest, which requires mutual TLS with the probes pinned in
+	// TLS. Batches are then no longer taken over the main HTTP server.
+	Listen string `json:"listen,omitempty"`
+	TLS    TLS    `json:"tls"`
+}
+
+// TLS is the certificate one side of the probe-collector transport
+// presents, and the keys of the other side it accepts.
+type TLS struct {
+	// Cert and Key are PEM files. They are reread when they change, so
+	// they can be replaced (e.g. renewed) without a restart.
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+
+	// Pins are the base64 SHA-256 hashes of the public keys accepted
+	// (see "caplog tls-pin"). While rotating keys, list the old and the
+	// new.
+	Pins []string `json:"pins,omitempty"`
+}
+
+// Config is the whole configuration.
+type Config struct {
+	// Profile is "full" (the default) or "lite", for small devices. See
+	// ApplyProfile.
+	Profile string `json:"profile,omitempty"`
+
+	// Disable lists optional modules to turn off: "dashboard", "sinks",
+	// "alerting" or "devices". Modules can also be left out of the build
+	// with tags (see the README).
+	Disable []string `json:"disable,omitempty"`
+
+	Interface         string   `json:"interface"`
+	InterfaceFallback []string `json:"interface_fallback,omitempty"`
+
+	// Interfaces are captured at the same time as Interface, e.g. to see
+	// each network segment separately on the dashboard.
+	Interfaces []string `json:"interfaces,omitempty"`
+
+	// InterfaceOptions changes how some of the interfaces are captured, by
+	// name, e.g. a bigger snaplen on the WAN side for long TLS hellos.
+	InterfaceOptions map[string]InterfaceOptions `json:"interface_options,omitempty"`
+
+	// HostStats is "exact" to keep totals for every host, or "sketch" to
+	// find only the biggest HeavyHitters hosts, in bounded memory.
+	HostStats    string `json:"host_stats"`
+	HeavyHitters int    `json:"heavy_hitters,omitempty"`
+	HostSampling int    `json:"host_sampling,omitempty"` // count 1 in N packets per host
+
+	// Detailed turns on flow statistics, per-device cardinality,
+	// src-dst totals and reverse DNS names. It is on by default, and the
+	// lite profile turns it off.
+	Detailed bool `json:"detailed"`
+
+	// CPULimit is the fraction of all CPUs caplog should use at most. Above
+	// it, caplog sheds work: first the detailed statistics and names, then
+	// it samples hosts more and more heavily. 0 turns this off.
+	CPULimit float64 `json:"cpu_limit"`
+
+	// CardinalityInterval is how long distinct remote hosts and ports are
+	// counted for, per device.
+	CardinalityInterval Duration `json:"cardinality_interval"`
+
+	// BillingDay is the day of the month (1-28) billing periods start on.
+	BillingDay int `json:"billing_day"`
+
+	// DataCap is the data allowance per billing period, in bytes (0 for
+	// none), that forecast use is compared with.
+	DataCap uint64 `json:"data_cap,omitempty"`
+
+	// ProbeID names this caplog in exported batches (default: hostname).
+	ProbeID string `json:"probe_id,omitempty"`
+
+	// Location tags this caplog when it registers with a collector, e.g.
+	// "branch-office".
+	Location string `json:"location,omitempty"`
+
+	// StateDir is where state that should survive restarts is kept, such
+	// as batch sequence numbers. Empty means nothing is kept.
+	StateDir string `json:"state_dir,omitempty"`
+
+	// NamesSaveInterval is how often the learned names are saved in
+	// StateDir, so that they survive a crash as well as a restart. 0 saves
+	// them only at shutdown.
+	NamesSaveInterval Duration `json:"names_save_interval"`
+
+	// StateSaveInterval is how often the dashboard's rollups and billing
+	// samples are saved in StateDir, so that the current periods survive a
+	// crash. They are also
+	// saved as each period ends, and at shutdown. 0 saves them only then.
+	StateSaveInterval Duration `json:"state_save_interval"`
+
+	// DrainTimeout bounds shutting down, from the captures stopping: the
+	// packets already read are processed, the partial buffers handed over,
+	// and the outputs flushed, in that order, within it. 0 means no limit.
+	DrainTimeout Duration `json:"drain_timeout"`
+
+	LocalNet    string `json:"local_net,omitempty"`
+	WAN         WAN    `json:"wan"`
+	BufferSize  int    `json:"buffer_size"`
+	QueueLength int    `json:"queue_length"`
+
+	// Processors is the number of goroutines decoding each capture's
+	// packets. 0 means one per CPU. It can be changed while running, by a
+	// reload or with SIGUSR1 (one more) and SIGUSR2 (one fewer).
+	Processors int `json:"processors,omitempty"`
+
+	// Filter is a BPF expression that further restricts what is captured,
+	// e.g. "not host 192.168.1.2".
+	Filter string `json:"filter,omitempty"`
+
+	// SizeAccounting is what packets' sizes count, everywhere: "frame"
+	// (the default) for the Ethernet frame as captured, "ip" for the IP
+	// packet, or "wire" for the frame padded to Ethernet's minimum plus
+	// WireOverhead bytes, which is 24 by default (FCS, preamble and gap)
+	// and can include a line's encapsulation. It needs a restart.
+	SizeAccounting string `json:"size_accounting,omitempty"`
+	WireOverhead   int    `json:"wire_overhead"`
+
+	// Coalescing spots the superframes NICs coalesce packets into.
+	Coalescing Coalescing `json:"coalescing"`
+
+	// Ignore lists traffic to leave out of the dashboard's totals, unlike
+	// Filter still decoded and (unless a rule drops it) sent to the
+	// outputs. The first rule that matches applies.
+	Ignore []Ignore `json:"ignore,omitempty"`
+
+	// Names labels addresses by hand ("192.168.1.10": "nas"). Labels beat
+	// names from any other source, unless NameChain says otherwise.
+	Names map[string]string `json:"names,omitempty"`
+
+	// NameChain lists the name sources to use, most trusted first. See the
+	// README for the sources. Empty means the default chain.
+	NameChain []string `json:"name_chain,omitempty"`
+
+	// NameMinTTL is the least time a name learned for an address is kept
+	// after it was last seen, even if its DNS TTL is shorter, as
+	// connections outlive short TTLs. NameMapSize is the most addresses
+	// each host's names are kept for (0 for no limit).
+	NameMinTTL  Duration `json:"name_min_ttl"`
+	NameMapSize int      `json:"name_map_size"`
+
+	// NameAffinity is how long a name a host was given for a server (by
+	// DNS or SNI) keeps naming the host's later traffic to the server that
+	// has no name of its own, after it was last used. 0 is off.
+	NameAffinity Duration `json:"name_affinity"`
+
+	// PTRLookup looks up the names of addresses that weren't named any
+	// other way.
+	PTRLookup PTRLookup `json:"ptr_lookup"`
+
+	HTTP        HTTP        `json:"http"`
+	Outputs     Outputs     `json:"outputs"`
+	RemoteWrite RemoteWrite `json:"remote_write"`
+	Collector   Collector   `json:"collector"`
+	Enrichment  Enrichment  `json:"enrichment"`
+	SNMP        SNMP        `json:"snmp"`
+	Events      Events      `json:"events"`
+	Suricata    Suricata    `json:"suricata"`
+	DNSServer   DNSServer   `json:"dns_server"`
+	Warmup      Warmup      `json:"warmup"`
+	Controller  Controller  `json:"controller"`
+	ASN         ASN         `json:"asn"`
+	OUI         OUI         `json:"oui"`
+	Mirror      Mirror      `json:"mirror"`
+	Flows       Flows       `json:"flows"`
+	Streams     Streams     `json:"streams"`
+
+	HomeAssistant HomeAssistant `json:"home_assistant"`
+
+	// DHCPLeases is the DHCP server's leases file, to learn devices' host
+	// names and addresses from: ISC dhcpd's, dnsmasq's or Kea's. "auto"
+	// looks for one in the usual places. Empty means none.
+	// DHCPLeasesFormat is "isc", "dnsmasq" or "kea"; empty works it out
+	// from the file.
+	DHCPLeases       string `json:"dhcp_leases,omitempty"`
+	DHCPLeasesFormat string `json:"dhcp_leases_format,omitempty"`
+
+	History History `json:"history"`
+
+	// Dimensions are extra breakdowns of traffic for the dashboard API.
+	Dimensions []Dimension `json:"dimensions,omitempty"`
+
+	// Rollups keep totals along a dimension for whole periods.
+	Rollups []Rollup `json:"rollups,omitempty"`
+}
+
+// Ignore is a rule picking out traffic to leave out of the totals. Traffic
+// matches if one end is in Hosts, the other end is in Peers (if set), and
+// either port is in Ports (if set). It needs Hosts or Ports.
+type Ignore struct {
+	Name string `json:"name,omitempty"` // for /vars
+
+	// Hosts and Peers are addresses or netblocks.
+	Hosts []string `json:"hosts,omitempty"`
+	Peers []string `json:"peers,omitempty"`
+	Ports []int    `json:"ports,omitempty"`
+
+	// Drop keeps the traffic out of the outputs too.
+	Drop bool `json:"drop,omitempty"`
+}
+
+// Coalescing spots superframes: packets bigger than the MTU, coalesced by
+// GRO, TSO or GSO, which would otherwise each count as one packet.
+type Coalescing struct {
+	// MTU is the largest IP packet on the wire (default 1500); bigger ones
+	// are superframes. With jumbo frames, it should be theirs.
+	MTU int `json:"mtu"`
+
+	// Estimate counts each superframe as the packets it was likely made
+	// from, with their headers.
+	Estimate bool `json:"estimate,omitempty"`
+}
+
+// InterfaceOptions are the capture settings for one interface.
+type InterfaceOptions struct {
+	// Filter replaces the top-level Filter for the interface.
+	Filter string `json:"filter,omitempty"`
+
+	// SnapLen is how many bytes of each packet are captured, from 118 to
+	// 65535. 0 means the default, 1600.
+	SnapLen int `json:"snaplen,omitempty"`
+}
+
+// PTRLookup configures caplog's own PTR lookups, for addresses that were
+// resolved before it started. They are off by default, as they tell the DNS
+// server (and whoever it asks) which addresses are being talked to.
+type PTRLookup struct {
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Server is the DNS server ("host:port") to ask. Empty means the
+	// system's resolver.
+	Server string `json:"server,omitempty"`
+
+	// Rate is the most lookups a second.
+	Rate int `json:"rate"`
+
+	// CacheTTL is how long before an address is looked up again, whether
+	// or not it had a name.
+	CacheTTL Duration `json:"cache_ttl"`
+}
+
+// Default returns the default configuration.
+func Default() *Config {
+	return &Config{
+		Interface:           "br0",
+		BufferSize:          10000,
+		QueueLength:         100,
+		WireOverhead:        24,
+		Coalescing:          Coalescing{MTU: 1500},
+		HostStats:           "exact",
+		Detailed:            true,
+		BillingDay:          1,
+		CardinalityInterval: Duration{5 * time.Minute},
+		NamesSaveInterval:   Duration{5 * time.Minute},
+		StateSaveInterval:   Duration{5 * time.Minute},
+		DrainTimeout:        Duration{10 * time.Second},
+		NameMinTTL:          Duration{time.Hour},
+		NameMapSize:         100000,
+		NameAffinity:        Duration{30 * time.Minute},
+		PTRLookup: PTRLookup{
+			Rate:     10,
+			CacheTTL: Duration{time.Hour},
+		},
+		HTTP: HTTP{
+			Port: 8080,
+		},
+		Outputs: Outputs{
+			SpoolMaxMB:    100,
+			CollectorKeep: 1000,
+			Archive: Archive{
+				Interval: Duration{5 * time.Minute},
+				MaxMB:    64,
+			},
+		},
+		RemoteWrite: RemoteWrite{
+			Interval: Duration{30 * time.Second},
+		},
+		Collector: Collector{
+			MaxSkew:      Duration{2 * time.Second},
+			ReplayWindow: Duration{30 * time.Second},
+			MaxHeld:      1000,
+		},
+		SNMP: SNMP{
+			Community: "public",
+		},
+		DNSServer: DNSServer{
+			Interval: Duration{10 * time.Second},
+		},
+		WAN: WAN{
+			Interval: Duration{5 * time.Minute},
+		},
+		Controller: Controller{
+			Interval: Duration{time.Minute},
+		},
+		Flows: Flows{
+			IdleTimeout:   Duration{time.Minute},
+			ActiveTimeout: Duration{30 * time.Minute},
+		},
+		Streams: Streams{
+			IdleTimeout: Duration{2 * time.Minute},
+		},
+		OUI: OUI{
+			MaxAge: Duration{30 * 24 * time.Hour},
+		},
+		HomeAssistant: HomeAssistant{
+			Interval:      Duration{30 * time.Second},
+			OnlineTimeout: Duration{10 * time.Minute},
+		},
+		Events: Events{
+			Syslog: Syslog{Facility: "local0"},
+		},
+		History: History{
+			Resolution: Duration{time.Minute},
+			Retention:  Duration{24 * time.Hour},
+		},
+	}
+}
+
+// ProbeName returns the ProbeID, or else the hostname.
+func (c *Config) ProbeName() string {
+	if c.ProbeID != "" {
+		return c.ProbeID
+	}
+	h, _ := os.Hostname()
+	return h
+}
+
+// InterfaceFilter returns the filter for the interface: its own, or else
+// the top-level Filter.
+func (c *Config) InterfaceFilter(iface string) string {
+	if f := c.InterfaceOptions[iface].Filter; f != "" {
+		return f
+	}
+	return c.Filter
+}
+
+// ApplyProfile adjusts the settings for the profile. The "lite" profile is
+// for routers with 64-128MB of memory: it keeps the top-level counters and a
+// sampled top-talkers sketch, and turns off everything else. Settings that
+// were changed from their defaults are left alone.
+func (c *Config) ApplyProfile() error {
+	switch c.Profile {
+	case "", "full":
+		return nil
+	case "lite":
+	default:
+		return fmt.Errorf("unknown profile %q (want full or lite)", c.Profile)
+	}
+	d := Default()
+	if c.BufferSize == d.BufferSize {
+		c.BufferSize = 1000
+	}
+	if c.QueueLength == d.QueueLength {
+		c.QueueLength = 4
+	}
+	if c.HostStats == d.HostStats {
+		c.HostStats = "sketch"
+	}
+	if c.HeavyHitters == d.HeavyHitters {
+		c.HeavyHitters = 20
+	}
+	if c.HostSampling == d.HostSampling {
+		c.HostSampling = 100
+	}
+	if c.History.Retention == d.History.Retention {
+		c.History.Retention = Duration{time.Hour}
+	}
+	c.Detailed = false
+	return nil
+}
+
+// Load reads a config file over the defaults.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c := Default()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Write writes the config as indented JSON.
+func (c *Config) Write(w io.Writer) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+// Set sets one setting by its dotted key (as in the JSON, e.g.
+// "outputs.influx") from a string, as given on a command line. Lists are
+// comma-separated.
+func (c *Config) Set(key, value string) error {
+	v := reflect.ValueOf(c).Elem()
+	for _, part := range strings.Split(key, ".") {
+		f, ok := fieldByTag(v, part)
+		if !ok {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		v = f
+	}
+	switch v.Interface().(type) {
+	case Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		v.Set(reflect.ValueOf(Duration{d}))
+		return nil
+	case []string:
+		var list []string
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		v.SetBool(b)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("setting %q can't be set from a string", key)
+	}
+	return nil
+}
+
+// fieldByTag finds the struct field with the given JSON name.
+func fieldByTag(v reflect.Value, name string) (reflect.Value, bool) {
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
@@ -0,0 +1,132 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSet(t *testing.T) {
+	c := Default()
+	sets := []struct{ key, value string }{
+		{"interface", "eth1"},
+		{"buffer_size", "500"},
+		{"http.cors_origins", "http://a, http://b"},
+		{"outputs.influx", "http://127.0.0.1:8086/"},
+		{"remote_write.interval", "1m"},
+	}
+	for _, s := range sets {
+		if err := c.Set(s.key, s.value); err != nil {
+			t.Errorf("Set(%q, %q): %v", s.key, s.value, err)
+		}
+	}
+	want := Default()
+	want.Interface = "eth1"
+	want.BufferSize = 500
+	want.HTTP.CORSOrigins = []string{"http://a", "http://b"}
+	want.Outputs.Influx = "http://127.0.0.1:8086/"
+	want.RemoteWrite.Interval = Duration{time.Minute}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("after Set: got %+v, want %+v", c, want)
+	}
+
+	if err := c.Set("outputs.kafka", "x"); err == nil {
+		t.Error("Set(outputs.kafka): got nil error, want error")
+	}
+	if err := c.Set("buffer_size", "lots"); err == nil {
+		t.Error("Set(buffer_size, lots): got nil error, want error")
+	}
+}
+
+func TestWriteLoadRoundTrip(t *testing.T) {
+	c := Default()
+	c.Outputs.Telegraf = "udp://127.0.0.1:8094"
+	c.RemoteWrite.Interval = Duration{15 * time.Second}
+	var b bytes.Buffer
+	if err := c.Write(&b); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "caplog.json")
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("Load(Write(c)): got %+v, want %+v", got, c)
+	}
+}
+
+func TestApplyProfileFull(t *testing.T) {
+	for _, profile := range []string{"", "full"} {
+		c := Default()
+		c.Profile = profile
+		if err := c.ApplyProfile(); err != nil {
+			t.Fatalf("ApplyProfile(%q): %v", profile, err)
+		}
+		if !c.Detailed {
+			t.Errorf("ApplyProfile(%q): Detailed is off, want the default on", profile)
+		}
+	}
+}
+
+func TestApplyProfileLite(t *testing.T) {
+	c := Default()
+	c.Profile = "lite"
+	c.QueueLength = 10 // changed, so kept
+	if err := c.ApplyProfile(); err != nil {
+		t.Fatalf("ApplyProfile: %v", err)
+	}
+	if c.BufferSize != 1000 || c.QueueLength != 10 || c.HostStats != "sketch" || c.Detailed {
+		t.Errorf("after ApplyProfile: got %+v", c)
+	}
+
+	c.Profile = "tiny"
+	if err := c.ApplyProfile(); err == nil {
+		t.Error("ApplyProfile(tiny): got nil error, want error")
+	}
+}
+
+func TestInterfaceFilter(t *testing.T) {
+	c := Default()
+	c.Filter = "not host 192.168.1.2"
+	c.InterfaceOptions = map[string]InterfaceOptions{
+		"wan0": {Filter: "not port 22", SnapLen: 65535},
+		"iot":  {SnapLen: 128},
+	}
+	tests := map[string]string{
+		"wan0": "not port 22",
+		"iot":  "not host 192.168.1.2", // no filter of its own
+		"br0":  "not host 192.168.1.2", // no options
+	}
+	for iface, want := range tests {
+		if got := c.InterfaceFilter(iface); got != want {
+			t.Errorf("InterfaceFilter(%s) = %q, want %q", iface, got, want)
+		}
+	}
+}
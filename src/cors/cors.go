@@ -0,0 +1,99 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cors adds Cross-Origin Resource Sharing headers to the JSON API, so
+// that frontends served from other origins can query caplog from the browser.
+package cors
+
+import (
+	"net/http"
+	"strings"
+)
+
+// AllowedOrigins is the list of origins allowed to make cross-origin requests.
+// An entry of "*" allows any origin.
+var AllowedOrigins []string
+
+// apiPaths are the path prefixes that get CORS headers. The HTML dashboard
+// itself doesn't need them.
+var apiPaths = []string{
+	"/api/",
+	"/dashboard/events",
+	"/dashboard/json",
+	"/vars",
+}
+
+// ParseOrigins splits a comma-separated list of origins, as given on the
+// command line.
+func ParseOrigins(s string) []string {
+	return CleanOrigins(strings.Split(s, ","))
+}
+
+// CleanOrigins returns the origins as browsers send them, without spaces or
+// a trailing "/", and leaving out empty ones. A config file's list should be
+// cleaned before it is used as AllowedOrigins.
+func CleanOrigins(list []string) []string {
+	var origins []string
+	for _, o := range list {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, strings.TrimRight(o, "/"))
+		}
+	}
+	return origins
+}
+
+// Allowed reports whether the origin is in AllowedOrigins.
+func Allowed(origin string) bool {
+	for _, o := range AllowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func isAPI(path string) bool {
+	for _, p := range apiPaths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler wraps h, adding CORS headers to responses from the JSON API when the
+// request origin is allowed, and answering preflight requests.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !isAPI(r.URL.Path) || !Allowed(origin) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		hd := w.Header()
+		hd.Set("Access-Control-Allow-Origin", origin)
+		hd.Add("Vary", "Origin")
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			// Preflight request.
+			hd.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+				hd.Set("Access-Control-Allow-Headers", reqHeaders)
+			}
+			hd.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,55 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCleanOrigins(t *testing.T) {
+	// As from a config file: not split from a string, but still needing
+	// the trailing "/" gone.
+	got := CleanOrigins([]string{"http://hass.local:8123/", " http://other.example ", ""})
+	if want := []string{"http://hass.local:8123", "http://other.example"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CleanOrigins: got %q, want %q", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	AllowedOrigins = ParseOrigins("http://hass.local:8123/, http://other.example")
+	defer func() { AllowedOrigins = nil }()
+
+	h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		path, origin, want string
+	}{
+		{path: "/dashboard/json", origin: "http://hass.local:8123", want: "http://hass.local:8123"},
+		{path: "/vars", origin: "http://other.example", want: "http://other.example"},
+		{path: "/vars", origin: "http://evil.example", want: ""},
+		{path: "/dashboard", origin: "http://hass.local:8123", want: ""},
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Header.Set("Origin", test.origin)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.want {
+			t.Errorf("test %d: Access-Control-Allow-Origin for %s from %s: got %q, want %q", i, test.path, test.origin, got, test.want)
+		}
+	}
+}
@@ -19,7 +19,9 @@ package dashboard
 import (
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -27,21 +29,26 @@ import (
 )
 
 var (
-	vals Values
-
-	mapVars = MapValues{
-		UpByIP:     make(map[string]Aggregation),
-		DownByIP:   make(map[string]Aggregation),
-		UpByName:   make(map[string]Aggregation),
-		DownByName: make(map[string]Aggregation),
-		SrcDstIP:   make(map[string]map[string]Aggregation),
-		SrcDstName: make(map[string]map[string]Aggregation),
-	}
+	detailed int32 = 1 // see SetDetailed; accessed atomically
+
+	// combined counts all traffic; each interface also has its own Counters.
+	combined Counters
+	ifMu     sync.RWMutex // guards ifVals
+	ifVals   = make(map[string]*Counters)
+
+	// Each 802.1Q VLAN also has its own Counters (untagged traffic isn't
+	// counted here).
+	vlanMu   sync.RWMutex // guards vlanVals
+	vlanVals = make(map[uint16]*Counters)
 )
 
 // Aggregation combines the two counters for each total or flow.
 type Aggregation struct {
 	Bytes, Packets uint64
+
+	// LastSeen is the time of the most recent packet (in Unix nanoseconds),
+	// for the totals that keep it: hosts, pairs of hosts and dimensions.
+	LastSeen int64 `json:",omitempty"`
 }
 
 // Add adds 1 packet of a given size to an agg, returning the new value.
@@ -50,36 +57,38 @@ func (a *Aggregation) Add(bytes uint64) {
 	atomic.AddUint64(&a.Packets, 1)
 }
 
-// Values contains all the aggregations for a flow (and other values).
-type Values struct {
-	Now time.Time
+// addPacket adds the packet's size, and the packets on the wire it
+// stands for.
+func (a *Aggregation) addPacket(m *packets.Metadata) {
+	atomic.AddUint64(&a.Bytes, m.Size)
+	atomic.AddUint64(&a.Packets, m.Packets())
+}
 
-	// Flow statistics.
+// Counters are the per-direction and per-family totals for some traffic.
+type Counters struct {
 	Up, Down, Internal, External, Total Aggregation
 	V4, V6                              Aggregation
-}
 
-type MapValues struct {
-	UpByIP, DownByIP     map[string]Aggregation
-	UpByName, DownByName map[string]Aggregation
-	SrcDstIP, SrcDstName map[string]map[string]Aggregation
+	// ICMP and ICMPv6 traffic, in any direction: echo requests and
+	// replies, destination unreachable messages, and everything else.
+	Ping, Unreachable, OtherICMP Aggregation
 }
 
-// AddPacket lets vals account for the packet.
-func AddPacket(m *packets.Metadata) {
-	vals.Total.Add(m.Size)
+// add accounts for the packet.
+func (c *Counters) add(m *packets.Metadata) {
+	c.Total.addPacket(m)
 
 	// Classify packet flow for subtotals.
 	srcPrivate, dstPrivate := packets.IsLocal(m.SrcIP), packets.IsLocal(m.DstIP)
 	switch {
 	case srcPrivate && dstPrivate:
-		vals.Internal.Add(m.Size)
+		c.Internal.addPacket(m)
 	case srcPrivate:
-		vals.Up.Add(m.Size)
+		c.Up.addPacket(m)
 	case dstPrivate:
-		vals.Down.Add(m.Size)
+		c.Down.addPacket(m)
 	default:
-		vals.External.Add(m.Size)
+		c.External.addPacket(m)
 	}
 
 	// Only add to the V4 / V6 counters when considering internet
@@ -87,23 +96,184 @@ func AddPacket(m *packets.Metadata) {
 	// traffic will slowly dominate over time otherwise.
 	if !(srcPrivate && dstPrivate) {
 		if m.V6 {
-			vals.V6.Add(m.Size)
+			c.V6.addPacket(m)
 		} else {
-			vals.V4.Add(m.Size)
+			c.V4.addPacket(m)
+		}
+	}
+
+	switch {
+	case m.Ping():
+		c.Ping.addPacket(m)
+	case m.Unreachable():
+		c.Unreachable.addPacket(m)
+	case m.ICMP():
+		c.OtherICMP.addPacket(m)
+	}
+}
+
+// snapshot reads the counters atomically (each one, not all together).
+func (c *Counters) snapshot() Counters {
+	load := func(a *Aggregation) Aggregation {
+		return Aggregation{
+			Bytes:    atomic.LoadUint64(&a.Bytes),
+			Packets:  atomic.LoadUint64(&a.Packets),
+			LastSeen: atomic.LoadInt64(&a.LastSeen),
 		}
 	}
+	return Counters{
+		Up:       load(&c.Up),
+		Down:     load(&c.Down),
+		Internal: load(&c.Internal),
+		External: load(&c.External),
+		Total:    load(&c.Total),
+		V4:       load(&c.V4),
+		V6:       load(&c.V6),
+
+		Ping:        load(&c.Ping),
+		Unreachable: load(&c.Unreachable),
+		OtherICMP:   load(&c.OtherICMP),
+	}
 }
 
-// State returns the current state of the vals.
+// Values contains all the aggregations for a flow (and other values).
+type Values struct {
+	Now time.Time
+
+	// Flow statistics, combined over all interfaces.
+	Counters
+
+	// Interfaces has the statistics for each interface being captured.
+	Interfaces map[string]Counters `json:",omitempty"`
+
+	// VLANs has the statistics for each VLAN ID seen in 802.1Q tags.
+	VLANs map[uint16]Counters `json:",omitempty"`
+
+	// WANIP is the router's public address, if known. Its traffic is
+	// counted as local.
+	WANIP net.IP `json:",omitempty"`
+
+	// Coalescing is set if superframes were captured in the last minute,
+	// so that packet counts are low unless estimated. See
+	// packets.SetCoalescing.
+	Coalescing bool `json:",omitempty"`
+}
+
+// MapValues are the per-host totals (by local host, in each direction)
+// and the per-pair totals (from source to destination). The totals by MAC
+// address follow devices from one IP address to the next.
+type MapValues struct {
+	UpByIP, DownByIP     map[string]Aggregation
+	UpByName, DownByName map[string]Aggregation
+	UpByMAC, DownByMAC   map[string]Aggregation
+	SrcDstIP, SrcDstName map[string]map[string]Aggregation
+}
+
+// AddPacket accounts for the packet in the combined totals (and per-host
+// totals, extra dimensions, history, rollups, billing and DNS domains), but
+// not any interface's totals. Flows are counted from the flow table's
+// records (see AddFlows).
+func AddPacket(m *packets.Metadata) {
+	combined.add(m)
+	if m.VLAN != 0 {
+		accountVLAN(m)
+	}
+	accountHosts(m)
+	accountDimensions(m)
+	recordHistory(m)
+	accountRollups(m)
+	accountBilling(m)
+	accountDNSDomains(m)
+	if atomic.LoadInt32(&detailed) != 0 {
+		countDistinct(m)
+		accountPairs(m)
+	}
+}
+
+// Accounter returns a function that accounts for packets captured on the
+// named interface, both in its own totals and the combined totals. The
+// interface appears in the Values once it has seen a packet.
+func Accounter(iface string) func(*packets.Metadata) {
+	var (
+		once sync.Once
+		c    *Counters
+	)
+	return func(m *packets.Metadata) {
+		once.Do(func() {
+			ifMu.Lock()
+			defer ifMu.Unlock()
+			if c = ifVals[iface]; c == nil {
+				c = new(Counters)
+				ifVals[iface] = c
+			}
+		})
+		c.add(m)
+		AddPacket(m)
+	}
+}
+
+// accountVLAN accounts for a tagged packet in its VLAN's totals.
+func accountVLAN(m *packets.Metadata) {
+	vlanMu.RLock()
+	c := vlanVals[m.VLAN]
+	vlanMu.RUnlock()
+	if c == nil {
+		vlanMu.Lock()
+		if c = vlanVals[m.VLAN]; c == nil {
+			c = new(Counters)
+			vlanVals[m.VLAN] = c
+		}
+		vlanMu.Unlock()
+	}
+	c.add(m)
+}
+
+// SetDetailed turns the costlier statistics (flow sizes and per-device
+// cardinality) on or off, to save memory and CPU. It can be changed at any
+// time.
+func SetDetailed(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&detailed, v)
+}
+
+// State returns the current values.
 func State() Values {
-	vals.Now = time.Now()
-	return vals
+	v := Values{
+		Now:        time.Now(),
+		Counters:   combined.snapshot(),
+		WANIP:      packets.WANIP(),
+		Coalescing: packets.Coalescing(),
+	}
+	ifMu.RLock()
+	defer ifMu.RUnlock()
+	if len(ifVals) > 0 {
+		v.Interfaces = make(map[string]Counters, len(ifVals))
+		for name, c := range ifVals {
+			v.Interfaces[name] = c.snapshot()
+		}
+	}
+	vlanMu.RLock()
+	defer vlanMu.RUnlock()
+	if len(vlanVals) > 0 {
+		v.VLANs = make(map[uint16]Counters, len(vlanVals))
+		for id, c := range vlanVals {
+			v.VLANs[id] = c.snapshot()
+		}
+	}
+	return v
 }
 
 func dashValuesHandler(w http.ResponseWriter, r *http.Request) {
 	h := w.Header()
 	h.Add("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(State()); err != nil {
+	v := struct {
+		Values
+		Maps MapValues
+	}{State(), Maps()}
+	if err := json.NewEncoder(w).Encode(v); err != nil {
 		log.Print("template failed to write:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
@@ -0,0 +1,101 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"net"
+	"testing"
+
+	"packets"
+)
+
+func TestAccounterPerInterface(t *testing.T) {
+	before := State()
+	up := &packets.Metadata{Size: 100, SrcIP: net.ParseIP("192.168.1.2"), DstIP: net.ParseIP("8.8.8.8")}
+	down := &packets.Metadata{Size: 40, SrcIP: net.ParseIP("8.8.8.8"), DstIP: net.ParseIP("192.168.1.2")}
+	Accounter("test-a")(up)
+	b := Accounter("test-b")
+	b(down)
+	b(up)
+
+	after := State()
+	if got, want := after.Total.Bytes-before.Total.Bytes, uint64(240); got != want {
+		t.Errorf("combined Total.Bytes grew by %d, want %d", got, want)
+	}
+	tests := []struct {
+		iface    string
+		up, down uint64
+	}{
+		{"test-a", 100, 0},
+		{"test-b", 100, 40},
+	}
+	for _, test := range tests {
+		c, ok := after.Interfaces[test.iface]
+		if !ok {
+			t.Errorf("Interfaces[%q] missing", test.iface)
+			continue
+		}
+		if c.Up.Bytes != test.up || c.Down.Bytes != test.down {
+			t.Errorf("Interfaces[%q] up, down: got %d, %d, want %d, %d", test.iface, c.Up.Bytes, c.Down.Bytes, test.up, test.down)
+		}
+	}
+}
+
+func TestAccounterICMP(t *testing.T) {
+	a := Accounter("test-icmp")
+	for _, m := range []*packets.Metadata{
+		{Size: 98, Protocol: 1, ICMPType: 8},    // echo request
+		{Size: 98, Protocol: 1, ICMPType: 0},    // echo reply
+		{Size: 70, Protocol: 1, ICMPType: 3},    // unreachable
+		{Size: 118, Protocol: 58, ICMPType: 1},  // unreachable
+		{Size: 86, Protocol: 58, ICMPType: 135}, // neighbour solicitation
+		{Size: 60, Protocol: 6},                 // TCP
+	} {
+		m.SrcIP, m.DstIP = net.ParseIP("192.168.1.2"), net.ParseIP("8.8.8.8")
+		a(m)
+	}
+	c := State().Interfaces["test-icmp"]
+	tests := []struct {
+		name string
+		got  Aggregation
+		want uint64
+	}{
+		{"Ping", c.Ping, 196},
+		{"Unreachable", c.Unreachable, 188},
+		{"OtherICMP", c.OtherICMP, 86},
+	}
+	for _, test := range tests {
+		if test.got.Bytes != test.want {
+			t.Errorf("%s.Bytes: got %d, want %d", test.name, test.got.Bytes, test.want)
+		}
+	}
+}
+
+func TestAddPacketVLAN(t *testing.T) {
+	m := &packets.Metadata{Size: 60, SrcIP: net.ParseIP("192.168.1.2"), DstIP: net.ParseIP("8.8.8.8"), VLAN: 4000}
+	AddPacket(m)
+	AddPacket(m)
+	AddPacket(&packets.Metadata{Size: 60, SrcIP: net.ParseIP("192.168.1.2"), DstIP: net.ParseIP("8.8.8.8")})
+	c, ok := State().VLANs[4000]
+	if !ok {
+		t.Fatal("VLANs[4000] missing")
+	}
+	if c.Up.Bytes != 120 || c.Up.Packets != 2 {
+		t.Errorf("VLANs[4000].Up: got %+v, want 120 bytes in 2 packets", c.Up)
+	}
+	if _, ok := State().VLANs[0]; ok {
+		t.Error("untagged packets counted as VLAN 0")
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file computes the numbers ISPs and colos bill by: the 95th percentile
+// of the 5-minute rates over the billing period, and the peak 5-minute rate,
+// for each direction.
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"packets"
+)
+
+// billingInterval is the length of each rate sample.
+const billingInterval = 5 * time.Minute
+
+var (
+	// BillingDay is the day of the month (1-28) billing periods start on, at
+	// local midnight. Set it before capturing.
+	BillingDay = 1
+
+	// BillingDir, if set, is where the current period's samples are saved
+	// (hourly), so a restart doesn't lose them.
+	BillingDir string
+)
+
+// Rate is the billing figures for one direction, in bits per second.
+type Rate struct {
+	P95    float64
+	Peak   float64
+	PeakAt time.Time `json:",omitempty"`
+}
+
+// BillingReport covers one billing period. Every 5-minute interval of the
+// period so far counts, including any when caplog wasn't running (as zero).
+type BillingReport struct {
+	Start, End time.Time
+	Samples    int // complete 5-minute intervals so far
+	Up, Down   Rate
+}
+
+// billingState is the byte counts of each 5-minute interval of the current
+// period, for each direction.
+type billingState struct {
+	Start    time.Time
+	Up, Down []uint64
+}
+
+var billing = struct {
+	sync.Mutex
+	billingState
+	loaded   bool
+	previous *BillingReport
+}{}
+
+// billingPeriod returns the start and end of the billing period containing t.
+func billingPeriod(t time.Time) (start, end time.Time) {
+	day := BillingDay
+	if day < 1 || day > 28 {
+		day = 1
+	}
+	start = time.Date(t.Year(), t.Month(), day, 0, 0, 0, 0, t.Location())
+	if start.After(t) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start, start.AddDate(0, 1, 0)
+}
+
+func billingPath() string {
+	return filepath.Join(BillingDir, "billing.json")
+}
+
+// accountBilling adds an upstream or downstream packet to its interval.
+func accountBilling(m *packets.Metadata) {
+	dir := direction(m)
+	if dir != "up" && dir != "down" {
+		return
+	}
+	t := m.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	billing.Lock()
+	defer billing.Unlock()
+	startBilling(t)
+	start, end := billingPeriod(t)
+	switch {
+	case start.After(billing.Start):
+		r := billingReport(end)
+		billing.previous = &r
+		billing.billingState = billingState{Start: start}
+		if BillingDir != "" {
+			if err := saveBilling(); err != nil {
+				log.Printf("billing: %v", err)
+			}
+		}
+	case start.Before(billing.Start):
+		return // from a previous period
+	}
+	i := int(t.Sub(billing.Start) / billingInterval)
+	for len(billing.Up) <= i {
+		billing.Up = append(billing.Up, 0)
+		billing.Down = append(billing.Down, 0)
+	}
+	if dir == "up" {
+		billing.Up[i] += m.Size
+	} else {
+		billing.Down[i] += m.Size
+	}
+}
+
+// startBilling loads the saved samples, or else starts the period containing
+// t, the first time it is called. The caller must hold billing.
+func startBilling(t time.Time) {
+	if billing.loaded {
+		return
+	}
+	billing.loaded = true
+	loadBilling()
+	if billing.Start.IsZero() {
+		billing.Start, _ = billingPeriod(t)
+	}
+}
+
+// loadBilling loads the saved samples, if any. The caller must hold billing.
+func loadBilling() {
+	if BillingDir == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(billingPath())
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &billing.billingState)
+	}
+	if err != nil {
+		log.Printf("billing: %v", err)
+	}
+}
+
+// saveBilling saves the samples. The caller must hold billing.
+func saveBilling() error {
+	b, err := json.Marshal(billing.billingState)
+	if err != nil {
+		return err
+	}
+	tmp := billingPath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, billingPath())
+}
+
+// saveBillingState saves the samples, if there is somewhere to save them
+// and they have been started.
+func saveBillingState() error {
+	if BillingDir == "" {
+		return nil
+	}
+	billing.Lock()
+	defer billing.Unlock()
+	if !billing.loaded {
+		// Nothing counted yet, and saving would overwrite what was.
+		return nil
+	}
+	return saveBilling()
+}
+
+// billingReport computes the report for the current period, counting the
+// complete intervals before now. The caller must hold billing.
+func billingReport(now time.Time) BillingReport {
+	_, end := billingPeriod(billing.Start)
+	if now.After(end) {
+		now = end
+	}
+	n := int(now.Sub(billing.Start) / billingInterval)
+	if n < 0 {
+		n = 0
+	}
+	return BillingReport{
+		Start:   billing.Start,
+		End:     end,
+		Samples: n,
+		Up:      billingRate(billing.Up, n, billing.Start),
+		Down:    billingRate(billing.Down, n, billing.Start),
+	}
+}
+
+// billingRate computes the rates of the first n intervals of counts (which
+// may be shorter; the rest are zero).
+func billingRate(counts []uint64, n int, start time.Time) Rate {
+	if n == 0 {
+		return Rate{}
+	}
+	rates := make([]float64, n)
+	var r Rate
+	for i := 0; i < n && i < len(counts); i++ {
+		rates[i] = float64(counts[i]*8) / billingInterval.Seconds()
+		if rates[i] > r.Peak {
+			r.Peak = rates[i]
+			r.PeakAt = start.Add(time.Duration(i) * billingInterval)
+		}
+	}
+	// The 95th percentile discards the top 5% of samples.
+	sort.Float64s(rates)
+	r.P95 = rates[(n*95+99)/100-1]
+	return r
+}
+
+// Billing returns reports for the last complete billing period (if caplog was
+// running at the end of it) and the current one.
+func Billing() (previous *BillingReport, current BillingReport) {
+	billing.Lock()
+	defer billing.Unlock()
+	now := time.Now()
+	startBilling(now)
+	return billing.previous, billingReport(now)
+}
+
+// billingHandler serves /api/billing.
+func billingHandler(w http.ResponseWriter, r *http.Request) {
+	prev, cur := Billing()
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		Previous *BillingReport `json:",omitempty"`
+		Current  BillingReport
+	}{prev, cur})
+	if err != nil {
+		log.Print("billing failed to write:", err)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"packets"
+)
+
+func TestBillingPeriod(t *testing.T) {
+	defer func() { BillingDay = 1 }()
+	BillingDay = 15
+	tests := []struct {
+		t, start time.Time
+	}{
+		{time.Date(2015, 6, 20, 9, 0, 0, 0, time.UTC), time.Date(2015, 6, 15, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2015, 6, 14, 23, 0, 0, 0, time.UTC), time.Date(2015, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2015, 1, 2, 0, 0, 0, 0, time.UTC), time.Date(2014, 12, 15, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		start, end := billingPeriod(test.t)
+		if !start.Equal(test.start) || !end.Equal(test.start.AddDate(0, 1, 0)) {
+			t.Errorf("billingPeriod(%v): got %v-%v, want start %v", test.t, start, end, test.start)
+		}
+	}
+}
+
+func TestBillingRate(t *testing.T) {
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	// 100 intervals: 1..100 * 37500 bytes per 5 minutes = 1..100 kbit/s.
+	counts := make([]uint64, 100)
+	for i := range counts {
+		counts[i] = uint64(i+1) * 37500
+	}
+	r := billingRate(counts, 100, start)
+	if r.P95 != 95000 {
+		t.Errorf("P95: got %v, want 95000", r.P95)
+	}
+	if r.Peak != 100000 || !r.PeakAt.Equal(start.Add(99*billingInterval)) {
+		t.Errorf("Peak: got %v at %v, want 100000 at %v", r.Peak, r.PeakAt, start.Add(99*billingInterval))
+	}
+
+	// Intervals with no traffic count as zero.
+	if r := billingRate(counts[:1], 100, start); r.P95 != 0 || r.Peak != 1000 {
+		t.Errorf("billingRate with idle intervals: got %+v, want P95 0, Peak 1000", r)
+	}
+}
+
+func TestAccountBilling(t *testing.T) {
+	billing.Lock()
+	billing.billingState, billing.loaded, billing.previous = billingState{}, false, nil
+	billing.Unlock()
+
+	t0 := time.Date(2015, 6, 30, 23, 50, 0, 0, time.UTC)
+	for _, m := range []packets.Metadata{
+		{Timestamp: t0, Size: 3750, SrcIP: net.ParseIP("192.168.1.10"), DstIP: net.ParseIP("8.8.8.8")},
+		{Timestamp: t0.Add(5 * time.Minute), Size: 7500, SrcIP: net.ParseIP("8.8.8.8"), DstIP: net.ParseIP("192.168.1.10")},
+		{Timestamp: t0.Add(20 * time.Minute), Size: 1, SrcIP: net.ParseIP("8.8.8.8"), DstIP: net.ParseIP("192.168.1.10")},
+	} {
+		accountBilling(&m)
+	}
+	billing.Lock()
+	prev := billing.previous
+	billing.Unlock()
+	if prev == nil {
+		t.Fatal("no report for the previous period")
+	}
+	if prev.Up.Peak != 100 || prev.Down.Peak != 200 {
+		t.Errorf("previous period: got peaks up %v, down %v, want 100, 200", prev.Up.Peak, prev.Down.Peak)
+	}
+	if want := time.Date(2015, 7, 1, 0, 0, 0, 0, time.UTC); !billing.Start.Equal(want) {
+		t.Errorf("current period start: got %v, want %v", billing.Start, want)
+	}
+}
+
+func TestSaveBillingState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "billing")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { BillingDir = d }(BillingDir)
+	BillingDir = dir
+	reset := func() {
+		billing.Lock()
+		billing.billingState, billing.loaded, billing.previous = billingState{}, false, nil
+		billing.Unlock()
+	}
+	reset()
+
+	// Nothing counted yet: nothing to save.
+	if err := saveBillingState(); err != nil {
+		t.Fatalf("saveBillingState: %v", err)
+	}
+	if _, err := os.Stat(billingPath()); !os.IsNotExist(err) {
+		t.Errorf("saved before anything was counted: %v", err)
+	}
+
+	// One interval in, long before a periodic save would have happened.
+	t0 := time.Date(2015, 6, 10, 12, 0, 0, 0, time.UTC)
+	accountBilling(&packets.Metadata{Timestamp: t0, Size: 3750, SrcIP: net.ParseIP("192.168.1.10"), DstIP: net.ParseIP("8.8.8.8")})
+	if err := saveBillingState(); err != nil {
+		t.Fatalf("saveBillingState: %v", err)
+	}
+
+	// "Restart".
+	reset()
+	billing.Lock()
+	startBilling(t0)
+	up := append([]uint64(nil), billing.Up...)
+	billing.Unlock()
+	if len(up) == 0 || up[len(up)-1] != 3750 {
+		t.Errorf("after reloading: got up %v, want the 3750 bytes counted", up)
+	}
+}
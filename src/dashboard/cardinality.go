@@ -0,0 +1,151 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file counts, per local device and interval, roughly how many distinct
+// remote hosts and ports it sent packets to. A device suddenly talking to
+// thousands of hosts or ports is probably scanning, or compromised.
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"packets"
+	"sketch"
+)
+
+const (
+	hllPrecision = 8 // 256 bytes per HLL, ±6.5%
+
+	// maxCardinalityDevices bounds memory; further devices in an interval
+	// aren't counted.
+	maxCardinalityDevices = 10000
+)
+
+// CardinalityInterval is the length of each counting interval.
+var CardinalityInterval = 5 * time.Minute
+
+// DeviceCardinality is the approximate number of distinct destinations of
+// one device.
+type DeviceCardinality struct {
+	Device                   string
+	RemoteHosts, RemotePorts uint64
+}
+
+// CardinalityReport covers one interval, with the devices sorted by
+// RemoteHosts, most first.
+type CardinalityReport struct {
+	Start, End time.Time
+	Devices    []DeviceCardinality
+}
+
+type deviceHLLs struct {
+	hosts, ports *sketch.HLL
+}
+
+var cardinality = struct {
+	sync.Mutex
+	start    time.Time
+	devices  map[string]*deviceHLLs
+	previous CardinalityReport
+}{
+	devices: make(map[string]*deviceHLLs),
+}
+
+// countDistinct adds a packet sent by a local device.
+func countDistinct(m *packets.Metadata) {
+	if !packets.IsLocal(m.SrcIP) {
+		return
+	}
+	now := m.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	device := m.SrcIP.String()
+
+	cardinality.Lock()
+	defer cardinality.Unlock()
+	if cardinality.start.IsZero() {
+		cardinality.start = now.Truncate(CardinalityInterval)
+	}
+	if d := now.Sub(cardinality.start); d >= CardinalityInterval || d < 0 {
+		cardinality.previous = cardinalityReport(cardinality.start.Add(CardinalityInterval))
+		cardinality.start = now.Truncate(CardinalityInterval)
+		cardinality.devices = make(map[string]*deviceHLLs)
+	}
+	h := cardinality.devices[device]
+	if h == nil {
+		if len(cardinality.devices) >= maxCardinalityDevices {
+			return
+		}
+		h = &deviceHLLs{
+			hosts: sketch.NewHLL(hllPrecision),
+			ports: sketch.NewHLL(hllPrecision),
+		}
+		cardinality.devices[device] = h
+	}
+	h.hosts.Add(m.DstIP.String())
+	h.ports.Add(strconv.Itoa(int(m.DstPort)))
+}
+
+// cardinalityReport summarises the current interval. The caller must hold
+// cardinality.
+func cardinalityReport(end time.Time) CardinalityReport {
+	r := CardinalityReport{
+		Start:   cardinality.start,
+		End:     end,
+		Devices: make([]DeviceCardinality, 0, len(cardinality.devices)),
+	}
+	for dev, h := range cardinality.devices {
+		r.Devices = append(r.Devices, DeviceCardinality{
+			Device:      dev,
+			RemoteHosts: h.hosts.Count(),
+			RemotePorts: h.ports.Count(),
+		})
+	}
+	sort.Slice(r.Devices, func(i, j int) bool {
+		a, b := r.Devices[i], r.Devices[j]
+		if a.RemoteHosts != b.RemoteHosts {
+			return a.RemoteHosts > b.RemoteHosts
+		}
+		return a.Device < b.Device
+	})
+	return r
+}
+
+// Cardinality returns reports for the last complete interval and the one in
+// progress (which has no End yet).
+func Cardinality() (previous, current CardinalityReport) {
+	cardinality.Lock()
+	defer cardinality.Unlock()
+	return cardinality.previous, cardinalityReport(time.Time{})
+}
+
+// cardinalityHandler serves /api/devices/cardinality.
+func cardinalityHandler(w http.ResponseWriter, r *http.Request) {
+	prev, cur := Cardinality()
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		Previous, Current CardinalityReport
+	}{prev, cur})
+	if err != nil {
+		log.Print("cardinality failed to write:", err)
+	}
+}
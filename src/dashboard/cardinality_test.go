@@ -0,0 +1,60 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"packets"
+)
+
+func TestCountDistinct(t *testing.T) {
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	pkt := func(at time.Duration, src, dst string, dport uint16) *packets.Metadata {
+		return &packets.Metadata{
+			Timestamp: start.Add(at),
+			SrcIP:     net.ParseIP(src),
+			DstIP:     net.ParseIP(dst),
+			DstPort:   dport,
+		}
+	}
+	// A scanner and a quiet device, in the same interval.
+	for i := 0; i < 200; i++ {
+		countDistinct(pkt(time.Second, "192.168.5.66", fmt.Sprintf("198.51.100.%d", i), uint16(1000+i)))
+		countDistinct(pkt(time.Second, "192.168.5.2", "203.0.113.1", 443))
+	}
+	// Inbound packets don't count.
+	countDistinct(pkt(time.Second, "203.0.113.1", "192.168.5.2", 443))
+	// The next interval.
+	countDistinct(pkt(CardinalityInterval+time.Second, "192.168.5.2", "203.0.113.1", 443))
+
+	prev, cur := Cardinality()
+	if len(prev.Devices) != 2 {
+		t.Fatalf("previous interval devices: got %v, want 2 devices", prev.Devices)
+	}
+	scanner, quiet := prev.Devices[0], prev.Devices[1]
+	if scanner.Device != "192.168.5.66" || scanner.RemoteHosts < 180 || scanner.RemotePorts < 180 {
+		t.Errorf("scanner: got %+v, want 192.168.5.66 with about 200 hosts and ports", scanner)
+	}
+	if quiet.Device != "192.168.5.2" || quiet.RemoteHosts != 1 || quiet.RemotePorts != 1 {
+		t.Errorf("quiet device: got %+v, want 192.168.5.2 with 1 host and 1 port", quiet)
+	}
+	if len(cur.Devices) != 1 {
+		t.Errorf("current interval devices: got %v, want 1 device", cur.Devices)
+	}
+}
@@ -17,9 +17,12 @@
 package dashboard
 
 import (
+	"bytes"
 	"html/template"
 	"log"
 	"net/http"
+	"path/filepath"
+	"sort"
 )
 
 const (
@@ -29,16 +32,57 @@ const (
 	ipTableTemplateFile = dashTemplateBase + "srcdsttable.html"
 )
 
+// PanelDir is a directory of additional *.html templates, each rendered as a
+// panel at the bottom of the dashboard. Empty means no custom panels.
+var PanelDir string
+
+// renderPanels executes each template in PanelDir with the given state.
+func renderPanels(state Values, lang string) ([]template.HTML, error) {
+	if PanelDir == "" {
+		return nil, nil
+	}
+	files, err := filepath.Glob(filepath.Join(PanelDir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	panels := make([]template.HTML, 0, len(files))
+	for _, f := range files {
+		t, err := template.New(filepath.Base(f)).Funcs(templateFuncs(lang)).ParseFiles(f)
+		if err != nil {
+			return nil, err
+		}
+		var b bytes.Buffer
+		if err := t.Execute(&b, state); err != nil {
+			return nil, err
+		}
+		// Safe: html/template has already escaped the panel's output.
+		panels = append(panels, template.HTML(b.String()))
+	}
+	return panels, nil
+}
+
 func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	state := State()
+	lang := requestLanguage(r)
+	panels, err := renderPanels(state, lang)
+	if err != nil {
+		log.Print("panel failed:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	funcs := templateFuncs(lang)
+	funcs["panels"] = func() []template.HTML { return panels }
+
 	// Load the template each call; because makes dev easier.
 	// TODO: Move template parsing back out, make template static.
-	dash, err := template.ParseFiles(dashTemplateFile, ipTableTemplateFile)
+	dash, err := template.New("dashboard.html").Funcs(funcs).ParseFiles(dashTemplateFile, ipTableTemplateFile)
 	if err != nil {
 		log.Print("template failed to parse:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if err := dash.ExecuteTemplate(w, "dashboard.html", State()); err != nil {
+	if err := dash.ExecuteTemplate(w, "dashboard.html", state); err != nil {
 		log.Print("template failed to write:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
@@ -46,5 +90,24 @@ func dashboardHandler(w http.ResponseWriter, r *http.Request) {
 
 func RegisterHandlers() {
 	http.HandleFunc("/dashboard/json", dashValuesHandler)
+	http.HandleFunc("/dashboard/events", eventsHandler)
+	http.HandleFunc("/dashboard/stream", streamHandler)
 	http.HandleFunc("/dashboard", dashboardHandler)
+	http.HandleFunc("/api/hosts", hostsHandler)
+	http.HandleFunc("/api/flows", flowsHandler)
+	http.HandleFunc("/api/flows/stream", flowStreamHandler)
+	http.HandleFunc("/api/flows/stats", flowStatsHandler)
+	http.HandleFunc("/api/devices/cardinality", cardinalityHandler)
+	http.HandleFunc("/api/dimensions", dimensionsHandler)
+	http.HandleFunc("/api/dimensions/", dimensionsHandler)
+	http.HandleFunc("/api/query", queryHandler)
+	http.HandleFunc("/api/rollups", rollupsHandler)
+	http.HandleFunc("/api/rollups/", rollupsHandler)
+	http.HandleFunc("/api/billing", billingHandler)
+	http.HandleFunc("/api/forecast", forecastHandler)
+	http.HandleFunc("/devices", devicesHandler)
+	http.HandleFunc("/api/names", namesHandler)
+	http.HandleFunc("/api/names/export", namesExportHandler)
+	http.HandleFunc("/api/alerts", alertsHandler)
+	http.HandleFunc("/api/dns/domains", dnsDomainsHandler)
 }
@@ -0,0 +1,100 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file serves the devices seen on the LAN, and the names of hosts.
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"packets"
+)
+
+// deviceTotals is a device, with its totals by MAC address (so across all
+// the addresses it had), if known.
+type deviceTotals struct {
+	packets.Device
+	Up, Down *Aggregation `json:",omitempty"`
+}
+
+// withTotals adds the totals to the devices.
+func withTotals(devices []packets.Device) []deviceTotals {
+	ds := make([]deviceTotals, len(devices))
+	for i, d := range devices {
+		ds[i].Device = d
+		if a, ok := maps.upByMAC.get(d.MAC); ok {
+			ds[i].Up = &a
+		}
+		if a, ok := maps.downByMAC.get(d.MAC); ok {
+			ds[i].Down = &a
+		}
+	}
+	return ds
+}
+
+// devicesHandler lists the devices learned from ARP, most recently seen
+// first, with their totals. With ?active=<duration>, only those seen within
+// that long.
+func devicesHandler(w http.ResponseWriter, r *http.Request) {
+	devices := packets.Devices()
+	if s := r.FormValue("active"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			http.Error(w, "bad active: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		since := time.Now().Add(-d)
+		n := 0
+		for n < len(devices) && !devices[n].LastSeen.Before(since) {
+			n++
+		}
+		devices = devices[:n]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(withTotals(devices)); err != nil {
+		log.Print("devices failed to write:", err)
+	}
+}
+
+// namesHandler lists the names known for ?ip=<address>, from every source,
+// most confident (the one used) first.
+func namesHandler(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(r.FormValue("ip"))
+	if ip == nil {
+		http.Error(w, "want ?ip=<address>", http.StatusBadRequest)
+		return
+	}
+	names := packets.Names(ip)
+	if names == nil {
+		names = []packets.NameRecord{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(names); err != nil {
+		log.Print("names failed to write:", err)
+	}
+}
+
+// namesExportHandler serves every learned name, one JSON object per line,
+// for importing into another caplog with warmup.names.
+func namesExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if err := packets.SaveNames(w); err != nil {
+		log.Print("names export failed to write:", err)
+	}
+}
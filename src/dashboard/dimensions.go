@@ -0,0 +1,456 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file keeps totals along extra dimensions chosen in the config, such as
+// "by destination port range" or "by device group and application", so that
+// new breakdowns don't need new code.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"asn"
+	"packets"
+)
+
+// maxDimensionKeys bounds the memory of each dimension; packets with further
+// keys are added to the otherKey total.
+const maxDimensionKeys = 10000
+
+const otherKey = "other"
+
+// Dimension describes an extra breakdown of traffic.
+type Dimension struct {
+	Name string
+
+	// By lists the fields that make up each key: any of the keys of
+	// dimensionFields.
+	By []string
+
+	// PortBuckets are the lower bounds of the port ranges used by the
+	// *_port_bucket fields, e.g. [0, 1024, 49152].
+	PortBuckets []int
+
+	// Groups names groups of local devices, by CIDR, for the device_group
+	// field. Devices in no group are in "other".
+	Groups map[string][]string
+
+	// MergeFamilies counts a dual-stack host's IPv4 and IPv6 traffic to the
+	// same service together: the name fields use just the name looked up,
+	// not the CNAMEs it led to (which can differ between A and AAAA
+	// answers), and each key's totals are also kept by family.
+	MergeFamilies bool
+}
+
+// nameFields are the fields that are names, which MergeFamilies shortens.
+var nameFields = map[string]bool{"src_name": true, "dst_name": true, "remote_name": true}
+
+// dimensionFields are the fields a Dimension can be broken down by.
+var dimensionFields = map[string]func(d *dimension, m *packets.Metadata) string{
+	"src_ip":   func(d *dimension, m *packets.Metadata) string { return m.SrcIP.String() },
+	"dst_ip":   func(d *dimension, m *packets.Metadata) string { return m.DstIP.String() },
+	"src_name": func(d *dimension, m *packets.Metadata) string { return nameOr(m.SrcName, m.SrcIP) },
+	"dst_name": func(d *dimension, m *packets.Metadata) string { return nameOr(m.DstName, m.DstIP) },
+	"src_port": func(d *dimension, m *packets.Metadata) string { return strconv.Itoa(int(m.SrcPort)) },
+	"dst_port": func(d *dimension, m *packets.Metadata) string { return strconv.Itoa(int(m.DstPort)) },
+	"src_port_bucket": func(d *dimension, m *packets.Metadata) string {
+		return d.portBucket(m.SrcPort)
+	},
+	"dst_port_bucket": func(d *dimension, m *packets.Metadata) string {
+		return d.portBucket(m.DstPort)
+	},
+	"direction": func(d *dimension, m *packets.Metadata) string { return direction(m) },
+	"family": func(d *dimension, m *packets.Metadata) string {
+		if m.V6 {
+			return "v6"
+		}
+		return "v4"
+	},
+	"device": func(d *dimension, m *packets.Metadata) string {
+		if ip := deviceIP(m); ip != nil {
+			return ip.String()
+		}
+		return otherKey
+	},
+	"device_group": func(d *dimension, m *packets.Metadata) string { return d.group(deviceIP(m)) },
+	"vlan":         func(d *dimension, m *packets.Metadata) string { return strconv.Itoa(int(m.VLAN)) },
+	"device_mac": func(d *dimension, m *packets.Metadata) string {
+		if mac := deviceMAC(m); mac != "" {
+			return mac
+		}
+		return otherKey
+	},
+	"remote_name": func(d *dimension, m *packets.Metadata) string {
+		switch direction(m) {
+		case "up":
+			return nameOr(m.DstName, m.DstIP)
+		case "down":
+			return nameOr(m.SrcName, m.SrcIP)
+		}
+		return otherKey
+	},
+	"remote_asn": func(d *dimension, m *packets.Metadata) string {
+		var i asn.Info
+		switch direction(m) {
+		case "up":
+			i = asn.Info{Number: m.DstASN, Org: m.DstASOrg}
+		case "down":
+			i = asn.Info{Number: m.SrcASN, Org: m.SrcASOrg}
+		}
+		if i.Number == 0 {
+			return otherKey
+		}
+		return i.String()
+	},
+	"remote_port": func(d *dimension, m *packets.Metadata) string {
+		return strconv.Itoa(int(remotePort(m)))
+	},
+	"protocol": func(d *dimension, m *packets.Metadata) string {
+		if m.AppProtocol != "" {
+			return m.AppProtocol
+		}
+		switch m.Protocol {
+		case 6:
+			return "tcp"
+		case 17:
+			return "udp"
+		case 1, 58:
+			return "icmp"
+		}
+		return otherKey
+	},
+	"app": func(d *dimension, m *packets.Metadata) string {
+		if app, ok := wellKnownPorts[remotePort(m)]; ok {
+			return app
+		}
+		return otherKey
+	},
+}
+
+// wellKnownPorts names the applications behind some common ports.
+var wellKnownPorts = map[uint16]string{
+	22:   "ssh",
+	25:   "smtp",
+	53:   "dns",
+	80:   "http",
+	123:  "ntp",
+	143:  "imap",
+	443:  "https",
+	587:  "smtp",
+	853:  "dns",
+	993:  "imap",
+	1883: "mqtt",
+	3478: "stun",
+	5353: "mdns",
+	8080: "http",
+	8883: "mqtt",
+}
+
+type namedNet struct {
+	name string
+	net  *net.IPNet
+}
+
+// dimension is a Dimension with its totals.
+type dimension struct {
+	Dimension
+	fields []func(d *dimension, m *packets.Metadata) string
+	groups []namedNet
+
+	mu       sync.Mutex
+	totals   map[string]Aggregation
+	families map[string]*[2]Aggregation // v4, v6; if MergeFamilies
+}
+
+// dimensions are the extra dimensions, in the order they were added.
+var dimensions []*dimension
+
+// AddDimension starts keeping totals along d. Call it before capturing.
+func AddDimension(d Dimension) error {
+	if findDimension(d.Name) != nil {
+		return fmt.Errorf("dimension %s is defined twice", d.Name)
+	}
+	if _, ok := historyGroups[d.Name]; ok {
+		return fmt.Errorf("dimension %s has the name of a built-in one", d.Name)
+	}
+	dim, err := newDimension(d)
+	if err != nil {
+		return err
+	}
+	dimensions = append(dimensions, dim)
+	return nil
+}
+
+// newDimension checks d, and prepares its fields and groups.
+func newDimension(d Dimension) (*dimension, error) {
+	if d.Name == "" {
+		return nil, fmt.Errorf("dimension needs a name")
+	}
+	if len(d.By) == 0 {
+		return nil, fmt.Errorf("dimension %s: by is empty", d.Name)
+	}
+	dim := &dimension{
+		Dimension: d,
+		totals:    make(map[string]Aggregation),
+	}
+	if d.MergeFamilies {
+		dim.families = make(map[string]*[2]Aggregation)
+	}
+	for _, f := range d.By {
+		fn := dimensionFields[f]
+		if fn == nil {
+			return nil, fmt.Errorf("dimension %s: unknown field %q", d.Name, f)
+		}
+		if strings.HasSuffix(f, "_port_bucket") && len(d.PortBuckets) == 0 {
+			return nil, fmt.Errorf("dimension %s: %s needs port_buckets", d.Name, f)
+		}
+		if d.MergeFamilies && f == "family" {
+			return nil, fmt.Errorf("dimension %s: merge_families can't be by family", d.Name)
+		}
+		if d.MergeFamilies && nameFields[f] {
+			name := fn
+			fn = func(d *dimension, m *packets.Metadata) string { return queriedName(name(d, m)) }
+		}
+		dim.fields = append(dim.fields, fn)
+	}
+	dim.PortBuckets = append([]int(nil), d.PortBuckets...)
+	sort.Ints(dim.PortBuckets)
+	for name, cidrs := range d.Groups {
+		for _, c := range cidrs {
+			_, n, err := net.ParseCIDR(c)
+			if err != nil {
+				return nil, fmt.Errorf("dimension %s: group %s: %v", d.Name, name, err)
+			}
+			dim.groups = append(dim.groups, namedNet{name, n})
+		}
+	}
+	// Check the most specific networks first.
+	sort.SliceStable(dim.groups, func(i, j int) bool {
+		a, _ := dim.groups[i].net.Mask.Size()
+		b, _ := dim.groups[j].net.Mask.Size()
+		return a > b
+	})
+	return dim, nil
+}
+
+// findDimension returns the named dimension, or nil.
+func findDimension(name string) *dimension {
+	for _, d := range dimensions {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// accountDimensions adds the packet to every dimension's totals.
+func accountDimensions(m *packets.Metadata) {
+	for _, d := range dimensions {
+		d.add(m)
+	}
+}
+
+// key returns the values of the dimension's fields for m, joined by "|".
+func (d *dimension) key(m *packets.Metadata) string {
+	vals := make([]string, len(d.fields))
+	for i, f := range d.fields {
+		vals[i] = f(d, m)
+	}
+	return strings.Join(vals, "|")
+}
+
+func (d *dimension) add(m *packets.Metadata) {
+	key := d.key(m)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	a, ok := d.totals[key]
+	if !ok && len(d.totals) >= maxDimensionKeys {
+		key = otherKey
+		a = d.totals[key]
+	}
+	a.Bytes += m.Size
+	a.Packets += m.Packets()
+	if !m.Timestamp.IsZero() {
+		a.LastSeen = m.Timestamp.UnixNano()
+	}
+	d.totals[key] = a
+
+	if d.families == nil {
+		return
+	}
+	f := d.families[key]
+	if f == nil {
+		f = new([2]Aggregation)
+		d.families[key] = f
+	}
+	fa := &f[0]
+	if m.V6 {
+		fa = &f[1]
+	}
+	fa.Bytes += m.Size
+	fa.Packets += m.Packets()
+	if !m.Timestamp.IsZero() {
+		fa.LastSeen = m.Timestamp.UnixNano()
+	}
+}
+
+func (d *dimension) entries() []Entry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	es := entries(d.totals)
+	if d.families == nil {
+		return es
+	}
+	for i := range es {
+		f := d.families[es[i].Key]
+		es[i].Families = map[string]Aggregation{"v4": f[0], "v6": f[1]}
+	}
+	return es
+}
+
+// portBucket returns the range of PortBuckets that p is in, e.g. "1024-49151".
+func (d *dimension) portBucket(p uint16) string {
+	b := d.PortBuckets
+	i := sort.SearchInts(b, int(p)+1) - 1
+	if i < 0 {
+		return otherKey
+	}
+	if i == len(b)-1 {
+		return fmt.Sprintf("%d-65535", b[i])
+	}
+	return fmt.Sprintf("%d-%d", b[i], b[i+1]-1)
+}
+
+// group returns the name of the group ip is in.
+func (d *dimension) group(ip net.IP) string {
+	if ip == nil {
+		return otherKey
+	}
+	for _, g := range d.groups {
+		if g.net.Contains(ip) {
+			return g.name
+		}
+	}
+	return otherKey
+}
+
+// direction classifies the packet as on the dashboard.
+func direction(m *packets.Metadata) string {
+	srcLocal, dstLocal := packets.IsLocal(m.SrcIP), packets.IsLocal(m.DstIP)
+	switch {
+	case srcLocal && dstLocal:
+		return "internal"
+	case srcLocal:
+		return "up"
+	case dstLocal:
+		return "down"
+	}
+	return "external"
+}
+
+// deviceIP returns the local address of an upstream or downstream packet, or
+// nil.
+func deviceIP(m *packets.Metadata) net.IP {
+	switch direction(m) {
+	case "up":
+		return m.SrcIP
+	case "down":
+		return m.DstIP
+	}
+	return nil
+}
+
+// deviceMAC returns the local host's MAC address for an upstream or
+// downstream packet, or "" if it isn't known.
+func deviceMAC(m *packets.Metadata) string {
+	switch direction(m) {
+	case "up":
+		return m.SrcMAC
+	case "down":
+		return m.DstMAC
+	}
+	return ""
+}
+
+// remotePort returns the port at the remote end of an upstream or downstream
+// packet, or else the lower port (usually the service).
+func remotePort(m *packets.Metadata) uint16 {
+	switch direction(m) {
+	case "up":
+		return m.DstPort
+	case "down":
+		return m.SrcPort
+	}
+	if m.SrcPort < m.DstPort {
+		return m.SrcPort
+	}
+	return m.DstPort
+}
+
+// queriedName returns the name that was looked up, from a name and the
+// CNAMEs it led to (see packets.reverseDNSMap), e.g. "www.example.com" from
+// "cdn.example.net,www.example.com".
+func queriedName(names string) string {
+	return names[strings.LastIndex(names, ",")+1:]
+}
+
+func nameOr(name string, ip net.IP) string {
+	if name != "" {
+		return name
+	}
+	return ip.String()
+}
+
+// dimensionsHandler serves /api/dimensions, the list of extra dimensions,
+// and /api/dimensions/<name>, a page of one dimension's totals (with the
+// same query parameters as /api/hosts). Each key is the values of the
+// dimension's fields, separated by "|".
+func dimensionsHandler(w http.ResponseWriter, r *http.Request) {
+	var v interface{}
+	name := strings.TrimPrefix(r.URL.Path, "/api/dimensions")
+	name = strings.Trim(name, "/")
+	if name == "" {
+		list := make([]Dimension, len(dimensions))
+		for i, d := range dimensions {
+			list[i] = d.Dimension
+		}
+		v = list
+	} else {
+		dim := findDimension(name)
+		if dim == nil {
+			http.NotFound(w, r)
+			return
+		}
+		p, err := parsePageParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v = p.apply(dim.entries())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print("dimensions failed to write:", err)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"packets"
+)
+
+func TestDimensions(t *testing.T) {
+	defer func() { dimensions = nil }()
+	tests := []struct {
+		dim  Dimension
+		want map[string]uint64 // bytes by key
+	}{
+		{
+			dim: Dimension{Name: "ports", By: []string{"dst_port_bucket"}, PortBuckets: []int{1024, 0, 49152}},
+			want: map[string]uint64{
+				"0-1023":      150,
+				"49152-65535": 1000,
+			},
+		},
+		{
+			dim: Dimension{
+				Name:   "group-app",
+				By:     []string{"device_group", "app"},
+				Groups: map[string][]string{"iot": {"192.168.1.128/25"}, "lan": {"192.168.1.0/24"}},
+			},
+			want: map[string]uint64{
+				"lan|https": 1100,
+				"iot|dns":   50,
+			},
+		},
+		{
+			dim: Dimension{Name: "networks", By: []string{"remote_asn"}},
+			want: map[string]uint64{
+				"AS15169 GOOGLE": 1100,
+				"other":          50,
+			},
+		},
+		{
+			dim: Dimension{Name: "protocols", By: []string{"protocol"}},
+			want: map[string]uint64{
+				"quic": 1100,
+				"udp":  50,
+			},
+		},
+	}
+	for _, test := range tests {
+		if err := AddDimension(test.dim); err != nil {
+			t.Fatalf("AddDimension(%v): %v", test.dim, err)
+		}
+	}
+
+	ms := []packets.Metadata{
+		{Size: 100, SrcIP: net.ParseIP("192.168.1.10"), DstIP: net.ParseIP("8.8.8.8"), SrcPort: 50000, DstPort: 443, DstASN: 15169, DstASOrg: "GOOGLE", Protocol: 17, AppProtocol: packets.AppQUIC},
+		{Size: 1000, SrcIP: net.ParseIP("8.8.8.8"), DstIP: net.ParseIP("192.168.1.10"), SrcPort: 443, DstPort: 50000, SrcASN: 15169, SrcASOrg: "GOOGLE", Protocol: 17, AppProtocol: packets.AppQUIC},
+		{Size: 50, SrcIP: net.ParseIP("192.168.1.200"), DstIP: net.ParseIP("1.1.1.1"), SrcPort: 40000, DstPort: 53, Protocol: 17},
+	}
+	for i := range ms {
+		accountDimensions(&ms[i])
+	}
+
+	for i, test := range tests {
+		got := make(map[string]uint64)
+		for _, e := range dimensions[i].entries() {
+			got[e.Key] = e.Bytes
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("dimension %s: got %v, want %v", test.dim.Name, got, test.want)
+		}
+	}
+}
+
+func TestAddDimensionErrors(t *testing.T) {
+	defer func() { dimensions = nil }()
+	tests := []Dimension{
+		{By: []string{"dst_port"}},
+		{Name: "none"},
+		{Name: "bad-field", By: []string{"colour"}},
+		{Name: "no-buckets", By: []string{"dst_port_bucket"}},
+		{Name: "bad-group", By: []string{"device_group"}, Groups: map[string][]string{"x": {"nope"}}},
+	}
+	for _, d := range tests {
+		if err := AddDimension(d); err == nil {
+			t.Errorf("AddDimension(%v): got nil error, want error", d)
+		}
+	}
+	if err := AddDimension(Dimension{Name: "twice", By: []string{"family"}}); err != nil {
+		t.Fatalf("AddDimension: %v", err)
+	}
+	if err := AddDimension(Dimension{Name: "twice", By: []string{"family"}}); err == nil {
+		t.Error("AddDimension with a duplicate name: got nil error, want error")
+	}
+}
+
+func TestPortBucket(t *testing.T) {
+	d := &dimension{Dimension: Dimension{PortBuckets: []int{1024, 49152}}}
+	var got []string
+	for _, p := range []uint16{80, 1024, 8080, 49151, 49152, 65535} {
+		got = append(got, d.portBucket(p))
+	}
+	want := []string{"other", "1024-49151", "1024-49151", "1024-49151", "49152-65535", "49152-65535"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("portBucket: got %v, want %v", got, want)
+	}
+}
+
+func TestDimensionMergeFamilies(t *testing.T) {
+	if _, err := newDimension(Dimension{Name: "bad", By: []string{"dst_name", "family"}, MergeFamilies: true}); err == nil {
+		t.Error("newDimension(by family, merging families): got nil error")
+	}
+	d, err := newDimension(Dimension{Name: "dests", By: []string{"remote_name"}, MergeFamilies: true})
+	if err != nil {
+		t.Fatalf("newDimension: %v", err)
+	}
+	for _, m := range []*packets.Metadata{
+		{Size: 100, SrcIP: net.ParseIP("192.168.1.10"), DstIP: net.ParseIP("203.0.113.5"), DstName: "edge.cdn.example.net,www.example.com"},
+		{Size: 300, SrcIP: net.ParseIP("fd00::10"), DstIP: net.ParseIP("2001:db8::5"), DstName: "www.example.com", V6: true},
+		{Size: 50, SrcIP: net.ParseIP("192.168.1.10"), DstIP: net.ParseIP("203.0.113.9")},
+	} {
+		d.add(m)
+	}
+	got := make(map[string]Entry)
+	for _, e := range d.entries() {
+		got[e.Key] = e
+	}
+	e := got["www.example.com"]
+	if e.Bytes != 400 || e.Families["v4"].Bytes != 100 || e.Families["v6"].Bytes != 300 {
+		t.Errorf("www.example.com: got %+v, want 400 bytes, 100 over v4 and 300 over v6", e)
+	}
+	if e := got["203.0.113.9"]; e.Bytes != 50 || e.Families["v4"].Bytes != 50 {
+		t.Errorf("203.0.113.9: got %+v, want 50 bytes over v4", e)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file keeps the local DNS server's statistics for each domain (how
+// often it was looked up, and blocked), next to the traffic caplog saw to
+// it.
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"packets"
+)
+
+// maxDNSDomains bounds the domains kept; queries for further domains
+// aren't counted.
+const maxDNSDomains = 10000
+
+// DNSDomain is a domain's statistics.
+type DNSDomain struct {
+	Domain  string
+	Queries uint64 // lookups in the DNS server's log
+	Blocked uint64 // of which, blocked by the server
+
+	// Traffic is the traffic caplog saw to or from hosts by that name.
+	Traffic Aggregation
+}
+
+var dnsDomains struct {
+	on int32 // accessed atomically; set once there is a query
+
+	sync.RWMutex
+	m map[string]*DNSDomain
+}
+
+// AddDNSQuery counts a query from the DNS server's log.
+func AddDNSQuery(domain string, blocked bool) {
+	dnsDomains.Lock()
+	defer dnsDomains.Unlock()
+	if dnsDomains.m == nil {
+		dnsDomains.m = make(map[string]*DNSDomain)
+	}
+	d := dnsDomains.m[domain]
+	if d == nil {
+		if len(dnsDomains.m) >= maxDNSDomains {
+			return
+		}
+		d = &DNSDomain{Domain: domain}
+		dnsDomains.m[domain] = d
+	}
+	d.Queries++
+	if blocked {
+		d.Blocked++
+	}
+	atomic.StoreInt32(&dnsDomains.on, 1)
+}
+
+// accountDNSDomains adds the packet to the traffic of the remote host's
+// domain, if the DNS server has logged it. A name can be a CNAME chain
+// ("a,b"), any of which may be the one that was looked up.
+func accountDNSDomains(m *packets.Metadata) {
+	if atomic.LoadInt32(&dnsDomains.on) == 0 {
+		return
+	}
+	var name string
+	switch direction(m) {
+	case "up":
+		name = m.DstName
+	case "down":
+		name = m.SrcName
+	default:
+		return
+	}
+	dnsDomains.RLock()
+	defer dnsDomains.RUnlock()
+	for _, n := range strings.Split(name, ",") {
+		if d := dnsDomains.m[n]; d != nil {
+			d.Traffic.Add(m.Size)
+			return
+		}
+	}
+}
+
+// DNSDomains returns the domains' statistics, most queried first.
+func DNSDomains() []DNSDomain {
+	dnsDomains.Lock()
+	defer dnsDomains.Unlock()
+	list := make([]DNSDomain, 0, len(dnsDomains.m))
+	for _, d := range dnsDomains.m {
+		c := *d
+		c.Traffic = Aggregation{
+			Bytes:   atomic.LoadUint64(&d.Traffic.Bytes),
+			Packets: atomic.LoadUint64(&d.Traffic.Packets),
+		}
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Queries != list[j].Queries {
+			return list[i].Queries > list[j].Queries
+		}
+		return list[i].Domain < list[j].Domain
+	})
+	return list
+}
+
+// dnsDomainsHandler serves DNSDomains at /api/dns/domains. It takes the
+// limit and offset parameters of /api/hosts (the order is always by
+// queries).
+func dnsDomainsHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	list := DNSDomains()
+	if p.offset > len(list) {
+		p.offset = len(list)
+	}
+	list = list[p.offset:]
+	if len(list) > p.limit {
+		list = list[:p.limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		log.Print("dns domains failed to write:", err)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"net"
+	"testing"
+
+	"packets"
+)
+
+func TestDNSDomains(t *testing.T) {
+	AddDNSQuery("example.com", false)
+	AddDNSQuery("example.com", false)
+	AddDNSQuery("ads.example.net", true)
+	AddPacket(&packets.Metadata{
+		Size:    100,
+		SrcIP:   net.ParseIP("203.0.113.9"),
+		DstIP:   net.ParseIP("192.168.1.10"),
+		SrcName: "cdn.example.com,example.com",
+	})
+
+	got := make(map[string]DNSDomain)
+	for _, d := range DNSDomains() {
+		got[d.Domain] = d
+	}
+	tests := []struct {
+		domain                 string
+		queries, blocked, size uint64
+	}{
+		{"example.com", 2, 0, 100},
+		{"ads.example.net", 1, 1, 0},
+	}
+	for _, test := range tests {
+		d := got[test.domain]
+		if d.Queries != test.queries || d.Blocked != test.blocked || d.Traffic.Bytes != test.size {
+			t.Errorf("%s: got %+v, want %d queries, %d blocked, %d bytes", test.domain, d, test.queries, test.blocked, test.size)
+		}
+	}
+}
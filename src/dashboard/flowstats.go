@@ -0,0 +1,179 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file keeps percentiles of the sizes and durations of the flows
+// recorded by the flow table (see packets.FlowTable), since means hide the
+// elephants-and-mice shape of real traffic. It also counts whether each
+// flow's remote end had a name from its first packet, only later, or never,
+// which shows how much of the traffic the sniffed names (DNS, SNI, PTR)
+// actually attribute.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"packets"
+	"sketch"
+	"vars"
+)
+
+// Flows is the flow table whose records are given to AddFlows, if any. IDS
+// alerts are matched with its flows in progress.
+var Flows *packets.FlowTable
+
+// Attribution of a flow: when its remote end was first named.
+const (
+	namedNever = iota
+	namedFirst // by the flow's first packet
+	namedLater
+)
+
+var flowStats = struct {
+	sync.Mutex
+	bytes   *sketch.TDigest
+	seconds *sketch.TDigest
+	alerted uint64 // flows that had IDS alerts
+
+	// Flows by attribution, and the delays of those named late.
+	named     [3]uint64
+	nameDelay *sketch.TDigest
+}{
+	bytes:     sketch.NewTDigest(100),
+	seconds:   sketch.NewTDigest(100),
+	nameDelay: sketch.NewTDigest(100),
+}
+
+func init() {
+	for i, name := range []string{"flows-unnamed", "flows-named-first", "flows-named-later"} {
+		i := i
+		vars.Register(name, vars.Uint64Eval(func() uint64 {
+			flowStats.Lock()
+			defer flowStats.Unlock()
+			return flowStats.named[i]
+		}).String)
+	}
+	vars.Register("flows-name-hit-rate", func() string {
+		flowStats.Lock()
+		defer flowStats.Unlock()
+		return fmt.Sprintf("%.3f", hitRate(flowStats.named))
+	})
+}
+
+// hitRate is the fraction of flows named from their first packet.
+func hitRate(named [3]uint64) float64 {
+	total := named[namedNever] + named[namedFirst] + named[namedLater]
+	if total == 0 {
+		return 0
+	}
+	return float64(named[namedFirst]) / float64(total)
+}
+
+// AddFlows adds the records of flows that are done to the statistics, and
+// sends them to /api/flows/stream.
+func AddFlows(recs []packets.Flow) {
+	publishFlows(recs)
+	flowStats.Lock()
+	defer flowStats.Unlock()
+	for i := range recs {
+		f := &recs[i]
+		flowStats.bytes.Add(float64(f.SrcBytes + f.DstBytes))
+		flowStats.seconds.Add(f.End.Sub(f.Start).Seconds())
+		if f.Alerts > 0 {
+			flowStats.alerted++
+		}
+		switch f.Named {
+		case packets.FlowNamedFirst:
+			flowStats.named[namedFirst]++
+		case packets.FlowNamedLater:
+			flowStats.named[namedLater]++
+			flowStats.nameDelay.Add(f.NameDelay.Seconds())
+		default:
+			flowStats.named[namedNever]++
+		}
+	}
+}
+
+// Percentiles summarises a distribution.
+type Percentiles struct {
+	P50, P90, P99 float64
+}
+
+// FlowStats describes the sizes and durations of finished flows.
+type FlowStats struct {
+	Completed uint64 // flow records
+	Active    int    // flows in progress, not yet counted
+	Alerted   uint64 // flow records with IDS alerts
+
+	Bytes   Percentiles // total bytes in both directions
+	Seconds Percentiles // first packet to last packet
+
+	// Flow records by when their remote end was named: from the first
+	// packet, later (after NameDelay seconds), or never.
+	NamedFirst, NamedLater, Unnamed uint64
+	NameHitRate                     float64 // NamedFirst / Completed
+	NameDelay                       Percentiles
+
+	// TCP counts retransmissions, out of order segments and duplicate
+	// ACKs, across the flows recorded.
+	TCP packets.TCPQuality
+}
+
+func percentiles(t *sketch.TDigest) Percentiles {
+	if t.Count() == 0 {
+		return Percentiles{}
+	}
+	return Percentiles{
+		P50: t.Quantile(0.5),
+		P90: t.Quantile(0.9),
+		P99: t.Quantile(0.99),
+	}
+}
+
+// FlowSizes returns the current flow statistics.
+func FlowSizes() FlowStats {
+	active := 0
+	if Flows != nil {
+		active = Flows.Len()
+	}
+	flowStats.Lock()
+	defer flowStats.Unlock()
+	return FlowStats{
+		Completed: flowStats.bytes.Count(),
+		Active:    active,
+		Alerted:   flowStats.alerted,
+		Bytes:     percentiles(flowStats.bytes),
+		Seconds:   percentiles(flowStats.seconds),
+
+		NamedFirst:  flowStats.named[namedFirst],
+		NamedLater:  flowStats.named[namedLater],
+		Unnamed:     flowStats.named[namedNever],
+		NameHitRate: hitRate(flowStats.named),
+		NameDelay:   percentiles(flowStats.nameDelay),
+
+		TCP: packets.TCPTotals(),
+	}
+}
+
+// flowStatsHandler serves FlowSizes at /api/flows/stats.
+func flowStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(FlowSizes()); err != nil {
+		log.Print("flow stats failed to write:", err)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"packets"
+	"sketch"
+)
+
+// testFlows sets Flows to a flow table whose records go to AddFlows, with
+// the statistics reset, until the returned func is called.
+func testFlows() func() {
+	old := Flows
+	Flows = &packets.FlowTable{Export: AddFlows}
+	flowStats.Lock()
+	flowStats.bytes = sketch.NewTDigest(100)
+	flowStats.seconds = sketch.NewTDigest(100)
+	flowStats.alerted = 0
+	flowStats.named = [3]uint64{}
+	flowStats.nameDelay = sketch.NewTDigest(100)
+	flowStats.Unlock()
+	return func() { Flows = old }
+}
+
+func TestFlowSizes(t *testing.T) {
+	defer testFlows()()
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	pkt := func(at time.Duration, src, dst string, sport, dport uint16, size uint64) *packets.Metadata {
+		return &packets.Metadata{
+			Timestamp: start.Add(at),
+			Size:      size,
+			SrcIP:     net.ParseIP(src),
+			DstIP:     net.ParseIP(dst),
+			SrcPort:   sport,
+			DstPort:   dport,
+			Protocol:  6,
+		}
+	}
+
+	// One flow in both directions, lasting 10s.
+	Flows.AddMetadata(pkt(0, "10.0.0.1", "192.0.2.1", 40000, 443, 100))
+	Flows.AddMetadata(pkt(5*time.Second, "192.0.2.1", "10.0.0.1", 443, 40000, 1000))
+	Flows.AddMetadata(pkt(10*time.Second, "10.0.0.1", "192.0.2.1", 40000, 443, 100))
+
+	if s := FlowSizes(); s.Completed != 0 || s.Active != 1 {
+		t.Fatalf("completed, active flows: got %d, %d, want 0, 1", s.Completed, s.Active)
+	}
+	Flows.Close()
+	s := FlowSizes()
+	if s.Completed != 1 || s.Active != 0 {
+		t.Fatalf("completed, active flows after Close: got %d, %d, want 1, 0", s.Completed, s.Active)
+	}
+	if s.Bytes.P50 != 1200 || s.Seconds.P50 != 10 {
+		t.Errorf("flow p50: got %v bytes, %v s, want 1200 bytes, 10 s", s.Bytes.P50, s.Seconds.P50)
+	}
+}
+
+func TestFlowNameAttribution(t *testing.T) {
+	defer testFlows()()
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	pkt := func(at time.Duration, src, dst string, sport uint16, named bool) *packets.Metadata {
+		m := &packets.Metadata{
+			Timestamp: start.Add(at),
+			SrcIP:     net.ParseIP(src),
+			DstIP:     net.ParseIP(dst),
+			SrcPort:   sport,
+			DstPort:   443,
+			Protocol:  6,
+		}
+		if named {
+			m.DstNameSource = packets.NameDNS
+		}
+		return m
+	}
+
+	// Named from the first packet.
+	Flows.AddMetadata(pkt(0, "10.0.0.1", "192.0.2.1", 40000, true))
+	// Named 4s in, e.g. once an SNI was seen.
+	Flows.AddMetadata(pkt(0, "10.0.0.1", "192.0.2.2", 40001, false))
+	Flows.AddMetadata(pkt(4*time.Second, "10.0.0.1", "192.0.2.2", 40001, true))
+	// Never named.
+	Flows.AddMetadata(pkt(0, "10.0.0.1", "192.0.2.3", 40002, false))
+	Flows.AddMetadata(pkt(time.Second, "10.0.0.1", "192.0.2.3", 40002, false))
+	Flows.Close()
+
+	s := FlowSizes()
+	if s.NamedFirst != 1 || s.NamedLater != 1 || s.Unnamed != 1 {
+		t.Errorf("named first, later, never: got %d, %d, %d, want 1, 1, 1", s.NamedFirst, s.NamedLater, s.Unnamed)
+	}
+	if want := 1.0 / 3; s.NameHitRate != want {
+		t.Errorf("hit rate: got %v, want %v", s.NameHitRate, want)
+	}
+	if s.NameDelay.P50 != 4 {
+		t.Errorf("name delay p50: got %v, want 4", s.NameDelay.P50)
+	}
+}
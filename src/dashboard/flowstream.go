@@ -0,0 +1,195 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file streams flow records (see packets.FlowTable) as newline-delimited
+// JSON to HTTP clients as the flows finish, for `curl | jq` pipelines and
+// lightweight integrations.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"packets"
+	"vars"
+)
+
+const flowSubBufferSize = 1000
+
+// flowFilter selects which records a subscriber receives.
+type flowFilter struct {
+	host   string // matches either IP exactly, or is a substring of either name
+	port   uint16 // matches either port
+	family string // "v4" or "v6"
+}
+
+func (f *flowFilter) match(rec *packets.Flow) bool {
+	if f.port != 0 && rec.SrcPort != f.port && rec.DstPort != f.port {
+		return false
+	}
+	switch f.family {
+	case "v4":
+		if rec.V6 {
+			return false
+		}
+	case "v6":
+		if !rec.V6 {
+			return false
+		}
+	}
+	if f.host != "" {
+		if rec.SrcIP.String() != f.host && rec.DstIP.String() != f.host &&
+			!strings.Contains(rec.SrcName, f.host) && !strings.Contains(rec.DstName, f.host) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseFlowFilter(r *http.Request) (*flowFilter, error) {
+	q := r.URL.Query()
+	f := &flowFilter{
+		host:   q.Get("host"),
+		family: q.Get("family"),
+	}
+	if s := q.Get("port"); s != "" {
+		p, err := strconv.ParseUint(s, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", s)
+		}
+		f.port = uint16(p)
+	}
+	switch f.family {
+	case "", "v4", "v6":
+	default:
+		return nil, fmt.Errorf("invalid family %q", f.family)
+	}
+	return f, nil
+}
+
+type flowSub struct {
+	filter *flowFilter
+	ch     chan packets.Flow
+}
+
+// flowSubs is the set of connected stream clients.
+var flowSubs struct {
+	mu      sync.RWMutex
+	subs    map[*flowSub]struct{}
+	n       int32  // len(subs), for a cheap check in publish
+	dropped uint64 // records not sent because a client was too slow
+}
+
+func init() {
+	flowSubs.subs = make(map[*flowSub]struct{})
+	vars.Register("flow-stream-clients", vars.IntEval(func() int { return int(atomic.LoadInt32(&flowSubs.n)) }).String)
+	vars.Uint64("flow-stream-dropped", &flowSubs.dropped)
+}
+
+// publishFlows sends the records to every interested stream client. It
+// never blocks; slow clients miss records.
+func publishFlows(recs []packets.Flow) {
+	if atomic.LoadInt32(&flowSubs.n) == 0 {
+		return
+	}
+	flowSubs.mu.RLock()
+	defer flowSubs.mu.RUnlock()
+	for s := range flowSubs.subs {
+		for i := range recs {
+			if !s.filter.match(&recs[i]) {
+				continue
+			}
+			select {
+			case s.ch <- recs[i]:
+			default:
+				atomic.AddUint64(&flowSubs.dropped, 1)
+			}
+		}
+	}
+}
+
+func subscribeFlows(f *flowFilter) *flowSub {
+	s := &flowSub{
+		filter: f,
+		ch:     make(chan packets.Flow, flowSubBufferSize),
+	}
+	flowSubs.mu.Lock()
+	flowSubs.subs[s] = struct{}{}
+	atomic.StoreInt32(&flowSubs.n, int32(len(flowSubs.subs)))
+	flowSubs.mu.Unlock()
+	return s
+}
+
+func unsubscribeFlows(s *flowSub) {
+	flowSubs.mu.Lock()
+	delete(flowSubs.subs, s)
+	atomic.StoreInt32(&flowSubs.n, int32(len(flowSubs.subs)))
+	flowSubs.mu.Unlock()
+}
+
+// flowStreamHandler writes one JSON record per line until the client goes
+// away. Query parameters host, port and family (v4 or v6) filter the records.
+func flowStreamHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := parseFlowFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	s := subscribeFlows(f)
+	defer unsubscribeFlows(s)
+
+	h := w.Header()
+	h.Add("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case m := <-s.ch:
+			if err := enc.Encode(&m); err != nil {
+				log.Print("flow stream failed to write:", err)
+				return
+			}
+			// Write whatever else is ready before flushing.
+			for more := true; more; {
+				select {
+				case m := <-s.ch:
+					if err := enc.Encode(&m); err != nil {
+						log.Print("flow stream failed to write:", err)
+						return
+					}
+				default:
+					more = false
+				}
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"packets"
+)
+
+func TestFlowStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(flowStreamHandler))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/api/flows/stream?port=443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("Content-Type: got %q, want application/x-ndjson", got)
+	}
+	if n := atomic.LoadInt32(&flowSubs.n); n != 1 {
+		t.Fatalf("stream clients: got %d, want 1", n)
+	}
+
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	client, server := net.ParseIP("10.0.0.2"), net.ParseIP("192.0.2.80")
+	AddFlows([]packets.Flow{
+		{Start: start, End: start, SrcIP: client, DstIP: server, SrcPort: 5353, DstPort: 53, Protocol: 17, SrcPackets: 1, SrcBytes: 80, Reason: packets.FlowIdle},
+		{Start: start, End: start.Add(time.Second), SrcIP: client, DstIP: server, SrcPort: 50000, DstPort: 443, Protocol: 6, SrcPackets: 4, SrcBytes: 680, DstPackets: 2, DstBytes: 120, Reason: packets.FlowFIN},
+	})
+
+	line, err := bufio.NewReader(resp.Body).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got packets.Flow
+	if err := json.Unmarshal(line, &got); err != nil {
+		t.Fatalf("record %q: %v", line, err)
+	}
+	if got.DstPort != 443 || got.SrcPackets != 4 || got.Reason != packets.FlowFIN {
+		t.Errorf("first record: got %+v, want the flow to port 443", got)
+	}
+}
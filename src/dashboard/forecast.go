@@ -0,0 +1,201 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file forecasts each device's and the overall data use at the end of
+// the billing period, for those on capped plans.
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"history"
+)
+
+// usageRollup is the built-in rollup the forecasts are made from.
+const usageRollup = "usage-daily"
+
+// DataCap is the data allowance per billing period in bytes, or 0 if none.
+var DataCap uint64
+
+// EnableForecast starts keeping daily usage per device, for forecasting.
+// Call it before capturing, after setting RollupDir.
+func EnableForecast() error {
+	return AddRollup(Rollup{
+		Dimension: Dimension{Name: usageRollup, By: []string{"device"}},
+		Interval:  24 * time.Hour,
+		Keep:      62,
+	})
+}
+
+// Forecast is the data used so far in a billing period, and the forecast for
+// the whole period, in bytes.
+type Forecast struct {
+	Used, Forecast uint64
+}
+
+// ForecastReport forecasts the current billing period's use.
+type ForecastReport struct {
+	Start, End time.Time
+
+	// Method is "seasonal" if the forecast is by day of the week (once
+	// there are two weeks of history), else "linear".
+	Method string
+
+	Total   Forecast
+	Cap     uint64 `json:",omitempty"`
+	OverCap bool   `json:",omitempty"` // Total.Forecast > Cap
+	Devices map[string]Forecast
+}
+
+// forecast projects daily totals (per key, oldest first, the last being the
+// current day so far) to the end of the period. The days before start are
+// only history to learn from.
+func forecast(days []history.Period, start, end, now time.Time) ForecastReport {
+	r := ForecastReport{
+		Start:   start,
+		End:     end,
+		Method:  "linear",
+		Devices: make(map[string]Forecast),
+	}
+	if len(days) == 0 {
+		return r
+	}
+
+	// Learn the average day, and the average of each weekday, from complete
+	// days.
+	var (
+		complete     int
+		perDay       = make(map[string]float64)
+		perWeekday   [7]map[string]float64
+		weekdayCount [7]int
+		usedByKey    = make(map[string]uint64)
+	)
+	for i := range perWeekday {
+		perWeekday[i] = make(map[string]float64)
+	}
+	today := days[len(days)-1]
+	for _, d := range days[:len(days)-1] {
+		complete++
+		wd := d.Start.Weekday()
+		weekdayCount[wd]++
+		for k, p := range d.Totals {
+			perDay[k] += float64(p.Bytes)
+			perWeekday[wd][k] += float64(p.Bytes)
+		}
+	}
+	for _, d := range days {
+		if d.Start.Before(start) {
+			continue
+		}
+		for k, p := range d.Totals {
+			usedByKey[k] += p.Bytes
+		}
+	}
+	seasonal := complete >= 14
+	if seasonal {
+		r.Method = "seasonal"
+	}
+
+	// rate returns the expected bytes for key on a day.
+	rate := func(key string, day time.Time) float64 {
+		if complete == 0 {
+			// Extrapolate today.
+			elapsed := now.Sub(today.Start)
+			if elapsed <= 0 {
+				return 0
+			}
+			return float64(today.Totals[key].Bytes) * float64(24*time.Hour) / float64(elapsed)
+		}
+		if wd := day.Weekday(); seasonal && weekdayCount[wd] > 0 {
+			return perWeekday[wd][key] / float64(weekdayCount[wd])
+		}
+		return perDay[key] / float64(complete)
+	}
+
+	keys := make(map[string]bool)
+	for _, d := range days {
+		for k := range d.Totals {
+			keys[k] = true
+		}
+	}
+	for k := range keys {
+		// The rest of today, then each remaining day.
+		dayEnd := today.Start.AddDate(0, 0, 1)
+		left := float64(dayEnd.Sub(now)) / float64(24*time.Hour)
+		if left < 0 {
+			left = 0
+		}
+		expect := rate(k, today.Start) * left
+		for d := dayEnd; d.Before(end); d = d.AddDate(0, 0, 1) {
+			expect += rate(k, d)
+		}
+		f := Forecast{Used: usedByKey[k], Forecast: usedByKey[k] + uint64(expect)}
+		r.Devices[k] = f
+		r.Total.Used += f.Used
+		r.Total.Forecast += f.Forecast
+	}
+	return r
+}
+
+// Forecasts returns the forecast for the current billing period.
+func Forecasts(now time.Time) ForecastReport {
+	start, end := billingPeriod(now)
+	var days []history.Period
+	if ru := findRollup(usageRollup); ru != nil {
+		days = ru.r.Periods(now.AddDate(0, 0, -62), now)
+		// The last day must be today, even if it has no traffic yet.
+		today := ru.r.PeriodStart(now)
+		if n := len(days); n == 0 || !days[n-1].Start.Equal(today) {
+			days = append(days, history.Period{Start: today, Totals: map[string]history.Point{}})
+		}
+	}
+	r := forecast(days, start, end, now)
+	if DataCap > 0 {
+		r.Cap = DataCap
+		r.OverCap = r.Total.Forecast > DataCap
+	}
+	return r
+}
+
+// forecastHandler serves /api/forecast.
+func forecastHandler(w http.ResponseWriter, r *http.Request) {
+	rep := Forecasts(time.Now())
+	type device struct {
+		Device string
+		Forecast
+	}
+	ds := make([]device, 0, len(rep.Devices))
+	for k, f := range rep.Devices {
+		ds = append(ds, device{k, f})
+	}
+	sort.Slice(ds, func(i, j int) bool {
+		if ds[i].Forecast.Forecast != ds[j].Forecast.Forecast {
+			return ds[i].Forecast.Forecast > ds[j].Forecast.Forecast
+		}
+		return ds[i].Device < ds[j].Device
+	})
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		ForecastReport
+		Devices []device
+	}{rep, ds})
+	if err != nil {
+		log.Print("forecast failed to write:", err)
+	}
+}
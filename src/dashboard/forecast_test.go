@@ -0,0 +1,67 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"testing"
+	"time"
+
+	"history"
+)
+
+func TestForecast(t *testing.T) {
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	day := func(n int, bytes uint64) history.Period {
+		return history.Period{
+			Start:  start.AddDate(0, 0, n),
+			Totals: map[string]history.Point{"10.0.0.2": {Bytes: bytes, Packets: 1}},
+		}
+	}
+
+	// Half way through the first day: extrapolate it.
+	r := forecast([]history.Period{day(0, 100)}, start, end, start.Add(12*time.Hour))
+	if got, want := r.Total, (Forecast{Used: 100, Forecast: 100 + 100 + 29*200}); got != want || r.Method != "linear" {
+		t.Errorf("first day: got %+v (%s), want %+v (linear)", got, r.Method, want)
+	}
+
+	// Ten days at 1000 a day, the eleventh not started: 20 more days.
+	var days []history.Period
+	for i := 0; i < 10; i++ {
+		days = append(days, day(i, 1000))
+	}
+	days = append(days, day(10, 0))
+	r = forecast(days, start, end, start.AddDate(0, 0, 10))
+	if got, want := r.Devices["10.0.0.2"], (Forecast{Used: 10000, Forecast: 30000}); got != want {
+		t.Errorf("after ten days: got %+v, want %+v", got, want)
+	}
+
+	// Three weeks before the period, busy on Sundays only; the period starts
+	// on a Monday with nothing used yet.
+	days = nil
+	for i := -21; i < 0; i++ {
+		var b uint64
+		if start.AddDate(0, 0, i).Weekday() == time.Sunday {
+			b = 7000
+		}
+		days = append(days, day(i, b))
+	}
+	days = append(days, day(0, 0))
+	r = forecast(days, start, end, start)
+	// June 2015 has four Sundays.
+	if got, want := r.Total, (Forecast{Used: 0, Forecast: 4 * 7000}); got != want || r.Method != "seasonal" {
+		t.Errorf("seasonal: got %+v (%s), want %+v (seasonal)", got, r.Method, want)
+	}
+}
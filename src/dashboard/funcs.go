@@ -0,0 +1,139 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file has the functions available to dashboard and panel templates.
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"time"
+
+	"packets"
+)
+
+var siPrefixes = []string{"", "k", "M", "G", "T", "P", "E"}
+
+// toFloat converts any numeric value to a float64.
+func toFloat(v interface{}) (float64, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	}
+	return 0, fmt.Errorf("not a number: %v (%T)", v, v)
+}
+
+// formatBytes formats a byte count with an SI prefix, e.g. "12.3 MB".
+func formatBytes(v interface{}) (string, error) {
+	n, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+	i := 0
+	for n >= 1000 && i < len(siPrefixes)-1 {
+		n /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f B", n), nil
+	}
+	return fmt.Sprintf("%.1f %sB", n, siPrefixes[i]), nil
+}
+
+// formatDuration formats a duration (or a number of nanoseconds) rounded to
+// something readable.
+func formatDuration(v interface{}) (string, error) {
+	var d time.Duration
+	if dd, ok := v.(time.Duration); ok {
+		d = dd
+	} else {
+		n, err := toFloat(v)
+		if err != nil {
+			return "", err
+		}
+		d = time.Duration(n)
+	}
+	switch {
+	case d >= time.Hour:
+		d = d.Round(time.Minute)
+	case d >= time.Second:
+		d = d.Round(100 * time.Millisecond)
+	}
+	return d.String(), nil
+}
+
+// percent formats a as a percentage of b.
+func percent(a, b interface{}) (string, error) {
+	x, err := toFloat(a)
+	if err != nil {
+		return "", err
+	}
+	y, err := toFloat(b)
+	if err != nil {
+		return "", err
+	}
+	if y == 0 {
+		return "-", nil
+	}
+	return fmt.Sprintf("%.1f%%", 100*x/y), nil
+}
+
+// sortAggs sorts a map of aggregations into entries, descending by field
+// ("bytes", "packets", "last-seen" or "key").
+func sortAggs(field string, m map[string]Aggregation) ([]Entry, error) {
+	p := pageParams{
+		limit:  len(m),
+		sortBy: field,
+		desc:   field != "key",
+	}
+	switch field {
+	case "bytes", "packets", "last-seen", "key":
+	default:
+		return nil, fmt.Errorf("invalid sort field %q", field)
+	}
+	return p.apply(entries(m)).Entries, nil
+}
+
+// top returns at most the first n entries.
+func top(n int, es []Entry) []Entry {
+	if n < len(es) {
+		return es[:n]
+	}
+	return es
+}
+
+// templateFuncs returns the function library for templates, with messages
+// translated to lang.
+func templateFuncs(lang string) template.FuncMap {
+	return template.FuncMap{
+		"T":        func(msg string) string { return translate(lang, msg) },
+		"lang":     func() string { return lang },
+		"bytes":    formatBytes,
+		"duration": formatDuration,
+		"percent":  percent,
+		"sort":     sortAggs,
+		"top":      top,
+		"maps":     Maps,
+		"devices":  func() []deviceTotals { return withTotals(packets.Devices()) },
+		"vendor":   packets.Vendor,
+		"tcp":      packets.TCPTotals,
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{in: uint64(0), want: "0 B"},
+		{in: 999, want: "999 B"},
+		{in: uint64(1234), want: "1.2 kB"},
+		{in: int64(5e9), want: "5.0 GB"},
+	}
+	for _, test := range tests {
+		got, err := formatBytes(test.in)
+		if err != nil {
+			t.Errorf("formatBytes(%v): %v", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("formatBytes(%v): got %q, want %q", test.in, got, test.want)
+		}
+	}
+	if _, err := formatBytes("lots"); err == nil {
+		t.Error("formatBytes(\"lots\"): got nil error, want error")
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	if got, want := mustString(formatDuration(90*time.Minute+10*time.Second)), "1h30m0s"; got != want {
+		t.Errorf("formatDuration(1h30m10s): got %q, want %q", got, want)
+	}
+	if got, want := mustString(formatDuration(int64(1500*time.Millisecond))), "1.5s"; got != want {
+		t.Errorf("formatDuration(1.5e9): got %q, want %q", got, want)
+	}
+}
+
+func TestPercent(t *testing.T) {
+	if got, want := mustString(percent(uint64(1), uint64(8))), "12.5%"; got != want {
+		t.Errorf("percent(1, 8): got %q, want %q", got, want)
+	}
+	if got, want := mustString(percent(1, 0)), "-"; got != want {
+		t.Errorf("percent(1, 0): got %q, want %q", got, want)
+	}
+}
+
+func mustString(s string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
@@ -0,0 +1,185 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file keeps per-host totals, either exactly (a map entry per host) or,
+// for very high packet rates, approximately with heavy-hitter sketches.
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"packets"
+	"sketch"
+)
+
+// Host statistics modes.
+const (
+	HostsExact  = "exact"
+	HostsSketch = "sketch"
+)
+
+var (
+	// HostMode selects how per-host totals are kept: HostsExact, or
+	// HostsSketch to use bounded memory and only know the biggest hosts.
+	// Set it before capturing.
+	HostMode = HostsExact
+
+	// HeavyHitters is how many hosts each sketch keeps in HostsSketch mode.
+	HeavyHitters = 100
+
+	hostSampling    int64  = 1 // see SetHostSampling; accessed atomically
+	hostSampleCount uint64     // packets seen for sampling, accessed atomically
+
+	sketchOnce sync.Once
+	sketchMu   sync.Mutex // guards sketches
+	sketches   map[string]*sketch.HeavyHitters
+)
+
+// SetHostSampling makes per-host totals count only 1 in n packets (scaled
+// up), to save CPU. It can be changed at any time.
+func SetHostSampling(n int) {
+	if n < 1 {
+		n = 1
+	}
+	atomic.StoreInt64(&hostSampling, int64(n))
+}
+
+// hostKeys maps the by and dir query parameters of /api/hosts to a key of
+// sketches.
+var hostKeys = map[string]string{
+	"/":         "ip/up",
+	"ip/":       "ip/up",
+	"/up":       "ip/up",
+	"ip/up":     "ip/up",
+	"/down":     "ip/down",
+	"ip/down":   "ip/down",
+	"name/":     "name/up",
+	"name/up":   "name/up",
+	"name/down": "name/down",
+	"mac/":      "mac/up",
+	"mac/up":    "mac/up",
+	"mac/down":  "mac/down",
+}
+
+// accountHosts adds the packet to the local host's totals: the source for
+// upstream packets, the destination for downstream ones. They are kept by
+// MAC address too, if it is known.
+func accountHosts(m *packets.Metadata) {
+	srcLocal, dstLocal := packets.IsLocal(m.SrcIP), packets.IsLocal(m.DstIP)
+	var dir, ip, name, mac string
+	switch {
+	case srcLocal && !dstLocal:
+		dir, ip, name, mac = "up", m.SrcIP.String(), m.SrcName, m.SrcMAC
+	case dstLocal && !srcLocal:
+		dir, ip, name, mac = "down", m.DstIP.String(), m.DstName, m.DstMAC
+	default:
+		return
+	}
+	if name == "" {
+		name = ip
+	}
+	size, count := m.Size, m.Packets()
+	if n := uint64(atomic.LoadInt64(&hostSampling)); n > 1 {
+		if atomic.AddUint64(&hostSampleCount, 1)%n != 0 {
+			return
+		}
+		size, count = size*n, count*n
+	}
+
+	if HostMode == HostsSketch {
+		sketchOnce.Do(func() {
+			sketches = make(map[string]*sketch.HeavyHitters)
+			for _, k := range hostKeys {
+				if sketches[k] == nil {
+					sketches[k] = sketch.NewHeavyHitters(HeavyHitters)
+				}
+			}
+		})
+		sketchMu.Lock()
+		defer sketchMu.Unlock()
+		sketches["ip/"+dir].Add(ip, size)
+		sketches["name/"+dir].Add(name, size)
+		if mac != "" {
+			sketches["mac/"+dir].Add(mac, size)
+		}
+		return
+	}
+
+	byIP, byName, byMAC := maps.upByIP, maps.upByName, maps.upByMAC
+	if dir == "down" {
+		byIP, byName, byMAC = maps.downByIP, maps.downByName, maps.downByMAC
+	}
+	byIP.add(ip, size, count, m.Timestamp)
+	byName.add(name, size, count, m.Timestamp)
+	if mac != "" {
+		byMAC.add(mac, size, count, m.Timestamp)
+	}
+}
+
+// hostEntries returns the per-host totals for the by and dir parameters, or
+// false if they are invalid. In HostsSketch mode only the heavy hitters are
+// known, and only by bytes.
+func hostEntries(by, dir string) ([]Entry, bool) {
+	key, ok := hostKeys[by+"/"+dir]
+	if !ok {
+		return nil, false
+	}
+	if HostMode == HostsSketch {
+		sketchMu.Lock()
+		defer sketchMu.Unlock()
+		hh := sketches[key]
+		if hh == nil {
+			return []Entry{}, true
+		}
+		top := hh.Top()
+		es := make([]Entry, len(top))
+		for i, it := range top {
+			es[i] = Entry{Key: it.Key, Aggregation: Aggregation{Bytes: it.Count}}
+		}
+		return es, true
+	}
+
+	switch key {
+	case "ip/up":
+		return entries(maps.upByIP.snapshot()), true
+	case "ip/down":
+		return entries(maps.downByIP.snapshot()), true
+	case "name/up":
+		return entries(maps.upByName.snapshot()), true
+	case "mac/up":
+		return entries(maps.upByMAC.snapshot()), true
+	case "mac/down":
+		return entries(maps.downByMAC.snapshot()), true
+	default:
+		return entries(maps.downByName.snapshot()), true
+	}
+}
+
+// HostBytes returns the bytes sent (up) and received (down) by each local
+// host, by IP. In HostsSketch mode only the heavy hitters are included.
+func HostBytes() (up, down map[string]uint64) {
+	up, down = make(map[string]uint64), make(map[string]uint64)
+	for _, d := range []struct {
+		dir string
+		m   map[string]uint64
+	}{{"up", up}, {"down", down}} {
+		es, _ := hostEntries("ip", d.dir)
+		for _, e := range es {
+			d.m[e.Key] = e.Bytes
+		}
+	}
+	return up, down
+}
@@ -0,0 +1,78 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"net"
+	"testing"
+
+	"packets"
+)
+
+func TestHostEntriesSketch(t *testing.T) {
+	defer func(mode string) { HostMode = mode }(HostMode)
+	HostMode = HostsSketch
+
+	m := &packets.Metadata{Size: 500, SrcIP: net.ParseIP("192.168.7.7"), DstIP: net.ParseIP("8.8.4.4"), SrcName: "laptop"}
+	accountHosts(m)
+	accountHosts(m)
+
+	es, ok := hostEntries("name", "up")
+	if !ok {
+		t.Fatal("hostEntries(name, up): not ok")
+	}
+	found := false
+	for _, e := range es {
+		if e.Key == "laptop" {
+			found = true
+			if e.Bytes < 1000 {
+				t.Errorf("laptop bytes: got %d, want >= 1000", e.Bytes)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("hostEntries(name, up) = %v, missing laptop", es)
+	}
+	if _, ok := hostEntries("vendor", "up"); ok {
+		t.Error("hostEntries(vendor, up): got ok, want not ok")
+	}
+}
+
+func TestHostEntriesByMAC(t *testing.T) {
+	const mac = "00:11:22:33:44:66"
+	// The device is given a new address part way through.
+	for _, ip := range []string{"192.168.7.8", "192.168.7.9"} {
+		accountHosts(&packets.Metadata{Size: 100, SrcIP: net.ParseIP(ip), DstIP: net.ParseIP("8.8.4.4"), SrcMAC: mac})
+		accountHosts(&packets.Metadata{Size: 300, SrcIP: net.ParseIP("8.8.4.4"), DstIP: net.ParseIP(ip), DstMAC: mac})
+	}
+	for dir, want := range map[string]uint64{"up": 200, "down": 600} {
+		es, ok := hostEntries("mac", dir)
+		if !ok {
+			t.Fatalf("hostEntries(mac, %s): not ok", dir)
+		}
+		var got Aggregation
+		for _, e := range es {
+			if e.Key == mac {
+				got = e.Aggregation
+			}
+		}
+		if got.Bytes != want || got.Packets != 2 {
+			t.Errorf("hostEntries(mac, %s) for %s: got %+v, want %d bytes in 2 packets", dir, mac, got, want)
+		}
+	}
+	if a, ok := maps.upByMAC.get(mac); !ok || a.Bytes != 200 {
+		t.Errorf("upByMAC.get(%s) = %+v, %v; want 200 bytes", mac, a, ok)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file localizes dashboard strings. Messages are keyed by their English
+// text, so untranslated strings fall back to English.
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultLanguage = "en"
+
+// Language forces the dashboard language. If empty, the language is chosen
+// from the request's Accept-Language header.
+var Language string
+
+var catalogs = map[string]map[string]string{
+	"en": {},
+	"ja": {
+		"dashboard":           "ダッシュボード",
+		"An error occurred!":  "エラーが発生しました！",
+		"Packets":             "パケット",
+		"Bytes":               "バイト",
+		"Packets/sec":         "パケット/秒",
+		"Bytes/sec":           "バイト/秒",
+		"Total":               "合計",
+		"Up":                  "上り",
+		"Down":                "下り",
+		"Internal":            "内部",
+		"External":            "外部",
+		"Time":                "時刻",
+		"Packets/sec up":      "上りパケット/秒",
+		"Packets/sec down":    "下りパケット/秒",
+		"Packets/sec int.":    "内部パケット/秒",
+		"Bytes/sec up":        "上りバイト/秒",
+		"Bytes/sec down":      "下りバイト/秒",
+		"Bytes/sec int.":      "内部バイト/秒",
+		"Packets, bytes":      "パケット、バイト",
+		"Protocol":            "プロトコル",
+		"Packets by protocol": "プロトコル別パケット",
+		"Bytes by protocol":   "プロトコル別バイト",
+		"Src":                 "送信元",
+		"Dst":                 "宛先",
+		"Interface":           "インターフェース",
+		"All interfaces":      "全インターフェース",
+		"TCP quality":         "TCP品質",
+		"Retransmissions":     "再送",
+		"Out of order":        "順序違い",
+		"Duplicate ACKs":      "重複ACK",
+		"Some packets were coalesced by the network card, so packet counts may be low.": "一部のパケットがネットワークカードで結合されたため、パケット数が少なく表示される場合があります。",
+	},
+	"de": {
+		"dashboard":           "Übersicht",
+		"An error occurred!":  "Ein Fehler ist aufgetreten!",
+		"Packets":             "Pakete",
+		"Bytes":               "Bytes",
+		"Packets/sec":         "Pakete/s",
+		"Bytes/sec":           "Bytes/s",
+		"Total":               "Gesamt",
+		"Up":                  "Ausgehend",
+		"Down":                "Eingehend",
+		"Internal":            "Intern",
+		"External":            "Extern",
+		"Time":                "Zeit",
+		"Packets/sec up":      "Pakete/s ausgehend",
+		"Packets/sec down":    "Pakete/s eingehend",
+		"Packets/sec int.":    "Pakete/s intern",
+		"Bytes/sec up":        "Bytes/s ausgehend",
+		"Bytes/sec down":      "Bytes/s eingehend",
+		"Bytes/sec int.":      "Bytes/s intern",
+		"Packets, bytes":      "Pakete, Bytes",
+		"Protocol":            "Protokoll",
+		"Packets by protocol": "Pakete nach Protokoll",
+		"Bytes by protocol":   "Bytes nach Protokoll",
+		"Src":                 "Quelle",
+		"Dst":                 "Ziel",
+		"Interface":           "Schnittstelle",
+		"All interfaces":      "Alle Schnittstellen",
+		"TCP quality":         "TCP-Qualität",
+		"Retransmissions":     "Wiederholungen",
+		"Out of order":        "Außer der Reihe",
+		"Duplicate ACKs":      "Doppelte ACKs",
+		"Some packets were coalesced by the network card, so packet counts may be low.": "Einige Pakete wurden von der Netzwerkkarte zusammengefasst, daher können die Paketzahlen zu niedrig sein.",
+	},
+}
+
+// translate returns the message in the language, or the message itself if
+// there is no translation.
+func translate(lang, msg string) string {
+	if t, ok := catalogs[lang][msg]; ok {
+		return t
+	}
+	return msg
+}
+
+// requestLanguage picks a supported language for the request, preferring
+// Language if set, then the Accept-Language header, then English.
+func requestLanguage(r *http.Request) string {
+	if _, ok := catalogs[Language]; ok {
+		return Language
+	}
+	return parseAcceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// parseAcceptLanguage returns the supported language with the highest
+// quality in an Accept-Language header (e.g. "de-CH,de;q=0.9,en;q=0.8").
+func parseAcceptLanguage(header string) string {
+	type pref struct {
+		lang string
+		q    float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		// Only the primary subtag matters for the catalogs we have.
+		if i := strings.Index(tag, "-"); i >= 0 {
+			tag = tag[:i]
+		}
+		prefs = append(prefs, pref{tag, q})
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	for _, p := range prefs {
+		if _, ok := catalogs[p.lang]; ok && p.q > 0 {
+			return p.lang
+		}
+	}
+	return defaultLanguage
+}
@@ -0,0 +1,48 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import "testing"
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{header: "", want: "en"},
+		{header: "ja", want: "ja"},
+		{header: "de-CH,de;q=0.9,en;q=0.8", want: "de"},
+		{header: "fr-FR,fr;q=0.9,en;q=0.5,ja;q=0.7", want: "ja"},
+		{header: "fr", want: "en"},
+		{header: "ja;q=0,de", want: "de"},
+	}
+	for _, test := range tests {
+		if got := parseAcceptLanguage(test.header); got != test.want {
+			t.Errorf("parseAcceptLanguage(%q): got %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestCatalogsComplete(t *testing.T) {
+	for lang, cat := range catalogs {
+		if lang == defaultLanguage {
+			continue
+		}
+		for msg := range catalogs["ja"] {
+			if _, ok := cat[msg]; !ok {
+				t.Errorf("catalog %q is missing %q", lang, msg)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file correlates IDS (Suricata) alerts with the flows caplog sees, so
+// the alerts can be shown alongside how much traffic they involved.
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"packets"
+	"suricata"
+)
+
+// maxAlerts is how many recent alerts are kept.
+const maxAlerts = 100
+
+// IDSAlert is an alert, with the traffic caplog has seen on its flow.
+type IDSAlert struct {
+	suricata.Alert
+
+	// Tracked is whether caplog was tracking the flow (it isn't when the
+	// flow table is full, for example). If so, Bytes, First and Last
+	// describe the flow: while it is active they keep growing.
+	Tracked     bool
+	Bytes       uint64 `json:",omitempty"`
+	First, Last time.Time
+}
+
+// alertEntry is an alert and its flow, as it was when the alert came, if
+// it was tracked.
+type alertEntry struct {
+	alert   suricata.Alert
+	flow    packets.Flow
+	tracked bool
+}
+
+var alerts struct {
+	sync.Mutex
+	recent []alertEntry
+}
+
+// alertProto returns the IP protocol number of an EVE proto, or 0 if it
+// isn't one flows are kept for by port.
+func alertProto(proto string) uint8 {
+	switch strings.ToUpper(proto) {
+	case "TCP":
+		return 6
+	case "UDP":
+		return 17
+	case "ICMP":
+		return 1
+	case "IPV6-ICMP":
+		return 58
+	}
+	return 0
+}
+
+// AddAlert records an IDS alert, attaching it to its flow in Flows. It
+// reports whether the flow was found.
+func AddAlert(a suricata.Alert) bool {
+	var (
+		f       packets.Flow
+		tracked bool
+	)
+	if Flows != nil {
+		f, tracked = Flows.Alert(a.SrcIP, a.DstIP, a.SrcPort, a.DstPort, alertProto(a.Proto))
+	}
+
+	alerts.Lock()
+	defer alerts.Unlock()
+	alerts.recent = append(alerts.recent, alertEntry{a, f, tracked})
+	if len(alerts.recent) > maxAlerts {
+		alerts.recent = alerts.recent[len(alerts.recent)-maxAlerts:]
+	}
+	return tracked
+}
+
+// Alerts returns the recent IDS alerts, newest first.
+func Alerts() []IDSAlert {
+	alerts.Lock()
+	entries := append([]alertEntry(nil), alerts.recent...)
+	alerts.Unlock()
+
+	list := make([]IDSAlert, len(entries))
+	for i, e := range entries {
+		a := IDSAlert{Alert: e.alert, Tracked: e.tracked}
+		if e.tracked {
+			f := e.flow
+			// While the flow goes on, it is as it is now.
+			if now, ok := Flows.Lookup(f.SrcIP, f.DstIP, f.SrcPort, f.DstPort, f.Protocol); ok && now.Start.Equal(f.Start) {
+				f = now
+			}
+			a.Bytes, a.First, a.Last = f.SrcBytes+f.DstBytes, f.Start, f.End
+		}
+		list[len(entries)-1-i] = a
+	}
+	return list
+}
+
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(Alerts()); err != nil {
+		log.Print("alerts failed to write:", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"packets"
+	"suricata"
+)
+
+func TestAddAlert(t *testing.T) {
+	defer testFlows()()
+	at := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := &packets.Metadata{
+		Timestamp: at,
+		Size:      1000,
+		SrcIP:     net.ParseIP("203.0.113.9"),
+		DstIP:     net.ParseIP("192.168.1.10"),
+		SrcPort:   80,
+		DstPort:   50000,
+		Protocol:  6,
+	}
+	Flows.AddMetadata(m)
+
+	a := suricata.Alert{
+		Time:      at,
+		SrcIP:     net.ParseIP("192.168.1.10"),
+		DstIP:     net.ParseIP("203.0.113.9"),
+		SrcPort:   50000,
+		DstPort:   80,
+		Proto:     "TCP",
+		Signature: "test",
+	}
+	if !AddAlert(a) {
+		t.Error("AddAlert: flow not found")
+	}
+	// More traffic on the flow after the alert is seen too.
+	m.Timestamp = at.Add(time.Second)
+	Flows.AddMetadata(m)
+
+	got := Alerts()[0]
+	if !got.Tracked || got.Bytes != 2000 || !got.Last.Equal(m.Timestamp) {
+		t.Errorf("Alerts()[0]: got %+v, want 2000 bytes tracked until %v", got, m.Timestamp)
+	}
+
+	a.SrcPort = 1
+	if AddAlert(a) {
+		t.Error("AddAlert(unknown flow): got true")
+	}
+	if got := Alerts()[0]; got.Tracked {
+		t.Errorf("Alerts()[0]: got %+v, want untracked", got)
+	}
+
+	Flows.Close()
+	if s := FlowSizes(); s.Alerted != 1 {
+		t.Errorf("FlowSizes().Alerted: got %d, want 1", s.Alerted)
+	}
+}
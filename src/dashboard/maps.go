@@ -0,0 +1,210 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file implements the per-host and per-pair maps. Every processor adds
+// to them for every packet, so they are sharded, and existing entries are
+// updated atomically under a read lock.
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"packets"
+	"vars"
+)
+
+const (
+	// mapShards is the number of shards in each aggMap.
+	mapShards = 32
+
+	// maxPairs bounds each of the src-dst maps. Pairs beyond this aren't
+	// counted.
+	maxPairs = 100000
+
+	// pairSep separates the src and dst in the keys of the pair maps.
+	pairSep = "\x00"
+)
+
+var mapOverflow uint64 // packets not counted in a full map, accessed atomically
+
+func init() {
+	vars.Uint64("dashboard-map-overflow", &mapOverflow)
+}
+
+type aggShard struct {
+	sync.RWMutex
+	m map[string]*Aggregation
+}
+
+// aggMap is a concurrent map of aggregations.
+type aggMap struct {
+	max    int // if positive, the most keys to keep
+	shards [mapShards]aggShard
+}
+
+func newAggMap(max int) *aggMap {
+	a := &aggMap{max: max}
+	for i := range a.shards {
+		a.shards[i].m = make(map[string]*Aggregation)
+	}
+	return a
+}
+
+// shard returns the shard for a key (by FNV-1a, without allocating).
+func (a *aggMap) shard(key string) *aggShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &a.shards[h%mapShards]
+}
+
+// add adds count packets of size bytes in total to the key's aggregation,
+// seen at t (if not zero).
+func (a *aggMap) add(key string, size, count uint64, t time.Time) {
+	s := a.shard(key)
+	s.RLock()
+	agg := s.m[key]
+	s.RUnlock()
+	if agg == nil {
+		s.Lock()
+		if agg = s.m[key]; agg == nil {
+			if a.max > 0 && len(s.m) >= a.max/mapShards {
+				s.Unlock()
+				atomic.AddUint64(&mapOverflow, 1)
+				return
+			}
+			agg = new(Aggregation)
+			s.m[key] = agg
+		}
+		s.Unlock()
+	}
+	atomic.AddUint64(&agg.Bytes, size)
+	atomic.AddUint64(&agg.Packets, count)
+	if !t.IsZero() {
+		atomic.StoreInt64(&agg.LastSeen, t.UnixNano())
+	}
+}
+
+// snapshot copies the map.
+func (a *aggMap) snapshot() map[string]Aggregation {
+	m := make(map[string]Aggregation)
+	for i := range a.shards {
+		s := &a.shards[i]
+		s.RLock()
+		for k, agg := range s.m {
+			m[k] = Aggregation{
+				Bytes:    atomic.LoadUint64(&agg.Bytes),
+				Packets:  atomic.LoadUint64(&agg.Packets),
+				LastSeen: atomic.LoadInt64(&agg.LastSeen),
+			}
+		}
+		s.RUnlock()
+	}
+	return m
+}
+
+// get returns a copy of the key's aggregation, if it has one.
+func (a *aggMap) get(key string) (Aggregation, bool) {
+	s := a.shard(key)
+	s.RLock()
+	defer s.RUnlock()
+	agg := s.m[key]
+	if agg == nil {
+		return Aggregation{}, false
+	}
+	return Aggregation{
+		Bytes:    atomic.LoadUint64(&agg.Bytes),
+		Packets:  atomic.LoadUint64(&agg.Packets),
+		LastSeen: atomic.LoadInt64(&agg.LastSeen),
+	}, true
+}
+
+// pairSnapshot copies a pair map into a map from src to dst.
+func (a *aggMap) pairSnapshot() map[string]map[string]Aggregation {
+	m := make(map[string]map[string]Aggregation)
+	for k, agg := range a.snapshot() {
+		i := strings.Index(k, pairSep)
+		src, dst := k[:i], k[i+len(pairSep):]
+		if m[src] == nil {
+			m[src] = make(map[string]Aggregation)
+		}
+		m[src][dst] = agg
+	}
+	return m
+}
+
+func (a *aggMap) len() int {
+	n := 0
+	for i := range a.shards {
+		s := &a.shards[i]
+		s.RLock()
+		n += len(s.m)
+		s.RUnlock()
+	}
+	return n
+}
+
+// maps are the per-host and per-pair totals.
+var maps = struct {
+	upByIP, downByIP, upByName, downByName *aggMap
+	upByMAC, downByMAC                     *aggMap
+	srcDstIP, srcDstName                   *aggMap
+}{
+	upByIP:     newAggMap(0),
+	downByIP:   newAggMap(0),
+	upByName:   newAggMap(0),
+	downByName: newAggMap(0),
+	upByMAC:    newAggMap(0),
+	downByMAC:  newAggMap(0),
+	srcDstIP:   newAggMap(maxPairs),
+	srcDstName: newAggMap(maxPairs),
+}
+
+// accountPairs adds the packet to the totals between its source and
+// destination.
+func accountPairs(m *packets.Metadata) {
+	src, dst := m.SrcIP.String(), m.DstIP.String()
+	maps.srcDstIP.add(src+pairSep+dst, m.Size, m.Packets(), m.Timestamp)
+	maps.srcDstName.add(nameOr(m.SrcName, m.SrcIP)+pairSep+nameOr(m.DstName, m.DstIP), m.Size, m.Packets(), m.Timestamp)
+}
+
+// Maps returns a copy of the per-host and per-pair totals.
+func Maps() MapValues {
+	return MapValues{
+		UpByIP:     maps.upByIP.snapshot(),
+		DownByIP:   maps.downByIP.snapshot(),
+		UpByName:   maps.upByName.snapshot(),
+		DownByName: maps.downByName.snapshot(),
+		UpByMAC:    maps.upByMAC.snapshot(),
+		DownByMAC:  maps.downByMAC.snapshot(),
+		SrcDstIP:   maps.srcDstIP.pairSnapshot(),
+		SrcDstName: maps.srcDstName.pairSnapshot(),
+	}
+}
+
+// MapSizes returns the total number of entries in the per-host and per-pair
+// maps.
+func MapSizes() int {
+	n := 0
+	for _, a := range []*aggMap{maps.upByIP, maps.downByIP, maps.upByName, maps.downByName, maps.upByMAC, maps.downByMAC, maps.srcDstIP, maps.srcDstName} {
+		n += a.len()
+	}
+	return n
+}
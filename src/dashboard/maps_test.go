@@ -0,0 +1,80 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"packets"
+)
+
+func TestAggMapConcurrent(t *testing.T) {
+	a := newAggMap(0)
+	ts := time.Unix(1434055562, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				a.add(fmt.Sprint(j%10), 100, 1, ts)
+			}
+		}()
+	}
+	wg.Wait()
+
+	m := a.snapshot()
+	if got, want := len(m), 10; got != want {
+		t.Fatalf("len(snapshot): got %d, want %d", got, want)
+	}
+	for k, agg := range m {
+		if agg.Packets != 800 || agg.Bytes != 80000 || agg.LastSeen != ts.UnixNano() {
+			t.Errorf("snapshot()[%q]: got %+v, want 800 packets, 80000 bytes, last seen %d", k, agg, ts.UnixNano())
+		}
+	}
+}
+
+func TestAggMapLimit(t *testing.T) {
+	a := newAggMap(mapShards)
+	for i := 0; i < 1000; i++ {
+		a.add(fmt.Sprint(i), 1, 1, time.Time{})
+	}
+	if got := a.len(); got > mapShards {
+		t.Errorf("len: got %d, want <= %d", got, mapShards)
+	}
+}
+
+func TestAccountPairs(t *testing.T) {
+	m := &packets.Metadata{
+		Size:    300,
+		SrcIP:   net.ParseIP("192.168.9.9"),
+		DstIP:   net.ParseIP("203.0.113.80"),
+		DstName: "example.com",
+	}
+	accountPairs(m)
+	accountPairs(m)
+
+	v := Maps()
+	if got := v.SrcDstIP["192.168.9.9"]["203.0.113.80"].Bytes; got != 600 {
+		t.Errorf("SrcDstIP bytes: got %d, want 600", got)
+	}
+	if got := v.SrcDstName["192.168.9.9"]["example.com"].Packets; got != 2 {
+		t.Errorf("SrcDstName packets: got %d, want 2", got)
+	}
+}
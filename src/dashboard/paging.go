@@ -0,0 +1,229 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file implements server-side sorting and pagination for the listing
+// endpoints, so clients can ask for a top-N without downloading everything.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 10000
+)
+
+// Entry is one row in a listing: a key (host, name, flow...) and its counters.
+type Entry struct {
+	Key string
+	Aggregation
+
+	// Families splits the totals by address family ("v4" and "v6"), for
+	// dimensions with MergeFamilies.
+	Families map[string]Aggregation `json:",omitempty"`
+}
+
+// Page is a sorted slice of a listing.
+type Page struct {
+	Total   int // number of entries before paging
+	Offset  int
+	Entries []Entry
+}
+
+// pageParams are the sorting and paging parameters of a listing request.
+type pageParams struct {
+	limit, offset int
+	sortBy        string // "bytes", "packets", "last-seen", or "key"
+	desc          bool
+}
+
+// parsePageParams reads limit, offset, sort and order from the query string.
+// The default is the first 100 entries by bytes, descending.
+func parsePageParams(r *http.Request) (pageParams, error) {
+	q := r.URL.Query()
+	p := pageParams{
+		limit:  defaultPageLimit,
+		sortBy: "bytes",
+		desc:   true,
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid limit %q", s)
+		}
+		if n > maxPageLimit {
+			n = maxPageLimit
+		}
+		p.limit = n
+	}
+	if s := q.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid offset %q", s)
+		}
+		p.offset = n
+	}
+	switch s := q.Get("sort"); s {
+	case "":
+	case "bytes", "packets", "last-seen", "key":
+		p.sortBy = s
+	default:
+		return p, fmt.Errorf("invalid sort %q", s)
+	}
+	switch s := q.Get("order"); s {
+	case "", "desc":
+	case "asc":
+		p.desc = false
+	default:
+		return p, fmt.Errorf("invalid order %q", s)
+	}
+	return p, nil
+}
+
+// less compares two entries by the sort field.
+func (p pageParams) less(a, b *Entry) bool {
+	switch p.sortBy {
+	case "packets":
+		if a.Packets != b.Packets {
+			return a.Packets < b.Packets
+		}
+	case "last-seen":
+		if a.LastSeen != b.LastSeen {
+			return a.LastSeen < b.LastSeen
+		}
+	case "bytes":
+		if a.Bytes != b.Bytes {
+			return a.Bytes < b.Bytes
+		}
+	}
+	return a.Key < b.Key
+}
+
+// apply sorts the entries and cuts out the requested page.
+func (p pageParams) apply(entries []Entry) Page {
+	sort.Slice(entries, func(i, j int) bool {
+		if p.desc {
+			return p.less(&entries[j], &entries[i])
+		}
+		return p.less(&entries[i], &entries[j])
+	})
+	pg := Page{
+		Total:  len(entries),
+		Offset: p.offset,
+	}
+	if p.offset >= len(entries) {
+		pg.Entries = []Entry{}
+		return pg
+	}
+	end := p.offset + p.limit
+	if end > len(entries) {
+		end = len(entries)
+	}
+	pg.Entries = entries[p.offset:end]
+	return pg
+}
+
+// entries snapshots a map into a slice of entries. The caller should hold a
+// lock guarding m.
+func entries(m map[string]Aggregation) []Entry {
+	es := make([]Entry, 0, len(m))
+	for k, a := range m {
+		es = append(es, Entry{Key: k, Aggregation: a})
+	}
+	return es
+}
+
+// pairEntries snapshots a pair map into entries keyed by the source and
+// destination joined by "|".
+func pairEntries(a *aggMap) []Entry {
+	m := a.snapshot()
+	es := make([]Entry, 0, len(m))
+	for k, agg := range m {
+		es = append(es, Entry{Key: strings.Replace(k, pairSep, "|", 1), Aggregation: agg})
+	}
+	return es
+}
+
+// hostsHandler serves a page of per-host totals. Query parameters:
+//
+//	by     - "ip" (default) or "name"
+//	dir    - "up" (default) or "down"
+//	sort   - "bytes" (default), "packets", "last-seen" or "key"
+//	order  - "desc" (default) or "asc"
+//	limit  - page size (default 100)
+//	offset - index of the first entry to return
+//
+// In HostsSketch mode, only the heavy hitters are listed, with bytes but not
+// packets or last-seen times.
+func hostsHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	es, ok := hostEntries(q.Get("by"), q.Get("dir"))
+	if !ok {
+		http.Error(w, "invalid by or dir", http.StatusBadRequest)
+		return
+	}
+
+	h := w.Header()
+	h.Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p.apply(es)); err != nil {
+		log.Print("template failed to write:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// flowsHandler serves a page of the totals between pairs of hosts. Query
+// parameters:
+//
+//	by     - "ip" (default) or "name"
+//	sort   - "bytes" (default), "packets", "last-seen" or "key"
+//	order  - "desc" (default) or "asc"
+//	limit  - page size (default 100)
+//	offset - index of the first entry to return
+func flowsHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var es []Entry
+	switch r.URL.Query().Get("by") {
+	case "", "ip":
+		es = pairEntries(maps.srcDstIP)
+	case "name":
+		es = pairEntries(maps.srcDstName)
+	default:
+		http.Error(w, "invalid by", http.StatusBadRequest)
+		return
+	}
+
+	h := w.Header()
+	h.Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p.apply(es)); err != nil {
+		log.Print("template failed to write:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"packets"
+)
+
+func TestPaging(t *testing.T) {
+	m := map[string]Aggregation{
+		"a": {Bytes: 10, Packets: 3, LastSeen: 2},
+		"b": {Bytes: 30, Packets: 1, LastSeen: 3},
+		"c": {Bytes: 20, Packets: 2, LastSeen: 1},
+	}
+	tests := []struct {
+		query string
+		want  []string
+		total int
+	}{
+		{query: "", want: []string{"b", "c", "a"}},
+		{query: "?sort=packets", want: []string{"a", "c", "b"}},
+		{query: "?sort=last-seen&order=asc", want: []string{"c", "a", "b"}},
+		{query: "?sort=key&limit=2", want: []string{"c", "b"}},
+		{query: "?limit=1&offset=1", want: []string{"c"}},
+		{query: "?offset=5", want: []string{}},
+	}
+	for _, test := range tests {
+		p, err := parsePageParams(httptest.NewRequest("GET", "/api/hosts"+test.query, nil))
+		if err != nil {
+			t.Errorf("parsePageParams(%q): %v", test.query, err)
+			continue
+		}
+		pg := p.apply(entries(m))
+		got := []string{}
+		for _, e := range pg.Entries {
+			got = append(got, e.Key)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("query %q: got %v, want %v", test.query, got, test.want)
+		}
+		if pg.Total != len(m) {
+			t.Errorf("query %q: total = %d, want %d", test.query, pg.Total, len(m))
+		}
+	}
+}
+
+func TestPagingBadParams(t *testing.T) {
+	for _, q := range []string{"?limit=-1", "?offset=x", "?sort=colour", "?order=sideways"} {
+		if _, err := parsePageParams(httptest.NewRequest("GET", "/api/hosts"+q, nil)); err == nil {
+			t.Errorf("parsePageParams(%q): got nil error, want error", q)
+		}
+	}
+}
+
+func TestFlowsHandler(t *testing.T) {
+	ip, name := maps.srcDstIP, maps.srcDstName
+	defer func() { maps.srcDstIP, maps.srcDstName = ip, name }()
+	maps.srcDstIP, maps.srcDstName = newAggMap(maxPairs), newAggMap(maxPairs)
+	for _, m := range []packets.Metadata{
+		{Timestamp: time.Unix(1, 0), Size: 100, SrcIP: net.ParseIP("192.168.1.2"), DstIP: net.ParseIP("8.8.8.8"), DstName: "dns.google"},
+		{Timestamp: time.Unix(2, 0), Size: 1000, SrcIP: net.ParseIP("8.8.8.8"), DstIP: net.ParseIP("192.168.1.2"), SrcName: "dns.google"},
+		{Timestamp: time.Unix(3, 0), Size: 10, SrcIP: net.ParseIP("192.168.1.3"), DstIP: net.ParseIP("1.1.1.1")},
+	} {
+		accountPairs(&m)
+	}
+
+	tests := []struct {
+		query string
+		code  int
+		want  []string
+	}{
+		{"", http.StatusOK, []string{"8.8.8.8|192.168.1.2", "192.168.1.2|8.8.8.8", "192.168.1.3|1.1.1.1"}},
+		{"?sort=last-seen&order=asc&limit=2", http.StatusOK, []string{"192.168.1.2|8.8.8.8", "8.8.8.8|192.168.1.2"}},
+		{"?by=name&offset=1", http.StatusOK, []string{"192.168.1.2|dns.google", "192.168.1.3|1.1.1.1"}},
+		{"?by=mac", http.StatusBadRequest, nil},
+		{"?limit=x", http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		flowsHandler(w, httptest.NewRequest("GET", "/api/flows"+test.query, nil))
+		if w.Code != test.code {
+			t.Errorf("%q: code %d, want %d", test.query, w.Code, test.code)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		var pg Page
+		if err := json.Unmarshal(w.Body.Bytes(), &pg); err != nil {
+			t.Errorf("%q: %v", test.query, err)
+			continue
+		}
+		got := []string{}
+		for _, e := range pg.Entries {
+			got = append(got, e.Key)
+		}
+		if !reflect.DeepEqual(got, test.want) || pg.Total != 3 {
+			t.Errorf("%q: got %v of %d, want %v of 3", test.query, got, pg.Total, test.want)
+		}
+	}
+}
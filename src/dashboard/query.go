@@ -0,0 +1,205 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file records recent history of the totals, and serves /api/query over
+// it, so that graphs can be drawn without an external database.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"history"
+	"packets"
+)
+
+var (
+	// HistoryResolution and HistoryRetention are how finely and for how
+	// long history is kept. Set them before capturing.
+	HistoryResolution = time.Minute
+	HistoryRetention  = 24 * time.Hour
+
+	historyOnce  sync.Once
+	historyStore *history.Store
+)
+
+// historyGroups are the built-in ways history is broken down, other than by
+// the extra dimensions.
+var historyGroups = map[string]func(m *packets.Metadata) (string, bool){
+	"total":     func(m *packets.Metadata) (string, bool) { return "", true },
+	"direction": func(m *packets.Metadata) (string, bool) { return direction(m), true },
+	"family": func(m *packets.Metadata) (string, bool) {
+		if m.V6 {
+			return "v6", true
+		}
+		return "v4", true
+	},
+	"device": func(m *packets.Metadata) (string, bool) {
+		ip := deviceIP(m)
+		if ip == nil {
+			return "", false
+		}
+		return ip.String(), true
+	},
+}
+
+func historyDB() *history.Store {
+	historyOnce.Do(func() {
+		historyStore = history.NewStore(HistoryResolution, HistoryRetention)
+	})
+	return historyStore
+}
+
+// recordHistory adds the packet to the history of every group.
+func recordHistory(m *packets.Metadata) {
+	t := m.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	h := historyDB()
+	for group, key := range historyGroups {
+		if k, ok := key(m); ok {
+			h.Add(t, group, k, m.Size, m.Packets())
+		}
+	}
+	for _, d := range dimensions {
+		h.Add(t, d.Name, d.key(m), m.Size, m.Packets())
+	}
+}
+
+// queryError writes an error the way the Prometheus API does.
+func queryError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":    "error",
+		"errorType": "bad_data",
+		"error":     err.Error(),
+	})
+}
+
+// parseQueryTime reads a time as Unix seconds or RFC 3339, or returns def.
+func parseQueryTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// parseStep reads a step as seconds or a duration such as "5m".
+func parseStep(s string) (time.Duration, error) {
+	if s == "" {
+		return time.Minute, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// queryHandler serves /api/query, which returns history in the format of the
+// Prometheus range query API (a "matrix"), so Grafana and similar can graph
+// it. Query parameters:
+//
+//	metric - "bytes" (default) or "packets"
+//	by     - "total" (default), "direction", "family", "device", or the name
+//	         of an extra dimension
+//	start  - Unix seconds or RFC 3339 (default: an hour before end)
+//	end    - likewise (default: now)
+//	step   - seconds, or a duration such as "5m" (default 1m)
+func queryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	metric := q.Get("metric")
+	switch metric {
+	case "":
+		metric = "bytes"
+	case "bytes", "packets":
+	default:
+		queryError(w, http.StatusBadRequest, fmt.Errorf("unknown metric %q (want bytes or packets)", metric))
+		return
+	}
+	by := q.Get("by")
+	if by == "" {
+		by = "total"
+	}
+	if _, ok := historyGroups[by]; !ok && findDimension(by) == nil {
+		queryError(w, http.StatusBadRequest, fmt.Errorf("unknown by %q", by))
+		return
+	}
+	end, err := parseQueryTime(q.Get("end"), time.Now())
+	if err != nil {
+		queryError(w, http.StatusBadRequest, fmt.Errorf("end: %v", err))
+		return
+	}
+	start, err := parseQueryTime(q.Get("start"), end.Add(-time.Hour))
+	if err != nil {
+		queryError(w, http.StatusBadRequest, fmt.Errorf("start: %v", err))
+		return
+	}
+	step, err := parseStep(q.Get("step"))
+	if err != nil {
+		queryError(w, http.StatusBadRequest, fmt.Errorf("step: %v", err))
+		return
+	}
+	series, err := historyDB().Query(by, start, end, step)
+	if err != nil {
+		queryError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	type result struct {
+		Metric map[string]string `json:"metric"`
+		Values [][2]interface{}  `json:"values"`
+	}
+	results := make([]result, 0, len(series))
+	for _, s := range series {
+		res := result{Metric: map[string]string{"__name__": "caplog_" + metric}}
+		if by != "total" {
+			res.Metric[by] = s.Key
+		}
+		for _, sm := range s.Samples {
+			v := sm.Bytes
+			if metric == "packets" {
+				v = sm.Packets
+			}
+			res.Values = append(res.Values, [2]interface{}{
+				float64(sm.Time.UnixNano()) / 1e9,
+				strconv.FormatUint(v, 10),
+			})
+		}
+		results = append(results, res)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data": map[string]interface{}{
+			"resultType": "matrix",
+			"result":     results,
+		},
+	})
+	if err != nil {
+		log.Print("query failed to write:", err)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"history"
+	"packets"
+)
+
+func TestQueryHandler(t *testing.T) {
+	historyDB()
+	historyStore = history.NewStore(time.Minute, time.Hour)
+
+	t0 := time.Unix(1434060000, 0)
+	for _, m := range []packets.Metadata{
+		{Timestamp: t0, Size: 100, SrcIP: net.ParseIP("192.168.1.10"), DstIP: net.ParseIP("8.8.8.8")},
+		{Timestamp: t0.Add(time.Minute), Size: 1000, SrcIP: net.ParseIP("8.8.8.8"), DstIP: net.ParseIP("192.168.1.10")},
+	} {
+		recordHistory(&m)
+	}
+
+	tests := []struct {
+		query string
+		code  int
+		want  interface{}
+	}{
+		{
+			query: "?by=direction&start=1434060000&end=1434060060&step=60",
+			code:  http.StatusOK,
+			want: map[string]interface{}{
+				"status": "success",
+				"data": map[string]interface{}{
+					"resultType": "matrix",
+					"result": []interface{}{
+						map[string]interface{}{
+							"metric": map[string]interface{}{"__name__": "caplog_bytes", "direction": "down"},
+							"values": []interface{}{[]interface{}{1434060000.0, "0"}, []interface{}{1434060060.0, "1000"}},
+						},
+						map[string]interface{}{
+							"metric": map[string]interface{}{"__name__": "caplog_bytes", "direction": "up"},
+							"values": []interface{}{[]interface{}{1434060000.0, "100"}, []interface{}{1434060060.0, "0"}},
+						},
+					},
+				},
+			},
+		},
+		{
+			query: "?metric=packets&start=1434060000&end=1434060000&step=2m",
+			code:  http.StatusOK,
+			want: map[string]interface{}{
+				"status": "success",
+				"data": map[string]interface{}{
+					"resultType": "matrix",
+					"result": []interface{}{
+						map[string]interface{}{
+							"metric": map[string]interface{}{"__name__": "caplog_packets"},
+							"values": []interface{}{[]interface{}{1434060000.0, "2"}},
+						},
+					},
+				},
+			},
+		},
+		{query: "?metric=colour", code: http.StatusBadRequest},
+		{query: "?by=colour", code: http.StatusBadRequest},
+		{query: "?step=often", code: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		queryHandler(rec, httptest.NewRequest("GET", "/api/query"+test.query, nil))
+		if rec.Code != test.code {
+			t.Errorf("%s: status: got %d, want %d", test.query, rec.Code, test.code)
+			continue
+		}
+		if test.want == nil {
+			continue
+		}
+		var got interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s:\ngot  %v\nwant %v", test.query, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file keeps rollups: totals along a dimension for whole periods, such
+// as per device per day or per domain per hour, computed as packets arrive
+// so that reports and quotas don't need to rescan anything.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"history"
+	"packets"
+)
+
+// RollupDir, if set, is where rollups are saved (when each period ends, and
+// by SaveState), and loaded from at startup. Set it before adding rollups.
+var RollupDir string
+
+// Rollup describes a rollup: totals along the dimension for each Interval,
+// for the last Keep intervals.
+type Rollup struct {
+	Dimension
+	Interval time.Duration
+	Keep     int
+}
+
+type rollup struct {
+	Rollup
+	dim  *dimension
+	r    *history.Rollup
+	path string // where it is saved, if anywhere
+}
+
+// rollups are the rollups, in the order they were added.
+var rollups []*rollup
+
+// AddRollup starts keeping a rollup. Call it before capturing.
+func AddRollup(r Rollup) error {
+	if findRollup(r.Name) != nil {
+		return fmt.Errorf("rollup %s is defined twice", r.Name)
+	}
+	if r.Interval <= 0 {
+		return fmt.Errorf("rollup %s: interval must be positive", r.Name)
+	}
+	dim, err := newDimension(r.Dimension)
+	if err != nil {
+		return fmt.Errorf("rollup: %v", err)
+	}
+	ru := &rollup{
+		Rollup: r,
+		dim:    dim,
+		r:      history.NewRollup(r.Interval, r.Keep),
+	}
+	if RollupDir != "" {
+		ru.path = filepath.Join(RollupDir, "rollup-"+r.Name+".json")
+		if err := ru.r.Load(ru.path); err != nil {
+			return fmt.Errorf("rollup %s: %v", r.Name, err)
+		}
+		ru.r.Closed = func(*history.Rollup) {
+			if err := ru.save(); err != nil {
+				log.Printf("rollup %s: %v", r.Name, err)
+			}
+		}
+	}
+	rollups = append(rollups, ru)
+	return nil
+}
+
+// save saves the rollup, if it has a path.
+func (ru *rollup) save() error {
+	if ru.path == "" {
+		return nil
+	}
+	return ru.r.Save(ru.path)
+}
+
+// SaveState saves the rollups in RollupDir and the billing samples in
+// BillingDir, if they are set, so that the current periods' totals survive
+// a restart (or, if it is called now and then, a crash). It returns the
+// first error, but saves everything it can.
+func SaveState() error {
+	var first error
+	for _, ru := range rollups {
+		if err := ru.save(); err != nil && first == nil {
+			first = fmt.Errorf("rollup %s: %v", ru.Name, err)
+		}
+	}
+	if err := saveBillingState(); err != nil && first == nil {
+		first = fmt.Errorf("billing: %v", err)
+	}
+	return first
+}
+
+func findRollup(name string) *rollup {
+	for _, r := range rollups {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// accountRollups adds the packet to every rollup.
+func accountRollups(m *packets.Metadata) {
+	if len(rollups) == 0 {
+		return
+	}
+	t := m.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	for _, r := range rollups {
+		r.r.Add(t, r.dim.key(m), m.Size, m.Packets())
+	}
+}
+
+// RollupTotals returns the named rollup's totals per key, over the periods
+// that overlap start to end.
+func RollupTotals(name string, start, end time.Time) (map[string]history.Point, error) {
+	r := findRollup(name)
+	if r == nil {
+		return nil, fmt.Errorf("no rollup named %q", name)
+	}
+	return r.r.Totals(start, end), nil
+}
+
+// rollupsHandler serves /api/rollups, the list of rollups, and
+// /api/rollups/<name>, the periods of one rollup. Query parameters:
+//
+//	start - Unix seconds or RFC 3339 (default: the earliest kept)
+//	end   - likewise (default: now)
+func rollupsHandler(w http.ResponseWriter, r *http.Request) {
+	var v interface{}
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/rollups"), "/")
+	if name == "" {
+		list := make([]Rollup, len(rollups))
+		for i, ru := range rollups {
+			list[i] = ru.Rollup
+		}
+		v = list
+	} else {
+		ru := findRollup(name)
+		if ru == nil {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		end, err := parseQueryTime(q.Get("end"), time.Now())
+		if err != nil {
+			http.Error(w, "end: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		start, err := parseQueryTime(q.Get("start"), time.Time{})
+		if err != nil {
+			http.Error(w, "start: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		v = ru.r.Periods(start, end)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print("rollups failed to write:", err)
+	}
+}
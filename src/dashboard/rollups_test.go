@@ -0,0 +1,66 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"history"
+	"packets"
+)
+
+func TestRollups(t *testing.T) {
+	defer func() { rollups = nil }()
+	err := AddRollup(Rollup{
+		Dimension: Dimension{Name: "domain-hourly", By: []string{"remote_name"}},
+		Interval:  time.Hour,
+		Keep:      24,
+	})
+	if err != nil {
+		t.Fatalf("AddRollup: %v", err)
+	}
+	for _, r := range []Rollup{
+		{Dimension: Dimension{Name: "domain-hourly", By: []string{"device"}}, Interval: time.Hour},
+		{Dimension: Dimension{Name: "no-interval", By: []string{"device"}}},
+		{Dimension: Dimension{Name: "bad-field", By: []string{"colour"}}, Interval: time.Hour},
+	} {
+		if err := AddRollup(r); err == nil {
+			t.Errorf("AddRollup(%+v): got nil error, want error", r)
+		}
+	}
+
+	t0 := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, m := range []packets.Metadata{
+		{Timestamp: t0, Size: 100, SrcIP: net.ParseIP("192.168.1.10"), DstIP: net.ParseIP("8.8.8.8"), DstName: "dns.google"},
+		{Timestamp: t0.Add(time.Minute), Size: 1000, SrcIP: net.ParseIP("8.8.8.8"), DstIP: net.ParseIP("192.168.1.10"), SrcName: "dns.google"},
+		{Timestamp: t0.Add(2 * time.Hour), Size: 10, SrcIP: net.ParseIP("192.168.1.10"), DstIP: net.ParseIP("1.1.1.1")},
+	} {
+		accountRollups(&m)
+	}
+
+	got, err := RollupTotals("domain-hourly", t0, t0.Add(30*time.Minute))
+	if err != nil {
+		t.Fatalf("RollupTotals: %v", err)
+	}
+	if want := map[string]history.Point{"dns.google": {Bytes: 1100, Packets: 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RollupTotals: got %v, want %v", got, want)
+	}
+	if _, err := RollupTotals("nope", t0, t0); err == nil {
+		t.Error("RollupTotals(nope): got nil error, want error")
+	}
+}
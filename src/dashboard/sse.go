@@ -0,0 +1,108 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file pushes periodic Values snapshots to clients as server-sent events.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultEventInterval = 5 * time.Second
+	minEventInterval     = 500 * time.Millisecond
+	maxEventInterval     = time.Hour
+)
+
+// parseInterval reads the interval query parameter, which may be a Go duration
+// ("2s", "500ms") or a plain number of seconds, or else returns def. It is
+// kept from minEventInterval to maxEventInterval.
+func parseInterval(r *http.Request, def time.Duration) (time.Duration, error) {
+	s := r.URL.Query().Get("interval")
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		secs, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+			return 0, fmt.Errorf("invalid interval %q", s)
+		}
+		// Clamped first, so the conversion can't overflow.
+		d = maxEventInterval
+		if secs < maxEventInterval.Seconds() {
+			d = time.Duration(secs * float64(time.Second))
+		}
+	}
+	if d < minEventInterval {
+		d = minEventInterval
+	}
+	if d > maxEventInterval {
+		d = maxEventInterval
+	}
+	return d, nil
+}
+
+// writeEvent writes one server-sent event with a JSON payload.
+func writeEvent(w http.ResponseWriter, event string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
+
+// eventsHandler sends a "values" event with the current State immediately and
+// then every interval, until the client goes away.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	interval, err := parseInterval(r, defaultEventInterval)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Suggest clients reconnect at about the same rate as updates.
+	fmt.Fprintf(w, "retry: %d\n\n", interval/time.Millisecond)
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		if err := writeEvent(w, "values", State()); err != nil {
+			log.Print("event stream failed to write:", err)
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-tick.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		query string
+		want  time.Duration // 0 for an error
+	}{
+		{"", defaultEventInterval},
+		{"?interval=2s", 2 * time.Second},
+		{"?interval=1.5", 1500 * time.Millisecond},
+		{"?interval=10ms", minEventInterval},
+		{"?interval=-3", minEventInterval},
+		{"?interval=3h", maxEventInterval},
+		{"?interval=1e300", maxEventInterval},
+		{"?interval=NaN", 0},
+		{"?interval=Inf", 0},
+		{"?interval=-Inf", 0},
+		{"?interval=5x", 0},
+		{"?interval=soon", 0},
+	}
+	for _, test := range tests {
+		got, err := parseInterval(httptest.NewRequest("GET", "/dashboard/events"+test.query, nil), defaultEventInterval)
+		if test.want == 0 {
+			if err == nil {
+				t.Errorf("parseInterval(%q): got %v, want an error", test.query, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("parseInterval(%q): got %v, %v, want %v", test.query, got, err, test.want)
+		}
+	}
+}
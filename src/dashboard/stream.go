@@ -0,0 +1,185 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file streams the dashboard values to the live dashboard: the whole
+// Values first, then each interval a JSON merge patch (RFC 7386) of what
+// changed, as server-sent events or WebSocket messages.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStreamInterval = time.Second
+
+	// maxClientFrame bounds the frames a WebSocket client may send; it has
+	// nothing to say.
+	maxClientFrame = 4096
+)
+
+// streamMessage is a WebSocket message. Event is "values" or "delta", as
+// for server-sent events.
+type streamMessage struct {
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// jsonTree returns v as decoded JSON.
+func jsonTree(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var t interface{}
+	err = json.Unmarshal(b, &t)
+	return t, err
+}
+
+// mergePatch returns the JSON merge patch from old to new, which are decoded
+// JSON, and whether there are any changes. Objects are patched key by key;
+// anything else is replaced.
+func mergePatch(old, new interface{}) (interface{}, bool) {
+	oldObj, ok1 := old.(map[string]interface{})
+	newObj, ok2 := new.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return new, !reflect.DeepEqual(old, new)
+	}
+	patch := make(map[string]interface{})
+	for k, nv := range newObj {
+		ov, ok := oldObj[k]
+		if !ok {
+			patch[k] = nv
+			continue
+		}
+		if p, changed := mergePatch(ov, nv); changed {
+			patch[k] = p
+		}
+	}
+	for k := range oldObj {
+		if _, ok := newObj[k]; !ok {
+			patch[k] = nil // removed
+		}
+	}
+	return patch, len(patch) > 0
+}
+
+// streamValues calls send with the values, and then every interval with
+// what changed, until done is closed or send fails.
+func streamValues(interval time.Duration, done <-chan struct{}, send func(event string, data interface{}) error) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	var last interface{}
+	for {
+		cur, err := jsonTree(State())
+		if err != nil {
+			log.Print("stream failed to encode:", err)
+			return
+		}
+		if last == nil {
+			err = send("values", cur)
+		} else if p, changed := mergePatch(last, cur); changed {
+			err = send("delta", p)
+		}
+		if err != nil {
+			return
+		}
+		last = cur
+		select {
+		case <-tick.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// streamHandler serves /dashboard/stream, as a WebSocket if the client asks
+// to upgrade, or else as server-sent events. ?interval= is as for
+// /dashboard/events, but defaults to a second.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	interval, err := parseInterval(r, defaultStreamInterval)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if isWebSocket(r) {
+		streamWebSocket(w, r, interval)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "retry: %d\n\n", interval/time.Millisecond)
+	streamValues(interval, r.Context().Done(), func(event string, data interface{}) error {
+		if err := writeEvent(w, event, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
+
+// streamWebSocket streams over a WebSocket, until the client closes it.
+func streamWebSocket(w http.ResponseWriter, r *http.Request, interval time.Duration) {
+	conn, rw, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Print("stream: ", err)
+		return
+	}
+	defer conn.Close()
+
+	var mu sync.Mutex // guards writes to rw
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			op, payload, err := readFrame(rw.Reader, maxClientFrame)
+			if err != nil {
+				return
+			}
+			switch op {
+			case wsClose:
+				mu.Lock()
+				writeFrame(rw.Writer, wsClose, payload)
+				mu.Unlock()
+				return
+			case wsPing:
+				mu.Lock()
+				writeFrame(rw.Writer, wsPong, payload)
+				mu.Unlock()
+			}
+		}
+	}()
+	streamValues(interval, done, func(event string, data interface{}) error {
+		b, err := json.Marshal(streamMessage{event, data})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return writeFrame(rw.Writer, wsText, b)
+	})
+}
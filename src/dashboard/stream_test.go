@@ -0,0 +1,199 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cors"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		old, new, want string
+	}{
+		{`{"a":1,"b":{"c":2,"d":3}}`, `{"a":1,"b":{"c":2,"d":4}}`, `{"b":{"d":4}}`},
+		{`{"a":1,"b":2}`, `{"a":1}`, `{"b":null}`},
+		{`{"a":1}`, `{"a":1,"b":{"c":2}}`, `{"b":{"c":2}}`},
+		{`{"a":[1,2]}`, `{"a":[1,3]}`, `{"a":[1,3]}`},
+		{`{"a":1}`, `{"a":1}`, ``},
+	}
+	for _, test := range tests {
+		var old, new interface{}
+		json.Unmarshal([]byte(test.old), &old)
+		json.Unmarshal([]byte(test.new), &new)
+		p, changed := mergePatch(old, new)
+		if test.want == "" {
+			if changed {
+				t.Errorf("mergePatch(%s, %s): got %v, want no change", test.old, test.new, p)
+			}
+			continue
+		}
+		var want interface{}
+		json.Unmarshal([]byte(test.want), &want)
+		if !changed || !reflect.DeepEqual(p, want) {
+			t.Errorf("mergePatch(%s, %s): got %v, %t, want %s", test.old, test.new, p, changed, test.want)
+		}
+	}
+}
+
+func TestStreamSSE(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(streamHandler))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/dashboard/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type: got %q, want text/event-stream", got)
+	}
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "event: ") {
+			if got := strings.TrimSpace(line); got != "event: values" {
+				t.Errorf("first event: got %q, want event: values", got)
+			}
+			break
+		}
+	}
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(streamHandler))
+	defer srv.Close()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /dashboard/stream HTTP/1.1\r\n" +
+		"Host: caplog\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"))
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: got %s, want 101", resp.Status)
+	}
+	// The example from RFC 6455.
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("Sec-WebSocket-Accept: got %q, want %q", got, want)
+	}
+
+	op, payload, err := readServerFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op != wsText {
+		t.Fatalf("first frame opcode: got %#x, want text", op)
+	}
+	var m struct {
+		Event string
+		Data  map[string]interface{}
+	}
+	if err := json.Unmarshal(payload, &m); err != nil {
+		t.Fatalf("message %q: %v", payload, err)
+	}
+	if m.Event != "values" || m.Data["Total"] == nil {
+		t.Errorf("first message: got %+v, want the values", m)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	defer func(old []string) { cors.AllowedOrigins = old }(cors.AllowedOrigins)
+	cors.AllowedOrigins = []string{"https://frontend.example"}
+
+	srv := httptest.NewServer(http.HandlerFunc(streamHandler))
+	defer srv.Close()
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"http://caplog:8080", http.StatusSwitchingProtocols},
+		{"https://frontend.example", http.StatusSwitchingProtocols},
+		{"https://evil.example", http.StatusForbidden},
+		{"http://caplog.evil.example:8080", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := "GET /dashboard/stream HTTP/1.1\r\n" +
+			"Host: caplog:8080\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+			"Sec-WebSocket-Version: 13\r\n"
+		if test.origin != "" {
+			req += "Origin: " + test.origin + "\r\n"
+		}
+		conn.Write([]byte(req + "\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		conn.Close()
+		if err != nil {
+			t.Fatalf("Origin %q: %v", test.origin, err)
+		}
+		if resp.StatusCode != test.want {
+			t.Errorf("Origin %q: got %s, want %d", test.origin, resp.Status, test.want)
+		}
+	}
+}
+
+// readServerFrame reads an unmasked frame, as servers send.
+func readServerFrame(r *bufio.Reader) (byte, []byte, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	payload := make([]byte, n)
+	_, err := io.ReadFull(r, payload)
+	return h[0] & 0x0f, payload, err
+}
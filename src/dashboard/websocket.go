@@ -0,0 +1,165 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboard
+
+// This file implements just enough of the WebSocket protocol (RFC 6455) to
+// push text messages to a browser.
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"cors"
+)
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+
+	// wsMaxControl is the longest control frame payload allowed.
+	wsMaxControl = 125
+)
+
+// isWebSocket reports whether the request asks to upgrade to a WebSocket.
+func isWebSocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// wsOriginAllowed reports whether a WebSocket may be opened from the
+// request's origin. Browsers don't apply the same-origin policy to
+// WebSockets, so without this any page could read the stream through its
+// visitor's browser. Clients other than browsers don't send Origin.
+func wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return cors.Allowed(origin)
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the
+// connection. The request must come from the dashboard's own origin, or one
+// in cors.AllowedOrigins.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.ReadWriter, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" || key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "bad WebSocket handshake", http.StatusBadRequest)
+		return nil, nil, errors.New("bad WebSocket handshake")
+	}
+	if !wsOriginAllowed(r) {
+		http.Error(w, "WebSocket origin not allowed", http.StatusForbidden)
+		return nil, nil, errors.New("WebSocket origin not allowed: " + r.Header.Get("Origin"))
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket unsupported", http.StatusInternalServerError)
+		return nil, nil, errors.New("response can't be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw, nil
+}
+
+// writeFrame writes an unfragmented, unmasked frame (as servers send).
+func writeFrame(w *bufio.Writer, opcode byte, payload []byte) error {
+	w.WriteByte(0x80 | opcode) // FIN
+	switch n := len(payload); {
+	case n <= 125:
+		w.WriteByte(byte(n))
+	case n <= 0xffff:
+		w.WriteByte(126)
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		w.Write(b[:])
+	default:
+		w.WriteByte(127)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(n))
+		w.Write(b[:])
+	}
+	w.Write(payload)
+	return w.Flush()
+}
+
+// readFrame reads a frame sent by a client, which must be masked, and
+// returns its opcode and unmasked payload. Data frames longer than max are
+// an error.
+func readFrame(r *bufio.Reader, max int) (opcode byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = h[0] & 0x0f
+	if h[1]&0x80 == 0 {
+		return 0, nil, errors.New("websocket: unmasked client frame")
+	}
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if opcode&0x8 != 0 && n > wsMaxControl || n > uint64(max) {
+		return 0, nil, errors.New("websocket: frame too long")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+// This file reads dnsmasq's leases file.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DnsmasqLeasesFile is where dnsmasq keeps its leases on most systems.
+const DnsmasqLeasesFile = "/var/lib/misc/dnsmasq.leases"
+
+// Dnsmasq is a dnsmasq leases file.
+type Dnsmasq struct {
+	Path string // DnsmasqLeasesFile if empty
+}
+
+// File returns the path of the leases file.
+func (s *Dnsmasq) File() string {
+	if s.Path == "" {
+		return DnsmasqLeasesFile
+	}
+	return s.Path
+}
+
+// Leases reads all the leases in the file, by IP address.
+func (s *Dnsmasq) Leases() (map[string]Lease, error) {
+	return readLeases(s.File(), parseDnsmasq)
+}
+
+// parseDnsmasq parses a dnsmasq leases file. dnsmasq rewrites the whole file
+// each time, with only the leases it holds.
+func parseDnsmasq(f io.Reader) (map[string]Lease, error) {
+	/*
+		1456837200 00:11:22:33:44:55 192.168.1.20 laptop 01:00:11:22:33:44:55
+		0 66:77:88:99:aa:bb 192.168.1.21 * *
+		duid 00:01:00:01:1e:7d:1d:5c:00:11:22:33:44:55
+		1456837200 1234567 fd00::20 laptop 00:01:00:01:...
+
+		The expiry time is 0 for a lease that never ends, and * is a
+		missing host name or client ID. The IPv6 leases, after the server's
+		DUID, have the IAID where the others have the MAC address.
+	*/
+
+	leases := make(map[string]Lease)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		words := strings.Fields(sc.Text())
+		if len(words) == 0 || words[0] == "duid" {
+			continue
+		}
+		if len(words) < 4 {
+			return nil, fmt.Errorf("line %d: want at least 4 fields, got %d", n, len(words))
+		}
+		expiry, err := strconv.ParseInt(words[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad expiry time %q", n, words[0])
+		}
+		ip := net.ParseIP(words[2])
+		if ip == nil {
+			return nil, fmt.Errorf("line %d: %v", n, errMissingIP)
+		}
+		l := Lease{IP: ip, State: "active"}
+		if expiry != 0 {
+			l.Ends = time.Unix(expiry, 0).UTC()
+		}
+		// An IPv6 lease's IAID isn't a MAC address, nor is a hardware
+		// address of a type other than Ethernet (written with the type
+		// in front).
+		if mac, err := net.ParseMAC(words[1]); err == nil {
+			l.HWAddr = mac
+		}
+		if words[3] != "*" {
+			l.Host = words[3]
+		}
+		leases[ip.String()] = l
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return leases, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDnsmasqLeases = `1456837200 00:11:22:33:44:55 192.168.1.20 laptop 01:00:11:22:33:44:55
+0 66:77:88:99:aa:bb 192.168.1.21 * *
+1456837200 20-00:11:22:33:44:55:66:77 192.168.1.22 phone *
+duid 00:01:00:01:1e:7d:1d:5c:00:11:22:33:44:55
+1456837200 1234567 fd00::20 laptop 00:01:00:01:1e:7d:1d:5c:00:11:22:33:44:55
+`
+
+func TestParseDnsmasq(t *testing.T) {
+	got, err := parseDnsmasq(strings.NewReader(testDnsmasqLeases))
+	if err != nil {
+		t.Fatalf("parseDnsmasq: %v", err)
+	}
+	mac := func(s string) net.HardwareAddr {
+		m, _ := net.ParseMAC(s)
+		return m
+	}
+	ends := time.Date(2016, 3, 1, 13, 0, 0, 0, time.UTC)
+	want := map[string]Lease{
+		"192.168.1.20": {
+			IP:     net.ParseIP("192.168.1.20"),
+			HWAddr: mac("00:11:22:33:44:55"),
+			Host:   "laptop",
+			Ends:   ends,
+			State:  "active",
+		},
+		// Never ends, and has no host name.
+		"192.168.1.21": {
+			IP:     net.ParseIP("192.168.1.21"),
+			HWAddr: mac("66:77:88:99:aa:bb"),
+			State:  "active",
+		},
+		// Not Ethernet.
+		"192.168.1.22": {
+			IP:    net.ParseIP("192.168.1.22"),
+			Host:  "phone",
+			Ends:  ends,
+			State: "active",
+		},
+		// The IAID isn't a MAC address.
+		"fd00::20": {
+			IP:    net.ParseIP("fd00::20"),
+			Host:  "laptop",
+			Ends:  ends,
+			State: "active",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDnsmasq:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseDnsmasqErrors(t *testing.T) {
+	tests := []string{
+		"1456837200 00:11:22:33:44:55 192.168.1.20\n",
+		"soon 00:11:22:33:44:55 192.168.1.20 laptop *\n",
+		"1456837200 00:11:22:33:44:55 laptop laptop *\n",
+	}
+	for _, test := range tests {
+		if _, err := parseDnsmasq(strings.NewReader(test)); err == nil {
+			t.Errorf("parseDnsmasq(%q): no error", test)
+		}
+	}
+}
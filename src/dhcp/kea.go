@@ -0,0 +1,134 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+// This file reads Kea's leases file (the memfile lease database).
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeaLeasesFile is where Kea keeps its DHCPv4 leases on most systems.
+const KeaLeasesFile = "/var/lib/kea/kea-leases4.csv"
+
+// keaStates are the names of the lease states Kea numbers.
+var keaStates = map[string]string{
+	"0": "active",
+	"1": "declined",
+	"2": "expired",
+}
+
+// Kea is a Kea memfile leases file, of DHCPv4 or DHCPv6 leases.
+type Kea struct {
+	Path string // KeaLeasesFile if empty
+}
+
+// File returns the path of the leases file.
+func (s *Kea) File() string {
+	if s.Path == "" {
+		return KeaLeasesFile
+	}
+	return s.Path
+}
+
+// Leases reads all the leases in the file, by IP address.
+func (s *Kea) Leases() (map[string]Lease, error) {
+	return readLeases(s.File(), parseKea)
+}
+
+// parseKea parses a Kea leases file. Kea appends a lease each time it
+// changes (until the file is cleaned up), so the last one for each address
+// wins.
+func parseKea(f io.Reader) (map[string]Lease, error) {
+	/*
+		address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context,pool_id
+		192.168.1.20,00:11:22:33:44:55,01:00:11:22:33:44:55,3600,1456837200,1,0,0,laptop,0,,0
+
+		The columns differ between versions, and between the DHCPv4 and
+		DHCPv6 files, so they are found by name. Commas in host names are
+		written as "&#x2c".
+	*/
+
+	leases := make(map[string]Lease)
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err == io.EOF {
+		return leases, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"address", "expire"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("no %s column", name)
+		}
+	}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		n, _ := r.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(rec) {
+				return rec[i]
+			}
+			return ""
+		}
+		ip := net.ParseIP(field("address"))
+		if ip == nil {
+			return nil, fmt.Errorf("line %d: %v", n, errMissingIP)
+		}
+		expire, err := strconv.ParseInt(field("expire"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad expire time %q", n, field("expire"))
+		}
+		state := field("state")
+		if state == "" {
+			state = "0" // from before Kea had lease states
+		}
+		l := Lease{
+			IP:    ip,
+			Ends:  time.Unix(expire, 0).UTC(),
+			Host:  strings.TrimSuffix(strings.Replace(field("hostname"), "&#x2c", ",", -1), "."),
+			State: keaStates[state],
+		}
+		if l.State == "" {
+			l.State = "state " + state
+		}
+		if lifetime, err := strconv.ParseInt(field("valid_lifetime"), 10, 64); err == nil {
+			l.Starts = l.Ends.Add(-time.Duration(lifetime) * time.Second)
+		}
+		if mac, err := net.ParseMAC(field("hwaddr")); err == nil {
+			l.HWAddr = mac // DHCPv6 leases may have none
+		}
+		leases[ip.String()] = l
+	}
+	return leases, nil
+}
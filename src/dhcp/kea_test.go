@@ -0,0 +1,83 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testKeaLeases = `address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context,pool_id
+192.168.1.20,00:11:22:33:44:55,01:00:11:22:33:44:55,3600,1456837200,1,0,0,laptop.home.,0,,0
+192.168.1.21,66:77:88:99:aa:bb,,3600,1456837200,1,0,0,Bob&#x2cs TV,0,,0
+192.168.1.22,,,3600,1456837200,1,0,0,,1,,0
+192.168.1.20,00:11:22:33:44:55,01:00:11:22:33:44:55,0,1456833600,1,0,0,laptop.home.,2,,0
+`
+
+func TestParseKea(t *testing.T) {
+	got, err := parseKea(strings.NewReader(testKeaLeases))
+	if err != nil {
+		t.Fatalf("parseKea: %v", err)
+	}
+	mac := func(s string) net.HardwareAddr {
+		m, _ := net.ParseMAC(s)
+		return m
+	}
+	start := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	want := map[string]Lease{
+		// Replaced by the later lease for the address.
+		"192.168.1.20": {
+			IP:     net.ParseIP("192.168.1.20"),
+			HWAddr: mac("00:11:22:33:44:55"),
+			Host:   "laptop.home",
+			Starts: start,
+			Ends:   start,
+			State:  "expired",
+		},
+		"192.168.1.21": {
+			IP:     net.ParseIP("192.168.1.21"),
+			HWAddr: mac("66:77:88:99:aa:bb"),
+			Host:   "Bob,s TV",
+			Starts: start,
+			Ends:   start.Add(time.Hour),
+			State:  "active",
+		},
+		"192.168.1.22": {
+			IP:     net.ParseIP("192.168.1.22"),
+			Starts: start,
+			Ends:   start.Add(time.Hour),
+			State:  "declined",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseKea:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseKeaErrors(t *testing.T) {
+	tests := []string{
+		"hwaddr,expire\n00:11:22:33:44:55,1456837200\n",
+		"address,expire\nlaptop,1456837200\n",
+		"address,expire\n192.168.1.20,soon\n",
+	}
+	for _, test := range tests {
+		if _, err := parseKea(strings.NewReader(test)); err == nil {
+			t.Errorf("parseKea(%q): no error", test)
+		}
+	}
+}
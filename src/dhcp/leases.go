@@ -12,7 +12,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package dhcp has some routines for parsing dhcpd.leases files.
+// Package dhcp has some routines for parsing DHCP servers' leases files:
+// ISC dhcpd's dhcpd.leases, dnsmasq's and Kea's.
 package dhcp
 
 import (
@@ -20,41 +21,86 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"os"
-	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
-const (
-	leasesFile = "/var/lib/dhcp/dhcpd.leases"
-)
+// LeasesFile is where ISC dhcpd keeps its leases on most systems.
+const LeasesFile = "/var/lib/dhcp/dhcpd.leases"
 
-var (
-	dateRE = regexp.MustCompile(`(%d) (%d{4,})/(%d{1,2})/(%d{1,2}) hour:minute:second`)
+// DefaultInterval is how often Watch checks the file if interval is zero.
+const DefaultInterval = time.Minute
 
+var (
 	errMissingIP            = errors.New("missing IP address")
 	errMissingHWAddressType = errors.New("missing hardware address type")
 	errMissingHWAddress     = errors.New("missing hardware address")
 )
 
+// Lease is a lease from a leases file.
 type Lease struct {
 	IP     net.IP
 	HWAddr net.HardwareAddr
-	Host   string
+	Host   string // the client's host name, if it gave one
+
+	// Starts and Ends bound the lease. Ends is zero for a lease that never
+	// ends. Starts is zero if the file doesn't say (dnsmasq's).
+	Starts, Ends time.Time
+
+	// State is the binding state: "active", "free", "expired", "released",
+	// "abandoned", "backup", and so on. Kea's leases are "active",
+	// "declined" or "expired", and dnsmasq's are all "active".
+	State string
 }
 
-// Leases reads and parses the dhcpd.leases file to get all the leases.
+// Active reports whether the lease is bound at t.
+func (l *Lease) Active(t time.Time) bool {
+	return l.State == "active" && (l.Ends.IsZero() || t.Before(l.Ends))
+}
+
+// Leases reads and parses the dhcpd.leases file to get all the leases, by
+// IP address.
 func Leases() (map[string]Lease, error) {
-	f, err := os.Open(leasesFile)
+	return readLeases(LeasesFile, parseLeases)
+}
+
+// ISC is an ISC dhcpd leases file.
+type ISC struct {
+	Path string // LeasesFile if empty
+}
+
+// File returns the path of the leases file.
+func (s *ISC) File() string {
+	if s.Path == "" {
+		return LeasesFile
+	}
+	return s.Path
+}
+
+// Leases reads all the leases in the file, by IP address.
+func (s *ISC) Leases() (map[string]Lease, error) {
+	return readLeases(s.File(), parseLeases)
+}
+
+// readLeases reads a leases file, and parses it with parse.
+func readLeases(path string, parse func(io.Reader) (map[string]Lease, error)) (map[string]Lease, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-	return parseLeases(f)
+	return parse(f)
 }
 
-func parseLeases(f io.Reader) (map[[16]byte]Lease, error) {
+// parseLeases parses a leases file. dhcpd appends a lease each time it
+// changes, so the last one for each address wins. A statement that can't be
+// parsed is logged and skipped (a lease with a bad address, as a whole),
+// rather than losing the rest of the file.
+func parseLeases(f io.Reader) (map[string]Lease, error) {
 	/*
 		# comment
 		lease 192.168.1.xxx {
@@ -75,55 +121,69 @@ func parseLeases(f io.Reader) (map[[16]byte]Lease, error) {
 	var lease *Lease
 
 	sc := bufio.NewScanner(f)
-	for sc.Scan() {
+	for n := 1; sc.Scan(); n++ {
 		line := strings.TrimSpace(sc.Text())
 		if len(line) == 0 || line[0] == '#' {
 			continue
 		}
-
-		ws := bufio.NewScanner(strings.NewReader(line))
-		ws.Split(bufio.ScanWords)
-		for ws.Scan() {
-			switch ws.Text() {
-			case "lease":
-				// Next word: IP.
-				if !ws.Scan() {
-					return nil, errMissingIP
-				}
-				ip := net.ParseIP(ws.Text())
-				if ip != nil {
-					return nil, errMissingIP
-				}
-				lease = &Lease{IP: ip}
-			case "hardware":
-				if lease == nil {
-					return nil, fmt.Errorf("unexpected token %q", ws.Text())
-				}
-				// Expect "ethernet".
-				if !ws.Scan() {
-					return nil, errMissingHWAddressType
-				}
-				if h := ws.Text(); h != "ethernet" {
-					return nil, fmt.Errorf("unsupported hardware address type %q", h)
-				}
-				if !ws.Scan() {
-					return nil, errMissingHWAddress
-				}
-				m, err := net.ParseMAC(strings.TrimRight(ws.Text(), ";"))
-				if err != nil {
-					return nil, err
-				}
-				lease.HWAddr = m
-			case "client-hostname":
-				// Expect a quoted name.
-
-			case "}":
+		if line == "}" {
+			if lease != nil {
 				leases[lease.IP.String()] = *lease
 				lease = nil
 			}
+			continue
 		}
-		if err := ws.Err(); err != nil {
-			return nil, err
+		words := strings.Fields(strings.TrimSuffix(line, ";"))
+		if len(words) == 0 {
+			// An empty statement.
+			continue
+		}
+		if words[0] == "lease" {
+			// lease <ip> {
+			lease = nil
+			if len(words) >= 2 {
+				if ip := net.ParseIP(words[1]); ip != nil {
+					lease = &Lease{IP: ip}
+				}
+			}
+			if lease == nil {
+				log.Printf("dhcp: leases line %d: %v; skipping the lease", n, errMissingIP)
+			}
+			continue
+		}
+		if lease == nil {
+			// Outside a lease: server-duid, failover state, and so on.
+			continue
+		}
+		var err error
+		switch words[0] {
+		case "starts":
+			lease.Starts, err = parseTime(words[1:])
+		case "ends":
+			lease.Ends, err = parseTime(words[1:])
+		case "binding":
+			// binding state <state>
+			if len(words) == 3 && words[1] == "state" {
+				lease.State = words[2]
+			}
+		case "hardware":
+			switch {
+			case len(words) < 2:
+				err = errMissingHWAddressType
+			case words[1] != "ethernet":
+				err = fmt.Errorf("unsupported hardware address type %q", words[1])
+			case len(words) < 3:
+				err = errMissingHWAddress
+			default:
+				lease.HWAddr, err = net.ParseMAC(words[2])
+			}
+		case "client-hostname":
+			// A quoted name, with C-style octal escapes.
+			q := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "client-hostname"), ";"))
+			lease.Host, err = strconv.Unquote(q)
+		}
+		if err != nil {
+			log.Printf("dhcp: leases line %d: %v; skipping it", n, err)
 		}
 	}
 	if err := sc.Err(); err != nil {
@@ -131,3 +191,26 @@ func parseLeases(f io.Reader) (map[[16]byte]Lease, error) {
 	}
 	return leases, nil
 }
+
+// parseTime parses the time of a starts or ends statement: "<weekday>
+// yyyy/mm/dd hh:mm:ss" in UTC, "epoch <seconds>" (with db-time-format
+// local, followed by a comment), or "never".
+func parseTime(words []string) (time.Time, error) {
+	switch {
+	case len(words) >= 1 && words[0] == "never":
+		return time.Time{}, nil
+	case len(words) >= 2 && words[0] == "epoch":
+		secs, err := strconv.ParseInt(strings.TrimSuffix(words[1], ";"), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad time %q", strings.Join(words, " "))
+		}
+		return time.Unix(secs, 0).UTC(), nil
+	case len(words) == 3:
+		t, err := time.Parse("2006/01/02 15:04:05", words[1]+" "+words[2])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad time %q", strings.Join(words, " "))
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("bad time %q", strings.Join(words, " "))
+}
@@ -0,0 +1,127 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testLeases = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+# This lease file was written by isc-dhcp-4.4.1
+
+server-duid "\000\001\000\001";
+
+lease 192.168.1.20 {
+  starts 2 2016/03/01 12:00:00;
+  ends 2 2016/03/01 13:00:00;
+  cltt 2 2016/03/01 12:00:00;
+  binding state active;
+  next binding state free;
+  rewind binding state free;
+  hardware ethernet 00:11:22:33:44:55;
+  uid "\001\000\021\"3DU";
+  client-hostname "laptop";
+}
+lease 192.168.1.21 {
+  starts epoch 1456833600; # Tue Mar 01 12:00:00 2016
+  ends never;
+  binding state active;
+  hardware ethernet 66:77:88:99:aa:bb;
+  client-hostname "Caf\303\251 TV";
+}
+lease 192.168.1.20 {
+  starts 2 2016/03/01 12:00:00;
+  ends 2 2016/03/01 12:30:00;
+  binding state free;
+  hardware ethernet 00:11:22:33:44:55;
+}
+`
+
+func TestParseLeases(t *testing.T) {
+	got, err := parseLeases(strings.NewReader(testLeases))
+	if err != nil {
+		t.Fatalf("parseLeases: %v", err)
+	}
+	mac := func(s string) net.HardwareAddr {
+		m, _ := net.ParseMAC(s)
+		return m
+	}
+	start := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	want := map[string]Lease{
+		// Replaced by the later lease for the address.
+		"192.168.1.20": {
+			IP:     net.ParseIP("192.168.1.20"),
+			HWAddr: mac("00:11:22:33:44:55"),
+			Starts: start,
+			Ends:   start.Add(30 * time.Minute),
+			State:  "free",
+		},
+		"192.168.1.21": {
+			IP:     net.ParseIP("192.168.1.21"),
+			HWAddr: mac("66:77:88:99:aa:bb"),
+			Host:   "Café TV",
+			Starts: start,
+			State:  "active",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLeases:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	// Bad statements are skipped, and the rest of the file is read.
+	ip := net.ParseIP("192.168.1.20")
+	for _, test := range []struct {
+		in   string
+		want map[string]Lease
+	}{
+		{"lease {\n  binding state active;\n}\n", map[string]Lease{}},
+		{"lease 192.168.1.20 {\n  ;\n  binding state active;\n}\n", map[string]Lease{"192.168.1.20": {IP: ip, State: "active"}}},
+		{"lease 192.168.1.20 {\n  starts 2 2016/03/01;\n  binding state active;\n}\n", map[string]Lease{"192.168.1.20": {IP: ip, State: "active"}}},
+		{"lease 192.168.1.20 {\n  hardware token-ring 00:11:22:33:44:55;\n  binding state active;\n}\n", map[string]Lease{"192.168.1.20": {IP: ip, State: "active"}}},
+		{"lease 192.168.1.20 {\n  client-hostname laptop;\n  binding state active;\n}\n", map[string]Lease{"192.168.1.20": {IP: ip, State: "active"}}},
+	} {
+		got, err := parseLeases(strings.NewReader(test.in + "lease 192.168.1.22 {\n  binding state active;\n}\n"))
+		if err != nil {
+			t.Errorf("parseLeases(%q): %v", test.in, err)
+			continue
+		}
+		test.want["192.168.1.22"] = Lease{IP: net.ParseIP("192.168.1.22"), State: "active"}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseLeases(%q):\ngot  %+v\nwant %+v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestLeaseActive(t *testing.T) {
+	start := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		l    Lease
+		want bool
+	}{
+		{l: Lease{State: "active", Ends: start.Add(time.Hour)}, want: true},
+		{l: Lease{State: "active", Ends: start}, want: false},
+		{l: Lease{State: "active"}, want: true}, // never ends
+		{l: Lease{State: "free", Ends: start.Add(time.Hour)}, want: false},
+	}
+	for _, test := range tests {
+		if got := test.l.Active(start); got != test.want {
+			t.Errorf("%+v.Active: got %v, want %v", test.l, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+// This file finds out which DHCP server's leases file is being read.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LeaseSource is a DHCP server's leases file.
+type LeaseSource interface {
+	// File returns the path of the file, which Watch checks for changes.
+	File() string
+
+	// Leases reads all the leases in the file, by IP address.
+	Leases() (map[string]Lease, error)
+}
+
+// NewLeaseSource returns the leases file at path in the format: "isc",
+// "dnsmasq" or "kea". An empty format is detected (see Detect).
+func NewLeaseSource(format, path string) (LeaseSource, error) {
+	switch format {
+	case "":
+		return Detect(path)
+	case "isc":
+		return &ISC{Path: path}, nil
+	case "dnsmasq":
+		return &Dnsmasq{Path: path}, nil
+	case "kea":
+		return &Kea{Path: path}, nil
+	}
+	return nil, fmt.Errorf("unknown leases format %q (want isc, dnsmasq or kea)", format)
+}
+
+// Detect works out the format of the leases file at path from what is in
+// it, or (if it is empty, or doesn't exist yet) from its name. If path is
+// empty, it is the first of the servers' usual files that exists.
+func Detect(path string) (LeaseSource, error) {
+	if path == "" {
+		for _, s := range []LeaseSource{&ISC{}, &Dnsmasq{}, &Kea{}} {
+			if _, err := os.Stat(s.File()); err == nil {
+				return s, nil
+			}
+		}
+		return nil, errors.New("no leases file found in the usual places")
+	}
+	format, err := sniff(path)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		name := strings.ToLower(filepath.Base(path))
+		switch {
+		case strings.Contains(name, "dnsmasq"):
+			format = "dnsmasq"
+		case strings.Contains(name, "kea"), strings.HasSuffix(name, ".csv"):
+			format = "kea"
+		default:
+			format = "isc"
+		}
+	}
+	return NewLeaseSource(format, path)
+}
+
+// sniff returns the format of the leases file at path from its first line
+// (that isn't blank or a comment), or "" if there is none.
+func sniff(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		words := strings.Fields(line)
+		switch {
+		case strings.HasPrefix(line, "address,"):
+			return "kea", nil // the CSV header
+		case words[0] == "duid" || isDigits(words[0]):
+			return "dnsmasq", nil // "duid ..." or "<expiry> <mac> <ip> ..."
+		}
+		return "isc", nil
+	}
+	return "", sc.Err()
+}
+
+// isDigits reports whether s is all decimal digits.
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,64 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, contents string // no file if contents is empty
+		want           LeaseSource
+	}{
+		{"dhcpd.leases", testLeases, &ISC{}},
+		{"leases", testDnsmasqLeases, &Dnsmasq{}},
+		{"leases.v6", "duid 00:01\n", &Dnsmasq{}},
+		{"leases4", testKeaLeases, &Kea{}},
+		// Nothing to go on but the name.
+		{"dnsmasq.leases", "", &Dnsmasq{}},
+		{"kea-leases4.csv", "", &Kea{}},
+		{"dhcpd.leases~", "", &ISC{}},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if test.contents != "" {
+			if err := ioutil.WriteFile(path, []byte(test.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := Detect(path)
+		if err != nil {
+			t.Errorf("Detect(%s): %v", test.name, err)
+			continue
+		}
+		if reflect.TypeOf(got) != reflect.TypeOf(test.want) || got.File() != path {
+			t.Errorf("Detect(%s) = %#v, want a %T of it", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNewLeaseSource(t *testing.T) {
+	if s, err := NewLeaseSource("dnsmasq", ""); err != nil || s.File() != DnsmasqLeasesFile {
+		t.Errorf(`NewLeaseSource("dnsmasq", "") = %#v, %v`, s, err)
+	}
+	if _, err := NewLeaseSource("udhcpd", "/var/lib/misc/udhcpd.leases"); err == nil {
+		t.Error(`NewLeaseSource("udhcpd", ...): no error`)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+// This file keeps up with a leases file as the server grants leases and they
+// end. The file's directory is watched for changes (servers often replace
+// the file rather than write to it), and the file is checked every interval
+// as well: in case it can't be watched, and for leases whose time runs out
+// without the file changing.
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// settle is how long Watch waits after the file changes before reading it,
+// so that it reads the whole of a rewrite rather than part of one.
+var settle = 100 * time.Millisecond
+
+// Watch reads the leases from src now, and again whenever its file changes,
+// until ctx is done. It calls f with each lease when it becomes active or
+// changes, and again when it ends: when it is no longer active in the file,
+// is gone from the file, or runs out. Whether the lease is Active says which.
+// A lease gone from the file is passed with the State "released", or
+// "expired" if its time had run out.
+func Watch(ctx context.Context, src LeaseSource, interval time.Duration, f func(Lease)) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	path := filepath.Clean(src.File())
+	events, errs, stop := notify(path, interval)
+	defer stop()
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	w := &leaseWatcher{src: src, f: f, known: make(map[string]Lease)}
+	var settled <-chan time.Time
+	force := true
+	for {
+		w.check(time.Now(), force)
+		force = false
+	wait:
+		for {
+			select {
+			case <-tick.C:
+				break wait
+			case <-settled:
+				settled, force = nil, true
+				break wait
+			case ev, ok := <-events:
+				if !ok {
+					events = nil
+				} else if filepath.Clean(ev.Name) == path && settled == nil {
+					settled = time.After(settle)
+				}
+			case err, ok := <-errs:
+				if !ok {
+					errs = nil
+				} else {
+					log.Printf("dhcp: watching %s: %v", path, err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// notify watches the directory of the file at path, returning its events
+// and errors, which are nil if it can't be watched.
+func notify(path string, interval time.Duration) (<-chan fsnotify.Event, <-chan error, func()) {
+	nw, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = nw.Add(filepath.Dir(path)); err != nil {
+			nw.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("dhcp: can't watch %s (%v), checking it every %v", path, err, interval)
+		return nil, nil, func() {}
+	}
+	return nw.Events, nw.Errors, func() { nw.Close() }
+}
+
+// leaseWatcher is the state of Watch.
+type leaseWatcher struct {
+	src     LeaseSource
+	f       func(Lease)
+	failing bool
+	read    time.Time        // the modification time of the file last read
+	leases  map[string]Lease // as last read
+	known   map[string]Lease // the active leases f was given, by IP address
+}
+
+// check rereads the file if it has changed (or if force is set), and
+// reports the leases that began, changed or ended by now.
+func (w *leaseWatcher) check(now time.Time, force bool) {
+	path := w.src.File()
+	fi, err := os.Stat(path)
+	if force || err != nil || !fi.ModTime().Equal(w.read) {
+		if leases, err := w.src.Leases(); err != nil {
+			// Keep the leases last read, until they run out.
+			if !w.failing {
+				log.Printf("dhcp: %v", err)
+				w.failing = true
+			}
+		} else {
+			if w.failing {
+				log.Printf("dhcp: reading %s again", path)
+				w.failing = false
+			}
+			if fi != nil {
+				w.read = fi.ModTime()
+			}
+			w.leases = leases
+		}
+	}
+	w.report(now)
+}
+
+// report calls f with each lease that began or changed, and each that
+// ended, since it was last called.
+func (w *leaseWatcher) report(now time.Time) {
+	for ip, l := range w.leases {
+		if !l.Active(now) {
+			continue
+		}
+		k, ok := w.known[ip]
+		if ok && sameLease(k, l) {
+			continue
+		}
+		if ok && !bytes.Equal(k.HWAddr, l.HWAddr) {
+			// The address went to another device.
+			w.f(gone(k, now))
+		}
+		w.known[ip] = l
+		w.f(l)
+	}
+	for ip, k := range w.known {
+		l, ok := w.leases[ip]
+		if ok && l.Active(now) {
+			continue
+		}
+		if !ok {
+			l = gone(k, now)
+		}
+		delete(w.known, ip)
+		w.f(l)
+	}
+}
+
+// gone returns the lease, ended because it is no longer in the file.
+func gone(l Lease, now time.Time) Lease {
+	l.State = "released"
+	if !l.Ends.IsZero() && !now.Before(l.Ends) {
+		l.State = "expired"
+	}
+	return l
+}
+
+// sameLease reports whether a and b say the same.
+func sameLease(a, b Lease) bool {
+	return a.IP.Equal(b.IP) && bytes.Equal(a.HWAddr, b.HWAddr) && a.Host == b.Host &&
+		a.Starts.Equal(b.Starts) && a.Ends.Equal(b.Ends) && a.State == b.State
+}
@@ -0,0 +1,109 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dhcpd.leases")
+	if err := ioutil.WriteFile(path, []byte(testLeases), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	got := make(chan Lease, 10)
+	go func() {
+		Watch(ctx, &ISC{Path: path}, 10*time.Millisecond, func(l Lease) { got <- l })
+		close(got)
+	}()
+	// Only the lease that never ends is still active.
+	select {
+	case l := <-got:
+		if l.Host != "Café TV" {
+			t.Errorf("Watch: got lease %+v, want Café TV's", l)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch: no lease")
+	}
+	// The file is unchanged, so it isn't read again.
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	for l := range got {
+		t.Errorf("Watch: got %+v again for an unchanged file", l)
+	}
+}
+
+func TestLeaseWatcherReport(t *testing.T) {
+	mac := func(s string) net.HardwareAddr {
+		m, _ := net.ParseMAC(s)
+		return m
+	}
+	start := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	lease := func(ip, hw, host string, ends time.Duration) Lease {
+		return Lease{IP: net.ParseIP(ip), HWAddr: mac(hw), Host: host, Starts: start, Ends: start.Add(ends), State: "active"}
+	}
+	laptop := lease("192.168.1.20", "00:11:22:33:44:55", "laptop", time.Hour)
+	tv := lease("192.168.1.21", "66:77:88:99:aa:bb", "tv", 30*time.Minute)
+	phone := lease("192.168.1.20", "00:00:5e:00:53:01", "phone", 2*time.Hour)
+	renamed := laptop
+	renamed.Host = "work-laptop"
+
+	type report struct {
+		host   string
+		active bool
+		state  string
+	}
+	tests := []struct {
+		name   string
+		leases []Lease
+		after  time.Duration // since start
+		want   []report
+	}{
+		{"granted", []Lease{laptop}, time.Minute, []report{{"laptop", true, "active"}}},
+		{"another", []Lease{laptop, tv}, time.Minute, []report{{"tv", true, "active"}}},
+		{"unchanged", []Lease{laptop, tv}, 2 * time.Minute, nil},
+		{"renamed", []Lease{renamed, tv}, 3 * time.Minute, []report{{"work-laptop", true, "active"}}},
+		{"ran out", []Lease{renamed, tv}, 45 * time.Minute, []report{{"tv", false, "active"}}},
+		{"reassigned", []Lease{phone}, 50 * time.Minute, []report{{"work-laptop", false, "released"}, {"phone", true, "active"}}},
+		{"removed", nil, 3 * time.Hour, []report{{"phone", false, "expired"}}},
+	}
+	w := &leaseWatcher{known: make(map[string]Lease)}
+	for _, test := range tests {
+		var got []report
+		now := start.Add(test.after)
+		w.f = func(l Lease) { got = append(got, report{l.Host, l.Active(now), l.State}) }
+		w.leases = make(map[string]Lease)
+		for _, l := range test.leases {
+			w.leases[l.IP.String()] = l
+		}
+		w.report(now)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
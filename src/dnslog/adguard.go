@@ -0,0 +1,100 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnslog
+
+// This file reads AdGuard Home's query log, through its API.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// adguardLimit is how many entries are asked for at a time.
+const adguardLimit = 500
+
+// AdGuard reads the query log of an AdGuard Home server.
+type AdGuard struct {
+	URL                string // e.g. http://192.168.1.2:3000/
+	Username, Password string
+}
+
+// Entries returns the queries after since.
+func (a *AdGuard) Entries(ctx context.Context, since time.Time) ([]Entry, error) {
+	u := fmt.Sprintf("%s/control/querylog?limit=%d", strings.TrimRight(a.URL, "/"), adguardLimit)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if a.Username != "" {
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("AdGuard Home: %s", resp.Status)
+	}
+	var body struct {
+		Data []struct {
+			Time     time.Time `json:"time"`
+			Client   string    `json:"client"`
+			Reason   string    `json:"reason"`
+			Question struct {
+				Name string `json:"name"`
+			} `json:"question"`
+			Answer []struct {
+				Type  string `json:"type"`
+				Value string `json:"value"`
+			} `json:"answer"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("AdGuard Home: %v", err)
+	}
+
+	// The log is newest first.
+	var entries []Entry
+	for i := len(body.Data) - 1; i >= 0; i-- {
+		d := body.Data[i]
+		e := Entry{
+			Time:   d.Time,
+			Client: net.ParseIP(d.Client),
+			Domain: d.Question.Name,
+			// e.g. FilteredBlackList; but not NotFilteredAllowList, or
+			// FilteredSafeSearch, which answers with a safe address.
+			Blocked: strings.HasPrefix(d.Reason, "Filtered") && d.Reason != "FilteredSafeSearch",
+		}
+		if !e.Time.After(since) || e.Client == nil {
+			continue
+		}
+		for _, ans := range d.Answer {
+			if ans.Type != "A" && ans.Type != "AAAA" {
+				continue
+			}
+			if ip := net.ParseIP(ans.Value); ip != nil {
+				e.IPs = append(e.IPs, ip)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
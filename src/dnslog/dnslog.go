@@ -0,0 +1,91 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dnslog reads the query log of a local DNS server (Pi-hole or
+// AdGuard Home), so caplog can name traffic whose DNS it can't see, such
+// as lookups over DNS-over-TLS to the server.
+package dnslog
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+)
+
+// Entry is a query in the log.
+type Entry struct {
+	Time    time.Time
+	Client  net.IP
+	Domain  string
+	IPs     []net.IP // the addresses in the answer, if known
+	Blocked bool
+}
+
+// Source is a DNS server's query log.
+type Source interface {
+	// Entries returns the queries made after since, oldest first.
+	Entries(ctx context.Context, since time.Time) ([]Entry, error)
+}
+
+// DefaultInterval is how often Poll reads the log if interval is zero.
+const DefaultInterval = 10 * time.Second
+
+// Poll reads new entries from the source every interval, calling f with
+// each, until ctx is done. Entries from before Poll is called are skipped.
+func Poll(ctx context.Context, src Source, interval time.Duration, f func(Entry)) {
+	poll(ctx, src, interval, time.Now(), false, f)
+}
+
+// PollFrom is Poll, except that the entries after since are read straight
+// away, such as the lookups made shortly before caplog started.
+func PollFrom(ctx context.Context, src Source, interval time.Duration, since time.Time, f func(Entry)) {
+	poll(ctx, src, interval, since, true, f)
+}
+
+func poll(ctx context.Context, src Source, interval time.Duration, since time.Time, now bool, f func(Entry)) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	failing := false
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for first := now; ; first = false {
+		if !first {
+			select {
+			case <-tick.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+		entries, err := src.Entries(ctx, since)
+		if err != nil {
+			if !failing {
+				log.Printf("dnslog: %v", err)
+				failing = true
+			}
+			continue
+		}
+		if failing {
+			log.Print("dnslog: reading the query log again")
+			failing = false
+		}
+		for _, e := range entries {
+			if e.Time.After(since) {
+				since = e.Time
+			}
+			f(e)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnslog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPiHole(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/api.php" || r.FormValue("auth") != "token" {
+			fmt.Fprint(w, "[]")
+			return
+		}
+		fmt.Fprint(w, `{"data":[
+			["1456833600","A","old.example.com","192.168.1.10","2","0","3","12","N/A","-1","","",""],
+			["1456833601","A","example.com","192.168.1.10","2","0","3","12","N/A","-1","","",""],
+			["1456833602","AAAA","ads.example.net","192.168.1.11","1","0","4","1","N/A","-1","","",""]
+		]}`)
+	}))
+	defer srv.Close()
+
+	p := &PiHole{URL: srv.URL, Token: "token"}
+	got, err := p.Entries(context.Background(), time.Unix(1456833600, 0))
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	want := []Entry{
+		{Time: time.Unix(1456833601, 0), Client: net.ParseIP("192.168.1.10"), Domain: "example.com"},
+		{Time: time.Unix(1456833602, 0), Client: net.ParseIP("192.168.1.11"), Domain: "ads.example.net", Blocked: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	p.Token = "wrong"
+	if _, err := p.Entries(context.Background(), time.Time{}); err == nil {
+		t.Error("Entries with the wrong token: got nil error")
+	}
+}
+
+func TestAdGuard(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, _ := r.BasicAuth(); u != "admin" || p != "secret" {
+			http.Error(w, "no", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"data":[
+			{"answer":[],"client":"192.168.1.11","question":{"class":"IN","name":"ads.example.net","type":"A"},"reason":"FilteredBlackList","time":"2016-03-01T12:00:02Z"},
+			{"answer":[{"type":"CNAME","value":"cdn.example.com.","ttl":60},{"type":"A","value":"203.0.113.9","ttl":60}],"client":"192.168.1.10","question":{"class":"IN","name":"example.com","type":"A"},"reason":"NotFilteredNotFound","time":"2016-03-01T12:00:01Z"},
+			{"answer":[],"client":"192.168.1.10","question":{"class":"IN","name":"old.example.com","type":"A"},"reason":"NotFilteredNotFound","time":"2016-03-01T12:00:00Z"}
+		],"oldest":"2016-03-01T12:00:00Z"}`)
+	}))
+	defer srv.Close()
+
+	a := &AdGuard{URL: srv.URL + "/", Username: "admin", Password: "secret"}
+	got, err := a.Entries(context.Background(), time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	want := []Entry{
+		{Time: time.Date(2016, 3, 1, 12, 0, 1, 0, time.UTC), Client: net.ParseIP("192.168.1.10"), Domain: "example.com", IPs: []net.IP{net.ParseIP("203.0.113.9")}},
+		{Time: time.Date(2016, 3, 1, 12, 0, 2, 0, time.UTC), Client: net.ParseIP("192.168.1.11"), Domain: "ads.example.net", Blocked: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+// fakeSource returns its entries after since.
+type fakeSource []Entry
+
+func (f fakeSource) Entries(ctx context.Context, since time.Time) ([]Entry, error) {
+	var es []Entry
+	for _, e := range f {
+		if e.Time.After(since) {
+			es = append(es, e)
+		}
+	}
+	return es, nil
+}
+
+func TestPollFrom(t *testing.T) {
+	now := time.Now()
+	src := fakeSource{
+		{Time: now.Add(-2 * time.Hour), Domain: "too-old.example.com"},
+		{Time: now.Add(-time.Minute), Domain: "example.com"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan string, len(src))
+	// The interval is long, so only the first, immediate read happens.
+	go PollFrom(ctx, src, time.Hour, now.Add(-time.Hour), func(e Entry) { got <- e.Domain })
+	select {
+	case d := <-got:
+		if d != "example.com" {
+			t.Errorf("PollFrom: got %s, want example.com", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("PollFrom: nothing read")
+	}
+}
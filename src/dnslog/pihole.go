@@ -0,0 +1,142 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnslog
+
+// This file reads Pi-hole's query log, through its (v5) API.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// piholeBlocked are the statuses of blocked queries in Pi-hole's FTL:
+// gravity, regex, blacklist, upstream-blocked and CNAME-inspection blocks.
+var piholeBlocked = map[string]bool{
+	"1": true, "4": true, "5": true, "6": true, "7": true, "8": true,
+	"9": true, "10": true, "11": true, "15": true, "16": true,
+}
+
+// PiHole reads the query log of a Pi-hole. The log doesn't include the
+// answers, so allowed A and AAAA queries are looked up again through the
+// Pi-hole's own DNS server (which answers from its cache), if Resolver is
+// set.
+type PiHole struct {
+	URL   string // e.g. http://pi.hole/
+	Token string // the API token, from Settings > API
+
+	Resolver *net.Resolver
+}
+
+// NewPiHole returns a source for the Pi-hole at the URL, which looks up
+// answers through the DNS server on the same host.
+func NewPiHole(base, token string) (*PiHole, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("no host in %q", base)
+	}
+	server := net.JoinHostPort(u.Hostname(), "53")
+	return &PiHole{
+		URL:   base,
+		Token: token,
+		Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		},
+	}, nil
+}
+
+// Entries returns the queries after since.
+func (p *PiHole) Entries(ctx context.Context, since time.Time) ([]Entry, error) {
+	q := url.Values{
+		"getAllQueries": {""},
+		"from":          {strconv.FormatInt(since.Unix(), 10)},
+		"until":         {strconv.FormatInt(time.Now().Unix()+1, 10)},
+		"auth":          {p.Token},
+	}
+	u := strings.TrimRight(p.URL, "/") + "/admin/api.php?" + q.Encode()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Pi-hole: %s", resp.Status)
+	}
+	var body struct {
+		Data [][]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		// Pi-hole answers [] when the token is wrong.
+		return nil, fmt.Errorf("Pi-hole: %v (is the token right?)", err)
+	}
+
+	var entries []Entry
+	answers := make(map[string][]net.IP) // lookups this time
+	for _, row := range body.Data {
+		// [time, type, domain, client, status, ...], mostly strings.
+		if len(row) < 5 {
+			continue
+		}
+		f := make([]string, 5)
+		for i := range f {
+			f[i] = fmt.Sprint(row[i])
+		}
+		secs, err := strconv.ParseInt(f[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		e := Entry{
+			Time:    time.Unix(secs, 0),
+			Client:  net.ParseIP(f[3]),
+			Domain:  f[2],
+			Blocked: piholeBlocked[f[4]],
+		}
+		if !e.Time.After(since) || e.Client == nil {
+			continue
+		}
+		if !e.Blocked && p.Resolver != nil && (f[1] == "A" || f[1] == "AAAA") {
+			ips, ok := answers[e.Domain]
+			if !ok {
+				addrs, err := p.Resolver.LookupIPAddr(ctx, e.Domain)
+				if err == nil {
+					for _, a := range addrs {
+						ips = append(ips, a.IP)
+					}
+				}
+				answers[e.Domain] = ips
+			}
+			e.IPs = ips
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+// This file formats events in ArcSight's Common Event Format (CEF) and
+// QRadar's Log Event Extended Format (LEEF), so SIEMs can take them without
+// custom parsers.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	vendor         = "Google"
+	product        = "caplog"
+	productVersion = "1"
+)
+
+// cefKeys maps event fields to CEF's standard extension keys. Other fields
+// go in the custom string extensions, cs1 to cs6 (and any beyond six are
+// left out).
+var cefKeys = map[string]string{
+	"probe":    "dvchost",
+	"sink":     "destinationServiceName",
+	"points":   "cnt",
+	"src_ip":   "src",
+	"dst_ip":   "dst",
+	"src_port": "spt",
+	"dst_port": "dpt",
+	"src_name": "shost",
+	"dst_name": "dhost",
+	"bytes":    "out",
+}
+
+// leefKeys maps event fields to LEEF's predefined attributes. Other fields
+// keep their names, for QRadar custom properties.
+var leefKeys = map[string]string{
+	"src_ip":   "src",
+	"dst_ip":   "dst",
+	"src_port": "srcPort",
+	"dst_port": "dstPort",
+	"src_name": "srcName",
+	"dst_name": "dstName",
+	"bytes":    "srcBytes",
+	"probe":    "identHostName",
+}
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+	leefEscaper      = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ", "|", `\|`)
+)
+
+// Score maps a severity onto the 0-10 scale CEF and LEEF use, 10 being the
+// most severe.
+func (s Severity) Score() int {
+	switch s {
+	case Emergency:
+		return 10
+	case Alert:
+		return 9
+	case Critical:
+		return 8
+	case Error:
+		return 7
+	case Warning:
+		return 5
+	case Notice:
+		return 3
+	case Info:
+		return 1
+	}
+	return 0
+}
+
+// sortedFields returns the event's field names in order.
+func sortedFields(e Event) []string {
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CEF formats an event as a CEF:0 record.
+func CEF(e Event) string {
+	var ext []string
+	add := func(k, v string) { ext = append(ext, k+"="+cefValueEscaper.Replace(v)) }
+	add("rt", fmt.Sprint(e.Time.UnixNano()/1e6))
+	if e.Message != "" {
+		add("msg", e.Message)
+	}
+	custom := 0
+	for _, k := range sortedFields(e) {
+		if key, ok := cefKeys[k]; ok {
+			add(key, e.Fields[k])
+			continue
+		}
+		if custom++; custom > 6 {
+			continue
+		}
+		add(fmt.Sprintf("cs%d", custom), e.Fields[k])
+		add(fmt.Sprintf("cs%dLabel", custom), k)
+	}
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		vendor, product, productVersion,
+		cefHeaderEscaper.Replace(e.Type), cefHeaderEscaper.Replace(e.Type),
+		e.Severity.Score(), strings.Join(ext, " "))
+}
+
+// LEEF formats an event as a LEEF:2.0 record, with tab-separated attributes.
+func LEEF(e Event) string {
+	sev := e.Severity.Score()
+	if sev < 1 {
+		sev = 1
+	}
+	attrs := []string{
+		fmt.Sprintf("devTime=%d", e.Time.UnixNano()/1e6),
+		"cat=" + leefEscaper.Replace(e.Type),
+		fmt.Sprintf("sev=%d", sev),
+	}
+	if e.Message != "" {
+		attrs = append(attrs, "msg="+leefEscaper.Replace(e.Message))
+	}
+	for _, k := range sortedFields(e) {
+		key := k
+		if lk, ok := leefKeys[k]; ok {
+			key = lk
+		}
+		attrs = append(attrs, key+"="+leefEscaper.Replace(e.Fields[k]))
+	}
+	return fmt.Sprintf("LEEF:2.0|%s|%s|%s|%s|x09|%s",
+		vendor, product, productVersion, leefEscaper.Replace(e.Type), strings.Join(attrs, "\t"))
+}
+
+// Formatter returns the formatter for a format name: "cef", "leef", or ""
+// for none (plain syslog, or JSON in files).
+func Formatter(name string) (func(Event) string, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "cef":
+		return CEF, nil
+	case "leef":
+		return LEEF, nil
+	}
+	return nil, fmt.Errorf("unknown event format %q (want cef or leef)", name)
+}
@@ -0,0 +1,56 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCEF(t *testing.T) {
+	at := time.Unix(1456833600, 0)
+	tests := []struct {
+		e    Event
+		want string
+	}{
+		{
+			Event{Time: at, Type: "sink-dropped", Severity: Warning, Message: "a=b", Fields: map[string]string{"sink": "influx", "points": "10"}},
+			`CEF:0|Google|caplog|1|sink-dropped|sink-dropped|5|rt=1456833600000 msg=a\=b cnt=10 destinationServiceName=influx`,
+		},
+		{
+			Event{Time: at, Type: "a|b", Severity: Debug, Fields: map[string]string{"level": "2"}},
+			`CEF:0|Google|caplog|1|a\|b|a\|b|0|rt=1456833600000 cs1=2 cs1Label=level`,
+		},
+	}
+	for _, test := range tests {
+		if got := CEF(test.e); got != test.want {
+			t.Errorf("CEF(%v):\ngot  %s\nwant %s", test.e, got, test.want)
+		}
+	}
+}
+
+func TestLEEF(t *testing.T) {
+	e := Event{
+		Time:     time.Unix(1456833600, 0),
+		Type:     "probe-skew",
+		Severity: Warning,
+		Message:  "off\tby 3s",
+		Fields:   map[string]string{"probe": "attic", "skew": "3s"},
+	}
+	want := "LEEF:2.0|Google|caplog|1|probe-skew|x09|devTime=1456833600000\tcat=probe-skew\tsev=5\tmsg=off by 3s\tidentHostName=attic\tskew=3s"
+	if got := LEEF(e); got != want {
+		t.Errorf("LEEF(%v):\ngot  %q\nwant %q", e, got, want)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package events carries notable things that happen in caplog (a probe's
+// clock going wrong, an output failing, load shedding...) to whatever wants
+// to hear about them, such as a syslog server.
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"vars"
+)
+
+// Severity is how serious an event is. The values are syslog's.
+type Severity int
+
+// Severities.
+const (
+	Emergency Severity = iota
+	Alert
+	Critical
+	Error
+	Warning
+	Notice
+	Info
+	Debug
+)
+
+var severityNames = []string{"emergency", "alert", "critical", "error", "warning", "notice", "info", "debug"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return "unknown"
+	}
+	return severityNames[s]
+}
+
+// MarshalText encodes the severity by name.
+func (s Severity) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// ParseSeverity parses a severity name.
+func ParseSeverity(name string) (Severity, bool) {
+	for i, n := range severityNames {
+		if n == name {
+			return Severity(i), true
+		}
+	}
+	return 0, false
+}
+
+// Event is something that happened.
+type Event struct {
+	Time     time.Time
+	Type     string // short and stable, e.g. "probe-skew"
+	Severity Severity
+	Message  string
+	Fields   map[string]string `json:",omitempty"`
+}
+
+const (
+	// subscriberBuffer is how many events each subscriber can fall behind
+	// by before events are dropped.
+	subscriberBuffer = 100
+
+	// recentEvents is how many events are kept for /api/events.
+	recentEvents = 100
+)
+
+var (
+	mu          sync.Mutex
+	subscribers []chan Event
+	recent      []Event
+	dropped     uint64
+)
+
+func init() {
+	vars.Register("events-dropped", vars.Uint64Eval(func() uint64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return dropped
+	}).String)
+}
+
+// Publish sends an event to every subscriber. It never blocks: a subscriber
+// that has fallen too far behind misses the event.
+func Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	recent = append(recent, e)
+	if len(recent) > recentEvents {
+		recent = recent[len(recent)-recentEvents:]
+	}
+	for _, ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+			dropped++
+		}
+	}
+}
+
+// Subscribe calls f with each event published from now on, in order, from
+// its own goroutine.
+func Subscribe(f func(Event)) {
+	ch := make(chan Event, subscriberBuffer)
+	mu.Lock()
+	subscribers = append(subscribers, ch)
+	mu.Unlock()
+	go func() {
+		for e := range ch {
+			f(e)
+		}
+	}()
+}
+
+// Recent returns the most recent events, oldest first.
+func Recent() []Event {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Event(nil), recent...)
+}
+
+func handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(Recent()); err != nil {
+		log.Print("events failed to write:", err)
+	}
+}
+
+// RegisterHandler adds a HTTP handler for /api/events, the recent events.
+func RegisterHandler() {
+	http.HandleFunc("/api/events", handler)
+}
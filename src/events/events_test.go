@@ -0,0 +1,124 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	w := &SyslogWriter{
+		Facility:   16,
+		Severities: map[string]Severity{"loud": Critical},
+		Hostname:   "router",
+	}
+	at := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		e    Event
+		want string
+	}{
+		{
+			Event{Time: at, Type: "throttle", Severity: Warning, Message: "slow down"},
+			`<132>1 2016-03-01T12:00:00.000000Z router caplog PID throttle - slow down`,
+		},
+		{
+			Event{Time: at, Type: "loud", Severity: Info, Fields: map[string]string{"b": `a"]\`, "a": "1"}},
+			`<130>1 2016-03-01T12:00:00.000000Z router caplog PID loud [caplog@11129 a="1" b="a\"\]\\"]`,
+		},
+	}
+	for _, test := range tests {
+		got := string(w.Format(test.e))
+		// The PID varies.
+		f := strings.Fields(got)
+		f[4] = "PID"
+		if got := strings.Join(f, " "); got != test.want {
+			t.Errorf("Format(%v): got %q, want %q", test.e, got, test.want)
+		}
+	}
+}
+
+func TestParseSyslogURL(t *testing.T) {
+	tests := []struct {
+		url, network, addr string
+		ok                 bool
+	}{
+		{"udp://logs", "udp", "logs:514", true},
+		{"tls://logs", "tls", "logs:6514", true},
+		{"tcp://logs:1514", "tcp", "logs:1514", true},
+		{"http://logs", "", "", false},
+		{"udp://", "", "", false},
+	}
+	for _, test := range tests {
+		w, err := ParseSyslogURL(test.url)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseSyslogURL(%q): got error %v, want ok %v", test.url, err, test.ok)
+			continue
+		}
+		if err == nil && (w.Network != test.network || w.Address != test.addr) {
+			t.Errorf("ParseSyslogURL(%q): got %s %s, want %s %s", test.url, w.Network, w.Address, test.network, test.addr)
+		}
+	}
+}
+
+func TestWriteTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	got := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		line, _ := bufio.NewReader(c).ReadString(' ')
+		got <- line
+	}()
+
+	w := &SyslogWriter{Network: "tcp", Address: l.Addr().String()}
+	e := Event{Type: "test", Message: "hi"}
+	if err := w.Write(e); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	// Octet-counting framing: the length, then a space.
+	want := strconv.Itoa(len(w.Format(e))) + " "
+	if g := <-got; g != want {
+		t.Errorf("frame header: got %q, want %q", g, want)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	got := make(chan Event, 1)
+	Subscribe(func(e Event) { got <- e })
+	Publish(Event{Type: "test"})
+	select {
+	case e := <-got:
+		if e.Type != "test" || e.Time.IsZero() {
+			t.Errorf("Subscribe: got %+v, want type test with a time", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe: no event")
+	}
+	if r := Recent(); len(r) == 0 || r[len(r)-1].Type != "test" {
+		t.Errorf("Recent: got %v, want the test event last", r)
+	}
+}
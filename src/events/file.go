@@ -0,0 +1,59 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+// This file appends events to a file, one per line.
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// FileWriter appends events to a file, as JSON or in a SIEM format.
+type FileWriter struct {
+	Path string
+
+	// Body, if set, formats each line (e.g. CEF). The default is JSON.
+	Body func(Event) string
+
+	mu sync.Mutex
+}
+
+// Write appends an event. The file is opened for each event, so it can be
+// rotated underneath.
+func (w *FileWriter) Write(e Event) error {
+	var line []byte
+	if w.Body != nil {
+		line = []byte(w.Body(e))
+	} else {
+		var err error
+		if line, err = json.Marshal(e); err != nil {
+			return err
+		}
+	}
+	line = append(line, '\n')
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f, err := os.OpenFile(w.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,185 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+// This file sends events to a syslog server, as RFC 5424 messages over UDP,
+// TCP or TLS (RFC 5425 framing).
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sdID is the structured data ID for event fields, under Google's
+// enterprise number.
+const sdID = "caplog@11129"
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// ParseFacility parses a syslog facility name, such as "local0".
+func ParseFacility(name string) (int, bool) {
+	f, ok := facilities[name]
+	return f, ok
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// SyslogWriter sends events to a syslog server.
+type SyslogWriter struct {
+	Network string // "udp", "tcp" or "tls"
+	Address string
+
+	// Facility is the syslog facility (default 16, local0).
+	Facility int
+
+	// Severities overrides the severity of events, by type.
+	Severities map[string]Severity
+
+	// Hostname and AppName identify the sender (default: the hostname,
+	// and "caplog").
+	Hostname, AppName string
+
+	// TLSConfig is used for "tls" (default: verify the server).
+	TLSConfig *tls.Config
+
+	// Body, if set, formats the message (e.g. CEF), which then carries
+	// the event's fields instead of the structured data.
+	Body func(Event) string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// ParseSyslogURL makes a SyslogWriter from a URL such as
+// udp://logs.example.com:514 or tls://logs.example.com:6514.
+func ParseSyslogURL(s string) (*SyslogWriter, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("unsupported syslog transport %q (want udp, tcp or tls)", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("syslog URL %q has no host", s)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "514"
+		if u.Scheme == "tls" {
+			port = "6514"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	return &SyslogWriter{Network: u.Scheme, Address: addr, Facility: facilities["local0"]}, nil
+}
+
+// Format returns the event as an RFC 5424 message, without framing.
+func (w *SyslogWriter) Format(e Event) []byte {
+	sev := e.Severity
+	if s, ok := w.Severities[e.Type]; ok {
+		sev = s
+	}
+	host, app := w.Hostname, w.AppName
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	if app == "" {
+		app = "caplog"
+	}
+	msgID := e.Type
+	if msgID == "" {
+		msgID = "-"
+	}
+	b := []byte(fmt.Sprintf("<%d>1 %s %s %s %d %s ",
+		w.Facility*8+int(sev), e.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		nilValue(host), app, os.Getpid(), msgID))
+	if len(e.Fields) == 0 || w.Body != nil {
+		b = append(b, '-')
+	} else {
+		b = append(b, '[')
+		b = append(b, sdID...)
+		for _, k := range sortedFields(e) {
+			b = append(b, fmt.Sprintf(` %s="%s"`, k, sdEscaper.Replace(e.Fields[k]))...)
+		}
+		b = append(b, ']')
+	}
+	msg := e.Message
+	if w.Body != nil {
+		msg = w.Body(e)
+	}
+	if msg != "" {
+		b = append(b, ' ')
+		b = append(b, msg...)
+	}
+	return b
+}
+
+func nilValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Write sends an event, connecting (or reconnecting) if need be.
+func (w *SyslogWriter) Write(e Event) error {
+	return w.send(w.Format(e))
+}
+
+// send sends a formatted message.
+func (w *SyslogWriter) send(msg []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		var err error
+		switch w.Network {
+		case "tls":
+			d := &net.Dialer{Timeout: 10 * time.Second}
+			w.conn, err = tls.DialWithDialer(d, "tcp", w.Address, w.TLSConfig)
+		default:
+			w.conn, err = net.DialTimeout(w.Network, w.Address, 10*time.Second)
+		}
+		if err != nil {
+			w.conn = nil
+			return err
+		}
+	}
+	if w.Network != "udp" {
+		// Octet counting, so messages may contain newlines.
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := w.conn.Write(msg); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return err
+	}
+	return nil
+}
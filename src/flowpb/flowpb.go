@@ -0,0 +1,176 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package flowpb has Go bindings for the caplog.v1 schema in caplog.proto.
+//
+// The message types in caplog.pb.go are generated from caplog.proto by
+// protogen, which uses package protowire for the wire format rather than the
+// protobuf runtime. They are wire-compatible with code generated from
+// caplog.proto for other languages. After changing caplog.proto, run
+// "go generate flowpb"; CI checks that caplog.pb.go is up to date. This file
+// has the conversions to and from package packets.
+package flowpb
+
+//go:generate go run protogen -o caplog.pb.go caplog.proto
+
+import (
+	"net"
+	"time"
+
+	"packets"
+)
+
+// ipBytes returns the shortest form of an IP address.
+func ipBytes(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// FromMetadata converts a packets.Metadata.
+func FromMetadata(m *packets.Metadata) Metadata {
+	return Metadata{
+		TimestampNs: m.Timestamp.UnixNano(),
+		Size:        m.Size,
+		SrcName:     m.SrcName,
+		DstName:     m.DstName,
+		SrcIP:       ipBytes(m.SrcIP),
+		DstIP:       ipBytes(m.DstIP),
+		SrcPort:     uint32(m.SrcPort),
+		DstPort:     uint32(m.DstPort),
+		V6:          m.V6,
+		Protocol:    uint32(m.Protocol),
+		ICMPType:    uint32(m.ICMPType),
+		ICMPCode:    uint32(m.ICMPCode),
+		VLAN:        uint32(m.VLAN),
+		AppProtocol: m.AppProtocol,
+		SrcMAC:      macBytes(m.SrcMAC),
+		DstMAC:      macBytes(m.DstMAC),
+		Segments:    m.Segments,
+	}
+}
+
+// macBytes parses a MAC address, which is nil if there is none.
+func macBytes(s string) []byte {
+	mac, _ := net.ParseMAC(s)
+	return mac
+}
+
+// macString formats a MAC address, which is "" if there is none.
+func macString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return net.HardwareAddr(b).String()
+}
+
+// ToMetadata converts back to a packets.Metadata.
+func (m *Metadata) ToMetadata() packets.Metadata {
+	return packets.Metadata{
+		Timestamp:   time.Unix(0, m.TimestampNs),
+		Size:        m.Size,
+		SrcName:     m.SrcName,
+		DstName:     m.DstName,
+		SrcIP:       net.IP(m.SrcIP),
+		DstIP:       net.IP(m.DstIP),
+		SrcPort:     uint16(m.SrcPort),
+		DstPort:     uint16(m.DstPort),
+		V6:          m.V6,
+		Protocol:    uint8(m.Protocol),
+		ICMPType:    uint8(m.ICMPType),
+		ICMPCode:    uint8(m.ICMPCode),
+		VLAN:        uint16(m.VLAN),
+		AppProtocol: m.AppProtocol,
+		SrcMAC:      macString(m.SrcMAC),
+		DstMAC:      macString(m.DstMAC),
+		Segments:    m.Segments,
+	}
+}
+
+// FromFlow converts a packets.Flow.
+func FromFlow(f *packets.Flow) Flow {
+	return Flow{
+		StartNs:         f.Start.UnixNano(),
+		EndNs:           f.End.UnixNano(),
+		SrcIP:           ipBytes(f.SrcIP),
+		DstIP:           ipBytes(f.DstIP),
+		SrcPort:         uint32(f.SrcPort),
+		DstPort:         uint32(f.DstPort),
+		Protocol:        uint32(f.Protocol),
+		SrcName:         f.SrcName,
+		DstName:         f.DstName,
+		BytesUp:         f.SrcBytes,
+		BytesDown:       f.DstBytes,
+		PacketsUp:       f.SrcPackets,
+		PacketsDown:     f.DstPackets,
+		V6:              f.V6,
+		VLAN:            uint32(f.VLAN),
+		AppProtocol:     f.AppProtocol,
+		ICMPType:        uint32(f.ICMPType),
+		ICMPCode:        uint32(f.ICMPCode),
+		Reason:          f.Reason,
+		HandshakeRTTNs:  int64(f.HandshakeRTT),
+		SrcRTTNs:        int64(f.SrcRTT),
+		DstRTTNs:        int64(f.DstRTT),
+		MinSrcRTTNs:     int64(f.MinSrcRTT),
+		MinDstRTTNs:     int64(f.MinDstRTT),
+		Retransmissions: f.Retransmissions,
+		OutOfOrder:      f.OutOfOrder,
+		DupAcks:         f.DupAcks,
+		Named:           f.Named,
+		NameDelayNs:     int64(f.NameDelay),
+		Alerts:          uint32(f.Alerts),
+	}
+}
+
+// ToFlow converts back to a packets.Flow.
+func (f *Flow) ToFlow() packets.Flow {
+	return packets.Flow{
+		Start:       time.Unix(0, f.StartNs),
+		End:         time.Unix(0, f.EndNs),
+		SrcName:     f.SrcName,
+		DstName:     f.DstName,
+		SrcIP:       net.IP(f.SrcIP),
+		DstIP:       net.IP(f.DstIP),
+		SrcPort:     uint16(f.SrcPort),
+		DstPort:     uint16(f.DstPort),
+		V6:          f.V6,
+		Protocol:    uint8(f.Protocol),
+		VLAN:        uint16(f.VLAN),
+		AppProtocol: f.AppProtocol,
+		ICMPType:    uint8(f.ICMPType),
+		ICMPCode:    uint8(f.ICMPCode),
+		Named:       f.Named,
+		NameDelay:   time.Duration(f.NameDelayNs),
+		Alerts:      int(f.Alerts),
+		SrcPackets:  f.PacketsUp,
+		SrcBytes:    f.BytesUp,
+		DstPackets:  f.PacketsDown,
+		DstBytes:    f.BytesDown,
+		TCPQuality: packets.TCPQuality{
+			Retransmissions: f.Retransmissions,
+			OutOfOrder:      f.OutOfOrder,
+			DupAcks:         f.DupAcks,
+		},
+		FlowRTT: packets.FlowRTT{
+			HandshakeRTT: time.Duration(f.HandshakeRTTNs),
+			SrcRTT:       time.Duration(f.SrcRTTNs),
+			DstRTT:       time.Duration(f.DstRTTNs),
+			MinSrcRTT:    time.Duration(f.MinSrcRTTNs),
+			MinDstRTT:    time.Duration(f.MinDstRTTNs),
+		},
+		Reason: f.Reason,
+	}
+}
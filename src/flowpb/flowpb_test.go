@@ -0,0 +1,166 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flowpb
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"packets"
+)
+
+func TestBatchRoundTrip(t *testing.T) {
+	want := Batch{
+		Sequence: 42,
+		ProbeID:  "router",
+		DedupKey: "router/out/7",
+		SentNs:   1434055563000000000,
+		Packets: []Metadata{{
+			TimestampNs: 1434055562000000000,
+			Size:        74,
+			SrcName:     "laptop",
+			DstName:     "dns.google",
+			SrcIP:       []byte{10, 0, 0, 2},
+			DstIP:       []byte{8, 8, 8, 8},
+			SrcPort:     5353,
+			DstPort:     53,
+			V6:          true,
+			Protocol:    17,
+			VLAN:        42,
+			AppProtocol: "quic",
+			SrcMAC:      []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55},
+			Segments:    44,
+		}, {
+			TimestampNs: 1434055562000000001,
+			Size:        98,
+			SrcIP:       []byte{10, 0, 0, 2},
+			DstIP:       []byte{8, 8, 8, 8},
+			Protocol:    1,
+			ICMPType:    3,
+			ICMPCode:    1,
+		}},
+		Flows: []Flow{{
+			StartNs: 1, EndNs: 2,
+			SrcIP: []byte{10, 0, 0, 2}, DstIP: []byte{8, 8, 4, 4},
+			SrcPort: 3, DstPort: 4, Protocol: 6,
+			SrcName: "a", DstName: "b",
+			BytesUp: 5, BytesDown: 6, PacketsUp: 7, PacketsDown: 8,
+			V6: true, VLAN: 9, AppProtocol: "quic", ICMPType: 10, ICMPCode: 11,
+			Reason: "fin", HandshakeRTTNs: 12, SrcRTTNs: 13, DstRTTNs: 14,
+			MinSrcRTTNs: 15, MinDstRTTNs: 16, Retransmissions: 17,
+			OutOfOrder: 18, DupAcks: 19, Named: "later", NameDelayNs: 20,
+			Alerts: 21,
+		}},
+		Events: []Event{{
+			TimestampNs: 3,
+			Type:        "new-device",
+			Severity:    "info",
+			Message:     "hello",
+			Fields:      map[string]string{"mac": "00:11:22:33:44:55", "ip": "10.0.0.9"},
+		}},
+	}
+	var got Batch
+	if err := got.Unmarshal(want.Marshal(nil)); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestMetadataKnownEncoding(t *testing.T) {
+	m := Metadata{Size: 1, SrcPort: 443, V6: true}
+	// size=1, src_port=443, v6=true.
+	want := []byte{0x10, 0x01, 0x38, 0xbb, 0x03, 0x48, 0x01}
+	if got := m.Marshal(nil); !bytes.Equal(got, want) {
+		t.Errorf("Marshal: got % x, want % x", got, want)
+	}
+}
+
+func TestFromMetadata(t *testing.T) {
+	pm := packets.Metadata{
+		Timestamp: time.Unix(1, 2),
+		Size:      3,
+		SrcIP:     net.ParseIP("10.0.0.1"),
+		DstIP:     net.ParseIP("2001:db8::1"),
+		SrcPort:   4,
+		DstPort:   5,
+		SrcMAC:    "00:11:22:33:44:55",
+		Segments:  6,
+	}
+	m := FromMetadata(&pm)
+	if len(m.SrcIP) != 4 || len(m.DstIP) != 16 {
+		t.Errorf("FromMetadata: IP lengths %d, %d, want 4, 16", len(m.SrcIP), len(m.DstIP))
+	}
+	back := m.ToMetadata()
+	if !back.Timestamp.Equal(pm.Timestamp) || !back.SrcIP.Equal(pm.SrcIP) || !back.DstIP.Equal(pm.DstIP) || back.DstPort != 5 ||
+		back.SrcMAC != pm.SrcMAC || back.DstMAC != "" || back.Segments != 6 {
+		t.Errorf("ToMetadata(FromMetadata(%+v)) = %+v", pm, back)
+	}
+}
+
+func TestFromFlow(t *testing.T) {
+	want := packets.Flow{
+		Start:       time.Unix(1, 2),
+		End:         time.Unix(3, 4),
+		SrcName:     "laptop",
+		DstName:     "a.example",
+		SrcIP:       net.IP{10, 0, 0, 2},
+		DstIP:       net.ParseIP("2001:db8::1"),
+		SrcPort:     50000,
+		DstPort:     443,
+		Protocol:    6,
+		VLAN:        42,
+		AppProtocol: "quic",
+		Named:       packets.FlowNamedLater,
+		NameDelay:   10 * time.Millisecond,
+		Alerts:      1,
+		SrcPackets:  4,
+		SrcBytes:    680,
+		DstPackets:  2,
+		DstBytes:    120,
+		TCPQuality:  packets.TCPQuality{Retransmissions: 1, OutOfOrder: 2, DupAcks: 3},
+		FlowRTT:     packets.FlowRTT{HandshakeRTT: 20 * time.Millisecond, SrcRTT: 1, DstRTT: 2, MinSrcRTT: 3, MinDstRTT: 4},
+		Reason:      packets.FlowFIN,
+	}
+	f := FromFlow(&want)
+	var back Flow
+	if err := back.Unmarshal(f.Marshal(nil)); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got := back.ToFlow(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ToFlow(FromFlow(...)):\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestRanges(t *testing.T) {
+	rs := []Range{{5, 7}, {9, 9}, {12, 20}}
+	s := FormatRanges(rs)
+	if s != "5-7,9,12-20" {
+		t.Errorf("FormatRanges: got %q", s)
+	}
+	got, err := ParseRanges(s)
+	if err != nil || !reflect.DeepEqual(got, rs) {
+		t.Errorf("ParseRanges(%q): got %v, %v, want %v", s, got, err, rs)
+	}
+	for _, bad := range []string{"x", "5-", "7-5", "1-2-3"} {
+		if _, err := ParseRanges(bad); err == nil {
+			t.Errorf("ParseRanges(%q): got nil error", bad)
+		}
+	}
+}
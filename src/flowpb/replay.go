@@ -0,0 +1,73 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flowpb
+
+// This file is the one part of the probe-collector protocol that isn't a
+// message: how a collector asks a probe to send batches again.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ReplayHeader is the header of an ingest response in which the collector
+// lists the sequence numbers of batches it is missing, as ranges
+// ("5-7,9"). A probe that kept the batches it sent should send them again.
+const ReplayHeader = "Caplog-Replay"
+
+// Range is the sequence numbers From to To, inclusive.
+type Range struct {
+	From, To uint64
+}
+
+// FormatRanges formats ranges for ReplayHeader.
+func FormatRanges(rs []Range) string {
+	s := make([]string, len(rs))
+	for i, r := range rs {
+		s[i] = strconv.FormatUint(r.From, 10)
+		if r.To != r.From {
+			s[i] += "-" + strconv.FormatUint(r.To, 10)
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+// ParseRanges parses a ReplayHeader value.
+func ParseRanges(s string) ([]Range, error) {
+	var rs []Range
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		from, to := f, f
+		if i := strings.IndexByte(f, '-'); i >= 0 {
+			from, to = f[:i], f[i+1:]
+		}
+		var r Range
+		var err error
+		if r.From, err = strconv.ParseUint(from, 10, 64); err != nil {
+			return nil, fmt.Errorf("replay range %q: %v", f, err)
+		}
+		if r.To, err = strconv.ParseUint(to, 10, 64); err != nil {
+			return nil, fmt.Errorf("replay range %q: %v", f, err)
+		}
+		if r.To < r.From {
+			return nil, fmt.Errorf("replay range %q is backwards", f)
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
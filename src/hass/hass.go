@@ -0,0 +1,298 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hass integrates with Home Assistant. For each device on the LAN it
+// keeps an "online" and a "bandwidth" sensor, which it pushes to Home
+// Assistant's REST API and serves in the format of Home Assistant's REST
+// sensor, and it fires an event in Home Assistant when a device joins.
+package hass
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dashboard"
+	"events"
+	"packets"
+)
+
+const (
+	// DefaultInterval is the default time between updates.
+	DefaultInterval = 30 * time.Second
+
+	// DefaultOnlineTimeout is how long a device is online for after it was
+	// last seen, by default.
+	DefaultOnlineTimeout = 10 * time.Minute
+
+	// JoinedEvent is the Home Assistant event type fired when a device joins.
+	JoinedEvent = "caplog_device_joined"
+)
+
+// Sensor is the state of a Home Assistant entity, as POSTed to
+// /api/states/<entity_id>.
+type Sensor struct {
+	EntityID   string                 `json:"entity_id"`
+	State      string                 `json:"state"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// Bridge keeps the sensors up to date.
+type Bridge struct {
+	// URL is Home Assistant's base URL, e.g. http://homeassistant.local:8123/.
+	// If empty, the sensors are only served at /api/hass.
+	URL string
+
+	// Token is a Home Assistant long-lived access token.
+	Token string
+
+	// Interval is the time between updates. If zero, DefaultInterval is
+	// used.
+	Interval time.Duration
+
+	// OnlineTimeout is how long a device stays online after it was last
+	// seen. If zero, DefaultOnlineTimeout is used.
+	OnlineTimeout time.Duration
+
+	// Names are the known devices' names, by MAC address. Other devices
+	// are unknown.
+	Names map[string]string
+
+	mu      sync.Mutex
+	sensors []Sensor
+	last    time.Time
+	prev    map[string]uint64 // bytes so far, by MAC
+	online  map[string]bool   // by MAC
+}
+
+// device is a device's state at an update.
+type device struct {
+	packets.Device
+	name     string
+	known    bool
+	up, down uint64 // bytes so far
+}
+
+// slug returns the entity ID part for a MAC address.
+func slug(mac string) string {
+	return "caplog_" + strings.Replace(mac, ":", "", -1)
+}
+
+// update computes the sensors at now, from the devices and the bytes sent
+// (up) and received (down) by each IP, and returns the devices that have
+// come online since the last update.
+func (b *Bridge) update(now time.Time, devs []packets.Device, up, down map[string]uint64) []device {
+	timeout := b.OnlineTimeout
+	if timeout == 0 {
+		timeout = DefaultOnlineTimeout
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.online == nil {
+		b.online = make(map[string]bool)
+	}
+	elapsed := now.Sub(b.last).Seconds()
+	prev := b.prev
+	b.prev = make(map[string]uint64, len(devs))
+	b.sensors = b.sensors[:0]
+
+	var joined []device
+	for _, dev := range devs {
+		d := device{Device: dev}
+		d.name, d.known = b.Names[dev.MAC]
+		switch {
+		case d.known:
+		case dev.Name != "": // from a controller
+			d.name = dev.Name
+		case dev.HostName != "": // from DHCP
+			d.name = dev.HostName
+		case len(dev.IPs) > 0:
+			d.name = dev.IPs[0]
+		default:
+			d.name = dev.MAC
+		}
+		for _, ip := range dev.IPs {
+			d.up += up[ip]
+			d.down += down[ip]
+		}
+		online := now.Sub(dev.LastSeen) < timeout
+		if online && !b.online[dev.MAC] {
+			joined = append(joined, d)
+		}
+		b.online[dev.MAC] = online
+
+		attrs := map[string]interface{}{
+			"mac":       dev.MAC,
+			"ips":       dev.IPs,
+			"last_seen": dev.LastSeen.Format(time.RFC3339),
+			"known":     d.known,
+		}
+		if dev.Vendor != "" {
+			attrs["vendor"] = dev.Vendor
+		}
+		onlineAttrs := map[string]interface{}{
+			"friendly_name": d.name + " online",
+			"device_class":  "connectivity",
+		}
+		bwAttrs := map[string]interface{}{
+			"friendly_name":       d.name + " bandwidth",
+			"device_class":        "data_rate",
+			"state_class":         "measurement",
+			"unit_of_measurement": "B/s",
+			"up_bytes":            d.up,
+			"down_bytes":          d.down,
+		}
+		for k, v := range attrs {
+			onlineAttrs[k] = v
+			bwAttrs[k] = v
+		}
+		state := "off"
+		if online {
+			state = "on"
+		}
+		// The rate is unknown until there are two updates to compare.
+		rate := "unknown"
+		total := d.up + d.down
+		if p, ok := prev[dev.MAC]; ok && elapsed > 0 && total >= p {
+			rate = fmt.Sprintf("%.0f", float64(total-p)/elapsed)
+		}
+		b.prev[dev.MAC] = total
+		b.sensors = append(b.sensors,
+			Sensor{"binary_sensor." + slug(dev.MAC) + "_online", state, onlineAttrs},
+			Sensor{"sensor." + slug(dev.MAC) + "_bandwidth", rate, bwAttrs},
+		)
+	}
+	sort.Slice(b.sensors, func(i, j int) bool { return b.sensors[i].EntityID < b.sensors[j].EntityID })
+	b.last = now
+	return joined
+}
+
+// Sensors returns the sensors as of the last update, by entity ID.
+func (b *Bridge) Sensors() []Sensor {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Sensor(nil), b.sensors...)
+}
+
+// Run updates the sensors every Interval, pushes them to Home Assistant
+// (if URL is set), and announces devices that join. It doesn't return.
+func (b *Bridge) Run() {
+	interval := b.Interval
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+	for ; ; time.Sleep(interval) {
+		up, down := dashboard.HostBytes()
+		for _, d := range b.update(time.Now(), packets.Devices(), up, down) {
+			b.joined(d)
+		}
+		if b.URL == "" {
+			continue
+		}
+		for _, s := range b.Sensors() {
+			if err := b.post("/api/states/"+s.EntityID, s); err != nil {
+				log.Print("hass: ", err)
+				break
+			}
+		}
+	}
+}
+
+// joined announces a device that has come online, as a caplog event and a
+// Home Assistant event.
+func (b *Bridge) joined(d device) {
+	e := events.Event{
+		Type:     "device-joined",
+		Severity: events.Info,
+		Message:  fmt.Sprintf("%s (%s) joined", d.name, d.MAC),
+		Fields:   map[string]string{"mac": d.MAC, "ips": strings.Join(d.IPs, ",")},
+	}
+	if d.Vendor != "" {
+		e.Fields["vendor"] = d.Vendor
+	}
+	if !d.known {
+		e.Severity = events.Notice
+		e.Message = fmt.Sprintf("unknown device %s (%s) joined", d.name, d.MAC)
+	}
+	events.Publish(e)
+	if b.URL == "" {
+		return
+	}
+	data := map[string]interface{}{
+		"mac":   d.MAC,
+		"name":  d.name,
+		"ips":   d.IPs,
+		"known": d.known,
+	}
+	if d.Vendor != "" {
+		data["vendor"] = d.Vendor
+	}
+	if err := b.post("/api/events/"+JoinedEvent, data); err != nil {
+		log.Print("hass: ", err)
+	}
+}
+
+// post POSTs v as JSON to a Home Assistant API path.
+func (b *Bridge) post(path string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(b.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+b.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	return nil
+}
+
+// ServeHTTP serves the sensors for Home Assistant's REST sensor: all of them,
+// or with ?entity_id=, just that one.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sensors := b.Sensors()
+	var v interface{} = sensors
+	if id := r.FormValue("entity_id"); id != "" {
+		i := sort.Search(len(sensors), func(i int) bool { return sensors[i].EntityID >= id })
+		if i == len(sensors) || sensors[i].EntityID != id {
+			http.Error(w, "unknown entity_id", http.StatusNotFound)
+			return
+		}
+		v = sensors[i]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print("hass failed to write:", err)
+	}
+}
+
+// RegisterHandler registers /api/hass.
+func (b *Bridge) RegisterHandler() {
+	http.Handle("/api/hass", b)
+}
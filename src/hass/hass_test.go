@@ -0,0 +1,87 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hass
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"packets"
+)
+
+func TestUpdate(t *testing.T) {
+	b := &Bridge{Names: map[string]string{"00:11:22:33:44:55": "Phone"}}
+	t0 := time.Unix(1434055562, 0)
+	devs := []packets.Device{
+		{MAC: "00:11:22:33:44:55", IPs: []string{"192.168.1.5"}, LastSeen: t0},
+		{MAC: "66:77:88:99:aa:bb", IPs: []string{"192.168.1.9"}, LastSeen: t0.Add(-time.Hour)},
+	}
+	up := map[string]uint64{"192.168.1.5": 1000}
+	down := map[string]uint64{"192.168.1.5": 2000}
+
+	joined := b.update(t0, devs, up, down)
+	if len(joined) != 1 || joined[0].MAC != "00:11:22:33:44:55" || !joined[0].known {
+		t.Errorf("update: joined %+v, want just the phone", joined)
+	}
+	states := map[string]string{}
+	for _, s := range b.Sensors() {
+		states[s.EntityID] = s.State
+	}
+	for id, want := range map[string]string{
+		"binary_sensor.caplog_001122334455_online": "on",
+		"sensor.caplog_001122334455_bandwidth":     "unknown",
+		"binary_sensor.caplog_66778899aabb_online": "off",
+	} {
+		if got := states[id]; got != want {
+			t.Errorf("%s: got %q, want %q", id, got, want)
+		}
+	}
+
+	// 30s later, the phone has sent and received another 3000 bytes, and the
+	// other device is back.
+	up["192.168.1.5"], down["192.168.1.5"] = 2000, 4000
+	devs[1].LastSeen = t0.Add(30 * time.Second)
+	joined = b.update(t0.Add(30*time.Second), devs, up, down)
+	if len(joined) != 1 || joined[0].MAC != "66:77:88:99:aa:bb" || joined[0].known || joined[0].name != "192.168.1.9" {
+		t.Errorf("second update: joined %+v, want just the unknown device", joined)
+	}
+
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/api/hass?entity_id=sensor.caplog_001122334455_bandwidth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var s Sensor
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if s.State != "100" || s.Attributes["friendly_name"] != "Phone bandwidth" {
+		t.Errorf("bandwidth sensor: got %+v, want state 100 and Phone bandwidth", s)
+	}
+
+	resp, err = http.Get(srv.URL + "/api/hass?entity_id=sensor.nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown entity_id: got %s, want 404", resp.Status)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health reports the status of caplog's components via the HTTP
+// server.
+package health
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Check reports whether a component is healthy, and some details about it
+// (which must be JSON-encodable).
+type Check func() (ok bool, detail interface{})
+
+var (
+	checksMu sync.RWMutex
+	checks   = make(map[string]Check)
+)
+
+// Register registers a health check for a component.
+func Register(name string, c Check) {
+	checksMu.Lock()
+	checks[name] = c
+	checksMu.Unlock()
+}
+
+// Report is the result of evaluating all the checks.
+type Report struct {
+	Healthy bool
+	Checks  map[string]interface{}
+}
+
+// Evaluate runs every check.
+func Evaluate() Report {
+	checksMu.RLock()
+	defer checksMu.RUnlock()
+	r := Report{
+		Healthy: true,
+		Checks:  make(map[string]interface{}, len(checks)),
+	}
+	for name, c := range checks {
+		ok, detail := c()
+		r.Checks[name] = detail
+		r.Healthy = r.Healthy && ok
+	}
+	return r
+}
+
+// Statuses runs every check, and returns whether each is healthy.
+func Statuses() map[string]bool {
+	checksMu.RLock()
+	defer checksMu.RUnlock()
+	m := make(map[string]bool, len(checks))
+	for name, c := range checks {
+		m[name], _ = c()
+	}
+	return m
+}
+
+// handler serves the report, with status 503 if anything is unhealthy.
+func handler(w http.ResponseWriter, r *http.Request) {
+	rep := Evaluate()
+	h := w.Header()
+	h.Add("Content-Type", "application/json")
+	if !rep.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(rep); err != nil {
+		log.Print("template failed to write:", err)
+	}
+}
+
+// RegisterHandler adds a HTTP handler for the healthz endpoint.
+func RegisterHandler() {
+	http.HandleFunc("/healthz", handler)
+}
@@ -0,0 +1,206 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package history keeps recent time series of traffic totals in memory, at a
+// fixed resolution, so they can be queried without an external database.
+package history
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxSeries is the default limit on series per group. Further
+	// keys are added to the OtherKey series. Each series takes 24 bytes per
+	// interval retained.
+	DefaultMaxSeries = 100
+
+	// OtherKey is the key of the series that collects keys over the limit.
+	OtherKey = "other"
+
+	// maxPoints limits the size of a query result, per series.
+	maxPoints = 11000
+)
+
+// Point is the totals for an interval.
+type Point struct {
+	Bytes, Packets uint64
+}
+
+// Sample is a Point at a time.
+type Sample struct {
+	Time time.Time
+	Point
+}
+
+// Series is the samples of one key of a group.
+type Series struct {
+	Key     string
+	Samples []Sample
+}
+
+// series is a ring of totals, one per slot of the store's resolution.
+type series struct {
+	points []Point
+	slots  []int64 // slot number of each point; stale ones are reused
+}
+
+// Store keeps series of totals, for a number of groups (ways of breaking
+// down the traffic, such as by direction) each with a number of keys (e.g.
+// "up", "down"). It is safe for concurrent use.
+type Store struct {
+	resolution time.Duration
+	slots      int
+
+	// MaxSeries limits the number of keys per group. Set it before use.
+	MaxSeries int
+
+	mu     sync.Mutex
+	groups map[string]map[string]*series
+	last   int64 // slot number of the latest interval added to
+}
+
+// NewStore returns a store that keeps totals for each resolution-long
+// interval, for retention.
+func NewStore(resolution, retention time.Duration) *Store {
+	slots := int(retention / resolution)
+	if slots < 1 {
+		slots = 1
+	}
+	return &Store{
+		resolution: resolution,
+		slots:      slots,
+		MaxSeries:  DefaultMaxSeries,
+		groups:     make(map[string]map[string]*series),
+	}
+}
+
+// Resolution returns the length of the store's intervals.
+func (s *Store) Resolution() time.Duration { return s.resolution }
+
+// Add adds a packet (of packets segments, if it was coalesced) at time t to
+// the series for key in group.
+func (s *Store) Add(t time.Time, group, key string, bytes, packets uint64) {
+	n := t.UnixNano() / int64(s.resolution)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n > s.last {
+		s.last = n
+	}
+	g := s.groups[group]
+	if g == nil {
+		g = make(map[string]*series)
+		s.groups[group] = g
+	}
+	ser := g[key]
+	if ser == nil {
+		if len(g) >= s.MaxSeries {
+			key = OtherKey
+			ser = g[key]
+		}
+		if ser == nil {
+			ser = &series{
+				points: make([]Point, s.slots),
+				slots:  make([]int64, s.slots),
+			}
+			g[key] = ser
+		}
+	}
+	i := int(n % int64(s.slots))
+	if ser.slots[i] != n {
+		ser.slots[i] = n
+		ser.points[i] = Point{}
+	}
+	ser.points[i].Bytes += bytes
+	ser.points[i].Packets += packets
+}
+
+// Groups returns the names of the groups with data, sorted.
+func (s *Store) Groups() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gs := make([]string, 0, len(s.groups))
+	for g := range s.groups {
+		gs = append(gs, g)
+	}
+	sort.Strings(gs)
+	return gs
+}
+
+// Query returns a group's series from start to end, with one sample per
+// step (rounded up to a whole number of intervals) totalling the intervals
+// from its time until the next sample. Series are sorted by key, and those
+// with no traffic in the range are left out. Samples are only returned for
+// the retention up to the latest interval added to (which is now, when
+// capturing live), since nothing else is kept.
+func (s *Store) Query(group string, start, end time.Time, step time.Duration) ([]Series, error) {
+	if step < s.resolution {
+		step = s.resolution
+	}
+	step = (step + s.resolution - 1) / s.resolution * s.resolution
+	start = start.Truncate(s.resolution)
+	if end.Before(start) {
+		return nil, fmt.Errorf("end %v is before start %v", end, start)
+	}
+	if n := end.Sub(start) / step; n > maxPoints {
+		return nil, fmt.Errorf("%d points per series is too many (max %d); use a longer step", n, maxPoints)
+	}
+	per := int64(step / s.resolution)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldest, last := s.last-int64(s.slots)+1, s.last
+	if t := time.Unix(0, oldest*int64(s.resolution)); start.Before(t) {
+		// Skip the steps that end before it, keeping the samples' times.
+		start = start.Add(t.Sub(start) / step * step)
+	}
+	if t := time.Unix(0, last*int64(s.resolution)); end.After(t) {
+		end = t
+	}
+	var out []Series
+	for key, ser := range s.groups[group] {
+		res := Series{Key: key}
+		var any bool
+		for t := start; !t.After(end); t = t.Add(step) {
+			first := t.UnixNano() / int64(s.resolution)
+			from, to := first, first+per-1
+			if from < oldest {
+				from = oldest
+			}
+			if to > last {
+				to = last
+			}
+			var p Point
+			for n := from; n <= to; n++ {
+				i := int(n % int64(s.slots))
+				if n >= 0 && ser.slots[i] == n {
+					p.Bytes += ser.points[i].Bytes
+					p.Packets += ser.points[i].Packets
+				}
+			}
+			if p.Packets > 0 {
+				any = true
+			}
+			res.Samples = append(res.Samples, Sample{Time: t, Point: p})
+		}
+		if any {
+			out = append(out, res)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	s := NewStore(time.Minute, time.Hour)
+	t0 := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.Add(t0, "direction", "up", 100, 1)
+	s.Add(t0.Add(30*time.Second), "direction", "up", 50, 1)
+	s.Add(t0.Add(90*time.Second), "direction", "up", 10, 1)
+	s.Add(t0.Add(2*time.Minute), "direction", "down", 1000, 1)
+	// Too old: overwritten by the next one in the ring.
+	s.Add(t0.Add(-time.Hour), "direction", "internal", 5, 1)
+	s.Add(t0, "direction", "internal", 7, 1)
+
+	got, err := s.Query("direction", t0, t0.Add(3*time.Minute), 2*time.Minute)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	sample := func(d time.Duration, bytes, packets uint64) Sample {
+		return Sample{Time: t0.Add(d), Point: Point{bytes, packets}}
+	}
+	want := []Series{
+		{Key: "down", Samples: []Sample{sample(0, 0, 0), sample(2*time.Minute, 1000, 1)}},
+		{Key: "internal", Samples: []Sample{sample(0, 7, 1), sample(2*time.Minute, 0, 0)}},
+		{Key: "up", Samples: []Sample{sample(0, 160, 3), sample(2*time.Minute, 0, 0)}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Query:\ngot  %v\nwant %v", got, want)
+	}
+
+	if _, err := s.Query("direction", t0, t0.Add(-time.Minute), time.Minute); err == nil {
+		t.Error("Query with end before start: got nil error, want error")
+	}
+	if _, err := s.Query("direction", t0, t0.Add(24*365*time.Hour), time.Minute); err == nil {
+		t.Error("Query with too many points: got nil error, want error")
+	}
+}
+
+func TestMaxSeries(t *testing.T) {
+	s := NewStore(time.Minute, time.Hour)
+	s.MaxSeries = 2
+	t0 := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, k := range []string{"a", "b", "c", "d", "a"} {
+		s.Add(t0, "device", k, 1, 1)
+	}
+	got, err := s.Query("device", t0, t0, time.Minute)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	var keys []string
+	for _, ser := range got {
+		keys = append(keys, ser.Key)
+	}
+	// "other" is the one series allowed past the limit.
+	if want := []string{"a", "b", OtherKey}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys: got %v, want %v", keys, want)
+	}
+}
+
+func TestQueryClamped(t *testing.T) {
+	s := NewStore(time.Minute, time.Hour)
+	t0 := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.Add(t0.Add(-30*time.Minute), "direction", "up", 100, 1)
+	s.Add(t0, "direction", "up", 6000, 4) // a coalesced superframe
+
+	tests := []struct {
+		start, end time.Time
+		step       time.Duration
+		want       []Sample
+	}{
+		// Back to well before the retention, and on past the latest
+		// interval: only the hour kept up to t0 is looked at.
+		{t0.Add(-10000 * time.Hour), t0.Add(100 * time.Hour), time.Hour, []Sample{
+			{t0.Add(-time.Hour), Point{100, 1}},
+			{t0, Point{6000, 4}},
+		}},
+		// A step far longer than the retention.
+		{time.Unix(0, 0), t0, 1000000 * time.Hour, []Sample{
+			{time.Unix(0, 0), Point{6100, 5}},
+		}},
+	}
+	for _, test := range tests {
+		got, err := s.Query("direction", test.start, test.end, test.step)
+		if err != nil {
+			t.Errorf("Query(%v, %v, %v): %v", test.start, test.end, test.step, err)
+			continue
+		}
+		if len(got) != 1 || !reflect.DeepEqual(got[0].Samples, test.want) {
+			t.Errorf("Query(%v, %v, %v):\ngot  %v\nwant %v", test.start, test.end, test.step, got, test.want)
+		}
+	}
+}
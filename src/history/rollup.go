@@ -0,0 +1,218 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+// This file keeps rollups: totals per key for whole periods, such as per
+// device per day, kept for longer than the fine-grained history.
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Period is the totals per key for one period of a rollup.
+type Period struct {
+	Start  time.Time
+	Totals map[string]Point
+}
+
+// Rollup keeps totals per key for each period (e.g. each day), for a number
+// of periods. Periods are aligned to local time, so daily periods start at
+// midnight. It is safe for concurrent use.
+type Rollup struct {
+	interval time.Duration
+	keep     int
+
+	// MaxKeys limits the number of keys per period. Further keys are added
+	// to OtherKey. Set it before use.
+	MaxKeys int
+
+	// Closed, if set, is called (in its own goroutine) when a period ends.
+	Closed func(*Rollup)
+
+	mu      sync.Mutex
+	periods []*Period // oldest first
+}
+
+// NewRollup returns a rollup with periods of interval, keeping the last keep
+// of them (including the current one).
+func NewRollup(interval time.Duration, keep int) *Rollup {
+	if keep < 1 {
+		keep = 1
+	}
+	return &Rollup{
+		interval: interval,
+		keep:     keep,
+		MaxKeys:  DefaultMaxSeries,
+	}
+}
+
+// PeriodStart returns the start of the period containing t.
+func (r *Rollup) PeriodStart(t time.Time) time.Time {
+	_, off := t.Zone()
+	shift := time.Duration(off) * time.Second
+	return t.Add(shift).Truncate(r.interval).Add(-shift)
+}
+
+// Add adds a packet (of packets segments, if it was coalesced) at time t to
+// key's total. Packets older than every period kept are ignored.
+func (r *Rollup) Add(t time.Time, key string, bytes, packets uint64) {
+	start := r.PeriodStart(t)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var p *Period
+	for i := len(r.periods) - 1; i >= 0; i-- {
+		if r.periods[i].Start.Equal(start) {
+			p = r.periods[i]
+			break
+		}
+		if r.periods[i].Start.Before(start) {
+			break
+		}
+	}
+	if p == nil {
+		if n := len(r.periods); n > 0 && !r.periods[n-1].Start.Before(start) {
+			return // too old
+		}
+		p = &Period{Start: start, Totals: make(map[string]Point)}
+		if len(r.periods) > 0 && r.Closed != nil {
+			go r.Closed(r)
+		}
+		r.periods = append(r.periods, p)
+		if len(r.periods) > r.keep {
+			r.periods = r.periods[len(r.periods)-r.keep:]
+		}
+	}
+	tot, ok := p.Totals[key]
+	if !ok && len(p.Totals) >= r.MaxKeys {
+		key = OtherKey
+		tot = p.Totals[key]
+	}
+	tot.Bytes += bytes
+	tot.Packets += packets
+	p.Totals[key] = tot
+}
+
+// Periods returns copies of the periods that overlap start to end, oldest
+// first.
+func (r *Rollup) Periods(start, end time.Time) []Period {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ps []Period
+	for _, p := range r.periods {
+		if !p.Start.Add(r.interval).After(start) || p.Start.After(end) {
+			continue
+		}
+		c := Period{Start: p.Start, Totals: make(map[string]Point, len(p.Totals))}
+		for k, v := range p.Totals {
+			c.Totals[k] = v
+		}
+		ps = append(ps, c)
+	}
+	return ps
+}
+
+// Totals sums the periods that overlap start to end, per key.
+func (r *Rollup) Totals(start, end time.Time) map[string]Point {
+	tot := make(map[string]Point)
+	for _, p := range r.Periods(start, end) {
+		for k, v := range p.Totals {
+			t := tot[k]
+			t.Bytes += v.Bytes
+			t.Packets += v.Packets
+			tot[k] = t
+		}
+	}
+	return tot
+}
+
+// saving serialises saves to the same path, which would otherwise share a
+// temporary file.
+var saving struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}
+
+// saveLock returns the lock for saving to path.
+func saveLock(path string) *sync.Mutex {
+	saving.Lock()
+	defer saving.Unlock()
+	if saving.m == nil {
+		saving.m = make(map[string]*sync.Mutex)
+	}
+	mu := saving.m[path]
+	if mu == nil {
+		mu = new(sync.Mutex)
+		saving.m[path] = mu
+	}
+	return mu
+}
+
+// Save writes the rollup to a file, as JSON. Saves to the same path (e.g.
+// when a period ends while the rollup is being saved at shutdown) are one
+// after another.
+func (r *Rollup) Save(path string) error {
+	mu := saveLock(filepath.Clean(path))
+	mu.Lock()
+	defer mu.Unlock()
+	r.mu.Lock()
+	b, err := json.Marshal(r.periods)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// Write then rename, so the file is never half written.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load reads periods saved by Save, if the file exists, dropping any that
+// don't fit the rollup's interval or are too many to keep.
+func (r *Rollup) Load(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var ps []*Period
+	if err := json.Unmarshal(b, &ps); err != nil {
+		return err
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Start.Before(ps[j].Start) })
+	var kept []*Period
+	for _, p := range ps {
+		if !r.PeriodStart(p.Start).Equal(p.Start) || p.Totals == nil {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	if len(kept) > r.keep {
+		kept = kept[len(kept)-r.keep:]
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.periods = kept
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRollup(t *testing.T) {
+	loc := time.FixedZone("AEST", 10*60*60)
+	day := func(d, h int) time.Time { return time.Date(2015, 6, d, h, 0, 0, 0, loc) }
+	r := NewRollup(24*time.Hour, 2)
+	closed := make(chan struct{}, 10)
+	r.Closed = func(*Rollup) { closed <- struct{}{} }
+
+	r.Add(day(1, 1), "a", 10, 1)
+	r.Add(day(1, 23), "a", 5, 1)
+	r.Add(day(2, 0), "a", 100, 1)
+	r.Add(day(1, 12), "b", 1, 1) // late, but its period is still kept
+	r.Add(day(3, 9), "b", 7, 1)  // closes day 2, drops day 1
+	r.Add(day(1, 12), "c", 1, 1) // too old
+
+	<-closed
+	<-closed
+
+	got := r.Periods(day(1, 0), day(4, 0))
+	want := []Period{
+		{Start: day(2, 0), Totals: map[string]Point{"a": {100, 1}}},
+		{Start: day(3, 0), Totals: map[string]Point{"b": {7, 1}}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Periods: got %v, want %v", got, want)
+	}
+	for i := range got {
+		if !got[i].Start.Equal(want[i].Start) || !reflect.DeepEqual(got[i].Totals, want[i].Totals) {
+			t.Errorf("Periods()[%d]: got %v, want %v", i, got[i], want[i])
+		}
+	}
+	if got, want := r.Totals(day(2, 12), day(3, 1)), map[string]Point{"a": {100, 1}, "b": {7, 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Totals: got %v, want %v", got, want)
+	}
+
+	dir, err := ioutil.TempDir("", "rollup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "r.json")
+	if err := r.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	r2 := NewRollup(24*time.Hour, 1)
+	if err := r2.Load(path); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got, want := r2.Totals(day(1, 0), day(4, 0)), map[string]Point{"b": {7, 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Totals after Load: got %v, want %v", got, want)
+	}
+}
+
+func TestRollupConcurrentSaves(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rollup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "r.json")
+	r := NewRollup(time.Hour, 2)
+	r.Add(time.Now(), "a", 10, 1)
+
+	// E.g. a period ending while the rollup is saved at shutdown.
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- r.Save(path)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Save: %v", err)
+		}
+	}
+}
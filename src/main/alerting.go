@@ -0,0 +1,92 @@
+//go:build !noalerting
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file sets up the alerting module: forwarding events to syslog or a
+// file, and following Suricata's alerts.
+
+import (
+	"fmt"
+	"log"
+
+	"config"
+	"events"
+	"suricata"
+)
+
+func init() {
+	modules["alerting"] = true
+}
+
+// startAlerting starts forwarding events and following IDS alerts, as
+// configured in cfg.
+func startAlerting() error {
+	if cfg.Events.Syslog.URL != "" {
+		sw, err := syslogWriter(cfg.Events.Syslog)
+		if err != nil {
+			return fmt.Errorf("events.syslog: %v", err)
+		}
+		events.Subscribe(func(e events.Event) {
+			if err := sw.Write(e); err != nil {
+				log.Print("syslog: ", err)
+			}
+		})
+	}
+	if cfg.Events.File != "" {
+		body, err := events.Formatter(cfg.Events.FileFormat)
+		if err != nil {
+			return fmt.Errorf("events.file_format: %v", err)
+		}
+		fw := &events.FileWriter{Path: cfg.Events.File, Body: body}
+		events.Subscribe(func(e events.Event) {
+			if err := fw.Write(e); err != nil {
+				log.Print("events: ", err)
+			}
+		})
+	}
+
+	if cfg.Suricata.EVE != "" {
+		go suricata.Follow(cfg.Suricata.EVE, idsAlert)
+	}
+	return nil
+}
+
+// syslogWriter makes the syslog writer for events from the config.
+func syslogWriter(s config.Syslog) (*events.SyslogWriter, error) {
+	w, err := events.ParseSyslogURL(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	if w.Body, err = events.Formatter(s.Format); err != nil {
+		return nil, err
+	}
+	var ok bool
+	if w.Facility, ok = events.ParseFacility(s.Facility); !ok {
+		return nil, fmt.Errorf("unknown facility %q", s.Facility)
+	}
+	for typ, name := range s.Severities {
+		sev, ok := events.ParseSeverity(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown severity %q for %s", name, typ)
+		}
+		if w.Severities == nil {
+			w.Severities = make(map[string]events.Severity)
+		}
+		w.Severities[typ] = sev
+	}
+	return w, nil
+}
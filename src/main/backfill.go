@@ -0,0 +1,113 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file implements `caplog backfill`, which replays a capture file into
+// the outputs so historical captures can populate dashboards retroactively.
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"packets"
+	"sinks"
+)
+
+// subcommandFlags makes a flag set for a subcommand, which also accepts all
+// the top-level flags (so outputs etc. are configured the same way).
+func subcommandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	return fs
+}
+
+func backfill(args []string) {
+	fs := subcommandFlags("backfill")
+	from := fs.String("from", "", "pcap file to replay.")
+	only := fs.String("sink", "", "Comma-separated outputs to write to ("+strings.Join(sinks.Names(), ", ")+"). Default: all configured outputs.")
+	rate := fs.Int("rate", 0, "Maximum packets per second to replay (0 = unlimited).")
+	every := fs.Duration("progress", 5*time.Second, "How often to report progress.")
+	fs.Parse(args)
+	mustSettings(flag.CommandLine, fs)
+
+	if *from == "" {
+		fmt.Fprintln(os.Stderr, "backfill: -from is required")
+		os.Exit(2)
+	}
+	if *rate < 0 || *rate > packets.MaxRateLimit {
+		fmt.Fprintf(os.Stderr, "backfill: -rate must be from 0 to %d\n", packets.MaxRateLimit)
+		os.Exit(2)
+	}
+	var names []string
+	if *only != "" {
+		names = strings.Split(*only, ",")
+	}
+	out, err := outputs(names)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backfill:", err)
+		os.Exit(2)
+	}
+	if flows, flowsOut, err = openFlows(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "backfill:", err)
+		os.Exit(2)
+	}
+	if out == nil && flowsOut == nil {
+		fmt.Fprintln(os.Stderr, "backfill: no outputs configured")
+		os.Exit(2)
+	}
+
+	c := &packets.Capture{
+		Account:    func(*packets.Metadata) {},
+		BufferSize: cfg.BufferSize,
+		Sink:       out,
+		RateLimit:  *rate,
+		Flows:      flows,
+	}
+
+	done, reported := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(reported)
+		start := time.Now()
+		tick := time.NewTicker(*every)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				n, ts := c.Progress()
+				log.Printf("backfill: %d packets in %v, up to %v", n, time.Since(start), ts)
+			case <-done:
+				n, ts := c.Progress()
+				log.Printf("backfill: finished, %d packets in %v, last at %v", n, time.Since(start), ts)
+				return
+			}
+		}
+	}()
+	err = c.File(*from)
+	if err == nil {
+		flushOutputs()
+		closeFlows()
+	}
+	close(done)
+	<-reported
+	if err != nil {
+		log.Fatalf("backfill: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+//go:build !nodevices
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file sets up the devices module, which learns device names from the
+// network controller and the DHCP server's leases (and vendors from oui.go).
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"clients"
+	"config"
+	"dhcp"
+	"packets"
+)
+
+func init() {
+	modules["devices"] = true
+}
+
+// startDevices starts polling the controller configured in cfg, if any, and
+// watching the DHCP server's leases file, if any. It also loads the table
+// of vendors, if any.
+func startDevices() error {
+	if err := startOUI(cfg.OUI); err != nil {
+		return err
+	}
+	if cfg.Controller.Type != "" {
+		src, err := clientSource(cfg.Controller)
+		if err != nil {
+			return fmt.Errorf("controller: %v", err)
+		}
+		go clients.Poll(context.Background(), src, cfg.Controller.Interval.Duration, packets.LearnDevice)
+	}
+	if cfg.DHCPLeases != "" {
+		src, err := leaseSource(cfg)
+		if err != nil {
+			return fmt.Errorf("dhcp_leases: %v", err)
+		}
+		go dhcp.Watch(context.Background(), src, 0, learnLease)
+	}
+	return nil
+}
+
+// leaseSource makes the DHCP server's leases file c asks for.
+func leaseSource(c *config.Config) (dhcp.LeaseSource, error) {
+	path := c.DHCPLeases
+	if path == "auto" {
+		path = "" // the usual place for the format, or any format
+	}
+	return dhcp.NewLeaseSource(c.DHCPLeasesFormat, path)
+}
+
+// learnLease adds a lease to the devices while it is active, and forgets
+// it once it has ended.
+func learnLease(l dhcp.Lease) {
+	switch {
+	case l.HWAddr == nil:
+	case l.Active(time.Now()):
+		packets.LearnLease(l.HWAddr, l.IP, l.Host, l.Ends)
+	default:
+		packets.ForgetLease(l.HWAddr, l.IP, l.Host)
+	}
+}
+
+// clientSource makes the client list source for the controller.
+func clientSource(c config.Controller) (clients.Source, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("no url for %s", c.Type)
+	}
+	password := os.Getenv("CAPLOG_CONTROLLER_PASSWORD")
+	switch c.Type {
+	case "unifi":
+		return &clients.UniFi{URL: c.URL, Site: c.Site, Username: c.User, Password: password, Insecure: c.Insecure}, nil
+	case "openwrt":
+		return &clients.OpenWrt{URL: c.URL, Username: c.User, Password: password}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q (want unifi or openwrt)", c.Type)
+}
@@ -0,0 +1,241 @@
+//go:build !nodashboard
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file sets up the dashboard module: the web UI and its API, and the
+// Prometheus, SNMP and Home Assistant integrations built on its counters.
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"config"
+	"dashboard"
+	"hass"
+	"packets"
+	"prom"
+	"snmp"
+	"suricata"
+)
+
+func init() {
+	modules["dashboard"] = true
+}
+
+// setUpDashboard configures the dashboard from cfg, registers its handlers,
+// and starts the integrations that use it.
+func setUpDashboard() error {
+	switch cfg.HostStats {
+	case dashboard.HostsExact, dashboard.HostsSketch:
+		dashboard.HostMode = cfg.HostStats
+	default:
+		return fmt.Errorf("host_stats must be %q or %q", dashboard.HostsExact, dashboard.HostsSketch)
+	}
+	if cfg.HeavyHitters > 0 {
+		dashboard.HeavyHitters = cfg.HeavyHitters
+	}
+	dashboard.SetHostSampling(cfg.HostSampling)
+	dashboard.SetDetailed(cfg.Detailed)
+	if cfg.CardinalityInterval.Duration > 0 {
+		dashboard.CardinalityInterval = cfg.CardinalityInterval.Duration
+	}
+	if cfg.History.Resolution.Duration > 0 {
+		dashboard.HistoryResolution = cfg.History.Resolution.Duration
+	}
+	if cfg.History.Retention.Duration > 0 {
+		dashboard.HistoryRetention = cfg.History.Retention.Duration
+	}
+	for _, d := range cfg.Dimensions {
+		if err := dashboard.AddDimension(dashboardDimension(d)); err != nil {
+			return fmt.Errorf("dimensions: %v", err)
+		}
+	}
+	dashboard.RollupDir = cfg.StateDir
+	if cfg.BillingDay < 1 || cfg.BillingDay > 28 {
+		return fmt.Errorf("billing_day must be from 1 to 28")
+	}
+	dashboard.BillingDay = cfg.BillingDay
+	dashboard.BillingDir = cfg.StateDir
+	dashboard.DataCap = cfg.DataCap
+	if err := dashboard.EnableForecast(); err != nil {
+		return fmt.Errorf("forecast: %v", err)
+	}
+	for _, r := range cfg.Rollups {
+		err := dashboard.AddRollup(dashboard.Rollup{
+			Dimension: dashboardDimension(r.Dimension),
+			Interval:  r.Interval.Duration,
+			Keep:      r.Keep,
+		})
+		if err != nil {
+			return fmt.Errorf("rollups: %v", err)
+		}
+	}
+
+	dashboard.Flows = flows
+	dashboard.PanelDir = cfg.HTTP.Panels
+	dashboard.Language = cfg.HTTP.Language
+	dashboard.RegisterHandlers()
+	prom.RegisterHandler()
+
+	if cfg.HomeAssistant.Enabled {
+		b, err := hassBridge(cfg.HomeAssistant)
+		if err != nil {
+			return fmt.Errorf("home_assistant: %v", err)
+		}
+		b.RegisterHandler()
+		go b.Run()
+	}
+
+	if cfg.SNMP.Listen != "" {
+		agent := &snmp.Agent{
+			Community: cfg.SNMP.Community,
+			Vars:      snmp.CaplogVars,
+		}
+		go func() {
+			if err := agent.ListenAndServe(cfg.SNMP.Listen); err != nil {
+				log.Print("snmp: ", err)
+			}
+		}()
+	}
+
+	if cfg.RemoteWrite.URL != "" {
+		instance := cfg.RemoteWrite.Instance
+		if instance == "" {
+			instance, _ = os.Hostname()
+		}
+		rw := &prom.RemoteWriter{
+			URL:         cfg.RemoteWrite.URL,
+			Interval:    cfg.RemoteWrite.Interval.Duration,
+			Username:    cfg.RemoteWrite.User,
+			Password:    os.Getenv("CAPLOG_REMOTE_WRITE_PASSWORD"),
+			BearerToken: os.Getenv("CAPLOG_REMOTE_WRITE_TOKEN"),
+			Labels:      map[string]string{"instance": instance},
+		}
+		go rw.Run()
+	}
+
+	if cfg.StateDir != "" && cfg.StateSaveInterval.Duration > 0 {
+		go saveDashboardEvery(cfg.StateSaveInterval.Duration)
+	}
+	return nil
+}
+
+// saveDashboardEvery saves the dashboard's state every interval, logging
+// when saving starts failing.
+func saveDashboardEvery(interval time.Duration) {
+	failing := false
+	for range time.Tick(interval) {
+		if err := saveDashboard(); err != nil {
+			if !failing {
+				log.Printf("dashboard: saving: %v", err)
+				failing = true
+			}
+			continue
+		}
+		failing = false
+	}
+}
+
+// saveDashboard saves the dashboard's state in the state_dir, if there is
+// one.
+func saveDashboard() error {
+	if !moduleOn("dashboard") {
+		return nil
+	}
+	return dashboard.SaveState()
+}
+
+// accounter returns the function that counts an interface's packets on the
+// dashboard, or one that does nothing if the dashboard is off.
+func accounter(iface string) func(*packets.Metadata) {
+	if !moduleOn("dashboard") {
+		return func(*packets.Metadata) {}
+	}
+	return dashboard.Accounter(iface)
+}
+
+// probeAccounter is accounter for the collector's probes, or nil.
+func probeAccounter() func(string) func(*packets.Metadata) {
+	if !moduleOn("dashboard") {
+		return nil
+	}
+	return dashboard.Accounter
+}
+
+// setDashboardDetail sets how much detail the dashboard keeps, when
+// throttling.
+func setDashboardDetail(detailed bool, sampling int) {
+	if !moduleOn("dashboard") {
+		return
+	}
+	dashboard.SetDetailed(detailed)
+	dashboard.SetHostSampling(sampling)
+}
+
+// countDNSQuery counts a DNS server's query on the dashboard.
+func countDNSQuery(domain string, blocked bool) {
+	if moduleOn("dashboard") {
+		dashboard.AddDNSQuery(domain, blocked)
+	}
+}
+
+// addDashboardFlows adds flow records to the dashboard's statistics.
+func addDashboardFlows(recs []packets.Flow) {
+	if moduleOn("dashboard") {
+		dashboard.AddFlows(recs)
+	}
+}
+
+// trackAlert adds an IDS alert to the dashboard, reporting whether its flow
+// is being tracked.
+func trackAlert(a suricata.Alert) bool {
+	return moduleOn("dashboard") && dashboard.AddAlert(a)
+}
+
+// dashboardDimension converts a dimension from the config.
+func dashboardDimension(d config.Dimension) dashboard.Dimension {
+	return dashboard.Dimension{
+		Name:        d.Name,
+		By:          d.By,
+		PortBuckets: d.PortBuckets,
+		Groups:      d.Groups,
+
+		MergeFamilies: d.MergeFamilies,
+	}
+}
+
+// hassBridge makes the Home Assistant bridge from the config.
+func hassBridge(h config.HomeAssistant) (*hass.Bridge, error) {
+	b := &hass.Bridge{
+		URL:           h.URL,
+		Token:         os.Getenv("CAPLOG_HASS_TOKEN"),
+		Interval:      h.Interval.Duration,
+		OnlineTimeout: h.OnlineTimeout.Duration,
+		Names:         make(map[string]string),
+	}
+	for mac, name := range h.Devices {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			return nil, fmt.Errorf("devices: %v", err)
+		}
+		b.Names[hw.String()] = name
+	}
+	return b, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file learns names from the local DNS server's query log.
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"config"
+	"dnslog"
+)
+
+// dnsLogSource makes the query log source for the DNS server.
+func dnsLogSource(c config.DNSServer) (dnslog.Source, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("no url for %s", c.Type)
+	}
+	password := os.Getenv("CAPLOG_DNS_SERVER_PASSWORD")
+	switch c.Type {
+	case "pihole":
+		return dnslog.NewPiHole(c.URL, password)
+	case "adguard":
+		return &dnslog.AdGuard{URL: c.URL, Username: c.User, Password: password}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q (want pihole or adguard)", c.Type)
+}
+
+// started is when caplog started. Queries from before then are read only to
+// warm up the names (see warmup.dns_server_log), not counted.
+var started = time.Now()
+
+// learnDNS names the addresses in a query log entry for its client, on
+// every capture, and counts the query on the dashboard.
+func learnDNS(e dnslog.Entry) {
+	if len(e.IPs) > 0 {
+		for _, c := range captures {
+			c.LearnName(e.Client, e.Domain, e.IPs)
+		}
+	}
+	if e.Time.After(started) {
+		countDNSQuery(e.Domain, e.Blocked)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file groups the captured packets into flows, and hands the record of
+// each to the flow outputs (the "flows" file, Zeek's conn.log, the "out"
+// file and the collector...) and the dashboard. A collector hands on the
+// records its probes send the same way.
+
+import (
+	"log"
+	"time"
+
+	"config"
+	"packets"
+	"sinks"
+	"vars"
+)
+
+var (
+	flows    *packets.FlowTable // nil if flows aren't tracked
+	flowsOut sinks.FlowSink     // nil if there are no flow outputs
+)
+
+// openFlows makes the flow table c asks for, and opens the flow outputs.
+// The table is nil if neither the outputs nor the dashboard (with detailed
+// statistics) want flows.
+// The records of flows that are done are handed on once a second.
+func openFlows(c *config.Config) (*packets.FlowTable, sinks.FlowSink, error) {
+	out, err := sinks.OpenFlows(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if out == nil && !(moduleOn("dashboard") && c.Detailed) {
+		return nil, nil, nil
+	}
+	t := &packets.FlowTable{
+		IdleTimeout:   c.Flows.IdleTimeout.Duration,
+		ActiveTimeout: c.Flows.ActiveTimeout.Duration,
+		Export:        func(recs []packets.Flow) { exportFlows(out, recs) },
+	}
+	vars.Register("flows-in-progress", vars.IntEval(t.Len).String)
+	go func() {
+		for now := range time.Tick(time.Second) {
+			t.Expire(now)
+		}
+	}()
+	return t, out, nil
+}
+
+// closeFlows exports the records of the flows still going, and closes the
+// flow outputs.
+func closeFlows() {
+	if flows != nil {
+		flows.Close()
+	}
+	if flowsOut != nil {
+		if err := flowsOut.Close(); err != nil {
+			log.Printf("flows: %v", err)
+		}
+	}
+}
+
+// exportFlows hands flow records on to the flow outputs out (which may be
+// nil) and the dashboard.
+func exportFlows(out sinks.FlowSink, recs []packets.Flow) {
+	if out != nil {
+		if err := out.Write(recs); err != nil {
+			log.Printf("flows: %v", err)
+		}
+	}
+	addDashboardFlows(recs)
+}
+
+// probeFlows returns the collector's handling of the flow records its probes
+// send: the same as for those of the flows captured here.
+func probeFlows() func([]packets.Flow) {
+	out := flowsOut
+	return func(recs []packets.Flow) { exportFlows(out, recs) }
+}
@@ -0,0 +1,58 @@
+//go:build !noalerting
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file handles alerts from an IDS (Suricata).
+
+import (
+	"fmt"
+	"strconv"
+
+	"events"
+	"suricata"
+)
+
+// idsAlert records an alert on the dashboard, with its flow, and publishes
+// it as an event.
+func idsAlert(a suricata.Alert) {
+	tracked := trackAlert(a)
+	sev := events.Notice
+	switch a.Severity {
+	case 1:
+		sev = events.Error
+	case 2:
+		sev = events.Warning
+	}
+	events.Publish(events.Event{
+		Time:     a.Time,
+		Type:     "ids-alert",
+		Severity: sev,
+		Message:  fmt.Sprintf("%s: %s:%d -> %s:%d", a.Signature, a.SrcIP, a.SrcPort, a.DstIP, a.DstPort),
+		Fields: map[string]string{
+			"signature":    a.Signature,
+			"signature_id": strconv.Itoa(a.SignatureID),
+			"category":     a.Category,
+			"action":       a.Action,
+			"proto":        a.Proto,
+			"src_ip":       a.SrcIP.String(),
+			"dst_ip":       a.DstIP.String(),
+			"src_port":     strconv.Itoa(int(a.SrcPort)),
+			"dst_port":     strconv.Itoa(int(a.DstPort)),
+			"flow_tracked": strconv.FormatBool(tracked),
+		},
+	})
+}
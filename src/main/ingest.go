@@ -0,0 +1,91 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file sets up the collector's TLS listener for batches from probes,
+// gives up on gaps in their batches, and has the tls-pin command, which
+// prints the pin to configure for a certificate.
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"collector"
+	"config"
+	"mtls"
+	"vars"
+)
+
+// ingestTLS is the collector's side of mutual TLS with the probes, if it
+// has a TLS listener. Its pins are updated when the config is reloaded.
+var ingestTLS *mtls.Endpoint
+
+// listenTLS sets up the collector to take batches only over mutual TLS on
+// c.Collector.Listen, if it is set.
+func listenTLS(col *collector.Collector, c *config.Config) error {
+	if c.Collector.Listen == "" {
+		return nil
+	}
+	t := c.Collector.TLS
+	e, err := mtls.NewEndpoint(t.Cert, t.Key, t.Pins)
+	if err != nil {
+		return fmt.Errorf("collector.tls: %v", err)
+	}
+	e.RegisterVars("ingest-tls-")
+	vars.Register("ingest-tls-connections", vars.Int64Eval(col.Connections).String)
+	ingestTLS = e
+	col.TLS = e.ServerConfig()
+	go func() {
+		log.Printf("collector: taking batches with mutual TLS on %s", c.Collector.Listen)
+		if err := col.ListenTLS(c.Collector.Listen); err != nil {
+			log.Printf("collector: %v", err)
+		}
+	}()
+	return nil
+}
+
+// applyIngestPins updates the probes the TLS listener accepts.
+func applyIngestPins(c *config.Config) error {
+	if ingestTLS == nil {
+		return nil
+	}
+	return ingestTLS.SetPins(c.Collector.TLS.Pins)
+}
+
+// expireGaps gives up on the collector's gaps that have been waited on for
+// the replay window, checking every second.
+func expireGaps(col *collector.Collector) {
+	for now := range time.Tick(time.Second) {
+		col.Expire(now)
+	}
+}
+
+// tlsPin prints the pin of each certificate file.
+func tlsPin(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: caplog tls-pin cert.pem...")
+		os.Exit(2)
+	}
+	for _, p := range args {
+		pin, err := mtls.PinFile(p)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "tls-pin:", err)
+			os.Exit(1)
+		}
+		fmt.Println(pin)
+	}
+}
@@ -17,130 +17,263 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"math/rand"
-	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"runtime"
-	"time"
+	"strings"
 
-	"dashboard"
+	"collector"
+	"cors"
+	"dnslog"
+	"events"
+	"health"
 	"packets"
+	"throttle"
 	"vars"
 )
 
-var (
-	bufferSize = flag.Int("buffer", 10000, "Buffer size.")
-
-	interfaceName = flag.String("if", "br0", "Interface to perform capture on.")
-	influxDB      = flag.String("influx", "", "Destination InfluxDB for packet data.")
-
-	port = flag.Int("port", 8080, "Serving port for user interface.")
-
-	localNetblock = flag.String("localnet", "", "Additional netblock of routable addresses to consider local (fd::/8, 10/8, 192.168/16, etc are all automatically local).")
-)
-
-const influxRetryLimit = 5
-
-type influxEndpoint string
-
-// jsonArray formats a Metadata point as a JSON array of values.
-// This is a convenient format for Influx.
-func jsonArray(w io.Writer, p *packets.Metadata) error {
-	_, err := fmt.Fprintf(w, `[%d, "%v", "%v", %d, %d, "%s", "%s", %d]`,
-		p.Timestamp.UnixNano()/1e6, p.SrcIP, p.DstIP, p.SrcPort, p.DstPort, p.SrcName, p.DstName, p.Size,
-	)
-	return err
-}
+func main() {
+	flag.Parse()
 
-// writeToInflux writes an entire buffer to the InfluxDB.
-func (e influxEndpoint) writePackets(data []packets.Metadata) {
-	if len(data) == 0 {
+	switch flag.Arg(0) {
+	case "":
+	case "migrate-config":
+		migrateConfig(flag.Args()[1:])
 		return
-	}
-	log.Printf("Writing %d points to Influx...", len(data))
-	// Retry loop with fuzzed exponential backoff.
-	waitBase := 100 * time.Millisecond
-	for i := 0; i < influxRetryLimit; i++ {
-		pr, pw := io.Pipe()
-		go func() {
-			pw.Write([]byte(`[{"name":"packet","columns":["time","src_ip","dst_ip","src_port","dst_port","src_name","dst_name","size"], "points" : [`))
-			first := true
-			for _, p := range data {
-				if first {
-					first = false
-				} else {
-					pw.Write([]byte(","))
-				}
-				jsonArray(pw, &p)
-			}
-			pw.Write([]byte(`]}]`))
-			pw.Close()
-		}()
-		//log.Printf("Writing %q\n", b.String())
-		resp, err := http.Post(string(e), "application/json", pr)
-		if err != nil {
-			log.Println(err)
-			<-time.After(waitBase + time.Duration(rand.Int63n(int64(waitBase))))
-			waitBase *= 2
-			continue
-		}
-		log.Println(resp.Status)
+	case "backfill":
+		backfill(flag.Args()[1:])
+		return
+	case "soak":
+		soak(flag.Args()[1:])
 		return
+	case "tls-pin":
+		tlsPin(flag.Args()[1:])
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		os.Exit(2)
 	}
-}
-
-func main() {
-	flag.Parse()
+	mustSettings(flag.CommandLine)
 
 	// For now, crank up the MAXPROCS. Something to not worry about in future versions of Go, which will use ~NumCPU maxprocs by default.
 	numCPU := runtime.NumCPU()
 	log.Printf("GOMAXPROCS %d -> %d\n", runtime.GOMAXPROCS(numCPU), numCPU)
+	log.Printf("Modules built in: %s", builtModules())
 
-	if localNetblock != nil && *localNetblock != "" {
-		_, cidr, err := net.ParseCIDR(*localNetblock)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "-localnet must be a valid netblock: %v\n", err)
+	if err := checkModules(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := checkInterfaceOptions(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	ignore, err := ignoreRules(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	packets.SetIgnoreRules(ignore)
+	if err := packets.SetSizeAccounting(packets.SizeMode(cfg.SizeAccounting), cfg.WireOverhead); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	packets.SetCoalescing(cfg.Coalescing.MTU, cfg.Coalescing.Estimate)
+	if err := applyLocalNet(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.WAN.CheckURL != "" {
+		go pollWANIP(cfg.WAN.CheckURL, cfg.WAN.Interval.Duration)
+	}
+	if err := applyNames(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	lookups, err := ptrLookups(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	packets.SetPTRLookups(lookups)
+	asns, err := asnTable(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	packets.SetASNTable(asns)
+	if mirror, err = openMirror(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if flows, flowsOut, err = openFlows(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	streams = openStreams(cfg)
+
+	if moduleOn("dashboard") {
+		if err := setUpDashboard(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-		packets.LocalNetblock = cidr
 	}
 
 	// Serve HTTP UI.
-	dashboard.RegisterHandlers()
 	vars.RegisterHandler()
+	health.RegisterHandler()
+	cors.AllowedOrigins = cors.CleanOrigins(cfg.HTTP.CORSOrigins)
+	srv := newServer(fmt.Sprintf(":%d", cfg.HTTP.Port), cors.Handler(http.DefaultServeMux))
 	go func() {
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil); err != nil {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 			log.Print("ListenAndServe: ", err)
 		}
 	}()
 
+	events.RegisterHandler()
+	if moduleOn("alerting") {
+		if err := startAlerting(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	out, err := outputs(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if cfg.Collector.Enabled {
+		col := &collector.Collector{
+			Account:      probeAccounter(),
+			Sink:         out,
+			Flows:        probeFlows(),
+			MaxSkew:      cfg.Collector.MaxSkew.Duration,
+			ReplayWindow: cfg.Collector.ReplayWindow.Duration,
+			MaxHeld:      cfg.Collector.MaxHeld,
+		}
+		if err := listenTLS(col, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		col.RegisterHandlers()
+		col.RegisterVars()
+		go expireGaps(col)
+	}
+
+	// Further interfaces are captured alongside the main one.
+	for _, ifName := range cfg.Interfaces {
+		ifName = strings.TrimSpace(ifName)
+		c := &packets.Capture{
+			Account:    accounter(ifName),
+			Interface:  ifName,
+			BufferSize: cfg.BufferSize,
+			Sink:       out,
+			Filter:     cfg.InterfaceFilter(ifName),
+			SnapLen:    cfg.InterfaceOptions[ifName].SnapLen,
+			Processors: cfg.Processors,
+			Checkpoint: checkpointPath(ifName),
+			Mirror:     mirror,
+			Flows:      flows,
+			Streams:    streams,
+
+			DrainTimeout: cfg.DrainTimeout.Duration,
+		}
+		configureEnrichment(c)
+		warmUp(c)
+		captures = append(captures, c)
+		capturing.Add(1)
+		go func() {
+			defer capturing.Done()
+			if err := c.Live(); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+
 	c := &packets.Capture{
-		Account:    dashboard.AddPacket,
-		Interface:  *interfaceName,
-		BufferSize: *bufferSize,
+		BufferSize: cfg.BufferSize,
+		Sink:       out,
+		Processors: cfg.Processors,
+		Mirror:     mirror,
+		Flows:      flows,
+		Streams:    streams,
+
+		DrainTimeout: cfg.DrainTimeout.Duration,
+	}
+	configureEnrichment(c)
+	warmUp(c)
+	captures = append(captures, c)
+	go reloadOnHangup()
+	go holdSignals()
+	go resizeOnSignal()
+	if cfg.Outputs.Collector != "" {
+		go registerEvery(collector.RegisterInterval)
+	}
+	if cfg.StateDir != "" && cfg.NamesSaveInterval.Duration > 0 {
+		go saveNamesEvery(cfg.NamesSaveInterval.Duration)
+	}
+	if cfg.CPULimit > 0 {
+		m := &throttle.Monitor{
+			Limit:    cfg.CPULimit,
+			MaxLevel: maxThrottleLevel,
+			SetLevel: setThrottleLevel,
+		}
+		go m.Run()
 	}
 
-	if influxDB != nil && *influxDB != "" {
-		epURL, err := url.Parse(*influxDB)
+	if cfg.DNSServer.Type != "" {
+		src, err := dnsLogSource(cfg.DNSServer)
 		if err != nil {
-			panic(err)
+			fmt.Fprintf(os.Stderr, "dns_server: %v\n", err)
+			os.Exit(2)
+		}
+		if back := cfg.Warmup.DNSServerLog.Duration; back > 0 {
+			go dnslog.PollFrom(context.Background(), src, cfg.DNSServer.Interval.Duration, started.Add(-back), learnDNS)
+		} else {
+			go dnslog.Poll(context.Background(), src, cfg.DNSServer.Interval.Duration, learnDNS)
+		}
+	}
+
+	packets.SetDeviceLearning(moduleOn("devices"))
+	if moduleOn("devices") {
+		if err := startDevices(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-		epURL.Path = "db/caplog/series"
-		// TODO: make username/pw configurable.
-		epURL.RawQuery = url.Values{
-			"u": []string{"caplog"},
-			"p": []string{"freshbeans"},
-		}.Encode()
-		endpoint := influxEndpoint(epURL.String())
-		c.Log = endpoint.writePackets
 	}
 
-	if err := c.Live(); err != nil {
-		panic(err)
+	candidates := append([]string{cfg.Interface}, cfg.InterfaceFallback...)
+	for i, ifName := range candidates {
+		c.Interface = strings.TrimSpace(ifName)
+		c.Account = accounter(c.Interface)
+		c.Checkpoint = checkpointPath(c.Interface)
+		c.Filter = cfg.InterfaceFilter(c.Interface)
+		c.SnapLen = cfg.InterfaceOptions[c.Interface].SnapLen
+		err := c.Live()
+		if err == nil {
+			shutdown(c, srv)
+			return
+		}
+		oe, ok := err.(*packets.OpenError)
+		if !ok {
+			log.Fatal(err)
+		}
+		log.Print(oe)
+		if oe.Permission {
+			// Other interfaces won't do any better.
+			printPermissionHelp(os.Stderr)
+			os.Exit(1)
+		}
+		if i < len(candidates)-1 {
+			log.Printf("Trying %s instead", strings.TrimSpace(candidates[i+1]))
+		}
 	}
+	os.Exit(1)
 }
@@ -0,0 +1,55 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file mirrors the captured packets for another analyzer (see
+// packets.Mirror), as the "mirror" settings ask.
+
+import (
+	"fmt"
+
+	"config"
+	"packets"
+	"vars"
+)
+
+var mirror *packets.Mirror // nil if nothing is mirrored
+
+// openMirror starts the mirror c asks for, which is nil if it has no
+// outputs.
+func openMirror(c *config.Config) (*packets.Mirror, error) {
+	mc := c.Mirror
+	if mc.Socket == "" && mc.Tun == "" {
+		return nil, nil
+	}
+	m, err := packets.NewMirror(mc.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if mc.Socket != "" {
+		if err := m.Listen(mc.Socket); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("mirror socket: %v", err)
+		}
+	}
+	if mc.Tun != "" {
+		if err := m.Tun(mc.Tun); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("mirror: %v", err)
+		}
+	}
+	vars.Register("mirror-outputs", vars.IntEval(m.Outputs).String)
+	return m, nil
+}
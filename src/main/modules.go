@@ -0,0 +1,75 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file keeps track of the optional modules. Each can be left out of
+// the build with a "no" tag (go build -tags nodashboard,nosinks), for a
+// smaller binary, or turned off with "disable" in the config. Without them
+// caplog still captures and sends metadata to a collector.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"config"
+)
+
+// modules records the optional modules built in. Each module's file adds
+// itself, and its counterpart for the "no" tag stubs out its functions.
+var modules = make(map[string]bool)
+
+// moduleNames are all of the optional modules.
+var moduleNames = []string{"alerting", "dashboard", "devices", "sinks"}
+
+// moduleOn reports whether a module is built in and not disabled in cfg.
+func moduleOn(name string) bool {
+	if !modules[name] {
+		return false
+	}
+	for _, d := range cfg.Disable {
+		if d == name {
+			return false
+		}
+	}
+	return true
+}
+
+// checkModules checks that c only disables known modules.
+func checkModules(c *config.Config) error {
+	for _, d := range c.Disable {
+		known := false
+		for _, name := range moduleNames {
+			known = known || d == name
+		}
+		if !known {
+			return fmt.Errorf("disable: unknown module %q (known: %s)", d, strings.Join(moduleNames, ", "))
+		}
+	}
+	return nil
+}
+
+// builtModules lists the optional modules built in, for the log.
+func builtModules() string {
+	var names []string
+	for name := range modules {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
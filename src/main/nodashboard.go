@@ -0,0 +1,50 @@
+//go:build nodashboard
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file stands in for dashboard.go when the dashboard module is left out
+// of the build.
+
+import (
+	"fmt"
+	"os"
+
+	"packets"
+	"suricata"
+)
+
+func setUpDashboard() error { return nil }
+
+func accounter(string) func(*packets.Metadata) { return func(*packets.Metadata) {} }
+
+func probeAccounter() func(string) func(*packets.Metadata) { return nil }
+
+func setDashboardDetail(bool, int) {}
+
+func countDNSQuery(string, bool) {}
+
+func addDashboardFlows([]packets.Flow) {}
+
+func trackAlert(suricata.Alert) bool { return false }
+
+func saveDashboard() error { return nil }
+
+// soak measures growth in the dashboard's maps, so it needs the module.
+func soak([]string) {
+	fmt.Fprintln(os.Stderr, "soak: built without the dashboard module (nodashboard)")
+	os.Exit(2)
+}
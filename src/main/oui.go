@@ -0,0 +1,75 @@
+//go:build !nodevices
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file loads the table of network card vendors, for the devices, and
+// keeps it up to date if it is downloaded.
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"config"
+	"oui"
+	"packets"
+)
+
+// startOUI loads the vendors' table configured in c, if any. If it has a
+// URL, a missing table is not an error: it is downloaded in the background.
+func startOUI(c config.OUI) error {
+	if c.DB == "" {
+		return nil
+	}
+	t, err := oui.Open(c.DB)
+	switch {
+	case err == nil:
+		packets.SetOUITable(t)
+	case c.URL == "" || !os.IsNotExist(err):
+		return fmt.Errorf("oui: %v", err)
+	}
+	if c.URL != "" {
+		go updateOUI(c)
+	}
+	return nil
+}
+
+// updateOUI downloads the table again whenever the saved copy is older than
+// c.MaxAge, checking daily (or every MaxAge, if that is shorter). A failed
+// download is retried hourly.
+func updateOUI(c config.OUI) {
+	check := 24 * time.Hour
+	if c.MaxAge.Duration > 0 && c.MaxAge.Duration < check {
+		check = c.MaxAge.Duration
+	}
+	for {
+		wait := check
+		if fi, err := os.Stat(c.DB); err != nil || time.Since(fi.ModTime()) >= c.MaxAge.Duration {
+			if t, err := oui.Download(c.URL, c.DB); err != nil {
+				log.Print("oui: ", err)
+				if wait > time.Hour {
+					wait = time.Hour
+				}
+			} else {
+				packets.SetOUITable(t)
+				log.Printf("oui: downloaded %d prefixes from %s", t.Len(), c.URL)
+			}
+		}
+		time.Sleep(wait)
+	}
+}
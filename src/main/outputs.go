@@ -0,0 +1,63 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file sets up the outputs for packet metadata.
+
+import (
+	"context"
+
+	"config"
+	"packets"
+	"sinks"
+)
+
+// sink is the combined output sink made by outputs, if any. The outputs in
+// it are replaced when the config is reloaded.
+var sink *sinks.Swap
+
+// outputs opens the sinks configured in cfg (see sinks.Register). If only is
+// non-empty, only the named outputs are used (it is an error to name an output
+// that isn't configured). The configuration is validated, but the destinations
+// aren't contacted: each output writes from a queue (in memory, or spooled to
+// disk) in the background, and keeps retrying while its destination is
+// unreachable.
+func outputs(only []string) (packets.Sink, error) {
+	s, err := openOutputs(cfg, only)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	sink = sinks.NewSwap(s)
+	return sink, nil
+}
+
+// openOutputs opens the outputs configured in c, as outputs does. Without the
+// sinks module, only the collector is used unless others are named.
+func openOutputs(c *config.Config, only []string) (packets.Sink, error) {
+	if len(only) == 0 && !moduleOn("sinks") {
+		if c.Outputs.Collector == "" {
+			return nil, nil
+		}
+		only = []string{"collector"}
+	}
+	return sinks.Open(c, only)
+}
+
+// flushOutputs waits for the outputs to be written.
+func flushOutputs() {
+	if sink != nil {
+		sink.Flush(context.Background())
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+)
+
+// printPermissionHelp explains how to give caplog capture privileges on the
+// current platform.
+func printPermissionHelp(w io.Writer) {
+	self, err := os.Executable()
+	if err != nil {
+		self = "bin/caplog"
+	}
+	switch runtime.GOOS {
+	case "linux":
+		fmt.Fprintf(w, `Capturing packets needs the CAP_NET_RAW and CAP_NET_ADMIN capabilities.
+Either run caplog as root (sudo), or grant the binary the capabilities once:
+
+    sudo setcap cap_net_raw,cap_net_admin=eip %s
+
+(setcap must be re-run after each rebuild.)
+`, self)
+	case "darwin":
+		fmt.Fprint(w, `Capturing packets needs read access to the /dev/bpf* devices.
+Either run caplog with sudo, or install the ChmodBPF launch daemon that comes
+with Wireshark ("Install ChmodBPF.pkg"), which gives the access_bpf group
+access at boot; then add yourself to access_bpf and log in again.
+`)
+	case "freebsd", "openbsd", "netbsd":
+		fmt.Fprint(w, `Capturing packets needs read access to the /dev/bpf* devices.
+Either run caplog as root, or give a group access to them with devfs rules.
+`)
+	case "windows":
+		fmt.Fprint(w, `Capturing packets needs Npcap. If it was installed with "Restrict Npcap
+driver's access to Administrators only", run caplog as Administrator.
+`)
+	default:
+		fmt.Fprint(w, "Capturing packets usually needs root privileges; try running caplog as root.\n")
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file changes the number of processors on SIGUSR1 and SIGUSR2, for a
+// probe sharing a box with other work whose load varies through the day
+// (from cron, say). A reload that changes "processors" sets them again.
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// resizeOnSignal gives each running capture one more processor on SIGUSR1,
+// and one fewer (but at least one) on SIGUSR2.
+func resizeOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	for sig := range sigs {
+		for _, c := range captures {
+			n := c.RunningProcessors()
+			if n == 0 {
+				continue
+			}
+			if sig == syscall.SIGUSR1 {
+				n++
+			} else if n > 1 {
+				n--
+			}
+			c.SetProcessors(n)
+			log.Printf("%v received: %d processors for %s", sig, n, c.Interface)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file registers this caplog with its collector, if it has one, saying
+// what it is and how its captures are doing.
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"collector"
+	"sinks"
+)
+
+// version is caplog's version, set with -ldflags "-X main.version=..." (see
+// build.sh).
+var version = "dev"
+
+// registerEvery registers with the collector now and every interval after.
+func registerEvery(interval time.Duration) {
+	var last string
+	for {
+		err := sinks.RegisterProbe(registration())
+		if msg := errString(err); msg != last {
+			if err != nil {
+				log.Printf("collector: %v", err)
+			} else {
+				log.Print("collector: registered")
+			}
+			last = msg
+		}
+		time.Sleep(interval)
+	}
+}
+
+// registration describes this caplog and its captures.
+func registration() *collector.Registration {
+	r := &collector.Registration{
+		Location: cfg.Location,
+		Version:  version,
+		Started:  started,
+	}
+	for _, ifName := range append([]string{cfg.Interface}, cfg.Interfaces...) {
+		r.Interfaces = append(r.Interfaces, strings.TrimSpace(ifName))
+	}
+	for _, c := range captures {
+		n, _ := c.Progress()
+		r.Packets += n
+		r.Dropped += c.Dropped()
+	}
+	return r
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
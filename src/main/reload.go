@@ -0,0 +1,351 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file reloads the config file on SIGHUP. The settings that can change
+// without stopping the captures (and so without losing any counts) are
+// applied: the capture filters (each interface's too), the ignore rules,
+// local_net, names, name_chain, the name limits and affinity, ptr_lookup,
+// the ASN database (which is reread, in case the file was updated), the
+// outputs, the number of processors, and the probes the collector's TLS
+// listener accepts.
+// The rest need a restart.
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+
+	"asn"
+	"config"
+	"mtls"
+	"packets"
+)
+
+// localNet parses c's local netblock, which is nil if there is none.
+func localNet(c *config.Config) (*net.IPNet, error) {
+	if c.LocalNet == "" {
+		return nil, nil
+	}
+	_, cidr, err := net.ParseCIDR(c.LocalNet)
+	if err != nil {
+		return nil, fmt.Errorf("local_net must be a valid netblock: %v", err)
+	}
+	return cidr, nil
+}
+
+// labels parses the addresses of c's manual labels.
+func labels(c *config.Config) (map[string]net.IP, error) {
+	ips := make(map[string]net.IP, len(c.Names))
+	for addr := range c.Names {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("names: %q is not an IP address", addr)
+		}
+		ips[addr] = ip
+	}
+	return ips, nil
+}
+
+// applyLocalNet sets the local netblock from c.
+func applyLocalNet(c *config.Config) error {
+	cidr, err := localNet(c)
+	if err != nil {
+		return err
+	}
+	packets.SetLocalNetblock(cidr)
+	return nil
+}
+
+// nameChain parses c's name chain, which is the default if it is empty.
+func nameChain(c *config.Config) ([]packets.NameSource, error) {
+	if len(c.NameChain) == 0 {
+		return packets.DefaultNameChain, nil
+	}
+	return packets.ParseNameChain(c.NameChain)
+}
+
+// applyNames sets the name chain and limits, and replaces the manual labels
+// with those in c.
+func applyNames(c *config.Config) error {
+	chain, err := nameChain(c)
+	if err != nil {
+		return err
+	}
+	ips, err := labels(c)
+	if err != nil {
+		return err
+	}
+	if err := packets.SetNameChain(chain); err != nil {
+		return err
+	}
+	packets.SetNameLimits(c.NameMinTTL.Duration, c.NameMapSize)
+	packets.SetNameAffinity(c.NameAffinity.Duration)
+	packets.ForgetNames(packets.NameManual)
+	for addr, name := range c.Names {
+		packets.SetName(ips[addr], name, packets.NameManual)
+	}
+	return nil
+}
+
+// ptrLookups returns the PTR lookups c asks for, which is nil if they are off.
+func ptrLookups(c *config.Config) (*packets.PTRLookups, error) {
+	l := c.PTRLookup
+	if !l.Enabled {
+		return nil, nil
+	}
+	if l.Rate < 1 {
+		return nil, fmt.Errorf("ptr_lookup: rate must be at least 1")
+	}
+	p := &packets.PTRLookups{Rate: l.Rate, CacheTTL: l.CacheTTL.Duration}
+	if l.Server != "" {
+		if _, _, err := net.SplitHostPort(l.Server); err != nil {
+			return nil, fmt.Errorf("ptr_lookup: server: %v", err)
+		}
+		p.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, l.Server)
+			},
+		}
+	}
+	return p, nil
+}
+
+// asnTable opens c's ASN database, which is nil if there is none.
+func asnTable(c *config.Config) (asn.Table, error) {
+	if c.ASN.DB == "" {
+		return nil, nil
+	}
+	t, err := asn.Open(c.ASN.DB, c.ASN.Names)
+	if err != nil {
+		return nil, fmt.Errorf("asn: %v", err)
+	}
+	return t, nil
+}
+
+// outputsChanged reports whether the outputs must be reopened to go from
+// config a to b.
+func outputsChanged(a, b *config.Config) bool {
+	return !reflect.DeepEqual(a.Outputs, b.Outputs) ||
+		a.QueueLength != b.QueueLength ||
+		a.ProbeName() != b.ProbeName() ||
+		a.StateDir != b.StateDir
+}
+
+// ignoreRules parses c's rules for the traffic left out of the totals.
+func ignoreRules(c *config.Config) ([]*packets.IgnoreRule, error) {
+	var rules []*packets.IgnoreRule
+	for i, ig := range c.Ignore {
+		r := &packets.IgnoreRule{Name: ig.Name, Drop: ig.Drop}
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(ig.Hosts) == 0 && len(ig.Ports) == 0 {
+			return nil, fmt.Errorf("ignore: %s: needs hosts or ports", r.Name)
+		}
+		var err error
+		if r.Hosts, err = netblocks(ig.Hosts); err != nil {
+			return nil, fmt.Errorf("ignore: %s: hosts: %v", r.Name, err)
+		}
+		if r.Peers, err = netblocks(ig.Peers); err != nil {
+			return nil, fmt.Errorf("ignore: %s: peers: %v", r.Name, err)
+		}
+		for _, p := range ig.Ports {
+			if p < 1 || p > 65535 {
+				return nil, fmt.Errorf("ignore: %s: bad port %d", r.Name, p)
+			}
+			r.Ports = append(r.Ports, uint16(p))
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// netblocks parses addresses and netblocks, an address being a netblock of
+// its own.
+func netblocks(ss []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range ss {
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an address or netblock", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// checkInterfaceOptions checks that c's interface options are for
+// interfaces it captures, with snaplens packets.Capture allows.
+func checkInterfaceOptions(c *config.Config) error {
+	captured := map[string]bool{c.Interface: true}
+	for _, names := range [][]string{c.InterfaceFallback, c.Interfaces} {
+		for _, ifName := range names {
+			captured[strings.TrimSpace(ifName)] = true
+		}
+	}
+	for ifName, o := range c.InterfaceOptions {
+		if !captured[ifName] {
+			return fmt.Errorf("interface_options: %s isn't one of the interfaces captured", ifName)
+		}
+		if o.SnapLen != 0 && (o.SnapLen < packets.MinSnapLen || o.SnapLen > packets.MaxSnapLen) {
+			return fmt.Errorf("interface_options: %s: snaplen must be from %d to %d", ifName, packets.MinSnapLen, packets.MaxSnapLen)
+		}
+	}
+	return nil
+}
+
+// applyFilters sets the filter of each capture whose filter in c differs
+// from that in applied. If one is invalid, those set are set back.
+func applyFilters(c, applied *config.Config) error {
+	for i, capture := range captures {
+		f := c.InterfaceFilter(capture.Interface)
+		if f == applied.InterfaceFilter(capture.Interface) {
+			continue
+		}
+		if err := capture.SetFilter(f); err != nil {
+			for _, done := range captures[:i] {
+				done.SetFilter(applied.InterfaceFilter(done.Interface))
+			}
+			return fmt.Errorf("filter %q for %s: %v", f, capture.Interface, err)
+		}
+	}
+	return nil
+}
+
+// reloadOnHangup reloads the config each time caplog gets a SIGHUP.
+func reloadOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	applied := cfg
+	for range hup {
+		log.Print("SIGHUP received, reloading the config")
+		if c, err := reload(applied); err != nil {
+			log.Printf("reload: %v", err)
+		} else {
+			applied = c
+		}
+	}
+}
+
+// reload rereads the config, and applies what changed since applied. If the
+// new config is invalid, nothing is changed.
+func reload(applied *config.Config) (*config.Config, error) {
+	c, err := settings(false, flag.CommandLine)
+	if err == nil {
+		err = c.ApplyProfile()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := localNet(c); err != nil {
+		return nil, err
+	}
+	if _, err := labels(c); err != nil {
+		return nil, err
+	}
+	if _, err := nameChain(c); err != nil {
+		return nil, err
+	}
+	if err := checkInterfaceOptions(c); err != nil {
+		return nil, err
+	}
+	ignore, err := ignoreRules(c)
+	if err != nil {
+		return nil, err
+	}
+	lookups, err := ptrLookups(c)
+	if err != nil {
+		return nil, err
+	}
+	asns, err := asnTable(c)
+	if err != nil {
+		return nil, err
+	}
+	if ingestTLS != nil {
+		if err := mtls.CheckPins(c.Collector.TLS.Pins); err != nil {
+			return nil, fmt.Errorf("collector.tls: %v", err)
+		}
+	}
+	var out packets.Sink
+	reopen := outputsChanged(applied, c)
+	if reopen {
+		if sink == nil {
+			return nil, fmt.Errorf("outputs can't be added without a restart, as there were none at startup")
+		}
+		if out, err = openOutputs(c, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	// Valid, so apply it.
+	applyLocalNet(c)
+	applyNames(c)
+	applyIngestPins(c)
+	if c.PTRLookup != applied.PTRLookup {
+		packets.SetPTRLookups(lookups)
+	}
+	packets.SetASNTable(asns)
+	if !reflect.DeepEqual(c.Ignore, applied.Ignore) {
+		packets.SetIgnoreRules(ignore)
+	}
+	if c.Processors != applied.Processors {
+		for _, capture := range captures {
+			capture.SetProcessors(c.Processors)
+		}
+	}
+	if err := applyFilters(c, applied); err != nil {
+		log.Printf("reload: %v (keeping the old filters)", err)
+		c.Filter, c.InterfaceOptions = applied.Filter, applied.InterfaceOptions
+	}
+	if reopen {
+		old := sink.Set(out)
+		log.Print("reload: outputs reopened")
+		if old != nil {
+			go func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				if d := c.DrainTimeout.Duration; d > 0 {
+					ctx, cancel = context.WithTimeout(context.Background(), d)
+				}
+				defer cancel()
+				if err := old.Flush(ctx); err != nil {
+					log.Printf("reload: old outputs not flushed: %v", err)
+				}
+				// Spooled buffers are left to the new outputs.
+				if err := old.Close(); err != nil {
+					log.Printf("reload: old outputs not closed cleanly: %v", err)
+				}
+			}()
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file maps the legacy command-line flags onto the config file settings,
+// and implements `caplog migrate-config`.
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"config"
+	"packets"
+)
+
+var configFile = flag.String("config", "", "JSON config file. Run `caplog [flags] migrate-config` to convert flags into one.")
+
+// Secrets aren't kept in the config file; these override the environment.
+var (
+	influxPass  = flag.String("influx-pass", "", "Password for Influx (default: $CAPLOG_INFLUX_PASSWORD). Prefer the environment variable, which stays out of ps.")
+	influxToken = flag.String("influx-token", "", "API token for Influx, instead of a password (default: $CAPLOG_INFLUX_TOKEN).")
+)
+
+// cfg is the configuration in effect, set up by settings.
+var cfg = config.Default()
+
+// The legacy flags. They still work, but each is deprecated in favour of the
+// config setting it maps to in legacyFlags.
+func init() {
+	d := config.Default()
+	flag.Int("buffer", d.BufferSize, "Buffer size.")
+	flag.Int("queue", d.QueueLength, "Number of buffers each output holds in memory while its destination is unreachable.")
+
+	flag.String("if", d.Interface, "Interface to perform capture on.")
+	flag.String("if-fallback", "", "Comma-separated interfaces to try, in order, if -if can't be opened.")
+	flag.String("influx", "", "Destination InfluxDB for packet data.")
+	flag.String("influx-user", "", "Username for Influx (default caplog). See -influx-pass.")
+	flag.String("influx-db", "", "Influx database to write to (default caplog).")
+	flag.String("victoria", "", "VictoriaMetrics base URL to import packet data to, e.g. http://127.0.0.1:8428/.")
+	flag.String("questdb", "", "QuestDB ILP (TCP) address to write packet data to, e.g. 127.0.0.1:9009.")
+	flag.String("out", "", "File to append packet data to, as length-delimited caplog.v1.Batch protobufs (see src/flowpb/caplog.proto).")
+	flag.String("telegraf", "", "Socket to write line protocol to, e.g. udp://127.0.0.1:8094 or unixgram:///run/telegraf.sock (a Telegraf socket_listener).")
+
+	flag.String("remote-write", "", "Prometheus remote-write URL to push aggregates to.")
+	flag.Duration("remote-write-interval", d.RemoteWrite.Interval.Duration, "How often to push aggregates via remote write.")
+	flag.String("remote-write-user", "", "Basic auth username for remote write. The password is read from $CAPLOG_REMOTE_WRITE_PASSWORD, or use $CAPLOG_REMOTE_WRITE_TOKEN for a bearer token.")
+	flag.String("remote-write-instance", "", "Value of the instance label on pushed series (default: hostname).")
+
+	flag.Int("port", d.HTTP.Port, "Serving port for user interface.")
+	flag.String("panels", "", "Directory of extra dashboard panel templates (*.html).")
+	flag.String("lang", "", "Dashboard language (en, ja, de). Default: chosen by the browser's Accept-Language.")
+	flag.String("cors", "", "Comma-separated list of origins allowed to query the JSON API from a browser (* allows any origin).")
+
+	flag.String("localnet", "", "Additional netblock of routable addresses to consider local (fd::/8, 10/8, 192.168/16, etc are all automatically local).")
+}
+
+// legacyFlags maps each deprecated flag to its config setting.
+var legacyFlags = map[string]string{
+	"buffer":                "buffer_size",
+	"queue":                 "queue_length",
+	"if":                    "interface",
+	"if-fallback":           "interface_fallback",
+	"localnet":              "local_net",
+	"influx":                "outputs.influx",
+	"influx-user":           "outputs.influx_user",
+	"influx-db":             "outputs.influx_db",
+	"victoria":              "outputs.victoria",
+	"questdb":               "outputs.questdb",
+	"out":                   "outputs.file",
+	"telegraf":              "outputs.telegraf",
+	"remote-write":          "remote_write.url",
+	"remote-write-interval": "remote_write.interval",
+	"remote-write-user":     "remote_write.user",
+	"remote-write-instance": "remote_write.instance",
+	"port":                  "http.port",
+	"panels":                "http.panels",
+	"lang":                  "http.language",
+	"cors":                  "http.cors_origins",
+}
+
+// settings loads the -config file (if any) and applies any legacy flags that
+// were set in the flag sets on top of it. If warn is set, each legacy flag
+// gets a deprecation warning.
+func settings(warn bool, sets ...*flag.FlagSet) (*config.Config, error) {
+	c := config.Default()
+	if *configFile != "" {
+		var err error
+		if c, err = config.Load(*configFile); err != nil {
+			return nil, err
+		}
+	}
+	// Subcommand flag sets share values with flag.CommandLine, so a flag set
+	// in more than one place only needs applying once.
+	set := make(map[string]*flag.Flag)
+	for _, fs := range sets {
+		fs.Visit(func(f *flag.Flag) {
+			if _, ok := legacyFlags[f.Name]; ok {
+				set[f.Name] = f
+			}
+		})
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := legacyFlags[name]
+		if err := c.Set(key, set[name].Value.String()); err != nil {
+			return nil, fmt.Errorf("-%s: %v", name, err)
+		}
+		if warn {
+			log.Printf("Flag -%s is deprecated; use %q in the -config file instead", name, key)
+		}
+	}
+	c.Outputs.InfluxPassword = flagOrEnv(*influxPass, "CAPLOG_INFLUX_PASSWORD")
+	c.Outputs.InfluxToken = flagOrEnv(*influxToken, "CAPLOG_INFLUX_TOKEN")
+	return c, nil
+}
+
+// flagOrEnv returns the flag value if set, or else the environment variable.
+func flagOrEnv(value, env string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
+
+// mustSettings sets cfg from settings, or exits.
+func mustSettings(sets ...*flag.FlagSet) {
+	c, err := settings(true, sets...)
+	if err == nil {
+		err = c.ApplyProfile()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	cfg = c
+}
+
+// migrateConfig prints a config file equivalent to the flags it was given.
+func migrateConfig(args []string) {
+	fs := subcommandFlags("migrate-config")
+	fs.Parse(args)
+	c, err := settings(false, flag.CommandLine, fs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate-config:", err)
+		os.Exit(2)
+	}
+	if err := c.Write(os.Stdout); err != nil {
+		log.Fatalf("migrate-config: %v", err)
+	}
+}
+
+// configureEnrichment sets up a capture's enrichers from the config.
+func configureEnrichment(c *packets.Capture) {
+	if len(cfg.Enrichment.Order) > 0 {
+		c.Enrichers = cfg.Enrichment.Order
+	}
+	c.EnrichBudget = cfg.Enrichment.Budget.Duration
+	if !cfg.Detailed {
+		c.SetEnricher("revdns", false)
+	}
+	if !cfg.WAN.Detect {
+		c.SetEnricher("wan", false)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file shuts caplog down cleanly on SIGINT or SIGTERM, in order:
+//
+//  1. Each capture stops reading (see packets.Capture.Stop).
+//  2. It processes the packets it had read.
+//  3. It writes out its partial buffers (or, with a state_dir, checkpoints
+//     them, to be written out at the next start).
+//  4. The mirror, if any, sends what it has queued and is closed.
+//  5. The records of the flows still going, if flows are recorded, are
+//     written, and the summaries of the streams, if streams are.
+//  6. The outputs are flushed and closed.
+//  7. The learned names are saved (with a state_dir).
+//  8. The HTTP server is stopped.
+//
+// It all takes at most drain_timeout from when the main capture stopped
+// reading, and ends with a report in the log.
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"packets"
+)
+
+var capturing sync.WaitGroup // captures of further interfaces still running
+
+// newServer makes the HTTP server. Shutting it down also cancels the
+// requests' contexts, which ends streams such as /dashboard/events.
+func newServer(addr string, h http.Handler) *http.Server {
+	base, cancel := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     h,
+		BaseContext: func(net.Listener) context.Context { return base },
+	}
+	srv.RegisterOnShutdown(cancel)
+	return srv
+}
+
+// holdSignals keeps caplog from being killed by another SIGINT or SIGTERM
+// while it shuts down; the captures stop on the first.
+func holdSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	for sig := range sigs {
+		log.Printf("%v received, shutting down within %v", sig, cfg.DrainTimeout.Duration)
+	}
+}
+
+// shutdown stops the other captures once the first (the main one) has
+// stopped, and then the outputs and the server.
+func shutdown(first *packets.Capture, srv *http.Server) {
+	start := first.LastDrain().Stopped
+	if start.IsZero() {
+		start = time.Now()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if d := cfg.DrainTimeout.Duration; d > 0 {
+		ctx, cancel = context.WithDeadline(context.Background(), start.Add(d))
+	}
+	defer cancel()
+
+	for _, c := range captures {
+		c.Stop()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		capturing.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Print("shutdown: captures still stopping at drain_timeout")
+	}
+	for _, c := range captures {
+		if r := c.LastDrain(); !r.Stopped.IsZero() {
+			log.Printf("shutdown: capture on %s: %v", c.Interface, r)
+		}
+	}
+
+	if mirror != nil {
+		if err := mirror.Close(); err != nil {
+			log.Printf("shutdown: mirror: %v", err)
+		}
+	}
+	closeFlows()
+	if streams != nil {
+		streams.Close()
+	}
+
+	outputs := "none"
+	if sink != nil {
+		outputs = "flushed and closed"
+		if err := sink.Flush(ctx); err != nil {
+			outputs = "not flushed: " + err.Error()
+		} else if err := sink.Close(); err != nil {
+			outputs = "flushed, not closed: " + err.Error()
+		}
+	}
+	names := "saved"
+	if namesPath() == "" {
+		names = "not kept"
+	} else if err := saveNames(); err != nil {
+		names = "not saved: " + err.Error()
+	}
+	if err := saveDashboard(); err != nil {
+		log.Printf("shutdown: dashboard not saved: %v", err)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: HTTP server: %v", err)
+	}
+	log.Printf("shutdown: done %v after the capture stopped (drain_timeout %v); outputs %s; names %s",
+		time.Since(start).Round(time.Millisecond), cfg.DrainTimeout.Duration, outputs, names)
+}
+
+// checkpointPath is the file the capture on the interface checkpoints its
+// partial buffers in. It is empty (not kept) if there is no state_dir.
+func checkpointPath(iface string) string {
+	if cfg.StateDir == "" {
+		return ""
+	}
+	return filepath.Join(cfg.StateDir, "checkpoint-"+strings.Replace(iface, "/", "_", -1)+".json")
+}
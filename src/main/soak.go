@@ -0,0 +1,139 @@
+//go:build !nodashboard
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file implements `caplog soak`, a test mode that replays a capture in a
+// loop for a long time and fails if memory use keeps growing.
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"dashboard"
+	"packets"
+)
+
+// soakSample is a measurement of the things that shouldn't grow.
+type soakSample struct {
+	revDNSHosts, revDNSNames int
+	dashMaps                 int
+	goroutines               int
+	heap                     uint64
+}
+
+func takeSoakSample(c *packets.Capture) soakSample {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s := soakSample{
+		dashMaps:   dashboard.MapSizes(),
+		goroutines: runtime.NumGoroutine(),
+		heap:       ms.HeapAlloc,
+	}
+	s.revDNSHosts, s.revDNSNames = c.ReverseDNSSize()
+	return s
+}
+
+func (s soakSample) String() string {
+	return fmt.Sprintf("revdns hosts=%d names=%d, dashboard map entries=%d, goroutines=%d, heap=%d",
+		s.revDNSHosts, s.revDNSNames, s.dashMaps, s.goroutines, s.heap)
+}
+
+// soakLimits are the allowed growth over the baseline.
+type soakLimits struct {
+	mapGrowth  float64 // factor, for the maps
+	heapGrowth float64 // factor
+	goroutines int     // absolute
+}
+
+// check returns a description of each limit that s exceeds relative to base.
+func (l soakLimits) check(base, s soakSample) []string {
+	var failures []string
+	grew := func(name string, b, n int, factor float64) {
+		if float64(n) > float64(b)*factor && n > b {
+			failures = append(failures, fmt.Sprintf("%s grew from %d to %d (limit %.2fx)", name, b, n, factor))
+		}
+	}
+	grew("reverse DNS hosts", base.revDNSHosts, s.revDNSHosts, l.mapGrowth)
+	grew("reverse DNS names", base.revDNSNames, s.revDNSNames, l.mapGrowth)
+	grew("dashboard map entries", base.dashMaps, s.dashMaps, l.mapGrowth)
+	if s.goroutines > base.goroutines+l.goroutines {
+		failures = append(failures, fmt.Sprintf("goroutines grew from %d to %d (limit +%d)", base.goroutines, s.goroutines, l.goroutines))
+	}
+	if float64(s.heap) > float64(base.heap)*l.heapGrowth {
+		failures = append(failures, fmt.Sprintf("heap grew from %d to %d (limit %.2fx)", base.heap, s.heap, l.heapGrowth))
+	}
+	return failures
+}
+
+func soak(args []string) {
+	fs := subcommandFlags("soak")
+	from := fs.String("from", "", "pcap file to replay in a loop.")
+	duration := fs.Duration("duration", time.Hour, "How long to soak for.")
+	only := fs.String("sink", "", "Comma-separated outputs to also write to (default: none).")
+	var l soakLimits
+	fs.Float64Var(&l.mapGrowth, "max-map-growth", 1.1, "Fail if a map grows by more than this factor after the first replay.")
+	fs.Float64Var(&l.heapGrowth, "max-heap-growth", 2, "Fail if the heap grows by more than this factor after the first replay.")
+	fs.IntVar(&l.goroutines, "max-goroutine-growth", 20, "Fail if the number of goroutines grows by more than this after the first replay.")
+	fs.Parse(args)
+	mustSettings(flag.CommandLine, fs)
+
+	if *from == "" {
+		fmt.Fprintln(os.Stderr, "soak: -from is required")
+		os.Exit(2)
+	}
+	c := &packets.Capture{
+		Account:    dashboard.AddPacket,
+		BufferSize: cfg.BufferSize,
+	}
+	if *only != "" {
+		out, err := outputs(strings.Split(*only, ","))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "soak:", err)
+			os.Exit(2)
+		}
+		c.Sink = out
+	}
+
+	// The first replay is the warm-up; everything after should be steady.
+	if err := c.File(*from); err != nil {
+		log.Fatalf("soak: %v", err)
+	}
+	base := takeSoakSample(c)
+	log.Printf("soak: baseline after warm-up: %v", base)
+
+	deadline := time.Now().Add(*duration)
+	for i := 2; time.Now().Before(deadline); i++ {
+		if err := c.File(*from); err != nil {
+			log.Fatalf("soak: %v", err)
+		}
+		s := takeSoakSample(c)
+		log.Printf("soak: after replay %d: %v", i, s)
+		if failures := l.check(base, s); len(failures) > 0 {
+			for _, f := range failures {
+				log.Printf("soak: FAIL: %s", f)
+			}
+			os.Exit(1)
+		}
+	}
+	log.Print("soak: PASS")
+}
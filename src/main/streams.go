@@ -0,0 +1,54 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file reassembles the captured TCP streams, and appends a summary of
+// each to a file, as the "streams" settings ask.
+
+import (
+	"log"
+	"time"
+
+	"config"
+	"packets"
+	"sinks"
+)
+
+var streams *packets.StreamTable // nil if streams aren't summarised
+
+// openStreams makes the stream table c asks for, which is nil if there is
+// no file for the summaries. The summaries of streams that are done are
+// written once a second.
+func openStreams(c *config.Config) *packets.StreamTable {
+	sc := c.Streams
+	if sc.File == "" {
+		return nil
+	}
+	w := &sinks.StreamWriter{Path: sc.File}
+	t := &packets.StreamTable{
+		IdleTimeout: sc.IdleTimeout.Duration,
+		Export: func(recs []packets.Stream) {
+			if err := w.Write(recs); err != nil {
+				log.Printf("streams: %v (%d summaries lost)", err, len(recs))
+			}
+		},
+	}
+	go func() {
+		for now := range time.Tick(time.Second) {
+			t.Expire(now)
+		}
+	}()
+	return t
+}
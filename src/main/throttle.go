@@ -0,0 +1,71 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file decides what to give up when caplog uses too much CPU.
+
+import (
+	"fmt"
+
+	"events"
+	"packets"
+	"throttle"
+)
+
+// maxThrottleLevel is the highest level setThrottleLevel knows.
+const maxThrottleLevel = 3
+
+// enricherConfigured reports whether an enricher that throttling sheds is on
+// when not throttled. Reverse DNS names need detailed statistics.
+func enricherConfigured(name string) bool {
+	return name != "revdns" || cfg.Detailed
+}
+
+// captures are the running captures, for setThrottleLevel.
+var captures []*packets.Capture
+
+// setThrottleLevel sheds work as the level goes up:
+//
+//	1: no detailed statistics, reverse DNS names, ASNs or QUIC names
+//	2: also count only 1 in 10 packets per host
+//	3: only 1 in 100
+//
+// Level 0 restores the configured settings.
+func setThrottleLevel(level int) {
+	detailed := cfg.Detailed && level == 0
+	for _, c := range captures {
+		throttle.SetEnrichers(c, level, enricherConfigured)
+	}
+	sampling := cfg.HostSampling
+	if sampling < 1 {
+		sampling = 1
+	}
+	for i := 1; i < level; i++ {
+		sampling *= 10
+	}
+	setDashboardDetail(detailed, sampling)
+
+	e := events.Event{
+		Type:     "throttle",
+		Severity: events.Warning,
+		Message:  fmt.Sprintf("CPU use over limit, throttled to level %d", level),
+		Fields:   map[string]string{"level": fmt.Sprint(level)},
+	}
+	if level == 0 {
+		e.Severity = events.Notice
+		e.Message = "CPU use back under limit, no longer throttled"
+	}
+	events.Publish(e)
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file finds the router's public address by asking a web service.
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"packets"
+)
+
+// checkWANIP fetches the URL, whose response is just an IP address.
+func checkWANIP(url string) (net.IP, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, fmt.Errorf("%s: not an IP address: %q", url, body)
+	}
+	return ip, nil
+}
+
+// pollWANIP sets the WAN address from the URL every interval.
+func pollWANIP(url string, interval time.Duration) {
+	failing := false
+	for ; ; time.Sleep(interval) {
+		ip, err := checkWANIP(url)
+		if err != nil {
+			if !failing {
+				log.Print("wan: ", err)
+				failing = true
+			}
+			continue
+		}
+		failing = false
+		packets.SetWANIP(ip)
+	}
+}
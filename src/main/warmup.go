@@ -0,0 +1,115 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// This file warms up the names at startup, so the first minutes after a
+// restart aren't full of bare addresses: the names last saved in the
+// state_dir, and those exported by other caplogs, are loaded into each
+// capture.
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"packets"
+)
+
+// namesPath is the file the names are saved in, at shutdown and every
+// names_save_interval. It is empty (not kept) if there is no state_dir.
+func namesPath() string {
+	if cfg.StateDir == "" {
+		return ""
+	}
+	return filepath.Join(cfg.StateDir, "names.json")
+}
+
+// warmUp loads the saved and imported names into the capture.
+func warmUp(c *packets.Capture) {
+	var files []string
+	if p := namesPath(); p != "" {
+		if _, err := os.Stat(p); err == nil {
+			files = append(files, p)
+		}
+	}
+	files = append(files, cfg.Warmup.Names...)
+	for _, p := range files {
+		f, err := os.Open(p)
+		if err != nil {
+			log.Printf("warmup: %v", err)
+			continue
+		}
+		n, err := c.LoadNames(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			log.Printf("warmup: %s: %v", p, err)
+		}
+		log.Printf("warmup: %d names from %s", n, p)
+	}
+}
+
+// savingNames serialises saveNames, which the periodic saves and shutdown
+// both call.
+var savingNames sync.Mutex
+
+// saveNamesEvery saves the names every interval, so that a crash loses at
+// most an interval's worth.
+func saveNamesEvery(interval time.Duration) {
+	failing := false
+	for range time.Tick(interval) {
+		if err := saveNames(); err != nil {
+			if !failing {
+				log.Printf("names: saving: %v", err)
+				failing = true
+			}
+			continue
+		}
+		failing = false
+	}
+}
+
+// saveNames saves the learned names in the state_dir, for warmUp. The file
+// is replaced whole, so a crash while saving leaves the last one.
+func saveNames() error {
+	p := namesPath()
+	if p == "" {
+		return nil
+	}
+	savingNames.Lock()
+	defer savingNames.Unlock()
+	tmp := p + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = packets.SaveNames(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, p)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
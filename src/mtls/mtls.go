@@ -0,0 +1,232 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mtls secures the transport between caplog probes and a collector
+// with mutual TLS, in which each side accepts the other by a pin, the hash of
+// its public key, rather than by a certificate authority. Certificates can be
+// self-signed. They are reread when their files change, so keys can be
+// rotated without a restart: pin the new key alongside the old one, replace
+// the files, and then drop the old pin.
+package mtls
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vars"
+)
+
+// Pin returns a certificate's pin: the base64 SHA-256 of its
+// SubjectPublicKeyInfo, as in HPKP (RFC 7469). It stays the same when a
+// certificate is renewed with the same key.
+func Pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// PinFile returns the pin of the first certificate in a PEM file.
+func PinFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return "", fmt.Errorf("%s: no certificate", path)
+		}
+		if block.Type == "CERTIFICATE" {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return "", fmt.Errorf("%s: %v", path, err)
+			}
+			return Pin(cert), nil
+		}
+	}
+}
+
+// Endpoint is one side of the transport: its certificate, and the pins of
+// the peers it accepts.
+type Endpoint struct {
+	// Handshakes and Rejected count the peers accepted, and those refused
+	// because their key isn't pinned. They are accessed atomically (and
+	// first, to be aligned for it on 32-bit platforms).
+	Handshakes, Rejected uint64
+
+	CertFile, KeyFile string
+
+	mu       sync.Mutex
+	pins     map[string]bool
+	cert     *tls.Certificate
+	notAfter time.Time
+	modTime  time.Time // of the newer of the files, when loaded
+}
+
+// NewEndpoint loads the certificate and key, which must be PEM files, and
+// accepts the peers with the pins.
+func NewEndpoint(certFile, keyFile string, pins []string) (*Endpoint, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("a certificate and key are needed")
+	}
+	e := &Endpoint{CertFile: certFile, KeyFile: keyFile}
+	if err := e.SetPins(pins); err != nil {
+		return nil, err
+	}
+	if _, err := e.Certificate(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// CheckPins checks that there are pins, and that they are well-formed.
+func CheckPins(pins []string) error {
+	if len(pins) == 0 {
+		return errors.New("no peers are pinned")
+	}
+	for _, p := range pins {
+		if b, err := base64.StdEncoding.DecodeString(p); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("pin %q is not a base64 SHA-256 hash", p)
+		}
+	}
+	return nil
+}
+
+// SetPins replaces the pins of the peers accepted.
+func (e *Endpoint) SetPins(pins []string) error {
+	if err := CheckPins(pins); err != nil {
+		return err
+	}
+	set := make(map[string]bool, len(pins))
+	for _, p := range pins {
+		set[p] = true
+	}
+	e.mu.Lock()
+	e.pins = set
+	e.mu.Unlock()
+	return nil
+}
+
+// Certificate returns the certificate, rereading the files if either has
+// changed since it was loaded. If the new files can't be loaded (say, only
+// one has been replaced so far), the old certificate is kept.
+func (e *Endpoint) Certificate() (*tls.Certificate, error) {
+	var mod time.Time
+	for _, p := range []string{e.CertFile, e.KeyFile} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return e.loaded(err)
+		}
+		if fi.ModTime().After(mod) {
+			mod = fi.ModTime()
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cert != nil && mod.Equal(e.modTime) {
+		return e.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(e.CertFile, e.KeyFile)
+	if err != nil {
+		if e.cert != nil {
+			return e.cert, nil
+		}
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	e.cert, e.notAfter, e.modTime = &cert, leaf.NotAfter, mod
+	return e.cert, nil
+}
+
+// loaded returns the certificate loaded before, or else err.
+func (e *Endpoint) loaded(err error) (*tls.Certificate, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cert != nil {
+		return e.cert, nil
+	}
+	return nil, err
+}
+
+// NotAfter returns when the certificate expires.
+func (e *Endpoint) NotAfter() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.notAfter
+}
+
+// verify accepts the peer if its certificate's key is pinned. The rest of
+// the chain, and the names, don't matter. It is a VerifyConnection callback,
+// which unlike VerifyPeerCertificate also runs when a session is resumed, so
+// a peer whose pin has been removed can't get back in with a session ticket.
+func (e *Endpoint) verify(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		atomic.AddUint64(&e.Rejected, 1)
+		return errors.New("mtls: no peer certificate")
+	}
+	pin := Pin(cs.PeerCertificates[0])
+	e.mu.Lock()
+	ok := e.pins[pin]
+	e.mu.Unlock()
+	if !ok {
+		atomic.AddUint64(&e.Rejected, 1)
+		return fmt.Errorf("mtls: peer key %s is not pinned", pin)
+	}
+	atomic.AddUint64(&e.Handshakes, 1)
+	return nil
+}
+
+// ServerConfig returns a TLS config that requires clients to present a
+// pinned key.
+func (e *Endpoint) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		ClientAuth:       tls.RequireAnyClientCert,
+		GetCertificate:   func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return e.Certificate() },
+		VerifyConnection: e.verify,
+	}
+}
+
+// ClientConfig returns a TLS config that presents the certificate, and
+// requires the server to have a pinned key.
+func (e *Endpoint) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The pins are checked instead, by verify.
+		InsecureSkipVerify:   true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return e.Certificate() },
+		VerifyConnection:     e.verify,
+	}
+}
+
+// RegisterVars registers the handshake counts and the certificate's expiry
+// (in Unix time) with vars, with the prefix.
+func (e *Endpoint) RegisterVars(prefix string) {
+	vars.Register(prefix+"handshakes", vars.Uint64Eval(func() uint64 { return atomic.LoadUint64(&e.Handshakes) }).String)
+	vars.Register(prefix+"rejected", vars.Uint64Eval(func() uint64 { return atomic.LoadUint64(&e.Rejected) }).String)
+	vars.Register(prefix+"cert-expiry", vars.Int64Eval(func() int64 { return e.NotAfter().Unix() }).String)
+}
@@ -0,0 +1,226 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a new self-signed certificate and key to dir, as
+// name.pem and name.key, and returns its pin.
+func writeCert(t *testing.T, dir, name string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pin, err := PinFile(certFile)
+	if err != nil {
+		t.Fatalf("PinFile: %v", err)
+	}
+	return pin
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	serverPin := writeCert(t, dir, "server")
+	clientPin := writeCert(t, dir, "client")
+	otherPin := writeCert(t, dir, "other")
+
+	server, err := NewEndpoint(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), []string{clientPin})
+	if err != nil {
+		t.Fatalf("NewEndpoint: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Pin(r.TLS.PeerCertificates[0])))
+	}))
+	// Not StartTLS, which would use its own certificate.
+	srv.Listener = tls.NewListener(srv.Listener, server.ServerConfig())
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.Start()
+	defer srv.Close()
+	url := "https://" + srv.Listener.Addr().String()
+
+	get := func(name string, pins ...string) (string, error) {
+		e, err := NewEndpoint(filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key"), pins)
+		if err != nil {
+			t.Fatalf("NewEndpoint: %v", err)
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: e.ClientConfig()}}
+		resp, err := c.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	if got, err := get("client", serverPin); err != nil || got != clientPin {
+		t.Errorf("pinned client: got %q, %v, want %q", got, err, clientPin)
+	}
+	if _, err := get("other", serverPin); err == nil {
+		t.Error("unpinned client: got no error")
+	}
+	if _, err := get("client", otherPin); err == nil {
+		t.Error("client pinning another server: got no error")
+	}
+	if server.Handshakes != 1 || server.Rejected != 1 {
+		t.Errorf("server: got %d handshakes, %d rejected, want 1, 1", server.Handshakes, server.Rejected)
+	}
+
+	// Rotating the client's key: pin both, and replace its files.
+	if err := server.SetPins([]string{clientPin, otherPin}); err != nil {
+		t.Fatalf("SetPins: %v", err)
+	}
+	newPin := writeCert(t, dir, "client")
+	if _, err := get("client", serverPin); err == nil {
+		t.Error("new client key, not yet pinned: got no error")
+	}
+	if err := server.SetPins([]string{clientPin, newPin}); err != nil {
+		t.Fatalf("SetPins: %v", err)
+	}
+	if got, err := get("client", serverPin); err != nil || got != newPin {
+		t.Errorf("rotated client: got %q, %v, want %q", got, err, newPin)
+	}
+
+	// The server's files are reread when they change.
+	later := time.Now().Add(time.Minute)
+	newServerPin := writeCert(t, dir, "server")
+	os.Chtimes(filepath.Join(dir, "server.pem"), later, later)
+	if _, err := get("client", serverPin); err == nil {
+		t.Error("old server pin after the server's key changed: got no error")
+	}
+	if _, err := get("client", newServerPin); err != nil {
+		t.Errorf("new server pin: %v", err)
+	}
+}
+
+func TestResumedSessionPins(t *testing.T) {
+	dir := t.TempDir()
+	serverPin := writeCert(t, dir, "server")
+	clientPin := writeCert(t, dir, "client")
+	otherPin := writeCert(t, dir, "other")
+
+	server, err := NewEndpoint(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), []string{clientPin})
+	if err != nil {
+		t.Fatalf("NewEndpoint: %v", err)
+	}
+	client, err := NewEndpoint(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"), []string{serverPin})
+	if err != nil {
+		t.Fatalf("NewEndpoint: %v", err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", server.ServerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("ok"))
+			c.Close()
+		}
+	}()
+
+	cc := client.ClientConfig()
+	cc.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	dial := func() (resumed bool, err error) {
+		c, err := tls.Dial("tcp", l.Addr().String(), cc)
+		if err != nil {
+			return false, err
+		}
+		defer c.Close()
+		// Reading gets the session ticket, and the server's verdict.
+		if _, err := ioutil.ReadAll(c); err != nil {
+			return false, err
+		}
+		return c.ConnectionState().DidResume, nil
+	}
+	if _, err := dial(); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+	if resumed, err := dial(); err != nil || !resumed {
+		t.Fatalf("second connection: resumed %v, %v; want a resumed session", resumed, err)
+	}
+	// Once the client's pin is removed, its session is refused too.
+	if err := server.SetPins([]string{otherPin}); err != nil {
+		t.Fatalf("SetPins: %v", err)
+	}
+	if _, err := dial(); err == nil {
+		t.Error("resumed session after the pin was removed: got no error")
+	}
+}
+
+func TestCheckPins(t *testing.T) {
+	tests := []struct {
+		pins []string
+		ok   bool
+	}{
+		{nil, false},
+		{[]string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}, true},
+		{[]string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", "sha256"}, false},
+		{[]string{"AAAA"}, false},
+	}
+	for _, test := range tests {
+		if err := CheckPins(test.pins); (err == nil) != test.ok {
+			t.Errorf("CheckPins(%q): got %v, want ok %v", test.pins, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package oui names the vendors of network cards from the first bits of their
+// MAC addresses, using the IEEE's list of assignments (oui.csv, mam.csv,
+// oui36.csv or oui.txt) or Wireshark's manuf file.
+package oui
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IEEEURL is where the IEEE publishes the large (MA-L) assignments.
+const IEEEURL = "https://standards-oui.ieee.org/oui/oui.csv"
+
+// Table maps MAC address prefixes to vendors.
+type Table struct {
+	byLen   map[int]map[uint64]string
+	lengths []int // the lengths in byLen, longest first
+}
+
+// Len returns the number of prefixes in the table.
+func (t *Table) Len() int {
+	n := 0
+	for _, m := range t.byLen {
+		n += len(m)
+	}
+	return n
+}
+
+// Lookup returns the vendor of the MAC address, or "" if it isn't known.
+// Locally administered addresses, such as the random ones phones use, have
+// no vendor.
+func (t *Table) Lookup(mac net.HardwareAddr) string {
+	if t == nil || len(mac) != 6 || mac[0]&2 != 0 {
+		return ""
+	}
+	var k uint64
+	for _, b := range mac {
+		k = k<<8 | uint64(b)
+	}
+	for _, bits := range t.lengths {
+		if v, ok := t.byLen[bits][k>>uint(48-bits)]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// add adds the prefix: the first bits of the hex digits.
+func (t *Table) add(hex string, bits int, vendor string) error {
+	hex = strings.NewReplacer(":", "", "-", "", ".", "").Replace(hex)
+	if bits == 0 {
+		bits = 4 * len(hex)
+	}
+	if bits < 4 || bits > 48 || 4*len(hex) < bits || len(hex) > 12 {
+		return fmt.Errorf("bad prefix %s/%d", hex, bits)
+	}
+	n, err := strconv.ParseUint(hex, 16, 64)
+	if err != nil {
+		return fmt.Errorf("bad prefix %s", hex)
+	}
+	n >>= uint(4*len(hex) - bits)
+	m := t.byLen[bits]
+	if m == nil {
+		m = make(map[uint64]string)
+		t.byLen[bits] = m
+		t.lengths = append(t.lengths, bits)
+	}
+	m[n] = vendor
+	return nil
+}
+
+// Open loads a table from a file in any of the formats, gzipped or not.
+func Open(path string) (*Table, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return t, nil
+}
+
+// Parse reads a table in any of the formats, gzipped or not.
+func Parse(b []byte) (*Table, error) {
+	if len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b {
+		z, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer z.Close()
+		if b, err = ioutil.ReadAll(z); err != nil {
+			return nil, err
+		}
+	}
+	t := &Table{byLen: make(map[int]map[uint64]string)}
+	var err error
+	switch {
+	case bytes.HasPrefix(bytes.TrimPrefix(b, []byte("\ufeff")), []byte("Registry,")):
+		err = t.parseCSV(bytes.NewReader(b))
+	case bytes.Contains(b, []byte("(hex)")):
+		err = t.parseText(bytes.NewReader(b))
+	default:
+		err = t.parseManuf(bytes.NewReader(b))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(t.lengths) == 0 {
+		return nil, fmt.Errorf("no prefixes")
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(t.lengths)))
+	return t, nil
+}
+
+// parseCSV reads the IEEE's CSV files: "Registry,Assignment,Organization
+// Name,Organization Address" rows, where the assignment is 6, 7 or 9 hex
+// digits.
+func (t *Table) parseCSV(r io.Reader) error {
+	c := csv.NewReader(r)
+	c.FieldsPerRecord = -1
+	c.LazyQuotes = true
+	if _, err := c.Read(); err != nil { // the header
+		return err
+	}
+	for {
+		rec, err := c.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(rec) < 3 {
+			line, _ := c.FieldPos(0)
+			return fmt.Errorf("line %d: want registry, assignment and name", line)
+		}
+		if err := t.add(rec[1], 0, strings.TrimSpace(rec[2])); err != nil {
+			line, _ := c.FieldPos(0)
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+}
+
+// parseText reads the IEEE's oui.txt, taking the "00-22-72   (hex)   Name"
+// lines.
+func (t *Table) parseText(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		f := strings.SplitN(s.Text(), "(hex)", 2)
+		if len(f) != 2 {
+			continue
+		}
+		if err := t.add(strings.TrimSpace(f[0]), 0, strings.TrimSpace(f[1])); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return s.Err()
+}
+
+// parseManuf reads Wireshark's manuf file: "prefix[/bits]<tab>short
+// name[<tab>long name]" lines. The long name is used if there is one.
+func (t *Table) parseManuf(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		f := strings.Split(l, "\t")
+		if len(f) < 2 {
+			return fmt.Errorf("line %d: want prefix and name", line)
+		}
+		prefix, bits := f[0], 0
+		if i := strings.IndexByte(prefix, '/'); i >= 0 {
+			n, err := strconv.Atoi(prefix[i+1:])
+			if err != nil {
+				return fmt.Errorf("line %d: bad prefix %s", line, prefix)
+			}
+			prefix, bits = prefix[:i], n
+		}
+		name := strings.TrimSpace(f[len(f)-1])
+		if err := t.add(prefix, bits, name); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return s.Err()
+}
+
+// Download fetches a table from the URL and, if it is valid, saves it to
+// path.
+func Download(url, path string) (*Table, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", url, err)
+	}
+	t, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", url, err)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oui
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	ieeeCSV = `Registry,Assignment,Organization Name,Organization Address
+MA-L,240AC4,Espressif Inc.,"Room 204, Building 2 Shanghai CN 200233 "
+MA-L,70B3D5,IEEE Registration Authority,445 Hoes Lane Piscataway NJ US 08554 
+MA-S,70B3D5123,"Example Sensors, Ltd.",Somewhere
+MA-M,70B3D58,Example Meters,Elsewhere
+`
+
+	ieeeText = `OUI/MA-L			Organization                                 
+company_id			Organization                                 
+				Address                                      
+
+24-0A-C4   (hex)		Espressif Inc.
+240AC4     (base 16)		Espressif Inc.
+				Room 204, Building 2
+				Shanghai  CN  200233
+
+`
+
+	manuf = `# Wireshark manuf
+24:0A:C4	Espressif	Espressif Inc.
+70:B3:D5	IEEERegi	IEEE Registration Authority
+70:B3:D5:12:30:00/36	ExampleS
+`
+)
+
+func TestLookup(t *testing.T) {
+	var gz bytes.Buffer
+	z := gzip.NewWriter(&gz)
+	z.Write([]byte(ieeeCSV))
+	z.Close()
+
+	tests := []struct {
+		name, data string
+		want       map[string]string
+	}{
+		{"csv", ieeeCSV, map[string]string{
+			"24:0a:c4:01:02:03": "Espressif Inc.",
+			"70:b3:d5:12:34:56": "Example Sensors, Ltd.",
+			"70:b3:d5:82:34:56": "Example Meters",
+			"70:b3:d5:99:99:99": "IEEE Registration Authority",
+			"26:0a:c4:01:02:03": "", // locally administered
+			"00:00:00:00:00:01": "",
+		}},
+		{"gzip", gz.String(), map[string]string{
+			"24:0a:c4:01:02:03": "Espressif Inc.",
+		}},
+		{"text", ieeeText, map[string]string{
+			"24:0a:c4:01:02:03": "Espressif Inc.",
+			"70:b3:d5:99:99:99": "",
+		}},
+		{"manuf", manuf, map[string]string{
+			"24:0a:c4:01:02:03": "Espressif Inc.",
+			"70:b3:d5:12:34:56": "ExampleS",
+			"70:b3:d5:82:34:56": "IEEE Registration Authority",
+		}},
+	}
+	for _, test := range tests {
+		tbl, err := Parse([]byte(test.data))
+		if err != nil {
+			t.Errorf("%s: Parse: %v", test.name, err)
+			continue
+		}
+		for s, want := range test.want {
+			mac, err := net.ParseMAC(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tbl.Lookup(mac); got != want {
+				t.Errorf("%s: Lookup(%s) = %q, want %q", test.name, s, got, want)
+			}
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"# nothing\n",
+		"Registry,Assignment,Organization Name\nMA-L,XYZ123,Bad\n",
+		"24:0A:C4\n",
+		"24:0A:C4/99\tToo long\n",
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%q): got no error", data)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	data := ieeeCSV
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, data)
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "oui.csv")
+
+	tbl, err := Download(srv.URL, path)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got, want := tbl.Len(), 4; got != want {
+		t.Errorf("Len() = %d, want %d", got, want)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != ieeeCSV {
+		t.Errorf("saved %q, %v; want the download", b, err)
+	}
+
+	// A bad download leaves the saved file alone.
+	data = "<html>Not found</html>"
+	if _, err := Download(srv.URL, path); err == nil {
+		t.Error("Download of a bad table: got no error")
+	}
+	if tbl, err := Open(path); err != nil || tbl.Len() != 4 {
+		t.Errorf("Open after a bad download: %v", err)
+	}
+}
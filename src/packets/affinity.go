@@ -0,0 +1,158 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file gives names session affinity. Once a client has been given a
+// name for a server, by its own DNS lookup or the server name on a flow, the
+// name sticks to the pair for a while, and names the client's later flows to
+// the server that lack their own: TLS sessions resumed without a server
+// name, and connections that outlive the DNS answer. Each use keeps the name
+// for another window.
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vars"
+)
+
+const (
+	// DefaultNameAffinity is how long a name sticks to a client and server
+	// after it was last used, by default.
+	DefaultNameAffinity = 30 * time.Minute
+
+	// maxAffinities bounds each capture's table of client and server pairs.
+	maxAffinities = 65536
+)
+
+// nameAffinity is the window set by SetNameAffinity, accessed atomically.
+var nameAffinity = int64(DefaultNameAffinity)
+
+// affinityApplied counts the addresses in packets named by affinity,
+// accessed atomically.
+var affinityApplied uint64
+
+func init() {
+	vars.Uint64("name-affinity-applied", &affinityApplied)
+}
+
+// SetNameAffinity sets how long a name a client was given for a server
+// names the client's later traffic to it that has no name of its own, after
+// it was last used. 0 turns it off. It may be changed while capturing.
+func SetNameAffinity(window time.Duration) {
+	atomic.StoreInt64(&nameAffinity, int64(window))
+}
+
+// affinityKey is a local client and the server it talks to.
+type affinityKey struct {
+	client, server [16]byte
+}
+
+type affinity struct {
+	name   string
+	source NameSource
+	seen   int64 // UnixNano, accessed atomically
+}
+
+// affinityTable maps clients and servers to the names last attributed to
+// their traffic.
+type affinityTable struct {
+	mu sync.RWMutex
+	m  map[affinityKey]*affinity // made when first needed
+}
+
+// attributed reports whether names from the source were given to the
+// client itself, rather than known for the address to everyone.
+func attributed(s NameSource) bool {
+	return s == NameSNI || s == NameDNS
+}
+
+// stale reports whether a pair last used at seen has been idle for the
+// window by now (or is that far ahead of it, for replayed captures).
+func stale(seen int64, now time.Time, window time.Duration) bool {
+	d := now.Sub(time.Unix(0, seen))
+	return d >= window || d <= -window
+}
+
+// apply names the server end of the packet, if the client was given a name
+// for it: it remembers the name the server end has, if that was attributed
+// to the client, or else gives it the remembered one, unless it has a name
+// from a source as confident or more.
+func (t *affinityTable) apply(m *Metadata) {
+	window := time.Duration(atomic.LoadInt64(&nameAffinity))
+	if window <= 0 || m.SrcIP == nil || m.DstIP == nil {
+		return
+	}
+	k := affinityKey{ipKey(m.SrcIP), ipKey(m.DstIP)}
+	name, source := &m.DstName, &m.DstNameSource
+	if !IsLocal(m.SrcIP) && IsLocal(m.DstIP) {
+		k.client, k.server = k.server, k.client
+		name, source = &m.SrcName, &m.SrcNameSource
+	}
+	if attributed(*source) {
+		t.set(k, *name, *source, m.Timestamp, window)
+		return
+	}
+	t.mu.RLock()
+	a := t.m[k]
+	t.mu.RUnlock()
+	if a == nil || stale(atomic.LoadInt64(&a.seen), m.Timestamp, window) || rank(a.source) <= rank(*source) || !enabled(a.source) {
+		return
+	}
+	atomic.StoreInt64(&a.seen, m.Timestamp.UnixNano())
+	*name, *source = a.name, a.source
+	atomic.AddUint64(&affinityApplied, 1)
+}
+
+// set remembers the name for the pair, as used at now.
+func (t *affinityTable) set(k affinityKey, name string, source NameSource, now time.Time, window time.Duration) {
+	t.mu.RLock()
+	a := t.m[k]
+	t.mu.RUnlock()
+	if a != nil && a.name == name && a.source == source {
+		atomic.StoreInt64(&a.seen, now.UnixNano())
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.m == nil {
+		t.m = make(map[affinityKey]*affinity)
+	}
+	if _, ok := t.m[k]; !ok && len(t.m) >= maxAffinities {
+		t.sweep(now, window)
+		if len(t.m) >= maxAffinities {
+			return
+		}
+	}
+	t.m[k] = &affinity{name: name, source: source, seen: now.UnixNano()}
+}
+
+// sweep forgets the pairs idle for the window before now. t.mu must be
+// held.
+func (t *affinityTable) sweep(now time.Time, window time.Duration) {
+	for k, a := range t.m {
+		if stale(atomic.LoadInt64(&a.seen), now, window) {
+			delete(t.m, k)
+		}
+	}
+}
+
+// len returns the number of pairs in the table.
+func (t *affinityTable) len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.m)
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAffinity(t *testing.T) {
+	defer SetNameAffinity(DefaultNameAffinity)
+	SetNameAffinity(30 * time.Minute)
+
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	client, other, server := net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3"), net.ParseIP("192.0.2.80")
+	meta := func(at time.Duration, src, dst net.IP) *Metadata {
+		m := &Metadata{Timestamp: start.Add(at), SrcIP: src, DstIP: dst}
+		m.SrcName, m.DstName = src.String(), dst.String()
+		return m
+	}
+	var tbl affinityTable
+
+	// The client looks the server up, and connects.
+	m := meta(0, client, server)
+	m.DstName, m.DstNameSource = "a.example", NameDNS
+	tbl.apply(m)
+
+	tests := []struct {
+		desc       string
+		m          *Metadata
+		want       string
+		wantSource NameSource
+		wantSrc    bool // the name is the source's
+	}{
+		{"resumed", meta(10*time.Minute, client, server), "a.example", NameDNS, false},
+		{"reply", meta(20*time.Minute, server, client), "a.example", NameDNS, true},
+		{"kept by use", meta(49*time.Minute, client, server), "a.example", NameDNS, false},
+		{"another client", meta(50*time.Minute, other, server), "192.0.2.80", NameNone, false},
+		{"idle too long", meta(80*time.Minute, client, server), "192.0.2.80", NameNone, false},
+	}
+	for _, test := range tests {
+		tbl.apply(test.m)
+		name, source := test.m.DstName, test.m.DstNameSource
+		if test.wantSrc {
+			name, source = test.m.SrcName, test.m.SrcNameSource
+		}
+		if name != test.want || source != test.wantSource {
+			t.Errorf("%s: got %q %v, want %q %v", test.desc, name, source, test.want, test.wantSource)
+		}
+	}
+
+	// A new name replaces the old, and doesn't replace a more confident one.
+	m = meta(90*time.Minute, client, server)
+	m.DstName, m.DstNameSource = "b.example", NameSNI
+	tbl.apply(m)
+	m = meta(91*time.Minute, client, server)
+	m.DstName, m.DstNameSource = "cdn", NameManual
+	if tbl.apply(m); m.DstName != "cdn" {
+		t.Errorf("labelled: got %q, want %q", m.DstName, "cdn")
+	}
+	m = meta(92*time.Minute, client, server)
+	if tbl.apply(m); m.DstName != "b.example" || m.DstNameSource != NameSNI {
+		t.Errorf("renamed: got %q %v, want %q %v", m.DstName, m.DstNameSource, "b.example", NameSNI)
+	}
+
+	SetNameAffinity(0)
+	m = meta(93*time.Minute, client, server)
+	if tbl.apply(m); m.DstNameSource != NameNone {
+		t.Errorf("off: got %q %v, want no name", m.DstName, m.DstNameSource)
+	}
+}
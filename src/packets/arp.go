@@ -0,0 +1,351 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file keeps a table of the devices on the LAN, learned from ARP and
+// DHCP, so devices show up even when they send no IP traffic worth counting,
+// and from network controllers, which know their names.
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	// maxDevices bounds the table; the longest-silent device is forgotten
+	// to make room.
+	maxDevices = 4096
+
+	// maxDeviceIPs bounds the addresses kept for each device.
+	maxDeviceIPs = 16
+)
+
+// Device is a host seen sending ARP or DHCP, or listed by a network
+// controller.
+type Device struct {
+	MAC       string
+	IPs       []string // most recently seen first
+	Interface string   `json:",omitempty"`
+	FirstSeen time.Time
+	LastSeen  time.Time
+
+	// From a controller, if any:
+	Name     string    `json:",omitempty"`
+	Wireless *Wireless `json:",omitempty"`
+
+	// From DHCP, if seen: the host name the device gave, and when its
+	// address's lease ends (zero if unknown or infinite).
+	HostName string    `json:",omitempty"`
+	LeaseEnd time.Time `json:",omitempty"`
+
+	// Vendor is who made the device's network card, from its MAC address,
+	// if known (see SetOUITable).
+	Vendor string `json:",omitempty"`
+}
+
+// Wireless describes a device's association with an access point.
+type Wireless struct {
+	SSID   string `json:",omitempty"`
+	AP     string `json:",omitempty"` // the access point's name or MAC
+	Signal int    `json:",omitempty"` // dBm
+}
+
+// deviceEntry is a Device, with the time each address was last seen.
+type deviceEntry struct {
+	Device
+	ipSeen map[string]time.Time
+}
+
+// deviceTable maps MAC addresses to devices.
+type deviceTable struct {
+	mu      sync.RWMutex
+	devices map[string]*deviceEntry
+	macs    map[[16]byte]string // devices' MAC addresses, by IP
+}
+
+// devices is the table for all captures.
+var devices = newDeviceTable()
+
+func newDeviceTable() *deviceTable {
+	return &deviceTable{
+		devices: make(map[string]*deviceEntry),
+		macs:    make(map[[16]byte]string),
+	}
+}
+
+// add records the sender of an ARP packet seen at t.
+func (d *deviceTable) add(arp *layers.ARP, iface string, t time.Time) {
+	if arp.AddrType != layers.LinkTypeEthernet || len(arp.SourceHwAddress) != 6 {
+		return
+	}
+	mac := net.HardwareAddr(arp.SourceHwAddress).String()
+	var ip net.IP
+	// ARP probes (RFC 5227) come from 0.0.0.0 while the address is checked.
+	if src := net.IP(arp.SourceProtAddress); len(src) == net.IPv4len && !src.IsUnspecified() {
+		ip = src
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.entry(mac)
+	if e.FirstSeen.IsZero() {
+		e.FirstSeen = t
+	}
+	if t.After(e.LastSeen) {
+		e.LastSeen = t
+	}
+	e.Interface = iface
+	if ip != nil {
+		d.addIP(e, ip, t)
+	}
+}
+
+// learn merges what a controller knows about a device, as of t.
+func (d *deviceTable) learn(dev Device, t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.entry(dev.MAC)
+	if !dev.LastSeen.IsZero() {
+		if e.FirstSeen.IsZero() {
+			e.FirstSeen = dev.LastSeen
+		}
+		if dev.LastSeen.After(e.LastSeen) {
+			e.LastSeen = dev.LastSeen
+		}
+	}
+	if dev.Name != "" {
+		e.Name = dev.Name
+	}
+	e.Wireless = dev.Wireless
+	for _, s := range dev.IPs {
+		if ip := net.ParseIP(s); ip != nil {
+			d.addIP(e, ip, t)
+		}
+	}
+}
+
+// addDHCP records what a DHCP message captured on iface at t says about the
+// device it is for, and returns the device's host name. A message from the
+// client shows it is there; an ACK gives it an address until the lease ends,
+// and a RELEASE ends the lease.
+func (d *deviceTable) addDHCP(m *dhcpMessage, iface string, t time.Time) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.entry(m.mac.String())
+	if m.op == 1 {
+		if e.FirstSeen.IsZero() {
+			e.FirstSeen = t
+		}
+		if t.After(e.LastSeen) {
+			e.LastSeen = t
+		}
+		e.Interface = iface
+	}
+	switch {
+	case m.op == 2 && m.typ == dhcpAck:
+		var ends time.Time
+		if m.lease > 0 {
+			ends = t.Add(m.lease)
+		}
+		d.addLease(e, m.given(), m.name, ends, t)
+	case m.name != "":
+		e.HostName = m.name
+	}
+	if m.op == 1 && m.typ == dhcpRelease {
+		e.LeaseEnd = t
+	}
+	return e.HostName
+}
+
+// addLease records that the device has the address, if any, until ends
+// (zero if unknown or never), and the host name it gave, if any, as of t.
+// d.mu must be held.
+func (d *deviceTable) addLease(e *deviceEntry, ip net.IP, host string, ends, t time.Time) {
+	if host != "" {
+		e.HostName = host
+	}
+	if ip != nil {
+		d.addIP(e, ip, t)
+	}
+	e.LeaseEnd = ends
+}
+
+// name returns the name of the device with the address, or "" if unknown.
+func (d *deviceTable) name(ip net.IP) string {
+	k := ipKey(ip)
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if e := d.devices[d.macs[k]]; e != nil {
+		return e.Name
+	}
+	return ""
+}
+
+func ipKey(ip net.IP) (k [16]byte) {
+	copy(k[:], ip.To16())
+	return k
+}
+
+// entry returns the device with the MAC address, adding it if need be. d.mu
+// must be held.
+func (d *deviceTable) entry(mac string) *deviceEntry {
+	e := d.devices[mac]
+	if e == nil {
+		if len(d.devices) >= maxDevices {
+			d.evict()
+		}
+		e = &deviceEntry{
+			Device: Device{MAC: mac},
+			ipSeen: make(map[string]time.Time),
+		}
+		d.devices[mac] = e
+	}
+	return e
+}
+
+// addIP records that the device had the address at t. d.mu must be held.
+func (d *deviceTable) addIP(e *deviceEntry, addr net.IP, t time.Time) {
+	ip := addr.String()
+	if _, ok := e.ipSeen[ip]; !ok && len(e.ipSeen) >= maxDeviceIPs {
+		var oldest string
+		for a, seen := range e.ipSeen {
+			if oldest == "" || seen.Before(e.ipSeen[oldest]) {
+				oldest = a
+			}
+		}
+		d.forgetIP(e, oldest)
+	}
+	if t.After(e.ipSeen[ip]) {
+		e.ipSeen[ip] = t
+	}
+	d.macs[ipKey(addr)] = e.MAC
+}
+
+// forgetIP removes an address from a device. d.mu must be held.
+func (d *deviceTable) forgetIP(e *deviceEntry, ip string) {
+	delete(e.ipSeen, ip)
+	k := ipKey(net.ParseIP(ip))
+	if d.macs[k] == e.MAC {
+		delete(d.macs, k)
+	}
+}
+
+// evict forgets the device that has been silent longest. d.mu must be held.
+func (d *deviceTable) evict() {
+	var oldest *deviceEntry
+	for _, e := range d.devices {
+		if oldest == nil || e.LastSeen.Before(oldest.LastSeen) {
+			oldest = e
+		}
+	}
+	if oldest != nil {
+		for ip := range oldest.ipSeen {
+			d.forgetIP(oldest, ip)
+		}
+		delete(d.devices, oldest.MAC)
+	}
+}
+
+// list returns the devices, most recently seen first.
+func (d *deviceTable) list() []Device {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	list := make([]Device, 0, len(d.devices))
+	for _, e := range d.devices {
+		dev := e.Device
+		dev.Vendor = Vendor(e.MAC)
+		dev.IPs = make([]string, 0, len(e.ipSeen))
+		for ip := range e.ipSeen {
+			dev.IPs = append(dev.IPs, ip)
+		}
+		sort.Slice(dev.IPs, func(i, j int) bool {
+			ti, tj := e.ipSeen[dev.IPs[i]], e.ipSeen[dev.IPs[j]]
+			if !ti.Equal(tj) {
+				return ti.After(tj)
+			}
+			return dev.IPs[i] < dev.IPs[j]
+		})
+		list = append(list, dev)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].LastSeen.Equal(list[j].LastSeen) {
+			return list[i].LastSeen.After(list[j].LastSeen)
+		}
+		return list[i].MAC < list[j].MAC
+	})
+	return list
+}
+
+// Devices returns the devices seen sending ARP or DHCP by any capture, or
+// learned from a controller, most recently seen first.
+func Devices() []Device {
+	return devices.list()
+}
+
+// learnDevices is 1 while devices are learned from the packets captured (see
+// SetDeviceLearning).
+var learnDevices int32 = 1
+
+// SetDeviceLearning turns learning devices from the ARP and DHCP packets
+// captured on or off; it is on by default.
+func SetDeviceLearning(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&learnDevices, v)
+}
+
+// learningDevices reports whether devices are learned from packets.
+func learningDevices() bool {
+	return atomic.LoadInt32(&learnDevices) != 0
+}
+
+// LearnDevice adds what a network controller knows about a device (by MAC)
+// to the table: its name and wireless association, and its addresses, which
+// are then named for it. LastSeen should be set only if the controller says
+// the device is connected; FirstSeen and Interface are ignored.
+func LearnDevice(dev Device) {
+	devices.learn(dev, time.Now())
+}
+
+// LearnLease adds a lease from a DHCP server's records to the table: the
+// device with the MAC address has the address until the lease ends (zero
+// for never), and gave the host name, if any, which then names the address.
+func LearnLease(mac net.HardwareAddr, ip net.IP, host string, ends time.Time) {
+	now := time.Now()
+	devices.mu.Lock()
+	devices.addLease(devices.entry(mac.String()), ip, host, ends, now)
+	devices.mu.Unlock()
+	globalNames.set(ip, host, NameDHCP, now)
+}
+
+// ForgetLease records that a lease LearnLease was given has ended: the
+// device's lease ends now, if it hadn't already, and the address loses the
+// host name, unless it has been named some other way since.
+func ForgetLease(mac net.HardwareAddr, ip net.IP, host string) {
+	now := time.Now()
+	devices.mu.Lock()
+	if e := devices.devices[mac.String()]; e != nil && (e.LeaseEnd.IsZero() || e.LeaseEnd.After(now)) {
+		e.LeaseEnd = now
+	}
+	devices.mu.Unlock()
+	globalNames.forget(ip, host, NameDHCP)
+}
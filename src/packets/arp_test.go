@@ -0,0 +1,81 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestDeviceTable(t *testing.T) {
+	d := newDeviceTable()
+	arp := func(mac string, ip string) *layers.ARP {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			t.Fatalf("ParseMAC(%q): %v", mac, err)
+		}
+		return &layers.ARP{
+			AddrType:          layers.LinkTypeEthernet,
+			SourceHwAddress:   hw,
+			SourceProtAddress: net.ParseIP(ip).To4(),
+		}
+	}
+	t0 := time.Unix(1456833600, 0)
+	d.add(arp("00:11:22:33:44:55", "192.168.1.10"), "br0", t0)
+	d.add(arp("00:11:22:33:44:55", "192.168.1.11"), "br0", t0.Add(time.Minute))
+	d.add(arp("66:77:88:99:aa:bb", "0.0.0.0"), "br0", t0.Add(30*time.Second)) // a probe
+
+	want := []Device{
+		{MAC: "00:11:22:33:44:55", IPs: []string{"192.168.1.11", "192.168.1.10"}, Interface: "br0", FirstSeen: t0, LastSeen: t0.Add(time.Minute)},
+		{MAC: "66:77:88:99:aa:bb", IPs: []string{}, Interface: "br0", FirstSeen: t0.Add(30 * time.Second), LastSeen: t0.Add(30 * time.Second)},
+	}
+	if got := d.list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("list:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestDeviceTableLearn(t *testing.T) {
+	d := newDeviceTable()
+	t0 := time.Unix(1456833600, 0)
+	d.learn(Device{
+		MAC:      "00:11:22:33:44:55",
+		IPs:      []string{"192.168.1.10"},
+		Name:     "Phone",
+		Wireless: &Wireless{SSID: "home", AP: "lounge", Signal: -52},
+		LastSeen: t0,
+	}, t0)
+	d.learn(Device{MAC: "66:77:88:99:aa:bb", IPs: []string{"192.168.1.20"}, Name: "Printer"}, t0)
+
+	if got := d.name(net.ParseIP("192.168.1.10")); got != "Phone" {
+		t.Errorf("name(192.168.1.10): got %q, want Phone", got)
+	}
+	if got := d.name(net.ParseIP("192.168.1.99")); got != "" {
+		t.Errorf("name(192.168.1.99): got %q, want empty", got)
+	}
+	list := d.list()
+	if len(list) != 2 {
+		t.Fatalf("list: got %d devices, want 2", len(list))
+	}
+	if got := list[0]; got.Name != "Phone" || got.Wireless == nil || got.Wireless.SSID != "home" || !got.FirstSeen.Equal(t0) {
+		t.Errorf("list[0]: got %+v, want the connected Phone", got)
+	}
+	if got := list[1]; got.Name != "Printer" || !got.LastSeen.IsZero() {
+		t.Errorf("list[1]: got %+v, want the Printer, never seen", got)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file labels non-local addresses with the autonomous system announcing
+// them, so traffic can be attributed to a network even when it has no name.
+
+import (
+	"sync/atomic"
+
+	"asn"
+)
+
+// asnTable holds an asnHolder; atomic.Value can't hold a nil interface.
+var asnTable atomic.Value
+
+type asnHolder struct{ asn.Table }
+
+// SetASNTable sets the table that non-local addresses are looked up in. It
+// may be changed while capturing; nil turns the lookups off.
+func SetASNTable(t asn.Table) {
+	asnTable.Store(asnHolder{t})
+}
+
+// lookupASN sets the AS of the non-local addresses of the packet.
+func lookupASN(p *Packet) {
+	h, _ := asnTable.Load().(asnHolder)
+	if h.Table == nil {
+		return
+	}
+	m := p.Meta
+	if !IsLocal(m.SrcIP) {
+		if i, ok := h.Lookup(m.SrcIP); ok {
+			m.SrcASN, m.SrcASOrg = i.Number, i.Org
+		}
+	}
+	if !IsLocal(m.DstIP) {
+		if i, ok := h.Lookup(m.DstIP); ok {
+			m.DstASN, m.DstASOrg = i.Number, i.Org
+		}
+	}
+}
+
+func init() {
+	RegisterEnricher("asn", func(*Capture) Enricher { return EnricherFunc(lookupASN) })
+}
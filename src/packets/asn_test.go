@@ -0,0 +1,68 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"net"
+	"testing"
+
+	"asn"
+)
+
+// fakeASNs is an asn.Table of whole addresses.
+type fakeASNs map[string]asn.Info
+
+func (f fakeASNs) Lookup(ip net.IP) (asn.Info, bool) {
+	i, ok := f[ip.String()]
+	return i, ok
+}
+
+func TestLookupASN(t *testing.T) {
+	defer SetASNTable(nil)
+	pkt := func(src, dst string) *Packet {
+		return &Packet{Meta: &Metadata{SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}}
+	}
+
+	// No table: nothing happens.
+	p := pkt("10.0.0.1", "1.1.1.1")
+	lookupASN(p)
+	if p.Meta.DstASN != 0 {
+		t.Errorf("DstASN without a table: got %d, want 0", p.Meta.DstASN)
+	}
+
+	SetASNTable(fakeASNs{
+		"1.1.1.1":  {Number: 13335, Org: "CLOUDFLARENET"},
+		"8.8.8.8":  {Number: 15169},
+		"10.0.0.1": {Number: 64512, Org: "should not be used"},
+	})
+	tests := []struct {
+		src, dst       string
+		srcASN, dstASN uint32
+		srcOrg, dstOrg string
+	}{
+		{"10.0.0.1", "1.1.1.1", 0, 13335, "", "CLOUDFLARENET"},
+		{"8.8.8.8", "10.0.0.1", 15169, 0, "", ""},
+		{"10.0.0.1", "192.0.2.1", 0, 0, "", ""},
+	}
+	for _, test := range tests {
+		p := pkt(test.src, test.dst)
+		lookupASN(p)
+		m := p.Meta
+		if m.SrcASN != test.srcASN || m.DstASN != test.dstASN || m.SrcASOrg != test.srcOrg || m.DstASOrg != test.dstOrg {
+			t.Errorf("%s -> %s: got AS%d %q -> AS%d %q, want AS%d %q -> AS%d %q", test.src, test.dst,
+				m.SrcASN, m.SrcASOrg, m.DstASN, m.DstASOrg, test.srcASN, test.srcOrg, test.dstASN, test.dstOrg)
+		}
+	}
+}
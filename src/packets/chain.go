@@ -0,0 +1,144 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file makes the name sources a configurable resolution chain: the
+// sources in order of confidence, most confident first. Sources left out of
+// the chain are off: their names aren't learned (so SNI isn't parsed if
+// "sni" is left out), and those learned before aren't used.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"vars"
+)
+
+// DefaultNameChain is the chain used until SetNameChain is called. SNI
+// beats DNS, as the name a client asked a server for is more specific than
+// whichever name the address was last an answer for. caplog's own lookups
+// are the last resort.
+var DefaultNameChain = []NameSource{NameManual, NameController, NameDHCP, NameMDNS, NameLLMNR, NameNetBIOS, NameSNI, NameDNS, NameShared, NamePTR, NameLookup}
+
+// nameRanks holds the *[numNameSources]int ranks of the sources in the
+// chain: the first has the highest, and those left out have 0.
+var nameRanks atomic.Value
+
+// nameStats count, by source, the names learned (or given again) and the
+// addresses in packets named. They are accessed atomically.
+var nameStats struct {
+	learned, used [numNameSources]uint64
+}
+
+func init() {
+	if err := SetNameChain(DefaultNameChain); err != nil {
+		panic(err)
+	}
+	vars.Register("names-chain", func() string { return strings.Join(nameChainStrings(NameChain()), ",") })
+	for s := NameSource(0); s < numNameSources; s++ {
+		if s != NameNone {
+			vars.Uint64("names-learned-"+s.String(), &nameStats.learned[s])
+		}
+		vars.Uint64("names-used-"+s.String(), &nameStats.used[s])
+	}
+}
+
+// SetNameChain sets the order of confidence of the name sources, most
+// confident first. Sources not in the chain are turned off. It may be
+// changed while capturing.
+func SetNameChain(chain []NameSource) error {
+	ranks, err := chainRanks(chain)
+	if err != nil {
+		return err
+	}
+	nameRanks.Store(ranks)
+	return nil
+}
+
+// chainRanks checks the chain, and ranks its sources.
+func chainRanks(chain []NameSource) (*[numNameSources]int, error) {
+	var ranks [numNameSources]int
+	for i, s := range chain {
+		if s == NameNone || s >= numNameSources {
+			return nil, fmt.Errorf("name chain: %v isn't a source", s)
+		}
+		if ranks[s] != 0 {
+			return nil, fmt.Errorf("name chain: %v is in it twice", s)
+		}
+		ranks[s] = len(chain) - i
+	}
+	return &ranks, nil
+}
+
+// ParseNameChain parses and checks a chain of source names, as in the
+// config.
+func ParseNameChain(names []string) ([]NameSource, error) {
+	chain := make([]NameSource, len(names))
+	for i, n := range names {
+		if err := chain[i].UnmarshalText([]byte(n)); err != nil {
+			return nil, fmt.Errorf("name chain: %v", err)
+		}
+	}
+	if _, err := chainRanks(chain); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// NameChain returns the current chain.
+func NameChain() []NameSource {
+	var chain []NameSource
+	for s := NameSource(1); s < numNameSources; s++ {
+		if enabled(s) {
+			chain = append(chain, s)
+		}
+	}
+	sort.Slice(chain, func(i, j int) bool { return rank(chain[i]) > rank(chain[j]) })
+	return chain
+}
+
+func nameChainStrings(chain []NameSource) []string {
+	s := make([]string, len(chain))
+	for i, src := range chain {
+		s[i] = src.String()
+	}
+	return s
+}
+
+// rank is the source's position from the end of the chain, or 0 if it is
+// off (or NameNone).
+func rank(s NameSource) int {
+	if s >= numNameSources {
+		return 0
+	}
+	return nameRanks.Load().(*[numNameSources]int)[s]
+}
+
+// enabled reports whether names from the source are learned and used.
+func enabled(s NameSource) bool {
+	return rank(s) > 0
+}
+
+// learned counts n names learned from the source.
+func learned(s NameSource, n int) {
+	atomic.AddUint64(&nameStats.learned[s], uint64(n))
+}
+
+// used counts an address named from the source.
+func used(s NameSource) {
+	atomic.AddUint64(&nameStats.used[s], 1)
+}
@@ -0,0 +1,135 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestParseNameChain(t *testing.T) {
+	got, err := ParseNameChain([]string{"sni", "dns", "manual"})
+	if err != nil {
+		t.Fatalf("ParseNameChain: %v", err)
+	}
+	if want := []NameSource{NameSNI, NameDNS, NameManual}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseNameChain: got %v, want %v", got, want)
+	}
+	for _, bad := range [][]string{{"dns", "nonsense"}, {"dns", "ptr", "dns"}, {"none"}} {
+		if _, err := ParseNameChain(bad); err == nil {
+			t.Errorf("ParseNameChain(%q): got no error, want one", bad)
+		}
+	}
+}
+
+func TestNameChain(t *testing.T) {
+	defer SetNameChain(DefaultNameChain)
+	revDNSMaps.Lock()
+	saved := revDNSMaps.list
+	revDNSMaps.Unlock()
+	defer func() {
+		revDNSMaps.Lock()
+		revDNSMaps.list = saved
+		revDNSMaps.Unlock()
+	}()
+
+	if got := NameChain(); !reflect.DeepEqual(got, DefaultNameChain) {
+		t.Errorf("NameChain: got %v, want the default %v", got, DefaultNameChain)
+	}
+
+	// SNI first, then DNS, and no PTR or shared names.
+	chain := []NameSource{NameSNI, NameDNS, NameManual}
+	if err := SetNameChain(chain); err != nil {
+		t.Fatalf("SetNameChain: %v", err)
+	}
+	if got := NameChain(); !reflect.DeepEqual(got, chain) {
+		t.Errorf("NameChain: got %v, want %v", got, chain)
+	}
+
+	c := &Capture{}
+	rm := c.reverseDNSMap()
+	host, other := net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")
+	cdn, label, ptr := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("192.0.2.3")
+	rm.addName(host, "dns.example", NameDNS, []net.IP{cdn, label})
+	rm.addName(host, "sni.example", NameSNI, []net.IP{cdn})
+	SetName(label, "labelled", NameManual)
+	globalNames.set(ptr, "ptr.example", NamePTR, time.Now())
+	defer ForgetNames(NameManual)
+
+	tests := []struct {
+		host, ip   net.IP
+		want       string
+		wantSource NameSource
+	}{
+		{host, cdn, "sni.example", NameSNI},
+		{host, label, "dns.example", NameDNS}, // DNS beats the manual label
+		{host, ptr, ptr.String(), NameNone},   // PTR is off
+		{other, cdn, cdn.String(), NameNone},  // shared names are off
+	}
+	for _, test := range tests {
+		flow := (&layers.IPv4{SrcIP: test.host, DstIP: test.ip}).NetworkFlow()
+		_, dst := rm.names(test.host, flow)
+		if name, source := bestName(test.ip, dst); name != test.want || source != test.wantSource {
+			t.Errorf("bestName(%v) for %v: got %q %v, want %q %v", test.ip, test.host, name, source, test.want, test.wantSource)
+		}
+	}
+
+	// With shared names on, another host's lookups are used, and say so.
+	SetNameChain([]NameSource{NameSNI, NameDNS, NameShared})
+	flow := (&layers.IPv4{SrcIP: other, DstIP: cdn}).NetworkFlow()
+	_, dst := rm.names(other, flow)
+	if name, source := bestName(cdn, dst); name != "sni.example" || source != NameShared {
+		t.Errorf("bestName(%v) for %v: got %q %v, want %q %v", cdn, other, name, source, "sni.example", NameShared)
+	}
+}
+
+func TestLearnMDNS(t *testing.T) {
+	defer ForgetNames(NameMDNS)
+	ip := net.ParseIP("192.168.1.40")
+	dns := &layers.DNS{
+		QR: true,
+		Answers: []layers.DNSResourceRecord{
+			{Name: []byte("_airplay._tcp.local"), Type: layers.DNSTypePTR, Class: layers.DNSClassIN},
+			{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, IP: net.ParseIP("192.168.1.41")},
+		},
+		Additionals: []layers.DNSResourceRecord{
+			{Name: []byte("Living-Room.local"), Type: layers.DNSTypeA, Class: layers.DNSClassIN | 0x8000, TTL: 120, IP: ip},
+		},
+	}
+	learnMDNS(dns, time.Now())
+	if got, _ := globalNames.get(ip); got.name != "Living-Room" || got.source != NameMDNS {
+		t.Errorf("name for %v: got %q %v, want %q %v", ip, got.name, got.source, "Living-Room", NameMDNS)
+	}
+	if got, ok := globalNames.get(net.ParseIP("192.168.1.41")); ok {
+		t.Errorf("name for a non-.local record: got %q, want none", got.name)
+	}
+
+	// A reverse PTR record names the address, and a goodbye forgets it.
+	printer := net.ParseIP("192.168.1.42")
+	ptr := layers.DNSResourceRecord{Name: []byte("42.1.168.192.in-addr.arpa"), Type: layers.DNSTypePTR, Class: layers.DNSClassIN, TTL: 120, PTR: []byte("Printer.local")}
+	learnMDNS(&layers.DNS{QR: true, Answers: []layers.DNSResourceRecord{ptr}}, time.Now())
+	if got, _ := globalNames.get(printer); got.name != "Printer" || got.source != NameMDNS {
+		t.Errorf("name for %v: got %q %v, want %q %v", printer, got.name, got.source, "Printer", NameMDNS)
+	}
+	ptr.TTL = 0
+	learnMDNS(&layers.DNS{QR: true, Answers: []layers.DNSResourceRecord{ptr}}, time.Now())
+	if got, ok := globalNames.get(printer); ok {
+		t.Errorf("name for %v after a goodbye: got %q, want none", printer, got.name)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file saves the processors' partial buffers when a capture stops, and
+// writes them out when it starts again, so a restart doesn't lose them.
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// saveCheckpoint appends a partial buffer to the checkpoint file, one JSON
+// record per line.
+func (c *Capture) saveCheckpoint(b []Metadata) error {
+	if len(b) == 0 {
+		return nil
+	}
+	c.checkpointMu.Lock()
+	defer c.checkpointMu.Unlock()
+	f, err := os.OpenFile(c.Checkpoint, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range b {
+		if err := enc.Encode(&b[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// restoreCheckpoint writes the buffers saved by an earlier run to the sink,
+// and removes the checkpoint file. A record cut short by a crash ends the
+// file.
+func (c *Capture) restoreCheckpoint() {
+	f, err := os.Open(c.Checkpoint)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("checkpoint: %v", err)
+		return
+	}
+	defer f.Close()
+
+	size := c.BufferSize
+	if size <= 0 {
+		size = 1
+	}
+	var (
+		buf   []Metadata
+		total int
+	)
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var m Metadata
+		if err := dec.Decode(&m); err != nil {
+			log.Printf("checkpoint %s: %v", c.Checkpoint, err)
+			break
+		}
+		buf = append(buf, m)
+		if len(buf) >= size {
+			c.writeSink(buf)
+			total += len(buf)
+			buf = nil
+		}
+	}
+	if len(buf) > 0 {
+		c.writeSink(buf)
+		total += len(buf)
+	}
+	log.Printf("checkpoint: restored %d packets from %s", total, c.Checkpoint)
+	if err := os.Remove(c.Checkpoint); err != nil {
+		log.Printf("checkpoint: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var written [][]Metadata
+	c := &Capture{
+		BufferSize: 2,
+		Checkpoint: filepath.Join(dir, "checkpoint.json"),
+		Sink: SinkFunc(func(_ context.Context, data []Metadata) error {
+			written = append(written, append([]Metadata(nil), data...))
+			return nil
+		}),
+	}
+	m := Metadata{
+		Timestamp:     time.Date(2015, 6, 11, 20, 46, 2, 0, time.UTC),
+		Size:          126,
+		SrcName:       "10.0.0.2",
+		DstName:       "example.com",
+		SrcIP:         net.ParseIP("10.0.0.2"),
+		DstIP:         net.ParseIP("93.184.216.34"),
+		DstNameSource: NameSNI,
+		SrcPort:       50003,
+		DstPort:       443,
+		Protocol:      6,
+	}
+	// Two processors stopping.
+	for _, b := range [][]Metadata{{m, m}, {m}} {
+		if err := c.saveCheckpoint(b); err != nil {
+			t.Fatalf("saveCheckpoint: %v", err)
+		}
+	}
+	// A record cut short by a crash.
+	f, err := os.OpenFile(c.Checkpoint, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	f.WriteString(`{"Timestamp":"2015-06-11T20:4`)
+	f.Close()
+
+	c.restoreCheckpoint()
+	if len(written) != 2 || len(written[0]) != 2 || len(written[1]) != 1 {
+		t.Fatalf("restoreCheckpoint wrote %d buffers, want buffers of 2 and 1: %+v", len(written), written)
+	}
+	got := written[1][0]
+	if !got.Timestamp.Equal(m.Timestamp) || !got.DstIP.Equal(m.DstIP) || got.DstName != m.DstName || got.DstNameSource != m.DstNameSource || got.DstPort != m.DstPort {
+		t.Errorf("restored: got %+v, want %+v", got, m)
+	}
+	if _, err := os.Stat(c.Checkpoint); !os.IsNotExist(err) {
+		t.Errorf("checkpoint still there after restoring: %v", err)
+	}
+
+	// Nothing to restore.
+	written = nil
+	c.restoreCheckpoint()
+	if len(written) != 0 {
+		t.Errorf("restoreCheckpoint without a checkpoint wrote %+v", written)
+	}
+}
@@ -18,10 +18,11 @@ package packets
 
 import (
 	"net"
+	"sync/atomic"
 )
 
 var (
-	LocalNetblock *net.IPNet
+	localNetblock atomic.Value // *net.IPNet, see SetLocalNetblock
 	stdLocalNets  = []*net.IPNet{
 		MustParseCIDR("10.0.0.0/8"), // RFC1918 IPv4 private addresses
 		MustParseCIDR("172.16.0.0/12"),
@@ -44,11 +45,23 @@ func MustParseCIDR(s string) *net.IPNet {
 	return cidr
 }
 
+// SetLocalNetblock sets a netblock to count as local, besides the private
+// and link-local ones, useful in case NAT is not in use. It may be changed
+// while capturing; nil means none.
+func SetLocalNetblock(n *net.IPNet) {
+	localNetblock.Store(n)
+}
+
 // IsLocal returns true if the IP is a private or link-local address. It also
-// considers the LocalNetblock passed in (from a flag), useful in case NAT is
-// not in use.
+// considers the netblock set by SetLocalNetblock, and the router's WAN
+// address (see SetWANIP).
 func IsLocal(ip net.IP) bool {
-	if LocalNetblock != nil && LocalNetblock.Contains(ip) {
+	return isPrivate(ip) || isWAN(ip)
+}
+
+// isPrivate is IsLocal without the WAN address.
+func isPrivate(ip net.IP) bool {
+	if n, _ := localNetblock.Load().(*net.IPNet); n != nil && n.Contains(ip) {
 		return true
 	}
 	for _, cidr := range stdLocalNets {
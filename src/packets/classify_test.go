@@ -33,3 +33,19 @@ func TestLocal(t *testing.T) {
 		}
 	}
 }
+
+func TestSetLocalNetblock(t *testing.T) {
+	defer SetLocalNetblock(nil)
+	ip := net.ParseIP("2001:db8::5")
+	if IsLocal(ip) {
+		t.Errorf("IsLocal(%v) without a local netblock: got true, want false", ip)
+	}
+	SetLocalNetblock(MustParseCIDR("2001:db8::/64"))
+	if !IsLocal(ip) {
+		t.Errorf("IsLocal(%v) in the local netblock: got false, want true", ip)
+	}
+	SetLocalNetblock(nil)
+	if IsLocal(ip) {
+		t.Errorf("IsLocal(%v) after clearing the local netblock: got true, want false", ip)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file learns from the DHCP messages captured: the host names clients
+// give, the addresses servers lease them, and for how long.
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"time"
+)
+
+// DHCP message types (option 53).
+const (
+	dhcpAck     = 5
+	dhcpRelease = 7
+)
+
+// dhcpMessage is what caplog reads from a DHCP (or BOOTP) message.
+type dhcpMessage struct {
+	op     byte // 1 from a client, 2 from a server
+	typ    byte // 0 if there is no option 53, as in BOOTP
+	mac    net.HardwareAddr
+	ciaddr net.IP // the client's address, if it has one
+	yiaddr net.IP // the address the server gives it
+
+	requested net.IP        // option 50
+	name      string        // option 12
+	lease     time.Duration // option 51; 0 if none or infinite
+}
+
+// parseDHCP reads a DHCP message from a UDP payload.
+func parseDHCP(b []byte) (*dhcpMessage, bool) {
+	// The fixed BOOTP header, then the magic cookie.
+	const (
+		htype   = 1
+		hlen    = 2
+		ciaddr  = 12
+		yiaddr  = 16
+		chaddr  = 28
+		options = 240
+	)
+	if len(b) < options || string(b[236:options]) != "\x63\x82\x53\x63" {
+		return nil, false
+	}
+	m := &dhcpMessage{
+		op:     b[0],
+		ciaddr: specified(b[ciaddr : ciaddr+4]),
+		yiaddr: specified(b[yiaddr : yiaddr+4]),
+	}
+	if b[htype] == 1 && b[hlen] == 6 { // Ethernet
+		m.mac = append(net.HardwareAddr(nil), b[chaddr:chaddr+6]...)
+	}
+	for o := b[options:]; len(o) > 0; {
+		code := o[0]
+		if code == 255 { // end
+			break
+		}
+		if code == 0 { // pad
+			o = o[1:]
+			continue
+		}
+		if len(o) < 2 || len(o) < 2+int(o[1]) {
+			break
+		}
+		data := o[2 : 2+int(o[1])]
+		o = o[2+len(data):]
+		switch code {
+		case 12:
+			m.name = strings.TrimRight(string(data), "\x00")
+		case 50:
+			if len(data) == net.IPv4len {
+				m.requested = specified(data)
+			}
+		case 51:
+			if len(data) == 4 {
+				if secs := binary.BigEndian.Uint32(data); secs != 0xffffffff {
+					m.lease = time.Duration(secs) * time.Second
+				}
+			}
+		case 53:
+			if len(data) == 1 {
+				m.typ = data[0]
+			}
+		}
+	}
+	return m, true
+}
+
+// specified copies the address, or returns nil if it is 0.0.0.0.
+func specified(ip net.IP) net.IP {
+	if ip.IsUnspecified() {
+		return nil
+	}
+	return append(net.IP(nil), ip...)
+}
+
+// addr returns the address the message is about: the client's address, the
+// one it requests, or the one it is given.
+func (m *dhcpMessage) addr() net.IP {
+	for _, ip := range []net.IP{m.ciaddr, m.requested, m.yiaddr} {
+		if ip != nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+// given returns the address a server's ACK gives the client: the one it
+// leases, or the client's own for an ACK to an INFORM.
+func (m *dhcpMessage) given() net.IP {
+	if m.yiaddr != nil {
+		return m.yiaddr
+	}
+	return m.ciaddr
+}
+
+// dhcpHostName returns the host name (option 12) in a DHCP message, and the
+// address it is for. It returns nil if the message has no host name or
+// address.
+func dhcpHostName(b []byte) (net.IP, string) {
+	m, ok := parseDHCP(b)
+	if !ok || m.name == "" || m.addr() == nil {
+		return nil, ""
+	}
+	return m.addr(), m.name
+}
+
+// learnDHCP learns from a DHCP message captured on iface at t. The host name
+// a client gives names its address, and is kept with its device, so that
+// the address a server later leases it is named too, even if the client
+// asked without one (as in a DISCOVER).
+func learnDHCP(b []byte, iface string, t time.Time) {
+	m, ok := parseDHCP(b)
+	if !ok {
+		return
+	}
+	name := m.name
+	if m.mac != nil && learningDevices() {
+		if n := devices.addDHCP(m, iface, t); name == "" {
+			name = n
+		}
+	}
+	switch {
+	case m.op == 2 && m.typ == dhcpAck:
+		globalNames.set(m.given(), name, NameDHCP, t)
+	case m.op == 1 && m.typ != dhcpRelease:
+		globalNames.set(m.addr(), name, NameDHCP, t)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// dhcpMessageFor makes a DHCP message from (op 1) or to (op 2) the client
+// with the Ethernet address.
+func dhcpMessageFor(op byte, mac string, ciaddr, yiaddr net.IP, options ...byte) []byte {
+	b := dhcpRequest(ciaddr, options...)
+	b[0] = op
+	b[1], b[2] = 1, 6
+	copy(b[16:20], yiaddr.To4())
+	hw, _ := net.ParseMAC(mac)
+	copy(b[28:], hw)
+	return b
+}
+
+func TestParseDHCP(t *testing.T) {
+	ack := dhcpMessageFor(2, "00:11:22:33:44:55", nil, net.ParseIP("192.168.1.20"), 53, 1, 5, 51, 4, 0, 0, 0x0e, 0x10)
+	m, ok := parseDHCP(ack)
+	if !ok {
+		t.Fatal("parseDHCP(ack): not DHCP")
+	}
+	want := &dhcpMessage{
+		op:     2,
+		typ:    dhcpAck,
+		mac:    net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		yiaddr: net.ParseIP("192.168.1.20").To4(),
+		lease:  time.Hour,
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("parseDHCP(ack):\ngot  %+v\nwant %+v", m, want)
+	}
+
+	infinite := dhcpMessageFor(2, "00:11:22:33:44:55", nil, net.ParseIP("192.168.1.20"), 53, 1, 5, 51, 4, 0xff, 0xff, 0xff, 0xff)
+	if m, _ := parseDHCP(infinite); m.lease != 0 {
+		t.Errorf("parseDHCP(infinite lease): got lease %v, want 0", m.lease)
+	}
+}
+
+func TestLearnDHCP(t *testing.T) {
+	const mac = "00:11:22:33:44:66"
+	ip := net.ParseIP("192.168.1.30")
+	defer globalNames.forget(ip, "tablet", NameDHCP)
+	t0 := time.Unix(1456833600, 0)
+
+	// The client asks for an address, giving its name but no address...
+	learnDHCP(dhcpMessageFor(1, mac, nil, nil, 53, 1, 1, 12, 6, 't', 'a', 'b', 'l', 'e', 't'), "br0", t0)
+	if _, ok := globalNames.get(ip); ok {
+		t.Fatalf("%v named before it was leased", ip)
+	}
+	// ...and the server's ACK leases it one, for an hour.
+	learnDHCP(dhcpMessageFor(2, mac, nil, ip, 53, 1, 5, 51, 4, 0, 0, 0x0e, 0x10), "br0", t0.Add(time.Second))
+	if e, ok := globalNames.get(ip); !ok || e.name != "tablet" || e.source != NameDHCP {
+		t.Errorf("name for %v: got %+v, want tablet from dhcp", ip, e)
+	}
+
+	var dev *Device
+	for _, d := range Devices() {
+		if d.MAC == mac {
+			dev = &d
+		}
+	}
+	if dev == nil {
+		t.Fatalf("no device %s", mac)
+	}
+	want := Device{
+		MAC:       mac,
+		IPs:       []string{"192.168.1.30"},
+		Interface: "br0",
+		FirstSeen: t0,
+		LastSeen:  t0,
+		HostName:  "tablet",
+		LeaseEnd:  t0.Add(time.Second + time.Hour),
+	}
+	if !reflect.DeepEqual(*dev, want) {
+		t.Errorf("device:\ngot  %+v\nwant %+v", *dev, want)
+	}
+
+	// Releasing the address ends the lease.
+	learnDHCP(dhcpMessageFor(1, mac, ip, nil, 53, 1, 7), "br0", t0.Add(time.Minute))
+	for _, d := range Devices() {
+		if d.MAC == mac && !d.LeaseEnd.Equal(t0.Add(time.Minute)) {
+			t.Errorf("lease end after release: got %v, want %v", d.LeaseEnd, t0.Add(time.Minute))
+		}
+	}
+}
+
+func TestLearnLease(t *testing.T) {
+	mac, _ := net.ParseMAC("00:11:22:33:44:77")
+	ip := net.ParseIP("192.168.1.40")
+	defer globalNames.forget(ip, "printer", NameDHCP)
+	ends := time.Unix(1456837200, 0)
+
+	LearnLease(mac, ip, "printer", ends)
+	if e, ok := globalNames.get(ip); !ok || e.name != "printer" || e.source != NameDHCP {
+		t.Errorf("name for %v: got %+v, want printer from dhcp", ip, e)
+	}
+	for _, d := range Devices() {
+		if d.MAC != mac.String() {
+			continue
+		}
+		if d.HostName != "printer" || !d.LeaseEnd.Equal(ends) || !reflect.DeepEqual(d.IPs, []string{"192.168.1.40"}) {
+			t.Errorf("device: got %+v, want printer at 192.168.1.40 until %v", d, ends)
+		}
+	}
+
+	// A lease that ran out already keeps its end.
+	ForgetLease(mac, ip, "printer")
+	if e, ok := globalNames.get(ip); ok {
+		t.Errorf("name for %v after ForgetLease: got %+v, want none", ip, e)
+	}
+	for _, d := range Devices() {
+		if d.MAC == mac.String() {
+			if !d.LeaseEnd.Equal(ends) {
+				t.Errorf("device after ForgetLease: lease ends %v, want %v", d.LeaseEnd, ends)
+			}
+			return
+		}
+	}
+	t.Errorf("no device %v", mac)
+}
@@ -0,0 +1,76 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file filters out sniffed DNS answers that shouldn't name anything:
+// the 0.0.0.0 and 127.0.0.1 that ad blockers answer with, records with
+// absurd TTLs, and responses that weren't sent to a local host.
+
+import (
+	"sync/atomic"
+
+	"github.com/google/gopacket/layers"
+
+	"vars"
+)
+
+// maxAnswerTTL is the longest TTL (in seconds) a believable answer has: a
+// week. Real records rarely have more than a day.
+const maxAnswerTTL = 7 * 24 * 60 * 60
+
+// dnsRejected counts the answers (or whole responses) ignored, by reason,
+// accessed atomically.
+var dnsRejected struct {
+	blocked, ttl, offNetwork, notResponse uint64
+}
+
+func init() {
+	vars.Uint64("dns-rejected-blocked", &dnsRejected.blocked)
+	vars.Uint64("dns-rejected-ttl", &dnsRejected.ttl)
+	vars.Uint64("dns-rejected-off-network", &dnsRejected.offNetwork)
+	vars.Uint64("dns-rejected-not-response", &dnsRejected.notResponse)
+}
+
+// trustedResponse reports whether the DNS layer of the packet is a response
+// sent to a local host, and so worth learning from.
+func trustedResponse(m *Metadata, dns *layers.DNS) bool {
+	if !dns.QR {
+		if len(dns.Answers) > 0 {
+			atomic.AddUint64(&dnsRejected.notResponse, 1)
+		}
+		return false
+	}
+	if !IsLocal(m.DstIP) {
+		atomic.AddUint64(&dnsRejected.offNetwork, 1)
+		return false
+	}
+	return true
+}
+
+// saneAnswer reports whether an answer record is believable: its TTL isn't
+// absurd, and if it is an address, it isn't unspecified or loopback.
+func saneAnswer(a *layers.DNSResourceRecord) bool {
+	if a.TTL > maxAnswerTTL {
+		atomic.AddUint64(&dnsRejected.ttl, 1)
+		return false
+	}
+	if a.Type == layers.DNSTypeA || a.Type == layers.DNSTypeAAAA {
+		if a.IP == nil || a.IP.IsUnspecified() || a.IP.IsLoopback() {
+			atomic.AddUint64(&dnsRejected.blocked, 1)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestSaneAnswer(t *testing.T) {
+	for _, test := range []struct {
+		desc string
+		rr   layers.DNSResourceRecord
+		want bool
+	}{
+		{"address", layers.DNSResourceRecord{Type: layers.DNSTypeA, TTL: 300, IP: net.ParseIP("93.184.216.34")}, true},
+		{"blocked", layers.DNSResourceRecord{Type: layers.DNSTypeA, TTL: 300, IP: net.IPv4zero}, false},
+		{"loopback", layers.DNSResourceRecord{Type: layers.DNSTypeA, TTL: 300, IP: net.ParseIP("127.0.0.1")}, false},
+		{"blocked v6", layers.DNSResourceRecord{Type: layers.DNSTypeAAAA, TTL: 300, IP: net.IPv6unspecified}, false},
+		{"absurd TTL", layers.DNSResourceRecord{Type: layers.DNSTypeA, TTL: 1 << 31, IP: net.ParseIP("93.184.216.34")}, false},
+		{"CNAME", layers.DNSResourceRecord{Type: layers.DNSTypeCNAME, TTL: 3600, CNAME: []byte("example.net")}, true},
+	} {
+		if got := saneAnswer(&test.rr); got != test.want {
+			t.Errorf("saneAnswer(%s): got %t, want %t", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestTrustedResponse(t *testing.T) {
+	answer := []layers.DNSResourceRecord{{Type: layers.DNSTypeA, IP: net.ParseIP("93.184.216.34")}}
+	for _, test := range []struct {
+		desc string
+		dst  string
+		dns  layers.DNS
+		want bool
+	}{
+		{"response to a local host", "192.168.1.5", layers.DNS{QR: true, Answers: answer}, true},
+		{"response to an outside host", "203.0.113.9", layers.DNS{QR: true, Answers: answer}, false},
+		{"query", "192.168.1.5", layers.DNS{Answers: answer}, false},
+	} {
+		m := &Metadata{SrcIP: net.ParseIP("8.8.8.8"), DstIP: net.ParseIP(test.dst)}
+		if got := trustedResponse(m, &test.dns); got != test.want {
+			t.Errorf("trustedResponse(%s): got %t, want %t", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestReverseDNSMapSkipsBlockedAnswers(t *testing.T) {
+	r := newReverseDNSMap()
+	r.add(&layers.DNS{
+		QR: true,
+		Answers: []layers.DNSResourceRecord{
+			{Name: []byte("ads.example"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, IP: net.IPv4zero},
+		},
+	})
+	if got := r.len(); got != 0 {
+		t.Errorf("len after a blocked answer: got %d, want 0", got)
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file reads the DNS responses that gopacket's decoder misses: DNS over
+// TCP (which resolvers fall back to for answers too big for UDP, and some
+// use always), and responses cut short, either by the server (with the TC
+// bit) or by IP fragmentation of a large EDNS response. The records that
+// arrived whole in a cut-short response are still good.
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"vars"
+)
+
+const (
+	dnsPort = 53
+
+	// maxDNSTCPStreams bounds the DNS over TCP connections followed.
+	maxDNSTCPStreams = 4096
+
+	// dnsTCPIdle is how long a connection can be quiet before it is
+	// forgotten.
+	dnsTCPIdle = 30 * time.Second
+
+	// maxDNSNamePointers bounds the compression pointers followed in a
+	// name, so loops end.
+	maxDNSNamePointers = 32
+)
+
+// dnsExtra counts the messages read here, accessed atomically.
+var dnsExtra struct {
+	tcp, partial uint64
+}
+
+func init() {
+	vars.Uint64("dns-tcp-messages", &dnsExtra.tcp)
+	vars.Uint64("dns-partial-messages", &dnsExtra.partial)
+}
+
+// dnsMessages returns the DNS messages in the packet: the one gopacket
+// decoded, or else any read here.
+func (c *Capture) dnsMessages(p *Packet) []*layers.DNS {
+	m := p.Meta
+	switch {
+	case p.Has(layers.LayerTypeTCP):
+		if m.SrcPort != dnsPort && m.DstPort != dnsPort {
+			return nil
+		}
+		var msgs []*layers.DNS
+		for _, b := range c.dnsTCP.messages(m, p.TCP) {
+			dns := new(layers.DNS)
+			if parseDNS(b, dns) {
+				atomic.AddUint64(&dnsExtra.tcp, 1)
+				msgs = append(msgs, dns)
+			}
+		}
+		return msgs
+	case p.Has(layers.LayerTypeDNS):
+		return []*layers.DNS{p.DNS}
+	case p.Has(layers.LayerTypeUDP):
+		if m.SrcPort != dnsPort && m.SrcPort != mdnsPort {
+			return nil
+		}
+		return partialDNS(p.UDP.Payload)
+	case p.Has(layers.LayerTypeIPv4) && p.IPv4.Protocol == layers.IPProtocolUDP &&
+		p.IPv4.Flags&layers.IPv4MoreFragments != 0 && p.IPv4.FragOffset == 0:
+		// The first fragment of a large response has the UDP header, and
+		// the start of the message.
+		b := p.IPv4.Payload
+		if len(b) < 8 || binary.BigEndian.Uint16(b) != dnsPort {
+			return nil
+		}
+		return partialDNS(b[8:])
+	}
+	return nil
+}
+
+// partialDNS reads a DNS message gopacket couldn't decode.
+func partialDNS(b []byte) []*layers.DNS {
+	dns := new(layers.DNS)
+	if !parseDNS(b, dns) {
+		return nil
+	}
+	atomic.AddUint64(&dnsExtra.partial, 1)
+	return []*layers.DNS{dns}
+}
+
+// dnsTCPStream is a DNS over TCP connection's data from the server that
+// hasn't made up a whole message yet.
+type dnsTCPStream struct {
+	next uint32 // the sequence number expected next
+	buf  []byte
+	seen time.Time
+}
+
+// dnsTCPTable follows DNS over TCP connections, by their flow from the
+// server.
+type dnsTCPTable struct {
+	mu      sync.Mutex
+	streams map[sniFlowKey]*dnsTCPStream // made when first needed
+}
+
+// messages adds a segment, and returns the messages it completes. Only the
+// server's side is followed, in order: a connection that loses a segment is
+// given up on until it starts a new message in a segment of its own.
+func (t *dnsTCPTable) messages(m *Metadata, tcp *layers.TCP) [][]byte {
+	if m.SrcPort != dnsPort {
+		return nil
+	}
+	k := sniFlowKey{ipKey(m.SrcIP), ipKey(m.DstIP), m.SrcPort, m.DstPort, m.Protocol}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.streams[k]
+	if tcp.FIN || tcp.RST {
+		delete(t.streams, k)
+		if s == nil || tcp.RST {
+			return nil
+		}
+	}
+	data := tcp.Payload
+	if len(data) == 0 {
+		return nil
+	}
+	if s != nil && tcp.Seq != s.next {
+		if d := s.next - tcp.Seq; d < uint32(len(data)) {
+			data = data[d:] // partly retransmitted
+		} else if int32(d) > 0 {
+			return nil // all retransmitted
+		} else {
+			s = nil // a gap
+		}
+	}
+	if s == nil {
+		// Only start at what looks like the start of a response.
+		if len(data) < 2+12 || data[2+2]&0x80 == 0 {
+			delete(t.streams, k)
+			return nil
+		}
+		if t.streams == nil {
+			t.streams = make(map[sniFlowKey]*dnsTCPStream)
+		}
+		if len(t.streams) >= maxDNSTCPStreams {
+			t.sweep(m.Timestamp)
+			if len(t.streams) >= maxDNSTCPStreams {
+				return nil
+			}
+		}
+		s = new(dnsTCPStream)
+		t.streams[k] = s
+	}
+	s.next = tcp.Seq + uint32(len(tcp.Payload))
+	s.seen = m.Timestamp
+	s.buf = append(s.buf, data...)
+
+	var msgs [][]byte
+	for len(s.buf) >= 2 {
+		n := int(binary.BigEndian.Uint16(s.buf))
+		if len(s.buf) < 2+n {
+			break
+		}
+		msgs = append(msgs, s.buf[2:2+n:2+n])
+		s.buf = s.buf[2+n:]
+	}
+	if len(s.buf) == 0 {
+		s.buf = nil // the messages keep the old one
+	}
+	return msgs
+}
+
+// sweep forgets the connections idle for dnsTCPIdle before now (or after
+// it, for replayed captures). t.mu must be held.
+func (t *dnsTCPTable) sweep(now time.Time) {
+	for k, s := range t.streams {
+		if d := now.Sub(s.seen); d >= dnsTCPIdle || d <= -dnsTCPIdle {
+			delete(t.streams, k)
+		}
+	}
+}
+
+// parseDNS reads a DNS message into dns, as far as it goes: it stops at the
+// first record that is cut short, keeping those before it. It reports
+// whether there was a header.
+func parseDNS(b []byte, dns *layers.DNS) bool {
+	*dns = layers.DNS{}
+	if len(b) < 12 {
+		return false
+	}
+	dns.ID = binary.BigEndian.Uint16(b)
+	dns.QR = b[2]&0x80 != 0
+	dns.AA = b[2]&0x04 != 0
+	dns.TC = b[2]&0x02 != 0
+	dns.RD = b[2]&0x01 != 0
+	dns.RA = b[3]&0x80 != 0
+	dns.ResponseCode = layers.DNSResponseCode(b[3] & 0x0f)
+	dns.QDCount = binary.BigEndian.Uint16(b[4:])
+	dns.ANCount = binary.BigEndian.Uint16(b[6:])
+	dns.NSCount = binary.BigEndian.Uint16(b[8:])
+	dns.ARCount = binary.BigEndian.Uint16(b[10:])
+
+	off := 12
+	for i := 0; i < int(dns.QDCount); i++ {
+		name, n := dnsName(b, off)
+		if n < 0 || len(b) < n+4 {
+			return true
+		}
+		dns.Questions = append(dns.Questions, layers.DNSQuestion{
+			Name:  name,
+			Type:  layers.DNSType(binary.BigEndian.Uint16(b[n:])),
+			Class: layers.DNSClass(binary.BigEndian.Uint16(b[n+2:])),
+		})
+		off = n + 4
+	}
+	for _, sec := range []struct {
+		count int
+		rrs   *[]layers.DNSResourceRecord
+	}{
+		{int(dns.ANCount), &dns.Answers},
+		{int(dns.NSCount), &dns.Authorities},
+		{int(dns.ARCount), &dns.Additionals},
+	} {
+		for i := 0; i < sec.count; i++ {
+			var rr layers.DNSResourceRecord
+			if off = dnsRecord(b, off, &rr); off < 0 {
+				return true
+			}
+			*sec.rrs = append(*sec.rrs, rr)
+		}
+	}
+	return true
+}
+
+// dnsRecord reads the resource record at off, returning the offset after
+// it, or -1 if it is cut short or malformed.
+func dnsRecord(b []byte, off int, rr *layers.DNSResourceRecord) int {
+	name, n := dnsName(b, off)
+	if n < 0 || len(b) < n+10 {
+		return -1
+	}
+	rr.Name = name
+	rr.Type = layers.DNSType(binary.BigEndian.Uint16(b[n:]))
+	rr.Class = layers.DNSClass(binary.BigEndian.Uint16(b[n+2:]))
+	rr.TTL = binary.BigEndian.Uint32(b[n+4:])
+	rr.DataLength = binary.BigEndian.Uint16(b[n+8:])
+	start := n + 10
+	end := start + int(rr.DataLength)
+	if len(b) < end {
+		return -1
+	}
+	rr.Data = b[start:end]
+	switch rr.Type {
+	case layers.DNSTypeA:
+		if rr.DataLength != net.IPv4len {
+			return -1
+		}
+		rr.IP = net.IP(rr.Data)
+	case layers.DNSTypeAAAA:
+		if rr.DataLength != net.IPv6len {
+			return -1
+		}
+		rr.IP = net.IP(rr.Data)
+	case layers.DNSTypeCNAME, layers.DNSTypePTR, layers.DNSTypeNS:
+		target, n := dnsName(b, start)
+		if n < 0 || n > end {
+			return -1
+		}
+		switch rr.Type {
+		case layers.DNSTypeCNAME:
+			rr.CNAME = target
+		case layers.DNSTypePTR:
+			rr.PTR = target
+		default:
+			rr.NS = target
+		}
+	}
+	return end
+}
+
+// dnsName reads the possibly compressed name at off in the message, without
+// a trailing dot, as gopacket does. It returns the offset after the name
+// (not after what a pointer points to), or -1 if it is cut short or
+// malformed.
+func dnsName(b []byte, off int) ([]byte, int) {
+	var name []byte
+	end, pointers := -1, 0
+	for {
+		if off >= len(b) {
+			return nil, -1
+		}
+		l := int(b[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return name, end
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(b) || pointers >= maxDNSNamePointers {
+				return nil, -1
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			pointers++
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+		case l&0xc0 != 0:
+			return nil, -1
+		default:
+			if off+1+l > len(b) || len(name)+l+1 > 255 {
+				return nil, -1
+			}
+			if len(name) > 0 {
+				name = append(name, '.')
+			}
+			name = append(name, b[off+1:off+1+l]...)
+			off += 1 + l
+		}
+	}
+}
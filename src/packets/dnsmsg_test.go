@@ -0,0 +1,212 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// dnsResponse builds a response to an A query for name, answered with a
+// CNAME to target (if set) and the addresses, using compression pointers as
+// servers do.
+func dnsResponse(name, target string, ips ...net.IP) []byte {
+	b := []byte{0x12, 0x34, 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0}
+	labels := func(n string) []byte {
+		var l []byte
+		for _, s := range splitLabels(n) {
+			l = append(l, byte(len(s)))
+			l = append(l, s...)
+		}
+		return append(l, 0)
+	}
+	b = append(b, labels(name)...)
+	b = append(b, 0, 1, 0, 1)
+	owner := uint16(12)
+	rr := func(typ uint16, data []byte) {
+		b = binary.BigEndian.AppendUint16(b, 0xc000|owner)
+		b = binary.BigEndian.AppendUint16(b, typ)
+		b = append(b, 0, 1, 0, 0, 1, 0)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+		b = append(b, data...)
+		binary.BigEndian.PutUint16(b[6:], binary.BigEndian.Uint16(b[6:])+1)
+	}
+	if target != "" {
+		rr(uint16(layers.DNSTypeCNAME), labels(target))
+		owner = uint16(len(b) - len(labels(target)))
+	}
+	for _, ip := range ips {
+		rr(uint16(layers.DNSTypeA), ip.To4())
+	}
+	return b
+}
+
+func splitLabels(n string) []string {
+	var l []string
+	for i := 0; i < len(n); {
+		j := i
+		for j < len(n) && n[j] != '.' {
+			j++
+		}
+		l = append(l, n[i:j])
+		i = j + 1
+	}
+	return l
+}
+
+func TestParseDNS(t *testing.T) {
+	ips := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("192.0.2.3")}
+	msg := dnsResponse("www.example", "cdn.example.net", ips...)
+	var dns layers.DNS
+	if !parseDNS(msg, &dns) {
+		t.Fatal("parseDNS: got false, want true")
+	}
+	if !dns.QR || dns.ANCount != 4 || len(dns.Questions) != 1 || string(dns.Questions[0].Name) != "www.example" {
+		t.Errorf("header: got QR %v, %d answers, questions %v", dns.QR, dns.ANCount, dns.Questions)
+	}
+	if len(dns.Answers) != 4 {
+		t.Fatalf("answers: got %d, want 4", len(dns.Answers))
+	}
+	if a := dns.Answers[0]; string(a.Name) != "www.example" || string(a.CNAME) != "cdn.example.net" {
+		t.Errorf("CNAME: got %s -> %s, want www.example -> cdn.example.net", a.Name, a.CNAME)
+	}
+	for i, a := range dns.Answers[1:] {
+		if string(a.Name) != "cdn.example.net" || !a.IP.Equal(ips[i]) || a.TTL != 256 {
+			t.Errorf("answer %d: got %s %v TTL %d, want cdn.example.net %v TTL 256", i+1, a.Name, a.IP, a.TTL, ips[i])
+		}
+	}
+
+	// Cut short in the last answer, the others are kept.
+	if !parseDNS(msg[:len(msg)-2], &dns) || len(dns.Answers) != 3 {
+		t.Errorf("truncated: got %d answers, want 3", len(dns.Answers))
+	}
+	if parseDNS(msg[:11], &dns) {
+		t.Error("short header: got true, want false")
+	}
+
+	// A pointer loop ends.
+	loop := append(append([]byte(nil), msg[:12]...), 0xc0, 12, 0, 1, 0, 1)
+	if !parseDNS(loop, &dns) || len(dns.Questions) != 0 {
+		t.Errorf("pointer loop: got questions %v, want none", dns.Questions)
+	}
+}
+
+func TestDNSOverTCP(t *testing.T) {
+	revDNSMaps.Lock()
+	saved := revDNSMaps.list
+	revDNSMaps.Unlock()
+	defer func() {
+		revDNSMaps.Lock()
+		revDNSMaps.list = saved
+		revDNSMaps.Unlock()
+	}()
+
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	client, resolver := net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1")
+	c := &Capture{}
+	c.reverseDNSMap()
+	seq := uint32(1000)
+	segment := func(payload []byte) *Packet {
+		p := &Packet{
+			Meta:    &Metadata{Timestamp: start, SrcIP: resolver, DstIP: client, SrcPort: 53, DstPort: 40000, Protocol: 6},
+			Decoded: []gopacket.LayerType{layers.LayerTypeIPv4, layers.LayerTypeTCP},
+			IPv4:    &layers.IPv4{SrcIP: resolver, DstIP: client},
+			TCP:     &layers.TCP{BaseLayer: layers.BaseLayer{Payload: payload}, Seq: seq},
+		}
+		seq += uint32(len(payload))
+		return p
+	}
+	lookup := func(ip string) string {
+		dst := net.ParseIP(ip)
+		m := &Metadata{Timestamp: start, SrcIP: client, DstIP: dst, SrcPort: 40001, DstPort: 443, Protocol: 6}
+		p := &Packet{Meta: m, Decoded: []gopacket.LayerType{layers.LayerTypeIPv4}, IPv4: &layers.IPv4{SrcIP: client, DstIP: dst}}
+		c.reverseDNS(p)
+		return m.DstName
+	}
+
+	msg := dnsResponse("big.example", "", net.ParseIP("192.0.2.10"))
+	stream := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+	stream = append(stream, msg...)
+	msg2 := dnsResponse("other.example", "", net.ParseIP("192.0.2.20"))
+	stream = binary.BigEndian.AppendUint16(stream, uint16(len(msg2)))
+	stream = append(stream, msg2...)
+
+	// The first message is split over two segments, and the second starts
+	// in the second segment; a retransmission of the first is ignored.
+	split := len(msg) / 2
+	first := stream[:split]
+	c.reverseDNS(segment(first))
+	if got := lookup("192.0.2.10"); got == "big.example" {
+		t.Errorf("after part of a message: got %q, want it not known yet", got)
+	}
+	seq -= uint32(len(first))
+	c.reverseDNS(segment(first))
+	c.reverseDNS(segment(stream[split : len(stream)-3]))
+	if got := lookup("192.0.2.10"); got != "big.example" {
+		t.Errorf("after the first message: got %q, want %q", got, "big.example")
+	}
+	c.reverseDNS(segment(stream[len(stream)-3:]))
+	if got := lookup("192.0.2.20"); got != "other.example" {
+		t.Errorf("after the second message: got %q, want %q", got, "other.example")
+	}
+	if n := len(c.dnsTCP.streams); n != 1 {
+		t.Errorf("streams: got %d, want 1", n)
+	}
+	fin := segment(nil)
+	fin.TCP.FIN = true
+	c.reverseDNS(fin)
+	if n := len(c.dnsTCP.streams); n != 0 {
+		t.Errorf("streams after FIN: got %d, want 0", n)
+	}
+}
+
+func TestDNSFirstFragment(t *testing.T) {
+	revDNSMaps.Lock()
+	saved := revDNSMaps.list
+	revDNSMaps.Unlock()
+	defer func() {
+		revDNSMaps.Lock()
+		revDNSMaps.list = saved
+		revDNSMaps.Unlock()
+	}()
+
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	client, resolver := net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1")
+	c := &Capture{}
+	c.reverseDNSMap()
+
+	msg := dnsResponse("frag.example", "", net.ParseIP("192.0.2.30"), net.ParseIP("192.0.2.31"))
+	udp := []byte{0, 53, 0x9c, 0x40, 0, 0, 0, 0}
+	udp = append(udp, msg[:len(msg)-6]...) // the rest is in the next fragment
+	c.reverseDNS(&Packet{
+		Meta:    &Metadata{Timestamp: start, SrcIP: resolver, DstIP: client, Protocol: 17},
+		Decoded: []gopacket.LayerType{layers.LayerTypeIPv4},
+		IPv4: &layers.IPv4{
+			BaseLayer: layers.BaseLayer{Payload: udp},
+			SrcIP:     resolver, DstIP: client,
+			Flags: layers.IPv4MoreFragments, Protocol: layers.IPProtocolUDP,
+		},
+	})
+	m := &Metadata{Timestamp: start, SrcIP: client, DstIP: net.ParseIP("192.0.2.30"), Protocol: 6}
+	c.reverseDNS(&Packet{Meta: m, Decoded: []gopacket.LayerType{layers.LayerTypeIPv4}, IPv4: &layers.IPv4{SrcIP: client, DstIP: m.DstIP}})
+	if m.DstName != "frag.example" {
+		t.Errorf("name from the first fragment: got %q, want %q", m.DstName, "frag.example")
+	}
+}
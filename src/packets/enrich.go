@@ -0,0 +1,249 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file runs enrichers: each adds some information (names, locations,
+// labels...) to a packet's Metadata, in a configurable order, within a time
+// budget per packet. A panicking enricher is counted and logged, and the
+// packet carries on without it.
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"vars"
+)
+
+// Packet is a decoded packet on its way to becoming Metadata. Enrichers read
+// its layers and fill in Meta.
+type Packet struct {
+	Meta *Metadata
+
+	// Decoded lists the layers decoded. The layer fields are only valid if
+	// their type is in Decoded.
+	Decoded []gopacket.LayerType
+	IPv4    *layers.IPv4
+	IPv6    *layers.IPv6
+	TCP     *layers.TCP
+	UDP     *layers.UDP
+	DNS     *layers.DNS
+}
+
+// Has reports whether a layer of type t was decoded.
+func (p *Packet) Has(t gopacket.LayerType) bool {
+	for _, d := range p.Decoded {
+		if d == t {
+			return true
+		}
+	}
+	return false
+}
+
+// NetworkFlow returns the IP flow of the packet.
+func (p *Packet) NetworkFlow() gopacket.Flow {
+	if p.Meta.V6 {
+		return p.IPv6.NetworkFlow()
+	}
+	return p.IPv4.NetworkFlow()
+}
+
+// Enricher adds information to packets. One Enricher is made per Capture,
+// and Enrich is called from every processor goroutine.
+type Enricher interface {
+	Enrich(*Packet)
+}
+
+// EnricherFunc is a func that is an Enricher.
+type EnricherFunc func(*Packet)
+
+// Enrich calls f(p).
+func (f EnricherFunc) Enrich(p *Packet) { f(p) }
+
+var (
+	enricherMu     sync.Mutex
+	enricherOrder  []string
+	enricherMakers = make(map[string]func(*Capture) Enricher)
+)
+
+// RegisterEnricher makes an enricher available under a name. By default,
+// enrichers run in the order they are registered.
+func RegisterEnricher(name string, make func(*Capture) Enricher) {
+	enricherMu.Lock()
+	defer enricherMu.Unlock()
+	if _, ok := enricherMakers[name]; ok {
+		panic("packets: enricher " + name + " registered twice")
+	}
+	enricherOrder = append(enricherOrder, name)
+	enricherMakers[name] = make
+}
+
+// EnricherNames returns the names of the registered enrichers, sorted.
+func EnricherNames() []string {
+	enricherMu.Lock()
+	defer enricherMu.Unlock()
+	names := append([]string(nil), enricherOrder...)
+	sort.Strings(names)
+	return names
+}
+
+// stage is an enricher in a pipeline, with its statistics.
+type stage struct {
+	name string
+	e    Enricher
+	off  int32 // accessed atomically
+
+	// Accessed atomically.
+	calls, panics, nanos uint64
+}
+
+// enrich runs the enricher on p, recovering from any panic.
+func (s *stage) enrich(p *Packet) {
+	start := time.Now()
+	defer func() {
+		atomic.AddUint64(&s.nanos, uint64(time.Since(start)))
+		if r := recover(); r != nil {
+			if atomic.AddUint64(&s.panics, 1) == 1 {
+				// Only log the first: it will probably happen a lot.
+				log.Printf("enricher %s panicked (further panics are only counted): %v", s.name, r)
+			}
+		}
+	}()
+	atomic.AddUint64(&s.calls, 1)
+	s.e.Enrich(p)
+}
+
+// avgNanos is the mean time spent per call.
+func (s *stage) avgNanos() uint64 {
+	n := atomic.LoadUint64(&s.calls)
+	if n == 0 {
+		return 0
+	}
+	return atomic.LoadUint64(&s.nanos) / n
+}
+
+// EnricherStats are the statistics of one of a capture's enrichers.
+type EnricherStats struct {
+	Name     string
+	On       bool
+	Calls    uint64
+	Panics   uint64
+	AvgNanos uint64
+}
+
+// EnricherStats returns statistics for the capture's enrichers, in order.
+func (c *Capture) EnricherStats() []EnricherStats {
+	pl, err := c.enrichment()
+	if err != nil {
+		return nil
+	}
+	st := make([]EnricherStats, len(pl.stages))
+	for i, s := range pl.stages {
+		st[i] = EnricherStats{
+			Name:     s.name,
+			On:       atomic.LoadInt32(&s.off) == 0,
+			Calls:    atomic.LoadUint64(&s.calls),
+			Panics:   atomic.LoadUint64(&s.panics),
+			AvgNanos: s.avgNanos(),
+		}
+	}
+	return st
+}
+
+// pipeline is a Capture's enrichers, in order.
+type pipeline struct {
+	stages []*stage
+	budget time.Duration
+	over   uint64 // packets that ran out of budget, accessed atomically
+}
+
+// enrichment returns the capture's pipeline, making it on first use.
+func (c *Capture) enrichment() (*pipeline, error) {
+	c.pipelineOnce.Do(func() {
+		enricherMu.Lock()
+		defer enricherMu.Unlock()
+		names := c.Enrichers
+		if names == nil {
+			names = enricherOrder
+		}
+		pl := &pipeline{budget: c.EnrichBudget}
+		for _, name := range names {
+			make := enricherMakers[name]
+			if make == nil {
+				c.pipelineErr = fmt.Errorf("unknown enricher %q", name)
+				return
+			}
+			pl.stages = append(pl.stages, &stage{name: name, e: make(c)})
+		}
+		c.pipeline = pl
+	})
+	return c.pipeline, c.pipelineErr
+}
+
+// SetEnricher turns one of the capture's enrichers on or off, while
+// capturing or before. It returns false if the enricher isn't in use.
+func (c *Capture) SetEnricher(name string, on bool) bool {
+	pl, err := c.enrichment()
+	if err != nil {
+		return false
+	}
+	var v int32
+	if !on {
+		v = 1
+	}
+	for _, s := range pl.stages {
+		if s.name == name {
+			atomic.StoreInt32(&s.off, v)
+			return true
+		}
+	}
+	return false
+}
+
+// run enriches a packet. Once the budget is spent, the remaining enrichers
+// are skipped.
+func (pl *pipeline) run(p *Packet) {
+	var start time.Time
+	if pl.budget > 0 {
+		start = time.Now()
+	}
+	for i, s := range pl.stages {
+		if atomic.LoadInt32(&s.off) != 0 {
+			continue
+		}
+		if pl.budget > 0 && i > 0 && time.Since(start) > pl.budget {
+			atomic.AddUint64(&pl.over, 1)
+			return
+		}
+		s.enrich(p)
+	}
+}
+
+// registerVars exposes each stage's statistics in /vars.
+func (pl *pipeline) registerVars() {
+	vars.Uint64("enrich-budget-exceeded", &pl.over)
+	for _, s := range pl.stages {
+		s := s
+		vars.Register("enrich-"+s.name+"-calls", vars.Uint64Eval(func() uint64 { return atomic.LoadUint64(&s.calls) }).String)
+		vars.Register("enrich-"+s.name+"-panics", vars.Uint64Eval(func() uint64 { return atomic.LoadUint64(&s.panics) }).String)
+		vars.Register("enrich-"+s.name+"-avg-ns", vars.Uint64Eval(s.avgNanos).String)
+	}
+}
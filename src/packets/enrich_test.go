@@ -0,0 +1,95 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	var ran []string
+	for _, name := range []string{"test-a", "test-b", "test-slow"} {
+		name := name
+		RegisterEnricher(name, func(*Capture) Enricher {
+			return EnricherFunc(func(p *Packet) {
+				ran = append(ran, name)
+				if name == "test-slow" {
+					time.Sleep(10 * time.Millisecond)
+				}
+			})
+		})
+	}
+
+	tests := []struct {
+		order  []string
+		off    string
+		budget time.Duration
+		want   []string
+	}{
+		{order: []string{"test-b", "test-a"}, want: []string{"test-b", "test-a"}},
+		{order: []string{"test-a", "test-b"}, off: "test-a", want: []string{"test-b"}},
+		{order: []string{"test-slow", "test-a", "test-b"}, budget: time.Millisecond, want: []string{"test-slow"}},
+	}
+	for _, test := range tests {
+		c := &Capture{Enrichers: test.order, EnrichBudget: test.budget}
+		if test.off != "" && !c.SetEnricher(test.off, false) {
+			t.Errorf("SetEnricher(%q): got false, want true", test.off)
+		}
+		pl, err := c.enrichment()
+		if err != nil {
+			t.Fatalf("enrichment(): %v", err)
+		}
+		ran = nil
+		pl.run(&Packet{Meta: &Metadata{}})
+		if !reflect.DeepEqual(ran, test.want) {
+			t.Errorf("order %v, %q off, budget %v: ran %v, want %v", test.order, test.off, test.budget, ran, test.want)
+		}
+	}
+
+	c := &Capture{Enrichers: []string{"no-such-enricher"}}
+	if _, err := c.enrichment(); err == nil {
+		t.Error("enrichment() with an unknown enricher: got nil error, want error")
+	}
+}
+
+func TestEnricherPanic(t *testing.T) {
+	RegisterEnricher("test-panic", func(*Capture) Enricher {
+		return EnricherFunc(func(p *Packet) { panic("oops") })
+	})
+	RegisterEnricher("test-after-panic", func(*Capture) Enricher {
+		return EnricherFunc(func(p *Packet) { p.Meta.SrcName = "enriched" })
+	})
+	c := &Capture{Enrichers: []string{"test-panic", "test-after-panic"}}
+	pl, err := c.enrichment()
+	if err != nil {
+		t.Fatalf("enrichment(): %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		m := &Metadata{}
+		pl.run(&Packet{Meta: m})
+		if got, want := m.SrcName, "enriched"; got != want {
+			t.Errorf("SrcName after a panicking enricher: got %q, want %q", got, want)
+		}
+	}
+	st := c.EnricherStats()
+	if got, want := st[0].Panics, uint64(3); got != want {
+		t.Errorf("test-panic Panics: got %d, want %d", got, want)
+	}
+	if got, want := st[1].Calls, uint64(3); got != want {
+		t.Errorf("test-after-panic Calls: got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,538 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file groups packets into flows: bidirectional sessions between two
+// addresses, ports and a protocol, with when they started and ended and the
+// packets and bytes each way. A flow's record is exported when the flow ends
+// (a FIN from each side, or a RST), once it has been idle for a while, and
+// every so often while it lasts, so that long flows are stored before they
+// end. A record per flow is far smaller than a row per packet.
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"vars"
+)
+
+const (
+	// DefaultFlowIdleTimeout is how long a flow can be quiet before it
+	// ends, by default.
+	DefaultFlowIdleTimeout = time.Minute
+
+	// DefaultFlowActiveTimeout is how long a flow can last before a record
+	// of it is exported (and a new one begun), by default.
+	DefaultFlowActiveTimeout = 30 * time.Minute
+
+	// flowShards is the number of shards in a flow table, as every
+	// processor adds every packet.
+	flowShards = 16
+
+	// maxFlows bounds a flow table. Packets of new flows beyond this aren't
+	// tracked.
+	maxFlows = 100000
+
+	// maxPendingFlows bounds the records waiting to be exported.
+	maxPendingFlows = 10000
+
+	// flowLinger is how long a TCP flow that has ended is kept after its
+	// last packet, so the final ACKs don't begin a new flow.
+	flowLinger = 2 * time.Second
+
+	// flowSweepInterval is how often (in packet time) each shard is swept
+	// of the flows that have ended.
+	flowSweepInterval = time.Second
+)
+
+// Why a flow record was exported.
+const (
+	FlowFIN     = "fin"     // each side sent a FIN
+	FlowRST     = "rst"     // a side sent a RST
+	FlowIdle    = "idle"    // no packets for the idle timeout
+	FlowActive  = "active"  // the flow lasted the active timeout, and goes on
+	FlowFlushed = "flushed" // the table was closed
+)
+
+// flowStats counts the flow records, accessed atomically.
+var flowStats struct {
+	exported, dropped uint64
+}
+
+func init() {
+	vars.Uint64("flows-exported", &flowStats.exported)
+	vars.Uint64("flows-dropped", &flowStats.dropped)
+}
+
+// When a flow's remote end was first named.
+const (
+	FlowNamedFirst = "first" // by the flow's first packet
+	FlowNamedLater = "later"
+)
+
+// Flow is the record of a flow. Src is the end that sent the earliest
+// packet, usually the client.
+type Flow struct {
+	Start, End       time.Time
+	SrcName, DstName string
+	SrcIP, DstIP     net.IP
+	SrcPort, DstPort uint16
+	V6               bool
+	Protocol         uint8
+	VLAN             uint16 `json:",omitempty"`
+	AppProtocol      string `json:",omitempty"`
+
+	// ICMPType and ICMPCode are those of the first ICMP message.
+	ICMPType, ICMPCode uint8 `json:",omitempty"`
+
+	// Named is when the remote end (Dst, unless only Src isn't local) was
+	// first named: FlowNamedFirst, FlowNamedLater (NameDelay after Start),
+	// or "" if it never was.
+	Named     string        `json:",omitempty"`
+	NameDelay time.Duration `json:",omitempty"`
+
+	// Alerts is the number of IDS alerts on the flow (see
+	// FlowTable.Alert).
+	Alerts int `json:",omitempty"`
+
+	// SrcPackets and SrcBytes were sent by Src, and DstPackets and
+	// DstBytes by Dst.
+	SrcPackets, SrcBytes uint64
+	DstPackets, DstBytes uint64
+
+	// TCPQuality counts the flow's retransmissions, out of order segments
+	// and duplicate ACKs, both ways.
+	TCPQuality
+
+	// FlowRTT has the round trip times of TCP flows.
+	FlowRTT
+
+	// Reason is why the record was exported: FlowFIN, FlowRST, FlowIdle,
+	// FlowActive or FlowFlushed.
+	Reason string
+}
+
+// flowKey identifies a flow in either direction: a is the lesser end.
+type flowKey struct {
+	a, b         [16]byte
+	aPort, bPort uint16
+	proto        uint8
+}
+
+// newFlowKey returns the key of the packet's flow, and whether the packet
+// is from its a end.
+func newFlowKey(m *Metadata) (flowKey, bool) {
+	src, dst := ipKey(m.SrcIP), ipKey(m.DstIP)
+	c := bytes.Compare(src[:], dst[:])
+	if c < 0 || (c == 0 && m.SrcPort <= m.DstPort) {
+		return flowKey{src, dst, m.SrcPort, m.DstPort, m.Protocol}, true
+	}
+	return flowKey{dst, src, m.DstPort, m.SrcPort, m.Protocol}, false
+}
+
+// shard returns the number of the key's shard (FNV-1a).
+func (k *flowKey) shard() int {
+	h := uint32(2166136261)
+	mix := func(b byte) { h = (h ^ uint32(b)) * 16777619 }
+	for i := range k.a {
+		mix(k.a[i])
+		mix(k.b[i])
+	}
+	mix(byte(k.aPort))
+	mix(byte(k.bPort))
+	mix(k.proto)
+	return int(h % flowShards)
+}
+
+// flowEntry is a flow in progress.
+type flowEntry struct {
+	Flow
+	srcIsA               bool       // Src is the key's a end
+	srcSource, dstSource NameSource // where the names came from
+	finSrc, finDst       bool       // FINs seen from each end
+	ended                string     // FlowFIN or FlowRST, once ended
+	touched              time.Time  // the wall clock at the last packet
+	tcpSrc, tcpDst       tcpSender  // the segments from each end
+	rtt                  flowRTT
+}
+
+// newFlowEntry begins a flow with the packet, whose end fromA is.
+func newFlowEntry(m *Metadata, fromA bool) *flowEntry {
+	e := &flowEntry{
+		Flow: Flow{
+			Start:    m.Timestamp,
+			End:      m.Timestamp,
+			SrcIP:    m.SrcIP,
+			DstIP:    m.DstIP,
+			SrcPort:  m.SrcPort,
+			DstPort:  m.DstPort,
+			V6:       m.V6,
+			Protocol: m.Protocol,
+			VLAN:     m.VLAN,
+		},
+		srcIsA: fromA,
+	}
+	if m.ICMP() {
+		e.ICMPType, e.ICMPCode = m.ICMPType, m.ICMPCode
+	}
+	return e
+}
+
+// swap makes Dst the Src, and Src the Dst.
+func (e *flowEntry) swap() {
+	f := &e.Flow
+	f.SrcName, f.DstName = f.DstName, f.SrcName
+	f.SrcIP, f.DstIP = f.DstIP, f.SrcIP
+	f.SrcPort, f.DstPort = f.DstPort, f.SrcPort
+	f.SrcPackets, f.DstPackets = f.DstPackets, f.SrcPackets
+	f.SrcBytes, f.DstBytes = f.DstBytes, f.SrcBytes
+	e.srcIsA = !e.srcIsA
+	e.srcSource, e.dstSource = e.dstSource, e.srcSource
+	e.finSrc, e.finDst = e.finDst, e.finSrc
+	e.tcpSrc, e.tcpDst = e.tcpDst, e.tcpSrc
+	e.rtt.src, e.rtt.dst = e.rtt.dst, e.rtt.src
+	if e.rtt.handshake == 0 {
+		// The handshake was timed the wrong way round.
+		e.rtt.synAt, e.rtt.synAckAt = time.Time{}, time.Time{}
+	}
+}
+
+// remoteNamed reports whether the remote end of the packet's flow has a
+// name. The remote end is the destination, unless only the source isn't
+// local.
+func remoteNamed(m *Metadata) bool {
+	if !IsLocal(m.SrcIP) && IsLocal(m.DstIP) {
+		return m.SrcNameSource != NameNone
+	}
+	return m.DstNameSource != NameNone
+}
+
+// count adds the packet, which is from Src if fromSrc, to the flow. A
+// packet from before the flow's Start (from a capture whose packets were
+// read out of order) moves Start back, and if it is from Dst, Dst becomes
+// Src.
+func (e *flowEntry) count(p *Packet, fromSrc bool, now time.Time) {
+	m := p.Meta
+	first := e.SrcPackets+e.DstPackets == 0
+	if m.Timestamp.Before(e.Start) {
+		e.Start = m.Timestamp
+		if !fromSrc {
+			e.swap()
+			fromSrc = true
+		}
+	}
+	if m.Timestamp.After(e.End) {
+		e.End = m.Timestamp
+	}
+	e.touched = now
+	if e.Named == "" && remoteNamed(m) {
+		if first {
+			e.Named = FlowNamedFirst
+		} else {
+			e.Named, e.NameDelay = FlowNamedLater, m.Timestamp.Sub(e.Start)
+		}
+	}
+	srcName, srcSource, dstName, dstSource := m.SrcName, m.SrcNameSource, m.DstName, m.DstNameSource
+	if fromSrc {
+		e.SrcPackets += m.Packets()
+		e.SrcBytes += m.Size
+	} else {
+		e.DstPackets += m.Packets()
+		e.DstBytes += m.Size
+		srcName, srcSource, dstName, dstSource = dstName, dstSource, srcName, srcSource
+	}
+	if e.SrcName == "" || rank(srcSource) > rank(e.srcSource) {
+		e.SrcName, e.srcSource = srcName, srcSource
+	}
+	if e.DstName == "" || rank(dstSource) > rank(e.dstSource) {
+		e.DstName, e.dstSource = dstName, dstSource
+	}
+	if m.AppProtocol != "" {
+		e.AppProtocol = m.AppProtocol
+	}
+	if !p.Has(layers.LayerTypeTCP) {
+		return
+	}
+	var q TCPQuality
+	if fromSrc {
+		e.tcpSrc.segment(p, &e.tcpDst, &q)
+	} else {
+		e.tcpDst.segment(p, &e.tcpSrc, &q)
+	}
+	e.TCPQuality.add(q)
+	e.rtt.segment(p, fromSrc)
+	switch {
+	case p.TCP.RST:
+		e.ended = FlowRST
+	case p.TCP.FIN:
+		if fromSrc {
+			e.finSrc = true
+		} else {
+			e.finDst = true
+		}
+		if e.finSrc && e.finDst && e.ended == "" {
+			e.ended = FlowFIN
+		}
+	}
+}
+
+// record returns the flow's record, exported for the reason.
+func (e *flowEntry) record(reason string) Flow {
+	f := e.Flow
+	f.FlowRTT = e.rtt.record()
+	f.Reason = reason
+	return f
+}
+
+type flowShard struct {
+	sync.Mutex
+	m     map[flowKey]*flowEntry // made when first needed
+	swept time.Time              // the packet time of the last sweep
+}
+
+// sweep removes the flows done says are done, appending their records to
+// recs. s must be locked.
+func (s *flowShard) sweep(recs []Flow, done func(*flowEntry) string) []Flow {
+	for k, e := range s.m {
+		if reason := done(e); reason != "" {
+			recs = append(recs, e.record(reason))
+			delete(s.m, k)
+		}
+	}
+	return recs
+}
+
+// FlowTable groups the packets of one or more captures into flows, and
+// hands the records of those that are done to Export, from Expire (which
+// should be called every second or so) and Close. Export isn't called while
+// packets are being processed, so it may take its time.
+type FlowTable struct {
+	// IdleTimeout and ActiveTimeout, if positive, replace
+	// DefaultFlowIdleTimeout and DefaultFlowActiveTimeout.
+	IdleTimeout, ActiveTimeout time.Duration
+
+	// Export receives the records of flows that are done, from one call of
+	// Expire or Close at a time.
+	Export func([]Flow)
+
+	shards [flowShards]flowShard
+
+	pendingMu sync.Mutex
+	pending   []Flow // records waiting for Expire
+
+	exportMu sync.Mutex
+}
+
+func (t *FlowTable) idleTimeout() time.Duration {
+	if t.IdleTimeout > 0 {
+		return t.IdleTimeout
+	}
+	return DefaultFlowIdleTimeout
+}
+
+func (t *FlowTable) activeTimeout() time.Duration {
+	if t.ActiveTimeout > 0 {
+		return t.ActiveTimeout
+	}
+	return DefaultFlowActiveTimeout
+}
+
+// quiet returns a done func for sweeps that ends the flows idle for the
+// idle timeout (or, once ended, flowLinger), by since.
+func (t *FlowTable) quiet(since func(*flowEntry) time.Duration) func(*flowEntry) string {
+	idle := t.idleTimeout()
+	return func(e *flowEntry) string {
+		d := since(e)
+		if d < 0 {
+			d = -d // a replayed capture, going back in time
+		}
+		switch {
+		case e.ended != "" && d >= flowLinger:
+			return e.ended
+		case d >= idle:
+			return FlowIdle
+		}
+		return ""
+	}
+}
+
+// AddMetadata adds a packet known only by its Metadata (from a probe, say)
+// to its flow.
+func (t *FlowTable) AddMetadata(m *Metadata) {
+	t.add(&Packet{Meta: m})
+}
+
+// add adds the packet to its flow.
+func (t *FlowTable) add(p *Packet) {
+	m := p.Meta
+	if m.SrcIP == nil || m.DstIP == nil {
+		return
+	}
+	k, fromA := newFlowKey(m)
+	s := &t.shards[k.shard()]
+	now := time.Now()
+	var recs []Flow
+
+	s.Lock()
+	if s.m == nil {
+		s.m = make(map[flowKey]*flowEntry)
+	}
+	e := s.m[k]
+	switch {
+	case e == nil:
+	case e.ended != "" && p.Has(layers.LayerTypeTCP) && p.TCP.SYN && !p.TCP.ACK:
+		// The ports are being used again, for a new connection.
+		recs = append(recs, e.record(e.ended))
+		e = nil
+	case m.Timestamp.Sub(e.Start) >= t.activeTimeout():
+		recs = append(recs, e.record(FlowActive))
+		e = nil
+	}
+	if e == nil {
+		delete(s.m, k)
+		if len(s.m) >= maxFlows/flowShards {
+			s.Unlock()
+			atomic.AddUint64(&flowStats.dropped, 1)
+			t.queue(recs)
+			return
+		}
+		e = newFlowEntry(m, fromA)
+		s.m[k] = e
+	}
+	e.count(p, fromA == e.srcIsA, now)
+	if d := m.Timestamp.Sub(s.swept); d >= flowSweepInterval || d <= -flowSweepInterval {
+		s.swept = m.Timestamp
+		recs = s.sweep(recs, t.quiet(func(e *flowEntry) time.Duration { return m.Timestamp.Sub(e.End) }))
+	}
+	s.Unlock()
+	t.queue(recs)
+}
+
+// queue adds records to those waiting to be exported.
+func (t *FlowTable) queue(recs []Flow) {
+	if len(recs) == 0 {
+		return
+	}
+	t.pendingMu.Lock()
+	defer t.pendingMu.Unlock()
+	if room := maxPendingFlows - len(t.pending); len(recs) > room {
+		atomic.AddUint64(&flowStats.dropped, uint64(len(recs)-room))
+		recs = recs[:room]
+	}
+	t.pending = append(t.pending, recs...)
+}
+
+// export hands the records waiting to Export.
+func (t *FlowTable) export() {
+	t.pendingMu.Lock()
+	recs := t.pending
+	t.pending = nil
+	t.pendingMu.Unlock()
+	if len(recs) == 0 {
+		return
+	}
+	atomic.AddUint64(&flowStats.exported, uint64(len(recs)))
+	if t.Export != nil {
+		t.Export(recs)
+	}
+}
+
+// Expire ends the flows that have had no packets for the idle timeout by
+// the wall clock (for when packets stop arriving), and exports the records
+// of the flows that are done.
+func (t *FlowTable) Expire(now time.Time) {
+	t.exportMu.Lock()
+	defer t.exportMu.Unlock()
+	done := t.quiet(func(e *flowEntry) time.Duration { return now.Sub(e.touched) })
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.Lock()
+		recs := s.sweep(nil, done)
+		s.Unlock()
+		t.queue(recs)
+	}
+	t.export()
+}
+
+// Close exports the records of all the flows, done or not.
+func (t *FlowTable) Close() error {
+	t.exportMu.Lock()
+	defer t.exportMu.Unlock()
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.Lock()
+		recs := s.sweep(nil, func(e *flowEntry) string {
+			if e.ended != "" {
+				return e.ended
+			}
+			return FlowFlushed
+		})
+		s.Unlock()
+		t.queue(recs)
+	}
+	t.export()
+	return nil
+}
+
+// Len returns the number of flows in progress.
+func (t *FlowTable) Len() int {
+	n := 0
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.Lock()
+		n += len(s.m)
+		s.Unlock()
+	}
+	return n
+}
+
+// find calls f with the flow between the ends, if it is in progress, while
+// it is locked.
+func (t *FlowTable) find(src, dst net.IP, srcPort, dstPort uint16, proto uint8, f func(*flowEntry)) bool {
+	k, _ := newFlowKey(&Metadata{SrcIP: src, DstIP: dst, SrcPort: srcPort, DstPort: dstPort, Protocol: proto})
+	s := &t.shards[k.shard()]
+	s.Lock()
+	defer s.Unlock()
+	e := s.m[k]
+	if e == nil {
+		return false
+	}
+	f(e)
+	return true
+}
+
+// Lookup returns the record so far of the flow between the ends (in either
+// direction), if it is in progress.
+func (t *FlowTable) Lookup(src, dst net.IP, srcPort, dstPort uint16, proto uint8) (Flow, bool) {
+	var f Flow
+	ok := t.find(src, dst, srcPort, dstPort, proto, func(e *flowEntry) { f = e.record("") })
+	return f, ok
+}
+
+// Alert counts an IDS alert on the flow between the ends, and returns its
+// record so far, if it is in progress.
+func (t *FlowTable) Alert(src, dst net.IP, srcPort, dstPort uint16, proto uint8) (Flow, bool) {
+	var f Flow
+	ok := t.find(src, dst, srcPort, dstPort, proto, func(e *flowEntry) {
+		e.Alerts++
+		f = e.record("")
+	})
+	return f, ok
+}
@@ -0,0 +1,166 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestFlowTable(t *testing.T) {
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	client, server := net.ParseIP("10.0.0.2"), net.ParseIP("192.0.2.80")
+
+	tcp := func(at time.Duration, src, dst net.IP, sport, dport uint16, size uint64, flags string) *Packet {
+		seg := &layers.TCP{SrcPort: layers.TCPPort(sport), DstPort: layers.TCPPort(dport)}
+		for _, f := range flags {
+			switch f {
+			case 'S':
+				seg.SYN = true
+			case 'A':
+				seg.ACK = true
+			case 'F':
+				seg.FIN = true
+			case 'R':
+				seg.RST = true
+			}
+		}
+		return &Packet{
+			Meta: &Metadata{
+				Timestamp: start.Add(at), Size: size,
+				SrcIP: src, DstIP: dst, SrcName: src.String(), DstName: dst.String(),
+				SrcPort: sport, DstPort: dport, Protocol: uint8(layers.IPProtocolTCP),
+			},
+			Decoded: []gopacket.LayerType{layers.LayerTypeIPv4, layers.LayerTypeTCP},
+			TCP:     seg,
+		}
+	}
+	udp := func(at time.Duration, src, dst net.IP, sport, dport uint16, size uint64) *Packet {
+		return &Packet{
+			Meta: &Metadata{
+				Timestamp: start.Add(at), Size: size,
+				SrcIP: src, DstIP: dst, SrcName: src.String(), DstName: dst.String(),
+				SrcPort: sport, DstPort: dport, Protocol: uint8(layers.IPProtocolUDP),
+			},
+			Decoded: []gopacket.LayerType{layers.LayerTypeIPv4, layers.LayerTypeUDP},
+		}
+	}
+
+	seq := func(p *Packet, seq, ack uint32) *Packet {
+		p.TCP.Seq, p.TCP.Ack = seq, ack
+		return p
+	}
+
+	var got []Flow
+	ft := &FlowTable{Export: func(recs []Flow) { got = append(got, recs...) }}
+	named := seq(tcp(10*time.Millisecond, server, client, 443, 50000, 60, "SA"), 500, 101)
+	named.Meta.SrcName, named.Meta.SrcNameSource = "a.example", NameSNI
+	for _, p := range []*Packet{
+		// A whole TCP connection.
+		seq(tcp(0, client, server, 50000, 443, 60, "S"), 100, 0),
+		named,
+		seq(tcp(20*time.Millisecond, client, server, 50000, 443, 500, "A"), 101, 501),
+		seq(tcp(30*time.Millisecond, client, server, 50000, 443, 60, "FA"), 101, 501),
+		seq(tcp(40*time.Millisecond, server, client, 443, 50000, 60, "FA"), 501, 102),
+		seq(tcp(50*time.Millisecond, client, server, 50000, 443, 60, "A"), 102, 502),
+		// Reset.
+		tcp(0, client, server, 50001, 443, 60, "S"),
+		tcp(10*time.Millisecond, server, client, 443, 50001, 60, "R"),
+		// Goes quiet.
+		udp(0, client, server, 5353, 53, 80),
+		udp(time.Second, server, client, 53, 5353, 120),
+		// Lasts.
+		udp(0, client, server, 40000, 4000, 100),
+	} {
+		ft.add(p)
+	}
+	if n := ft.Len(); n != 4 {
+		t.Errorf("Len() = %d, want 4", n)
+	}
+
+	// Not yet idle: only the ended flows.
+	ft.Expire(time.Now().Add(flowLinger))
+	check := func(when string, want []Flow) {
+		t.Helper()
+		sort.Slice(got, func(i, j int) bool {
+			if got[i].SrcPort != got[j].SrcPort {
+				return got[i].SrcPort < got[j].SrcPort
+			}
+			return got[i].Start.Before(got[j].Start)
+		})
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", when, got, want)
+		}
+		got = nil
+	}
+	check("expired", []Flow{
+		{Start: start, End: start.Add(50 * time.Millisecond), SrcName: "10.0.0.2", DstName: "a.example", SrcIP: client, DstIP: server, SrcPort: 50000, DstPort: 443, Protocol: 6, Named: FlowNamedLater, NameDelay: 10 * time.Millisecond,
+			SrcPackets: 4, SrcBytes: 680, DstPackets: 2, DstBytes: 120,
+			FlowRTT: FlowRTT{HandshakeRTT: 20 * time.Millisecond, SrcRTT: 10 * time.Millisecond, DstRTT: 10 * time.Millisecond, MinSrcRTT: 10 * time.Millisecond, MinDstRTT: 10 * time.Millisecond}, Reason: FlowFIN},
+		{Start: start, End: start.Add(10 * time.Millisecond), SrcName: "10.0.0.2", DstName: "192.0.2.80", SrcIP: client, DstIP: server, SrcPort: 50001, DstPort: 443, Protocol: 6, SrcPackets: 1, SrcBytes: 60, DstPackets: 1, DstBytes: 60, Reason: FlowRST},
+	})
+
+	// The long flow goes on past the active timeout, and then everything
+	// goes quiet.
+	ft.add(udp(31*time.Minute, client, server, 40000, 4000, 100))
+	ft.Expire(time.Now().Add(DefaultFlowIdleTimeout))
+	check("idle", []Flow{
+		{Start: start, End: start.Add(time.Second), SrcName: "10.0.0.2", DstName: "192.0.2.80", SrcIP: client, DstIP: server, SrcPort: 5353, DstPort: 53, Protocol: 17, SrcPackets: 1, SrcBytes: 80, DstPackets: 1, DstBytes: 120, Reason: FlowIdle},
+		{Start: start, End: start, SrcName: "10.0.0.2", DstName: "192.0.2.80", SrcIP: client, DstIP: server, SrcPort: 40000, DstPort: 4000, Protocol: 17, SrcPackets: 1, SrcBytes: 100, Reason: FlowActive},
+		{Start: start.Add(31 * time.Minute), End: start.Add(31 * time.Minute), SrcName: "10.0.0.2", DstName: "192.0.2.80", SrcIP: client, DstIP: server, SrcPort: 40000, DstPort: 4000, Protocol: 17, SrcPackets: 1, SrcBytes: 100, Reason: FlowIdle},
+	})
+
+	ft.add(udp(time.Hour, server, client, 123, 123, 90))
+	ft.Close()
+	check("closed", []Flow{
+		{Start: start.Add(time.Hour), End: start.Add(time.Hour), SrcName: "192.0.2.80", DstName: "10.0.0.2", SrcIP: server, DstIP: client, SrcPort: 123, DstPort: 123, Protocol: 17, SrcPackets: 1, SrcBytes: 90, Reason: FlowFlushed},
+	})
+	if n := ft.Len(); n != 0 {
+		t.Errorf("Len() after Close = %d, want 0", n)
+	}
+}
+
+func TestFlowTableOutOfOrder(t *testing.T) {
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	client, server := net.ParseIP("10.0.0.2"), net.ParseIP("192.0.2.80")
+	udp := func(at time.Duration, src, dst net.IP, sport, dport uint16, size uint64) *Metadata {
+		return &Metadata{
+			Timestamp: start.Add(at), Size: size,
+			SrcIP: src, DstIP: dst, SrcPort: sport, DstPort: dport,
+			Protocol: uint8(layers.IPProtocolUDP),
+		}
+	}
+
+	var got []Flow
+	ft := &FlowTable{Export: func(recs []Flow) { got = append(got, recs...) }}
+	// The answer is read before the query that came before it.
+	ft.AddMetadata(udp(20*time.Millisecond, server, client, 53, 5353, 120))
+	ft.AddMetadata(udp(0, client, server, 5353, 53, 80))
+	ft.AddMetadata(udp(30*time.Millisecond, client, server, 5353, 53, 80))
+	ft.Close()
+
+	want := []Flow{
+		{Start: start, End: start.Add(30 * time.Millisecond), SrcIP: client, DstIP: server, SrcPort: 5353, DstPort: 53, Protocol: 17, SrcPackets: 2, SrcBytes: 160, DstPackets: 1, DstBytes: 120, Reason: FlowFlushed},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file runs the pcap fixtures in testdata through the capture pipeline and
+// compares the results (each packet, then each flow record) with golden
+// files, so decoder changes can't silently change attribution. After an intended change, regenerate the golden files
+// with `go test packets -update` and review the diff.
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata.")
+
+// goldenRecord is the part of Metadata that the golden files pin down.
+type goldenRecord struct {
+	Timestamp        string
+	Size             uint64
+	SrcName, DstName string
+	SrcIP, DstIP     string
+	SrcPort, DstPort uint16
+	V6               bool
+	VLAN             uint16 `json:",omitempty"`
+}
+
+func toGolden(m *Metadata) goldenRecord {
+	return goldenRecord{
+		Timestamp: m.Timestamp.UTC().Format(time.RFC3339Nano),
+		Size:      m.Size,
+		SrcName:   m.SrcName,
+		DstName:   m.DstName,
+		SrcIP:     m.SrcIP.String(),
+		DstIP:     m.DstIP.String(),
+		SrcPort:   m.SrcPort,
+		DstPort:   m.DstPort,
+		V6:        m.V6,
+		VLAN:      m.VLAN,
+	}
+}
+
+// goldenFlow is the part of a Flow that the golden files pin down. Each is
+// wrapped as {"Flow": ...}, after the packets.
+type goldenFlow struct {
+	Start, End           string
+	SrcName, DstName     string
+	SrcIP, DstIP         string
+	SrcPort, DstPort     uint16
+	V6                   bool
+	Protocol             uint8
+	VLAN                 uint16 `json:",omitempty"`
+	AppProtocol          string `json:",omitempty"`
+	Named                string `json:",omitempty"`
+	SrcPackets, SrcBytes uint64
+	DstPackets, DstBytes uint64
+	Reason               string
+}
+
+func toGoldenFlow(f *Flow) goldenFlow {
+	return goldenFlow{
+		Start:       f.Start.UTC().Format(time.RFC3339Nano),
+		End:         f.End.UTC().Format(time.RFC3339Nano),
+		SrcName:     f.SrcName,
+		DstName:     f.DstName,
+		SrcIP:       f.SrcIP.String(),
+		DstIP:       f.DstIP.String(),
+		SrcPort:     f.SrcPort,
+		DstPort:     f.DstPort,
+		V6:          f.V6,
+		Protocol:    f.Protocol,
+		VLAN:        f.VLAN,
+		AppProtocol: f.AppProtocol,
+		Named:       f.Named,
+		SrcPackets:  f.SrcPackets,
+		SrcBytes:    f.SrcBytes,
+		DstPackets:  f.DstPackets,
+		DstBytes:    f.DstBytes,
+		Reason:      f.Reason,
+	}
+}
+
+func TestGoldenCorpus(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/*.pcap")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures in testdata")
+	}
+	for _, fixture := range fixtures {
+		var (
+			got   bytes.Buffer
+			flows []Flow
+		)
+		enc := json.NewEncoder(&got)
+		c := &Capture{
+			Flows: &FlowTable{Export: func(recs []Flow) { flows = append(flows, recs...) }},
+			Account: func(m *Metadata) {
+				if err := enc.Encode(toGolden(m)); err != nil {
+					t.Fatalf("Encode: %v", err)
+				}
+			},
+			BufferSize: 10,
+			// One processor, so packets are handled in order and DNS answers
+			// are always seen before the connections that follow them.
+			Processors: 1,
+		}
+		if err := c.File(fixture); err != nil {
+			t.Errorf("File(%q): %v", fixture, err)
+			continue
+		}
+		c.Flows.Close()
+		// The table's order is a map's.
+		sort.Slice(flows, func(i, j int) bool {
+			if !flows[i].Start.Equal(flows[j].Start) {
+				return flows[i].Start.Before(flows[j].Start)
+			}
+			return flows[i].SrcPort < flows[j].SrcPort
+		})
+		for i := range flows {
+			if err := enc.Encode(struct{ Flow goldenFlow }{toGoldenFlow(&flows[i])}); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+		}
+
+		golden := strings.TrimSuffix(fixture, ".pcap") + ".golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, got.Bytes(), 0644); err != nil {
+				t.Errorf("WriteFile: %v", err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("ReadFile: %v", err)
+			continue
+		}
+		if !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", fixture, got.Bytes(), want)
+		}
+	}
+}
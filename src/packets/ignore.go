@@ -0,0 +1,129 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file leaves some traffic out of the totals, such as a backup server's
+// nightly transfer, without filtering it out of the capture: it is still
+// decoded and named, and (unless a rule says to drop it) still recorded.
+
+import (
+	"encoding/json"
+	"net"
+	"sync/atomic"
+
+	"vars"
+)
+
+// IgnoreRule picks out traffic to leave out of Capture.Account. A packet
+// matches if one end is in Hosts (or Hosts is empty), the other end is in
+// Peers (or Peers is empty), and either port is in Ports (or Ports is
+// empty).
+type IgnoreRule struct {
+	Name  string
+	Hosts []*net.IPNet
+	Peers []*net.IPNet
+	Ports []uint16
+
+	// Drop leaves the packets out of the Capture's Sink too. Otherwise
+	// they are still recorded.
+	Drop bool
+
+	packets, bytes uint64 // matched, accessed atomically
+}
+
+var (
+	ignoreRules atomic.Value // []*IgnoreRule
+
+	// Matched by any rule since starting, accessed atomically.
+	ignoredPackets, ignoredBytes uint64
+)
+
+func init() {
+	vars.Uint64("ignored-packets", &ignoredPackets)
+	vars.Uint64("ignored-bytes", &ignoredBytes)
+	vars.Register("ignore-rules", ignoreRulesString)
+}
+
+// SetIgnoreRules replaces the rules for the traffic left out of the totals.
+// The first rule a packet matches applies. It may be changed while
+// capturing; nil means none.
+func SetIgnoreRules(rules []*IgnoreRule) {
+	ignoreRules.Store(rules)
+}
+
+// ignored reports whether the packet should be left out of the totals, and
+// whether it should be left out of the sink as well.
+func ignored(m *Metadata) (ignore, drop bool) {
+	rules, _ := ignoreRules.Load().([]*IgnoreRule)
+	for _, r := range rules {
+		if r.matches(m) {
+			atomic.AddUint64(&r.packets, 1)
+			atomic.AddUint64(&r.bytes, m.Size)
+			atomic.AddUint64(&ignoredPackets, 1)
+			atomic.AddUint64(&ignoredBytes, m.Size)
+			return true, r.Drop
+		}
+	}
+	return false, false
+}
+
+// matches reports whether the rule picks out the packet.
+func (r *IgnoreRule) matches(m *Metadata) bool {
+	if len(r.Ports) > 0 && !hasPort(r.Ports, m.SrcPort) && !hasPort(r.Ports, m.DstPort) {
+		return false
+	}
+	return r.ends(m.SrcIP, m.DstIP) || r.ends(m.DstIP, m.SrcIP)
+}
+
+// ends reports whether host is in r.Hosts and peer in r.Peers.
+func (r *IgnoreRule) ends(host, peer net.IP) bool {
+	return inNets(r.Hosts, host) && inNets(r.Peers, peer)
+}
+
+// inNets reports whether the address is in one of the netblocks, or there
+// are none.
+func inNets(nets []*net.IPNet, ip net.IP) bool {
+	if len(nets) == 0 {
+		return true
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPort(ports []uint16, port uint16) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreRulesString is the packets and bytes each rule in use has matched
+// since it was set, by name, as JSON.
+func ignoreRulesString() string {
+	type counts struct{ Packets, Bytes uint64 }
+	rules, _ := ignoreRules.Load().([]*IgnoreRule)
+	m := make(map[string]counts, len(rules))
+	for _, r := range rules {
+		m[r.Name] = counts{atomic.LoadUint64(&r.packets), atomic.LoadUint64(&r.bytes)}
+	}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	nets := func(cidrs ...string) []*net.IPNet {
+		var ns []*net.IPNet
+		for _, c := range cidrs {
+			_, n, err := net.ParseCIDR(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ns = append(ns, n)
+		}
+		return ns
+	}
+	backup := &IgnoreRule{Name: "backup", Hosts: nets("192.168.1.5/32"), Ports: []uint16{873}}
+	pair := &IgnoreRule{Name: "pair", Hosts: nets("192.168.1.10/32"), Peers: nets("192.168.1.11/32"), Drop: true}
+	SetIgnoreRules([]*IgnoreRule{backup, pair})
+	defer SetIgnoreRules(nil)
+
+	pkt := func(src string, sport uint16, dst string, dport uint16) *Metadata {
+		return &Metadata{SrcIP: net.ParseIP(src), SrcPort: sport, DstIP: net.ParseIP(dst), DstPort: dport, Size: 100}
+	}
+	tests := []struct {
+		name         string
+		m            *Metadata
+		ignore, drop bool
+	}{
+		{"backup out", pkt("192.168.1.5", 40000, "203.0.113.7", 873), true, false},
+		{"backup in", pkt("203.0.113.7", 873, "192.168.1.5", 40000), true, false},
+		{"backup server's other traffic", pkt("192.168.1.5", 40000, "203.0.113.7", 443), false, false},
+		{"pair", pkt("192.168.1.11", 445, "192.168.1.10", 50000), true, true},
+		{"one of the pair elsewhere", pkt("192.168.1.10", 50000, "192.168.1.12", 445), false, false},
+		{"other", pkt("192.168.1.20", 50000, "198.51.100.1", 443), false, false},
+	}
+	for _, test := range tests {
+		ignore, drop := ignored(test.m)
+		if ignore != test.ignore || drop != test.drop {
+			t.Errorf("%s: ignored = %v, %v; want %v, %v", test.name, ignore, drop, test.ignore, test.drop)
+		}
+	}
+	if backup.packets != 2 || backup.bytes != 200 || pair.packets != 1 {
+		t.Errorf("counts: backup %d packets, %d bytes; pair %d packets; want 2, 200, 1", backup.packets, backup.bytes, pair.packets)
+	}
+	if got, want := ignoreRulesString(), `{"backup":{"Packets":2,"Bytes":200},"pair":{"Packets":1,"Bytes":100}}`; got != want {
+		t.Errorf("ignore-rules var: got %s, want %s", got, want)
+	}
+}
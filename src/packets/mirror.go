@@ -0,0 +1,319 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file mirrors captured packets for another analyzer, such as ntopng or
+// tcpdump started on demand, so that it needn't open a pcap handle of its own
+// competing with caplog's for the interface. Clients of a unix socket are
+// sent a pcap stream; a tun device is written the bare IP packets.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+
+	"vars"
+)
+
+const (
+	mirrorSnapLen = MaxSnapLen // as much as any capture takes
+	mirrorQueue   = 1000       // packets queued for each output before dropping
+)
+
+var (
+	mirrorSent, mirrorDropped uint64 // accessed atomically
+)
+
+func init() {
+	vars.Uint64("mirror-packets", &mirrorSent)
+	vars.Uint64("mirror-dropped", &mirrorDropped)
+}
+
+// Mirror re-emits the captured packets that match its filter to its
+// outputs: the clients of a unix socket (see Listen) and a tun device (see
+// Tun). It never waits for an output: packets one isn't taking quickly
+// enough are dropped, and counted in the "mirror-dropped" var, so a slow
+// analyzer can't hold up the capture. A Mirror can be shared by several
+// captures.
+type Mirror struct {
+	filter *pcap.BPF // nil for every packet
+
+	mu     sync.Mutex
+	outs   map[*mirrorOut]bool
+	lns    []net.Listener
+	closed bool
+}
+
+// mirrorOut is one output of a Mirror, written by its own goroutine.
+type mirrorOut struct {
+	name string
+	ch   chan mirrored
+	w    io.WriteCloser
+	tun  bool // write the IP packets, instead of pcap records
+}
+
+// mirrored is a packet queued for an output.
+type mirrored struct {
+	ci   gopacket.CaptureInfo
+	data []byte
+}
+
+// NewMirror returns a Mirror of the packets matching the BPF expression
+// filter, or of every packet captured if it is empty. It has no outputs until
+// Listen or Tun is called.
+func NewMirror(filter string) (*Mirror, error) {
+	m := &Mirror{outs: make(map[*mirrorOut]bool)}
+	if filter != "" {
+		bpf, err := pcap.NewBPF(layers.LinkTypeEthernet, mirrorSnapLen, filter)
+		if err != nil {
+			return nil, fmt.Errorf("mirror filter: %v", err)
+		}
+		m.filter = bpf
+	}
+	return m, nil
+}
+
+// Listen accepts connections on a unix socket at path (replacing a stale one
+// left by an earlier run), and sends each a pcap stream of the mirrored
+// packets from when it connected, e.g. for "socat UNIX-CONNECT:path - |
+// tcpdump -r -".
+func (m *Mirror) Listen(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		ln.Close()
+		return errors.New("mirror closed")
+	}
+	m.lns = append(m.lns, ln)
+	m.mu.Unlock()
+	go m.accept(ln)
+	return nil
+}
+
+// accept adds each connection to ln as an output, until ln is closed.
+func (m *Mirror) accept(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if err := writePcapHeader(conn); err != nil {
+			log.Printf("mirror: %v", err)
+			conn.Close()
+			continue
+		}
+		m.add("client", conn, false)
+	}
+}
+
+// Tun writes the mirrored IP packets to the tun device called name,
+// creating it if it doesn't exist. That takes CAP_NET_ADMIN, and only works
+// on Linux.
+func (m *Mirror) Tun(name string) error {
+	f, err := openTun(name)
+	if err != nil {
+		return fmt.Errorf("tun %s: %v", name, err)
+	}
+	if !m.add("tun "+name, f, true) {
+		return errors.New("mirror closed")
+	}
+	return nil
+}
+
+// add starts writing to w, unless the Mirror was closed (which closes w).
+func (m *Mirror) add(name string, w io.WriteCloser, tun bool) bool {
+	o := &mirrorOut{name: name, ch: make(chan mirrored, mirrorQueue), w: w, tun: tun}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		w.Close()
+		return false
+	}
+	m.outs[o] = true
+	go m.serve(o)
+	return true
+}
+
+// serve writes the packets queued for o, until it fails (when o is
+// dropped) or the Mirror is closed.
+func (m *Mirror) serve(o *mirrorOut) {
+	defer o.w.Close()
+	for p := range o.ch {
+		var err error
+		if o.tun {
+			if ip := ipPacket(p.data); ip != nil {
+				_, err = o.w.Write(ip)
+			}
+		} else {
+			err = writePcapRecord(o.w, p.ci, p.data)
+		}
+		if err != nil {
+			m.mu.Lock()
+			delete(m.outs, o)
+			m.mu.Unlock()
+			if o.tun { // a client going away isn't worth logging
+				log.Printf("mirror: %s: %v", o.name, err)
+			}
+			return
+		}
+		atomic.AddUint64(&mirrorSent, 1)
+	}
+}
+
+// mirror queues the packet for each output, if it matches the filter.
+func (m *Mirror) mirror(ci gopacket.CaptureInfo, data []byte) {
+	if m.filter != nil && !m.filter.Matches(ci, data) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for o := range m.outs {
+		select {
+		case o.ch <- mirrored{ci, data}:
+		default:
+			atomic.AddUint64(&mirrorDropped, 1)
+		}
+	}
+}
+
+// Outputs returns the number of outputs being written to.
+func (m *Mirror) Outputs() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.outs)
+}
+
+// Close stops accepting clients, and closes the outputs once they have
+// written the packets queued for them.
+func (m *Mirror) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	var err error
+	for _, ln := range m.lns {
+		if e := ln.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	for o := range m.outs {
+		close(o.ch)
+		delete(m.outs, o)
+	}
+	return err
+}
+
+// writePcapHeader writes the header of a pcap stream of Ethernet frames.
+func writePcapHeader(w io.Writer) error {
+	var h [24]byte
+	binary.LittleEndian.PutUint32(h[0:], 0xa1b2c3d4) // microsecond timestamps
+	binary.LittleEndian.PutUint16(h[4:], 2)
+	binary.LittleEndian.PutUint16(h[6:], 4)
+	binary.LittleEndian.PutUint32(h[16:], mirrorSnapLen)
+	binary.LittleEndian.PutUint32(h[20:], uint32(layers.LinkTypeEthernet))
+	_, err := w.Write(h[:])
+	return err
+}
+
+// writePcapRecord writes a packet to a pcap stream.
+func writePcapRecord(w io.Writer, ci gopacket.CaptureInfo, data []byte) error {
+	if len(data) > mirrorSnapLen {
+		data = data[:mirrorSnapLen]
+	}
+	length := ci.Length
+	if length < len(data) {
+		length = len(data)
+	}
+	buf := make([]byte, 16+len(data))
+	binary.LittleEndian.PutUint32(buf[0:], uint32(ci.Timestamp.Unix()))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(ci.Timestamp.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(length))
+	copy(buf[16:], data)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ipPacket returns the IPv4 or IPv6 packet in an Ethernet frame (tagged with
+// a VLAN or not), or nil if it holds something else, such as ARP.
+func ipPacket(frame []byte) []byte {
+	if len(frame) < 14 {
+		return nil
+	}
+	etherType, rest := binary.BigEndian.Uint16(frame[12:]), frame[14:]
+	if etherType == uint16(layers.EthernetTypeDot1Q) {
+		if len(rest) < 4 {
+			return nil
+		}
+		etherType, rest = binary.BigEndian.Uint16(rest[2:]), rest[4:]
+	}
+	switch etherType {
+	case uint16(layers.EthernetTypeIPv4), uint16(layers.EthernetTypeIPv6):
+		return rest
+	}
+	return nil
+}
+
+// Linux's tun interface: see linux/if_tun.h.
+const (
+	tunSetIff = 0x400454ca
+	iffTun    = 0x0001
+	iffNoPI   = 0x1000
+)
+
+// openTun opens the tun device called name, without packet information
+// headers.
+func openTun(name string) (*os.File, error) {
+	if len(name) >= 16 {
+		return nil, errors.New("name too long")
+	}
+	f, err := os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	var req struct { // struct ifreq
+		name  [16]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(req.name[:], name)
+	req.flags = iffTun | iffNoPI
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), tunSetIff, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		f.Close()
+		return nil, errno
+	}
+	return f, nil
+}
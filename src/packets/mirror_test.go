@@ -0,0 +1,122 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+func TestIPPacket(t *testing.T) {
+	ip := []byte{0x45, 0, 0, 20}
+	eth := func(etherType ...byte) []byte {
+		return append(append(make([]byte, 12), etherType...), ip...)
+	}
+	tests := []struct {
+		name  string
+		frame []byte
+		want  []byte
+	}{
+		{"IPv4", eth(0x08, 0x00), ip},
+		{"IPv6", eth(0x86, 0xdd), ip},
+		{"VLAN", eth(0x81, 0x00, 0, 10, 0x08, 0x00), ip},
+		{"ARP", eth(0x08, 0x06), nil},
+		{"short", make([]byte, 10), nil},
+		{"short VLAN", eth(0x81, 0x00)[:16], nil},
+	}
+	for _, test := range tests {
+		if got := ipPacket(test.frame); !bytes.Equal(got, test.want) {
+			t.Errorf("%s: got %x, want %x", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMirrorListen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mirror.sock")
+
+	m, err := NewMirror("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.Listen(path); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatalf("reading the pcap header: %v", err)
+	}
+	if magic := binary.LittleEndian.Uint32(header); magic != 0xa1b2c3d4 {
+		t.Errorf("magic: got %x", magic)
+	}
+	if lt := binary.LittleEndian.Uint32(header[20:]); lt != 1 {
+		t.Errorf("link type: got %d, want 1 (Ethernet)", lt)
+	}
+
+	// The client is an output once the header has been written.
+	for m.Outputs() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	frame := []byte("not really a frame")
+	ts := time.Unix(1500000000, 250000000)
+	m.mirror(gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(frame), Length: 60}, frame)
+
+	record := make([]byte, 16+len(frame))
+	if _, err := io.ReadFull(conn, record); err != nil {
+		t.Fatalf("reading the packet: %v", err)
+	}
+	got := []uint32{
+		binary.LittleEndian.Uint32(record[0:]),
+		binary.LittleEndian.Uint32(record[4:]),
+		binary.LittleEndian.Uint32(record[8:]),
+		binary.LittleEndian.Uint32(record[12:]),
+	}
+	want := []uint32{1500000000, 250000, uint32(len(frame)), 60}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record header field %d: got %d, want %d", i, got[i], want[i])
+		}
+	}
+	if !bytes.Equal(record[16:], frame) {
+		t.Errorf("data: got %q, want %q", record[16:], frame)
+	}
+
+	// Closing the mirror ends the stream.
+	m.Close()
+	if n, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("after Close: read %d, %v; want EOF", n, err)
+	}
+}
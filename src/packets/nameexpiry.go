@@ -0,0 +1,173 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file bounds the reverse DNS maps, so a long-running capture doesn't
+// grow without limit: names expire once their DNS TTL has passed since they
+// were last given (or a minimum time, as connections outlive short TTLs), and
+// each map keeps a limited number of addresses, forgetting those given least
+// recently.
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+
+	"vars"
+)
+
+const (
+	// DefaultNameMinTTL is the least time a name is kept by default.
+	DefaultNameMinTTL = time.Hour
+
+	// DefaultNameMapSize is the most addresses a map keeps by default.
+	DefaultNameMapSize = 100000
+
+	// nameSweepInterval is how often the maps are swept of expired names.
+	nameSweepInterval = time.Minute
+)
+
+// nameLimits are the limits set by SetNameLimits, accessed atomically.
+var nameLimits = struct {
+	minTTL, mapSize int64
+}{int64(DefaultNameMinTTL), DefaultNameMapSize}
+
+// revDNSDropped counts the addresses forgotten, accessed atomically.
+var revDNSDropped struct {
+	expired, evicted uint64
+}
+
+func init() {
+	vars.Uint64("reverse-dns-expired", &revDNSDropped.expired)
+	vars.Uint64("reverse-dns-evicted", &revDNSDropped.evicted)
+}
+
+// SetNameLimits sets the least time a name learned for an address is kept
+// after it was last given, whatever its TTL, and the most addresses each
+// host's map (and the map of names given to anyone) keeps, 0 meaning no
+// limit. It may be changed while capturing.
+func SetNameLimits(minTTL time.Duration, mapSize int) {
+	atomic.StoreInt64(&nameLimits.minTTL, int64(minTTL))
+	atomic.StoreInt64(&nameLimits.mapSize, int64(mapSize))
+}
+
+// nameExpiry returns when a name given at t with the TTL expires.
+func nameExpiry(t time.Time, ttl time.Duration) time.Time {
+	if min := time.Duration(atomic.LoadInt64(&nameLimits.minTTL)); ttl < min {
+		ttl = min
+	}
+	return t.Add(ttl)
+}
+
+// shardCap returns the most addresses a shard of a map keeps, or 0 for no
+// limit.
+func shardCap() int {
+	n := int(atomic.LoadInt64(&nameLimits.mapSize))
+	if n <= 0 {
+		return 0
+	}
+	if n < revDNSShards {
+		return 1
+	}
+	return n / revDNSShards
+}
+
+// lastGiven returns when the most recent of the names was given.
+func lastGiven(set []nameEntry) time.Time {
+	var t time.Time
+	for i := range set {
+		if set[i].seen.After(t) {
+			t = set[i].seen
+		}
+	}
+	return t
+}
+
+// evict forgets the addresses given least recently, if there are more than
+// max (unless it is 0), down to 7/8 of max, so that the sorting is done once
+// for many additions. s must be locked.
+func (s *revDNSShard) evict(max int) {
+	if max == 0 || len(s.rm) <= max {
+		return
+	}
+	type given struct {
+		e    gopacket.Endpoint
+		seen time.Time
+	}
+	all := make([]given, 0, len(s.rm))
+	for e, set := range s.rm {
+		all = append(all, given{e, lastGiven(set)})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].seen.Before(all[j].seen) })
+	drop := len(all) - (max - max/8)
+	for _, g := range all[:drop] {
+		delete(s.rm, g.e)
+	}
+	atomic.AddUint64(&revDNSDropped.evicted, uint64(drop))
+}
+
+// sweep forgets the names that expired before now, and the addresses left
+// with none.
+func (r *reverseDNSMap) sweep(now time.Time) {
+	var expired uint64
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.Lock()
+		for e, set := range s.rm {
+			kept := set[:0]
+			for _, n := range set {
+				if n.expires.After(now) {
+					kept = append(kept, n)
+				}
+			}
+			switch {
+			case len(kept) == 0:
+				delete(s.rm, e)
+				expired++
+			case len(kept) < len(set):
+				s.rm[e] = kept
+			}
+		}
+		s.Unlock()
+	}
+	atomic.AddUint64(&revDNSDropped.expired, expired)
+}
+
+// maybeSweep sweeps every map, if it hasn't been done for
+// nameSweepInterval.
+func (m *multiReverseDNS) maybeSweep(now time.Time) {
+	last := atomic.LoadInt64(&m.swept)
+	if now.UnixNano()-last < int64(nameSweepInterval) || !atomic.CompareAndSwapInt64(&m.swept, last, now.UnixNano()) {
+		return
+	}
+	m.sweep(now)
+}
+
+// sweep sweeps every map. The hosts' maps are kept even if they empty, as
+// there are only as many as local hosts.
+func (m *multiReverseDNS) sweep(now time.Time) {
+	m.mu.RLock()
+	maps := make([]*reverseDNSMap, 0, len(m.maps)+1)
+	for _, rm := range m.maps {
+		maps = append(maps, rm)
+	}
+	m.mu.RUnlock()
+	maps = append(maps, m.everyone)
+	for _, rm := range maps {
+		rm.sweep(now)
+	}
+}
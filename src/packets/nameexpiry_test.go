@@ -0,0 +1,85 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestNameTTL(t *testing.T) {
+	defer SetNameLimits(DefaultNameMinTTL, DefaultNameMapSize)
+	SetNameLimits(10*time.Minute, DefaultNameMapSize)
+
+	r := newReverseDNSMap()
+	short, long := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+	r.add(&layers.DNS{
+		Answers: []layers.DNSResourceRecord{
+			{Name: []byte("short.example"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, IP: short, TTL: 60},
+			{Name: []byte("long.example"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, IP: long, TTL: 3600},
+		},
+	})
+	now := time.Now()
+	tests := []struct {
+		at   time.Duration
+		ip   net.IP
+		want string
+	}{
+		// The 60s TTL is raised to the minimum.
+		{5 * time.Minute, short, "short.example"},
+		{11 * time.Minute, short, "192.0.2.1"},
+		{11 * time.Minute, long, "long.example"},
+		{61 * time.Minute, long, "192.0.2.2"},
+	}
+	for _, test := range tests {
+		r.sweep(now.Add(test.at))
+		if got := r.name(layers.NewIPEndpoint(test.ip)); got != test.want {
+			t.Errorf("name(%v) after %v: got %q, want %q", test.ip, test.at, got, test.want)
+		}
+	}
+	if n := r.len(); n != 0 {
+		t.Errorf("len after every name expired: got %d, want 0", n)
+	}
+}
+
+func TestNameMapSize(t *testing.T) {
+	defer SetNameLimits(DefaultNameMinTTL, DefaultNameMapSize)
+	const size = 4 * revDNSShards
+	SetNameLimits(DefaultNameMinTTL, size)
+
+	r := newReverseDNSMap()
+	t0 := time.Now()
+	const n = 10 * size
+	for i := 0; i < n; i++ {
+		ip := net.IPv4(10, 0, byte(i>>8), byte(i))
+		r.set(layers.NewIPEndpoint(ip), fmt.Sprintf("h%d.example", i), NameDNS, t0.Add(time.Duration(i)*time.Second))
+	}
+	if got := r.len(); got > size {
+		t.Errorf("len: got %d, want at most %d", got, size)
+	}
+	// The most recently given are kept.
+	i := n - 1
+	last := net.IPv4(10, 0, byte(i>>8), byte(i))
+	if got, want := r.name(layers.NewIPEndpoint(last)), fmt.Sprintf("h%d.example", i); got != want {
+		t.Errorf("name of the last address: got %q, want %q", got, want)
+	}
+	if got := r.name(layers.NewIPEndpoint(net.IPv4(10, 0, 0, 0))); got != "10.0.0.0" {
+		t.Errorf("name of the first address: got %q, want it forgotten", got)
+	}
+}
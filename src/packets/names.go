@@ -0,0 +1,445 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file keeps track of where names come from. Each name for an address
+// has a source, and the sources are ordered by confidence (see chain.go), so
+// that by default a label someone typed in beats what a device calls itself,
+// which beats whatever happened to be in a DNS answer.
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"vars"
+)
+
+// maxNames bounds the table of host-independent names.
+const maxNames = 65536
+
+// NameSource is where a name came from. When two sources disagree about an
+// address, the one earlier in the name chain wins (see SetNameChain).
+type NameSource uint8
+
+const (
+	NameNone       NameSource = iota // no name; the address is used
+	NamePTR                          // a PTR answer seen on the wire
+	NameSNI                          // the server name in a TLS ClientHello
+	NameDNS                          // an A or AAAA answer, or a DNS server's log
+	NameDHCP                         // the host name a device gave its DHCP server
+	NameController                   // a network controller (UniFi, OpenWrt)
+	NameManual                       // a label in the config
+	NameMDNS                         // the name a device announced over mDNS
+	NameShared                       // a DNS or SNI name another host was given
+	NameLookup                       // a PTR lookup caplog made itself
+	NameLLMNR                        // the name a host answered LLMNR queries for
+	NameNetBIOS                      // a host's NetBIOS name
+
+	numNameSources
+)
+
+var nameSourceNames = [...]string{"none", "ptr", "sni", "dns", "dhcp", "controller", "manual", "mdns", "shared", "lookup", "llmnr", "netbios"}
+
+func (s NameSource) String() string {
+	if int(s) < len(nameSourceNames) {
+		return nameSourceNames[s]
+	}
+	return fmt.Sprintf("NameSource(%d)", s)
+}
+
+// MarshalText encodes the source as its name.
+func (s NameSource) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a source from its name.
+func (s *NameSource) UnmarshalText(b []byte) error {
+	for i, n := range nameSourceNames {
+		if n == string(b) {
+			*s = NameSource(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown name source %q", b)
+}
+
+// nameEntry is a name and where it came from.
+type nameEntry struct {
+	name    string
+	source  NameSource
+	seen    time.Time
+	hits    uint32    // times given, in a reverseDNSMap
+	expires time.Time // when a reverseDNSMap forgets it
+}
+
+// nameTable maps addresses to names that don't depend on who is asking:
+// PTR answers, DHCP host names, and manual labels.
+type nameTable struct {
+	mu sync.RWMutex
+	m  map[[16]byte]nameEntry
+}
+
+// globalNames is the table for all captures.
+var globalNames = &nameTable{m: make(map[[16]byte]nameEntry)}
+
+func init() {
+	vars.Register("names-size", vars.IntEval(func() int {
+		globalNames.mu.RLock()
+		defer globalNames.mu.RUnlock()
+		return len(globalNames.m)
+	}).String)
+}
+
+// set names the address, unless it already has a name from a more
+// confident source.
+func (t *nameTable) set(ip net.IP, name string, source NameSource, now time.Time) {
+	if ip == nil || name == "" || !enabled(source) {
+		return
+	}
+	learned(source, 1)
+	k := ipKey(ip)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old, ok := t.m[k]
+	if ok && rank(old.source) > rank(source) {
+		return
+	}
+	if !ok && len(t.m) >= maxNames {
+		t.evict()
+	}
+	t.m[k] = nameEntry{name: name, source: source, seen: now}
+}
+
+// evict forgets the oldest name that isn't a manual label. t.mu must be held.
+func (t *nameTable) evict() {
+	var (
+		oldest [16]byte
+		found  bool
+	)
+	for k, e := range t.m {
+		if e.source == NameManual {
+			continue
+		}
+		if !found || e.seen.Before(t.m[oldest].seen) {
+			oldest, found = k, true
+		}
+	}
+	if found {
+		delete(t.m, oldest)
+	}
+}
+
+// forget forgets the name for the address, if it is still the one given by
+// the source.
+func (t *nameTable) forget(ip net.IP, name string, source NameSource) {
+	k := ipKey(ip)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.m[k]; ok && e.name == name && e.source == source {
+		delete(t.m, k)
+	}
+}
+
+// get returns the name for the address, if any.
+func (t *nameTable) get(ip net.IP) (nameEntry, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	e, ok := t.m[ipKey(ip)]
+	return e, ok
+}
+
+// SetName names the address for every host, e.g. from a manual label. The
+// name replaces any other from a source no more confident than this one.
+func SetName(ip net.IP, name string, source NameSource) {
+	globalNames.set(ip, name, source, time.Now())
+}
+
+// ForgetNames forgets every host-independent name from the source, e.g.
+// the manual labels before the config is reloaded.
+func ForgetNames(source NameSource) {
+	globalNames.mu.Lock()
+	defer globalNames.mu.Unlock()
+	for k, e := range globalNames.m {
+		if e.source == source {
+			delete(globalNames.m, k)
+		}
+	}
+}
+
+// bestName chooses the most confident of the names known for the address:
+// the one from the local host's lookups (if any), the host-independent one,
+// and the name of the device with the address. With none, it is the address.
+func bestName(ip net.IP, local nameEntry) (string, NameSource) {
+	best := local
+	if e, ok := globalNames.get(ip); ok && rank(e.source) >= rank(best.source) {
+		best = e
+	}
+	if rank(NameController) >= rank(best.source) && enabled(NameController) {
+		if n := devices.name(ip); n != "" {
+			best = nameEntry{name: n, source: NameController}
+		}
+	}
+	if !enabled(best.source) {
+		best = nameEntry{}
+	}
+	if best.source == NameNone {
+		return ip.String(), NameNone
+	}
+	return best.name, best.source
+}
+
+// NameRecord is a name known for an address, for /api/names.
+type NameRecord struct {
+	Name   string
+	Source NameSource
+
+	// Host is set for names from a host's own lookups (DNS and SNI), which
+	// apply to that host's traffic first.
+	Host net.IP `json:",omitempty"`
+
+	// Hits is how many times the host was given the name, and LastSeen
+	// the last time.
+	Hits     uint32    `json:",omitempty"`
+	LastSeen time.Time `json:",omitempty"`
+}
+
+// revDNSMaps are the captures' reverse DNS maps, for Names.
+var revDNSMaps struct {
+	sync.Mutex
+	list []*multiReverseDNS
+}
+
+// Names returns every name known for the address, from every source, most
+// confident first. Names from sources that are off come last.
+func Names(ip net.IP) []NameRecord {
+	var recs []NameRecord
+	if n := devices.name(ip); n != "" {
+		recs = append(recs, NameRecord{Name: n, Source: NameController})
+	}
+	if e, ok := globalNames.get(ip); ok {
+		recs = append(recs, NameRecord{Name: e.name, Source: e.source})
+	}
+	revDNSMaps.Lock()
+	maps := revDNSMaps.list
+	revDNSMaps.Unlock()
+	ep := layers.NewIPEndpoint(ip)
+	for _, m := range maps {
+		for host, set := range m.lookupAll(ep) {
+			for _, e := range set {
+				recs = append(recs, NameRecord{Name: e.name, Source: e.source, Host: net.IP(host.Raw()), Hits: e.hits, LastSeen: e.seen})
+			}
+		}
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		if ri, rj := rank(recs[i].Source), rank(recs[j].Source); ri != rj {
+			return ri > rj
+		}
+		if recs[i].Host.String() != recs[j].Host.String() {
+			return recs[i].Host.String() < recs[j].Host.String()
+		}
+		return recs[i].Name < recs[j].Name
+	})
+	return recs
+}
+
+// ptrAddr returns the address a PTR query name is for, such as 1.2.3.4 for
+// "4.3.2.1.in-addr.arpa", or nil if it isn't one.
+func ptrAddr(name string) net.IP {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	switch {
+	case strings.HasSuffix(name, ".in-addr.arpa"):
+		parts := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa"), ".")
+		if len(parts) != 4 {
+			return nil
+		}
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+		return net.ParseIP(strings.Join(parts, ".")).To4()
+	case strings.HasSuffix(name, ".ip6.arpa"):
+		nibbles := strings.Split(strings.TrimSuffix(name, ".ip6.arpa"), ".")
+		if len(nibbles) != 32 {
+			return nil
+		}
+		ip := make(net.IP, net.IPv6len)
+		for i, n := range nibbles {
+			if len(n) != 1 {
+				return nil
+			}
+			var v byte
+			switch c := n[0]; {
+			case c >= '0' && c <= '9':
+				v = c - '0'
+			case c >= 'a' && c <= 'f':
+				v = c - 'a' + 10
+			default:
+				return nil
+			}
+			// Nibbles are least significant first.
+			b := 15 - i/2
+			if i%2 == 0 {
+				ip[b] |= v
+			} else {
+				ip[b] |= v << 4
+			}
+		}
+		return ip
+	}
+	return nil
+}
+
+// tlsServerName returns the server name in a TLS ClientHello, or "" if the
+// payload isn't one (or the name is in a later segment).
+func tlsServerName(b []byte) string {
+	// Record header: handshake (22), version, length.
+	if len(b) < 5 || b[0] != 22 {
+		return ""
+	}
+	return clientHelloServerName(b[5:])
+}
+
+// clientHelloServerName returns the server name in a ClientHello handshake
+// message (without a record header, as QUIC carries it), or "" if there is
+// none.
+func clientHelloServerName(b []byte) string {
+	// Handshake header: ClientHello (1), 24-bit length.
+	if len(b) < 4 || b[0] != 1 {
+		return ""
+	}
+	b = b[4:]
+	// Version and random.
+	if len(b) < 34 {
+		return ""
+	}
+	b = b[34:]
+	// Session ID, cipher suites, compression methods.
+	for _, lenBytes := range []int{1, 2, 1} {
+		var n int
+		b, n = readLength(b, lenBytes)
+		if n < 0 || len(b) < n {
+			return ""
+		}
+		b = b[n:]
+	}
+	b, n := readLength(b, 2)
+	if n < 0 || len(b) < n {
+		return ""
+	}
+	b = b[:n]
+	for len(b) >= 4 {
+		typ := int(b[0])<<8 | int(b[1])
+		n := int(b[2])<<8 | int(b[3])
+		b = b[4:]
+		if len(b) < n {
+			return ""
+		}
+		ext := b[:n]
+		b = b[n:]
+		if typ != 0 { // server_name
+			continue
+		}
+		// A list of (type, length, name); host_name is type 0.
+		ext, n = readLength(ext, 2)
+		if n < 0 || len(ext) < n {
+			return ""
+		}
+		ext = ext[:n]
+		for len(ext) >= 3 {
+			typ := ext[0]
+			var n int
+			ext, n = readLength(ext[1:], 2)
+			if n < 0 || len(ext) < n {
+				return ""
+			}
+			if typ == 0 {
+				return string(ext[:n])
+			}
+			ext = ext[n:]
+		}
+		return ""
+	}
+	return ""
+}
+
+// readLength reads a big-endian length of size bytes, returning the rest of
+// b, or -1 if b is too short.
+func readLength(b []byte, size int) ([]byte, int) {
+	if len(b) < size {
+		return b, -1
+	}
+	n := 0
+	for _, c := range b[:size] {
+		n = n<<8 | int(c)
+	}
+	return b[size:], n
+}
+
+// mdnsPort is the port mDNS responses are sent from.
+const mdnsPort = 5353
+
+// learnMDNS names the addresses in the A and AAAA records of an mDNS
+// response, which are the devices' own, and in its reverse PTR records
+// (which some devices answer with), with their .local names (without the
+// ".local"). A record with a TTL of 0 is a goodbye: the device is leaving, so
+// its name is forgotten.
+func learnMDNS(dns *layers.DNS, now time.Time) {
+	if !dns.QR {
+		return
+	}
+	for _, rrs := range [][]layers.DNSResourceRecord{dns.Answers, dns.Additionals} {
+		for i := range rrs {
+			a := &rrs[i]
+			// The top bit of the class is mDNS's cache-flush bit.
+			if a.Class&0x7fff != layers.DNSClassIN {
+				continue
+			}
+			var ip net.IP
+			var name string
+			switch a.Type {
+			case layers.DNSTypeA, layers.DNSTypeAAAA:
+				ip, name = a.IP, string(a.Name)
+			case layers.DNSTypePTR:
+				ip, name = ptrAddr(string(a.Name)), string(a.PTR)
+			default:
+				continue
+			}
+			name, ok := mdnsHostName(name)
+			if !ok || ip == nil || ip.IsUnspecified() {
+				continue
+			}
+			if a.TTL == 0 {
+				globalNames.forget(ip, name, NameMDNS)
+				continue
+			}
+			globalNames.set(ip, name, NameMDNS, now)
+		}
+	}
+}
+
+// mdnsHostName returns a .local name without the ".local", and whether it
+// was one.
+func mdnsHostName(name string) (string, bool) {
+	name = strings.TrimSuffix(name, ".")
+	if !strings.HasSuffix(name, ".local") {
+		return "", false
+	}
+	return strings.TrimSuffix(name, ".local"), true
+}
@@ -0,0 +1,195 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestPTRAddr(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want net.IP
+	}{
+		{"4.3.2.1.in-addr.arpa", net.ParseIP("1.2.3.4")},
+		{"4.3.2.1.IN-ADDR.ARPA.", net.ParseIP("1.2.3.4")},
+		{"b.a.9.8.7.6.5.0.4.0.0.0.3.0.0.0.2.0.0.0.1.0.0.0.0.0.0.0.1.2.3.4.ip6.arpa", net.ParseIP("4321:0:1:2:3:4:567:89ab")},
+		{"3.2.1.in-addr.arpa", nil},
+		{"example.com", nil},
+		{"x.3.2.1.in-addr.arpa", nil},
+	} {
+		if got := ptrAddr(test.name); !got.Equal(test.want) {
+			t.Errorf("ptrAddr(%q): got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// clientHello returns the first record a TLS client sends for the server name.
+func clientHello(t *testing.T, serverName string) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: serverName}).Handshake()
+		client.Close()
+	}()
+	hdr := make([]byte, 5)
+	if _, err := io.ReadFull(server, hdr); err != nil {
+		t.Fatalf("reading ClientHello: %v", err)
+	}
+	body := make([]byte, int(hdr[3])<<8|int(hdr[4]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatalf("reading ClientHello: %v", err)
+	}
+	return append(hdr, body...)
+}
+
+func TestTLSServerName(t *testing.T) {
+	hello := clientHello(t, "example.com")
+	if got, want := tlsServerName(hello), "example.com"; got != want {
+		t.Errorf("tlsServerName(hello): got %q, want %q", got, want)
+	}
+	for n := 0; n < len(hello); n += 7 {
+		if got := tlsServerName(hello[:n]); got != "" {
+			t.Errorf("tlsServerName(hello[:%d]): got %q, want \"\"", n, got)
+		}
+	}
+	if got := tlsServerName([]byte("GET / HTTP/1.1\r\n\r\n")); got != "" {
+		t.Errorf("tlsServerName(HTTP): got %q, want \"\"", got)
+	}
+}
+
+// dhcpRequest makes a DHCP request from a client with the options.
+func dhcpRequest(ciaddr net.IP, options ...byte) []byte {
+	b := make([]byte, 240)
+	b[0] = 1 // BOOTREQUEST
+	copy(b[12:16], ciaddr.To4())
+	copy(b[236:], "\x63\x82\x53\x63")
+	return append(append(b, options...), 255)
+}
+
+func TestDHCPHostName(t *testing.T) {
+	for _, test := range []struct {
+		desc     string
+		msg      []byte
+		wantIP   net.IP
+		wantName string
+	}{
+		{
+			desc:     "requested address",
+			msg:      dhcpRequest(nil, 53, 1, 3, 50, 4, 192, 168, 1, 20, 0, 12, 6, 'l', 'a', 'p', 't', 'o', 'p'),
+			wantIP:   net.ParseIP("192.168.1.20"),
+			wantName: "laptop",
+		},
+		{
+			desc:     "renewal",
+			msg:      dhcpRequest(net.ParseIP("192.168.1.21"), 12, 5, 'p', 'h', 'o', 'n', 'e'),
+			wantIP:   net.ParseIP("192.168.1.21"),
+			wantName: "phone",
+		},
+		{
+			desc: "no host name",
+			msg:  dhcpRequest(nil, 50, 4, 192, 168, 1, 20),
+		},
+		{
+			desc: "truncated option",
+			msg:  dhcpRequest(nil, 50, 4, 192, 168, 1, 20, 12, 200, 'x'),
+		},
+		{
+			desc: "not DHCP",
+			msg:  make([]byte, 300),
+		},
+	} {
+		ip, name := dhcpHostName(test.msg)
+		if !ip.Equal(test.wantIP) || name != test.wantName {
+			t.Errorf("dhcpHostName(%s): got %v %q, want %v %q", test.desc, ip, name, test.wantIP, test.wantName)
+		}
+	}
+}
+
+func TestNameTable(t *testing.T) {
+	nt := &nameTable{m: make(map[[16]byte]nameEntry)}
+	ip := net.ParseIP("10.0.0.5")
+	now := time.Now()
+	for _, step := range []struct {
+		name   string
+		source NameSource
+		want   string
+	}{
+		{"ptr.example", NamePTR, "ptr.example"},
+		{"laptop", NameDHCP, "laptop"},
+		{"other.example", NamePTR, "laptop"}, // less confident
+		{"nas", NameManual, "nas"},
+		{"renamed", NameDHCP, "nas"},
+		{"nas2", NameManual, "nas2"},
+	} {
+		nt.set(ip, step.name, step.source, now)
+		if e, _ := nt.get(ip); e.name != step.want {
+			t.Errorf("after set(%q, %v): got %q, want %q", step.name, step.source, e.name, step.want)
+		}
+	}
+}
+
+func TestBestName(t *testing.T) {
+	// Sniffed DNS must not beat a manual label.
+	ip := net.ParseIP("10.0.0.77")
+	revDNSMaps.Lock()
+	saved := revDNSMaps.list
+	revDNSMaps.Unlock()
+	defer func() {
+		revDNSMaps.Lock()
+		revDNSMaps.list = saved
+		revDNSMaps.Unlock()
+	}()
+	c := &Capture{}
+	rm := c.reverseDNSMap()
+	host := net.ParseIP("10.0.0.2")
+	rm.addName(host, "sniffed.example", NameDNS, []net.IP{ip})
+	flow := (&layers.IPv4{SrcIP: host, DstIP: ip}).NetworkFlow()
+
+	_, dst := rm.names(host, flow)
+	if name, source := bestName(ip, dst); name != "sniffed.example" || source != NameDNS {
+		t.Errorf("bestName before label: got %q %v, want %q %v", name, source, "sniffed.example", NameDNS)
+	}
+	SetName(ip, "printer", NameManual)
+	defer func() {
+		globalNames.mu.Lock()
+		delete(globalNames.m, ipKey(ip))
+		globalNames.mu.Unlock()
+	}()
+	if name, source := bestName(ip, dst); name != "printer" || source != NameManual {
+		t.Errorf("bestName after label: got %q %v, want %q %v", name, source, "printer", NameManual)
+	}
+
+	// SNI replaces the DNS name.
+	rm.addName(host, "sni.example", NameSNI, []net.IP{ip})
+	if _, dst := rm.names(host, flow); dst.name != "sni.example" {
+		t.Errorf("after SNI: got %q, want %q", dst.name, "sni.example")
+	}
+
+	recs := Names(ip)
+	if len(recs) != 3 || recs[0].Source != NameManual || recs[1].Source != NameSNI || !recs[1].Host.Equal(host) || recs[2].Source != NameDNS {
+		t.Errorf("Names(%v): got %+v, want the manual label, then host %v's SNI and DNS names", ip, recs, host)
+	}
+
+	if name, source := bestName(net.ParseIP("10.9.9.9"), nameEntry{}); name != "10.9.9.9" || source != NameNone {
+		t.Errorf("bestName(unknown): got %q %v, want %q %v", name, source, "10.9.9.9", NameNone)
+	}
+}
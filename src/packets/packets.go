@@ -16,13 +16,17 @@
 package packets
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
-	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/gopacket"
@@ -34,14 +38,99 @@ import (
 
 const maxBuffers = 100
 
+// DefaultSnapLen is how many bytes of each packet Live captures, unless the
+// Capture's SnapLen says otherwise: enough for the names in most DNS answers
+// and TLS ClientHellos. MinSnapLen is just enough for the headers of a
+// tagged IPv6 TCP packet, and MaxSnapLen is a whole packet.
+const (
+	DefaultSnapLen = 1600
+	MinSnapLen     = 14 + 4 + 40 + 60
+	MaxSnapLen     = 65535
+)
+
+// MaxRateLimit is the highest Capture.RateLimit: a packet a nanosecond.
+const MaxRateLimit = int(time.Second)
+
+// captureFilter selects the packets caplog decodes, tagged with a VLAN or
+// not. (BPF only looks past the 802.1Q tag after "vlan".)
+const captureFilter = "tcp or udp or icmp or icmp6 or arp or (vlan and (tcp or udp or icmp or icmp6 or arp))"
+
 // Metadata is some information about a packet, but not including the data.
 type Metadata struct {
-	Timestamp        time.Time
+	Timestamp time.Time
+
+	// Size is the packet's size in bytes, as set by SetSizeAccounting: the
+	// frame by default.
 	Size             uint64
 	SrcName, DstName string
 	SrcIP, DstIP     net.IP
+
+	// SrcMAC and DstMAC are the Ethernet addresses of the ends that are
+	// local hosts (e.g. "00:11:22:33:44:55"), which stay the same when
+	// DHCP gives them new IP addresses. They are empty for remote hosts,
+	// whose packets carry the router's.
+	SrcMAC, DstMAC string `json:",omitempty"`
+
+	// SrcNameSource and DstNameSource are where the names came from.
+	SrcNameSource, DstNameSource NameSource `json:",omitempty"`
+
+	// SrcASN and DstASN are the autonomous systems of non-local addresses,
+	// and SrcASOrg and DstASOrg their names, if known. See SetASNTable.
+	SrcASN, DstASN     uint32 `json:",omitempty"`
+	SrcASOrg, DstASOrg string `json:",omitempty"`
+
 	SrcPort, DstPort uint16
 	V6               bool
+
+	// Protocol is the IP protocol number (6 TCP, 17 UDP, 1 ICMP, 58
+	// ICMPv6), or 0 if unknown.
+	Protocol uint8
+
+	// ICMPType and ICMPCode are set for ICMP and ICMPv6 packets, which
+	// have no ports.
+	ICMPType, ICMPCode uint8
+
+	// VLAN is the 802.1Q VLAN ID, or 0 if the packet wasn't tagged.
+	VLAN uint16
+
+	// AppProtocol is the protocol above the transport, if it was
+	// recognised: so far only AppQUIC.
+	AppProtocol string `json:",omitempty"`
+
+	// Segments, if set, is the estimated number of packets on the wire a
+	// superframe was coalesced from. See SetCoalescing and Packets.
+	Segments uint32 `json:",omitempty"`
+}
+
+// AppQUIC is the AppProtocol of QUIC packets.
+const AppQUIC = "quic"
+
+// ICMP reports whether the packet is ICMP or ICMPv6.
+func (m *Metadata) ICMP() bool {
+	return m.Protocol == uint8(layers.IPProtocolICMPv4) || m.Protocol == uint8(layers.IPProtocolICMPv6)
+}
+
+// Ping reports whether the packet is an ICMP echo request or reply.
+func (m *Metadata) Ping() bool {
+	switch m.Protocol {
+	case uint8(layers.IPProtocolICMPv4):
+		return m.ICMPType == layers.ICMPv4TypeEchoRequest || m.ICMPType == layers.ICMPv4TypeEchoReply
+	case uint8(layers.IPProtocolICMPv6):
+		return m.ICMPType == layers.ICMPv6TypeEchoRequest || m.ICMPType == layers.ICMPv6TypeEchoReply
+	}
+	return false
+}
+
+// Unreachable reports whether the packet is an ICMP destination unreachable
+// message.
+func (m *Metadata) Unreachable() bool {
+	switch m.Protocol {
+	case uint8(layers.IPProtocolICMPv4):
+		return m.ICMPType == layers.ICMPv4TypeDestinationUnreachable
+	case uint8(layers.IPProtocolICMPv6):
+		return m.ICMPType == layers.ICMPv6TypeDestinationUnreachable
+	}
+	return false
 }
 
 // Capture handles decoding packets and calling user functions.
@@ -49,10 +138,115 @@ type Capture struct {
 	Account    func(*Metadata)
 	Interface  string
 	BufferSize int
-	Log        func([]Metadata)
 
+	// Sink, if set, receives buffers of metadata.
+	Sink Sink
+
+	// Processors, if positive, is the number of decoding goroutines. The
+	// default is one per CPU. See SetProcessors.
+	Processors int
+	poolMu     sync.Mutex
+	pool       *processorPool // while running
+
+	// RateLimit, if positive, limits reading to that many packets per second.
+	// Useful when replaying files into a sink that can't keep up. Rates
+	// above MaxRateLimit don't limit reading at all.
+	RateLimit int
+
+	// Enrichers lists the enrichers to run on each packet, in order. If nil,
+	// all registered enrichers are run. See RegisterEnricher.
+	Enrichers []string
+
+	// EnrichBudget, if positive, is how long enrichment may take for each
+	// packet. Enrichers still to run once it is used up are skipped.
+	EnrichBudget time.Duration
+
+	// Filter, if set, is a BPF expression that further restricts what is
+	// captured, e.g. "not host 192.168.1.2". See SetFilter.
+	Filter string
+
+	// SnapLen, if positive, is how many bytes of each packet Live captures,
+	// between MinSnapLen and MaxSnapLen; the default is DefaultSnapLen.
+	// Packets are still counted at their full size, but less of a DNS
+	// answer or TLS ClientHello is left to name them with.
+	SnapLen int
+
+	// Mirror, if set, is given every packet read, to re-emit those matching
+	// its own filter for another analyzer.
+	Mirror *Mirror
+
+	// Flows, if set, groups the packets into flows. Packets that ignore
+	// rules drop aren't in any flow.
+	Flows *FlowTable
+
+	// Streams, if set, reassembles the TCP streams to summarise them.
+	// Packets that ignore rules drop aren't in any stream.
+	Streams *StreamTable
+
+	handleMu sync.Mutex
+	handle   *pcap.Handle // while running
+
+	// Checkpoint, if set, is a file to save the partial buffers in when the
+	// capture stops, instead of writing them to Sink. They are written to
+	// Sink when the capture next starts, so that they survive a restart
+	// even if Sink couldn't be flushed before exiting.
+	Checkpoint   string
+	checkpointMu sync.Mutex
+
+	// DrainTimeout, if positive, bounds how long a capture that was stopped
+	// spends processing the packets it had already read, and handing over
+	// the partial buffers. Whatever is left after that is abandoned. See
+	// DrainReport.
+	DrainTimeout time.Duration
+
+	drainMu sync.Mutex
+	drain   DrainReport
+
+	pipelineOnce sync.Once
+	pipeline     *pipeline
+	pipelineErr  error
+
+	stopMake, stopClose sync.Once
+	stop                chan struct{} // closed by Stop
+
+	revDNSOnce sync.Once
 	revDNS     *multiReverseDNS
+	sniFlows   sniFlowTable
+	affinities affinityTable // names that stick to a client and server
+	quicFlows  sniFlowTable  // flows seen to be QUIC, named AppQUIC
+	quicHellos quicHelloTable
+	dnsTCP     dnsTCPTable // DNS over TCP responses being put together
 	bufferRing chan []Metadata
+	logging    sync.WaitGroup // buffers being written by logBuffer
+
+	// Progress counters, accessed atomically.
+	count  uint64
+	lastTS int64
+
+	// Packets in partial buffers handed over when stopping, accessed
+	// atomically.
+	checkpointed, flushed uint64
+}
+
+// Progress returns the number of packets read so far, and the capture time of
+// the most recent one.
+func (c *Capture) Progress() (uint64, time.Time) {
+	return atomic.LoadUint64(&c.count), time.Unix(0, atomic.LoadInt64(&c.lastTS))
+}
+
+// Dropped returns the number of packets the kernel or the interface dropped
+// since the capture started, because they weren't read quickly enough.
+func (c *Capture) Dropped() uint64 {
+	c.handleMu.Lock()
+	defer c.handleMu.Unlock()
+	if c.handle == nil {
+		return 0
+	}
+	s, err := c.handle.Stats()
+	if err != nil || s == nil {
+		return 0
+	}
+	return uint64(s.PacketsDropped + s.PacketsIfDropped)
 }
 
 // nextBuffer returns a fresh buffer from the buffer ring, or allocates a new
@@ -66,74 +260,154 @@ func (c *Capture) nextBuffer() []Metadata {
 	}
 }
 
-// logBuffer passes the buffer to c.Log, and then tries to return the buffer
-// to the buffer ring (but won't block trying).
+// logBuffer passes the buffer to c.Sink (dropping it if the sink panics), and
+// then tries to return the buffer to the buffer ring (but won't block trying).
 func (c *Capture) logBuffer(b []Metadata) {
-	c.Log(b)
+	defer c.logging.Done()
+	crashed("sink", func() { c.writeSink(b) })
 	select {
 	case c.bufferRing <- b[:0]:
 	default:
 	}
 }
 
-// processor is a worker that decodes packets and passes on to Account and Log.
-func (c *Capture) processor(num int, packetsCh <-chan gopacket.Packet) {
+// writeSink writes a buffer to c.Sink, logging any error.
+func (c *Capture) writeSink(b []Metadata) {
+	if err := c.Sink.Write(context.Background(), b); err != nil {
+		log.Printf("sink: %v", err)
+	}
+}
+
+// processor is a worker that decodes packets and passes on to Account and Sink,
+// until packetsCh is closed. quit is closed first if the processor was resized
+// away, rather than the capture finishing.
+func (c *Capture) processor(num int, packetsCh <-chan gopacket.Packet, quit <-chan struct{}) {
 	log.Printf("processor %d: starting", num)
 
 	buffer := c.nextBuffer()
 	defer func() {
-		// TODO: Save a checkpoint.
-		if c.Log != nil {
-			c.Log(buffer)
+		if c.Sink == nil {
+			return
 		}
+		select {
+		case <-quit:
+			// Resized away while the capture carries on.
+			c.writeSink(buffer)
+			return
+		default:
+		}
+		if c.Checkpoint != "" {
+			err := c.saveCheckpoint(buffer)
+			if err == nil {
+				atomic.AddUint64(&c.checkpointed, uint64(len(buffer)))
+				return
+			}
+			log.Printf("processor %d: checkpoint: %v", num, err)
+		}
+		c.writeSink(buffer)
+		atomic.AddUint64(&c.flushed, uint64(len(buffer)))
 	}()
 
 	var (
 		eth     layers.Ethernet
+		dot1q   layers.Dot1Q
+		arp     layers.ARP
 		ip4     layers.IPv4
 		ip6     layers.IPv6
 		tcp     layers.TCP
 		udp     layers.UDP
+		icmp4   layers.ICMPv4
+		icmp6   layers.ICMPv6
 		dns     layers.DNS
 		payload gopacket.Payload
 	)
-	parser := gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet, &eth, &ip4, &ip6, &tcp, &udp, &dns, &payload)
-	for packet := range packetsCh {
+	parser := gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet, &eth, &dot1q, &arp, &ip4, &ip6, &tcp, &udp, &icmp4, &icmp6, &dns, &payload)
+	pl, _ := c.enrichment() // checked by run
+	var streams *streamAssembler
+	if c.Streams != nil {
+		streams = c.Streams.assembler()
+		defer func() {
+			select {
+			case <-quit:
+				// Resized away: the other processors carry on with the
+				// streams.
+			default:
+				streams.close()
+			}
+		}()
+	}
+	p := &Packet{IPv4: &ip4, IPv6: &ip6, TCP: &tcp, UDP: &udp, DNS: &dns}
+	for {
+		packet, ok := <-packetsCh
+		if !ok {
+			break
+		}
+		if c.Mirror != nil {
+			c.Mirror.mirror(packet.Metadata().CaptureInfo, packet.Data())
+		}
 		var decoded []gopacket.LayerType
-		if err := parser.DecodeLayers(packet.Data(), &decoded); err != nil {
+		m := packet.Metadata()
+		if err := parser.DecodeLayers(packet.Data(), &decoded); err != nil && !icmpv6Body(decoded, err) && m.CaptureLength >= m.Length {
+			// (Packets cut short by SnapLen are expected to fail.)
 			log.Printf("processor %d: %v", num, err)
 		}
-		m := packet.Metadata()
+		if hasLayer(decoded, layers.LayerTypeARP) {
+			// Not IP traffic, so not counted; only who sent it.
+			if learningDevices() {
+				devices.add(&arp, c.Interface, m.Timestamp)
+			}
+			continue
+		}
 		b := Metadata{
 			Timestamp: m.Timestamp,
-			Size:      uint64(m.Length),
+			Size:      packetSize(m.Length, decoded, &ip4, &ip6),
 		}
 		for _, layerType := range decoded {
 			switch layerType {
+			case layers.LayerTypeDot1Q:
+				b.VLAN = dot1q.VLANIdentifier
 			case layers.LayerTypeIPv6:
 				b.SrcIP, b.DstIP = ip6.SrcIP, ip6.DstIP
-				b.SrcName, b.DstName = c.revDNS.names(local(b.SrcIP, b.DstIP), ip6.NetworkFlow())
 				b.V6 = true
 			case layers.LayerTypeIPv4:
 				b.SrcIP, b.DstIP = ip4.SrcIP, ip4.DstIP
-				b.SrcName, b.DstName = c.revDNS.names(local(b.SrcIP, b.DstIP), ip4.NetworkFlow())
 			case layers.LayerTypeTCP:
 				b.SrcPort, b.DstPort = uint16(tcp.SrcPort), uint16(tcp.DstPort)
+				b.Protocol = uint8(layers.IPProtocolTCP)
 			case layers.LayerTypeUDP:
 				b.SrcPort, b.DstPort = uint16(udp.SrcPort), uint16(udp.DstPort)
-			case layers.LayerTypeDNS:
-				// Add DNS answers to reverse DNS map.
-				// The "src" is the host who did the query, but answers are replies, so "src" = dst.
-				// Should be here only after b.DstIP is set.
-				c.revDNS.add(b.DstIP, &dns)
+				b.Protocol = uint8(layers.IPProtocolUDP)
+			case layers.LayerTypeICMPv4:
+				b.ICMPType, b.ICMPCode = icmp4.TypeCode.Type(), icmp4.TypeCode.Code()
+				b.Protocol = uint8(layers.IPProtocolICMPv4)
+			case layers.LayerTypeICMPv6:
+				b.ICMPType, b.ICMPCode = icmp6.TypeCode.Type(), icmp6.TypeCode.Code()
+				b.Protocol = uint8(layers.IPProtocolICMPv6)
 			}
 		}
+		if hasLayer(decoded, layers.LayerTypeEthernet) {
+			b.SrcMAC, b.DstMAC = hostMAC(eth.SrcMAC, b.SrcIP), hostMAC(eth.DstMAC, b.DstIP)
+		}
+		p.Meta, p.Decoded = &b, decoded
+		coalesce(p, m.Length)
+		pl.run(p)
 
-		c.Account(&b)
+		ignore, drop := ignored(&b)
+		if !ignore {
+			c.Account(&b)
+		}
 
-		if c.Log != nil {
+		if c.Flows != nil && !drop {
+			c.Flows.add(p)
+		}
+		if streams != nil && !drop {
+			streams.add(p)
+		}
+
+		if c.Sink != nil && !drop {
 			buffer = append(buffer, b)
 			if len(buffer) >= c.BufferSize {
+				c.logging.Add(1)
 				go c.logBuffer(buffer)
 				buffer = c.nextBuffer()
 			}
@@ -142,21 +416,186 @@ func (c *Capture) processor(num int, packetsCh <-chan gopacket.Packet) {
 	log.Printf("processor %d: stopping", num)
 }
 
-// Live runs a live packet capture on the interface.
+// hostMAC returns the MAC address of the host with the IP address, as a
+// string, if it is local, and the MAC address isn't a broadcast or multicast
+// group's.
+func hostMAC(mac net.HardwareAddr, ip net.IP) string {
+	if len(mac) == 0 || mac[0]&1 != 0 || !IsLocal(ip) {
+		return ""
+	}
+	return mac.String()
+}
+
+// hasLayer reports whether the layer type was decoded.
+func hasLayer(decoded []gopacket.LayerType, t gopacket.LayerType) bool {
+	for _, d := range decoded {
+		if d == t {
+			return true
+		}
+	}
+	return false
+}
+
+// icmpv6Body reports whether a decoding error only means the parser stopped
+// at the body of an ICMPv6 message (echo, neighbour discovery...), which
+// gopacket decodes as a layer of its own, and isn't needed.
+func icmpv6Body(decoded []gopacket.LayerType, err error) bool {
+	_, unsupported := err.(gopacket.UnsupportedLayerType)
+	return unsupported && len(decoded) > 0 && decoded[len(decoded)-1] == layers.LayerTypeICMPv6
+}
+
+// OpenError is returned by Live when the interface can't be opened.
+type OpenError struct {
+	Interface string
+	Err       error
+
+	// Permission is true if the cause was insufficient privileges (as
+	// opposed to, say, the interface not existing).
+	Permission bool
+}
+
+func (e *OpenError) Error() string {
+	if e.Permission {
+		return fmt.Sprintf("no permission to capture on %s: %v", e.Interface, e.Err)
+	}
+	return fmt.Sprintf("opening %s: %v", e.Interface, e.Err)
+}
+
+// permissionMessages are fragments of libpcap error messages that mean the
+// process lacks privileges, across platforms.
+var permissionMessages = []string{
+	"permission denied",
+	"operation not permitted",
+	"don't have permission",
+	"access is denied",
+}
+
+func isPermissionError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, m := range permissionMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Live runs a live packet capture on the interface. If the interface can't be
+// opened, the error is an *OpenError.
 func (c *Capture) Live() error {
+	snaplen := c.SnapLen
+	if snaplen <= 0 {
+		snaplen = DefaultSnapLen
+	}
 	// Note: BlockForever != 0. 0 can do undesirable things on Darwin.
-	handle, err := pcap.OpenLive(c.Interface, 1600, true, pcap.BlockForever)
+	handle, err := pcap.OpenLive(c.Interface, int32(snaplen), true, pcap.BlockForever)
+	if err != nil {
+		return &OpenError{
+			Interface:  c.Interface,
+			Err:        err,
+			Permission: isPermissionError(err),
+		}
+	}
+	defer handle.Close()
+	return c.run(handle)
+}
+
+// File processes the packets in a pcap file, preserving their original
+// timestamps, until the end of the file, an interrupt, or Stop.
+func (c *Capture) File(path string) error {
+	handle, err := pcap.OpenOffline(path)
 	if err != nil {
 		return err
 	}
 	defer handle.Close()
-	if err := handle.SetBPFFilter("tcp or udp"); err != nil {
+	return c.run(handle)
+}
+
+// Stop makes the capture stop reading, as on an interrupt: the packets read
+// so far are processed, partial buffers are written to the Sink (or the
+// Checkpoint), and Live or File returns, within DrainTimeout if it is set. If the capture hasn't started, it will stop as soon as it
+// does.
+func (c *Capture) Stop() {
+	ch := c.stopCh()
+	c.stopClose.Do(func() { close(ch) })
+}
+
+// stopCh returns the channel closed by Stop, making it the first time.
+func (c *Capture) stopCh() chan struct{} {
+	c.stopMake.Do(func() { c.stop = make(chan struct{}) })
+	return c.stop
+}
+
+// ReverseDNSSize returns the number of hosts with reverse DNS maps, and the
+// total number of names across all of them.
+func (c *Capture) ReverseDNSSize() (hosts, names int) {
+	r := c.reverseDNSMap()
+	return r.len(), r.entries()
+}
+
+// reverseDNSMap returns the reverse DNS map, making it the first time. It
+// is kept across runs (e.g. of several files).
+func (c *Capture) reverseDNSMap() *multiReverseDNS {
+	c.revDNSOnce.Do(func() {
+		c.revDNS = newMultiReverseDNSMap()
+		revDNSMaps.Lock()
+		revDNSMaps.list = append(revDNSMaps.list, c.revDNS)
+		revDNSMaps.Unlock()
+	})
+	return c.revDNS
+}
+
+// bpfFilter is the whole BPF expression for the capture.
+func bpfFilter(extra string) string {
+	if extra == "" {
+		return captureFilter
+	}
+	return "(" + captureFilter + ") and (" + extra + ")"
+}
+
+// SetFilter changes Filter. If the capture is running, the new filter
+// applies from the next packet read; if it is invalid, the old one is kept.
+func (c *Capture) SetFilter(extra string) error {
+	c.handleMu.Lock()
+	defer c.handleMu.Unlock()
+	if c.handle != nil {
+		if err := c.handle.SetBPFFilter(bpfFilter(extra)); err != nil {
+			return err
+		}
+	}
+	c.Filter = extra
+	return nil
+}
+
+// run reads and processes packets from the handle.
+func (c *Capture) run(handle *pcap.Handle) error {
+	c.handleMu.Lock()
+	err := handle.SetBPFFilter(bpfFilter(c.Filter))
+	if err == nil {
+		c.handle = handle
+	}
+	c.handleMu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		c.handleMu.Lock()
+		c.handle = nil
+		c.handleMu.Unlock()
+	}()
+	pl, err := c.enrichment()
+	if err != nil {
 		return err
 	}
+	pl.registerVars()
 
-	c.revDNS = newMultiReverseDNSMap()
-	vars.Register("reverse-dns-map-size", vars.IntEval(c.revDNS.len).String)
-	vars.Register("reverse-dns-map", c.revDNS.String)
+	revDNS := c.reverseDNSMap()
+	vars.Register("reverse-dns-map-size", vars.IntEval(revDNS.len).String)
+	vars.Register("reverse-dns-names", vars.IntEval(revDNS.entries).String)
+	vars.Register("reverse-dns-map", revDNS.String)
+	vars.Register("reverse-dns-host-names", revDNS.hostSizes)
+	vars.Register("reverse-dns-shared-names", vars.IntEval(revDNS.everyone.len).String)
+	vars.Register("name-affinities", vars.IntEval(c.affinities.len).String)
 
 	packetsCh := make(chan gopacket.Packet, c.BufferSize)
 	packetsChLen := func() int { return len(packetsCh) }
@@ -166,41 +605,160 @@ func (c *Capture) Live() error {
 	bufferRingLen := func() int { return len(c.bufferRing) }
 	vars.Register("buffer-ring-len", vars.IntEval(bufferRingLen).String)
 
-	var wg sync.WaitGroup
-	for i := 0; i < runtime.NumCPU(); i++ {
-		wg.Add(1)
-		go func(num int) {
-			c.processor(num, packetsCh)
-			wg.Done()
-		}(i)
+	if c.Checkpoint != "" && c.Sink != nil {
+		c.restoreCheckpoint()
 	}
 
-	// Pump packets into packetsCh, until interrupted.
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
+	pool := &processorPool{c: c, packetsCh: packetsCh}
+	c.poolMu.Lock()
+	pool.resize(c.Processors)
+	c.pool = pool
+	c.poolMu.Unlock()
+	defer func() {
+		c.poolMu.Lock()
+		c.pool = nil
+		c.poolMu.Unlock()
+	}()
+	vars.Register("processors", vars.IntEval(pool.size).String)
+
+	// Pump packets into packetsCh, until interrupted or stopped.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	stop, finished := make(chan struct{}), make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case sig := <-sigs:
+			log.Printf("%v received, stopping...", sig)
+		case <-c.stopCh():
+		case <-finished:
+			return
+		}
+		close(stop)
+		// Unblock a read waiting for a packet that may never come.
+		c.handleMu.Lock()
+		c.handle = nil
+		handle.Close()
+		c.handleMu.Unlock()
+	}()
+
+	var limit <-chan time.Time
+	if c.RateLimit > 0 && c.RateLimit <= MaxRateLimit {
+		tick := time.NewTicker(time.Second / time.Duration(c.RateLimit))
+		defer tick.Stop()
+		limit = tick.C
+	}
 
 	src := gopacket.NewPacketSource(handle, handle.LinkType())
 	src.DecodeOptions = gopacket.Lazy
-packetLoop:
+	name := "capture"
+	if c.Interface != "" {
+		name += " on " + c.Interface
+	}
+	supervise(name, func() { c.pump(src, packetsCh, stop, limit) })
+
+	// Finish processing: the processors drain packetsCh (by way of their own
+	// channels), and write out their partial buffers.
+	stopped := time.Now()
+	report := DrainReport{Stopped: stopped, Queued: pool.queued()}
+	atomic.StoreUint64(&c.checkpointed, 0)
+	atomic.StoreUint64(&c.flushed, 0)
+	close(packetsCh)
+	drained := make(chan struct{})
+	go func() {
+		pool.wg.Wait()
+		c.logging.Wait()
+		close(drained)
+	}()
+	var timeout <-chan time.Time
+	select {
+	case <-stop:
+		if c.DrainTimeout > 0 {
+			t := time.NewTimer(c.DrainTimeout)
+			defer t.Stop()
+			timeout = t.C
+		}
+	default: // the end of a file: take as long as it takes
+	}
+	select {
+	case <-drained:
+	case <-timeout:
+		report.TimedOut = true
+		report.Abandoned = pool.queued()
+	}
+	report.Took = time.Since(stopped)
+	report.Checkpointed = int(atomic.LoadUint64(&c.checkpointed))
+	report.Flushed = int(atomic.LoadUint64(&c.flushed))
+	c.drainMu.Lock()
+	c.drain = report
+	c.drainMu.Unlock()
+	return nil
+}
+
+// DrainReport describes how a capture finished, once it stopped reading.
+type DrainReport struct {
+	Stopped time.Time // when reading stopped
+
+	Queued       int // packets read, but not yet processed, at Stopped
+	Checkpointed int // packets in partial buffers saved in the checkpoint
+	Flushed      int // packets in partial buffers written to the sink
+
+	// TimedOut is set if the capture wasn't drained within DrainTimeout,
+	// and Abandoned is then how many of the Queued packets were left.
+	TimedOut  bool
+	Abandoned int
+
+	Took time.Duration
+}
+
+func (r DrainReport) String() string {
+	s := fmt.Sprintf("drained %d queued packets in %v; partial buffers: %d packets checkpointed, %d written", r.Queued-r.Abandoned, r.Took.Round(time.Millisecond), r.Checkpointed, r.Flushed)
+	if r.TimedOut {
+		s += fmt.Sprintf("; timed out, abandoning %d packets and the buffers not yet handed over", r.Abandoned)
+	}
+	return s
+}
+
+// LastDrain reports how the capture finished when it last stopped, or
+// returns the zero DrainReport if it hasn't.
+func (c *Capture) LastDrain() DrainReport {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	return c.drain
+}
+
+// pump reads packets from src into packetsCh, until the end of the packets or
+// stop is closed.
+func (c *Capture) pump(src *gopacket.PacketSource, packetsCh chan<- gopacket.Packet, stop <-chan struct{}, limit <-chan time.Time) {
 	for {
 		packet, err := src.NextPacket()
 		if err == io.EOF {
-			break packetLoop
+			return
 		}
 		if err != nil {
+			select {
+			case <-stop:
+				return
+			default:
+			}
 			log.Println("Error capturing packet:", err)
 			continue
 		}
+		atomic.AddUint64(&c.count, 1)
+		atomic.StoreInt64(&c.lastTS, packet.Metadata().Timestamp.UnixNano())
+		if limit != nil {
+			select {
+			case <-limit:
+			case <-stop:
+				return
+			}
+		}
 		select {
 		case packetsCh <- packet:
 			// Nop - writing the packet to the channel was the main thing.
 		case <-stop:
-			log.Println("^C recieved, stopping...")
-			break packetLoop
+			return
 		}
 	}
-	// Finish processing.
-	close(packetsCh)
-	wg.Wait()
-	return nil
 }
@@ -0,0 +1,83 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIsPermissionError(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want bool
+	}{
+		{msg: "eth0: You don't have permission to capture on that device (socket: Operation not permitted)", want: true},
+		{msg: "(cannot open BPF device) /dev/bpf0: Permission denied", want: true},
+		{msg: "eth9: No such device exists (SIOCGIFHWADDR: No such device)", want: false},
+	}
+	for _, test := range tests {
+		if got := isPermissionError(errors.New(test.msg)); got != test.want {
+			t.Errorf("isPermissionError(%q): got %v, want %v", test.msg, got, test.want)
+		}
+	}
+}
+
+func TestDrainReport(t *testing.T) {
+	tests := []struct {
+		r    DrainReport
+		want string
+	}{
+		{
+			r:    DrainReport{Queued: 120, Checkpointed: 300, Took: 15 * time.Millisecond},
+			want: "drained 120 queued packets in 15ms; partial buffers: 300 packets checkpointed, 0 written",
+		},
+		{
+			r:    DrainReport{Queued: 120, Flushed: 40, TimedOut: true, Abandoned: 20, Took: 10 * time.Second},
+			want: "drained 100 queued packets in 10s; partial buffers: 0 packets checkpointed, 40 written; timed out, abandoning 20 packets and the buffers not yet handed over",
+		},
+	}
+	for _, test := range tests {
+		if got := test.r.String(); got != test.want {
+			t.Errorf("DrainReport(%+v).String():\ngot  %s\nwant %s", test.r, got, test.want)
+		}
+	}
+}
+
+func TestHostMAC(t *testing.T) {
+	mac := func(s string) net.HardwareAddr {
+		m, _ := net.ParseMAC(s)
+		return m
+	}
+	tests := []struct {
+		mac  net.HardwareAddr
+		ip   string
+		want string
+	}{
+		{mac("00:11:22:33:44:55"), "192.168.1.20", "00:11:22:33:44:55"},
+		{mac("00:11:22:33:44:55"), "fe80::1", "00:11:22:33:44:55"},
+		{mac("00:11:22:33:44:55"), "8.8.8.8", ""},       // the router's, really
+		{mac("ff:ff:ff:ff:ff:ff"), "192.168.1.255", ""}, // broadcast
+		{mac("01:00:5e:00:00:fb"), "224.0.0.251", ""},   // multicast
+		{nil, "192.168.1.20", ""},
+	}
+	for _, test := range tests {
+		if got := hostMAC(test.mac, net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("hostMAC(%v, %s) = %q, want %q", test.mac, test.ip, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file keeps a capture's processors, so that their number can change
+// while it runs: a probe sharing a box with other work can give up CPUs
+// while that work is busy, and take them back after.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"runtime"
+	"sync"
+
+	"github.com/google/gopacket"
+)
+
+// processorQueue is the number of packets waiting for each processor, once
+// dispatched from the capture's channel.
+const processorQueue = 64
+
+// processorPool is a running capture's processors. Each flow's packets all go
+// to the same processor, in the order they were read, so that TCP sequence
+// numbers (for retransmissions, RTTs and streams) are seen in order.
+type processorPool struct {
+	c         *Capture
+	packetsCh <-chan gopacket.Packet
+	wg        sync.WaitGroup
+	dispatch  sync.Once
+
+	mu     sync.RWMutex
+	procs  []poolProcessor // the newest last
+	next   int             // the number of the next processor started
+	closed bool            // packetsCh is closed, and so are the processors'
+}
+
+// poolProcessor is a processor's channel, and the channel closed to tell it
+// it was resized away.
+type poolProcessor struct {
+	packetsCh chan gopacket.Packet
+	quit      chan struct{}
+}
+
+// resize starts or stops processors to make n (one per CPU if n isn't
+// positive). A processor that is stopped finishes the packets dispatched to
+// it, and writes its partial buffer to the sink; the others carry on with the
+// rest. Flows are shared out afresh among the processors left, so a packet
+// or two of a flow moved to another processor may be seen out of order.
+func (p *processorPool) resize(n int) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	p.dispatch.Do(func() {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.dispatcher()
+		}()
+	})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	for len(p.procs) < n {
+		proc := poolProcessor{
+			packetsCh: make(chan gopacket.Packet, processorQueue),
+			quit:      make(chan struct{}),
+		}
+		p.procs = append(p.procs, proc)
+		num := p.next
+		p.next++
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			supervise(fmt.Sprintf("processor %d", num), func() { p.c.processor(num, proc.packetsCh, proc.quit) })
+		}()
+	}
+	for len(p.procs) > n {
+		last := len(p.procs) - 1
+		close(p.procs[last].quit)
+		close(p.procs[last].packetsCh)
+		p.procs = p.procs[:last]
+	}
+}
+
+// dispatcher sends each packet from packetsCh to a processor chosen by its
+// flow, until packetsCh is closed, and then closes the processors' channels.
+func (p *processorPool) dispatcher() {
+	for packet := range p.packetsCh {
+		h := flowHash(packet.Data())
+		p.mu.RLock()
+		p.procs[h%uint32(len(p.procs))].packetsCh <- packet
+		p.mu.RUnlock()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, proc := range p.procs {
+		close(proc.packetsCh)
+	}
+}
+
+// queued returns the number of packets not yet processed, whether waiting in
+// packetsCh or dispatched to a processor.
+func (p *processorPool) queued() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	n := len(p.packetsCh)
+	for _, proc := range p.procs {
+		n += len(proc.packetsCh)
+	}
+	return n
+}
+
+// flowHash hashes the addresses and ports of an Ethernet frame's IP packet,
+// the same way in both directions, so that a flow's packets get the same
+// hash. It reads the raw frame, so as not to decode packets twice; anything
+// it doesn't understand hashes to 0.
+func flowHash(data []byte) uint32 {
+	if len(data) < 14 {
+		return 0
+	}
+	etherType, off := binary.BigEndian.Uint16(data[12:]), 14
+	for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= off+4 {
+		etherType = binary.BigEndian.Uint16(data[off+2:])
+		off += 4
+	}
+	var src, dst []byte
+	var proto byte
+	switch etherType {
+	case 0x0800: // IPv4
+		if len(data) < off+20 {
+			return 0
+		}
+		ihl := int(data[off]&0x0f) * 4
+		src, dst, proto = data[off+12:off+16], data[off+16:off+20], data[off+9]
+		if binary.BigEndian.Uint16(data[off+6:])&0x3fff != 0 {
+			proto = 0 // a fragment: only the first has the ports
+		}
+		off += ihl
+	case 0x86dd: // IPv6
+		if len(data) < off+40 {
+			return 0
+		}
+		src, dst, proto = data[off+8:off+24], data[off+24:off+40], data[off+6]
+		off += 40
+	default:
+		return 0
+	}
+	var sport, dport []byte
+	if (proto == 6 || proto == 17) && len(data) >= off+4 { // TCP or UDP
+		sport, dport = data[off:off+2], data[off+2:off+4]
+	}
+	return endpointHash(src, sport) ^ endpointHash(dst, dport)
+}
+
+// endpointHash hashes an address and port.
+func endpointHash(addr, port []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(addr)
+	h.Write(port)
+	return h.Sum32()
+}
+
+// size returns the number of processors.
+func (p *processorPool) size() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.procs)
+}
+
+// SetProcessors changes Processors. If the capture is running, processors
+// are started or stopped to match, without losing any packets.
+func (c *Capture) SetProcessors(n int) {
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	c.Processors = n
+	if c.pool != nil {
+		c.pool.resize(n)
+	}
+}
+
+// RunningProcessors returns the number of processors, or 0 if the capture
+// isn't running.
+func (c *Capture) RunningProcessors() int {
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	if c.pool == nil {
+		return 0
+	}
+	return c.pool.size()
+}
@@ -0,0 +1,151 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+func TestProcessorPool(t *testing.T) {
+	packetsCh := make(chan gopacket.Packet)
+	p := &processorPool{c: &Capture{BufferSize: 10}, packetsCh: packetsCh}
+
+	for _, n := range []int{3, 5, 1} {
+		p.resize(n)
+		if got := p.size(); got != n {
+			t.Errorf("after resize(%d): size %d", n, got)
+		}
+	}
+	if p.next != 5 {
+		t.Errorf("processors started: got %d, want 5", p.next)
+	}
+
+	// The processors stopped by resizing have finished; the last one
+	// finishes once packetsCh is closed.
+	close(packetsCh)
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("processors still running after packetsCh was closed")
+	}
+}
+
+// frame is an Ethernet frame with an IPv4 TCP segment, with n bytes of data.
+func frame(src, dst net.IP, sport, dport uint16, seq, ack uint32, n int) []byte {
+	b := make([]byte, 14+20+20+n)
+	copy(b[0:], []byte{0x02, 0, 0, 0, 0, 2})
+	copy(b[6:], []byte{0x02, 0, 0, 0, 0, 1})
+	binary.BigEndian.PutUint16(b[12:], 0x0800)
+	ip := b[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(40+n))
+	ip[8], ip[9] = 64, 6
+	copy(ip[12:], src.To4())
+	copy(ip[16:], dst.To4())
+	tcp := ip[20:]
+	binary.BigEndian.PutUint16(tcp[0:], sport)
+	binary.BigEndian.PutUint16(tcp[2:], dport)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12], tcp[13] = 5<<4, 0x10 // ACK
+	binary.BigEndian.PutUint16(tcp[14:], 100)
+	return b
+}
+
+func TestFlowHash(t *testing.T) {
+	client, server := net.ParseIP("10.0.0.2"), net.ParseIP("192.0.2.80")
+	out := flowHash(frame(client, server, 50000, 443, 1, 1, 10))
+	if back := flowHash(frame(server, client, 443, 50000, 1, 1, 0)); back != out {
+		t.Errorf("flowHash: got %x back, %x out; want the same", back, out)
+	}
+	if other := flowHash(frame(client, server, 50001, 443, 1, 1, 10)); other == out {
+		t.Errorf("flowHash: another port got the same hash %x", other)
+	}
+
+	// Tagged with a VLAN, it is the same flow.
+	f := frame(client, server, 50000, 443, 1, 1, 10)
+	tagged := append(append(append([]byte{}, f[:12]...), 0x81, 0x00, 0x00, 0x2a), f[12:]...)
+	if got := flowHash(tagged); got != out {
+		t.Errorf("flowHash(tagged): got %x, want %x", got, out)
+	}
+
+	for _, short := range [][]byte{nil, f[:13], f[:20]} {
+		if got := flowHash(short); got != 0 {
+			t.Errorf("flowHash(%d bytes): got %x, want 0", len(short), got)
+		}
+	}
+}
+
+// testPacket is a packet read from nowhere.
+type testPacket struct {
+	gopacket.Packet
+	data []byte
+	md   gopacket.PacketMetadata
+}
+
+func (p *testPacket) Data() []byte                       { return p.data }
+func (p *testPacket) Metadata() *gopacket.PacketMetadata { return &p.md }
+
+func TestProcessorsKeepFlowOrder(t *testing.T) {
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	server := net.ParseIP("192.0.2.80")
+	var got []Flow
+	c := &Capture{
+		Account:    func(*Metadata) {},
+		BufferSize: 10,
+		Flows:      &FlowTable{Export: func(recs []Flow) { got = append(got, recs...) }},
+	}
+	packetsCh := make(chan gopacket.Packet, 10)
+	p := &processorPool{c: c, packetsCh: packetsCh}
+	p.resize(4)
+
+	// Several flows, each of many segments, interleaved.
+	const clients, segments = 8, 200
+	at := start
+	for i := 0; i < segments; i++ {
+		for j := 0; j < clients; j++ {
+			client := net.IPv4(10, 0, 0, byte(j+2))
+			data := frame(client, server, 50000, 443, uint32(1+100*i), 1, 100)
+			at = at.Add(time.Millisecond)
+			md := gopacket.PacketMetadata{CaptureInfo: gopacket.CaptureInfo{Timestamp: at, CaptureLength: len(data), Length: len(data)}}
+			packetsCh <- &testPacket{data: data, md: md}
+		}
+	}
+	close(packetsCh)
+	p.wg.Wait()
+	c.Flows.Close()
+
+	if len(got) != clients {
+		t.Fatalf("got %d records, want %d", len(got), clients)
+	}
+	for _, f := range got {
+		if f.SrcPackets != segments {
+			t.Errorf("%v: got %d packets, want %d", f.SrcIP, f.SrcPackets, segments)
+		}
+		if f.TCPQuality != (TCPQuality{}) {
+			t.Errorf("%v: TCPQuality got %+v, want none", f.SrcIP, f.TCPQuality)
+		}
+	}
+}
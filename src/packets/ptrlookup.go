@@ -0,0 +1,199 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file looks up the names of addresses caplog has no other name for,
+// with PTR queries, so that addresses resolved before caplog started (or over
+// DNS-over-HTTPS) still get names. The lookups are made in the background at
+// a limited rate, and each address is looked up at most once per cache TTL,
+// whether or not it had a name.
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vars"
+)
+
+const (
+	// maxPTRQueue bounds the addresses waiting to be looked up. Others are
+	// dropped, and looked up when next seen.
+	maxPTRQueue = 256
+
+	// maxPTRTracked bounds the addresses remembered as looked up.
+	maxPTRTracked = 65536
+
+	ptrTimeout = 2 * time.Second
+)
+
+// ptrStats count the lookups, accessed atomically.
+var ptrStats struct {
+	lookups, named, failed, dropped uint64
+}
+
+func init() {
+	vars.Uint64("ptr-lookups", &ptrStats.lookups)
+	vars.Uint64("ptr-lookup-names", &ptrStats.named)
+	vars.Uint64("ptr-lookup-failures", &ptrStats.failed)
+	vars.Uint64("ptr-lookup-dropped", &ptrStats.dropped)
+}
+
+// PTRLookups looks up the names of addresses in the background.
+type PTRLookups struct {
+	// Resolver makes the lookups (net.DefaultResolver if nil).
+	Resolver *net.Resolver
+
+	// Rate is the most lookups a second (at least 1).
+	Rate int
+
+	// CacheTTL is how long before an address is looked up again.
+	CacheTTL time.Duration
+
+	queue chan net.IP
+	done  chan struct{}
+
+	mu    sync.RWMutex
+	tried map[[16]byte]time.Time // when each address may be looked up again
+}
+
+var (
+	ptrLookupsMu sync.Mutex
+	ptrLookups   atomic.Value // *PTRLookups
+)
+
+func init() {
+	ptrLookups.Store((*PTRLookups)(nil))
+}
+
+// SetPTRLookups starts looking up names with p, instead of any set before.
+// nil turns lookups off.
+func SetPTRLookups(p *PTRLookups) {
+	if p != nil {
+		p.queue = make(chan net.IP, maxPTRQueue)
+		p.done = make(chan struct{})
+		p.tried = make(map[[16]byte]time.Time)
+		go p.run()
+	}
+	ptrLookupsMu.Lock()
+	defer ptrLookupsMu.Unlock()
+	if old := ptrLookups.Load().(*PTRLookups); old != nil {
+		close(old.done)
+	}
+	ptrLookups.Store(p)
+}
+
+// lookUpUnnamed asks for the addresses of the packet that weren't named to
+// be looked up, if lookups are on.
+func lookUpUnnamed(m *Metadata) {
+	p := ptrLookups.Load().(*PTRLookups)
+	if p == nil || !enabled(NameLookup) {
+		return
+	}
+	if m.SrcNameSource == NameNone {
+		p.request(m.SrcIP, m.Timestamp)
+	}
+	if m.DstNameSource == NameNone {
+		p.request(m.DstIP, m.Timestamp)
+	}
+}
+
+// request queues the address to be looked up, unless it was tried in the
+// last CacheTTL before now.
+func (p *PTRLookups) request(ip net.IP, now time.Time) {
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() || ip.Equal(net.IPv4bcast) {
+		return
+	}
+	k := ipKey(ip)
+	p.mu.RLock()
+	next, ok := p.tried[k]
+	p.mu.RUnlock()
+	if ok && now.Before(next) {
+		return
+	}
+	p.mu.Lock()
+	if next, ok := p.tried[k]; ok && now.Before(next) {
+		p.mu.Unlock()
+		return
+	}
+	if len(p.tried) >= maxPTRTracked {
+		for k, next := range p.tried {
+			if !now.Before(next) {
+				delete(p.tried, k)
+			}
+		}
+		if len(p.tried) >= maxPTRTracked {
+			p.mu.Unlock()
+			atomic.AddUint64(&ptrStats.dropped, 1)
+			return
+		}
+	}
+	p.tried[k] = now.Add(p.CacheTTL)
+	p.mu.Unlock()
+	select {
+	case p.queue <- append(net.IP(nil), ip...):
+	default:
+		// Try again when it is next seen.
+		p.mu.Lock()
+		delete(p.tried, k)
+		p.mu.Unlock()
+		atomic.AddUint64(&ptrStats.dropped, 1)
+	}
+}
+
+// run looks up the queued addresses, at most Rate a second, until done is
+// closed.
+func (p *PTRLookups) run() {
+	rate := p.Rate
+	if rate < 1 {
+		rate = 1
+	}
+	tick := time.NewTicker(time.Second / time.Duration(rate))
+	defer tick.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case ip := <-p.queue:
+			p.lookup(ip)
+		}
+		select {
+		case <-p.done:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// lookup looks up the address, and names it with the first name found.
+func (p *PTRLookups) lookup(ip net.IP) {
+	r := p.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ptrTimeout)
+	names, err := r.LookupAddr(ctx, ip.String())
+	cancel()
+	atomic.AddUint64(&ptrStats.lookups, 1)
+	if err != nil || len(names) == 0 {
+		atomic.AddUint64(&ptrStats.failed, 1)
+		return
+	}
+	atomic.AddUint64(&ptrStats.named, 1)
+	globalNames.set(ip, strings.TrimSuffix(names[0], "."), NameLookup, time.Now())
+}
@@ -0,0 +1,133 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ptrServer answers every query on a local UDP port with a PTR record for
+// name, and counts the queries.
+func ptrServer(t *testing.T, name string, queries *int32) *net.Resolver {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(queries, 1)
+			q := buf[:n]
+			b := append([]byte(nil), q[:2]...)
+			b = append(b, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0)
+			end := 12
+			for end < n && q[end] != 0 {
+				end += 1 + int(q[end])
+			}
+			b = append(b, q[12:end+5]...) // just the question, not EDNS
+			var rdata []byte
+			for _, s := range splitLabels(name) {
+				rdata = append(rdata, byte(len(s)))
+				rdata = append(rdata, s...)
+			}
+			rdata = append(rdata, 0)
+			b = append(b, 0xc0, 12, 0, 12, 0, 1, 0, 0, 1, 0, 0, byte(len(rdata)))
+			b = append(b, rdata...)
+			conn.WriteTo(b, addr)
+		}
+	}()
+	server := conn.LocalAddr().String()
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", server)
+		},
+	}
+}
+
+func TestPTRLookup(t *testing.T) {
+	var queries int32
+	p := &PTRLookups{
+		Resolver: ptrServer(t, "printer.example.", &queries),
+		Rate:     100,
+		CacheTTL: time.Hour,
+	}
+	SetPTRLookups(p)
+	defer SetPTRLookups(nil)
+	defer ForgetNames(NameLookup)
+
+	now := time.Now()
+	ip := net.IPv4(192, 168, 1, 50)
+	m := &Metadata{Timestamp: now, SrcIP: ip, DstIP: net.IPv4(192, 168, 1, 1), DstNameSource: NameDHCP}
+	lookUpUnnamed(m)
+	lookUpUnnamed(m)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if e, ok := globalNames.get(ip); ok {
+			if e.name != "printer.example" || e.source != NameLookup {
+				t.Errorf("name = %q from %v, want %q from lookup", e.name, e.source, "printer.example")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no name looked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&queries); got != 1 {
+		t.Errorf("queries = %d, want 1 (the named destination isn't looked up, and the source is cached)", got)
+	}
+
+	// Once the cache TTL is up, it's looked up again.
+	p.request(ip, now.Add(2*time.Hour))
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&queries) < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("not looked up again after the cache TTL")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPTRLookupSkips(t *testing.T) {
+	p := &PTRLookups{CacheTTL: time.Hour, queue: make(chan net.IP, 1), tried: make(map[[16]byte]time.Time)}
+	now := time.Now()
+	for _, ip := range []net.IP{nil, net.IPv4zero, net.IPv4(127, 0, 0, 1), net.IPv4(224, 0, 0, 251), net.IPv4bcast} {
+		p.request(ip, now)
+	}
+	if len(p.queue) != 0 {
+		t.Errorf("%d addresses queued, want 0", len(p.queue))
+	}
+
+	// With the queue full, an address is dropped, and tried again later.
+	p.request(net.IPv4(10, 0, 0, 1), now)
+	p.request(net.IPv4(10, 0, 0, 2), now)
+	if len(p.queue) != 1 {
+		t.Fatalf("%d addresses queued, want 1", len(p.queue))
+	}
+	<-p.queue
+	p.request(net.IPv4(10, 0, 0, 2), now)
+	if len(p.queue) != 1 {
+		t.Errorf("dropped address not queued when seen again")
+	}
+}
@@ -0,0 +1,380 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file recognises QUIC (HTTP/3) on UDP port 443, and learns the server
+// names in QUIC connections' ClientHellos. QUIC encrypts even its first
+// packets, but the keys of Initial packets come from the connection ID the
+// client chose, which is in the clear (RFC 9001, section 5.2), so anyone can
+// read them. A ClientHello may be split across several Initial packets, so
+// their CRYPTO frames are put back together.
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	quicPort = 443
+
+	quicVersion1 = 0x00000001 // RFC 9000
+	quicVersion2 = 0x6b3343cf // RFC 9369
+
+	// maxQUICHellos bounds the ClientHellos being put back together.
+	maxQUICHellos = 1024
+
+	// maxQUICHelloSize is the largest ClientHello put back together.
+	maxQUICHelloSize = 16 << 10
+
+	// maxQUICHelloPackets is how many Initial packets a ClientHello may
+	// take before it is given up on.
+	maxQUICHelloPackets = 8
+
+	// quicHelloIdle is how long a ClientHello can wait for its next part.
+	quicHelloIdle = 10 * time.Second
+)
+
+// The salts the Initial secrets are derived with.
+var (
+	quicSaltV1 = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+	quicSaltV2 = []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9}
+)
+
+func init() {
+	RegisterEnricher("quic", func(c *Capture) Enricher { return EnricherFunc(c.quic) })
+}
+
+// quic marks QUIC packets with AppQUIC, and names the server of a client's
+// Initial packets by their ClientHello's server name, as for TLS over TCP.
+// Packets with short headers don't say they are QUIC, so they are only
+// recognised if their flow started with a long header.
+func (c *Capture) quic(p *Packet) {
+	m := p.Meta
+	if !p.Has(layers.LayerTypeUDP) || (m.SrcPort != quicPort && m.DstPort != quicPort) {
+		return
+	}
+	b := p.UDP.Payload
+	if len(b) < 5 || b[0]&0x40 == 0 { // the fixed bit
+		return
+	}
+	if b[0]&0x80 == 0 {
+		if server, _ := c.quicFlows.server(m); server != nil {
+			m.AppProtocol = AppQUIC
+		}
+		return
+	}
+	switch binary.BigEndian.Uint32(b[1:5]) {
+	case quicVersion1, quicVersion2:
+	default:
+		return
+	}
+	m.AppProtocol = AppQUIC
+	if m.DstPort != quicPort {
+		return
+	}
+	c.quicFlows.add(m, AppQUIC)
+	if !enabled(NameSNI) {
+		return
+	}
+	if n := c.quicHellos.serverName(b, m.Timestamp); n != "" {
+		c.reverseDNSMap().addName(m.SrcIP, n, NameSNI, []net.IP{m.DstIP})
+		c.sniFlows.add(m, n)
+	}
+}
+
+// quicFragment is the data of a CRYPTO frame, at an offset in the stream.
+type quicFragment struct {
+	off  int
+	data []byte
+}
+
+// quicHello is a ClientHello being put back together.
+type quicHello struct {
+	frags   []quicFragment
+	packets int
+	seen    time.Time
+}
+
+// quicHelloTable has the ClientHellos still missing parts, by the
+// destination connection ID of their Initial packets, which the client keeps
+// until the server replies.
+type quicHelloTable struct {
+	mu     sync.Mutex
+	hellos map[string]*quicHello // made when first needed
+}
+
+// serverName returns the server name of the ClientHello in a client's
+// Initial packet, once all of it has been seen, or "".
+func (t *quicHelloTable) serverName(b []byte, now time.Time) string {
+	dcid, frags := openQUICInitial(b)
+	if len(frags) == 0 {
+		return ""
+	}
+	// Most ClientHellos fit in one packet.
+	if n, done := quicServerName(frags); done {
+		return n
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hellos == nil {
+		t.hellos = make(map[string]*quicHello)
+	}
+	h := t.hellos[string(dcid)]
+	if h == nil {
+		if len(t.hellos) >= maxQUICHellos {
+			t.sweep(now)
+			if len(t.hellos) >= maxQUICHellos {
+				return ""
+			}
+		}
+		h = new(quicHello)
+		t.hellos[string(dcid)] = h
+	}
+	h.frags = append(h.frags, frags...)
+	h.packets++
+	h.seen = now
+	n, done := quicServerName(h.frags)
+	if done || h.packets >= maxQUICHelloPackets {
+		delete(t.hellos, string(dcid))
+	}
+	return n
+}
+
+// sweep forgets the ClientHellos idle for quicHelloIdle before now (or after
+// it, for replayed captures). t.mu must be held.
+func (t *quicHelloTable) sweep(now time.Time) {
+	for k, h := range t.hellos {
+		if d := now.Sub(h.seen); d >= quicHelloIdle || d <= -quicHelloIdle {
+			delete(t.hellos, k)
+		}
+	}
+}
+
+// quicServerName puts the CRYPTO stream back together from the start, and
+// returns the ClientHello's server name. done is false if the ClientHello
+// isn't all there yet.
+func quicServerName(frags []quicFragment) (name string, done bool) {
+	sort.Slice(frags, func(i, j int) bool { return frags[i].off < frags[j].off })
+	var hello []byte
+	for _, f := range frags {
+		if f.off > len(hello) {
+			break // a gap
+		}
+		if end := f.off + len(f.data); end > len(hello) {
+			hello = append(hello, f.data[len(hello)-f.off:]...)
+		}
+	}
+	if len(hello) < 4 {
+		return "", false
+	}
+	if hello[0] != 1 { // not a ClientHello
+		return "", true
+	}
+	n := 4 + (int(hello[1])<<16 | int(hello[2])<<8 | int(hello[3]))
+	if n > maxQUICHelloSize {
+		return "", true
+	}
+	if len(hello) < n {
+		return "", false
+	}
+	return clientHelloServerName(hello[:n]), true
+}
+
+// openQUICInitial decrypts a client's Initial packet (the first, if several
+// are coalesced in the datagram), and returns its destination connection ID
+// and CRYPTO frames. It returns no frames if the packet isn't an Initial
+// packet of a known version, or can't be decrypted.
+func openQUICInitial(b []byte) (dcid []byte, frags []quicFragment) {
+	if len(b) < 7 || b[0]&0xc0 != 0xc0 {
+		return nil, nil
+	}
+	var (
+		salt   []byte
+		prefix string // of the labels
+		typ    byte   // of Initial packets
+	)
+	switch binary.BigEndian.Uint32(b[1:5]) {
+	case quicVersion1:
+		salt, prefix, typ = quicSaltV1, "quic ", 0
+	case quicVersion2:
+		salt, prefix, typ = quicSaltV2, "quicv2 ", 1
+	default:
+		return nil, nil
+	}
+	if (b[0]>>4)&3 != typ {
+		return nil, nil
+	}
+	off := 5
+	n := int(b[off])
+	off++
+	if n > 20 || len(b) < off+n+1 {
+		return nil, nil
+	}
+	dcid = b[off : off+n]
+	off += n
+	n = int(b[off]) // source connection ID
+	off++
+	if n > 20 || len(b) < off+n {
+		return nil, nil
+	}
+	off += n
+	token, w := quicVarint(b[off:])
+	if w == 0 || uint64(len(b)-off-w) < token {
+		return nil, nil
+	}
+	off += w + int(token)
+	length, w := quicVarint(b[off:])
+	off += w
+	// The header protection sample starts 4 bytes into the packet number.
+	if w == 0 || uint64(len(b)-off) < length || length < 20 {
+		return nil, nil
+	}
+	pnOff, end := off, off+int(length)
+
+	secret := hkdfExpandLabel(hkdfExtract(salt, dcid), "client in", 32)
+	hp, err := aes.NewCipher(hkdfExpandLabel(secret, prefix+"hp", 16))
+	if err != nil {
+		return nil, nil
+	}
+	var mask [aes.BlockSize]byte
+	hp.Encrypt(mask[:], b[pnOff+4:pnOff+4+aes.BlockSize])
+	// Unprotect a copy of the header, since the packet's buffer isn't ours.
+	hdr := append([]byte(nil), b[:pnOff+4]...)
+	hdr[0] ^= mask[0] & 0x0f
+	pnLen := int(hdr[0]&3) + 1
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		hdr[pnOff+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(hdr[pnOff+i])
+	}
+	hdr = hdr[:pnOff+pnLen]
+
+	block, err := aes.NewCipher(hkdfExpandLabel(secret, prefix+"key", 16))
+	if err != nil {
+		return nil, nil
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil
+	}
+	nonce := hkdfExpandLabel(secret, prefix+"iv", aead.NonceSize())
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * uint(i)))
+	}
+	payload, err := aead.Open(nil, nonce, b[pnOff+pnLen:end], hdr)
+	if err != nil {
+		return nil, nil
+	}
+	return dcid, quicCryptoFrames(payload)
+}
+
+// quicCryptoFrames returns the CRYPTO frames in the payload of an Initial
+// packet. It stops at any frame it doesn't expect there.
+func quicCryptoFrames(b []byte) []quicFragment {
+	var frags []quicFragment
+	for len(b) > 0 {
+		typ := b[0]
+		b = b[1:]
+		switch typ {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x02, 0x03: // ACK: largest, delay, count, first range
+			var v [4]uint64
+			if b = quicVarints(b, v[:]); b == nil {
+				return frags
+			}
+			// Each further range is a gap and a length, and ACK_ECN
+			// has three counts.
+			extra := 2 * v[2]
+			if typ == 0x03 {
+				extra += 3
+			}
+			for ; extra > 0 && b != nil; extra-- {
+				b = quicVarints(b, v[:1])
+			}
+			if b == nil {
+				return frags
+			}
+		case 0x06: // CRYPTO: offset, length, data
+			var v [2]uint64
+			if b = quicVarints(b, v[:]); b == nil || uint64(len(b)) < v[1] || v[0]+v[1] > maxQUICHelloSize {
+				return frags
+			}
+			frags = append(frags, quicFragment{int(v[0]), b[:v[1]]})
+			b = b[v[1]:]
+		default:
+			return frags
+		}
+	}
+	return frags
+}
+
+// quicVarint decodes a QUIC variable-length integer, returning it and its
+// length, or a length of 0 if b is too short.
+func quicVarint(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, 0
+	}
+	v := uint64(b[0] & 0x3f)
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n
+}
+
+// quicVarints decodes len(v) variable-length integers into v, and returns
+// the rest of b, or nil if b is too short.
+func quicVarints(b []byte, v []uint64) []byte {
+	for i := range v {
+		var n int
+		if v[i], n = quicVarint(b); n == 0 {
+			return nil
+		}
+		b = b[n:]
+	}
+	return b
+}
+
+// hkdfExtract is HKDF-Extract with SHA-256 (RFC 5869).
+func hkdfExtract(salt, secret []byte) []byte {
+	h := hmac.New(sha256.New, salt)
+	h.Write(secret)
+	return h.Sum(nil)
+}
+
+// hkdfExpandLabel is TLS 1.3's HKDF-Expand-Label with SHA-256 and an empty
+// context (RFC 8446, section 7.1), for lengths of up to 32 bytes.
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	const tls13 = "tls13 "
+	info := []byte{byte(length >> 8), byte(length), byte(len(tls13) + len(label))}
+	info = append(info, tls13...)
+	info = append(info, label...)
+	info = append(info, 0, 1) // the empty context, and HKDF's counter
+	h := hmac.New(sha256.New, secret)
+	h.Write(info)
+	return h.Sum(nil)[:length]
+}
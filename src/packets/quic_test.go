@@ -0,0 +1,187 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestQUICInitialKeys(t *testing.T) {
+	// RFC 9001, appendix A.1, and RFC 9369, appendix A.1.
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+	tests := []struct {
+		salt                []byte
+		prefix, key, iv, hp string
+	}{
+		{quicSaltV1, "quic ", "1f369613dd76d5467730efcbe3b1a22d", "fa044b2f42a3fd3b46fb255c", "9f50449e04a0e810283a1e9933adedd2"},
+		{quicSaltV2, "quicv2 ", "8b1a0bc121284290a29e0971b5cd045d", "91f73e2351d8fa91660e909f", "45b95e15235d6f45a6b19cbcb0294ba9"},
+	}
+	for _, test := range tests {
+		secret := hkdfExpandLabel(hkdfExtract(test.salt, dcid), "client in", 32)
+		for _, k := range []struct{ label, want string }{{"key", test.key}, {"iv", test.iv}, {"hp", test.hp}} {
+			if got := hex.EncodeToString(hkdfExpandLabel(secret, test.prefix+k.label, len(k.want)/2)); got != k.want {
+				t.Errorf("%s%s: got %s, want %s", test.prefix, k.label, got, k.want)
+			}
+		}
+	}
+}
+
+// appendQUICVarint appends v in the two-byte form.
+func appendQUICVarint(b []byte, v int) []byte {
+	return append(b, 0x40|byte(v>>8), byte(v))
+}
+
+// cryptoFrame makes a CRYPTO frame.
+func cryptoFrame(off int, data []byte) []byte {
+	b := appendQUICVarint([]byte{0x06}, off)
+	b = appendQUICVarint(b, len(data))
+	return append(b, data...)
+}
+
+// sealQUICInitial makes a client's Initial packet, padded as clients must.
+func sealQUICInitial(t *testing.T, version uint32, dcid []byte, pn uint16, frames ...[]byte) []byte {
+	salt, prefix, typ := quicSaltV1, "quic ", byte(0)
+	if version == quicVersion2 {
+		salt, prefix, typ = quicSaltV2, "quicv2 ", 1
+	}
+	payload := bytes.Join(frames, nil)
+	if len(payload) < 1100 {
+		payload = append(payload, make([]byte, 1100-len(payload))...)
+	}
+	hdr := []byte{0xc0 | typ<<4 | 1, byte(version >> 24), byte(version >> 16), byte(version >> 8), byte(version), byte(len(dcid))}
+	hdr = append(hdr, dcid...)
+	hdr = append(hdr, 0, 0) // no source connection ID or token
+	hdr = appendQUICVarint(hdr, 2+len(payload)+16)
+	pnOff := len(hdr)
+	hdr = append(hdr, byte(pn>>8), byte(pn))
+
+	secret := hkdfExpandLabel(hkdfExtract(salt, dcid), "client in", 32)
+	block, err := aes.NewCipher(hkdfExpandLabel(secret, prefix+"key", 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := hkdfExpandLabel(secret, prefix+"iv", 12)
+	nonce[10] ^= byte(pn >> 8)
+	nonce[11] ^= byte(pn)
+	b := aead.Seal(append([]byte(nil), hdr...), nonce, payload, hdr)
+
+	hp, err := aes.NewCipher(hkdfExpandLabel(secret, prefix+"hp", 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mask [16]byte
+	hp.Encrypt(mask[:], b[pnOff+4:pnOff+20])
+	b[0] ^= mask[0] & 0x0f
+	b[pnOff] ^= mask[1]
+	b[pnOff+1] ^= mask[2]
+	return b
+}
+
+func TestQUICServerName(t *testing.T) {
+	hello := clientHello(t, "quic.example")[5:] // without the record header
+	half := len(hello) / 2
+	dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	ack := []byte{0x02, 0, 0, 0, 0}
+
+	whole := sealQUICInitial(t, quicVersion1, dcid, 0, cryptoFrame(0, hello))
+	tampered := append([]byte(nil), whole...)
+	tampered[len(tampered)-1] ^= 1
+	tests := []struct {
+		name    string
+		packets [][]byte
+		want    []string
+	}{
+		{"v1", [][]byte{whole}, []string{"quic.example"}},
+		{"v2", [][]byte{sealQUICInitial(t, quicVersion2, dcid, 0, cryptoFrame(0, hello))}, []string{"quic.example"}},
+		{"frames out of order", [][]byte{
+			sealQUICInitial(t, quicVersion1, dcid, 0, []byte{0x01}, cryptoFrame(half, hello[half:]), cryptoFrame(0, hello[:half])),
+		}, []string{"quic.example"}},
+		{"two packets", [][]byte{
+			sealQUICInitial(t, quicVersion1, dcid, 0, cryptoFrame(half, hello[half:])),
+			sealQUICInitial(t, quicVersion1, dcid, 1, ack, cryptoFrame(0, hello[:half])),
+		}, []string{"", "quic.example"}},
+		{"tampered", [][]byte{tampered}, []string{""}},
+		{"not QUIC", [][]byte{bytes.Repeat([]byte{0xc3}, 1200)}, []string{""}},
+	}
+	for _, test := range tests {
+		var tab quicHelloTable
+		for i, b := range test.packets {
+			if got := tab.serverName(b, time.Unix(0, 0)); got != test.want[i] {
+				t.Errorf("%s: packet %d: got %q, want %q", test.name, i, got, test.want[i])
+			}
+		}
+		if len(tab.hellos) != 0 {
+			t.Errorf("%s: %d ClientHellos left waiting, want 0", test.name, len(tab.hellos))
+		}
+	}
+}
+
+func TestQUICEnricher(t *testing.T) {
+	revDNSMaps.Lock()
+	saved := revDNSMaps.list
+	revDNSMaps.Unlock()
+	defer func() {
+		revDNSMaps.Lock()
+		revDNSMaps.list = saved
+		revDNSMaps.Unlock()
+	}()
+
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	client, server := net.ParseIP("10.0.0.2"), net.ParseIP("192.0.2.80")
+	pkt := func(src, dst net.IP, sport, dport uint16, payload []byte) *Packet {
+		return &Packet{
+			Meta:    &Metadata{Timestamp: start, SrcIP: src, DstIP: dst, SrcPort: sport, DstPort: dport, Protocol: 17},
+			Decoded: []gopacket.LayerType{layers.LayerTypeIPv4, layers.LayerTypeUDP},
+			IPv4:    &layers.IPv4{SrcIP: src, DstIP: dst},
+			UDP:     &layers.UDP{BaseLayer: layers.BaseLayer{Payload: payload}},
+		}
+	}
+	c := &Capture{}
+	c.reverseDNSMap()
+	enrich := func(p *Packet) *Metadata {
+		c.quic(p)
+		c.reverseDNS(p)
+		return p.Meta
+	}
+
+	initial := sealQUICInitial(t, quicVersion1, []byte{1, 2, 3, 4}, 0, cryptoFrame(0, clientHello(t, "h3.example")[5:]))
+	short := append([]byte{0x41}, make([]byte, 40)...)
+	if m := enrich(pkt(client, server, 50000, 443, initial)); m.AppProtocol != AppQUIC || m.DstName != "h3.example" || m.DstNameSource != NameSNI {
+		t.Errorf("Initial: got %q, %q from %v, want %q, %q from %v", m.AppProtocol, m.DstName, m.DstNameSource, AppQUIC, "h3.example", NameSNI)
+	}
+	if m := enrich(pkt(server, client, 443, 50000, short)); m.AppProtocol != AppQUIC || m.SrcName != "h3.example" {
+		t.Errorf("short header reply: got %q, %q, want %q, %q", m.AppProtocol, m.SrcName, AppQUIC, "h3.example")
+	}
+	// Short headers of a flow not seen starting aren't recognisable.
+	if m := enrich(pkt(client, server, 50001, 443, short)); m.AppProtocol != "" {
+		t.Errorf("short header of an unknown flow: got %q, want \"\"", m.AppProtocol)
+	}
+	if m := enrich(pkt(client, server, 50002, 53, initial)); m.AppProtocol != "" {
+		t.Errorf("UDP port 53: got %q, want \"\"", m.AppProtocol)
+	}
+}
@@ -17,97 +17,343 @@ package packets
 // This file implements a concurrent-safe reverse DNS map.
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 )
 
-// reverseDNSMap is a concurrent-safe reverse DNS mapping (from Endpoints to names).
+// revDNSShards is the number of shards in each reverseDNSMap. Every processor
+// looks up names for every packet, and adds those in DNS answers, so a
+// single lock is contended.
+const revDNSShards = 16
+
+// maxNamesPerAddr bounds the names kept for each address. A CDN address
+// serves many names, so one isn't enough.
+const maxNamesPerAddr = 4
+
+type revDNSShard struct {
+	sync.RWMutex
+	rm map[gopacket.Endpoint][]nameEntry // made when first needed
+}
+
+// reverseDNSMap is a concurrent-safe reverse DNS mapping (from Endpoints to
+// names). It keeps a few names for each endpoint, with how often and when
+// each was last given.
 type reverseDNSMap struct {
-	rm map[gopacket.Endpoint]string
-	mu sync.RWMutex
+	shards [revDNSShards]revDNSShard
 }
 
 // newReverseDNSMap makes an empty reverseDNSMap.
 func newReverseDNSMap() *reverseDNSMap {
-	return &reverseDNSMap{
-		rm: make(map[gopacket.Endpoint]string),
+	return new(reverseDNSMap)
+}
+
+// shard returns the shard for an endpoint.
+func (r *reverseDNSMap) shard(e gopacket.Endpoint) *revDNSShard {
+	return &r.shards[e.FastHash()%revDNSShards]
+}
+
+// lookup returns the name that mapped to the given endpoint most recently
+// from the most confident source, if any.
+func (r *reverseDNSMap) lookup(e gopacket.Endpoint) (nameEntry, bool) {
+	return r.choose(e, func(a, b *nameEntry) bool { return a.seen.After(b.seen) })
+}
+
+// popular returns the name that mapped to the given endpoint most often
+// from the most confident source, if any.
+func (r *reverseDNSMap) popular(e gopacket.Endpoint) (nameEntry, bool) {
+	return r.choose(e, func(a, b *nameEntry) bool {
+		if a.hits != b.hits {
+			return a.hits > b.hits
+		}
+		return a.seen.After(b.seen)
+	})
+}
+
+// choose returns the endpoint's name from the most confident source, and
+// among those, the one that is better.
+func (r *reverseDNSMap) choose(e gopacket.Endpoint, better func(a, b *nameEntry) bool) (nameEntry, bool) {
+	s := r.shard(e)
+	s.RLock()
+	defer s.RUnlock()
+	set := s.rm[e]
+	if len(set) == 0 {
+		return nameEntry{}, false
 	}
+	best := &set[0]
+	for i := range set[1:] {
+		n := &set[i+1]
+		if rank(n.source) > rank(best.source) || (n.source == best.source && better(n, best)) {
+			best = n
+		}
+	}
+	return *best, enabled(best.source)
+}
+
+// all returns every name for the endpoint.
+func (r *reverseDNSMap) all(e gopacket.Endpoint) []nameEntry {
+	s := r.shard(e)
+	s.RLock()
+	defer s.RUnlock()
+	return append([]nameEntry(nil), s.rm[e]...)
 }
 
 // name returns either the name that mapped to the given endpoint most recently,
 // or the formatted endpoint if not found.
 func (r *reverseDNSMap) name(e gopacket.Endpoint) string {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	if n, ok := r.rm[e]; ok {
-		return n
+	if n, ok := r.lookup(e); ok {
+		return n.name
 	}
 	return e.String()
 }
 
+// set records that the endpoint was given the name by the source at t. If
+// the endpoint already has as many names as are kept, the least confident,
+// least often given one (the least recent, of those) is forgotten.
+func (r *reverseDNSMap) set(e gopacket.Endpoint, name string, source NameSource, t time.Time) {
+	r.put(e, nameEntry{name: name, source: source, seen: t, hits: 1})
+}
+
+// put is set for a name given n.hits times, the last at n.seen, which
+// expires at n.expires (or, if that is unset, after the minimum TTL).
+func (r *reverseDNSMap) put(e gopacket.Endpoint, n nameEntry) {
+	if !enabled(n.source) {
+		return
+	}
+	if n.expires.IsZero() {
+		n.expires = nameExpiry(n.seen, 0)
+	}
+	s := r.shard(e)
+	s.Lock()
+	defer s.Unlock()
+	if s.rm == nil {
+		s.rm = make(map[gopacket.Endpoint][]nameEntry)
+	}
+	set := s.rm[e]
+	for i := range set {
+		if old := &set[i]; old.name == n.name {
+			old.hits += n.hits
+			if n.seen.After(old.seen) {
+				old.seen = n.seen
+			}
+			if rank(n.source) > rank(old.source) {
+				old.source = n.source
+			}
+			if n.expires.After(old.expires) {
+				old.expires = n.expires
+			}
+			return
+		}
+	}
+	if len(set) < maxNamesPerAddr {
+		s.rm[e] = append(set, n)
+		if len(set) == 0 {
+			s.evict(shardCap())
+		}
+		return
+	}
+	worst := 0
+	for i := 1; i < len(set); i++ {
+		if lessUseful(&set[i], &set[worst]) {
+			worst = i
+		}
+	}
+	set[worst] = n
+}
+
+// lessUseful reports whether name a is less worth keeping than b.
+func lessUseful(a, b *nameEntry) bool {
+	if ra, rb := rank(a.source), rank(b.source); ra != rb {
+		return ra < rb
+	}
+	if a.hits != b.hits {
+		return a.hits < b.hits
+	}
+	return a.seen.Before(b.seen)
+}
+
 // names maps the names for both endpoints of a flow.
 func (r *reverseDNSMap) names(netFlow gopacket.Flow) (string, string) {
 	src, dst := netFlow.Endpoints()
 	return r.name(src), r.name(dst)
 }
 
-// add reads the DNS answers and adds them to the mapping.
-func (r *reverseDNSMap) add(dns *layers.DNS) {
+// dnsAnswer is the chain of names an address was the answer for, and the
+// TTL of the address record.
+type dnsAnswer struct {
+	names string
+	ttl   time.Duration
+}
+
+// entry returns the answer as a name given at t.
+func (a dnsAnswer) entry(t time.Time) nameEntry {
+	return nameEntry{name: a.names, source: NameDNS, seen: t, hits: 1, expires: nameExpiry(t, a.ttl)}
+}
+
+// answers reads the DNS answers, returning the chain of names (the final
+// name first) that each address was the answer for. PTR answers name the
+// address for everyone, not only the host that asked, so they go straight
+// into the global table.
+func answers(dns *layers.DNS, now time.Time) map[gopacket.Endpoint]dnsAnswer {
 	// Extract A, quad A, and CNAME records into useful maps.
 	cnames := make(map[string]string)
-	ips := make(map[gopacket.Endpoint]string)
-	for _, a := range dns.Answers {
-		if a.Class != layers.DNSClassIN {
+	ips := make(map[gopacket.Endpoint]dnsAnswer)
+	for i := range dns.Answers {
+		a := &dns.Answers[i]
+		if a.Class != layers.DNSClassIN || !saneAnswer(a) {
 			continue
 		}
 		switch a.Type {
 		case layers.DNSTypeA, layers.DNSTypeAAAA:
-			ips[layers.NewIPEndpoint(a.IP)] = string(a.Name)
+			ips[layers.NewIPEndpoint(a.IP)] = dnsAnswer{string(a.Name), time.Duration(a.TTL) * time.Second}
 		case layers.DNSTypeCNAME:
 			cnames[string(a.CNAME)] = string(a.Name)
+		case layers.DNSTypePTR:
+			if ip := ptrAddr(string(a.Name)); ip != nil {
+				globalNames.set(ip, strings.TrimSuffix(string(a.PTR), "."), NamePTR, now)
+			}
 		}
 	}
 	// Create a topologically-sorted chain of CNAMEs resolving to each IP.
-	r.mu.Lock()
-	for ip, n := range ips {
+	for ip, a := range ips {
 		var names []string
-		for ok := true; ok; n, ok = cnames[n] {
+		for n, ok := a.names, true; ok; n, ok = cnames[n] {
 			names = append(names, n)
 		}
-		r.rm[ip] = strings.Join(names, ",")
+		a.names = strings.Join(names, ",")
+		ips[ip] = a
+	}
+	return ips
+}
+
+// add reads the DNS answers and adds them to the mapping.
+func (r *reverseDNSMap) add(dns *layers.DNS) {
+	now := time.Now()
+	for ip, a := range answers(dns, now) {
+		r.put(ip, a.entry(now))
+	}
+}
+
+// addName maps the addresses to a name from the source.
+func (r *reverseDNSMap) addName(name string, source NameSource, ips []net.IP) {
+	now := time.Now()
+	for _, ip := range ips {
+		r.set(layers.NewIPEndpoint(ip), name, source, now)
 	}
-	r.mu.Unlock()
 }
 
 // len returns the number of addresses in the map.
 func (r *reverseDNSMap) len() int {
-	return len(r.rm)
+	n := 0
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.RLock()
+		n += len(s.rm)
+		s.RUnlock()
+	}
+	return n
 }
 
 func (r *reverseDNSMap) String() string {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	return fmt.Sprintf("%v", r.rm)
+	all := make(map[gopacket.Endpoint][]string)
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.RLock()
+		for e, set := range s.rm {
+			for _, n := range set {
+				all[e] = append(all[e], n.name)
+			}
+		}
+		s.RUnlock()
+	}
+	return fmt.Sprintf("%v", all)
 }
 
 // multiReverseDNS is a concurrent-safe reverse DNS mapping per host,
 // so that knoweldge obtained about the DNS queries by host A doesn't
 // interfere with knowledge obtained about host B.
 type multiReverseDNS struct {
+	// swept is when the maps were last swept of expired names, in Unix
+	// nanoseconds. It is accessed atomically, and first, to be aligned
+	// for it on 32-bit platforms.
+	swept int64
+
 	maps map[gopacket.Endpoint]*reverseDNSMap
 	mu   sync.RWMutex
+
+	// everyone has the names learned by all the hosts, for addresses a host
+	// hasn't looked up itself (e.g. over DNS-over-HTTPS, or before caplog
+	// started).
+	everyone *reverseDNSMap
 }
 
-// TODO: implement load/save.
+func init() {
+	RegisterEnricher("revdns", func(c *Capture) Enricher { return EnricherFunc(c.reverseDNS) })
+}
+
+// reverseDNS learns names from the packet: the server name of a TLS
+// ClientHello, host names and leases from DHCP, and the names in an mDNS
+// announcement. It then names the hosts with the most confident of the names
+// known for them, including those from DNS answers seen earlier by the local
+// host, the server name of the packet's flow, and the name the host was
+// given for the server before (see affinity.go). Last, it learns from any
+// DNS answers in the packet, or in the DNS over TCP connection it is part of.
+func (c *Capture) reverseDNS(p *Packet) {
+	m := p.Meta
+	if m.SrcIP == nil {
+		return
+	}
+	switch {
+	case p.Has(layers.LayerTypeTCP) && m.DstPort == 443 && enabled(NameSNI):
+		if n := tlsServerName(p.TCP.Payload); n != "" {
+			c.revDNS.addName(m.SrcIP, n, NameSNI, []net.IP{m.DstIP})
+			c.sniFlows.add(m, n)
+		}
+	case p.Has(layers.LayerTypeUDP) && (m.DstPort == 67 || m.DstPort == 68):
+		learnDHCP(p.UDP.Payload, c.Interface, m.Timestamp)
+	}
+	src, dst := c.revDNS.names(local(m.SrcIP, m.DstIP), p.NetworkFlow())
+	m.SrcName, m.SrcNameSource = bestName(m.SrcIP, src)
+	m.DstName, m.DstNameSource = bestName(m.DstIP, dst)
+	lookUpUnnamed(m)
+	if (p.Has(layers.LayerTypeTCP) || m.AppProtocol == AppQUIC) && enabled(NameSNI) {
+		c.nameSNIFlow(m)
+	}
+	c.affinities.apply(m)
+	used(m.SrcNameSource)
+	used(m.DstNameSource)
+	learnWindowsNames(p)
+	for _, dns := range c.dnsMessages(p) {
+		if m.SrcPort == mdnsPort {
+			if IsLocal(m.SrcIP) && enabled(NameMDNS) {
+				learnMDNS(dns, m.Timestamp)
+			}
+			continue
+		}
+		if trustedResponse(m, dns) {
+			// The "src" is the host who did the query, but answers are replies, so "src" = dst.
+			c.revDNS.add(m.DstIP, dns)
+		}
+	}
+}
+
+// LearnName records that the local host looked up the name and was given
+// the addresses, as if the DNS answer had been captured. It is for names
+// learned elsewhere, such as from a DNS server's query log.
+func (c *Capture) LearnName(host net.IP, name string, ips []net.IP) {
+	c.reverseDNSMap().addName(host, name, NameDNS, ips)
+}
 
 func newMultiReverseDNSMap() *multiReverseDNS {
 	return &multiReverseDNS{
-		maps: make(map[gopacket.Endpoint]*reverseDNSMap),
+		maps:     make(map[gopacket.Endpoint]*reverseDNSMap),
+		everyone: newReverseDNSMap(),
 	}
 }
 
@@ -118,27 +364,106 @@ func (m *multiReverseDNS) hostMap(src gopacket.Endpoint) (rm *reverseDNSMap) {
 	if rm != nil {
 		return
 	}
-	rm = newReverseDNSMap()
 	m.mu.Lock()
-	m.maps[src] = rm
-	m.mu.Unlock()
+	defer m.mu.Unlock()
+	// Another processor may have made it since.
+	if rm = m.maps[src]; rm == nil {
+		rm = newReverseDNSMap()
+		m.maps[src] = rm
+	}
 	return
 }
 
 func (m *multiReverseDNS) add(src net.IP, dns *layers.DNS) {
-	m.hostMap(layers.NewIPEndpoint(src)).add(dns)
+	now := time.Now()
+	names := answers(dns, now) // learning any PTR answers
+	if !enabled(NameDNS) {
+		return
+	}
+	rm := m.hostMap(layers.NewIPEndpoint(src))
+	learned(NameDNS, len(names))
+	for ip, a := range names {
+		rm.put(ip, a.entry(now))
+		m.everyone.put(ip, a.entry(now))
+	}
+	m.maybeSweep(now)
+}
+
+func (m *multiReverseDNS) addName(src net.IP, name string, source NameSource, ips []net.IP) {
+	if !enabled(source) {
+		return
+	}
+	learned(source, len(ips))
+	m.hostMap(layers.NewIPEndpoint(src)).addName(name, source, ips)
+	m.everyone.addName(name, source, ips)
+	m.maybeSweep(time.Now())
 }
 
-func (m *multiReverseDNS) names(src net.IP, flow gopacket.Flow) (string, string) {
+// names returns the names for both endpoints of the flow: the src host's
+// own most recent, or else the most popular among all hosts.
+func (m *multiReverseDNS) names(src net.IP, flow gopacket.Flow) (nameEntry, nameEntry) {
 	rm := m.hostMap(layers.NewIPEndpoint(src))
-	return rm.names(flow)
+	s, d := flow.Endpoints()
+	return m.name(rm, s), m.name(rm, d)
 }
 
-// len returns the number of addresses in the map.
+func (m *multiReverseDNS) name(rm *reverseDNSMap, e gopacket.Endpoint) nameEntry {
+	own, _ := rm.lookup(e)
+	if !enabled(NameShared) || rank(own.source) >= rank(NameShared) {
+		return own
+	}
+	shared, ok := m.everyone.popular(e)
+	if !ok {
+		return own
+	}
+	shared.source = NameShared
+	return shared
+}
+
+// lookupAll returns each host's names for the endpoint, by host.
+func (m *multiReverseDNS) lookupAll(e gopacket.Endpoint) map[gopacket.Endpoint][]nameEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	found := make(map[gopacket.Endpoint][]nameEntry)
+	for host, rm := range m.maps {
+		if set := rm.all(e); len(set) > 0 {
+			found[host] = set
+		}
+	}
+	return found
+}
+
+// len returns the number of hosts in the map.
 func (m *multiReverseDNS) len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return len(m.maps)
 }
 
+// entries returns the total number of addresses in all the hosts' maps.
+func (m *multiReverseDNS) entries() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n := 0
+	for _, rm := range m.maps {
+		n += rm.len()
+	}
+	return n
+}
+
+// hostSizes returns the number of addresses in each host's map, as a JSON
+// object keyed by the host's address.
+func (m *multiReverseDNS) hostSizes() string {
+	m.mu.RLock()
+	sizes := make(map[string]int, len(m.maps))
+	for host, rm := range m.maps {
+		sizes[host.String()] = rm.len()
+	}
+	m.mu.RUnlock()
+	b, _ := json.Marshal(sizes)
+	return string(b)
+}
+
 func (m *multiReverseDNS) String() string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -16,7 +16,9 @@ package packets
 
 import (
 	"net"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/gopacket/layers"
 )
@@ -91,5 +93,94 @@ func TestReverseDNSMapCNAMEChain(t *testing.T) {
 }
 
 func TestMultiReverseDNSMap(t *testing.T) {
-	// TODO(josh): write tests
+	m := newMultiReverseDNSMap()
+	a, b := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	ip := net.ParseIP("93.184.216.34")
+	flow := (&layers.IPv4{SrcIP: a, DstIP: ip}).NetworkFlow()
+
+	// Every processor learns names for host a at once; none may be lost.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.addName(a, "example.com", NameDNS, []net.IP{net.IPv4(192, 0, 2, byte(i))})
+		}(i)
+	}
+	wg.Wait()
+	m.addName(a, "example.com", NameDNS, []net.IP{ip})
+
+	if got, want := m.entries(), 9; got != want {
+		t.Errorf("entries: got %d, want %d", got, want)
+	}
+	if _, dst := m.names(a, flow); dst.name != "example.com" {
+		t.Errorf("host a's name for %v: got %q, want %q", ip, dst.name, "example.com")
+	}
+	// Host b didn't look it up, but another host did.
+	if _, dst := m.names(b, flow); dst.name != "example.com" {
+		t.Errorf("host b's name for %v: got %q, want %q", ip, dst.name, "example.com")
+	}
+	if _, dst := m.names(b, (&layers.IPv4{SrcIP: b, DstIP: net.ParseIP("203.0.113.1")}).NetworkFlow()); dst.source != NameNone {
+		t.Errorf("host b's name for an unknown address: got %+v, want none", dst)
+	}
+	if got, want := m.len(), 2; got != want {
+		t.Errorf("len: got %d, want %d", got, want)
+	}
+	if got, want := m.hostSizes(), `{"10.0.0.1":9,"10.0.0.2":0}`; got != want {
+		t.Errorf("hostSizes: got %s, want %s", got, want)
+	}
+}
+
+func TestReverseDNSMapManyNames(t *testing.T) {
+	// A CDN address serves many names.
+	r := newReverseDNSMap()
+	e := layers.NewIPEndpoint(net.ParseIP("151.101.1.1"))
+	t0 := time.Date(2015, 6, 11, 20, 0, 0, 0, time.UTC)
+	for i, n := range []string{"a.example", "b.example", "a.example", "a.example", "c.example", "d.example", "b.example"} {
+		r.set(e, n, NameDNS, t0.Add(time.Duration(i)*time.Second))
+	}
+	if n, _ := r.lookup(e); n.name != "b.example" {
+		t.Errorf("lookup: got %q, want the most recent, %q", n.name, "b.example")
+	}
+	if n, _ := r.popular(e); n.name != "a.example" || n.hits != 3 {
+		t.Errorf("popular: got %q (%d hits), want %q (3 hits)", n.name, n.hits, "a.example")
+	}
+	r.set(e, "e.example", NameDNS, t0.Add(time.Minute))
+	set := r.all(e)
+	if len(set) != maxNamesPerAddr {
+		t.Fatalf("all: got %d names, want %d", len(set), maxNamesPerAddr)
+	}
+	for _, n := range set {
+		if n.name == "c.example" {
+			t.Errorf("all: got %+v, want c.example (given once, least recently) forgotten", set)
+		}
+	}
+}
+
+func BenchmarkReverseDNSMapParallel(b *testing.B) {
+	r := newReverseDNSMap()
+	ips := make([]net.IP, 1024)
+	for i := range ips {
+		ips[i] = net.IPv4(198, 51, byte(i>>8), byte(i))
+		r.addName("example.com", NameDNS, ips[i:i+1])
+	}
+	d := &layers.DNS{
+		Answers: []layers.DNSResourceRecord{
+			{Name: []byte("example.org"), Type: layers.DNSTypeA, Class: layers.DNSClassIN},
+		},
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		d := *d
+		d.Answers = append([]layers.DNSResourceRecord(nil), d.Answers...)
+		for i := 0; pb.Next(); i++ {
+			// Mostly lookups, with an answer every 16 packets.
+			ip := ips[i%len(ips)]
+			if i%16 == 0 {
+				d.Answers[0].IP = ip
+				r.add(&d)
+				continue
+			}
+			r.name(layers.NewIPEndpoint(ip))
+		}
+	})
 }
@@ -0,0 +1,141 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file estimates the round trip times of TCP flows, as seen from the
+// capture point: from the handshake (the SYN to the SYN/ACK is the round
+// trip to the server, and the SYN/ACK to the ACK the round trip to the
+// client), and then from TCP timestamps, which each end echoes back to the
+// other. The time from a timestamp passing to its echo passing is the
+// round trip to the end that echoed it. The two ends' round trips add up to
+// the whole.
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// FlowRTT is what a flow's record has of its round trip times. Each is 0 if
+// it wasn't seen.
+type FlowRTT struct {
+	// HandshakeRTT is the whole round trip, from the SYN to the ACK of the
+	// SYN/ACK.
+	HandshakeRTT time.Duration `json:",omitempty"`
+
+	// SrcRTT and DstRTT are the round trips between the capture point and
+	// each end, smoothed as TCP does, and MinSrcRTT and MinDstRTT the
+	// least seen.
+	SrcRTT, DstRTT       time.Duration `json:",omitempty"`
+	MinSrcRTT, MinDstRTT time.Duration `json:",omitempty"`
+}
+
+// rttSide follows the round trips to one end of a TCP flow.
+type rttSide struct {
+	srtt, min time.Duration
+
+	// The timestamp the end sent last, waiting for its echo, and when.
+	tsVal     uint32
+	tsAt      time.Time
+	tsWaiting bool
+}
+
+// sample adds a round trip to the end.
+func (s *rttSide) sample(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	if s.srtt == 0 {
+		s.srtt = d
+	} else {
+		s.srtt += (d - s.srtt) / 8
+	}
+	if s.min == 0 || d < s.min {
+		s.min = d
+	}
+}
+
+// flowRTT follows the round trips of a TCP flow.
+type flowRTT struct {
+	src, dst rttSide
+
+	synAt, synAckAt time.Time
+	synAckSeq       uint32
+	handshake       time.Duration
+}
+
+// tcpTimestamps returns the segment's TSval and TSecr, if it has them.
+func tcpTimestamps(tcp *layers.TCP) (val, ecr uint32, ok bool) {
+	for _, o := range tcp.Options {
+		if o.OptionType == layers.TCPOptionKindTimestamps && len(o.OptionData) >= 8 {
+			return binary.BigEndian.Uint32(o.OptionData), binary.BigEndian.Uint32(o.OptionData[4:]), true
+		}
+	}
+	return 0, 0, false
+}
+
+// segment follows a segment from Src if fromSrc, or else from Dst.
+func (r *flowRTT) segment(p *Packet, fromSrc bool) {
+	tcp, at := p.TCP, p.Meta.Timestamp
+	from, to := &r.src, &r.dst
+	if !fromSrc {
+		from, to = to, from
+	}
+	switch {
+	case tcp.SYN && !tcp.ACK && fromSrc:
+		if r.synAt.IsZero() {
+			r.synAt = at
+		}
+	case tcp.SYN && tcp.ACK && !fromSrc:
+		// A SYN/ACK sent again is timed from the first.
+		if r.synAckAt.IsZero() && !r.synAt.IsZero() {
+			r.synAckAt, r.synAckSeq = at, tcp.Seq
+			r.dst.sample(at.Sub(r.synAt))
+		}
+	case tcp.ACK && fromSrc && r.handshake == 0 && !r.synAckAt.IsZero() && tcp.Ack == r.synAckSeq+1:
+		r.src.sample(at.Sub(r.synAckAt))
+		r.handshake = at.Sub(r.synAt)
+	}
+
+	val, ecr, ok := tcpTimestamps(tcp)
+	if !ok {
+		return
+	}
+	// The echo of the other end's timestamp times the round trip to this
+	// end. (A SYN's TSecr is meaningless.)
+	switch {
+	case !to.tsWaiting || !tcp.ACK:
+	case ecr == to.tsVal:
+		from.sample(at.Sub(to.tsAt))
+		to.tsWaiting = false
+	case seqLess(to.tsVal, ecr):
+		to.tsWaiting = false // its echo was missed
+	}
+	if !from.tsWaiting && val != from.tsVal {
+		from.tsVal, from.tsAt, from.tsWaiting = val, at, true
+	}
+}
+
+// record returns the round trips for the flow's record.
+func (r *flowRTT) record() FlowRTT {
+	return FlowRTT{
+		HandshakeRTT: r.handshake,
+		SrcRTT:       r.src.srtt,
+		DstRTT:       r.dst.srtt,
+		MinSrcRTT:    r.src.min,
+		MinDstRTT:    r.dst.min,
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestFlowRTT(t *testing.T) {
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	const ms = time.Millisecond
+
+	// seg is a segment from the client unless fromServer, with the flags
+	// of "SA" and the timestamps val and ecr.
+	type seg struct {
+		at         time.Duration
+		fromServer bool
+		flags      string
+		seq, ack   uint32
+		val, ecr   uint32
+	}
+	var r flowRTT
+	for _, s := range []seg{
+		// The client is 2ms away, and the server 30ms.
+		{at: 0, flags: "S", seq: 100, val: 1000},
+		{at: 30 * ms, fromServer: true, flags: "SA", seq: 500, ack: 101, val: 7000, ecr: 1000},
+		{at: 32 * ms, flags: "A", seq: 101, ack: 501, val: 1032, ecr: 7000},
+		{at: 33 * ms, flags: "A", seq: 101, ack: 501, val: 1033, ecr: 7000},
+		// The server echoes 1032, 30ms after it passed.
+		{at: 62 * ms, fromServer: true, flags: "A", seq: 501, ack: 101, val: 7032, ecr: 1032},
+		// The client echoes 7032, 4ms after it passed.
+		{at: 66 * ms, flags: "A", seq: 101, ack: 501, val: 1066, ecr: 7032},
+		// The server echoes a later timestamp than the one timed (1066):
+		// no sample.
+		{at: 100 * ms, fromServer: true, flags: "A", seq: 501, ack: 101, val: 7100, ecr: 1070},
+	} {
+		tcp := &layers.TCP{Seq: s.seq, Ack: s.ack}
+		for _, f := range s.flags {
+			switch f {
+			case 'S':
+				tcp.SYN = true
+			case 'A':
+				tcp.ACK = true
+			}
+		}
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint32(data, s.val)
+		binary.BigEndian.PutUint32(data[4:], s.ecr)
+		tcp.Options = []layers.TCPOption{{OptionType: layers.TCPOptionKindTimestamps, OptionLength: 10, OptionData: data}}
+		r.segment(&Packet{Meta: &Metadata{Timestamp: start.Add(s.at)}, TCP: tcp}, !s.fromServer)
+	}
+	got := r.record()
+	want := FlowRTT{
+		HandshakeRTT: 32 * ms,
+		SrcRTT:       2*ms + (4*ms-2*ms)/8,
+		DstRTT:       30 * ms,
+		MinSrcRTT:    2 * ms,
+		MinDstRTT:    30 * ms,
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
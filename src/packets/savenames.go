@@ -0,0 +1,140 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file saves the names learned for addresses, and loads them again, so
+// that traffic is named from the start after a restart, rather than only
+// once the addresses are looked up again.
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// maxSavedNameAge is the age beyond which loaded names are skipped, as the
+// addresses may well have moved on.
+const maxSavedNameAge = 24 * time.Hour
+
+// savedName is a name in a saved map, one JSON object per line.
+type savedName struct {
+	Host     net.IP `json:",omitempty"` // empty for host-independent names
+	IP       net.IP
+	Name     string
+	Source   NameSource
+	Hits     uint32 `json:",omitempty"`
+	LastSeen time.Time
+	Expires  time.Time `json:",omitempty"` // when a host's name expires
+}
+
+// each calls f with every name in the map.
+func (r *reverseDNSMap) each(f func(gopacket.Endpoint, nameEntry)) {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.RLock()
+		for e, set := range s.rm {
+			for _, n := range set {
+				f(e, n)
+			}
+		}
+		s.RUnlock()
+	}
+}
+
+// SaveNames writes the names learned by every capture, and the PTR, DHCP and
+// looked-up names, for LoadNames. Manual labels and controller names aren't
+// saved, as they come from elsewhere anyway.
+func SaveNames(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	var err error
+	save := func(s savedName) {
+		if err == nil {
+			err = enc.Encode(&s)
+		}
+	}
+	revDNSMaps.Lock()
+	maps := revDNSMaps.list
+	revDNSMaps.Unlock()
+	for _, m := range maps {
+		m.mu.RLock()
+		hosts := make(map[gopacket.Endpoint]*reverseDNSMap, len(m.maps))
+		for host, rm := range m.maps {
+			hosts[host] = rm
+		}
+		m.mu.RUnlock()
+		for host, rm := range hosts {
+			rm.each(func(e gopacket.Endpoint, n nameEntry) {
+				save(savedName{Host: net.IP(host.Raw()), IP: net.IP(e.Raw()), Name: n.name, Source: n.source, Hits: n.hits, LastSeen: n.seen, Expires: n.expires})
+			})
+		}
+	}
+	globalNames.mu.RLock()
+	defer globalNames.mu.RUnlock()
+	for k, n := range globalNames.m {
+		if n.source == NamePTR || n.source == NameDHCP || n.source == NameLookup {
+			ip := net.IP(append([]byte(nil), k[:]...))
+			save(savedName{IP: ip, Name: n.name, Source: n.source, LastSeen: n.seen})
+		}
+	}
+	return err
+}
+
+// LoadNames learns the names saved by SaveNames, as if they had been seen
+// when they last were, and returns how many it learned. Names last seen over
+// a day ago are skipped. The rest are kept for at least the minimum TTL from
+// now, to give the hosts time to look them up again. A record cut short ends
+// the names.
+func (c *Capture) LoadNames(r io.Reader) (int, error) {
+	m := c.reverseDNSMap()
+	now := time.Now()
+	cutoff := now.Add(-maxSavedNameAge)
+	grace := nameExpiry(now, 0)
+	dec := json.NewDecoder(r)
+	n := 0
+	for dec.More() {
+		var s savedName
+		if err := dec.Decode(&s); err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return n, err
+		}
+		if s.IP == nil || s.Name == "" || s.LastSeen.Before(cutoff) {
+			continue
+		}
+		switch {
+		case s.Host != nil && (s.Source == NamePTR || s.Source == NameSNI || s.Source == NameDNS):
+			if s.Hits == 0 {
+				s.Hits = 1
+			}
+			e := layers.NewIPEndpoint(s.IP)
+			entry := nameEntry{name: s.Name, source: s.Source, seen: s.LastSeen, hits: s.Hits, expires: s.Expires}
+			if entry.expires.Before(grace) {
+				entry.expires = grace
+			}
+			m.hostMap(layers.NewIPEndpoint(s.Host)).put(e, entry)
+			m.everyone.put(e, entry)
+		case s.Host == nil && (s.Source == NamePTR || s.Source == NameDHCP || s.Source == NameLookup):
+			globalNames.set(s.IP, s.Name, s.Source, s.LastSeen)
+		default:
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestSaveLoadNames(t *testing.T) {
+	revDNSMaps.Lock()
+	saved := revDNSMaps.list
+	revDNSMaps.list = nil
+	revDNSMaps.Unlock()
+	defer func() {
+		revDNSMaps.Lock()
+		revDNSMaps.list = saved
+		revDNSMaps.Unlock()
+		ForgetNames(NamePTR)
+	}()
+
+	host := net.ParseIP("10.0.0.2")
+	cdn := net.ParseIP("192.0.2.1")
+	old := net.ParseIP("192.0.2.2")
+	ptr := net.ParseIP("192.0.2.3")
+	c := &Capture{}
+	m := c.reverseDNSMap()
+	m.addName(host, "example.com", NameDNS, []net.IP{cdn})
+	m.addName(host, "example.com", NameDNS, []net.IP{cdn})
+	m.addName(host, "example.net", NameSNI, []net.IP{cdn})
+	m.hostMap(layers.NewIPEndpoint(host)).put(layers.NewIPEndpoint(old), nameEntry{name: "stale.example", source: NameDNS, seen: time.Now().Add(-48 * time.Hour), hits: 1})
+	globalNames.set(ptr, "ptr.example", NamePTR, time.Now())
+
+	var b bytes.Buffer
+	if err := SaveNames(&b); err != nil {
+		t.Fatalf("SaveNames: %v", err)
+	}
+	ForgetNames(NamePTR)
+
+	// Cut the last record short, as a crash might.
+	lines := strings.SplitAfter(b.String(), "\n")
+	in := b.String() + lines[0][:len(lines[0])/2]
+
+	c2 := &Capture{}
+	n, err := c2.LoadNames(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("LoadNames: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("LoadNames: got %d names, want 3", n)
+	}
+	m2 := c2.reverseDNSMap()
+	rm := m2.hostMap(layers.NewIPEndpoint(host))
+	tests := []struct {
+		ip   net.IP
+		want string
+		hits uint32
+	}{
+		{cdn, "example.net", 1}, // SNI beats DNS
+		{old, "", 0},
+	}
+	for _, test := range tests {
+		got, _ := rm.lookup(layers.NewIPEndpoint(test.ip))
+		if got.name != test.want || got.hits != test.hits {
+			t.Errorf("lookup(%v): got %q (%d hits), want %q (%d hits)", test.ip, got.name, got.hits, test.want, test.hits)
+		}
+	}
+	all := rm.all(layers.NewIPEndpoint(cdn))
+	if len(all) != 2 {
+		t.Errorf("names for %v: got %d, want 2", cdn, len(all))
+	}
+	for _, e := range all {
+		if e.name == "example.com" && e.hits != 2 {
+			t.Errorf("example.com: got %d hits, want 2", e.hits)
+		}
+	}
+	// Another host falls back to the names everyone was given.
+	if got, _ := m2.everyone.popular(layers.NewIPEndpoint(cdn)); got.name != "example.net" {
+		t.Errorf("everyone.popular(%v): got %q, want example.net", cdn, got.name)
+	}
+	if got, _ := globalNames.get(ptr); got.name != "ptr.example" {
+		t.Errorf("globalNames.get(%v): got %q, want ptr.example", ptr, got.name)
+	}
+
+	if _, err := c2.LoadNames(strings.NewReader("nonsense")); err == nil {
+		t.Error("LoadNames(nonsense): got no error, want one")
+	}
+}
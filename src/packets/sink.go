@@ -0,0 +1,43 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import "context"
+
+// Sink receives buffers of packet metadata from a Capture, e.g. to store
+// them or send them elsewhere.
+type Sink interface {
+	// Write takes a buffer of metadata. The buffer is reused once Write
+	// returns, so Write must copy anything it keeps.
+	Write(ctx context.Context, data []Metadata) error
+
+	// Flush waits until everything written so far has been handled.
+	Flush(ctx context.Context) error
+
+	// Close flushes the sink and releases its resources.
+	Close() error
+}
+
+// SinkFunc is a func that is a Sink, with nothing to flush or close.
+type SinkFunc func(ctx context.Context, data []Metadata) error
+
+// Write calls f(ctx, data).
+func (f SinkFunc) Write(ctx context.Context, data []Metadata) error { return f(ctx, data) }
+
+// Flush does nothing.
+func (SinkFunc) Flush(context.Context) error { return nil }
+
+// Close does nothing.
+func (SinkFunc) Close() error { return nil }
@@ -0,0 +1,149 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file decides what a packet's Size counts. pcap's length is the
+// Ethernet frame without its FCS, which is neither what an IP-level counter
+// (a router's interface statistics, say) nor what an ISP's line counts: the
+// first leaves out the Ethernet header, and the second adds the FCS, the
+// preamble, the gap between frames and whatever encapsulation the line has.
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// SizeMode is what a packet's Size counts.
+type SizeMode string
+
+const (
+	// SizeFrame counts the Ethernet frame as captured, headers included
+	// but not the FCS. It is the default.
+	SizeFrame SizeMode = "frame"
+
+	// SizeIP counts the IP packet, from the IP header on, as IP-level
+	// counters do.
+	SizeIP SizeMode = "ip"
+
+	// SizeWire counts the frame padded to Ethernet's minimum, plus a fixed
+	// overhead per packet: by default DefaultWireOverhead, for the FCS,
+	// preamble and gap between frames, to which a line's encapsulation
+	// (PPPoE, say) can be added to compare with an ISP's counters.
+	SizeWire SizeMode = "wire"
+)
+
+const (
+	// DefaultWireOverhead is the bytes SizeWire adds to each frame: a 4
+	// byte FCS, 8 bytes of preamble and start delimiter, and a 12 byte gap.
+	DefaultWireOverhead = 4 + 8 + 12
+
+	// minFrame is the least an Ethernet frame is padded to, without FCS.
+	minFrame = 60
+)
+
+// sizing is the mode and overhead set by SetSizeAccounting.
+type sizing struct {
+	mode     SizeMode
+	overhead uint64
+}
+
+var sizeAccounting atomic.Value // sizing
+
+func init() {
+	sizeAccounting.Store(sizing{mode: SizeFrame, overhead: DefaultWireOverhead})
+}
+
+// SetSizeAccounting sets what packets' sizes count from now on. The
+// overhead is the bytes SizeWire adds to each frame, and is ignored by
+// the other modes. An empty mode is SizeFrame.
+func SetSizeAccounting(mode SizeMode, overhead int) error {
+	switch mode {
+	case "":
+		mode = SizeFrame
+	case SizeFrame, SizeIP, SizeWire:
+	default:
+		return fmt.Errorf("size accounting must be %q, %q or %q, not %q", SizeFrame, SizeIP, SizeWire, mode)
+	}
+	if overhead < 0 {
+		return fmt.Errorf("wire overhead must not be negative, not %d", overhead)
+	}
+	sizeAccounting.Store(sizing{mode: mode, overhead: uint64(overhead)})
+	return nil
+}
+
+// SizeAccounting returns the current mode.
+func SizeAccounting() SizeMode {
+	return sizeAccounting.Load().(sizing).mode
+}
+
+// packetSize returns the size of a packet of the frame length, with the
+// decoded layers, in the current mode.
+func packetSize(length int, decoded []gopacket.LayerType, ip4 *layers.IPv4, ip6 *layers.IPv6) uint64 {
+	s := sizeAccounting.Load().(sizing)
+	switch s.mode {
+	case SizeIP:
+		return ipSize(length, decoded, ip4, ip6)
+	case SizeWire:
+		if length < minFrame {
+			length = minFrame
+		}
+		return uint64(length) + s.overhead
+	}
+	return uint64(length)
+}
+
+// ipSize returns the length of the IP packet in a frame, from the IP
+// header if it has one, or else the frame without its Ethernet headers.
+func ipSize(length int, decoded []gopacket.LayerType, ip4 *layers.IPv4, ip6 *layers.IPv6) uint64 {
+	switch {
+	case hasLayer(decoded, layers.LayerTypeIPv4) && ip4.Length > 0:
+		return uint64(ip4.Length)
+	case hasLayer(decoded, layers.LayerTypeIPv6) && ip6.Length > 0:
+		return uint64(ip6.Length) + 40
+	}
+	// The IP length is 0 in segments offloaded to the NIC, and there may be
+	// no IP header at all: take off the link layer instead.
+	header := 0
+	for _, t := range decoded {
+		switch t {
+		case layers.LayerTypeEthernet:
+			header += 14
+		case layers.LayerTypeDot1Q:
+			header += 4
+		}
+	}
+	if length < header {
+		return 0
+	}
+	return uint64(length - header)
+}
+
+// repeatedSize returns what each packet after the first adds to the size
+// of a superframe, in the current mode: the headers, of the link layer and
+// of those above, that the superframe only has once, and the overhead of
+// another frame on the wire.
+func repeatedSize(link, header int) uint64 {
+	s := sizeAccounting.Load().(sizing)
+	switch s.mode {
+	case SizeIP:
+		return uint64(header)
+	case SizeWire:
+		return uint64(link+header) + s.overhead
+	}
+	return uint64(link + header)
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestPacketSize(t *testing.T) {
+	defer SetSizeAccounting(SizeFrame, DefaultWireOverhead)
+	var (
+		eth4    = []gopacket.LayerType{layers.LayerTypeEthernet, layers.LayerTypeIPv4, layers.LayerTypeTCP}
+		tagged6 = []gopacket.LayerType{layers.LayerTypeEthernet, layers.LayerTypeDot1Q, layers.LayerTypeIPv6, layers.LayerTypeUDP}
+	)
+	for _, test := range []struct {
+		mode     SizeMode
+		overhead int
+		length   int
+		decoded  []gopacket.LayerType
+		ip4, ip6 uint16 // IP length fields
+		want     uint64
+	}{
+		{mode: "", length: 1514, decoded: eth4, ip4: 1500, want: 1514},
+		{mode: SizeFrame, length: 1514, decoded: eth4, ip4: 1500, want: 1514},
+		{mode: SizeIP, length: 1514, decoded: eth4, ip4: 1500, want: 1500},
+		{mode: SizeIP, length: 1518, decoded: tagged6, ip6: 1460, want: 1500},
+		// Offloaded segments have no IP length; nor do cut-short packets
+		// that stopped decoding.
+		{mode: SizeIP, length: 9014, decoded: eth4, want: 9000},
+		{mode: SizeIP, length: 1518, decoded: tagged6[:2], want: 1500},
+		{mode: SizeWire, overhead: DefaultWireOverhead, length: 1514, decoded: eth4, ip4: 1500, want: 1538},
+		{mode: SizeWire, overhead: DefaultWireOverhead, length: 54, decoded: eth4, ip4: 40, want: 84},
+		{mode: SizeWire, overhead: DefaultWireOverhead + 8, length: 1514, decoded: eth4, ip4: 1500, want: 1546},
+	} {
+		if err := SetSizeAccounting(test.mode, test.overhead); err != nil {
+			t.Fatalf("SetSizeAccounting(%q, %d): %v", test.mode, test.overhead, err)
+		}
+		ip4, ip6 := &layers.IPv4{Length: test.ip4}, &layers.IPv6{Length: test.ip6}
+		if got := packetSize(test.length, test.decoded, ip4, ip6); got != test.want {
+			t.Errorf("%q, overhead %d: packetSize(%d, %v): got %d, want %d", test.mode, test.overhead, test.length, test.decoded, got, test.want)
+		}
+	}
+	for _, mode := range []SizeMode{"bytes", "IP"} {
+		if err := SetSizeAccounting(mode, 0); err == nil {
+			t.Errorf("SetSizeAccounting(%q): got no error", mode)
+		}
+	}
+	if err := SetSizeAccounting(SizeWire, -1); err == nil {
+		t.Error("SetSizeAccounting with a negative overhead: got no error")
+	}
+	if got := SizeAccounting(); got != SizeWire {
+		t.Errorf("SizeAccounting after errors: got %q, want %q", got, SizeWire)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file names HTTPS flows, over TCP or QUIC, by the server name in their
+// TLS ClientHello.
+// An address (especially a CDN's) serves many names, so the name the client
+// asked for on the flow is better than any name known for the address.
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxSNIFlows bounds the table of flows' server names.
+	maxSNIFlows = 65536
+
+	// sniFlowIdle is how long a flow can be quiet before its name is
+	// forgotten.
+	sniFlowIdle = 5 * time.Minute
+)
+
+// sniFlowKey is a TCP or UDP flow, from the client's side.
+type sniFlowKey struct {
+	client, server         [16]byte
+	clientPort, serverPort uint16
+	proto                  uint8
+}
+
+type sniFlow struct {
+	name string
+	seen int64 // UnixNano, accessed atomically
+}
+
+// sniFlowTable maps flows to the server names in their ClientHellos.
+type sniFlowTable struct {
+	mu    sync.RWMutex
+	flows map[sniFlowKey]*sniFlow // made when first needed
+}
+
+// add names the flow from client to server.
+func (t *sniFlowTable) add(m *Metadata, name string) {
+	k := sniFlowKey{ipKey(m.SrcIP), ipKey(m.DstIP), m.SrcPort, m.DstPort, m.Protocol}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.flows == nil {
+		t.flows = make(map[sniFlowKey]*sniFlow)
+	}
+	if _, ok := t.flows[k]; !ok && len(t.flows) >= maxSNIFlows {
+		t.sweep(m.Timestamp)
+		if len(t.flows) >= maxSNIFlows {
+			return
+		}
+	}
+	t.flows[k] = &sniFlow{name: name, seen: m.Timestamp.UnixNano()}
+}
+
+// sweep forgets the flows idle for sniFlowIdle before now (or after it, for
+// replayed captures). t.mu must be held.
+func (t *sniFlowTable) sweep(now time.Time) {
+	for k, f := range t.flows {
+		if d := now.Sub(time.Unix(0, atomic.LoadInt64(&f.seen))); d >= sniFlowIdle || d <= -sniFlowIdle {
+			delete(t.flows, k)
+		}
+	}
+}
+
+// server returns the server's address and name if the packet is on a named
+// flow, in either direction.
+func (t *sniFlowTable) server(m *Metadata) (net.IP, string) {
+	src, dst := ipKey(m.SrcIP), ipKey(m.DstIP)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	server := m.DstIP
+	f := t.flows[sniFlowKey{src, dst, m.SrcPort, m.DstPort, m.Protocol}]
+	if f == nil {
+		server = m.SrcIP
+		f = t.flows[sniFlowKey{dst, src, m.DstPort, m.SrcPort, m.Protocol}]
+	}
+	if f == nil {
+		return nil, ""
+	}
+	if d := m.Timestamp.Sub(time.Unix(0, atomic.LoadInt64(&f.seen))); d >= sniFlowIdle || d <= -sniFlowIdle {
+		return nil, "" // a new flow on the same ports, without a ClientHello
+	}
+	atomic.StoreInt64(&f.seen, m.Timestamp.UnixNano())
+	return server, f.name
+}
+
+// nameSNIFlow names the server end of the packet by its flow's server name,
+// if the flow has one and SNI is as trusted as the name it has, or more.
+func (c *Capture) nameSNIFlow(m *Metadata) {
+	server, name := c.sniFlows.server(m)
+	if server == nil {
+		return
+	}
+	if server.Equal(m.DstIP) {
+		if rank(NameSNI) >= rank(m.DstNameSource) {
+			m.DstName, m.DstNameSource = name, NameSNI
+		}
+	} else if rank(NameSNI) >= rank(m.SrcNameSource) {
+		m.SrcName, m.SrcNameSource = name, NameSNI
+	}
+}
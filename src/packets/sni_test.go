@@ -0,0 +1,75 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestSNIFlows(t *testing.T) {
+	revDNSMaps.Lock()
+	saved := revDNSMaps.list
+	revDNSMaps.Unlock()
+	defer func() {
+		revDNSMaps.Lock()
+		revDNSMaps.list = saved
+		revDNSMaps.Unlock()
+	}()
+
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	client, cdn := net.ParseIP("10.0.0.2"), net.ParseIP("192.0.2.80")
+	pkt := func(at time.Duration, src, dst net.IP, sport, dport uint16, payload []byte) *Packet {
+		return &Packet{
+			Meta:    &Metadata{Timestamp: start.Add(at), SrcIP: src, DstIP: dst, SrcPort: sport, DstPort: dport},
+			Decoded: []gopacket.LayerType{layers.LayerTypeIPv4, layers.LayerTypeTCP},
+			IPv4:    &layers.IPv4{SrcIP: src, DstIP: dst},
+			TCP:     &layers.TCP{BaseLayer: layers.BaseLayer{Payload: payload}},
+		}
+	}
+	c := &Capture{}
+	c.reverseDNSMap()
+
+	// Two flows to the same CDN address, for different names.
+	c.reverseDNS(pkt(0, client, cdn, 50000, 443, clientHello(t, "a.example")))
+	c.reverseDNS(pkt(time.Second, client, cdn, 50001, 443, clientHello(t, "b.example")))
+
+	tests := []struct {
+		p       *Packet
+		want    string
+		wantSrc bool // the name is the source's
+	}{
+		{p: pkt(2*time.Second, client, cdn, 50000, 443, nil), want: "a.example"},
+		{p: pkt(2*time.Second, cdn, client, 443, 50000, nil), want: "a.example", wantSrc: true},
+		{p: pkt(2*time.Second, cdn, client, 443, 50001, nil), want: "b.example", wantSrc: true},
+		// Idle for too long: a new flow on the same ports.
+		{p: pkt(time.Hour, client, cdn, 50000, 443, nil), want: "b.example"},
+	}
+	for _, test := range tests {
+		c.reverseDNS(test.p)
+		m := test.p.Meta
+		name, source := m.DstName, m.DstNameSource
+		if test.wantSrc {
+			name, source = m.SrcName, m.SrcNameSource
+		}
+		if name != test.want || source != NameSNI {
+			t.Errorf("%v:%d -> %v:%d: got %q %v, want %q %v", m.SrcIP, m.SrcPort, m.DstIP, m.DstPort, name, source, test.want, NameSNI)
+		}
+	}
+}
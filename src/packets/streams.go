@@ -14,52 +14,252 @@
 
 package packets
 
-// This file is currently pointless - but the idea was to log metadata about
-// TCP streams.
+// This file reassembles TCP streams, to summarise each one: its bytes of
+// data (without headers, or data sent again) and how long it lasted. A
+// stream is one direction of a TCP connection. Its data is counted and
+// thrown away; only the summaries are kept.
 
 import (
+	"net"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/tcpassembly"
+
+	"vars"
 )
 
+const (
+	// DefaultStreamIdleTimeout is how long a stream can be quiet before
+	// it is summarised, by default.
+	DefaultStreamIdleTimeout = 2 * time.Minute
+
+	// maxPendingStreams bounds the summaries waiting to be exported.
+	maxPendingStreams = 10000
+
+	// Each processor's assembler buffers at most this many pages (of
+	// segments that arrived ahead of a gap) per stream, and in all.
+	streamPagesPerConnection = 16
+	streamPagesTotal         = 4096
+)
+
+// streamStats counts the streams, accessed atomically.
+var streamStats struct {
+	active            int64
+	exported, dropped uint64
+}
+
+func init() {
+	vars.Register("streams-active", vars.Int64Eval(func() int64 { return atomic.LoadInt64(&streamStats.active) }).String)
+	vars.Uint64("streams-exported", &streamStats.exported)
+	vars.Uint64("streams-dropped", &streamStats.dropped)
+}
+
+// Stream is the summary of one direction of a TCP connection, from Src to
+// Dst.
+type Stream struct {
+	Start, End       time.Time
+	SrcName, DstName string
+	SrcIP, DstIP     net.IP
+	SrcPort, DstPort uint16
+
+	// Bytes is the data reassembled, and Skipped the data missing from
+	// the capture (lost before reaching it, or from before it began).
+	Bytes   uint64
+	Skipped uint64 `json:",omitempty"`
+
+	// Complete is set if the stream ended with a FIN or RST, rather than
+	// going quiet or the capture stopping.
+	Complete bool
+}
+
+// StreamTable reassembles the TCP streams of one or more captures, and
+// hands the summaries of those that are done to Export, from Expire (which
+// should be called every second or so) and Close.
+type StreamTable struct {
+	// IdleTimeout, if positive, replaces DefaultStreamIdleTimeout.
+	IdleTimeout time.Duration
+
+	// Export receives the summaries of streams that are done, from one
+	// call of Expire or Close at a time.
+	Export func([]Stream)
+
+	poolOnce sync.Once
+	pool     *tcpassembly.StreamPool
+
+	pendingMu sync.Mutex
+	pending   []Stream // summaries waiting for Expire
+
+	exportMu sync.Mutex
+}
+
+func (t *StreamTable) idleTimeout() time.Duration {
+	if t.IdleTimeout > 0 {
+		return t.IdleTimeout
+	}
+	return DefaultStreamIdleTimeout
+}
+
+// assembler returns a new assembler for one processor's packets. The
+// streams are shared between the assemblers, so a stream's packets can go
+// to any of them.
+func (t *StreamTable) assembler() *streamAssembler {
+	t.poolOnce.Do(func() {
+		t.pool = tcpassembly.NewStreamPool(&streamFactory{t: t})
+	})
+	a := tcpassembly.NewAssembler(t.pool)
+	a.MaxBufferedPagesPerConnection = streamPagesPerConnection
+	a.MaxBufferedPagesTotal = streamPagesTotal
+	return &streamAssembler{a: a, idle: t.idleTimeout()}
+}
+
+// queue adds a summary to those waiting to be exported.
+func (t *StreamTable) queue(s Stream) {
+	t.pendingMu.Lock()
+	defer t.pendingMu.Unlock()
+	if len(t.pending) >= maxPendingStreams {
+		atomic.AddUint64(&streamStats.dropped, 1)
+		return
+	}
+	t.pending = append(t.pending, s)
+}
+
+// Expire exports the summaries of the streams that are done. Streams are
+// found to be idle by each processor, from the times of the packets it
+// gets.
+func (t *StreamTable) Expire(now time.Time) {
+	t.exportMu.Lock()
+	defer t.exportMu.Unlock()
+	t.pendingMu.Lock()
+	recs := t.pending
+	t.pending = nil
+	t.pendingMu.Unlock()
+	if len(recs) == 0 {
+		return
+	}
+	atomic.AddUint64(&streamStats.exported, uint64(len(recs)))
+	if t.Export != nil {
+		t.Export(recs)
+	}
+}
+
+// Close exports the summaries of the streams that are done. It should be
+// called once the captures have stopped, which summarises the rest.
+func (t *StreamTable) Close() error {
+	t.Expire(time.Now())
+	return nil
+}
+
+// streamAssembler is a processor's assembler.
+type streamAssembler struct {
+	a       *tcpassembly.Assembler
+	idle    time.Duration
+	flushed time.Time // the packet time of the last flush
+}
+
+// add reassembles the packet, if it is TCP, and every so often summarises
+// the streams idle by its time.
+func (s *streamAssembler) add(p *Packet) {
+	if !p.Has(layers.LayerTypeTCP) {
+		return
+	}
+	t := p.Meta.Timestamp
+	s.a.AssembleWithTimestamp(p.NetworkFlow(), p.TCP, t)
+	if d := t.Sub(s.flushed); d >= time.Second || d <= -time.Second {
+		s.flushed = t
+		s.a.FlushOlderThan(t.Add(-s.idle))
+	}
+}
+
+// close summarises every stream, of every assembler, as the capture has
+// stopped.
+func (s *streamAssembler) close() {
+	s.a.FlushAll()
+}
+
 type streamFactory struct {
-	revDNS *reverseDNSMap
+	t *StreamTable
 }
 
 func (f *streamFactory) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
+	atomic.AddInt64(&streamStats.active, 1)
+	src, dst := netFlow.Endpoints()
+	sport, dport := tcpFlow.Endpoints()
+	s := &stream{t: f.t}
+	s.SrcIP, s.DstIP = net.IP(src.Raw()), net.IP(dst.Raw())
+	s.SrcPort, s.DstPort = portOf(sport), portOf(dport)
 	// More accurate if reverse DNS mapping happens now.
-	src, dst := f.revDNS.names(netFlow)
-	return &stream{
-		netFlow: netFlow,
-		tcpFlow: tcpFlow,
-		srcName: src,
-		dstName: dst,
+	s.SrcName, s.DstName = streamNames(s.SrcIP, netFlow)
+	return s
+}
+
+// streamNames returns the best names the captures have for the ends of the
+// flow, as seen by its source, or else their addresses.
+func streamNames(src net.IP, netFlow gopacket.Flow) (string, string) {
+	revDNSMaps.Lock()
+	maps := revDNSMaps.list
+	revDNSMaps.Unlock()
+	s, d := netFlow.Endpoints()
+	var best [2]nameEntry
+	for i, e := range []gopacket.Endpoint{s, d} {
+		best[i], _ = globalNames.get(net.IP(e.Raw()))
+	}
+	for _, m := range maps {
+		es, ed := m.names(src, netFlow)
+		for i, e := range []nameEntry{es, ed} {
+			if e.name != "" && (best[i].name == "" || rank(e.source) > rank(best[i].source)) {
+				best[i] = e
+			}
+		}
 	}
+	for i, e := range []gopacket.Endpoint{s, d} {
+		if best[i].name == "" {
+			best[i].name = net.IP(e.Raw()).String()
+		}
+	}
+	return best[0].name, best[1].name
 }
 
-type stream struct {
-	netFlow, tcpFlow gopacket.Flow
-	srcName, dstName string
+// portOf returns the port of a TCP endpoint.
+func portOf(e gopacket.Endpoint) uint16 {
+	b := e.Raw()
+	if len(b) != 2 {
+		return 0
+	}
+	return uint16(b[0])<<8 | uint16(b[1])
+}
 
-	bytes  uint64
-	closed bool
+type stream struct {
+	Stream
+	t *StreamTable
 }
 
 // Reassembled implements tcpassembly.Stream. It throws away the content
 // and only accumulates the length.
 func (s *stream) Reassembled(reassembly []tcpassembly.Reassembly) {
 	for _, ra := range reassembly {
-		atomic.AddUint64(&s.bytes, uint64(len(ra.Bytes)))
+		if s.Start.IsZero() || ra.Seen.Before(s.Start) {
+			s.Start = ra.Seen
+		}
+		if ra.Seen.After(s.End) {
+			s.End = ra.Seen
+		}
+		s.Bytes += uint64(len(ra.Bytes))
 		if ra.Skip > 0 {
-			atomic.AddUint64(&s.bytes, uint64(ra.Skip))
+			s.Skipped += uint64(ra.Skip)
+		}
+		if ra.End {
+			s.Complete = true
 		}
 	}
 }
 
-// ReassemblyComplete implements tcpassembly.Stream. It marks the stream as
-// closed.
+// ReassemblyComplete implements tcpassembly.Stream. It queues the
+// stream's summary.
 func (s *stream) ReassemblyComplete() {
-	s.closed = true
+	atomic.AddInt64(&streamStats.active, -1)
+	s.t.queue(s.Stream)
 }
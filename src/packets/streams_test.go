@@ -0,0 +1,64 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+func TestStreams(t *testing.T) {
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	client, server := net.ParseIP("10.0.0.2").To4(), net.ParseIP("192.0.2.80").To4()
+	SetName(server, "www.example.com", NameManual)
+	defer ForgetNames(NameManual)
+
+	var got []Stream
+	st := &StreamTable{Export: func(recs []Stream) { got = append(got, recs...) }}
+	f := &streamFactory{t: st}
+	s := f.New(
+		gopacket.NewFlow(layers.EndpointIPv4, client, server),
+		gopacket.NewFlow(layers.EndpointTCPPort, []byte{0xc3, 0x50}, []byte{0x01, 0xbb}),
+	)
+	s.Reassembled([]tcpassembly.Reassembly{
+		{Bytes: make([]byte, 100), Start: true, Seen: start},
+		{Bytes: make([]byte, 200), Seen: start.Add(time.Second)},
+	})
+	s.Reassembled([]tcpassembly.Reassembly{
+		{Bytes: make([]byte, 50), Skip: 1000, Seen: start.Add(2 * time.Second)},
+		{End: true, Seen: start.Add(3 * time.Second)},
+	})
+	st.Expire(start)
+	if len(got) != 0 {
+		t.Errorf("exported before the stream was complete: %+v", got)
+	}
+	s.ReassemblyComplete()
+	st.Close()
+	want := []Stream{{
+		Start: start, End: start.Add(3 * time.Second),
+		SrcName: "10.0.0.2", DstName: "www.example.com",
+		SrcIP: client, DstIP: server, SrcPort: 50000, DstPort: 443,
+		Bytes: 350, Skipped: 1000, Complete: true,
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file spots superframes: packets bigger than the link's MTU, which
+// the NIC or kernel coalesced from several (GRO on receive, and TSO or GSO
+// on the capturing host's own sends). Each is captured as one packet, so
+// packet counts, and packets per second, come out low. Optionally, each is
+// counted as the packets it was likely made from, estimated from the MTU.
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"vars"
+)
+
+// DefaultMTU is the largest IP packet expected on the wire, by default.
+const DefaultMTU = 1500
+
+// coalescingWindow is how long after the last superframe Coalescing
+// reports true.
+const coalescingWindow = time.Minute
+
+// coalescing is the settings from SetCoalescing.
+type coalescing struct {
+	mtu      int
+	estimate bool
+}
+
+var coalescingSettings atomic.Value // coalescing
+
+// superframeStats counts the superframes, accessed atomically.
+var superframeStats struct {
+	frames, packets uint64
+	last            int64 // the wall clock at the last, in Unix nanoseconds
+}
+
+func init() {
+	coalescingSettings.Store(coalescing{mtu: DefaultMTU})
+	vars.Uint64("superframes", &superframeStats.frames)
+	vars.Uint64("superframe-packets", &superframeStats.packets)
+	vars.Register("coalescing", func() string {
+		if Coalescing() {
+			return "1"
+		}
+		return "0"
+	})
+}
+
+// SetCoalescing sets the largest IP packet expected on the wire (0 for
+// DefaultMTU), bigger ones being superframes, and whether superframes are
+// counted as the packets they were likely made from. With jumbo frames,
+// the MTU should be theirs.
+func SetCoalescing(mtu int, estimate bool) {
+	if mtu <= 0 {
+		mtu = DefaultMTU
+	}
+	coalescingSettings.Store(coalescing{mtu: mtu, estimate: estimate})
+}
+
+// Coalescing reports whether a superframe was captured in the last minute,
+// in which case packet counts are low unless they are estimated.
+func Coalescing() bool {
+	last := atomic.LoadInt64(&superframeStats.last)
+	return last != 0 && time.Since(time.Unix(0, last)) < coalescingWindow
+}
+
+// Packets returns the number of packets on the wire that m stands for: its
+// Segments, if it is an estimated superframe, or else 1.
+func (m *Metadata) Packets() uint64 {
+	if m.Segments > 1 {
+		return uint64(m.Segments)
+	}
+	return 1
+}
+
+// coalesce checks whether the packet, of the frame length, is a superframe.
+// If so, it is counted, and if estimating, its Segments and Size are set to
+// those of the packets it was likely made from, with their headers.
+func coalesce(p *Packet, length int) {
+	s := coalescingSettings.Load().(coalescing)
+	ip := int(ipSize(length, p.Decoded, p.IPv4, p.IPv6))
+	if ip <= s.mtu {
+		return
+	}
+	// The headers each packet would have had.
+	link := length - ip
+	header := 0
+	switch {
+	case p.Has(layers.LayerTypeIPv4):
+		header = 4 * int(p.IPv4.IHL)
+	case p.Has(layers.LayerTypeIPv6):
+		header = 40
+	}
+	switch {
+	case p.Has(layers.LayerTypeTCP):
+		header += 4 * int(p.TCP.DataOffset)
+	case p.Has(layers.LayerTypeUDP):
+		header += 8
+	}
+	if header >= s.mtu || header > ip {
+		return // not a packet with a body to split
+	}
+	segments := (ip - header + s.mtu - header - 1) / (s.mtu - header)
+	atomic.AddUint64(&superframeStats.frames, 1)
+	atomic.AddUint64(&superframeStats.packets, uint64(segments))
+	atomic.StoreInt64(&superframeStats.last, time.Now().UnixNano())
+	if !s.estimate {
+		return
+	}
+	p.Meta.Segments = uint32(segments)
+	p.Meta.Size += uint64(segments-1) * repeatedSize(link, header)
+}
@@ -0,0 +1,88 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestCoalesce(t *testing.T) {
+	defer SetCoalescing(DefaultMTU, false)
+	defer SetSizeAccounting(SizeFrame, DefaultWireOverhead)
+	var (
+		tcp4 = []gopacket.LayerType{layers.LayerTypeEthernet, layers.LayerTypeIPv4, layers.LayerTypeTCP}
+		udp4 = []gopacket.LayerType{layers.LayerTypeEthernet, layers.LayerTypeIPv4, layers.LayerTypeUDP}
+		tcp6 = []gopacket.LayerType{layers.LayerTypeEthernet, layers.LayerTypeDot1Q, layers.LayerTypeIPv6, layers.LayerTypeTCP}
+	)
+	for _, test := range []struct {
+		desc     string
+		mtu      int
+		estimate bool
+		mode     SizeMode
+		decoded  []gopacket.LayerType
+		length   int // of the frame
+		segments uint32
+		size     uint64
+	}{
+		{desc: "full-size", estimate: true, decoded: tcp4, length: 1514, size: 1514},
+		{desc: "GRO", estimate: true, decoded: tcp4, length: 14 + 40 + 14600, segments: 10, size: 10 * 1514},
+		{desc: "GRO, not estimated", decoded: tcp4, length: 14 + 40 + 14600, size: 14 + 40 + 14600},
+		{desc: "GRO, IP sizes", estimate: true, mode: SizeIP, decoded: tcp4, length: 14 + 40 + 14600, segments: 10, size: 10 * 1500},
+		{desc: "GRO, wire sizes", estimate: true, mode: SizeWire, decoded: tcp4, length: 14 + 40 + 14600, segments: 10, size: 10 * (1514 + DefaultWireOverhead)},
+		{desc: "partial last segment", estimate: true, decoded: tcp4, length: 14 + 40 + 2000, segments: 2, size: 14 + 40 + 2000 + 54},
+		{desc: "UDP GSO", estimate: true, decoded: udp4, length: 14 + 28 + 3000, segments: 3, size: 14 + 28 + 3000 + 2*42},
+		{desc: "tagged IPv6", estimate: true, decoded: tcp6, length: 18 + 60 + 2880, segments: 2, size: 18 + 60 + 2880 + 78},
+		{desc: "jumbo", mtu: 9000, estimate: true, decoded: tcp4, length: 9014, size: 9014},
+	} {
+		SetCoalescing(test.mtu, test.estimate)
+		if err := SetSizeAccounting(test.mode, DefaultWireOverhead); err != nil {
+			t.Fatal(err)
+		}
+		ip4, ip6 := &layers.IPv4{IHL: 5}, &layers.IPv6{}
+		if hasLayer(test.decoded, layers.LayerTypeIPv4) {
+			ip4.Length = uint16(test.length - 14)
+		} else {
+			ip6.Length = uint16(test.length - 18 - 40)
+		}
+		p := &Packet{
+			Meta:    &Metadata{Size: packetSize(test.length, test.decoded, ip4, ip6)},
+			Decoded: test.decoded,
+			IPv4:    ip4,
+			IPv6:    ip6,
+			TCP:     &layers.TCP{DataOffset: 5},
+		}
+		before := superframeStats.frames
+		coalesce(p, test.length)
+		if p.Meta.Segments != test.segments || p.Meta.Size != test.size {
+			t.Errorf("%s: got %d segments of %d bytes, want %d of %d", test.desc, p.Meta.Segments, p.Meta.Size, test.segments, test.size)
+		}
+		if counted := superframeStats.frames > before; counted != (test.segments > 0 || !test.estimate) {
+			t.Errorf("%s: superframe counted: got %v, want %v", test.desc, counted, !counted)
+		}
+	}
+	if !Coalescing() {
+		t.Error("Coalescing() = false after superframes")
+	}
+	m := &Metadata{Segments: 3}
+	if got := m.Packets(); got != 3 {
+		t.Errorf("Packets() with 3 segments: got %d, want 3", got)
+	}
+	if got := (&Metadata{}).Packets(); got != 1 {
+		t.Errorf("Packets() of a packet: got %d, want 1", got)
+	}
+}
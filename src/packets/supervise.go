@@ -0,0 +1,92 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file supervises the parts of a capture, so that a panic (a bug, or a
+// packet malformed in a way the decoders didn't expect) restarts the part
+// that panicked instead of taking the whole daemon down.
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"events"
+	"vars"
+)
+
+var (
+	// minRestartWait and maxRestartWait bound the backoff before a part
+	// that panicked is restarted. The wait doubles with each panic, and is
+	// reset once the part has run for resetAfter.
+	minRestartWait = 100 * time.Millisecond
+	maxRestartWait = 10 * time.Second
+	resetAfter     = time.Minute
+
+	crashes uint64 // accessed atomically
+)
+
+func init() {
+	vars.Uint64("capture-crashes", &crashes)
+}
+
+// supervise calls f until it returns without panicking. Each panic is
+// logged and published as a "crash" event with its stack, and f is called
+// again after a backoff.
+func supervise(component string, f func()) {
+	wait := minRestartWait
+	for {
+		start := time.Now()
+		if !crashed(component, f) {
+			return
+		}
+		if time.Since(start) >= resetAfter {
+			wait = minRestartWait
+		}
+		log.Printf("%s: restarting in %v", component, wait)
+		time.Sleep(wait)
+		if wait *= 2; wait > maxRestartWait {
+			wait = maxRestartWait
+		}
+	}
+}
+
+// crashed calls f, and reports whether it panicked.
+func crashed(component string, f func()) (panicked bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		panicked = true
+		atomic.AddUint64(&crashes, 1)
+		stack := string(debug.Stack())
+		log.Printf("%s panicked: %v\n%s", component, r, stack)
+		events.Publish(events.Event{
+			Type:     "crash",
+			Severity: events.Error,
+			Message:  fmt.Sprintf("%s panicked: %v", component, r),
+			Fields: map[string]string{
+				"component": component,
+				"panic":     fmt.Sprint(r),
+				"stack":     stack,
+			},
+		})
+	}()
+	f()
+	return false
+}
@@ -0,0 +1,61 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"events"
+)
+
+func TestSupervise(t *testing.T) {
+	defer func(w time.Duration) { minRestartWait = w }(minRestartWait)
+	minRestartWait = time.Millisecond
+	before := atomic.LoadUint64(&crashes)
+
+	calls := 0
+	supervise("test-component", func() {
+		calls++
+		if calls < 3 {
+			panic("malformed packet")
+		}
+	})
+	if calls != 3 {
+		t.Errorf("supervise called f %d times, want 3", calls)
+	}
+	if got := atomic.LoadUint64(&crashes) - before; got != 2 {
+		t.Errorf("crashes: got %d more, want 2", got)
+	}
+
+	var crash *events.Event
+	for _, e := range events.Recent() {
+		if e.Type == "crash" && e.Fields["component"] == "test-component" {
+			e := e
+			crash = &e
+		}
+	}
+	if crash == nil {
+		t.Fatal("no crash event published")
+	}
+	if crash.Fields["panic"] != "malformed packet" {
+		t.Errorf("crash event panic: got %q, want %q", crash.Fields["panic"], "malformed packet")
+	}
+	if !strings.Contains(crash.Fields["stack"], "TestSupervise") {
+		t.Errorf("crash event stack doesn't show where it panicked:\n%s", crash.Fields["stack"])
+	}
+}
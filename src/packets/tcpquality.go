@@ -0,0 +1,161 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file follows the sequence numbers of TCP flows, to count the
+// segments sent again (retransmissions), those that arrived after later ones
+// (out of order), and duplicate ACKs, which are how lost packets show on a
+// link. A segment that fills a gap soon after the gap appeared was
+// reordered; one that comes later, or repeats what was already seen, was
+// sent again.
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"vars"
+)
+
+// reorderWindow is how soon after a gap a segment filling it counts as out
+// of order rather than retransmitted. Retransmissions take at least a
+// round trip, which this is shorter than on all but the nearest links.
+const reorderWindow = 3 * time.Millisecond
+
+// tcpTotals counts across all flows, accessed atomically.
+var tcpTotals struct {
+	retransmissions, outOfOrder, dupAcks uint64
+}
+
+func init() {
+	vars.Uint64("tcp-retransmissions", &tcpTotals.retransmissions)
+	vars.Uint64("tcp-out-of-order", &tcpTotals.outOfOrder)
+	vars.Uint64("tcp-dup-acks", &tcpTotals.dupAcks)
+}
+
+// TCPQuality counts the TCP segments that show loss or reordering.
+type TCPQuality struct {
+	Retransmissions uint64 `json:",omitempty"`
+	OutOfOrder      uint64 `json:",omitempty"`
+	DupAcks         uint64 `json:",omitempty"`
+}
+
+// TCPTotals returns the counts across all the flows in flow tables so far.
+func TCPTotals() TCPQuality {
+	return TCPQuality{
+		Retransmissions: atomic.LoadUint64(&tcpTotals.retransmissions),
+		OutOfOrder:      atomic.LoadUint64(&tcpTotals.outOfOrder),
+		DupAcks:         atomic.LoadUint64(&tcpTotals.dupAcks),
+	}
+}
+
+// tcpSender follows the segments from one end of a TCP flow.
+type tcpSender struct {
+	started bool
+	next    uint32 // the sequence number after the highest sent
+
+	// The latest gap: the sequence numbers missing, and when it appeared.
+	gapStart, gapEnd uint32
+	gapAt            time.Time
+
+	acked  bool // ack and window are set
+	ack    uint32
+	window uint16
+}
+
+// seqLess reports whether a is before b, modulo 2^32.
+func seqLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// tcpPayloadLen returns the length of the TCP segment's data, from the IP
+// header if it has a length (so packets cut short by the snaplen count in
+// full), or else from what was captured.
+func tcpPayloadLen(p *Packet) uint32 {
+	header := 4 * int(p.TCP.DataOffset)
+	n := -1
+	switch {
+	case p.Has(layers.LayerTypeIPv4) && p.IPv4 != nil && p.IPv4.Length > 0:
+		n = int(p.IPv4.Length) - 4*int(p.IPv4.IHL) - header
+	case p.Has(layers.LayerTypeIPv6) && p.IPv6 != nil && p.IPv6.Length > 0:
+		n = int(p.IPv6.Length) - header // ignoring extension headers
+	}
+	if n < 0 {
+		n = len(p.TCP.Payload)
+	}
+	return uint32(n)
+}
+
+// segment follows a segment from the sender, whose peer is the other end,
+// and adds what it shows to q.
+func (s *tcpSender) segment(p *Packet, peer *tcpSender, q *TCPQuality) {
+	seg := p.TCP
+	n := tcpPayloadLen(p)
+	at := p.Meta.Timestamp
+	length := n // in sequence numbers
+	if seg.SYN {
+		length++
+	}
+	if seg.FIN {
+		length++
+	}
+	start, end := seg.Seq, seg.Seq+length
+
+	if seg.ACK && !seg.SYN && !seg.FIN && !seg.RST {
+		// An ACK without data, that repeats the last, while the peer has
+		// data outstanding, and doesn't move the window, is a duplicate.
+		if n == 0 && s.acked && seg.Ack == s.ack && seg.Window == s.window && peer.started && seg.Ack != peer.next {
+			q.DupAcks++
+		}
+		s.acked, s.ack, s.window = true, seg.Ack, seg.Window
+	}
+
+	switch {
+	case length == 0 || seg.RST:
+		return
+	case !s.started:
+		s.started, s.next = true, end
+		return
+	case n == 1 && start+1 == s.next && !seg.SYN && !seg.FIN:
+		return // a keepalive, repeating the last byte
+	}
+	switch {
+	case seqLess(s.next, start):
+		// Ahead of what was expected: the segments between are late.
+		s.gapStart, s.gapEnd, s.gapAt = s.next, start, at
+		s.next = end
+	case !seqLess(start, s.gapStart) && seqLess(start, s.gapEnd) && at.Sub(s.gapAt) < reorderWindow:
+		q.OutOfOrder++
+		s.gapStart = end // the rest of the gap may still come
+	case seqLess(start, s.next):
+		q.Retransmissions++
+		if seqLess(s.next, end) {
+			s.next = end
+		}
+	default:
+		s.next = end
+	}
+}
+
+// add adds the counts in o to q, and to the totals.
+func (q *TCPQuality) add(o TCPQuality) {
+	q.Retransmissions += o.Retransmissions
+	q.OutOfOrder += o.OutOfOrder
+	q.DupAcks += o.DupAcks
+	atomic.AddUint64(&tcpTotals.retransmissions, o.Retransmissions)
+	atomic.AddUint64(&tcpTotals.outOfOrder, o.OutOfOrder)
+	atomic.AddUint64(&tcpTotals.dupAcks, o.DupAcks)
+}
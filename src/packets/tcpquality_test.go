@@ -0,0 +1,100 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestTCPQuality(t *testing.T) {
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	client, server := net.ParseIP("10.0.0.2"), net.ParseIP("192.0.2.80")
+
+	// seg is a segment with n bytes of data, from the client unless
+	// fromServer, with the flags of "SAF".
+	seg := func(at time.Duration, fromServer bool, flags string, seq, ack uint32, n uint16) *Packet {
+		m := &Metadata{
+			Timestamp: start.Add(at), Size: uint64(54 + n),
+			SrcIP: client, DstIP: server, SrcPort: 50000, DstPort: 443,
+			Protocol: uint8(layers.IPProtocolTCP),
+		}
+		tcp := &layers.TCP{SrcPort: 50000, DstPort: 443, Seq: seq, Ack: ack, DataOffset: 5, Window: 100}
+		if fromServer {
+			m.SrcIP, m.DstIP, m.SrcPort, m.DstPort = server, client, 443, 50000
+			tcp.SrcPort, tcp.DstPort = 443, 50000
+		}
+		for _, f := range flags {
+			switch f {
+			case 'S':
+				tcp.SYN = true
+			case 'A':
+				tcp.ACK = true
+			case 'F':
+				tcp.FIN = true
+			}
+		}
+		return &Packet{
+			Meta:    m,
+			Decoded: []gopacket.LayerType{layers.LayerTypeIPv4, layers.LayerTypeTCP},
+			IPv4:    &layers.IPv4{IHL: 5, Length: 40 + n},
+			TCP:     tcp,
+		}
+	}
+	const ms = time.Millisecond
+
+	before := TCPTotals()
+	var got []Flow
+	ft := &FlowTable{Export: func(recs []Flow) { got = append(got, recs...) }}
+	for _, p := range []*Packet{
+		seg(0, false, "S", 1000, 0, 0),
+		seg(1*ms, true, "SA", 5000, 1001, 0),
+		seg(2*ms, false, "A", 1001, 5001, 0),
+		seg(10*ms, false, "A", 1001, 5001, 100),
+		// 1101 is overtaken, but only just.
+		seg(11*ms, false, "A", 1201, 5001, 100),
+		seg(12*ms, false, "A", 1101, 5001, 100), // out of order
+		seg(20*ms, true, "A", 5001, 1301, 0),
+		// 1401 is lost: the server says so, and it is sent again.
+		seg(30*ms, false, "A", 1301, 5001, 100),
+		seg(31*ms, false, "A", 1501, 5001, 100),
+		seg(32*ms, true, "A", 5001, 1401, 0),
+		seg(33*ms, true, "A", 5001, 1401, 0),     // duplicate
+		seg(34*ms, true, "A", 5001, 1401, 0),     // duplicate
+		seg(100*ms, false, "A", 1401, 5001, 100), // retransmission
+		seg(101*ms, true, "A", 5001, 1601, 0),
+		seg(110*ms, false, "A", 1001, 5001, 100), // retransmission
+		seg(120*ms, false, "A", 1600, 5001, 1),   // keepalive
+		seg(121*ms, true, "A", 5001, 1601, 0),    // its ACK, with nothing outstanding
+	} {
+		ft.add(p)
+	}
+	ft.Close()
+	if len(got) != 1 {
+		t.Fatalf("got %d records, want 1: %+v", len(got), got)
+	}
+	want := TCPQuality{Retransmissions: 2, OutOfOrder: 1, DupAcks: 2}
+	if got[0].TCPQuality != want {
+		t.Errorf("TCPQuality: got %+v, want %+v", got[0].TCPQuality, want)
+	}
+	after := TCPTotals()
+	if d := after.Retransmissions - before.Retransmissions; d != want.Retransmissions {
+		t.Errorf("TCPTotals().Retransmissions went up by %d, want %d", d, want.Retransmissions)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file names the vendors of the devices on the LAN, from their MAC
+// addresses, to help tell apart devices that have no other name.
+
+import (
+	"net"
+	"sync/atomic"
+
+	"oui"
+)
+
+// ouiTable holds the *oui.Table.
+var ouiTable atomic.Value
+
+// SetOUITable sets the table that devices' vendors are looked up in. It may
+// be changed while capturing; nil turns the lookups off.
+func SetOUITable(t *oui.Table) {
+	ouiTable.Store(t)
+}
+
+// Vendor returns the vendor of the MAC address, or "" if it isn't known.
+func Vendor(mac string) string {
+	t, _ := ouiTable.Load().(*oui.Table)
+	if t == nil {
+		return ""
+	}
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return ""
+	}
+	return t.Lookup(hw)
+}
@@ -0,0 +1,52 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"testing"
+	"time"
+
+	"oui"
+)
+
+func TestVendor(t *testing.T) {
+	tbl, err := oui.Parse([]byte("24:0A:C4\tEspressif\tEspressif Inc.\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetOUITable(tbl)
+	defer SetOUITable(nil)
+
+	for mac, want := range map[string]string{
+		"24:0a:c4:01:02:03": "Espressif Inc.",
+		"00:11:22:33:44:55": "",
+		"not a mac":         "",
+	} {
+		if got := Vendor(mac); got != want {
+			t.Errorf("Vendor(%q) = %q, want %q", mac, got, want)
+		}
+	}
+
+	d := newDeviceTable()
+	d.learn(Device{MAC: "24:0a:c4:01:02:03"}, time.Now())
+	if l := d.list(); len(l) != 1 || l[0].Vendor != "Espressif Inc." {
+		t.Errorf("list: got %+v, want the device with its vendor", l)
+	}
+
+	SetOUITable(nil)
+	if got := Vendor("24:0a:c4:01:02:03"); got != "" {
+		t.Errorf("Vendor with no table = %q, want \"\"", got)
+	}
+}
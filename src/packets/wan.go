@@ -0,0 +1,138 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file tracks the router's public (WAN) address, so that its traffic
+// counts as the network's own rather than an external host's. The address
+// is set from outside (e.g. by asking a web service), or detected: captured
+// outside the NAT, nearly every packet is between the WAN address and some
+// external host.
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"events"
+	"vars"
+)
+
+const (
+	// wanSampleRate is how many external packets there are for each one
+	// observed for detection.
+	wanSampleRate = 16
+
+	// wanWindow is how many packets are observed for each decision.
+	wanWindow = 1000
+)
+
+var wan struct {
+	ip      atomic.Value // net.IP; set with mu held
+	sampled uint64       // external packets seen, accessed atomically
+
+	mu     sync.Mutex // guards the rest
+	counts map[[16]byte]int
+	n      int
+}
+
+func init() {
+	wan.ip.Store(net.IP(nil))
+	vars.Register("wan-ip", func() string { return WANIP().String() })
+	RegisterEnricher("wan", func(*Capture) Enricher { return EnricherFunc(observeWAN) })
+}
+
+// WANIP returns the router's public address, or nil if it isn't known.
+func WANIP() net.IP {
+	return wan.ip.Load().(net.IP)
+}
+
+// SetWANIP sets the router's public address, which IsLocal then counts as
+// local. A change is published as a "wan-ip-changed" event.
+func SetWANIP(ip net.IP) {
+	wan.mu.Lock()
+	old := WANIP()
+	if ip.Equal(old) {
+		wan.mu.Unlock()
+		return
+	}
+	wan.ip.Store(ip)
+	wan.mu.Unlock()
+	e := events.Event{
+		Type:     "wan-ip-changed",
+		Severity: events.Notice,
+		Message:  fmt.Sprintf("WAN address changed from %v to %v", old, ip),
+		Fields:   map[string]string{"old": old.String(), "new": ip.String()},
+	}
+	if old == nil {
+		e.Severity = events.Info
+		e.Message = fmt.Sprintf("WAN address is %v", ip)
+		delete(e.Fields, "old")
+	}
+	events.Publish(e)
+}
+
+// isWAN reports whether ip is the router's public address.
+func isWAN(ip net.IP) bool {
+	w := WANIP()
+	return w != nil && w.Equal(ip)
+}
+
+// observeWAN samples packets between public addresses, and sets the WAN
+// address once one address is in almost all of them.
+func observeWAN(p *Packet) {
+	m := p.Meta
+	if m.SrcIP == nil || isPrivate(m.SrcIP) || isPrivate(m.DstIP) {
+		return
+	}
+	if atomic.AddUint64(&wan.sampled, 1)%wanSampleRate != 0 {
+		return
+	}
+	if ip := wanObserve(m.SrcIP, m.DstIP); ip != nil {
+		SetWANIP(ip)
+	}
+}
+
+// wanObserve counts the addresses of a packet, returning the WAN address at
+// the end of each window if one was found.
+func wanObserve(src, dst net.IP) net.IP {
+	wan.mu.Lock()
+	defer wan.mu.Unlock()
+	if wan.counts == nil {
+		wan.counts = make(map[[16]byte]int)
+	}
+	wan.counts[ipKey(src)]++
+	wan.counts[ipKey(dst)]++
+	if wan.n++; wan.n < wanWindow {
+		return nil
+	}
+	var top [16]byte
+	max := 0
+	for k, c := range wan.counts {
+		if c > max {
+			top, max = k, c
+		}
+	}
+	n := wan.n
+	wan.counts, wan.n = nil, 0
+	if max < n*3/4 {
+		return nil
+	}
+	ip := net.IP(top[:])
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
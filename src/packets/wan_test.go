@@ -0,0 +1,56 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestWANDetect(t *testing.T) {
+	defer wan.ip.Store(net.IP(nil))
+	self := net.ParseIP("198.51.100.7")
+	if IsLocal(self) {
+		t.Fatalf("IsLocal(%v) before detection: got true, want false", self)
+	}
+	var got net.IP
+	for i := 0; i < wanWindow; i++ {
+		peer := net.ParseIP(fmt.Sprintf("203.0.113.%d", i%200))
+		if i%2 == 0 {
+			got = wanObserve(self, peer)
+		} else {
+			got = wanObserve(peer, self)
+		}
+	}
+	if !got.Equal(self) {
+		t.Fatalf("wanObserve: got %v, want %v", got, self)
+	}
+	SetWANIP(got)
+	if !IsLocal(self) {
+		t.Errorf("IsLocal(%v) after detection: got false, want true", self)
+	}
+	if IsLocal(net.ParseIP("203.0.113.1")) {
+		t.Error("IsLocal(203.0.113.1): got true, want false")
+	}
+
+	// Transit traffic between many hosts has no WAN address.
+	for i := 0; i < wanWindow; i++ {
+		got = wanObserve(net.ParseIP(fmt.Sprintf("203.0.113.%d", i%200)), net.ParseIP(fmt.Sprintf("192.0.2.%d", i%100)))
+	}
+	if got != nil {
+		t.Errorf("wanObserve of transit traffic: got %v, want nil", got)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+// This file names Windows hosts, on LANs without mDNS, from the names they
+// answer LLMNR queries for, and those they register or are given in answers
+// with the NetBIOS name service.
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	llmnrPort     = 5355
+	netbiosNSPort = 137
+
+	// nbType is the NetBIOS name service's NB record type, whose data is
+	// (flags, IPv4 address) pairs.
+	nbType = 0x20
+
+	// nbGroup is the flag of an NB record for a group name.
+	nbGroup = 0x8000
+)
+
+// learnWindowsNames learns names from an LLMNR response or NetBIOS name
+// service packet sent by a local host.
+func learnWindowsNames(p *Packet) {
+	m := p.Meta
+	if !p.Has(layers.LayerTypeUDP) || !IsLocal(m.SrcIP) {
+		return
+	}
+	switch {
+	case m.SrcPort == llmnrPort && enabled(NameLLMNR):
+		learnLLMNR(p.UDP.Payload, m.Timestamp)
+	case m.SrcPort == netbiosNSPort && enabled(NameNetBIOS):
+		learnNetBIOS(p.UDP.Payload, m.Timestamp)
+	}
+}
+
+// learnLLMNR names the addresses in the A and AAAA records of an LLMNR
+// response, which are the responder's own.
+func learnLLMNR(b []byte, now time.Time) {
+	var dns layers.DNS
+	if !parseDNS(b, &dns) || !dns.QR {
+		return
+	}
+	for i := range dns.Answers {
+		a := &dns.Answers[i]
+		if a.Class != layers.DNSClassIN || (a.Type != layers.DNSTypeA && a.Type != layers.DNSTypeAAAA) {
+			continue
+		}
+		name := strings.TrimSuffix(string(a.Name), ".")
+		if name == "" || a.IP == nil || a.IP.IsUnspecified() {
+			continue
+		}
+		globalNames.set(a.IP, name, NameLLMNR, now)
+	}
+}
+
+// learnNetBIOS names the addresses in a NetBIOS name registration or
+// refresh, or a positive answer to a name query, with the unique workstation
+// and server names.
+func learnNetBIOS(b []byte, now time.Time) {
+	if len(b) < 12 {
+		return
+	}
+	response := b[2]&0x80 != 0
+	opcode := b[2] >> 3 & 0x0f
+	switch {
+	case response && opcode == 0 && b[3]&0x0f == 0:
+		// A positive query response.
+	case !response && (opcode == 5 || opcode == 8 || opcode == 9):
+		// A registration, or a refresh.
+	default:
+		return
+	}
+	var dns layers.DNS
+	parseDNS(b, &dns)
+	for _, rrs := range [][]layers.DNSResourceRecord{dns.Answers, dns.Additionals} {
+		for i := range rrs {
+			rr := &rrs[i]
+			if rr.Type != nbType || rr.Class != layers.DNSClassIN {
+				continue
+			}
+			name, suffix, ok := netbiosName(rr.Name)
+			// 0x00 is the workstation service, and 0x20 the file server.
+			if !ok || name == "" || (suffix != 0x00 && suffix != 0x20) {
+				continue
+			}
+			for d := rr.Data; len(d) >= 6; d = d[6:] {
+				ip := net.IP(d[2:6])
+				if binary.BigEndian.Uint16(d)&nbGroup != 0 || ip.IsUnspecified() {
+					continue
+				}
+				globalNames.set(ip, name, NameNetBIOS, now)
+			}
+		}
+	}
+}
+
+// netbiosName decodes the first label of an encoded NetBIOS name (the rest
+// is the scope), returning the name without its padding, and its suffix.
+func netbiosName(encoded []byte) (string, byte, bool) {
+	label := encoded
+	if i := strings.IndexByte(string(encoded), '.'); i >= 0 {
+		label = encoded[:i]
+	}
+	if len(label) != 32 {
+		return "", 0, false
+	}
+	var raw [16]byte
+	for i := range raw {
+		hi, lo := label[2*i]-'A', label[2*i+1]-'A'
+		if hi > 0x0f || lo > 0x0f {
+			return "", 0, false
+		}
+		raw[i] = hi<<4 | lo
+	}
+	return strings.TrimRight(string(raw[:15]), " "), raw[15], true
+}
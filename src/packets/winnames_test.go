@@ -0,0 +1,112 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packets
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// nbName encodes a NetBIOS name with its suffix, as a DNS name.
+func nbName(name string, suffix byte) []byte {
+	raw := []byte(name + "               ")[:15]
+	raw = append(raw, suffix)
+	b := []byte{32}
+	for _, c := range raw {
+		b = append(b, 'A'+c>>4, 'A'+c&0x0f)
+	}
+	return append(b, 0)
+}
+
+// udpPacket is a UDP packet from src:port, with the payload.
+func udpPacket(src net.IP, port uint16, payload []byte) *Packet {
+	return &Packet{
+		Meta:    &Metadata{Timestamp: time.Now(), SrcIP: src, DstIP: net.IPv4bcast, SrcPort: port, DstPort: port, Protocol: 17},
+		Decoded: []gopacket.LayerType{layers.LayerTypeIPv4, layers.LayerTypeUDP},
+		UDP:     &layers.UDP{BaseLayer: layers.BaseLayer{Payload: payload}},
+	}
+}
+
+func TestLearnWindowsNames(t *testing.T) {
+	defer ForgetNames(NameLLMNR)
+	defer ForgetNames(NameNetBIOS)
+
+	// An LLMNR response.
+	llmnr := net.ParseIP("192.168.1.60")
+	learnWindowsNames(udpPacket(net.ParseIP("192.168.1.60"), llmnrPort, dnsResponse("desktop-llmnr", "", llmnr)))
+
+	// A NetBIOS name registration, whose record points to the question.
+	reg := net.ParseIP("192.168.1.61").To4()
+	b := []byte{0x12, 0x34, 0x29, 0x10, 0, 1, 0, 0, 0, 0, 0, 1}
+	b = append(b, nbName("DESKTOP-REG", 0x00)...)
+	b = append(b, 0, nbType, 0, 1)
+	b = append(b, 0xc0, 12, 0, nbType, 0, 1, 0, 4, 0x93, 0xe0, 0, 6, 0, 0)
+	b = append(b, reg...)
+	learnWindowsNames(udpPacket(reg, netbiosNSPort, b))
+
+	// A positive query response, with a group name and a unique one, and
+	// a domain name.
+	unique, group := net.ParseIP("192.168.1.62").To4(), net.ParseIP("192.168.1.63").To4()
+	b = []byte{0x12, 0x35, 0x85, 0x00, 0, 0, 0, 2, 0, 0, 0, 0}
+	b = append(b, nbName("FILESERVER", 0x20)...)
+	b = append(b, 0, nbType, 0, 1, 0, 4, 0x93, 0xe0, 0, 12, 0x80, 0)
+	b = append(b, group...)
+	b = append(b, 0, 0)
+	b = append(b, unique...)
+	b = append(b, nbName("CORP", 0x1c)...)
+	b = append(b, 0, nbType, 0, 1, 0, 4, 0x93, 0xe0, 0, 6, 0, 0)
+	b = append(b, net.ParseIP("192.168.1.64").To4()...)
+	learnWindowsNames(udpPacket(net.ParseIP("192.168.1.1"), netbiosNSPort, b))
+
+	for _, test := range []struct {
+		ip     net.IP
+		name   string
+		source NameSource
+	}{
+		{llmnr, "desktop-llmnr", NameLLMNR},
+		{reg, "DESKTOP-REG", NameNetBIOS},
+		{unique, "FILESERVER", NameNetBIOS},
+		{group, "", NameNone},
+		{net.ParseIP("192.168.1.64"), "", NameNone},
+	} {
+		got, _ := globalNames.get(test.ip)
+		if got.name != test.name || got.source != test.source {
+			t.Errorf("name for %v: got %q %v, want %q %v", test.ip, got.name, got.source, test.name, test.source)
+		}
+	}
+}
+
+func TestNetBIOSName(t *testing.T) {
+	for _, test := range []struct {
+		encoded string
+		name    string
+		suffix  byte
+		ok      bool
+	}{
+		{"EEEFFDELFEEPFACNEBECEDCACACACAAA", "DESKTOP-ABC", 0x00, true},
+		{"EEEFFDELFEEPFACNEBECEDCACACACAAA.corp.example", "DESKTOP-ABC", 0x00, true},
+		{"EEEFFDELFEEPFACNEBECEDCACACACA", "", 0, false},
+		{"ZZEFFDELFEEPFACNEBECEDCACACACAAA", "", 0, false},
+	} {
+		name, suffix, ok := netbiosName([]byte(test.encoded))
+		if name != test.name || suffix != test.suffix || ok != test.ok {
+			t.Errorf("netbiosName(%q) = %q, %#x, %v; want %q, %#x, %v", test.encoded, name, suffix, ok, test.name, test.suffix, test.ok)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prom
+
+// This file serves /metrics, for Prometheus (or anything else that speaks
+// its text format) to scrape.
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dashboard"
+	"health"
+	"vars"
+)
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	invalidName  = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// writeMetrics writes samples in the Prometheus text format, grouped by
+// name. Names ending in _total are counters, and the rest are gauges.
+func writeMetrics(w *bufio.Writer, samples []Sample) {
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Name < samples[j].Name })
+	for i, s := range samples {
+		if i == 0 || samples[i-1].Name != s.Name {
+			typ := "gauge"
+			if strings.HasSuffix(s.Name, "_total") {
+				typ = "counter"
+			}
+			fmt.Fprintf(w, "# TYPE %s %s\n", s.Name, typ)
+		}
+		w.WriteString(s.Name)
+		if len(s.Labels) > 0 {
+			keys := make([]string, 0, len(s.Labels))
+			for k := range s.Labels {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			w.WriteByte('{')
+			for j, k := range keys {
+				if j > 0 {
+					w.WriteByte(',')
+				}
+				fmt.Fprintf(w, `%s="%s"`, k, labelEscaper.Replace(s.Labels[k]))
+			}
+			w.WriteByte('}')
+		}
+		w.WriteByte(' ')
+		w.WriteString(strconv.FormatFloat(s.Value, 'g', -1, 64))
+		w.WriteByte('\n')
+	}
+}
+
+// internalSamples converts the numeric /vars and the health checks into
+// gauges.
+func internalSamples() []Sample {
+	var s []Sample
+	for k, v := range vars.Evaluate() {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			continue
+		}
+		s = append(s, Sample{Name: "caplog_" + invalidName.ReplaceAllString(k, "_"), Value: f})
+	}
+	for name, ok := range health.Statuses() {
+		var v float64
+		if ok {
+			v = 1
+		}
+		s = append(s, Sample{Name: "caplog_healthy", Labels: map[string]string{"check": name}, Value: v})
+	}
+	return s
+}
+
+// metricsHandler serves /metrics.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	samples := append(Samples(dashboard.State()), internalSamples()...)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	writeMetrics(bw, samples)
+	if err := bw.Flush(); err != nil {
+		log.Print("metrics failed to write:", err)
+	}
+}
+
+// RegisterHandler adds a HTTP handler for /metrics.
+func RegisterHandler() {
+	http.HandleFunc("/metrics", metricsHandler)
+}
@@ -0,0 +1,42 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prom
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	samples := []Sample{
+		{Name: "caplog_direction_bytes_total", Labels: map[string]string{"direction": "up"}, Value: 100},
+		{Name: "caplog_buffer_ring_len", Value: 2},
+		{Name: "caplog_direction_bytes_total", Labels: map[string]string{"direction": "down", "interface": `a"b`}, Value: 1.5e10},
+	}
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+	writeMetrics(w, samples)
+	w.Flush()
+	want := `# TYPE caplog_buffer_ring_len gauge
+caplog_buffer_ring_len 2
+# TYPE caplog_direction_bytes_total counter
+caplog_direction_bytes_total{direction="up"} 100
+caplog_direction_bytes_total{direction="down",interface="a\"b"} 1.5e+10
+`
+	if got := b.String(); got != want {
+		t.Errorf("writeMetrics:\ngot\n%s\nwant\n%s", got, want)
+	}
+}
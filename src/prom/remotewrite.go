@@ -0,0 +1,258 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prom exports the dashboard aggregates to Prometheus-compatible
+// systems.
+package prom
+
+// This file implements pushing via the Prometheus remote-write protocol, for
+// probes behind NAT that can't be scraped.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"dashboard"
+	"protowire"
+	"vars"
+)
+
+const (
+	remoteWriteRetryLimit = 5
+	maxSeriesPerRequest   = 500
+	// maxPendingSeries bounds memory if the endpoint is down for a long time.
+	maxPendingSeries = 100000
+)
+
+// Sample is one value of one series.
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+	Time   time.Time
+}
+
+// Samples converts a dashboard state into samples: the combined totals, and
+// each interface's.
+func Samples(v dashboard.Values) []Sample {
+	var s []Sample
+	add := func(name, label, value string, a dashboard.Aggregation, extra map[string]string) {
+		for _, m := range []struct {
+			suffix string
+			value  uint64
+		}{{"_bytes_total", a.Bytes}, {"_packets_total", a.Packets}} {
+			labels := map[string]string{label: value}
+			for k, v := range extra {
+				labels[k] = v
+			}
+			s = append(s, Sample{Name: "caplog_" + name + m.suffix, Labels: labels, Value: float64(m.value), Time: v.Now})
+		}
+	}
+	add("direction", "direction", "total", v.Total, nil)
+	add("direction", "direction", "up", v.Up, nil)
+	add("direction", "direction", "down", v.Down, nil)
+	add("direction", "direction", "internal", v.Internal, nil)
+	add("direction", "direction", "external", v.External, nil)
+	add("family", "family", "v4", v.V4, nil)
+	add("family", "family", "v6", v.V6, nil)
+	add("icmp", "kind", "ping", v.Ping, nil)
+	add("icmp", "kind", "unreachable", v.Unreachable, nil)
+	add("icmp", "kind", "other", v.OtherICMP, nil)
+	ifaces := make([]string, 0, len(v.Interfaces))
+	for name := range v.Interfaces {
+		ifaces = append(ifaces, name)
+	}
+	sort.Strings(ifaces)
+	for _, name := range ifaces {
+		// A separate metric, so that summing caplog_direction_* doesn't
+		// count packets twice.
+		iface := map[string]string{"interface": name}
+		c := v.Interfaces[name]
+		add("interface_direction", "direction", "up", c.Up, iface)
+		add("interface_direction", "direction", "down", c.Down, iface)
+		add("interface_direction", "direction", "internal", c.Internal, iface)
+		add("interface_direction", "direction", "external", c.External, iface)
+	}
+	vlans := make([]int, 0, len(v.VLANs))
+	for id := range v.VLANs {
+		vlans = append(vlans, int(id))
+	}
+	sort.Ints(vlans)
+	for _, id := range vlans {
+		vlan := map[string]string{"vlan": strconv.Itoa(id)}
+		c := v.VLANs[uint16(id)]
+		add("vlan_direction", "direction", "up", c.Up, vlan)
+		add("vlan_direction", "direction", "down", c.Down, vlan)
+		add("vlan_direction", "direction", "internal", c.Internal, vlan)
+		add("vlan_direction", "direction", "external", c.External, vlan)
+	}
+	return s
+}
+
+// RemoteWriter periodically pushes the dashboard aggregates to a
+// remote-write endpoint (Grafana Cloud, Mimir, Cortex, Thanos, Prometheus
+// with --web.enable-remote-write-receiver...).
+type RemoteWriter struct {
+	URL      string
+	Interval time.Duration
+
+	// Either basic auth or a bearer token may be used.
+	Username, Password string
+	BearerToken        string
+
+	// Labels are added to every series (e.g. instance="router").
+	Labels map[string]string
+
+	client  *http.Client
+	pending []Sample
+	sent    uint64
+	failed  uint64
+	dropped uint64
+}
+
+// Run collects and pushes samples every Interval, forever.
+func (rw *RemoteWriter) Run() {
+	if rw.Interval <= 0 {
+		rw.Interval = 30 * time.Second
+	}
+	rw.client = &http.Client{Timeout: 30 * time.Second}
+	vars.Uint64("remote-write-samples-sent", &rw.sent)
+	vars.Uint64("remote-write-failures", &rw.failed)
+	vars.Uint64("remote-write-samples-dropped", &rw.dropped)
+
+	for range time.Tick(rw.Interval) {
+		rw.pending = append(rw.pending, Samples(dashboard.State())...)
+		if over := len(rw.pending) - maxPendingSeries; over > 0 {
+			// Drop the oldest.
+			rw.pending = rw.pending[over:]
+			rw.dropped += uint64(over)
+		}
+		rw.flush()
+	}
+}
+
+// flush sends pending samples in batches, stopping at the first batch that
+// can't be sent (so it is retried next time).
+func (rw *RemoteWriter) flush() {
+	for len(rw.pending) > 0 {
+		n := len(rw.pending)
+		if n > maxSeriesPerRequest {
+			n = maxSeriesPerRequest
+		}
+		retry, err := rw.send(rw.pending[:n])
+		if err != nil {
+			log.Printf("remote write: %v", err)
+			rw.failed++
+			if retry {
+				return
+			}
+			// Not retryable; the endpoint will never accept it.
+			rw.dropped += uint64(n)
+		} else {
+			rw.sent += uint64(n)
+		}
+		rw.pending = rw.pending[n:]
+	}
+	rw.pending = nil
+}
+
+// send posts one batch with fuzzed exponential backoff. It reports whether
+// a failure is worth retrying later.
+func (rw *RemoteWriter) send(samples []Sample) (retry bool, err error) {
+	body := snappyEncode(rw.encode(samples))
+	waitBase := 100 * time.Millisecond
+	for i := 0; i < remoteWriteRetryLimit; i++ {
+		req, err := http.NewRequest("POST", rw.URL, bytes.NewReader(body))
+		if err != nil {
+			return false, err
+		}
+		h := req.Header
+		h.Set("Content-Type", "application/x-protobuf")
+		h.Set("Content-Encoding", "snappy")
+		h.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+		h.Set("User-Agent", "caplog")
+		switch {
+		case rw.BearerToken != "":
+			h.Set("Authorization", "Bearer "+rw.BearerToken)
+		case rw.Username != "":
+			req.SetBasicAuth(rw.Username, rw.Password)
+		}
+		resp, err := rw.client.Do(req)
+		if err == nil {
+			msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			switch {
+			case resp.StatusCode/100 == 2:
+				return false, nil
+			case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests:
+				return false, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+			}
+			err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+		}
+		log.Printf("remote write attempt %d: %v", i+1, err)
+		<-time.After(waitBase + time.Duration(rand.Int63n(int64(waitBase))))
+		waitBase *= 2
+	}
+	return true, fmt.Errorf("giving up after %d attempts", remoteWriteRetryLimit)
+}
+
+// encode marshals samples as a prometheus.WriteRequest protobuf, one series
+// per sample.
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func (rw *RemoteWriter) encode(samples []Sample) []byte {
+	var req, ts, buf protowire.Buffer
+	for _, s := range samples {
+		ts.Reset()
+
+		// Labels must be sorted by name, __name__ first.
+		labels := map[string]string{"__name__": s.Name}
+		for k, v := range rw.Labels {
+			labels[k] = v
+		}
+		for k, v := range s.Labels {
+			labels[k] = v
+		}
+		names := make([]string, 0, len(labels))
+		for k := range labels {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			buf.Reset()
+			buf.String(1, k)
+			buf.String(2, labels[k])
+			ts.Message(1, buf.B)
+		}
+
+		buf.Reset()
+		buf.Double(1, s.Value)
+		buf.Int64(2, s.Time.UnixNano()/1e6)
+		ts.Message(2, buf.B)
+
+		req.Message(1, ts.B)
+	}
+	return req.B
+}
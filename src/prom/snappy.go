@@ -0,0 +1,44 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prom
+
+// This file has just enough snappy encoding for remote write, to avoid
+// pulling in a library for it.
+
+import "encoding/binary"
+
+// snappyEncode produces a valid snappy block made only of literals. It
+// doesn't compress, but every snappy decoder accepts it, and the payloads are
+// small.
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)+len(src)/65536*3+16), uint64(len(src)))
+	for len(src) > 0 {
+		n := len(src)
+		if n > 65536 {
+			n = 65536
+		}
+		switch {
+		case n <= 60:
+			dst = append(dst, byte(n-1)<<2)
+		case n <= 256:
+			dst = append(dst, 60<<2, byte(n-1))
+		default:
+			dst = append(dst, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
@@ -0,0 +1,77 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// snappyDecodeLiterals decodes a literal-only snappy block.
+func snappyDecodeLiterals(t *testing.T, b []byte) []byte {
+	n, k := binary.Uvarint(b)
+	b = b[k:]
+	var out []byte
+	for len(b) > 0 {
+		tag := b[0]
+		if tag&3 != 0 {
+			t.Fatalf("unexpected non-literal tag %#x", tag)
+		}
+		l := int(tag>>2) + 1
+		b = b[1:]
+		switch tag >> 2 {
+		case 60:
+			l = int(b[0]) + 1
+			b = b[1:]
+		case 61:
+			l = int(b[0]) | int(b[1])<<8 + 1
+			b = b[2:]
+		}
+		out = append(out, b[:l]...)
+		b = b[l:]
+	}
+	if uint64(len(out)) != n {
+		t.Fatalf("decoded length %d, header says %d", len(out), n)
+	}
+	return out
+}
+
+func TestSnappyEncode(t *testing.T) {
+	for _, n := range []int{0, 1, 60, 61, 256, 257, 65536, 100000} {
+		src := bytes.Repeat([]byte{'x'}, n)
+		if got := snappyDecodeLiterals(t, snappyEncode(src)); !bytes.Equal(got, src) {
+			t.Errorf("snappy round trip of %d bytes: got %d bytes", n, len(got))
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	rw := &RemoteWriter{}
+	got := rw.encode([]Sample{{Name: "m", Value: 1, Time: time.Unix(0, 2e6)}})
+	want := []byte{
+		0x0a, 0x1c, // timeseries, 28 bytes
+		0x0a, 0x0d, // label, 13 bytes
+		0x0a, 0x08, '_', '_', 'n', 'a', 'm', 'e', '_', '_',
+		0x12, 0x01, 'm',
+		0x12, 0x0b, // sample, 11 bytes
+		0x09, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, // 1.0
+		0x10, 0x02, // 2ms
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("encode: got % x, want % x", got, want)
+	}
+}
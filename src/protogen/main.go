@@ -0,0 +1,461 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command protogen generates Go bindings for a proto3 schema, using package
+// protowire for the wire format instead of the protobuf runtime.
+//
+// It understands only what caplog.proto needs: messages with int64, uint64,
+// uint32, bool, string and bytes fields, repeated message fields and
+// map<string, string> fields. Anything else is an error, rather than
+// silently wrong code. Field names become Go names in CamelCase, with the
+// usual initialisms (src_ip is SrcIP).
+//
+// Usage:
+//
+//	protogen [-o out.go] schema.proto
+//
+// flowpb runs it with go generate.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// message is a parsed message declaration.
+type message struct {
+	Name   string
+	Doc    []string
+	Fields []field
+}
+
+// field is a parsed field declaration.
+type field struct {
+	Name     string // as in the schema
+	Type     string // scalar type or message name
+	Number   int
+	Repeated bool
+	Map      bool // map<string, string>
+	Doc      []string
+	Comment  string // trailing
+}
+
+var (
+	packageRE = regexp.MustCompile(`^package\s+([\w.]+)\s*;$`)
+	goPkgRE   = regexp.MustCompile(`^option\s+go_package\s*=\s*"([^"]+)"\s*;$`)
+	messageRE = regexp.MustCompile(`^message\s+(\w+)\s*\{$`)
+	fieldRE   = regexp.MustCompile(`^(repeated\s+)?(\w+)\s+(\w+)\s*=\s*(\d+)\s*;$`)
+	mapRE     = regexp.MustCompile(`^map\s*<\s*string\s*,\s*string\s*>\s+(\w+)\s*=\s*(\d+)\s*;$`)
+)
+
+// scalars are the supported scalar types.
+var scalars = map[string]bool{
+	"int64": true, "uint64": true, "uint32": true,
+	"bool": true, "string": true, "bytes": true,
+}
+
+// schema is a parsed .proto file.
+type schema struct {
+	Package   string // proto package
+	GoPackage string
+	Messages  []*message
+}
+
+// parse reads the subset of proto3 described in the package comment.
+func parse(r io.Reader) (*schema, error) {
+	s := new(schema)
+	var (
+		doc  []string
+		msg  *message
+		line int
+	)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line++
+		text, comment := strings.TrimSpace(sc.Text()), ""
+		if i := strings.Index(text, "//"); i >= 0 {
+			text, comment = strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+2:])
+		}
+		if text == "" {
+			if comment != "" {
+				doc = append(doc, comment)
+			} else {
+				doc = nil
+			}
+			continue
+		}
+		errorf := func(format string, args ...interface{}) error {
+			return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+		}
+		if msg == nil {
+			switch {
+			case strings.HasPrefix(text, "syntax"):
+				if text != `syntax = "proto3";` {
+					return nil, errorf("only proto3 is supported")
+				}
+			case packageRE.MatchString(text):
+				s.Package = packageRE.FindStringSubmatch(text)[1]
+			case goPkgRE.MatchString(text):
+				s.GoPackage = goPkgRE.FindStringSubmatch(text)[1]
+			case messageRE.MatchString(text):
+				msg = &message{Name: messageRE.FindStringSubmatch(text)[1], Doc: doc}
+			default:
+				return nil, errorf("unsupported declaration %q", text)
+			}
+			doc = nil
+			continue
+		}
+		var f field
+		switch {
+		case text == "}":
+			s.Messages = append(s.Messages, msg)
+			msg, doc = nil, nil
+			continue
+		case mapRE.MatchString(text):
+			m := mapRE.FindStringSubmatch(text)
+			f = field{Name: m[1], Type: "string", Map: true}
+			f.Number, _ = strconv.Atoi(m[2])
+		case fieldRE.MatchString(text):
+			m := fieldRE.FindStringSubmatch(text)
+			f = field{Name: m[3], Type: m[2], Repeated: m[1] != ""}
+			f.Number, _ = strconv.Atoi(m[4])
+		default:
+			return nil, errorf("unsupported field %q", text)
+		}
+		f.Doc, f.Comment = doc, comment
+		doc = nil
+		for _, g := range msg.Fields {
+			if g.Number == f.Number {
+				return nil, errorf("field number %d is used by %s", f.Number, g.Name)
+			}
+		}
+		if f.Number < 1 || f.Number > 1<<29-1 {
+			return nil, errorf("bad field number %d", f.Number)
+		}
+		msg.Fields = append(msg.Fields, f)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if msg != nil {
+		return nil, fmt.Errorf("message %s is not closed", msg.Name)
+	}
+	if s.GoPackage == "" {
+		return nil, fmt.Errorf("no go_package option")
+	}
+	// Check field types once all the messages are known.
+	known := make(map[string]bool)
+	for _, m := range s.Messages {
+		known[m.Name] = true
+	}
+	for _, m := range s.Messages {
+		for _, f := range m.Fields {
+			switch {
+			case f.Map, scalars[f.Type] && !f.Repeated:
+			case known[f.Type] && f.Repeated:
+			case known[f.Type]:
+				return nil, fmt.Errorf("%s.%s: only repeated message fields are supported", m.Name, f.Name)
+			case scalars[f.Type]:
+				return nil, fmt.Errorf("%s.%s: repeated scalar fields are not supported", m.Name, f.Name)
+			default:
+				return nil, fmt.Errorf("%s.%s: unsupported type %s", m.Name, f.Name, f.Type)
+			}
+		}
+	}
+	return s, nil
+}
+
+// initialisms are written in capitals in Go names.
+var initialisms = map[string]bool{
+	"id": true, "ip": true, "icmp": true, "mac": true, "rtt": true, "vlan": true,
+}
+
+// goName converts a snake_case schema name to a Go name.
+func goName(s string) string {
+	var b strings.Builder
+	for _, w := range strings.Split(s, "_") {
+		if initialisms[w] {
+			b.WriteString(strings.ToUpper(w))
+		} else if w != "" {
+			b.WriteString(strings.ToUpper(w[:1]) + w[1:])
+		}
+	}
+	return b.String()
+}
+
+// goType is the Go type of the field.
+func goType(f *field) string {
+	switch {
+	case f.Map:
+		return "map[string]string"
+	case f.Repeated:
+		return "[]" + f.Type
+	case f.Type == "bytes":
+		return "[]byte"
+	}
+	return f.Type
+}
+
+// generate writes the Go source for the schema.
+func generate(w io.Writer, s *schema, source string) error {
+	var b bytes.Buffer
+	p := func(format string, args ...interface{}) { fmt.Fprintf(&b, format+"\n", args...) }
+
+	p("// Code generated by protogen from %s. DO NOT EDIT.", source)
+	p("")
+	p("package %s", s.GoPackage)
+	p("")
+	var maps bool
+	for _, m := range s.Messages {
+		for _, f := range m.Fields {
+			maps = maps || f.Map
+		}
+	}
+	if maps {
+		p("import (")
+		p(`"sort"`)
+		p("")
+		p(`"protowire"`)
+		p(")")
+	} else {
+		p(`import "protowire"`)
+	}
+
+	for _, m := range s.Messages {
+		p("")
+		p("// %s is %s.%s.", m.Name, s.Package, m.Name)
+		if len(m.Doc) > 0 {
+			p("//")
+			for _, d := range m.Doc {
+				p("// %s", d)
+			}
+		}
+		p("type %s struct {", m.Name)
+		for i := range m.Fields {
+			f := &m.Fields[i]
+			if i > 0 && len(f.Doc) > 0 {
+				p("")
+			}
+			for _, d := range f.Doc {
+				p("// %s", d)
+			}
+			if f.Comment != "" {
+				p("%s %s // %s", goName(f.Name), goType(f), f.Comment)
+			} else {
+				p("%s %s", goName(f.Name), goType(f))
+			}
+		}
+		p("}")
+		genMarshal(p, m)
+		genUnmarshal(p, m)
+	}
+	if maps {
+		p("")
+		p("// marshalStringMap appends the map's entries, which are messages")
+		p("// {key = 1; value = 2}, in key order for determinism.")
+		p("func marshalStringMap(p *protowire.Buffer, field int, m map[string]string) {")
+		p("keys := make([]string, 0, len(m))")
+		p("for k := range m {")
+		p("keys = append(keys, k)")
+		p("}")
+		p("sort.Strings(keys)")
+		p("var entry protowire.Buffer")
+		p("for _, k := range keys {")
+		p("entry.Reset()")
+		p("entry.String(1, k)")
+		p("entry.String(2, m[k])")
+		p("p.Message(field, entry.B)")
+		p("}")
+		p("}")
+		p("")
+		p("// unmarshalStringMapEntry decodes a map entry in b into m.")
+		p("func unmarshalStringMapEntry(b []byte, m map[string]string) error {")
+		p("var k, v string")
+		p("d := protowire.NewDecoder(b)")
+		p("for !d.Done() {")
+		p("n, wt, err := d.Next()")
+		p("if err != nil {")
+		p("return err")
+		p("}")
+		p("if wt != protowire.Bytes || (n != 1 && n != 2) {")
+		p("if err := d.Skip(wt); err != nil {")
+		p("return err")
+		p("}")
+		p("continue")
+		p("}")
+		p("s, err := d.Bytes()")
+		p("if err != nil {")
+		p("return err")
+		p("}")
+		p("if n == 1 {")
+		p("k = string(s)")
+		p("} else {")
+		p("v = string(s)")
+		p("}")
+		p("}")
+		p("m[k] = v")
+		p("return nil")
+		p("}")
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting generated code: %v", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// genMarshal writes the message's Marshal method.
+func genMarshal(p func(string, ...interface{}), m *message) {
+	p("")
+	p("// Marshal appends the encoded message to b.")
+	p("func (x *%s) Marshal(b []byte) []byte {", m.Name)
+	p("p := protowire.Buffer{B: b}")
+	var buf bool
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		name := "x." + goName(f.Name)
+		switch {
+		case f.Map:
+			p("marshalStringMap(&p, %d, %s)", f.Number, name)
+		case f.Repeated:
+			if !buf {
+				p("var buf []byte")
+				buf = true
+			}
+			p("for i := range %s {", name)
+			p("buf = %s[i].Marshal(buf[:0])", name)
+			p("p.Message(%d, buf)", f.Number)
+			p("}")
+		case f.Type == "int64":
+			p("p.Int64(%d, %s)", f.Number, name)
+		case f.Type == "uint64":
+			p("p.Uint64(%d, %s)", f.Number, name)
+		case f.Type == "uint32":
+			p("p.Uint64(%d, uint64(%s))", f.Number, name)
+		case f.Type == "bool":
+			p("p.Bool(%d, %s)", f.Number, name)
+		case f.Type == "string":
+			p("p.String(%d, %s)", f.Number, name)
+		case f.Type == "bytes":
+			p("p.Bytes(%d, %s)", f.Number, name)
+		}
+	}
+	p("return p.B")
+	p("}")
+}
+
+// genUnmarshal writes the message's Unmarshal method.
+func genUnmarshal(p func(string, ...interface{}), m *message) {
+	p("")
+	p("// Unmarshal decodes the message in b. Byte fields alias b.")
+	p("func (x *%s) Unmarshal(b []byte) error {", m.Name)
+	p("*x = %s{}", m.Name)
+	p("d := protowire.NewDecoder(b)")
+	p("for !d.Done() {")
+	p("n, wt, err := d.Next()")
+	p("if err != nil {")
+	p("return err")
+	p("}")
+	p("switch {")
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		name := "x." + goName(f.Name)
+		varint := !f.Map && !f.Repeated && f.Type != "string" && f.Type != "bytes"
+		if varint {
+			p("case n == %d && wt == protowire.Varint:", f.Number)
+			p("v, err := d.Uvarint()")
+		} else {
+			p("case n == %d && wt == protowire.Bytes:", f.Number)
+			p("v, err := d.Bytes()")
+		}
+		p("if err != nil {")
+		p("return err")
+		p("}")
+		switch {
+		case f.Map:
+			p("if %s == nil {", name)
+			p("%s = make(map[string]string)", name)
+			p("}")
+			p("if err := unmarshalStringMapEntry(v, %s); err != nil {", name)
+			p("return err")
+			p("}")
+		case f.Repeated:
+			p("var m %s", f.Type)
+			p("if err := m.Unmarshal(v); err != nil {")
+			p("return err")
+			p("}")
+			p("%s = append(%s, m)", name, name)
+		case f.Type == "int64", f.Type == "uint32":
+			p("%s = %s(v)", name, f.Type)
+		case f.Type == "bool":
+			p("%s = v != 0", name)
+		case f.Type == "string":
+			p("%s = string(v)", name)
+		default: // uint64, bytes
+			p("%s = v", name)
+		}
+	}
+	p("default:")
+	p("if err := d.Skip(wt); err != nil {")
+	p("return err")
+	p("}")
+	p("}")
+	p("}")
+	p("return nil")
+	p("}")
+}
+
+func main() {
+	out := flag.String("o", "", "write the Go code to this `file` (default standard output)")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: protogen [-o out.go] schema.proto")
+		os.Exit(2)
+	}
+	in := flag.Arg(0)
+	f, err := os.Open(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := parse(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %v", in, err)
+	}
+	var b bytes.Buffer
+	if err := generate(&b, s, filepath.Base(in)); err != nil {
+		log.Fatalf("%s: %v", in, err)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(b.Bytes())
+	} else {
+		err = ioutil.WriteFile(*out, b.Bytes(), 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGeneratedUpToDate(t *testing.T) {
+	f, err := os.Open("../flowpb/caplog.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s, err := parse(f)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var got bytes.Buffer
+	if err := generate(&got, s, "caplog.proto"); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	want, err := ioutil.ReadFile("../flowpb/caplog.pb.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Error(`flowpb/caplog.pb.go is out of date with caplog.proto: run "go generate flowpb"`)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, bad := range []string{
+		`syntax = "proto2";`,
+		"message M {\n  float x = 1;\n}",
+		"message M {\n  string x = 1;\n  string y = 1;\n}",
+		"message M {\n  repeated string x = 1;\n}",
+		"message M {\n  N n = 1;\n}\nmessage N {\n}",
+		"message M {\n  string x = 1;",
+		"enum E {",
+	} {
+		if _, err := parse(strings.NewReader(`option go_package = "p";` + "\n" + bad)); err == nil {
+			t.Errorf("parse(%q): got nil error", bad)
+		}
+	}
+}
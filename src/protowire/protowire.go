@@ -0,0 +1,181 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package protowire has just enough protocol buffer wire format encoding and
+// decoding for caplog's few message types, to avoid depending on the protobuf
+// runtime.
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Wire types.
+const (
+	Varint  = 0
+	Fixed64 = 1
+	Bytes   = 2
+	Fixed32 = 5
+)
+
+var errTruncated = errors.New("protowire: truncated message")
+
+// Buffer accumulates an encoded message.
+type Buffer struct {
+	B []byte
+}
+
+// Reset empties the buffer, keeping its storage.
+func (p *Buffer) Reset() { p.B = p.B[:0] }
+
+func (p *Buffer) tag(field, wireType int) {
+	p.B = binary.AppendUvarint(p.B, uint64(field<<3|wireType))
+}
+
+// Uint64 appends a varint field. Zero values are omitted, as in proto3.
+func (p *Buffer) Uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	p.tag(field, Varint)
+	p.B = binary.AppendUvarint(p.B, v)
+}
+
+// Int64 appends an int64 (not sint64) varint field.
+func (p *Buffer) Int64(field int, v int64) { p.Uint64(field, uint64(v)) }
+
+// Bool appends a bool field.
+func (p *Buffer) Bool(field int, v bool) {
+	if v {
+		p.Uint64(field, 1)
+	}
+}
+
+// Double appends a double field.
+func (p *Buffer) Double(field int, v float64) {
+	if v == 0 {
+		return
+	}
+	p.tag(field, Fixed64)
+	p.B = binary.LittleEndian.AppendUint64(p.B, math.Float64bits(v))
+}
+
+// Bytes appends a length-delimited field.
+func (p *Buffer) Bytes(field int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	p.Message(field, b)
+}
+
+// Message appends an embedded message, even if it is empty.
+func (p *Buffer) Message(field int, b []byte) {
+	p.tag(field, Bytes)
+	p.B = binary.AppendUvarint(p.B, uint64(len(b)))
+	p.B = append(p.B, b...)
+}
+
+// String appends a string field.
+func (p *Buffer) String(field int, s string) {
+	if s == "" {
+		return
+	}
+	p.tag(field, Bytes)
+	p.B = binary.AppendUvarint(p.B, uint64(len(s)))
+	p.B = append(p.B, s...)
+}
+
+// Decoder reads fields from an encoded message.
+type Decoder struct {
+	b []byte
+}
+
+// NewDecoder returns a decoder for the message in b.
+func NewDecoder(b []byte) *Decoder { return &Decoder{b} }
+
+// Done reports whether every field has been read.
+func (d *Decoder) Done() bool { return len(d.b) == 0 }
+
+// Next reads the next field number and wire type.
+func (d *Decoder) Next() (field, wireType int, err error) {
+	v, err := d.Uvarint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(v >> 3), int(v & 7), nil
+}
+
+// Uvarint reads a varint value.
+func (d *Decoder) Uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.b = d.b[n:]
+	return v, nil
+}
+
+// Fixed64 reads a 64-bit value.
+func (d *Decoder) Fixed64() (uint64, error) {
+	if len(d.b) < 8 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint64(d.b)
+	d.b = d.b[8:]
+	return v, nil
+}
+
+// Double reads a double value.
+func (d *Decoder) Double() (float64, error) {
+	v, err := d.Fixed64()
+	return math.Float64frombits(v), err
+}
+
+// Bytes reads a length-delimited value. The result aliases the input.
+func (d *Decoder) Bytes() ([]byte, error) {
+	n, err := d.Uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(d.b)) < n {
+		return nil, errTruncated
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b, nil
+}
+
+// Skip skips over a value of an unknown field.
+func (d *Decoder) Skip(wireType int) error {
+	var err error
+	switch wireType {
+	case Varint:
+		_, err = d.Uvarint()
+	case Fixed64:
+		_, err = d.Fixed64()
+	case Bytes:
+		_, err = d.Bytes()
+	case Fixed32:
+		if len(d.b) < 4 {
+			return errTruncated
+		}
+		d.b = d.b[4:]
+	default:
+		return fmt.Errorf("protowire: unsupported wire type %d", wireType)
+	}
+	return err
+}
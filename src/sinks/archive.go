@@ -0,0 +1,447 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file archives packets in object storage (S3, GCS, or an S3-compatible
+// store such as MinIO), as gzipped JSON Lines objects under time-partitioned
+// prefixes, for cheap long-term retention of the raw data.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"config"
+	"packets"
+	"vars"
+)
+
+const (
+	// DefaultArchiveLayout partitions objects by day and hour, as Hive,
+	// Spark, Athena and BigQuery external tables expect.
+	DefaultArchiveLayout = "dt=2006-01-02/hour=15"
+
+	archiveNameTime = "20060102T150405Z"
+	gcsScope        = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsEndpoint     = "https://storage.googleapis.com"
+)
+
+func init() {
+	Register("archive", func(c *config.Config) (packets.Sink, error) {
+		ac := c.Outputs.Archive
+		if ac.URL == "" {
+			return nil, nil
+		}
+		// The records are packets.Metadata in JSON, with RFC 3339 times.
+		if _, err := timeFormat(c, "archive", RFC3339); err != nil {
+			return nil, err
+		}
+		u, err := url.Parse(ac.URL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("outputs.archive.url must be s3://bucket/prefix or gs://bucket/prefix, not %q", ac.URL)
+		}
+		seq, err := OpenSequence(SequencePath(c.StateDir, "archive"))
+		if err != nil {
+			return nil, err
+		}
+		w := &ArchiveWriter{
+			Prefix:   strings.Trim(u.Path, "/"),
+			Layout:   ac.Layout,
+			ProbeID:  c.ProbeName(),
+			Interval: ac.Interval.Duration,
+			MaxSize:  ac.MaxMB << 20,
+			Sequence: seq,
+		}
+		switch u.Scheme {
+		case "s3":
+			s := &S3Store{
+				Bucket:   u.Host,
+				Region:   ac.Region,
+				Endpoint: ac.Endpoint,
+				Credentials: awsCredentials{
+					AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+					SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+					SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+				},
+			}
+			if s.Credentials.AccessKey == "" || s.Credentials.SecretKey == "" {
+				return nil, fmt.Errorf("outputs.archive: $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY must be set for s3")
+			}
+			w.Put = s.Put
+		case "gs":
+			p := ac.Credentials
+			if p == "" {
+				p = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+			}
+			if p == "" {
+				return nil, fmt.Errorf("outputs.archive.credentials (or $GOOGLE_APPLICATION_CREDENTIALS) must name a service account key file for gs")
+			}
+			sa, err := loadServiceAccount(p, gcsScope)
+			if err != nil {
+				return nil, err
+			}
+			w.Put = (&GCSStore{Bucket: u.Host, Token: sa.Token}).Put
+		default:
+			return nil, fmt.Errorf("outputs.archive.url must be s3://bucket/prefix or gs://bucket/prefix, not %q", ac.URL)
+		}
+		vars.Register("sink-archive-uploads", vars.Uint64Eval(func() uint64 { return atomic.LoadUint64(&w.stats.uploads) }).String)
+		vars.Register("sink-archive-upload-errors", vars.Uint64Eval(func() uint64 { return atomic.LoadUint64(&w.stats.errors) }).String)
+		vars.Register("sink-archive-upload-bytes", vars.Uint64Eval(func() uint64 { return atomic.LoadUint64(&w.stats.bytes) }).String)
+		vars.Register("sink-archive-last-upload", vars.Int64Eval(func() int64 { return atomic.LoadInt64(&w.stats.last) }).String)
+		vars.Register("sink-archive-pending", vars.IntEval(w.Pending).String)
+		q, err := queued(c, "archive", IgnoreKey(w.Write))
+		if err != nil {
+			return nil, err
+		}
+		done := make(chan struct{})
+		go w.run(done)
+		return &archiveSink{Sink: q, w: w, done: done}, nil
+	})
+}
+
+// archiveSink is the queue in front of an ArchiveWriter, which also uploads
+// the writer's objects when flushed.
+type archiveSink struct {
+	packets.Sink
+	w         *ArchiveWriter
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// queue returns the queue in front of the writer, for Open to share.
+func (s *archiveSink) queue() *Queue {
+	q, _ := s.Sink.(*Queue)
+	return q
+}
+
+// Flush waits for the queue, and then uploads every object.
+func (s *archiveSink) Flush(ctx context.Context) error {
+	if err := s.Sink.Flush(ctx); err != nil {
+		return err
+	}
+	return s.w.Flush(ctx)
+}
+
+// Close uploads every object, and stops the queue and writer.
+func (s *archiveSink) Close() error {
+	err := s.Flush(context.Background())
+	s.closeOnce.Do(func() { close(s.done) })
+	if cerr := s.Sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// archiveObject is an object being put together, or waiting to be uploaded.
+type archiveObject struct {
+	partition string
+	opened    time.Time
+	name      string // set when sealed
+	buf       bytes.Buffer
+	gz        *gzip.Writer
+	enc       *json.Encoder
+}
+
+// ArchiveWriter collects packets into gzipped JSON Lines objects, one for
+// each partition (the packets' time in UTC, formatted with Layout), and
+// uploads each with Put once it is Interval old or MaxSize big, or the writer
+// is flushed.
+//
+// Objects are named <Prefix>/<partition>/<ProbeID>-<time>-<sequence>.jsonl.gz,
+// which sort by time, and never replace each other. An object whose upload
+// fails is kept, and uploaded again before any more packets are taken, so
+// the queue in front retries the buffer rather than the packets being
+// archived twice.
+type ArchiveWriter struct {
+	// stats are accessed atomically, and so are first, to be aligned on
+	// 32-bit platforms.
+	stats struct {
+		uploads, errors, bytes uint64
+		last                   int64 // Unix time of the last upload
+	}
+
+	Prefix   string
+	Layout   string // DefaultArchiveLayout if empty
+	ProbeID  string
+	Interval time.Duration
+	MaxSize  int
+
+	// Sequence numbers the objects. If nil, they are numbered from 1.
+	Sequence *Sequence
+
+	// Put uploads an object.
+	Put func(ctx context.Context, name string, body []byte) error
+
+	mu      sync.Mutex
+	open    map[string]*archiveObject // by partition
+	pending []*archiveObject          // sealed, in upload order
+	failing bool
+	now     func() time.Time // time.Now, unless testing
+}
+
+// Pending returns the number of sealed objects not yet uploaded.
+func (w *ArchiveWriter) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending)
+}
+
+func (w *ArchiveWriter) clock() time.Time {
+	if w.now != nil {
+		return w.now()
+	}
+	return time.Now()
+}
+
+// Write adds a buffer of packet metadata to the objects, first uploading
+// any that failed before. It uploads the objects the buffer fills, but if
+// that fails, they are only retried (and the error returned) at the next
+// Write or Flush, as the buffer itself has been taken.
+func (w *ArchiveWriter) Write(data []packets.Metadata) error {
+	ctx := context.Background()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.upload(ctx); err != nil {
+		return err
+	}
+	layout := w.Layout
+	if layout == "" {
+		layout = DefaultArchiveLayout
+	}
+	now := w.clock()
+	for i := range data {
+		m := &data[i]
+		part := m.Timestamp.UTC().Format(layout)
+		o := w.open[part]
+		if o == nil {
+			o = &archiveObject{partition: part, opened: now}
+			o.gz = gzip.NewWriter(&o.buf)
+			o.enc = json.NewEncoder(o.gz)
+			if w.open == nil {
+				w.open = make(map[string]*archiveObject)
+			}
+			w.open[part] = o
+		}
+		if err := o.enc.Encode(m); err != nil {
+			return err
+		}
+		if w.MaxSize > 0 && o.buf.Len() >= w.MaxSize {
+			w.seal(o)
+		}
+	}
+	w.sealOlder(now.Add(-w.Interval))
+	w.upload(ctx) // if it fails, the next Write returns the error
+	return nil
+}
+
+// Flush uploads every object, including those still collecting packets.
+func (w *ArchiveWriter) Flush(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sealOlder(time.Time{})
+	return w.upload(ctx)
+}
+
+// run uploads the objects that are Interval old, while no packets are
+// coming to do it, until done is closed.
+func (w *ArchiveWriter) run(done chan struct{}) {
+	tick := time.Minute
+	if w.Interval > 0 && w.Interval < tick {
+		tick = w.Interval
+	}
+	t := time.NewTicker(tick)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		w.mu.Lock()
+		w.sealOlder(w.clock().Add(-w.Interval))
+		w.upload(context.Background())
+		w.mu.Unlock()
+	}
+}
+
+// sealOlder seals the objects opened before t (or all of them, if t is
+// zero), oldest first, so they are numbered in order. w.mu must be held.
+func (w *ArchiveWriter) sealOlder(t time.Time) {
+	var old []*archiveObject
+	for _, o := range w.open {
+		if t.IsZero() || o.opened.Before(t) {
+			old = append(old, o)
+		}
+	}
+	sort.Slice(old, func(i, j int) bool {
+		if !old[i].opened.Equal(old[j].opened) {
+			return old[i].opened.Before(old[j].opened)
+		}
+		return old[i].partition < old[j].partition
+	})
+	for _, o := range old {
+		w.seal(o)
+	}
+}
+
+// seal finishes an object, names it, and queues it for upload. w.mu must be
+// held.
+func (w *ArchiveWriter) seal(o *archiveObject) {
+	delete(w.open, o.partition)
+	o.gz.Close()
+	if w.Sequence == nil {
+		w.Sequence = new(Sequence)
+	}
+	n := w.Sequence.Next()
+	w.Sequence.written("archive", n)
+	file := w.ProbeID + "-" + o.opened.UTC().Format(archiveNameTime) + "-" + strconv.FormatUint(n, 10) + ".jsonl.gz"
+	o.name = path.Join(w.Prefix, o.partition, file)
+	w.pending = append(w.pending, o)
+}
+
+// upload uploads the sealed objects in order, stopping at the first that
+// fails. w.mu must be held.
+func (w *ArchiveWriter) upload(ctx context.Context) error {
+	for len(w.pending) > 0 {
+		o := w.pending[0]
+		if err := w.Put(ctx, o.name, o.buf.Bytes()); err != nil {
+			atomic.AddUint64(&w.stats.errors, 1)
+			if !w.failing {
+				log.Printf("archive: %v", err)
+				w.failing = true
+			}
+			return err
+		}
+		w.failing = false
+		atomic.AddUint64(&w.stats.uploads, 1)
+		atomic.AddUint64(&w.stats.bytes, uint64(o.buf.Len()))
+		atomic.StoreInt64(&w.stats.last, w.clock().Unix())
+		w.pending[0] = nil
+		w.pending = w.pending[1:]
+	}
+	w.pending = nil
+	return nil
+}
+
+// S3Store puts objects in an S3 bucket, or a bucket of an S3-compatible
+// store.
+type S3Store struct {
+	Bucket string
+	Region string // "us-east-1" if empty
+
+	// Endpoint is the base URL of an S3-compatible store, whose buckets
+	// are addressed by path. If empty, AWS's virtual-hosted URLs are used.
+	Endpoint string
+
+	Credentials awsCredentials
+
+	// Client, if set, is used instead of http.DefaultClient.
+	Client *http.Client
+}
+
+// Put uploads an object.
+func (s *S3Store) Put(ctx context.Context, name string, body []byte) error {
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	base := "https://" + s.Bucket + ".s3." + region + ".amazonaws.com"
+	key := "/" + name
+	if s.Endpoint != "" {
+		base, key = strings.TrimRight(s.Endpoint, "/"), "/"+s.Bucket+"/"+name
+	}
+	req, err := http.NewRequest("PUT", base, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.URL.Path, req.URL.RawPath = key, awsURIEncode(key, false)
+	req.Header.Set("Content-Type", "application/gzip")
+	sum := sha256.Sum256(body)
+	s.Credentials.sign(req, region, "s3", hex.EncodeToString(sum[:]), time.Now())
+	return putObject(ctx, s.Client, req, "S3")
+}
+
+// GCSStore puts objects in a Google Cloud Storage bucket, with its XML API.
+type GCSStore struct {
+	Bucket string
+
+	// Token returns an OAuth2 access token for the requests.
+	Token func() (string, error)
+
+	// Endpoint is the API's base URL, if not the usual one. Client, if
+	// set, is used instead of http.DefaultClient.
+	Endpoint string
+	Client   *http.Client
+}
+
+// Put uploads an object.
+func (s *GCSStore) Put(ctx context.Context, name string, body []byte) error {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = gcsEndpoint
+	}
+	req, err := http.NewRequest("PUT", strings.TrimRight(endpoint, "/"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	key := "/" + s.Bucket + "/" + name
+	req.URL.Path, req.URL.RawPath = key, awsURIEncode(key, false)
+	req.Header.Set("Content-Type", "application/gzip")
+	if s.Token != nil {
+		token, err := s.Token()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return putObject(ctx, s.Client, req, "GCS")
+}
+
+// putObject sends a PUT of an object, and checks its response.
+func putObject(ctx context.Context, client *http.Client, req *http.Request, store string) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s put %s: %s: %s", store, req.URL.Path, resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
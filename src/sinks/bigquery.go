@@ -0,0 +1,403 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file streams flow records (see packets.FlowTable) into a BigQuery
+// table, with the Storage Write API's default stream. The API is gRPC only, so requests are framed by hand
+// and sent over HTTP/2, with the row type described by a protobuf descriptor
+// (also by hand; see package protowire).
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"config"
+	"packets"
+	"protowire"
+)
+
+const (
+	bigQueryEndpoint = "https://bigquerystorage.googleapis.com"
+	bigQueryScope    = "https://www.googleapis.com/auth/bigquery.insertdata"
+	appendRowsMethod = "/google.cloud.bigquery.storage.v1.BigQueryWrite/AppendRows"
+
+	// maxAppendRequest bounds the rows sent in one request; the API allows
+	// 10 MB.
+	maxAppendRequest = 8 << 20
+
+	// maxBigQueryPending bounds the records kept while they can't be
+	// appended.
+	maxBigQueryPending = 100000
+)
+
+func init() {
+	RegisterFlows("bigquery", func(c *config.Config) (FlowSink, error) {
+		bc := c.Outputs.BigQuery
+		if bc.Dataset == "" {
+			return nil, nil
+		}
+		// TIMESTAMP columns take microseconds.
+		if _, err := timeFormat(c, "bigquery", Microseconds); err != nil {
+			return nil, err
+		}
+		path := bc.Credentials
+		if path == "" {
+			path = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		}
+		if path == "" {
+			return nil, fmt.Errorf("outputs.bigquery.credentials (or $GOOGLE_APPLICATION_CREDENTIALS) must name a service account key file")
+		}
+		sa, err := loadServiceAccount(path, bigQueryScope)
+		if err != nil {
+			return nil, err
+		}
+		w := &BigQueryWriter{
+			Project: bc.Project,
+			Dataset: bc.Dataset,
+			Table:   bc.Table,
+			ProbeID: c.ProbeName(),
+			Token:   sa.Token,
+		}
+		if w.Project == "" {
+			w.Project = sa.ProjectID
+		}
+		if w.Project == "" {
+			return nil, fmt.Errorf("outputs.bigquery.project is required (the key file doesn't say)")
+		}
+		if w.Table == "" {
+			w.Table = "flows"
+		}
+		return w, nil
+	})
+}
+
+// bigQueryColumns are the table's columns, which are the fields of the rows'
+// protobuf type, numbered from 1. Integers are INT64 columns, except start
+// and end, which are TIMESTAMPs (in microseconds).
+var bigQueryColumns = []struct {
+	name   string
+	string bool // STRING, or else INT64
+}{
+	{"start", false},
+	{"end", false},
+	{"src_ip", true},
+	{"dst_ip", true},
+	{"src_name", true},
+	{"dst_name", true},
+	{"src_port", false},
+	{"dst_port", false},
+	{"protocol", false},
+	{"family", true},
+	{"vlan", false},
+	{"app_protocol", true},
+	{"src_packets", false},
+	{"src_bytes", false},
+	{"dst_packets", false},
+	{"dst_bytes", false},
+	{"reason", true},
+	{"probe", true},
+}
+
+// bigQueryDescriptor is the rows' type, as a google.protobuf.DescriptorProto.
+var bigQueryDescriptor = func() []byte {
+	const (
+		typeInt64      = 3
+		typeString     = 9
+		labelOptional  = 1
+		fieldName      = 1
+		fieldNumber    = 3
+		fieldLabel     = 4
+		fieldType      = 5
+		descriptorName = 1
+		descriptorFld  = 2
+	)
+	var d, f protowire.Buffer
+	d.String(descriptorName, "Flow")
+	for i, col := range bigQueryColumns {
+		f.Reset()
+		f.String(fieldName, col.name)
+		f.Uint64(fieldNumber, uint64(i+1))
+		f.Uint64(fieldLabel, labelOptional)
+		if col.string {
+			f.Uint64(fieldType, typeString)
+		} else {
+			f.Uint64(fieldType, typeInt64)
+		}
+		d.Message(descriptorFld, f.B)
+	}
+	return d.B
+}()
+
+// appendBigQueryRow appends a flow record as a row, in the type described by
+// bigQueryDescriptor. Zero values are omitted, so they are NULL.
+func appendBigQueryRow(b []byte, f *packets.Flow, probe string) []byte {
+	p := protowire.Buffer{B: b}
+	p.Int64(1, f.Start.UnixNano()/1e3)
+	p.Int64(2, f.End.UnixNano()/1e3)
+	p.String(3, f.SrcIP.String())
+	p.String(4, f.DstIP.String())
+	p.String(5, f.SrcName)
+	p.String(6, f.DstName)
+	p.Uint64(7, uint64(f.SrcPort))
+	p.Uint64(8, uint64(f.DstPort))
+	p.Uint64(9, uint64(f.Protocol))
+	if f.V6 {
+		p.String(10, "v6")
+	} else {
+		p.String(10, "v4")
+	}
+	p.Uint64(11, uint64(f.VLAN))
+	p.String(12, f.AppProtocol)
+	p.Uint64(13, f.SrcPackets)
+	p.Uint64(14, f.SrcBytes)
+	p.Uint64(15, f.DstPackets)
+	p.Uint64(16, f.DstBytes)
+	p.String(17, f.Reason)
+	p.String(18, probe)
+	return p.B
+}
+
+// BigQueryWriter appends flow records to a table through the default stream
+// of the Storage Write API. The default stream is at-least-once: a request
+// that times out after the rows were appended may be sent again.
+type BigQueryWriter struct {
+	Project, Dataset, Table string
+
+	// ProbeID fills the probe column.
+	ProbeID string
+
+	// Token returns an OAuth2 access token for the requests.
+	Token func() (string, error)
+
+	// Endpoint is the API's base URL, if not the usual one. Client, if
+	// set, is used instead of http.DefaultClient; it must speak HTTP/2.
+	Endpoint string
+	Client   *http.Client
+
+	// maxRequest bounds the rows in one request, if not maxAppendRequest.
+	maxRequest int
+
+	mu      sync.Mutex
+	pending []packets.Flow // not yet appended
+}
+
+// stream is the name of the table's default stream.
+func (w *BigQueryWriter) stream() string {
+	return "projects/" + w.Project + "/datasets/" + w.Dataset + "/tables/" + w.Table + "/streams/_default"
+}
+
+// Write appends the records to the table, after any left from a write that
+// failed. They are sent in requests of up to maxAppendRequest bytes. If a
+// request fails, its records and those after it are kept, to be sent first
+// by the next Write (up to maxBigQueryPending, dropping the oldest); the
+// requests before it aren't sent again.
+func (w *BigQueryWriter) Write(flows []packets.Flow) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, flows...)
+	var dropped error
+	if n := len(w.pending) - maxBigQueryPending; n > 0 {
+		w.pending = append(w.pending[:0], w.pending[n:]...)
+		dropped = fmt.Errorf("BigQuery: dropped %d records that couldn't be appended", n)
+	}
+	if err := w.appendPending(); err != nil {
+		return err
+	}
+	return dropped
+}
+
+// Close appends any records left from a write that failed.
+func (w *BigQueryWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.appendPending()
+}
+
+// appendPending appends the pending records, forgetting each request's as
+// it succeeds. w.mu must be held.
+func (w *BigQueryWriter) appendPending() error {
+	max := w.maxRequest
+	if max == 0 {
+		max = maxAppendRequest
+	}
+	var rows, row protowire.Buffer
+	first := 0 // the first record in rows
+	for i := range w.pending {
+		row.B = appendBigQueryRow(row.B[:0], &w.pending[i], w.ProbeID)
+		if len(rows.B) > 0 && len(rows.B)+len(row.B) > max {
+			if err := w.appendRows(rows.B); err != nil {
+				w.pending = append(w.pending[:0], w.pending[first:]...)
+				return err
+			}
+			rows.Reset()
+			first = i
+		}
+		rows.Message(1, row.B) // ProtoRows.serialized_rows
+	}
+	if len(rows.B) > 0 {
+		if err := w.appendRows(rows.B); err != nil {
+			w.pending = append(w.pending[:0], w.pending[first:]...)
+			return err
+		}
+	}
+	w.pending = w.pending[:0]
+	return nil
+}
+
+// appendRows sends one AppendRowsRequest with the encoded ProtoRows, and
+// checks its response.
+func (w *BigQueryWriter) appendRows(rows []byte) error {
+	var schema, data, req protowire.Buffer
+	schema.Message(1, bigQueryDescriptor) // ProtoSchema.proto_descriptor
+	data.Message(1, schema.B)             // ProtoData.writer_schema
+	data.Message(2, rows)                 // ProtoData.rows
+	req.String(1, w.stream())             // AppendRowsRequest.write_stream
+	req.Message(4, data.B)                // AppendRowsRequest.proto_rows
+	req.String(6, "caplog")               // AppendRowsRequest.trace_id
+
+	// A gRPC message: uncompressed, with a 4-byte length.
+	body := make([]byte, 5, 5+len(req.B))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(req.B)))
+	body = append(body, req.B...)
+
+	endpoint := w.Endpoint
+	if endpoint == "" {
+		endpoint = bigQueryEndpoint
+	}
+	hr, err := http.NewRequest("POST", strings.TrimRight(endpoint, "/")+appendRowsMethod, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hr.Header.Set("Content-Type", "application/grpc")
+	hr.Header.Set("TE", "trailers")
+	hr.Header.Set("X-Goog-Request-Params", "write_stream="+url.QueryEscape(w.stream()))
+	if w.Token != nil {
+		token, err := w.Token()
+		if err != nil {
+			return err
+		}
+		hr.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(hr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("BigQuery append: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	msgs, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("BigQuery append: %v", err)
+	}
+	// The status is in the trailers, or in the headers if there is no
+	// response message.
+	status := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		if m, err := url.PathUnescape(message); err == nil {
+			message = m
+		}
+		return fmt.Errorf("BigQuery append: gRPC status %s: %s", status, message)
+	}
+	for len(msgs) >= 5 {
+		n := int(binary.BigEndian.Uint32(msgs[1:5]))
+		if len(msgs) < 5+n {
+			break
+		}
+		if err := appendRowsError(msgs[5 : 5+n]); err != nil {
+			return fmt.Errorf("BigQuery append: %v", err)
+		}
+		msgs = msgs[5+n:]
+	}
+	return nil
+}
+
+// appendRowsError returns the error in an AppendRowsResponse, if any: the
+// error status, or the first of the row errors.
+func appendRowsError(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for !d.Done() {
+		f, wt, err := d.Next()
+		if err != nil {
+			return err
+		}
+		if (f != 2 && f != 4) || wt != protowire.Bytes {
+			if err := d.Skip(wt); err != nil {
+				return err
+			}
+			continue
+		}
+		msg, err := d.Bytes()
+		if err != nil {
+			return err
+		}
+		// google.rpc.Status is code (1) and message (2); RowError is
+		// index (1), code (2) and message (3).
+		codeField, msgField := 1, 2
+		if f == 4 {
+			codeField, msgField = 2, 3
+		}
+		var (
+			index, code uint64
+			text        string
+		)
+		md := protowire.NewDecoder(msg)
+		for !md.Done() {
+			mf, mwt, err := md.Next()
+			if err != nil {
+				return err
+			}
+			switch {
+			case mf == codeField && mwt == protowire.Varint:
+				code, err = md.Uvarint()
+			case mf == msgField && mwt == protowire.Bytes:
+				var s []byte
+				s, err = md.Bytes()
+				text = string(s)
+			case f == 4 && mf == 1 && mwt == protowire.Varint:
+				index, err = md.Uvarint()
+			default:
+				err = md.Skip(mwt)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if f == 4 {
+			return fmt.Errorf("row %d: %s (code %d)", index, text, code)
+		}
+		return fmt.Errorf("%s (code %d)", text, code)
+	}
+	return nil
+}
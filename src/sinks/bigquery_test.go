@@ -0,0 +1,300 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"packets"
+	"protowire"
+)
+
+// protoFields decodes a message's fields, for checking: varints as uint64,
+// and length-delimited fields as []byte. Repeated fields keep the last.
+func protoFields(t *testing.T, b []byte) map[int]interface{} {
+	fields := make(map[int]interface{})
+	d := protowire.NewDecoder(b)
+	for !d.Done() {
+		f, wt, err := d.Next()
+		if err != nil {
+			t.Fatalf("decoding: %v", err)
+		}
+		switch wt {
+		case protowire.Varint:
+			fields[f], err = d.Uvarint()
+		case protowire.Bytes:
+			fields[f], err = d.Bytes()
+		default:
+			err = d.Skip(wt)
+		}
+		if err != nil {
+			t.Fatalf("decoding: %v", err)
+		}
+	}
+	return fields
+}
+
+// grpcServer answers AppendRows with the status answer returns, after
+// checking the request and decoding its rows.
+func grpcServer(t *testing.T, answer func(rows []map[int]interface{}) (status, message string)) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != appendRowsMethod || r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" {
+			t.Errorf("request: %s %s %s (%s)", r.Method, r.URL.Path, r.Proto, r.Header.Get("Content-Type"))
+		}
+		if got, want := r.Header.Get("Authorization"), "Bearer tok"; got != want {
+			t.Errorf("Authorization: got %q, want %q", got, want)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+			t.Fatalf("body isn't one gRPC message: % x", body)
+		}
+		req := protoFields(t, body[5:])
+		if got, want := string(req[1].([]byte)), "projects/p/datasets/d/tables/t/streams/_default"; got != want {
+			t.Errorf("write_stream: got %q, want %q", got, want)
+		}
+		data := protoFields(t, req[4].([]byte))
+		schema := protoFields(t, data[1].([]byte))
+		if got := schema[1].([]byte); string(got) != string(bigQueryDescriptor) {
+			t.Errorf("proto_descriptor: got % x", got)
+		}
+		var rows []map[int]interface{}
+		d := protowire.NewDecoder(data[2].([]byte))
+		for !d.Done() {
+			d.Next()
+			b, _ := d.Bytes()
+			rows = append(rows, protoFields(t, b))
+		}
+		status, message := answer(rows)
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Write([]byte{0, 0, 0, 0, 2, 0x0a, 0}) // append_result {}
+		w.Header().Set("Grpc-Status", status)
+		w.Header().Set("Grpc-Message", message)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	return srv
+}
+
+// testBigQueryWriter returns a writer to the server.
+func testBigQueryWriter(srv *httptest.Server) *BigQueryWriter {
+	return &BigQueryWriter{
+		Project: "p", Dataset: "d", Table: "t", ProbeID: "router",
+		Token:    func() (string, error) { return "tok", nil },
+		Endpoint: srv.URL,
+		Client:   srv.Client(),
+	}
+}
+
+func TestBigQueryWriter(t *testing.T) {
+	var got []map[int]interface{}
+	srv := grpcServer(t, func(rows []map[int]interface{}) (string, string) {
+		got = append(got, rows...)
+		return "0", ""
+	})
+	defer srv.Close()
+	w := testBigQueryWriter(srv)
+	f := testFlow
+	f.SrcName, f.DstName, f.AppProtocol = "10.0.0.2", `dns "google"`, packets.AppQUIC
+	if err := w.Write([]packets.Flow{testFlow, f}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("rows: got %d, want 2", len(got))
+	}
+	row := got[1]
+	want := map[int]interface{}{
+		1: uint64(1434055562000000), 2: uint64(1434055562020000), 3: []byte("10.0.0.2"), 4: []byte("8.8.8.8"),
+		5: []byte("10.0.0.2"), 6: []byte(`dns "google"`), 7: uint64(5353), 8: uint64(53),
+		9: uint64(17), 10: []byte("v4"), 12: []byte("quic"), 13: uint64(1), 14: uint64(74),
+		15: uint64(1), 16: uint64(90), 17: []byte("idle"), 18: []byte("router"),
+	}
+	for f, v := range want {
+		if b, ok := v.([]byte); ok {
+			if got, _ := row[f].([]byte); string(got) != string(b) {
+				t.Errorf("row field %d (%s): got %q, want %q", f, bigQueryColumns[f-1].name, got, b)
+			}
+		} else if row[f] != v {
+			t.Errorf("row field %d (%s): got %v, want %v", f, bigQueryColumns[f-1].name, row[f], v)
+		}
+	}
+	if _, ok := row[11]; ok {
+		t.Errorf("row field 11 (vlan): got %v, want NULL", row[11])
+	}
+}
+
+func TestBigQueryWriterError(t *testing.T) {
+	srv := grpcServer(t, func([]map[int]interface{}) (string, string) {
+		return "3", "Field%20reason%20not%20found"
+	})
+	defer srv.Close()
+	w := testBigQueryWriter(srv)
+	err := w.Write([]packets.Flow{testFlow})
+	if err == nil || !strings.Contains(err.Error(), "Field reason not found") {
+		t.Errorf("Write to a table without the column: got %v, want the gRPC message", err)
+	}
+}
+
+func TestBigQueryWriterResume(t *testing.T) {
+	// Each request has room for two rows, and the second request fails
+	// once.
+	var (
+		got   []uint64 // src_port of each row appended
+		calls int
+	)
+	srv := grpcServer(t, func(rows []map[int]interface{}) (string, string) {
+		calls++
+		if calls == 2 {
+			return "14", "unavailable"
+		}
+		for _, row := range rows {
+			got = append(got, row[7].(uint64))
+		}
+		return "0", ""
+	})
+	defer srv.Close()
+	w := testBigQueryWriter(srv)
+	row := appendBigQueryRow(nil, &testFlow, w.ProbeID)
+	w.maxRequest = 2 * (len(row) + 2)
+
+	flow := func(port uint16) packets.Flow {
+		f := testFlow
+		f.SrcPort = port
+		return f
+	}
+	if err := w.Write([]packets.Flow{flow(1), flow(2), flow(3), flow(4), flow(5)}); err == nil {
+		t.Fatal("Write: got nil error, want the failed request's")
+	}
+	if want := []uint64{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after the failure: appended %v, want %v", got, want)
+	}
+	// The retry starts from the request that failed.
+	if err := w.Write([]packets.Flow{flow(6)}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if want := []uint64{1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("appended %v, want %v", got, want)
+	}
+	if err := w.Close(); err != nil || len(w.pending) != 0 {
+		t.Errorf("Close: %v, with %d pending", err, len(w.pending))
+	}
+}
+
+func TestAppendRowsError(t *testing.T) {
+	var status, rowErr, resp protowire.Buffer
+	if err := appendRowsError(nil); err != nil {
+		t.Errorf("appendRowsError(empty): got %v, want nil", err)
+	}
+	status.Uint64(1, 3)
+	status.String(2, "bad schema")
+	resp.Message(2, status.B)
+	if err := appendRowsError(resp.B); err == nil || !strings.Contains(err.Error(), "bad schema") {
+		t.Errorf("appendRowsError(error): got %v, want bad schema", err)
+	}
+	rowErr.Uint64(1, 7)
+	rowErr.Uint64(2, 1)
+	rowErr.String(3, "bad timestamp")
+	resp.Reset()
+	resp.Message(4, rowErr.B)
+	if err := appendRowsError(resp.B); err == nil || !strings.Contains(err.Error(), "row 7: bad timestamp") {
+		t.Errorf("appendRowsError(row error): got %v, want row 7: bad timestamp", err)
+	}
+}
+
+func TestServiceAccountToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || len(parts) != 3 {
+			t.Errorf("token request: %v", r.Form)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+			t.Errorf("assertion signature: %v", err)
+		}
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var c struct{ Iss, Scope, Aud string }
+		json.Unmarshal(claims, &c)
+		if c.Iss != "caplog@p.iam.gserviceaccount.com" || c.Scope != bigQueryScope || c.Aud != "http://"+r.Host+"/token" {
+			t.Errorf("claims: %s", claims)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"tok","expires_in":3600,"token_type":"Bearer"}`))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "gcpauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "p",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email": "caplog@p.iam.gserviceaccount.com",
+		"token_uri":    srv.URL + "/token",
+	})
+	path := filepath.Join(dir, "key.json")
+	if err := ioutil.WriteFile(path, keyFile, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := loadServiceAccount(path, bigQueryScope)
+	if err != nil {
+		t.Fatalf("loadServiceAccount: %v", err)
+	}
+	if sa.ProjectID != "p" {
+		t.Errorf("ProjectID: got %q, want p", sa.ProjectID)
+	}
+	for i := 0; i < 2; i++ {
+		if tok, err := sa.Token(); tok != "tok" || err != nil {
+			t.Errorf("Token: got %q, %v, want tok", tok, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("token requests: got %d, want 1 (the token is cached)", calls)
+	}
+}
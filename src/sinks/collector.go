@@ -0,0 +1,347 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file sends batches to a caplog collector, sends them again if it
+// asks, and registers the probe with it.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"collector"
+	"config"
+	"flowpb"
+	"mtls"
+	"packets"
+	"vars"
+)
+
+// registrar is the collector output's writer, which RegisterProbe sends
+// registrations with. It is a nil *CollectorWriter if there is none.
+var registrar atomic.Value
+
+func init() {
+	registrar.Store((*CollectorWriter)(nil))
+	Register("collector", func(c *config.Config) (packets.Sink, error) {
+		if c.Outputs.Collector == "" {
+			registrar.Store((*CollectorWriter)(nil))
+			return nil, nil
+		}
+		u, err := parseHTTPURL("outputs.collector", c.Outputs.Collector)
+		if err != nil {
+			return nil, err
+		}
+		// Batches have nanoseconds, which the collector relies on.
+		if _, err := timeFormat(c, "collector", Nanoseconds); err != nil {
+			return nil, err
+		}
+		seq, err := OpenSequence(SequencePath(c.StateDir, "collector"))
+		if err != nil {
+			return nil, err
+		}
+		cw := &CollectorWriter{
+			URL:      c.Outputs.Collector,
+			ProbeID:  c.ProbeName(),
+			Sequence: seq,
+		}
+		dir := c.Outputs.SpoolDir
+		if dir == "" {
+			dir = c.Outputs.FailedSpoolDir
+		}
+		if dir != "" && c.Outputs.CollectorKeep > 0 {
+			if cw.Sent, err = OpenSentBatches(filepath.Join(dir, "collector-sent"), c.Outputs.CollectorKeep); err != nil {
+				return nil, err
+			}
+		}
+		vars.Uint64("sink-collector-replayed", &cw.replayed)
+		vars.Uint64("sink-collector-replay-missing", &cw.unkept)
+		registrar.Store(cw)
+		if t := c.Outputs.CollectorTLS; t.Cert != "" || len(t.Pins) > 0 {
+			if u.Scheme != "https" {
+				return nil, fmt.Errorf("outputs.collector must be an https:// URL to use collector_tls")
+			}
+			e, err := mtls.NewEndpoint(t.Cert, t.Key, t.Pins)
+			if err != nil {
+				return nil, fmt.Errorf("outputs.collector_tls: %v", err)
+			}
+			e.RegisterVars("collector-tls-")
+			cw.Client = &http.Client{
+				Transport: &http.Transport{
+					Proxy:               http.ProxyFromEnvironment,
+					TLSClientConfig:     e.ClientConfig(),
+					TLSHandshakeTimeout: 10 * time.Second,
+					IdleConnTimeout:     90 * time.Second,
+				},
+			}
+		}
+		return queued(c, "collector", cw.WriteKeyed)
+	})
+	RegisterFlows("collector", func(c *config.Config) (FlowSink, error) {
+		if c.Outputs.Collector == "" {
+			return nil, nil
+		}
+		return flowBatches(func() flowBatcher {
+			if w := registrar.Load().(*CollectorWriter); w != nil {
+				return w
+			}
+			return nil
+		}), nil
+	})
+}
+
+// CollectorWriter sends each buffer to a caplog running as a collector, as
+// a caplog.v1.Batch POSTed to /api/ingest. Flow records are sent as batches
+// of their own.
+type CollectorWriter struct {
+	// replayed counts the batches sent again when the collector asked, and
+	// unkept those it asked for that weren't kept. They are accessed
+	// atomically, and are first to be aligned on 32-bit platforms.
+	replayed, unkept uint64
+
+	URL string // base URL of the collector, e.g. http://collector:8080/
+
+	// ProbeID and the numbers from Sequence label every batch, as for
+	// FileWriter.
+	ProbeID  string
+	Sequence *Sequence
+
+	// Client, if set, is used instead of http.DefaultClient, e.g. for
+	// mutual TLS.
+	Client *http.Client
+
+	// Sent, if set, keeps the batches sent, to send again if the collector
+	// asks for them.
+	Sent *SentBatches
+
+	mu sync.Mutex // serialises use of Sequence
+}
+
+// Write sends a buffer of packet metadata.
+func (w *CollectorWriter) Write(data []packets.Metadata) error {
+	return w.WriteKeyed("", data)
+}
+
+// WriteKeyed is like Write, and records the dedup key in the batch.
+func (w *CollectorWriter) WriteKeyed(key string, data []packets.Metadata) error {
+	if len(data) == 0 {
+		return nil
+	}
+	b := newBatch(data)
+	b.DedupKey = key
+	return w.send(&b)
+}
+
+// WriteFlows sends a batch of flow records.
+func (w *CollectorWriter) WriteFlows(flows []packets.Flow) error {
+	if len(flows) == 0 {
+		return nil
+	}
+	b := newFlowBatch(flows)
+	return w.send(&b)
+}
+
+// send numbers the batch, keeps it if asked to, and sends it.
+func (w *CollectorWriter) send(b *flowpb.Batch) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.Sequence == nil {
+		w.Sequence = new(Sequence)
+	}
+	b.Sequence = w.Sequence.Next()
+	b.SequenceEpoch = w.Sequence.Epoch()
+	b.ProbeID = w.ProbeID
+	if w.Sent != nil {
+		if err := w.Sent.Put(b.Sequence, b.Marshal(nil)); err != nil {
+			log.Printf("collector: keeping batch %d: %v", b.Sequence, err)
+		}
+	}
+	replay, err := w.post(b)
+	if err != nil {
+		return err
+	}
+	w.Sequence.written("collector", b.Sequence)
+	if replay != "" {
+		w.replay(replay)
+	}
+	return nil
+}
+
+// RegisterProbe sends the registration to the collector output opened last,
+// if there is one.
+func RegisterProbe(r *collector.Registration) error {
+	w := registrar.Load().(*CollectorWriter)
+	if w == nil {
+		return nil
+	}
+	return w.Register(r)
+}
+
+// Register sends the probe's registration, with its ID set to ProbeID, as
+// JSON POSTed to /api/register.
+func (w *CollectorWriter) Register(r *collector.Registration) error {
+	r.ID = w.ProbeID
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimRight(w.URL, "/") + "/api/register"
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector: register: %s", resp.Status)
+	}
+	return nil
+}
+
+// post sends a batch, and returns the batches the collector asked for again.
+func (w *CollectorWriter) post(b *flowpb.Batch) (string, error) {
+	// Set last, so the collector can tell how long it took to arrive.
+	b.SentNs = time.Now().UnixNano()
+	url := strings.TrimRight(w.URL, "/") + "/api/ingest"
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(url, "application/x-protobuf", bytes.NewReader(b.Marshal(nil)))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", statusError("collector", resp, "")
+	}
+	return resp.Header.Get(flowpb.ReplayHeader), nil
+}
+
+// replay sends again the kept batches in the ReplayHeader value, up to as
+// many as are kept. Any it asks for in turn are left to the next response.
+func (w *CollectorWriter) replay(h string) {
+	rs, err := flowpb.ParseRanges(h)
+	if err != nil {
+		log.Printf("collector: %v", err)
+		return
+	}
+	budget := 0
+	if w.Sent != nil {
+		budget = w.Sent.Keep
+	}
+	for _, r := range rs {
+		for seq := r.From; seq <= r.To && seq != 0; seq++ {
+			if budget == 0 {
+				atomic.AddUint64(&w.unkept, r.To-seq+1)
+				break
+			}
+			budget--
+			b, err := w.Sent.Get(seq)
+			if err != nil {
+				atomic.AddUint64(&w.unkept, 1)
+				continue
+			}
+			if _, err := w.post(b); err != nil {
+				log.Printf("collector: sending batch %d again: %v", seq, err)
+				return
+			}
+			atomic.AddUint64(&w.replayed, 1)
+		}
+	}
+}
+
+// SentBatches keeps the last batches sent on disk, by sequence number.
+type SentBatches struct {
+	dir  string
+	Keep int // how many to keep
+}
+
+// OpenSentBatches opens (creating if needed) the batches kept in dir.
+func OpenSentBatches(dir string, keep int) (*SentBatches, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &SentBatches{dir: dir, Keep: keep}
+	// Drop any beyond Keep of the last, in case it was lowered.
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolSuffix))
+	if err != nil {
+		return nil, err
+	}
+	var last uint64
+	seqs := make([]uint64, 0, len(files))
+	for _, f := range files {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(f), spoolSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+		if seq > last {
+			last = seq
+		}
+	}
+	for _, seq := range seqs {
+		if seq+uint64(keep) <= last {
+			os.Remove(s.path(seq))
+		}
+	}
+	return s, nil
+}
+
+func (s *SentBatches) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSuffix))
+}
+
+// Put keeps a marshalled batch, and forgets the one Keep before it.
+func (s *SentBatches) Put(seq uint64, raw []byte) error {
+	tmp := s.path(seq) + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path(seq)); err != nil {
+		return err
+	}
+	if seq > uint64(s.Keep) {
+		os.Remove(s.path(seq - uint64(s.Keep)))
+	}
+	return nil
+}
+
+// Get returns a kept batch.
+func (s *SentBatches) Get(seq uint64) (*flowpb.Batch, error) {
+	raw, err := ioutil.ReadFile(s.path(seq))
+	if err != nil {
+		return nil, err
+	}
+	b := new(flowpb.Batch)
+	if err := b.Unmarshal(raw); err != nil {
+		return nil, fmt.Errorf("sent batch %d: %v", seq, err)
+	}
+	return b, nil
+}
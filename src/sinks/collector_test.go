@@ -0,0 +1,104 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"flowpb"
+	"packets"
+)
+
+func TestCollectorReplay(t *testing.T) {
+	// The collector loses batch 2, and asks for it (and 9, which was
+	// never sent) after 3.
+	var got []uint64
+	lost := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var b flowpb.Batch
+		if err := b.Unmarshal(body); err != nil {
+			t.Errorf("Unmarshal: %v", err)
+		}
+		if b.Sequence == 2 && !lost {
+			lost = true
+		} else {
+			got = append(got, b.Sequence)
+		}
+		if b.Sequence == 3 {
+			w.Header().Set(flowpb.ReplayHeader, "2,9")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sent, err := OpenSentBatches(t.TempDir(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &CollectorWriter{URL: srv.URL, ProbeID: "p", Sent: sent}
+	for i := 0; i < 3; i++ {
+		if err := w.WriteKeyed("", []packets.Metadata{{Size: 60}}); err != nil {
+			t.Fatalf("WriteKeyed: %v", err)
+		}
+	}
+	if want := []uint64{1, 3, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("collector got %v, want %v", got, want)
+	}
+	if atomic.LoadUint64(&w.replayed) != 1 || atomic.LoadUint64(&w.unkept) != 1 {
+		t.Errorf("replayed %d, unkept %d; want 1, 1", w.replayed, w.unkept)
+	}
+	// Only the last 2 are kept.
+	if _, err := sent.Get(1); err == nil {
+		t.Error("batch 1 still kept")
+	}
+}
+
+func TestCollectorFlows(t *testing.T) {
+	var got []flowpb.Batch
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var b flowpb.Batch
+		if err := b.Unmarshal(body); err != nil {
+			t.Errorf("Unmarshal: %v", err)
+		}
+		got = append(got, b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	w := &CollectorWriter{URL: srv.URL, ProbeID: "p"}
+	registrar.Store(w)
+	defer registrar.Store((*CollectorWriter)(nil))
+	var fs FlowSink = flowBatches(func() flowBatcher { return registrar.Load().(*CollectorWriter) })
+	if err := w.Write([]packets.Metadata{{Size: 60}}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := fs.Write([]packets.Flow{{SrcPort: 5353, DstPort: 53, Protocol: 17, SrcPackets: 1, SrcBytes: 60, Reason: packets.FlowIdle}}); err != nil {
+		t.Fatalf("flows Write: %v", err)
+	}
+	// Flows come in batches of their own, numbered with the packets'.
+	if len(got) != 2 || len(got[1].Packets) != 0 || len(got[1].Flows) != 1 || got[1].Sequence != 2 || got[1].ProbeID != "p" {
+		t.Fatalf("collector got %+v, want a packet batch and then a flow batch", got)
+	}
+	if f := got[1].Flows[0].ToFlow(); f.SrcPort != 5353 || f.SrcBytes != 60 || f.Reason != packets.FlowIdle {
+		t.Errorf("flow record: got %+v", f)
+	}
+}
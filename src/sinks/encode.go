@@ -0,0 +1,113 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file has the append-style encoders the sinks share. Sinks encode
+// every point they write, so these avoid fmt, and allocating per point:
+// everything is appended to one buffer, which is reused.
+
+import (
+	"net"
+	"strconv"
+	"unicode/utf8"
+
+	"packets"
+)
+
+const hexDigits = "0123456789abcdef"
+
+// appendIP appends the address in its usual form. IPv4 addresses, the most
+// common, are formatted without allocating.
+func appendIP(b []byte, ip net.IP) []byte {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return append(b, ip.String()...)
+	}
+	for i, n := range ip4 {
+		if i > 0 {
+			b = append(b, '.')
+		}
+		b = strconv.AppendUint(b, uint64(n), 10)
+	}
+	return b
+}
+
+// appendJSONString appends s as a quoted JSON string. As with encoding/json,
+// invalid UTF-8 becomes U+FFFD, but <, > and & aren't escaped.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= 0x20 && c != '"' && c != '\\' && c < utf8.RuneSelf {
+			i++
+			continue
+		}
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r != utf8.RuneError || size != 1 {
+				i += size
+				continue
+			}
+		}
+		b = append(b, s[start:i]...)
+		switch c {
+		case '"', '\\':
+			b = append(b, '\\', c)
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		default:
+			if c >= utf8.RuneSelf {
+				b = append(b, `�`...)
+			} else {
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+		}
+		i++
+		start = i
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
+
+// appendJSONArray appends a Metadata point as a JSON array of values:
+// [time, src_ip, dst_ip, src_port, dst_port, src_name, dst_name, size], with
+// the time as a number in the precision. This is a convenient format for
+// Influx.
+func appendJSONArray(b []byte, p *packets.Metadata, precision TimeFormat) []byte {
+	b = append(b, '[')
+	b = precision.AppendTime(b, p.Timestamp)
+	b = append(b, ',', '"')
+	b = appendIP(b, p.SrcIP)
+	b = append(b, '"', ',', '"')
+	b = appendIP(b, p.DstIP)
+	b = append(b, '"', ',')
+	b = strconv.AppendUint(b, uint64(p.SrcPort), 10)
+	b = append(b, ',')
+	b = strconv.AppendUint(b, uint64(p.DstPort), 10)
+	b = append(b, ',')
+	b = appendJSONString(b, p.SrcName)
+	b = append(b, ',')
+	b = appendJSONString(b, p.DstName)
+	b = append(b, ',')
+	b = strconv.AppendUint(b, p.Size, 10)
+	return append(b, ']')
+}
@@ -0,0 +1,137 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+
+	"packets"
+)
+
+func TestAppendIP(t *testing.T) {
+	for _, s := range []string{"10.0.0.2", "0.0.0.0", "255.255.255.255", "2001:db8::1", "::ffff:1.2.3.4"} {
+		ip := net.ParseIP(s)
+		if got, want := string(appendIP(nil, ip)), ip.String(); got != want {
+			t.Errorf("appendIP(%s): got %q, want %q", s, got, want)
+		}
+	}
+	if got, want := string(appendIP(nil, nil)), "<nil>"; got != want {
+		t.Errorf("appendIP(nil): got %q, want %q", got, want)
+	}
+}
+
+func TestAppendJSONString(t *testing.T) {
+	tests := []string{
+		"",
+		"dns.google",
+		`dns "google"`,
+		`back\slash`,
+		"tab\tnew\nline\r",
+		"\x00\x1f\x7f",
+		"<a&b>",
+		"héllo, 世界",
+		"bad \xff utf-8 \xe2\x82",
+	}
+	for _, s := range tests {
+		var want bytes.Buffer
+		enc := json.NewEncoder(&want)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(s); err != nil {
+			t.Fatalf("Encode(%q): %v", s, err)
+		}
+		if got := string(appendJSONString(nil, s)); got+"\n" != want.String() {
+			t.Errorf("appendJSONString(%q): got %s, want %s", s, got, want.String())
+		}
+	}
+}
+
+func TestInfluxBody(t *testing.T) {
+	v6 := testPacket
+	v6.SrcIP, v6.DstIP = net.ParseIP("fd00::2"), net.ParseIP("2001:4860:4860::8888")
+	v6.SrcName = "back\\slash\n"
+	var got []struct {
+		Name    string
+		Columns []string
+		Points  [][]interface{}
+	}
+	body := influxBody([]packets.Metadata{testPacket, v6}, Milliseconds)
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("Unmarshal(%s): %v", body, err)
+	}
+	if len(got) != 1 || got[0].Name != "packet" || len(got[0].Columns) != 8 {
+		t.Fatalf("influxBody: got %s", body)
+	}
+	want := [][]interface{}{
+		{1434055562000.0, "10.0.0.2", "8.8.8.8", 5353.0, 53.0, "10.0.0.2", `dns "google"`, 74.0},
+		{1434055562000.0, "fd00::2", "2001:4860:4860::8888", 5353.0, 53.0, "back\\slash\n", `dns "google"`, 74.0},
+	}
+	if !reflect.DeepEqual(got[0].Points, want) {
+		t.Errorf("influxBody points: got %v, want %v", got[0].Points, want)
+	}
+}
+
+// fprintfJSONArray is how points were formatted before appendJSONArray, for
+// comparison.
+func fprintfJSONArray(w *bytes.Buffer, p *packets.Metadata) {
+	fmt.Fprintf(w, `[%d, "%v", "%v", %d, %d, "%s", "%s", %d]`,
+		p.Timestamp.UnixNano()/1e6, p.SrcIP, p.DstIP, p.SrcPort, p.DstPort, p.SrcName, p.DstName, p.Size,
+	)
+}
+
+func BenchmarkJSONArrayFprintf(b *testing.B) {
+	var w bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		fprintfJSONArray(&w, &testPacket)
+	}
+}
+
+func BenchmarkAppendJSONArray(b *testing.B) {
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = appendJSONArray(buf[:0], &testPacket, Milliseconds)
+	}
+}
+
+// BenchmarkInfluxBody encodes 1000 points per op.
+func BenchmarkInfluxBody(b *testing.B) {
+	data := make([]packets.Metadata, 1000)
+	for i := range data {
+		data[i] = testPacket
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ioutil.Discard.Write(influxBody(data, Milliseconds))
+	}
+}
+
+func BenchmarkAppendLine(b *testing.B) {
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = AppendLine(buf[:0], &testPacket)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file shares a budget of memory between the outputs' queues, so that a
+// slow output (a remote database over LTE, say) can't starve the fast ones:
+// while there is room, any queue can use it, and once the budget is used up,
+// room is made by dropping from the queue furthest over its weighted share,
+// rather than from whichever queue a new buffer is for. Queues spooled to
+// disk (with spool_dir) each have their own spool, and take no part.
+
+import (
+	"fmt"
+	"sync"
+
+	"vars"
+)
+
+// FairShare divides a budget of queued buffers between queues, in
+// proportion to their weights.
+type FairShare struct {
+	budget int
+
+	mu     sync.Mutex
+	queues []*Queue
+	weight map[*Queue]int
+	total  int // the sum of the weights
+}
+
+// NewFairShare makes a share of the budget, in buffers, for queues to join.
+func NewFairShare(budget int) *FairShare {
+	return &FairShare{budget: budget, weight: make(map[*Queue]int)}
+}
+
+// Join adds an in-memory queue with the weight (1 if it isn't positive),
+// and registers its share with vars. The queue should have been made with
+// room for the whole budget.
+func (f *FairShare) Join(q *Queue, weight int) error {
+	if q.ch == nil {
+		return fmt.Errorf("%s: a spooled queue can't share memory", q.name)
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	f.mu.Lock()
+	f.queues = append(f.queues, q)
+	f.weight[q] = weight
+	f.total += weight
+	f.mu.Unlock()
+	q.share = f
+	vars.Register("sink-"+q.name+"-share", vars.IntEval(func() int { return f.Share(q) }).String)
+	return nil
+}
+
+// Share returns the queue's share of the budget, in buffers.
+func (f *FairShare) Share(q *Queue) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.total == 0 {
+		return 0
+	}
+	return f.budget * f.weight[q] / f.total
+}
+
+// admit makes room for a buffer for q, dropping the oldest buffers of the
+// queues furthest over their shares while the budget is used up.
+func (f *FairShare) admit(q *Queue) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for {
+		queued := 0
+		for _, m := range f.queues {
+			queued += len(m.ch)
+		}
+		if queued < f.budget {
+			return
+		}
+		// The fullest for its weight, counting the buffer to come.
+		var fullest *Queue
+		var most float64
+		for _, m := range f.queues {
+			n := len(m.ch)
+			if m == q {
+				n++
+			}
+			if over := float64(n) / float64(f.weight[m]); fullest == nil || over > most {
+				fullest, most = m, over
+			}
+		}
+		if !fullest.dropOldest() {
+			return // written meanwhile
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"context"
+	"testing"
+
+	"packets"
+)
+
+func TestFairShare(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := NewQueue("test-share-slow", 4, func(data []packets.Metadata) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	})
+	fast := NewQueue("test-share-fast", 4, func(data []packets.Metadata) error { return nil })
+	f := NewFairShare(4)
+	if err := f.Join(slow, 1); err != nil {
+		t.Fatalf("Join(slow): %v", err)
+	}
+	if err := f.Join(fast, 3); err != nil {
+		t.Fatalf("Join(fast): %v", err)
+	}
+	if got := f.Share(slow); got != 1 {
+		t.Errorf("Share(slow): got %d, want 1", got)
+	}
+	if got := f.Share(fast); got != 3 {
+		t.Errorf("Share(fast): got %d, want 3", got)
+	}
+
+	buf := []packets.Metadata{testPacket}
+	slow.WritePackets(buf)
+	<-started // the first buffer is being written, and no longer queued
+	for i := 0; i < 10; i++ {
+		slow.WritePackets(buf)
+		fast.WritePackets(buf)
+		fast.Flush(context.Background())
+	}
+	// The slow queue filled the budget, so it made room for the fast one's
+	// buffers too.
+	if s := fast.Status(); s.Written != 10 || s.Dropped != 0 {
+		t.Errorf("fast: got %d written, %d dropped, want 10, 0", s.Written, s.Dropped)
+	}
+	if s := slow.Status(); s.Queued != 3 || s.Dropped != 7 {
+		t.Errorf("slow: got %d queued, %d dropped, want 3, 7", s.Queued, s.Dropped)
+	}
+	close(release)
+	slow.Flush(context.Background())
+	if s := slow.Status(); s.Written != 4 {
+		t.Errorf("slow: got %d written, want 4", s.Written)
+	}
+}
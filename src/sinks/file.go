@@ -0,0 +1,177 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file appends batches to a file in the caplog.v1 protobuf schema.
+
+import (
+	"encoding/binary"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"config"
+	"flowpb"
+	"packets"
+)
+
+// outWriter is the "out" output's writer, which its flow records are
+// written with too. It is a nil *FileWriter if there is none.
+var outWriter atomic.Value
+
+func init() {
+	outWriter.Store((*FileWriter)(nil))
+	Register("out", func(c *config.Config) (packets.Sink, error) {
+		if c.Outputs.File == "" {
+			outWriter.Store((*FileWriter)(nil))
+			return nil, nil
+		}
+		// The caplog.v1 schema has nanoseconds.
+		if _, err := timeFormat(c, "out", Nanoseconds); err != nil {
+			return nil, err
+		}
+		seq, err := OpenSequence(SequencePath(c.StateDir, "out"))
+		if err != nil {
+			return nil, err
+		}
+		fw := &FileWriter{
+			Path:     c.Outputs.File,
+			ProbeID:  c.ProbeName(),
+			Sequence: seq,
+		}
+		outWriter.Store(fw)
+		return queued(c, "out", fw.WriteKeyed)
+	})
+	RegisterFlows("out", func(c *config.Config) (FlowSink, error) {
+		if c.Outputs.File == "" {
+			return nil, nil
+		}
+		return flowBatches(func() flowBatcher {
+			if w := outWriter.Load().(*FileWriter); w != nil {
+				return w
+			}
+			return nil
+		}), nil
+	})
+}
+
+// flowBatcher is an output that also writes flow records, in batches of
+// their own.
+type flowBatcher interface {
+	WriteFlows(flows []packets.Flow) error
+}
+
+// flowBatches is a FlowSink writing to the flowBatcher that is current when
+// records are written: that of the packet output of the same name, so that
+// both share a file (or collector) and sequence numbers. Records are dropped
+// if that output wasn't opened (e.g. -sink left it out).
+type flowBatches func() flowBatcher
+
+// Write writes the records as a batch.
+func (fb flowBatches) Write(flows []packets.Flow) error {
+	if w := fb(); w != nil {
+		return w.WriteFlows(flows)
+	}
+	return nil
+}
+
+// Close does nothing: the packet output closes the writer.
+func (fb flowBatches) Close() error { return nil }
+
+// FileWriter appends each buffer to a file as a caplog.v1.Batch, prefixed
+// with its length as a varint (the usual "delimited" protobuf stream format).
+// Flow records are appended as batches of their own.
+type FileWriter struct {
+	Path string
+
+	// ProbeID and the numbers from Sequence label every batch. If Sequence
+	// is nil, batches are numbered from 1.
+	ProbeID  string
+	Sequence *Sequence
+
+	mu  sync.Mutex
+	f   *os.File
+	buf []byte
+}
+
+// newBatch converts a buffer to a batch (with no envelope fields set).
+func newBatch(data []packets.Metadata) flowpb.Batch {
+	b := flowpb.Batch{Packets: make([]flowpb.Metadata, len(data))}
+	for i := range data {
+		b.Packets[i] = flowpb.FromMetadata(&data[i])
+	}
+	return b
+}
+
+// newFlowBatch converts flow records to a batch (with no envelope fields
+// set).
+func newFlowBatch(flows []packets.Flow) flowpb.Batch {
+	b := flowpb.Batch{Flows: make([]flowpb.Flow, len(flows))}
+	for i := range flows {
+		b.Flows[i] = flowpb.FromFlow(&flows[i])
+	}
+	return b
+}
+
+// Write appends a buffer of packet metadata to the file.
+func (w *FileWriter) Write(data []packets.Metadata) error {
+	return w.WriteKeyed("", data)
+}
+
+// WriteKeyed is like Write, and records the dedup key in the batch.
+func (w *FileWriter) WriteKeyed(key string, data []packets.Metadata) error {
+	if len(data) == 0 {
+		return nil
+	}
+	b := newBatch(data)
+	b.DedupKey = key
+	return w.write(&b)
+}
+
+// WriteFlows appends a batch of flow records to the file.
+func (w *FileWriter) WriteFlows(flows []packets.Flow) error {
+	if len(flows) == 0 {
+		return nil
+	}
+	b := newFlowBatch(flows)
+	return w.write(&b)
+}
+
+// write numbers the batch and appends it.
+func (w *FileWriter) write(b *flowpb.Batch) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		f, err := os.OpenFile(w.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		w.f = f
+	}
+	if w.Sequence == nil {
+		w.Sequence = new(Sequence)
+	}
+	b.Sequence = w.Sequence.Next()
+	b.SequenceEpoch = w.Sequence.Epoch()
+	b.ProbeID = w.ProbeID
+	msg := b.Marshal(nil)
+	w.buf = binary.AppendUvarint(w.buf[:0], uint64(len(msg)))
+	w.buf = append(w.buf, msg...)
+	if _, err := w.f.Write(w.buf); err != nil {
+		return err
+	}
+	w.Sequence.written("out", b.Sequence)
+	return nil
+}
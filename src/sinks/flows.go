@@ -0,0 +1,94 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file appends flow records (see packets.FlowTable) and stream
+// summaries to files.
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"config"
+	"packets"
+)
+
+func init() {
+	RegisterFlows("flows", func(c *config.Config) (FlowSink, error) {
+		if c.Flows.File == "" {
+			return nil, nil
+		}
+		return &FlowWriter{Path: c.Flows.File}, nil
+	})
+}
+
+// FlowWriter appends flow records to a file, as JSON, one per line.
+type FlowWriter struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Write appends the records to the file.
+func (w *FlowWriter) Write(flows []packets.Flow) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return appendJSONLines(w.Path, len(flows), func(i int) interface{} { return &flows[i] })
+}
+
+// Close does nothing: each Write opens and closes the file.
+func (w *FlowWriter) Close() error { return nil }
+
+// StreamWriter appends stream summaries (see packets.StreamTable) to a
+// file, as JSON, one per line.
+type StreamWriter struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Write appends the summaries to the file.
+func (w *StreamWriter) Write(streams []packets.Stream) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return appendJSONLines(w.Path, len(streams), func(i int) interface{} { return &streams[i] })
+}
+
+// appendJSONLines appends n values, the ith from value, to the file at
+// path, as JSON, one per line.
+func appendJSONLines(path string, n int, value func(i int) interface{}) error {
+	if n == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	for i := 0; i < n; i++ {
+		if err := enc.Encode(value(i)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
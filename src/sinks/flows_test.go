@@ -0,0 +1,62 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"packets"
+)
+
+func TestFlowWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flows.json")
+	w := &FlowWriter{Path: path}
+	start := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	flows := []packets.Flow{
+		{Start: start, End: start.Add(time.Second), SrcIP: net.ParseIP("10.0.0.2"), DstIP: net.ParseIP("192.0.2.80"), SrcPort: 50000, DstPort: 443, Protocol: 6, SrcPackets: 3, SrcBytes: 300, Reason: packets.FlowFIN},
+		{Start: start, End: start, SrcIP: net.ParseIP("10.0.0.2"), DstIP: net.ParseIP("192.0.2.53"), SrcPort: 5353, DstPort: 53, Protocol: 17, SrcPackets: 1, SrcBytes: 80, Reason: packets.FlowIdle},
+	}
+	if err := w.Write(flows[:1]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Write(flows[1:]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []packets.Flow
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var fl packets.Flow
+		if err := json.Unmarshal(s.Bytes(), &fl); err != nil {
+			t.Fatalf("line %q: %v", s.Text(), err)
+		}
+		got = append(got, fl)
+	}
+	if !reflect.DeepEqual(got, flows) {
+		t.Errorf("read back:\ngot  %+v\nwant %+v", got, flows)
+	}
+}
@@ -0,0 +1,172 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file gets OAuth2 access tokens for a Google service account, from its
+// JSON key, for the sinks writing to Google Cloud (RFC 7523).
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	googleTokenURI = "https://oauth2.googleapis.com/token"
+
+	// tokenEarly is how long before it expires a token is replaced.
+	tokenEarly = time.Minute
+)
+
+// serviceAccount is a Google service account, with a cached access token.
+type serviceAccount struct {
+	Email     string
+	ProjectID string
+	TokenURI  string
+	Scope     string
+
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// loadServiceAccount reads a service account's JSON key file, for tokens
+// with the scope.
+func loadServiceAccount(path, scope string) (*serviceAccount, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f struct {
+		Type        string `json:"type"`
+		ProjectID   string `json:"project_id"`
+		PrivateKey  string `json:"private_key"`
+		ClientEmail string `json:"client_email"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if f.Type != "service_account" {
+		return nil, fmt.Errorf("%s: not a service account key (type %q)", path, f.Type)
+	}
+	block, _ := pem.Decode([]byte(f.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("%s: no private key", path)
+	}
+	var key *rsa.PrivateKey
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		key, _ = k.(*rsa.PrivateKey)
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return nil, fmt.Errorf("%s: private key: %v", path, err)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%s: private key isn't RSA", path)
+	}
+	a := &serviceAccount{
+		Email:     f.ClientEmail,
+		ProjectID: f.ProjectID,
+		TokenURI:  f.TokenURI,
+		Scope:     scope,
+		key:       key,
+	}
+	if a.TokenURI == "" {
+		a.TokenURI = googleTokenURI
+	}
+	return a, nil
+}
+
+// Token returns an access token, getting a new one if the last has (nearly)
+// expired.
+func (a *serviceAccount) Token() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if a.token != "" && now.Before(a.expiry.Add(-tokenEarly)) {
+		return a.token, nil
+	}
+	assertion, err := a.assertion(now)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.PostForm(a.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("getting a token for %s: %s: %s", a.Email, resp.Status, strings.TrimSpace(string(b)))
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return "", fmt.Errorf("getting a token for %s: %v", a.Email, err)
+	}
+	if t.AccessToken == "" {
+		return "", errors.New("getting a token for " + a.Email + ": no access_token")
+	}
+	a.token, a.expiry = t.AccessToken, now.Add(time.Duration(t.ExpiresIn)*time.Second)
+	return a.token, nil
+}
+
+// assertion makes the signed JWT that is exchanged for a token.
+func (a *serviceAccount) assertion(now time.Time) (string, error) {
+	header := `{"alg":"RS256","typ":"JWT"}`
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   a.Email,
+		"scope": a.Scope,
+		"aud":   a.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,141 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file writes to InfluxDB (0.8), through its JSON series API.
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/url"
+
+	"config"
+	"packets"
+)
+
+func init() {
+	Register("influx", func(c *config.Config) (packets.Sink, error) {
+		if c.Outputs.Influx == "" {
+			return nil, nil
+		}
+		e, err := newInfluxEndpoint(c)
+		if err != nil {
+			return nil, err
+		}
+		return queued(c, "influx", e.write)
+	})
+}
+
+// influxEndpoint writes to the series API of a database. Credentials are
+// either in the URL, or a token for the Authorization header.
+type influxEndpoint struct {
+	url, token string
+	precision  TimeFormat // of the time column
+}
+
+// influxPrecisions are the time_precision parameters of the series API.
+var influxPrecisions = map[TimeFormat]string{Seconds: "s", Milliseconds: "ms", Microseconds: "u"}
+
+// newInfluxEndpoint makes the endpoint from the outputs config.
+func newInfluxEndpoint(c *config.Config) (*influxEndpoint, error) {
+	u, err := parseHTTPURL("outputs.influx", c.Outputs.Influx)
+	if err != nil {
+		return nil, err
+	}
+	db, user, pass := c.Outputs.InfluxDB, c.Outputs.InfluxUser, c.Outputs.InfluxPassword
+	if db == "" {
+		db = "caplog"
+	}
+	if user == "" {
+		user = "caplog"
+	}
+	precision, err := timeFormat(c, "influx", Milliseconds, Seconds, Microseconds)
+	if err != nil {
+		return nil, err
+	}
+	if pass == "" && c.Outputs.InfluxToken == "" {
+		// What caplog always used, before it was configurable.
+		pass = "freshbeans"
+	}
+	u.Path = "db/" + db + "/series"
+	q := url.Values{}
+	if pass != "" {
+		q.Set("u", user)
+		q.Set("p", pass)
+	}
+	if precision != Milliseconds {
+		// Influx's default.
+		q.Set("time_precision", influxPrecisions[precision])
+	}
+	u.RawQuery = q.Encode()
+	return &influxEndpoint{url: u.String(), token: c.Outputs.InfluxToken, precision: precision}, nil
+}
+
+// influxHeader starts the body of a write, which is followed by the points as
+// JSON arrays (see appendJSONArray), and influxTrailer.
+const (
+	influxHeader  = `[{"name":"packet","columns":["time","src_ip","dst_ip","src_port","dst_port","src_name","dst_name","size"],"points":[`
+	influxTrailer = `]}]`
+
+	// influxPointSize is a generous guess at the length of an encoded point,
+	// for sizing the body up front.
+	influxPointSize = 96
+)
+
+// influxBody encodes the buffer as the body of a write, with times in the
+// precision.
+func influxBody(data []packets.Metadata, precision TimeFormat) []byte {
+	b := make([]byte, 0, len(influxHeader)+len(data)*influxPointSize+len(influxTrailer))
+	b = append(b, influxHeader...)
+	for i := range data {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendJSONArray(b, &data[i], precision)
+	}
+	return append(b, influxTrailer...)
+}
+
+// write sends the buffer to Influx, with the dedup key (if any) in an
+// Idempotency-Key header.
+func (e *influxEndpoint) write(key string, data []packets.Metadata) error {
+	if len(data) == 0 {
+		return nil
+	}
+	log.Printf("Writing %d points to Influx...", len(data))
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(influxBody(data, e.precision)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Token "+e.token)
+	}
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return statusError("influx", resp, "")
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file writes line protocol to InfluxDB's /api/v2/write endpoint, which
+// InfluxDB 2.x and 1.8+ both have.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"config"
+	"packets"
+)
+
+func init() {
+	Register("influx2", func(c *config.Config) (packets.Sink, error) {
+		ic := c.Outputs.InfluxV2
+		if ic.URL == "" {
+			return nil, nil
+		}
+		if _, err := parseHTTPURL("outputs.influx_v2.url", ic.URL); err != nil {
+			return nil, err
+		}
+		if ic.Bucket == "" {
+			return nil, fmt.Errorf("outputs.influx_v2.bucket is required")
+		}
+		precision, err := timeFormat(c, "influx2", Nanoseconds, Microseconds, Milliseconds, Seconds)
+		if err != nil {
+			return nil, err
+		}
+		w := &InfluxV2Writer{
+			URL:       ic.URL,
+			Org:       ic.Org,
+			Bucket:    ic.Bucket,
+			Token:     c.Outputs.InfluxToken,
+			Precision: precision,
+		}
+		return queued(c, "influx2", w.WriteKeyed)
+	})
+}
+
+// InfluxV2Writer writes each buffer as line protocol to /api/v2/write.
+type InfluxV2Writer struct {
+	URL    string // base URL, e.g. http://127.0.0.1:8086/
+	Org    string // ignored by InfluxDB 1.8
+	Bucket string // or "database/retention-policy" for InfluxDB 1.8
+
+	// Token authenticates the writes; for InfluxDB 1.8 it is
+	// "username:password".
+	Token string
+
+	// Precision is the precision of the timestamps written: Nanoseconds
+	// (the default), Microseconds, Milliseconds or Seconds.
+	Precision TimeFormat
+}
+
+// Write writes a buffer of packet metadata.
+func (w *InfluxV2Writer) Write(data []packets.Metadata) error {
+	return w.WriteKeyed("", data)
+}
+
+// WriteKeyed is like Write, and sends the dedup key (if any) in an
+// Idempotency-Key header. InfluxDB itself overwrites identical points, so
+// replays are harmless anyway.
+func (w *InfluxV2Writer) WriteKeyed(key string, data []packets.Metadata) error {
+	if len(data) == 0 {
+		return nil
+	}
+	var body []byte
+	for i := range data {
+		body = AppendLinePrecision(body, &data[i], w.Precision)
+	}
+	q := url.Values{
+		"bucket":    []string{w.Bucket},
+		"precision": []string{w.Precision.String()},
+	}
+	if w.Org != "" {
+		q.Set("org", w.Org)
+	}
+	u := strings.TrimRight(w.URL, "/") + "/api/v2/write?" + q.Encode()
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.Token)
+	}
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return statusError("InfluxDB write", resp, string(bytes.TrimSpace(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"packets"
+)
+
+func TestInfluxV2Writer(t *testing.T) {
+	type request struct {
+		path, query, auth, body string
+	}
+	got := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got <- request{r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), string(b)}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	w := &InfluxV2Writer{URL: srv.URL + "/", Org: "home", Bucket: "caplog", Token: "s3cret"}
+	if err := w.Write([]packets.Metadata{testPacket}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	want := request{
+		path:  "/api/v2/write",
+		query: "bucket=caplog&org=home&precision=ns",
+		auth:  "Token s3cret",
+		body:  string(AppendLine(nil, &testPacket)),
+	}
+	if g := <-got; g != want {
+		t.Errorf("request: got %+v, want %+v", g, want)
+	}
+
+	w.Precision = Seconds
+	if err := w.Write([]packets.Metadata{testPacket}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	want.query = "bucket=caplog&org=home&precision=s"
+	want.body = string(AppendLinePrecision(nil, &testPacket, Seconds))
+	if g := <-got; g != want {
+		t.Errorf("request in seconds: got %+v, want %+v", g, want)
+	}
+}
+
+func TestInfluxV2WriterError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"code":"unauthorized"}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	w := &InfluxV2Writer{URL: srv.URL, Bucket: "caplog"}
+	if err := w.Write([]packets.Metadata{testPacket}); err == nil {
+		t.Error("Write to an unauthorized server: got nil error, want error")
+	}
+}
@@ -0,0 +1,64 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"testing"
+
+	"config"
+)
+
+func TestNewInfluxEndpoint(t *testing.T) {
+	tests := []struct {
+		user, db, pass, token string
+		timeFormat            string
+		want                  influxEndpoint
+	}{
+		{
+			want: influxEndpoint{url: "http://influx:8086/db/caplog/series?p=freshbeans&u=caplog", precision: Milliseconds},
+		},
+		{
+			user: "net", db: "home net", pass: "s3cret",
+			want: influxEndpoint{url: "http://influx:8086/db/home%20net/series?p=s3cret&u=net", precision: Milliseconds},
+		},
+		{
+			token: "tok",
+			want:  influxEndpoint{url: "http://influx:8086/db/caplog/series", token: "tok", precision: Milliseconds},
+		},
+		{
+			token: "tok", timeFormat: "s",
+			want: influxEndpoint{url: "http://influx:8086/db/caplog/series?time_precision=s", token: "tok", precision: Seconds},
+		},
+	}
+	for _, test := range tests {
+		c := config.Default()
+		c.Outputs.Influx = "http://influx:8086/"
+		c.Outputs.InfluxUser, c.Outputs.InfluxDB = test.user, test.db
+		c.Outputs.InfluxPassword, c.Outputs.InfluxToken = test.pass, test.token
+		if test.timeFormat != "" {
+			c.Outputs.TimeFormat = map[string]string{"influx": test.timeFormat}
+		}
+		e, err := newInfluxEndpoint(c)
+		if err != nil {
+			t.Errorf("newInfluxEndpoint(%+v): %v", c.Outputs, err)
+			continue
+		}
+		if *e != test.want {
+			t.Errorf("newInfluxEndpoint(%+v): got %+v, want %+v", c.Outputs, *e, test.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sinks has outputs for packet metadata.
+package sinks
+
+// This file encodes Metadata as InfluxDB line protocol, which is understood by
+// InfluxDB 1.x-3.x, Telegraf, and a number of other databases.
+
+import (
+	"strconv"
+
+	"packets"
+)
+
+const measurement = "packet"
+
+// AppendLine appends a line of line protocol for the packet to b, including
+// the trailing newline, with the timestamp in nanoseconds. The address
+// family is a tag; everything else is a field, to keep series cardinality
+// down.
+//
+//	packet,family=v4 src_ip="10.0.0.2",dst_ip="8.8.8.8",src_name="10.0.0.2",dst_name="dns.google",src_port=5353i,dst_port=53i,size=74i 1434055562000000000
+func AppendLine(b []byte, m *packets.Metadata) []byte {
+	return AppendLinePrecision(b, m, Nanoseconds)
+}
+
+// AppendLinePrecision is like AppendLine, with the timestamp in another
+// precision. Line protocol timestamps are integers, so it can't be RFC3339.
+func AppendLinePrecision(b []byte, m *packets.Metadata, precision TimeFormat) []byte {
+	b = append(b, measurement...)
+	b = append(b, ",family="...)
+	if m.V6 {
+		b = append(b, "v6"...)
+	} else {
+		b = append(b, "v4"...)
+	}
+	b = append(b, ' ')
+	b = append(b, `src_ip="`...)
+	b = appendIP(b, m.SrcIP)
+	b = append(b, `",dst_ip="`...)
+	b = appendIP(b, m.DstIP)
+	b = append(b, `",src_name=`...)
+	b = appendStringField(b, m.SrcName)
+	b = append(b, ",dst_name="...)
+	b = appendStringField(b, m.DstName)
+	b = append(b, ",src_port="...)
+	b = strconv.AppendUint(b, uint64(m.SrcPort), 10)
+	b = append(b, "i,dst_port="...)
+	b = strconv.AppendUint(b, uint64(m.DstPort), 10)
+	b = append(b, "i,size="...)
+	b = strconv.AppendUint(b, m.Size, 10)
+	b = append(b, "i "...)
+	b = precision.AppendTime(b, m.Timestamp)
+	return append(b, '\n')
+}
+
+// appendStringField appends a quoted string field value, escaping quotes and
+// backslashes.
+func appendStringField(b []byte, value string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c == '"' || c == '\\' {
+			b = append(b, value[start:i]...)
+			b = append(b, '\\', c)
+			start = i + 1
+		}
+	}
+	b = append(b, value[start:]...)
+	return append(b, '"')
+}
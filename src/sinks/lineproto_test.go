@@ -0,0 +1,67 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"packets"
+)
+
+func TestAppendLine(t *testing.T) {
+	got := string(AppendLine(nil, &testPacket))
+	want := `packet,family=v4 src_ip="10.0.0.2",dst_ip="8.8.8.8",src_name="10.0.0.2",dst_name="dns \"google\"",src_port=5353i,dst_port=53i,size=74i 1434055562000000000` + "\n"
+	if got != want {
+		t.Errorf("AppendLine:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestSocketWriter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	lines := make(chan string)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		sc := bufio.NewScanner(c)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+
+	w, err := ParseSocketURL("tcp://" + l.Addr().String())
+	if err != nil {
+		t.Fatalf("ParseSocketURL: %v", err)
+	}
+	if err := w.Write([]packets.Metadata{testPacket, testPacket}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if got := <-lines; !strings.HasPrefix(got, "packet,family=v4 ") {
+			t.Errorf("line %d: got %q", i, got)
+		}
+	}
+}
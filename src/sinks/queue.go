@@ -0,0 +1,579 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file decouples capturing from writing: buffers are queued in memory
+// and written in the background, retrying for as long as the destination is
+// unreachable, so a sink that is down at startup (or later) doesn't stop or
+// slow the capture. Buffers that fail can instead be set aside on disk, and
+// written once the destination is back.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"events"
+	"health"
+	"packets"
+	"vars"
+)
+
+const (
+	// DefaultQueueLength is the default number of buffers a Queue holds.
+	DefaultQueueLength = 100
+
+	// CloseTimeout bounds how long Close waits for the queue to flush.
+	CloseTimeout = 30 * time.Second
+
+	minRetryWait = 100 * time.Millisecond
+	maxRetryWait = time.Minute
+)
+
+// QueueStatus describes the state of a queue, for /healthz.
+type QueueStatus struct {
+	Queued        int
+	Failed        int `json:",omitempty"` // buffers in the failed spool
+	Dropped       uint64
+	Rejected      uint64 // points in buffers the destination rejected
+	Written       uint64
+	LastSuccess   time.Time
+	LastWriteTime time.Duration // how long the last successful write took
+	LastError     string        `json:",omitempty"`
+	LastErrorTime time.Time
+}
+
+// KeyedWrite writes a buffer that has a dedup key ("" if it has none), which
+// stays the same if the buffer is written again. Idempotent destinations
+// can use it to drop buffers they have already seen.
+type KeyedWrite func(key string, data []packets.Metadata) error
+
+// permanentError is an error that retrying won't fix.
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+// Permanent marks err as one that retrying won't fix, such as the
+// destination rejecting the buffer as malformed. A queue drops the buffer
+// instead of retrying it, counting it as rejected. It returns nil if err is
+// nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// IsPermanent reports whether err was marked by Permanent.
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// statusError is the error for an HTTP response that isn't a success. It is
+// permanent if the destination rejected the buffer itself; other errors,
+// such as bad credentials, can be fixed while the buffer waits.
+func statusError(prefix string, resp *http.Response, detail string) error {
+	err := fmt.Errorf("%s: %s", prefix, resp.Status)
+	if detail != "" {
+		err = fmt.Errorf("%s: %s: %s", prefix, resp.Status, detail)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+		return Permanent(err)
+	}
+	return err
+}
+
+// IgnoreKey adapts a write function for destinations without dedup keys.
+func IgnoreKey(write func([]packets.Metadata) error) KeyedWrite {
+	return func(_ string, data []packets.Metadata) error { return write(data) }
+}
+
+// Queue writes buffers with a write function in the background.
+type Queue struct {
+	name  string
+	write KeyedWrite
+	ch    chan []packets.Metadata
+
+	// If spool is set, buffers are queued there instead of in ch, and wake
+	// signals that one was appended. handoff is closed once another queue
+	// (e.g. the output reopened on a reload) reads the spool instead.
+	spool       *Spool
+	wake        chan struct{}
+	handoff     chan struct{}
+	handoffOnce sync.Once
+
+	// If failed is set, buffers that fail to write are appended to it
+	// rather than retried, and replayed once a write succeeds. wait is the
+	// backoff before the next attempt.
+	failed *Spool
+	wait   time.Duration
+
+	// If share is set, the memory queue's room comes from it.
+	share *FairShare
+
+	pending sync.WaitGroup // buffers queued or being written
+
+	done      chan struct{} // closed by Close, to stop the writing goroutine
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	status  QueueStatus
+	failing bool // the last write failed
+}
+
+// NewQueue starts a queue of up to length buffers in front of write, and
+// registers its status with vars and health under the name.
+func NewQueue(name string, length int, write func([]packets.Metadata) error) *Queue {
+	if length <= 0 {
+		length = DefaultQueueLength
+	}
+	q := &Queue{
+		name:  name,
+		write: IgnoreKey(write),
+		ch:    make(chan []packets.Metadata, length),
+		done:  make(chan struct{}),
+	}
+	vars.Register("sink-"+name+"-queued", vars.IntEval(func() int { return len(q.ch) }).String)
+	q.register()
+	health.Register("sink-"+name, q.health)
+	go q.run()
+	return q
+}
+
+// NewSpooledQueue starts a queue kept in a spool, so that buffers not yet
+// written survive a restart, and are written with the spool's dedup keys.
+// Its status is registered like NewQueue's.
+func NewSpooledQueue(name string, s *Spool, write KeyedWrite) *Queue {
+	q := &Queue{
+		name:    name,
+		write:   write,
+		spool:   s,
+		wake:    make(chan struct{}, 1),
+		handoff: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	vars.Register("sink-"+name+"-queued", vars.IntEval(s.Len).String)
+	q.register()
+	health.Register("sink-"+name, q.health)
+	s.claim(q)
+	go q.runSpool()
+	return q
+}
+
+// NewFailedSpoolQueue starts a queue like NewQueue's, except that a buffer
+// that fails to write is appended to the spool instead of being retried. The
+// spooled buffers are written, with the spool's dedup keys, once writes
+// succeed again. Unlike NewSpooledQueue's, buffers only touch the disk while
+// the destination is failing.
+func NewFailedSpoolQueue(name string, length int, s *Spool, write KeyedWrite) *Queue {
+	if length <= 0 {
+		length = DefaultQueueLength
+	}
+	q := &Queue{
+		name:   name,
+		write:  write,
+		ch:     make(chan []packets.Metadata, length),
+		failed: s,
+		done:   make(chan struct{}),
+	}
+	vars.Register("sink-"+name+"-queued", vars.IntEval(func() int { return len(q.ch) }).String)
+	vars.Register("sink-"+name+"-failed", vars.IntEval(s.Len).String)
+	q.register()
+	health.Register("sink-"+name, q.health)
+	s.claim(q)
+	go q.run()
+	return q
+}
+
+// register registers the queue's counts with vars, alongside its length.
+func (q *Queue) register() {
+	vars.Register("sink-"+q.name+"-written", vars.Uint64Eval(func() uint64 { return q.Status().Written }).String)
+	vars.Register("sink-"+q.name+"-dropped", vars.Uint64Eval(func() uint64 { return q.Status().Dropped }).String)
+	vars.Register("sink-"+q.name+"-rejected", vars.Uint64Eval(func() uint64 { return q.Status().Rejected }).String)
+	vars.Register("sink-"+q.name+"-write-ms", vars.Int64Eval(func() int64 { return q.Status().LastWriteTime.Milliseconds() }).String)
+}
+
+// queue returns q, for Open to share memory between queues.
+func (q *Queue) queue() *Queue { return q }
+
+// WritePackets queues a copy of the buffer, dropping the oldest queued buffer
+// if the queue is full. It never blocks, and is suitable for
+// packets.Capture's Log.
+func (q *Queue) WritePackets(data []packets.Metadata) {
+	if len(data) == 0 {
+		return
+	}
+	if q.spool != nil {
+		dropped, err := q.spool.Append(data)
+		if err != nil {
+			// Nowhere to put it.
+			dropped += len(data)
+			log.Printf("%s: spool: %v", q.name, err)
+		}
+		if dropped > 0 {
+			q.mu.Lock()
+			q.status.Dropped += uint64(dropped)
+			q.mu.Unlock()
+			q.dropEvent(dropped)
+		}
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+		return
+	}
+	// The capture reuses buffers once Log returns.
+	b := make([]packets.Metadata, len(data))
+	copy(b, data)
+	q.pending.Add(1)
+	if q.share != nil {
+		q.share.admit(q)
+	}
+	for {
+		select {
+		case q.ch <- b:
+			return
+		default:
+		}
+		q.dropOldest()
+	}
+}
+
+// dropOldest drops the oldest buffer in the memory queue, reporting whether
+// there was one.
+func (q *Queue) dropOldest() bool {
+	select {
+	case old := <-q.ch:
+		q.mu.Lock()
+		q.status.Dropped += uint64(len(old))
+		q.mu.Unlock()
+		q.pending.Done()
+		log.Printf("%s: queue full, dropped %d points", q.name, len(old))
+		q.dropEvent(len(old))
+		return true
+	default:
+		return false
+	}
+}
+
+// run writes queued buffers, until the queue is closed.
+func (q *Queue) run() {
+	for {
+		var b []packets.Metadata
+		select {
+		case b = <-q.ch:
+		case <-q.done:
+			return
+		}
+		if q.failed != nil {
+			q.writeOrSpool(b)
+		} else {
+			q.writeRetrying("", b)
+		}
+		q.pending.Done()
+	}
+}
+
+// writeOrSpool writes a buffer once. If that fails, the buffer goes to the
+// failed spool and the next attempt is delayed; if it succeeds, the spooled
+// buffers are replayed.
+func (q *Queue) writeOrSpool(b []packets.Metadata) {
+	if err := q.attempt("", b); err != nil {
+		if IsPermanent(err) {
+			q.reject(b, err)
+			return
+		}
+		dropped, err := q.failed.Append(b)
+		if err != nil {
+			dropped += len(b)
+			log.Printf("%s: failed spool: %v", q.name, err)
+		}
+		if dropped > 0 {
+			q.mu.Lock()
+			q.status.Dropped += uint64(dropped)
+			q.mu.Unlock()
+			q.dropEvent(dropped)
+		}
+		if q.wait < minRetryWait {
+			q.wait = minRetryWait
+		}
+		q.sleep(q.wait + time.Duration(rand.Int63n(int64(q.wait))))
+		if q.wait *= 2; q.wait > maxRetryWait {
+			q.wait = maxRetryWait
+		}
+		return
+	}
+	q.wait = 0
+	q.replay()
+}
+
+// replay writes spooled buffers, oldest first, until there are none left or
+// one fails. It stops early if the memory queue gets half full, since new
+// buffers are more at risk of being dropped, and does nothing once another
+// queue replays the spool.
+func (q *Queue) replay() {
+	q.failed.reading.Lock()
+	defer q.failed.reading.Unlock()
+	for q.failed.isReader(q) && len(q.ch) <= cap(q.ch)/2 {
+		off, ok := q.failed.Oldest()
+		if !ok {
+			return
+		}
+		b, err := q.failed.Read(off)
+		if err == nil {
+			if err := q.attempt(q.failed.Key(off), b); IsPermanent(err) {
+				q.reject(b, err)
+			} else if err != nil {
+				return
+			}
+		} else {
+			log.Printf("%s: failed spool: %v", q.name, err)
+		}
+		if err := q.failed.Ack(off); err != nil {
+			log.Printf("%s: failed spool: %v", q.name, err)
+			return
+		}
+	}
+}
+
+// runSpool writes spooled buffers, acknowledging each once written, until
+// the queue is closed or another queue reads the spool instead.
+func (q *Queue) runSpool() {
+	for {
+		q.spool.reading.Lock()
+		if !q.spool.isReader(q) {
+			q.spool.reading.Unlock()
+			return
+		}
+		off, ok := q.spool.Oldest()
+		if !ok {
+			q.spool.reading.Unlock()
+			select {
+			case <-q.wake:
+			case <-q.done:
+				return
+			case <-q.handoff:
+				return
+			}
+			continue
+		}
+		written, err := q.writeSpooled(off)
+		q.spool.reading.Unlock()
+		if !written {
+			return
+		}
+		if err != nil {
+			log.Printf("%s: spool: %v", q.name, err)
+			if !q.sleep(maxRetryWait) {
+				return
+			}
+		}
+	}
+}
+
+// writeSpooled writes the spooled buffer at off, and acknowledges it. It
+// returns false if the queue stopped before the buffer was written, leaving
+// it in the spool.
+func (q *Queue) writeSpooled(off uint64) (bool, error) {
+	b, err := q.spool.Read(off)
+	if err == nil {
+		if !q.writeRetrying(q.spool.Key(off), b) {
+			return false, nil
+		}
+	} else {
+		// It was dropped while being read, or is corrupt.
+		log.Printf("%s: spool: %v", q.name, err)
+	}
+	return true, q.spool.Ack(off)
+}
+
+// writeRetrying writes a buffer until it succeeds or is rejected, with
+// fuzzed exponential backoff between attempts. It returns false if the queue
+// stopped first.
+func (q *Queue) writeRetrying(key string, b []packets.Metadata) bool {
+	wait := minRetryWait
+	for {
+		err := q.attempt(key, b)
+		if err == nil {
+			return true
+		}
+		if IsPermanent(err) {
+			q.reject(b, err)
+			return true
+		}
+		log.Printf("%s: %v (retrying in ~%v)", q.name, err, wait)
+		if !q.sleep(wait + time.Duration(rand.Int63n(int64(wait)))) {
+			return false
+		}
+		if wait *= 2; wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+	}
+}
+
+// sleep waits for d, returning false early if the queue is closed or hands
+// its spool over.
+func (q *Queue) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-q.done:
+		return false
+	case <-q.handoff:
+		return false
+	}
+}
+
+// claim makes q the queue that writes the spool's buffers. The queue that
+// did stops once it has finished with the buffer it is writing, so that no
+// buffer is written by both.
+func (s *Spool) claim(q *Queue) {
+	s.mu.Lock()
+	old := s.reader
+	s.reader = q
+	s.mu.Unlock()
+	if old != nil && old.handoff != nil {
+		old.handoffOnce.Do(func() { close(old.handoff) })
+	}
+}
+
+// isReader reports whether q writes the spool's buffers.
+func (s *Spool) isReader(q *Queue) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reader == q
+}
+
+// attempt writes a buffer once, updating the status, and publishing an
+// event if the sink started failing or recovered.
+func (q *Queue) attempt(key string, b []packets.Metadata) error {
+	start := time.Now()
+	err := q.write(key, b)
+	q.mu.Lock()
+	if err == nil {
+		q.status.Written += uint64(len(b))
+		q.status.LastSuccess = time.Now()
+		q.status.LastWriteTime = q.status.LastSuccess.Sub(start)
+	} else {
+		q.status.LastError = err.Error()
+		q.status.LastErrorTime = time.Now()
+	}
+	changed := q.failing != (err != nil)
+	q.failing = err != nil
+	q.mu.Unlock()
+	if changed {
+		e := events.Event{
+			Type:     "sink-recovered",
+			Severity: events.Notice,
+			Message:  fmt.Sprintf("%s: writing again", q.name),
+			Fields:   map[string]string{"sink": q.name},
+		}
+		if err != nil {
+			e.Type = "sink-failing"
+			e.Severity = events.Error
+			e.Message = fmt.Sprintf("%s: %v", q.name, err)
+		}
+		events.Publish(e)
+	}
+	return err
+}
+
+// reject drops a buffer that the destination rejected, counting its points.
+func (q *Queue) reject(b []packets.Metadata, err error) {
+	q.mu.Lock()
+	q.status.Rejected += uint64(len(b))
+	q.mu.Unlock()
+	log.Printf("%s: %v (dropping %d points, as retrying won't help)", q.name, err, len(b))
+}
+
+// dropEvent publishes that n points were dropped.
+func (q *Queue) dropEvent(n int) {
+	events.Publish(events.Event{
+		Type:     "sink-dropped",
+		Severity: events.Warning,
+		Message:  fmt.Sprintf("%s: queue full, dropped %d points", q.name, n),
+		Fields:   map[string]string{"sink": q.name, "points": fmt.Sprint(n)},
+	})
+}
+
+// Write queues a buffer; it never blocks or fails. It is WritePackets as a
+// packets.Sink.
+func (q *Queue) Write(ctx context.Context, data []packets.Metadata) error {
+	q.WritePackets(data)
+	return nil
+}
+
+// Flush waits until everything queued so far has been written (or dropped,
+// or set aside in the failed spool), or ctx is done.
+func (q *Queue) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if q.spool != nil {
+			for q.spool.Len() > 0 && ctx.Err() == nil {
+				time.Sleep(10 * time.Millisecond)
+			}
+			return
+		}
+		q.pending.Wait()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes the queue for up to CloseTimeout, and then stops writing.
+// Buffers not written by then are dropped (though a spool keeps them).
+func (q *Queue) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), CloseTimeout)
+	defer cancel()
+	err := q.Flush(ctx)
+	q.closeOnce.Do(func() { close(q.done) })
+	return err
+}
+
+// Status returns the current status of the queue.
+func (q *Queue) Status() QueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := q.status
+	if q.spool != nil {
+		s.Queued = q.spool.Len()
+	} else {
+		s.Queued = len(q.ch)
+	}
+	if q.failed != nil {
+		s.Failed = q.failed.Len()
+	}
+	return s
+}
+
+// health reports unhealthy if the most recent attempt failed.
+func (q *Queue) health() (bool, interface{}) {
+	s := q.Status()
+	return !s.LastErrorTime.After(s.LastSuccess), s
+}
@@ -0,0 +1,193 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"packets"
+)
+
+var testPacket = packets.Metadata{
+	Timestamp: time.Unix(1434055562, 0),
+	Size:      74,
+	SrcName:   "10.0.0.2",
+	DstName:   `dns "google"`,
+	SrcIP:     net.ParseIP("10.0.0.2"),
+	DstIP:     net.ParseIP("8.8.8.8"),
+	SrcPort:   5353,
+	DstPort:   53,
+}
+
+func TestQueueRetries(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		written  []packets.Metadata
+	)
+	q := NewQueue("test-retries", 10, func(data []packets.Metadata) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts < 3 {
+			return errors.New("unreachable")
+		}
+		written = append(written, data...)
+		return nil
+	})
+	buf := []packets.Metadata{testPacket}
+	q.WritePackets(buf)
+	// The capture reuses the buffer; the queue must have copied it.
+	buf[0].Size = 0
+	q.Flush(context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 {
+		t.Errorf("attempts: got %d, want 3", attempts)
+	}
+	if len(written) != 1 || written[0].Size != testPacket.Size {
+		t.Errorf("written: got %+v, want [%+v]", written, testPacket)
+	}
+	s := q.Status()
+	if ok, _ := q.health(); !ok {
+		t.Errorf("health after recovery: got unhealthy, status %+v", s)
+	}
+	if s.Written != 1 {
+		t.Errorf("Status().Written: got %d, want 1", s.Written)
+	}
+}
+
+func TestFailedSpoolQueue(t *testing.T) {
+	dir, cleanup := tempSpool(t)
+	defer cleanup()
+	s, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("OpenSpool: %v", err)
+	}
+	s.KeyPrefix = "probe/test"
+
+	var (
+		mu   sync.Mutex
+		down = true
+		keys []string
+	)
+	q := NewFailedSpoolQueue("test-failed-spool", 10, s, func(key string, data []packets.Metadata) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			return errors.New("unreachable")
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	q.WritePackets([]packets.Metadata{testPacket})
+	q.WritePackets([]packets.Metadata{testPacket})
+	q.Flush(context.Background())
+	if got, want := q.Status().Failed, 2; got != want {
+		t.Fatalf("Status().Failed while down: got %d, want %d", got, want)
+	}
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	q.WritePackets([]packets.Metadata{testPacket})
+	q.Flush(context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The new buffer first, then the spooled ones, oldest first.
+	if want := []string{"", "probe/test/1", "probe/test/2"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("written keys: got %q, want %q", keys, want)
+	}
+	st := q.Status()
+	if st.Failed != 0 || st.Written != 3 {
+		t.Errorf("Status after recovery: got %+v, want Failed 0, Written 3", st)
+	}
+}
+
+func TestQueueRejects(t *testing.T) {
+	dir, cleanup := tempSpool(t)
+	defer cleanup()
+	s, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("OpenSpool: %v", err)
+	}
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	write := func(string, []packets.Metadata) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		return Permanent(errors.New("malformed"))
+	}
+	queues := []*Queue{
+		NewQueue("test-rejects", 10, func(data []packets.Metadata) error { return write("", data) }),
+		NewFailedSpoolQueue("test-rejects-failed", 10, s, write),
+	}
+	for _, q := range queues {
+		mu.Lock()
+		attempts = 0
+		mu.Unlock()
+		q.WritePackets([]packets.Metadata{testPacket, testPacket})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := q.Flush(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("%s: Flush: %v", q.name, err)
+		}
+		mu.Lock()
+		if attempts != 1 {
+			t.Errorf("%s: attempts: got %d, want 1", q.name, attempts)
+		}
+		mu.Unlock()
+		if st := q.Status(); st.Rejected != 2 || st.Failed != 0 {
+			t.Errorf("%s: Status: got %+v, want Rejected 2, Failed 0", q.name, st)
+		}
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		code      int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusRequestEntityTooLarge, true},
+		{http.StatusUnprocessableEntity, true},
+		{http.StatusUnauthorized, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusServiceUnavailable, false},
+	}
+	for _, test := range tests {
+		resp := &http.Response{StatusCode: test.code, Status: http.StatusText(test.code)}
+		err := statusError("test", resp, "")
+		if got := IsPermanent(err); got != test.permanent {
+			t.Errorf("IsPermanent(statusError(%d)): got %v, want %v", test.code, got, test.permanent)
+		}
+	}
+	if IsPermanent(errors.New("unreachable")) || IsPermanent(nil) || Permanent(nil) != nil {
+		t.Error("IsPermanent: got true for an unmarked error")
+	}
+}
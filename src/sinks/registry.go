@@ -0,0 +1,285 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file keeps the registry of sinks, so that a new backend only needs to
+// register itself to be configurable.
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"config"
+	"packets"
+)
+
+// Opener makes a sink from the config, or returns nil if the config doesn't
+// use it. The sink should not contact its destination yet.
+type Opener func(c *config.Config) (packets.Sink, error)
+
+// FlowSink receives flow records (see packets.FlowTable).
+type FlowSink interface {
+	Write(flows []packets.Flow) error
+	Close() error
+}
+
+// FlowOpener makes a flow sink from the config, or returns nil if the config
+// doesn't use it.
+type FlowOpener func(c *config.Config) (FlowSink, error)
+
+var (
+	registryMu   sync.Mutex
+	registry     = make(map[string]Opener)
+	flowRegistry = make(map[string]FlowOpener)
+)
+
+// Register makes a sink available under a name, which is also the name used
+// with -sink and in /healthz.
+func Register(name string, open Opener) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("sinks: " + name + " registered twice")
+	}
+	registry[name] = open
+}
+
+// RegisterFlows makes a flow sink available under a name, which is also the
+// name used in outputs.time_format.
+func RegisterFlows(name string, open FlowOpener) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := flowRegistry[name]; ok {
+		panic("sinks: " + name + " registered twice")
+	}
+	flowRegistry[name] = open
+}
+
+// Names returns the names of the registered sinks, sorted.
+func Names() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the sinks configured in c, combined into one. If only is
+// non-empty, just the named sinks are opened, and it is an error to name one
+// that isn't configured. Open returns nil if no sinks are configured.
+func Open(c *config.Config, only []string) (packets.Sink, error) {
+	names, explicit := only, true
+	if len(names) == 0 {
+		names, explicit = Names(), false
+	}
+	for name := range c.Outputs.TimeFormat {
+		if err := known("outputs.time_format", name); err != nil {
+			return nil, err
+		}
+	}
+	for name, w := range c.Outputs.Weights {
+		if err := known("outputs.weights", name); err != nil {
+			return nil, err
+		}
+		if w <= 0 {
+			return nil, fmt.Errorf("outputs.weights: the weight of %q must be positive, not %d", name, w)
+		}
+	}
+	var ss Tee
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		registryMu.Lock()
+		open := registry[name]
+		registryMu.Unlock()
+		if open == nil {
+			return nil, fmt.Errorf("unknown output %q (known: %s)", name, strings.Join(Names(), ", "))
+		}
+		s, err := open(c)
+		if err != nil {
+			return nil, err
+		}
+		if s == nil {
+			if explicit {
+				return nil, fmt.Errorf("output %q is not configured (set it under \"outputs\" in the config)", name)
+			}
+			continue
+		}
+		ss = append(ss, s)
+	}
+	if c.Outputs.QueueBudget > 0 {
+		share := NewFairShare(c.Outputs.QueueBudget)
+		for _, s := range ss {
+			q, ok := s.(interface{ queue() *Queue })
+			if !ok || q.queue() == nil || q.queue().ch == nil {
+				continue // not queued, or spooled
+			}
+			if err := share.Join(q.queue(), c.Outputs.Weights[q.queue().name]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	switch len(ss) {
+	case 0:
+		return nil, nil
+	case 1:
+		return ss[0], nil
+	}
+	return ss, nil
+}
+
+// OpenFlows opens the flow sinks configured in c, combined into one, or
+// returns nil if there are none.
+func OpenFlows(c *config.Config) (FlowSink, error) {
+	registryMu.Lock()
+	names := make([]string, 0, len(flowRegistry))
+	for name := range flowRegistry {
+		names = append(names, name)
+	}
+	registryMu.Unlock()
+	sort.Strings(names)
+	var fs FlowTee
+	for _, name := range names {
+		registryMu.Lock()
+		open := flowRegistry[name]
+		registryMu.Unlock()
+		s, err := open(c)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			fs = append(fs, s)
+		}
+	}
+	switch len(fs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return fs[0], nil
+	}
+	return fs, nil
+}
+
+// known returns an error if the config setting key names an output that
+// isn't registered.
+func known(key, name string) error {
+	registryMu.Lock()
+	open, flowOpen := registry[name], flowRegistry[name]
+	registryMu.Unlock()
+	if open == nil && flowOpen == nil {
+		return fmt.Errorf("%s: unknown output %q (known: %s)", key, name, strings.Join(Names(), ", "))
+	}
+	return nil
+}
+
+// queued wraps write in a Queue for the named sink: spooled to disk if the
+// config has a spool_dir, otherwise in memory, with buffers that fail set
+// aside on disk if it has a failed_spool_dir.
+func queued(c *config.Config, name string, write KeyedWrite) (packets.Sink, error) {
+	length := c.QueueLength
+	if c.Outputs.QueueBudget > 0 {
+		length = c.Outputs.QueueBudget // Open joins the queue to the share
+	}
+	dir := c.Outputs.SpoolDir
+	if dir == "" {
+		dir = c.Outputs.FailedSpoolDir
+	}
+	if dir == "" {
+		return NewQueue(name, length, func(data []packets.Metadata) error { return write("", data) }), nil
+	}
+	s, err := OpenSpool(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	s.KeyPrefix = c.ProbeName() + "/" + name
+	s.MaxBytes = int64(c.Outputs.SpoolMaxMB) << 20
+	if c.Outputs.SpoolDir == "" {
+		return NewFailedSpoolQueue(name, length, s, write), nil
+	}
+	return NewSpooledQueue(name, s, write), nil
+}
+
+// parseHTTPURL checks that s is an absolute http or https URL, without
+// contacting it.
+func parseHTTPURL(key, s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a URL: %v", key, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s must be an http:// or https:// URL, got %q", key, s)
+	}
+	return u, nil
+}
+
+// Tee is a Sink that writes to several sinks. Errors are from the first sink
+// that fails, but every sink is written to regardless.
+type Tee []packets.Sink
+
+// Write writes data to every sink.
+func (t Tee) Write(ctx context.Context, data []packets.Metadata) error {
+	return t.each(func(s packets.Sink) error { return s.Write(ctx, data) })
+}
+
+// Flush flushes every sink.
+func (t Tee) Flush(ctx context.Context) error {
+	return t.each(func(s packets.Sink) error { return s.Flush(ctx) })
+}
+
+// Close closes every sink.
+func (t Tee) Close() error {
+	return t.each(packets.Sink.Close)
+}
+
+func (t Tee) each(f func(packets.Sink) error) error {
+	var first error
+	for _, s := range t {
+		if err := f(s); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// FlowTee is a FlowSink that writes to several flow sinks. Errors are from
+// the first that fails, but every one is written to regardless.
+type FlowTee []FlowSink
+
+// Write writes the records to every flow sink.
+func (t FlowTee) Write(flows []packets.Flow) error {
+	return t.each(func(s FlowSink) error { return s.Write(flows) })
+}
+
+// Close closes every flow sink.
+func (t FlowTee) Close() error {
+	return t.each(FlowSink.Close)
+}
+
+func (t FlowTee) each(f func(FlowSink) error) error {
+	var first error
+	for _, s := range t {
+		if err := f(s); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"config"
+	"packets"
+)
+
+func TestOpen(t *testing.T) {
+	c := config.Default()
+	c.Outputs.File = filepath.Join(t.TempDir(), "out")
+
+	s, err := Open(c, nil)
+	if err != nil {
+		t.Fatalf("Open(nil): %v", err)
+	}
+	if _, ok := s.(*Queue); !ok {
+		t.Errorf("Open(nil): got %T, want *Queue", s)
+	}
+
+	for _, only := range [][]string{{"victoria"}, {"no-such-sink"}} {
+		if _, err := Open(c, only); err == nil {
+			t.Errorf("Open(%v): got nil error, want error", only)
+		}
+	}
+
+	if s, err := Open(config.Default(), nil); s != nil || err != nil {
+		t.Errorf("Open with no outputs: got (%v, %v), want (nil, nil)", s, err)
+	}
+}
+
+func TestTee(t *testing.T) {
+	var n int
+	ok := packets.SinkFunc(func(context.Context, []packets.Metadata) error {
+		n++
+		return nil
+	})
+	fail := packets.SinkFunc(func(context.Context, []packets.Metadata) error {
+		return errors.New("failed")
+	})
+	err := Tee{ok, fail, ok}.Write(context.Background(), []packets.Metadata{{}})
+	if err == nil {
+		t.Error("Tee.Write: got nil error, want error")
+	}
+	if n != 2 {
+		t.Errorf("Tee.Write wrote to %d good sinks, want 2", n)
+	}
+}
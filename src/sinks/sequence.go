@@ -0,0 +1,182 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file numbers exported batches so that consumers can spot gaps and
+// duplicates, even across restarts.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vars"
+)
+
+// Sequence hands out increasing batch sequence numbers. If it has a path,
+// the last number used is saved there, and numbering carries on from it
+// after a restart.
+//
+// A number is only used up once Done is called, after the batch has been
+// written: a failed write is retried with the same number, and a crash
+// between the write and Done repeats the number (a duplicate, which
+// consumers can drop) rather than skipping it (a false gap).
+//
+// The numbering has an epoch, saved with it, so that consumers can tell a
+// sender that started again from 1 from a late batch.
+type Sequence struct {
+	path string
+
+	mu    sync.Mutex
+	last  uint64
+	epoch uint64 // when numbering started, in Unix nanoseconds; 0 until needed
+}
+
+// sequenceErrors counts the numbers that couldn't be saved, accessed
+// atomically.
+var sequenceErrors uint64
+
+func init() {
+	vars.Uint64("sink-sequence-errors", &sequenceErrors)
+}
+
+// sequences are the sequences opened, by path.
+var sequences struct {
+	sync.Mutex
+	m map[string]*Sequence
+}
+
+// OpenSequence loads a sequence from path. If path is empty, or the file
+// doesn't exist yet, numbering starts at 1. Opening a path again returns the
+// same Sequence, so that an output reopened when the config is reloaded
+// carries on with it.
+func OpenSequence(path string) (*Sequence, error) {
+	if path == "" {
+		return &Sequence{}, nil
+	}
+	sequences.Lock()
+	defer sequences.Unlock()
+	if s := sequences.m[filepath.Clean(path)]; s != nil {
+		return s, nil
+	}
+	s, err := openSequence(path)
+	if err != nil {
+		return nil, err
+	}
+	if sequences.m == nil {
+		sequences.m = make(map[string]*Sequence)
+	}
+	sequences.m[filepath.Clean(path)] = s
+	return s, nil
+}
+
+func openSequence(path string) (*Sequence, error) {
+	s := &Sequence{path: path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The last number used, and its epoch (missing from older files).
+	fields := strings.Fields(string(b))
+	if len(fields) < 1 || len(fields) > 2 {
+		return nil, fmt.Errorf("sequence file %s: want a number and its epoch, got %q", path, b)
+	}
+	if s.last, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return nil, fmt.Errorf("sequence file %s: %v", path, err)
+	}
+	if len(fields) == 2 {
+		if s.epoch, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("sequence file %s: epoch: %v", path, err)
+		}
+	}
+	return s, nil
+}
+
+// Next returns the number for the next batch.
+func (s *Sequence) Next() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last + 1
+}
+
+// Epoch returns when the numbering started (in Unix nanoseconds). It is
+// chosen, and saved, the first time it is needed.
+func (s *Sequence) Epoch() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.epoch == 0 {
+		s.epoch = uint64(time.Now().UnixNano())
+		// If this fails, Done tries again.
+		s.save()
+	}
+	return s.epoch
+}
+
+// Done records that batch n was written. The number is used up even if it
+// can't be saved, since the batch was written; the error only means that a
+// restart would repeat it.
+func (s *Sequence) Done(n uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n <= s.last {
+		return nil
+	}
+	s.last = n
+	return s.save()
+}
+
+// save writes the last number and the epoch to the file, if there is one.
+// s.mu must be held.
+func (s *Sequence) save() error {
+	if s.path == "" {
+		return nil
+	}
+	// Write then rename, so the file is never half written.
+	tmp := s.path + ".tmp"
+	line := strconv.FormatUint(s.last, 10) + " " + strconv.FormatUint(s.epoch, 10) + "\n"
+	if err := ioutil.WriteFile(tmp, []byte(line), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// written calls Done for a batch that was written, logging (as the sink
+// name) and counting in sink-sequence-errors a number that can't be saved.
+// The write isn't failed for it, as retrying would write the batch again.
+func (s *Sequence) written(name string, n uint64) {
+	if err := s.Done(n); err != nil {
+		log.Printf("%s: saving sequence number %d: %v", name, n, err)
+		atomic.AddUint64(&sequenceErrors, 1)
+	}
+}
+
+// SequencePath is the file a sink's sequence is kept in, under stateDir. It
+// is empty (not kept) if stateDir is.
+func SequencePath(stateDir, sink string) string {
+	if stateDir == "" {
+		return ""
+	}
+	return filepath.Join(stateDir, sink+".seq")
+}
@@ -0,0 +1,122 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSequencePersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequence")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := SequencePath(dir, "out")
+
+	s, err := OpenSequence(path)
+	if err != nil {
+		t.Fatalf("OpenSequence: %v", err)
+	}
+	epoch := s.Epoch()
+	if epoch == 0 {
+		t.Errorf("Epoch: got 0")
+	}
+	for want := uint64(1); want <= 3; want++ {
+		n := s.Next()
+		if n != want {
+			t.Errorf("Next: got %d, want %d", n, want)
+		}
+		if err := s.Done(n); err != nil {
+			t.Fatalf("Done(%d): %v", n, err)
+		}
+	}
+	// Not Done: the number is reused.
+	if got, want := s.Next(), uint64(4); got != want {
+		t.Errorf("Next: got %d, want %d", got, want)
+	}
+
+	// "Restart".
+	s, err = openSequence(path)
+	if err != nil {
+		t.Fatalf("OpenSequence again: %v", err)
+	}
+	if got, want := s.Next(), uint64(4); got != want {
+		t.Errorf("Next after reopening: got %d, want %d", got, want)
+	}
+	if got := s.Epoch(); got != epoch {
+		t.Errorf("Epoch after reopening: got %d, want %d", got, epoch)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.seq.tmp")); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestSequenceOldFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequence")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// Written before epochs were kept.
+	path := SequencePath(dir, "out")
+	if err := ioutil.WriteFile(path, []byte("7\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	s, err := OpenSequence(path)
+	if err != nil {
+		t.Fatalf("OpenSequence: %v", err)
+	}
+	if got, want := s.Next(), uint64(8); got != want {
+		t.Errorf("Next: got %d, want %d", got, want)
+	}
+	// The epoch is chosen, and kept, without restarting the numbering.
+	epoch := s.Epoch()
+	if s, err = openSequence(path); err != nil {
+		t.Fatalf("OpenSequence again: %v", err)
+	}
+	if got, want := s.Next(), uint64(8); got != want {
+		t.Errorf("Next after reopening: got %d, want %d", got, want)
+	}
+	if got := s.Epoch(); got != epoch {
+		t.Errorf("Epoch after reopening: got %d, want %d", got, epoch)
+	}
+}
+
+func TestSequenceSaveFails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequence")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// The state directory is gone, so the number can't be saved.
+	s, err := OpenSequence(SequencePath(filepath.Join(dir, "gone"), "out"))
+	if err != nil {
+		t.Fatalf("OpenSequence: %v", err)
+	}
+	before := atomic.LoadUint64(&sequenceErrors)
+	s.written("out", s.Next())
+	if got := atomic.LoadUint64(&sequenceErrors) - before; got != 1 {
+		t.Errorf("sequenceErrors: got %d more, want 1", got)
+	}
+	// The number is used up all the same.
+	if got, want := s.Next(), uint64(2); got != want {
+		t.Errorf("Next: got %d, want %d", got, want)
+	}
+}
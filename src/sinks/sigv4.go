@@ -0,0 +1,127 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file signs requests to S3 and S3-compatible stores with AWS Signature
+// Version 4, in the Authorization header.
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const awsTimeFormat = "20060102T150405Z"
+
+// awsCredentials are an access key, and the session token that goes with
+// temporary ones.
+type awsCredentials struct {
+	AccessKey, SecretKey, SessionToken string
+}
+
+// sign signs the request for the service in the region at t, covering the
+// host and every header set on it. payloadHash is the hex SHA-256 of the
+// body, which S3 also wants as x-amz-content-sha256.
+func (c awsCredentials) sign(r *http.Request, region, service, payloadHash string, t time.Time) {
+	t = t.UTC()
+	r.Header.Set("X-Amz-Date", t.Format(awsTimeFormat))
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.SessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canon strings.Builder
+	canon.WriteString(r.Method + "\n")
+	canon.WriteString(awsURIEncode(r.URL.Path, false) + "\n")
+	canon.WriteString(awsCanonicalQuery(r) + "\n")
+	for _, k := range names {
+		canon.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canon.WriteString("\n" + signed + "\n" + payloadHash)
+
+	date := t.Format("20060102")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	sum := sha256.Sum256([]byte(canon.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + t.Format(awsTimeFormat) + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.AccessKey+"/"+scope+", SignedHeaders="+signed+", Signature="+sig)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsCanonicalQuery is the query string, sorted and encoded as SigV4 wants.
+func awsCanonicalQuery(r *http.Request) string {
+	q := r.URL.Query()
+	if len(q) == 0 {
+		return ""
+	}
+	var pairs []string
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode percent-encodes everything but the unreserved characters
+// (and the slashes, unless encodeSlash).
+func awsURIEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
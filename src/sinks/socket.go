@@ -0,0 +1,137 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file writes line protocol to a socket, such as a Telegraf
+// socket_listener, which can then take care of buffering and routing.
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"config"
+	"packets"
+)
+
+func init() {
+	Register("telegraf", func(c *config.Config) (packets.Sink, error) {
+		if c.Outputs.Telegraf == "" {
+			return nil, nil
+		}
+		sw, err := ParseSocketURL(c.Outputs.Telegraf)
+		if err != nil {
+			return nil, fmt.Errorf("outputs.telegraf must be a socket URL: %v", err)
+		}
+		if sw.Precision, err = timeFormat(c, "telegraf", Nanoseconds, Microseconds, Milliseconds, Seconds); err != nil {
+			return nil, err
+		}
+		return queued(c, "telegraf", IgnoreKey(sw.Write))
+	})
+}
+
+// maxDatagram is the largest datagram written to a packet-oriented socket.
+// Lines are never split across datagrams.
+const maxDatagram = 8192
+
+// SocketWriter writes line protocol to a stream or datagram socket.
+type SocketWriter struct {
+	Network string // "udp", "tcp", "unix" or "unixgram"
+	Address string
+
+	// Precision is the precision of the timestamps written: Nanoseconds
+	// (the default), Microseconds, Milliseconds or Seconds. The reader
+	// has to be told which, e.g. Telegraf's influx_timestamp_precision.
+	Precision TimeFormat
+
+	mu   sync.Mutex
+	conn net.Conn
+	buf  []byte
+}
+
+// ParseSocketURL makes a SocketWriter from a URL such as
+// udp://127.0.0.1:8094 or unixgram:///run/telegraf.sock.
+func ParseSocketURL(s string) (*SocketWriter, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	w := &SocketWriter{Network: u.Scheme}
+	switch u.Scheme {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+		w.Address = u.Host
+	case "unix", "unixgram":
+		w.Address = u.Path
+	default:
+		return nil, fmt.Errorf("unsupported socket type %q", u.Scheme)
+	}
+	if w.Address == "" {
+		return nil, fmt.Errorf("missing address in %q", s)
+	}
+	return w, nil
+}
+
+func (w *SocketWriter) datagrams() bool {
+	switch w.Network {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	}
+	return false
+}
+
+// Write writes a buffer of packet metadata, (re)connecting if necessary.
+func (w *SocketWriter) Write(data []packets.Metadata) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.write(data); err != nil {
+		if w.conn != nil {
+			w.conn.Close()
+			w.conn = nil
+		}
+		return fmt.Errorf("writing to %s %s: %v", w.Network, w.Address, err)
+	}
+	return nil
+}
+
+func (w *SocketWriter) write(data []packets.Metadata) error {
+	if w.conn == nil {
+		c, err := net.DialTimeout(w.Network, w.Address, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		w.conn = c
+	}
+	w.buf = w.buf[:0]
+	for i := range data {
+		n := len(w.buf)
+		w.buf = AppendLinePrecision(w.buf, &data[i], w.Precision)
+		if w.datagrams() && len(w.buf) > maxDatagram && n > 0 {
+			// Send everything before this line, and start again with it.
+			if _, err := w.conn.Write(w.buf[:n]); err != nil {
+				return err
+			}
+			w.buf = append(w.buf[:0], w.buf[n:]...)
+		}
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.conn.Write(w.buf)
+	return err
+}
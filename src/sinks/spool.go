@@ -0,0 +1,258 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file keeps queued batches on disk, so they survive a restart.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"flowpb"
+	"packets"
+)
+
+const (
+	// DefaultSpoolLength is the default number of batches a Spool holds.
+	DefaultSpoolLength = 10000
+
+	spoolSuffix = ".batch"
+	ackedFile   = "acked"
+)
+
+// Spool is an on-disk queue of batches. Batches are numbered (their offset)
+// in the order they are appended. Once a batch is written to its
+// destination it is acknowledged: the highest acknowledged offset is saved
+// first, then the batch is deleted, so a crash in between can't cause the
+// batch to be sent again. A crash between writing and acknowledging can, so
+// each batch also has a dedup key that stays the same across restarts.
+type Spool struct {
+	dir string
+
+	// KeyPrefix starts every dedup key, e.g. "probe/sink". It should be
+	// unique to the spool.
+	KeyPrefix string
+
+	// Length is the most batches to hold. Beyond it, the oldest are dropped.
+	Length int
+
+	// MaxBytes, if positive, is the most disk space the batches may take.
+	// Beyond it, the oldest are dropped.
+	MaxBytes int64
+
+	mu     sync.Mutex
+	acked  uint64 // batches up to here are done
+	next   uint64 // offset of the next batch appended
+	bytes  int64  // size of the waiting batches
+	reader *Queue // the queue writing the batches
+
+	// reading is held by the reader while it writes a batch.
+	reading sync.Mutex
+}
+
+// spools are the spools opened, by directory.
+var spools struct {
+	sync.Mutex
+	m map[string]*Spool
+}
+
+// OpenSpool opens (creating if needed) the spool in dir. Opening a directory
+// again returns the same Spool, so that an output reopened when the config
+// is reloaded carries on with it.
+func OpenSpool(dir string) (*Spool, error) {
+	spools.Lock()
+	defer spools.Unlock()
+	if s := spools.m[filepath.Clean(dir)]; s != nil {
+		return s, nil
+	}
+	s, err := openSpool(dir)
+	if err != nil {
+		return nil, err
+	}
+	if spools.m == nil {
+		spools.m = make(map[string]*Spool)
+	}
+	spools.m[filepath.Clean(dir)] = s
+	return s, nil
+}
+
+func openSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, Length: DefaultSpoolLength}
+	b, err := ioutil.ReadFile(filepath.Join(dir, ackedFile))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if s.acked, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err != nil {
+			return nil, fmt.Errorf("spool %s: %v", dir, err)
+		}
+	}
+	s.next = s.acked + 1
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		off, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(f), spoolSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		if off <= s.acked {
+			// Acknowledged, but not deleted before a crash.
+			os.Remove(f)
+			continue
+		}
+		if off >= s.next {
+			s.next = off + 1
+		}
+		if fi, err := os.Stat(f); err == nil {
+			s.bytes += fi.Size()
+		}
+	}
+	return s, nil
+}
+
+func (s *Spool) path(off uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", off, spoolSuffix))
+}
+
+// Key returns the dedup key of the batch at off.
+func (s *Spool) Key(off uint64) string {
+	return s.KeyPrefix + "/" + strconv.FormatUint(off, 10)
+}
+
+// Len returns the number of batches waiting.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int(s.next - s.acked - 1)
+}
+
+// Bytes returns the size of the batches waiting.
+func (s *Spool) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+// Append adds a batch. It returns the number of packets in batches dropped
+// to make room. The batch just added is kept even if it alone is over
+// MaxBytes.
+func (s *Spool) Append(data []packets.Metadata) (dropped int, err error) {
+	b := newBatch(data)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	off := s.next
+	b.Sequence = off
+	raw := b.Marshal(nil)
+	tmp := s.path(off) + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, s.path(off)); err != nil {
+		return 0, err
+	}
+	s.next++
+	s.bytes += int64(len(raw))
+	for s.full() {
+		old, err := s.read(s.acked + 1)
+		if err == nil {
+			dropped += len(old)
+		}
+		if err := s.ack(s.acked + 1); err != nil {
+			return dropped, err
+		}
+	}
+	return dropped, nil
+}
+
+// Oldest returns the offset of the oldest waiting batch, and false if there
+// are none.
+func (s *Spool) Oldest() (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acked + 1, s.acked+1 < s.next
+}
+
+// Read returns the batch at off.
+func (s *Spool) Read(off uint64) ([]packets.Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(off)
+}
+
+func (s *Spool) read(off uint64) ([]packets.Metadata, error) {
+	raw, err := ioutil.ReadFile(s.path(off))
+	if err != nil {
+		return nil, err
+	}
+	var b flowpb.Batch
+	if err := b.Unmarshal(raw); err != nil {
+		return nil, fmt.Errorf("spool %s: batch %d: %v", s.dir, off, err)
+	}
+	data := make([]packets.Metadata, len(b.Packets))
+	for i := range b.Packets {
+		data[i] = b.Packets[i].ToMetadata()
+	}
+	return data, nil
+}
+
+// Ack records that the batch at off (and every one before it) was written,
+// and deletes it.
+func (s *Spool) Ack(off uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if off <= s.acked {
+		return nil
+	}
+	return s.ack(off)
+}
+
+func (s *Spool) ack(off uint64) error {
+	path := filepath.Join(s.dir, ackedFile)
+	if err := ioutil.WriteFile(path+".tmp", []byte(strconv.FormatUint(off, 10)+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	for o := s.acked + 1; o <= off; o++ {
+		if fi, err := os.Stat(s.path(o)); err == nil {
+			s.bytes -= fi.Size()
+		}
+		os.Remove(s.path(o))
+	}
+	s.acked = off
+	return nil
+}
+
+// full reports whether the oldest batch must be dropped to make room.
+// s.mu must be held.
+func (s *Spool) full() bool {
+	n := int(s.next - s.acked - 1)
+	if s.Length > 0 && n > s.Length {
+		return true
+	}
+	return s.MaxBytes > 0 && s.bytes > s.MaxBytes && n > 1
+}
@@ -0,0 +1,262 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"packets"
+)
+
+func tempSpool(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestSpoolSurvivesRestart(t *testing.T) {
+	dir, cleanup := tempSpool(t)
+	defer cleanup()
+
+	s, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("OpenSpool: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := s.Append([]packets.Metadata{testPacket}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if err := s.Ack(1); err != nil {
+		t.Fatalf("Ack(1): %v", err)
+	}
+
+	// Crash after acknowledging batch 2, before deleting it.
+	if err := ioutil.WriteFile(filepath.Join(dir, ackedFile), []byte("2\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	s, err = openSpool(dir) // as if restarted
+	if err != nil {
+		t.Fatalf("OpenSpool again: %v", err)
+	}
+	off, ok := s.Oldest()
+	if !ok || off != 3 {
+		t.Fatalf("Oldest after restart: got %d, %t, want 3, true", off, ok)
+	}
+	if _, err := os.Stat(s.path(2)); !os.IsNotExist(err) {
+		t.Errorf("acknowledged batch 2 still spooled: %v", err)
+	}
+	data, err := s.Read(off)
+	if err != nil {
+		t.Fatalf("Read(%d): %v", off, err)
+	}
+	if len(data) != 1 || data[0].Size != testPacket.Size || !data[0].SrcIP.Equal(testPacket.SrcIP) {
+		t.Errorf("Read(%d): got %+v, want [%+v]", off, data, testPacket)
+	}
+	s.KeyPrefix = "probe/out"
+	if got, want := s.Key(off), "probe/out/3"; got != want {
+		t.Errorf("Key(%d): got %q, want %q", off, got, want)
+	}
+}
+
+func TestSpoolDropsOldest(t *testing.T) {
+	dir, cleanup := tempSpool(t)
+	defer cleanup()
+
+	s, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("OpenSpool: %v", err)
+	}
+	s.Length = 2
+	var dropped int
+	for i := 0; i < 3; i++ {
+		n, err := s.Append([]packets.Metadata{testPacket, testPacket})
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		dropped += n
+	}
+	if dropped != 2 {
+		t.Errorf("dropped packets: got %d, want 2", dropped)
+	}
+	if off, _ := s.Oldest(); off != 2 || s.Len() != 2 {
+		t.Errorf("Oldest, Len: got %d, %d, want 2, 2", off, s.Len())
+	}
+}
+
+func TestSpooledQueueKeys(t *testing.T) {
+	dir, cleanup := tempSpool(t)
+	defer cleanup()
+
+	s, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("OpenSpool: %v", err)
+	}
+	s.KeyPrefix = "probe/test-spooled"
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	q := NewSpooledQueue("test-spooled", s, func(key string, data []packets.Metadata) error {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, key)
+		return nil
+	})
+	q.WritePackets([]packets.Metadata{testPacket})
+	q.WritePackets([]packets.Metadata{testPacket})
+	q.Flush(context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"probe/test-spooled/1", "probe/test-spooled/2"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys: got %v, want %v", keys, want)
+	}
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	dir, cleanup := tempSpool(t)
+	defer cleanup()
+
+	s, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("OpenSpool: %v", err)
+	}
+	if _, err := s.Append([]packets.Metadata{testPacket}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	one := s.Bytes()
+	if one <= 0 {
+		t.Fatalf("Bytes after one Append: got %d, want > 0", one)
+	}
+	s.MaxBytes = 2*one + one/2
+	dropped := 0
+	for i := 0; i < 4; i++ {
+		n, err := s.Append([]packets.Metadata{testPacket})
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		dropped += n
+	}
+	if got, want := s.Len(), 2; got != want {
+		t.Errorf("Len: got %d, want %d", got, want)
+	}
+	if got, want := dropped, 3; got != want {
+		t.Errorf("dropped: got %d, want %d", got, want)
+	}
+	if got, want := s.Bytes(), 2*one; got != want {
+		t.Errorf("Bytes: got %d, want %d", got, want)
+	}
+
+	// The size is counted again after a restart.
+	s, err = openSpool(dir)
+	if err != nil {
+		t.Fatalf("OpenSpool again: %v", err)
+	}
+	if got, want := s.Bytes(), 2*one; got != want {
+		t.Errorf("Bytes after reopening: got %d, want %d", got, want)
+	}
+}
+
+func TestOpenSpoolShared(t *testing.T) {
+	dir, cleanup := tempSpool(t)
+	defer cleanup()
+	a, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("OpenSpool: %v", err)
+	}
+	b, err := OpenSpool(dir + "/")
+	if err != nil {
+		t.Fatalf("OpenSpool again: %v", err)
+	}
+	if a != b {
+		t.Error("OpenSpool twice: got different spools, want the same one")
+	}
+}
+
+func TestSpoolHandover(t *testing.T) {
+	dir, cleanup := tempSpool(t)
+	defer cleanup()
+	s, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("OpenSpool: %v", err)
+	}
+	s.KeyPrefix = "probe/test-handover"
+
+	// The output is down, so the first queue is retrying its oldest buffer
+	// when the outputs are reopened.
+	var (
+		mu   sync.Mutex
+		down = true
+		sent = make(map[string]int)
+	)
+	write := func(key string, data []packets.Metadata) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			return errors.New("unreachable")
+		}
+		sent[key]++
+		return nil
+	}
+	old := NewSpooledQueue("test-handover-old", s, write)
+	for i := 0; i < 5; i++ {
+		old.WritePackets([]packets.Metadata{testPacket})
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	s, err = OpenSpool(dir)
+	if err != nil {
+		t.Fatalf("OpenSpool again: %v", err)
+	}
+	q := NewSpooledQueue("test-handover-new", s, write)
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	for i := 0; i < 5; i++ {
+		q.WritePackets([]packets.Metadata{testPacket})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	// Long enough for the first queue to have retried, had it kept going.
+	time.Sleep(300 * time.Millisecond)
+	old.Close()
+	q.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != 10 {
+		t.Errorf("buffers sent: got %d, want 10", len(sent))
+	}
+	for key, n := range sent {
+		if n != 1 {
+			t.Errorf("buffer %s sent %d times, want once", key, n)
+		}
+	}
+}
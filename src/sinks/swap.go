@@ -0,0 +1,79 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file lets the outputs be replaced while capturing, e.g. when the
+// config is reloaded.
+
+import (
+	"context"
+	"sync"
+
+	"packets"
+)
+
+// Swap is a Sink that passes everything to another, which can be replaced.
+type Swap struct {
+	mu sync.RWMutex
+	s  packets.Sink
+}
+
+// NewSwap returns a Swap passing to s (which may be nil, to drop
+// everything).
+func NewSwap(s packets.Sink) *Swap {
+	return &Swap{s: s}
+}
+
+// Set replaces the sink, returning the old one. Once Set returns, nothing
+// more is written to the old sink, so it can be flushed and closed.
+func (w *Swap) Set(s packets.Sink) packets.Sink {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	old := w.s
+	w.s = s
+	return old
+}
+
+// Write writes to the current sink.
+func (w *Swap) Write(ctx context.Context, data []packets.Metadata) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.s == nil {
+		return nil
+	}
+	return w.s.Write(ctx, data)
+}
+
+// Flush flushes the current sink.
+func (w *Swap) Flush(ctx context.Context) error {
+	w.mu.RLock()
+	s := w.s
+	w.mu.RUnlock()
+	if s == nil {
+		return nil
+	}
+	return s.Flush(ctx)
+}
+
+// Close closes the current sink.
+func (w *Swap) Close() error {
+	w.mu.RLock()
+	s := w.s
+	w.mu.RUnlock()
+	if s == nil {
+		return nil
+	}
+	return s.Close()
+}
@@ -0,0 +1,48 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"context"
+	"testing"
+
+	"packets"
+)
+
+func TestSwap(t *testing.T) {
+	var a, b int
+	count := func(n *int) packets.Sink {
+		return packets.SinkFunc(func(_ context.Context, data []packets.Metadata) error {
+			*n += len(data)
+			return nil
+		})
+	}
+	w := NewSwap(count(&a))
+	buf := []packets.Metadata{testPacket}
+	w.Write(context.Background(), buf)
+	old := w.Set(count(&b))
+	w.Write(context.Background(), buf)
+	w.Write(context.Background(), buf)
+	if a != 1 || b != 2 {
+		t.Errorf("written: got %d to the old sink and %d to the new, want 1 and 2", a, b)
+	}
+	if old == nil {
+		t.Error("Set: got nil old sink")
+	}
+	w.Set(nil)
+	if err := w.Write(context.Background(), buf); err != nil || b != 2 {
+		t.Errorf("Write with no sink: got %v, and %d written, want nil and 2", err, b)
+	}
+}
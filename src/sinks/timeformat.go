@@ -0,0 +1,101 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file has the timestamp formats outputs can be configured with, since
+// their destinations expect different ones.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"config"
+)
+
+// TimeFormat is how an output writes timestamps.
+type TimeFormat int
+
+const (
+	Nanoseconds  TimeFormat = iota // integer nanoseconds since the Unix epoch
+	Microseconds                   // integer microseconds since the Unix epoch
+	Milliseconds                   // integer milliseconds since the Unix epoch
+	Seconds                        // integer seconds since the Unix epoch
+	RFC3339                        // RFC 3339 text in UTC, with nanoseconds
+)
+
+var timeFormatNames = [...]string{"ns", "us", "ms", "s", "rfc3339"}
+
+func (f TimeFormat) String() string {
+	if f < 0 || int(f) >= len(timeFormatNames) {
+		return "TimeFormat(" + strconv.Itoa(int(f)) + ")"
+	}
+	return timeFormatNames[f]
+}
+
+// ParseTimeFormat parses the name of a TimeFormat: "ns", "us", "ms", "s" or
+// "rfc3339".
+func ParseTimeFormat(s string) (TimeFormat, error) {
+	for f, name := range timeFormatNames {
+		if strings.EqualFold(s, name) {
+			return TimeFormat(f), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown time format %q (known: %s)", s, strings.Join(timeFormatNames[:], ", "))
+}
+
+// AppendTime appends t in the format: a number, or for RFC3339, unquoted
+// text. Numbers are truncated to whole units.
+func (f TimeFormat) AppendTime(b []byte, t time.Time) []byte {
+	switch f {
+	case Microseconds:
+		return strconv.AppendInt(b, t.UnixNano()/1e3, 10)
+	case Milliseconds:
+		return strconv.AppendInt(b, t.UnixNano()/1e6, 10)
+	case Seconds:
+		return strconv.AppendInt(b, t.Unix(), 10)
+	case RFC3339:
+		return t.UTC().AppendFormat(b, time.RFC3339Nano)
+	}
+	return strconv.AppendInt(b, t.UnixNano(), 10)
+}
+
+// timeFormat returns the time format configured for the named output under
+// outputs.time_format, or def if there is none. It is an error to configure
+// a format the output doesn't support.
+func timeFormat(c *config.Config, name string, def TimeFormat, supported ...TimeFormat) (TimeFormat, error) {
+	s, ok := c.Outputs.TimeFormat[name]
+	if !ok {
+		return def, nil
+	}
+	f, err := ParseTimeFormat(s)
+	if err != nil {
+		return 0, fmt.Errorf("outputs.time_format.%s: %v", name, err)
+	}
+	if f == def {
+		return f, nil
+	}
+	for _, sf := range supported {
+		if f == sf {
+			return f, nil
+		}
+	}
+	names := []string{def.String()}
+	for _, sf := range supported {
+		names = append(names, sf.String())
+	}
+	return 0, fmt.Errorf("outputs.time_format.%s must be %s, not %q", name, strings.Join(names, ", "), s)
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"testing"
+	"time"
+
+	"config"
+)
+
+func TestTimeFormat(t *testing.T) {
+	ts := time.Unix(1434055562, 123456789)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"ns", "1434055562123456789"},
+		{"us", "1434055562123456"},
+		{"ms", "1434055562123"},
+		{"S", "1434055562"},
+		{"rfc3339", "2015-06-11T20:46:02.123456789Z"},
+	}
+	for _, test := range tests {
+		f, err := ParseTimeFormat(test.name)
+		if err != nil {
+			t.Errorf("ParseTimeFormat(%q): %v", test.name, err)
+			continue
+		}
+		if got := string(f.AppendTime(nil, ts)); got != test.want {
+			t.Errorf("%v.AppendTime(%v): got %s, want %s", f, ts, got, test.want)
+		}
+	}
+	if _, err := ParseTimeFormat("fortnights"); err == nil {
+		t.Error("ParseTimeFormat(fortnights): got nil error, want error")
+	}
+}
+
+func TestTimeFormatConfig(t *testing.T) {
+	tests := []struct {
+		set     string
+		want    TimeFormat
+		wantErr bool
+	}{
+		{"", Milliseconds, false},
+		{"ms", Milliseconds, false},
+		{"s", Seconds, false},
+		{"ns", 0, true},
+		{"weeks", 0, true},
+	}
+	for _, test := range tests {
+		c := config.Default()
+		if test.set != "" {
+			c.Outputs.TimeFormat = map[string]string{"test": test.set}
+		}
+		got, err := timeFormat(c, "test", Milliseconds, Seconds)
+		if (err != nil) != test.wantErr {
+			t.Errorf("timeFormat(%q): got error %v, want error %v", test.set, err, test.wantErr)
+			continue
+		}
+		if err == nil && got != test.want {
+			t.Errorf("timeFormat(%q): got %v, want %v", test.set, got, test.want)
+		}
+	}
+
+	// Formats for unknown outputs are mistakes.
+	c := config.Default()
+	c.Outputs.TimeFormat = map[string]string{"infux": "s"}
+	if _, err := Open(c, nil); err == nil {
+		t.Error("Open with a time format for an unknown output: got nil error, want error")
+	}
+}
@@ -0,0 +1,195 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file writes to VictoriaMetrics' JSON line import API.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"config"
+	"packets"
+)
+
+func init() {
+	Register("victoria", func(c *config.Config) (packets.Sink, error) {
+		if c.Outputs.Victoria == "" {
+			return nil, nil
+		}
+		if _, err := parseHTTPURL("outputs.victoria", c.Outputs.Victoria); err != nil {
+			return nil, err
+		}
+		// The import API only takes milliseconds.
+		if _, err := timeFormat(c, "victoria", Milliseconds); err != nil {
+			return nil, err
+		}
+		vw := &VictoriaWriter{URL: c.Outputs.Victoria}
+		return queued(c, "victoria", vw.WriteKeyed)
+	})
+	Register("questdb", func(c *config.Config) (packets.Sink, error) {
+		if c.Outputs.QuestDB == "" {
+			return nil, nil
+		}
+		if _, _, err := net.SplitHostPort(c.Outputs.QuestDB); err != nil {
+			return nil, fmt.Errorf("outputs.questdb must be host:port: %v", err)
+		}
+		// QuestDB's designated timestamps are in microseconds, but
+		// over ILP they are nanoseconds unless it is configured
+		// otherwise (line.tcp.timestamp).
+		qw := NewQuestDBWriter(c.Outputs.QuestDB)
+		var err error
+		if qw.Precision, err = timeFormat(c, "questdb", Nanoseconds, Microseconds, Milliseconds, Seconds); err != nil {
+			return nil, err
+		}
+		return queued(c, "questdb", IgnoreKey(qw.Write))
+	})
+}
+
+// victoriaSeries is one line of /api/v1/import input.
+type victoriaSeries struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []float64         `json:"values"`
+	Timestamps []int64           `json:"timestamps"`
+}
+
+// VictoriaWriter writes to a VictoriaMetrics server. Since VictoriaMetrics is
+// a time-series database, per-packet rows would be far too many series; each
+// buffer is summed into byte and packet counts per host pair and family,
+// timestamped at the last packet.
+type VictoriaWriter struct {
+	URL string // base URL, e.g. http://127.0.0.1:8428/
+}
+
+type hostPair struct {
+	srcIP, dstIP, srcName, dstName, family string
+}
+
+// encode produces the import body for a buffer, sorted for determinism.
+func (w *VictoriaWriter) encode(data []packets.Metadata) ([]byte, error) {
+	type counts struct {
+		bytes, packets float64
+		last           time.Time
+	}
+	agg := make(map[hostPair]*counts)
+	for i := range data {
+		m := &data[i]
+		k := hostPair{m.SrcIP.String(), m.DstIP.String(), m.SrcName, m.DstName, "v4"}
+		if m.V6 {
+			k.family = "v6"
+		}
+		c := agg[k]
+		if c == nil {
+			c = new(counts)
+			agg[k] = c
+		}
+		c.bytes += float64(m.Size)
+		c.packets++
+		if m.Timestamp.After(c.last) {
+			c.last = m.Timestamp
+		}
+	}
+	keys := make([]hostPair, 0, len(agg))
+	for k := range agg {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, k := range keys {
+		c := agg[k]
+		for _, s := range []struct {
+			name  string
+			value float64
+		}{
+			{"caplog_packet_bytes", c.bytes},
+			{"caplog_packet_count", c.packets},
+		} {
+			err := enc.Encode(victoriaSeries{
+				Metric: map[string]string{
+					"__name__": s.name,
+					"src_ip":   k.srcIP,
+					"dst_ip":   k.dstIP,
+					"src_name": k.srcName,
+					"dst_name": k.dstName,
+					"family":   k.family,
+				},
+				Values:     []float64{s.value},
+				Timestamps: []int64{c.last.UnixNano() / 1e6},
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// Write writes a buffer of packet metadata.
+func (w *VictoriaWriter) Write(data []packets.Metadata) error {
+	return w.WriteKeyed("", data)
+}
+
+// WriteKeyed is like Write, and sends the dedup key (if any) in an
+// Idempotency-Key header, for proxies that deduplicate. VictoriaMetrics
+// itself stores identical samples once, so replays are harmless anyway.
+func (w *VictoriaWriter) WriteKeyed(key string, data []packets.Metadata) error {
+	if len(data) == 0 {
+		return nil
+	}
+	body, err := w.encode(data)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimRight(w.URL, "/") + "/api/v1/import"
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return statusError("VictoriaMetrics import", resp, "")
+	}
+	return nil
+}
+
+// NewQuestDBWriter returns a writer for QuestDB's InfluxDB line protocol
+// (ILP) listener, by default on TCP port 9009. Rows go to the "packet" table,
+// which QuestDB creates on first write.
+func NewQuestDBWriter(addr string) *SocketWriter {
+	return &SocketWriter{
+		Network: "tcp",
+		Address: addr,
+	}
+}
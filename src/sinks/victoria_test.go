@@ -0,0 +1,54 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"packets"
+)
+
+func TestVictoriaWriter(t *testing.T) {
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/import" {
+			t.Errorf("request path: got %q, want /api/v1/import", r.URL.Path)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		got <- string(b)
+	}))
+	defer srv.Close()
+
+	second := testPacket
+	second.Timestamp = second.Timestamp.Add(time.Second)
+	second.Size = 26
+	w := &VictoriaWriter{URL: srv.URL + "/"}
+	if err := w.Write([]packets.Metadata{testPacket, second}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	want := `{"metric":{"__name__":"caplog_packet_bytes","dst_ip":"8.8.8.8","dst_name":"dns \"google\"","family":"v4","src_ip":"10.0.0.2","src_name":"10.0.0.2"},"values":[100],"timestamps":[1434055563000]}
+{"metric":{"__name__":"caplog_packet_count","dst_ip":"8.8.8.8","dst_name":"dns \"google\"","family":"v4","src_ip":"10.0.0.2","src_name":"10.0.0.2"},"values":[2],"timestamps":[1434055563000]}
+`
+	if g := <-got; g != want {
+		t.Errorf("import body:\ngot  %s\nwant %s", g, want)
+	}
+}
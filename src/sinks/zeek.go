@@ -0,0 +1,308 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+// This file writes flow records in the format of Zeek's conn.log, so Zeek
+// tooling (zeek-cut, RITA, Splunk and Elastic integrations...) can read
+// caplog's data.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"config"
+	"packets"
+)
+
+func init() {
+	RegisterFlows("zeek", func(c *config.Config) (FlowSink, error) {
+		if c.Outputs.ZeekConn == "" {
+			return nil, nil
+		}
+		switch c.Outputs.ZeekFormat {
+		case "", "tsv", "json":
+		default:
+			return nil, fmt.Errorf("outputs.zeek_format must be tsv or json, not %q", c.Outputs.ZeekFormat)
+		}
+		// Zeek's TSV is always in epoch seconds; its JSON can also
+		// have milliseconds or ISO 8601 (json_timestamps).
+		var supported []TimeFormat
+		if c.Outputs.ZeekFormat == "json" {
+			supported = []TimeFormat{Milliseconds, RFC3339}
+		}
+		tf, err := timeFormat(c, "zeek", Seconds, supported...)
+		if err != nil {
+			return nil, err
+		}
+		return &ZeekConnWriter{
+			Path:       c.Outputs.ZeekConn,
+			JSON:       c.Outputs.ZeekFormat == "json",
+			TimeFormat: tf,
+		}, nil
+	})
+}
+
+// maxZeekPending bounds the records kept while they can't be written.
+const maxZeekPending = 10000
+
+// zeekFields are the conn.log columns, with their Zeek types.
+var zeekFields = []struct{ name, typ string }{
+	{"ts", "time"},
+	{"uid", "string"},
+	{"id.orig_h", "addr"},
+	{"id.orig_p", "port"},
+	{"id.resp_h", "addr"},
+	{"id.resp_p", "port"},
+	{"proto", "enum"},
+	{"service", "string"},
+	{"duration", "interval"},
+	{"orig_bytes", "count"},
+	{"resp_bytes", "count"},
+	{"conn_state", "string"},
+	{"local_orig", "bool"},
+	{"local_resp", "bool"},
+	{"missed_bytes", "count"},
+	{"history", "string"},
+	{"orig_pkts", "count"},
+	{"orig_ip_bytes", "count"},
+	{"resp_pkts", "count"},
+	{"resp_ip_bytes", "count"},
+	{"tunnel_parents", "set[string]"},
+}
+
+// zeekConn is a flow record, with its Zeek connection ID. orig is the
+// flow's Src.
+type zeekConn struct {
+	packets.Flow
+	uid string
+}
+
+// ZeekConnWriter appends a conn.log record for each flow record. caplog
+// doesn't see payloads, so the byte counts are the orig_ip_bytes and
+// resp_ip_bytes columns (counting whole frames), and orig_bytes,
+// resp_bytes, service and history are unset. conn_state is OTH. For ICMP,
+// the ports are the type and code, as in Zeek.
+type ZeekConnWriter struct {
+	Path string
+
+	// JSON writes Zeek's JSON format (one object per line) instead of
+	// TSV with a header.
+	JSON bool
+
+	// TimeFormat is the format of ts in JSON: Milliseconds (an integer),
+	// RFC3339, or otherwise Zeek's default, epoch seconds with
+	// microseconds.
+	TimeFormat TimeFormat
+
+	mu      sync.Mutex
+	pending []zeekConn // not yet written
+}
+
+// newZeekUID makes a connection ID in Zeek's style: C and base62.
+func newZeekUID() string {
+	const digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	b := []byte{'C'}
+	for i := 0; i < 17; i++ {
+		b = append(b, digits[rand.Intn(len(digits))])
+	}
+	return string(b)
+}
+
+// Write appends the records, along with any left from a write that failed,
+// in order of their start. If writing fails, the records are kept to be
+// written with the next (up to maxZeekPending, dropping the oldest).
+func (w *ZeekConnWriter) Write(flows []packets.Flow) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, f := range flows {
+		w.pending = append(w.pending, zeekConn{Flow: f, uid: newZeekUID()})
+	}
+	if n := len(w.pending) - maxZeekPending; n > 0 {
+		w.pending = append(w.pending[:0], w.pending[n:]...)
+		return fmt.Errorf("zeek: dropped %d records that couldn't be written", n)
+	}
+	return w.writePending()
+}
+
+// writePending writes the pending records, and forgets them if that worked.
+// w.mu must be held.
+func (w *ZeekConnWriter) writePending() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	conns := w.pending
+	sort.SliceStable(conns, func(i, j int) bool { return conns[i].Start.Before(conns[j].Start) })
+
+	f, err := os.OpenFile(w.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if !w.JSON {
+		if fi, err := f.Stat(); err == nil && fi.Size() == 0 {
+			writeZeekHeader(bw, time.Now())
+		}
+	}
+	for i := range conns {
+		c := &conns[i]
+		if w.JSON {
+			b, err := json.Marshal(c.jsonRecord(w.TimeFormat))
+			if err != nil {
+				f.Close()
+				return err
+			}
+			bw.Write(b)
+			bw.WriteByte('\n')
+		} else {
+			bw.WriteString(strings.Join(c.tsvRecord(), "\t"))
+			bw.WriteByte('\n')
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	w.pending = nil
+	return nil
+}
+
+func writeZeekHeader(w *bufio.Writer, open time.Time) {
+	names := make([]string, len(zeekFields))
+	types := make([]string, len(zeekFields))
+	for i, f := range zeekFields {
+		names[i], types[i] = f.name, f.typ
+	}
+	fmt.Fprintf(w, "#separator \\x09\n")
+	fmt.Fprintf(w, "#set_separator\t,\n")
+	fmt.Fprintf(w, "#empty_field\t(empty)\n")
+	fmt.Fprintf(w, "#unset_field\t-\n")
+	fmt.Fprintf(w, "#path\tconn\n")
+	fmt.Fprintf(w, "#open\t%s\n", open.Format("2006-01-02-15-04-05"))
+	fmt.Fprintf(w, "#fields\t%s\n", strings.Join(names, "\t"))
+	fmt.Fprintf(w, "#types\t%s\n", strings.Join(types, "\t"))
+}
+
+func (c *zeekConn) protoName() string {
+	switch c.Protocol {
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	case 1, 58:
+		return "icmp"
+	}
+	return "unknown_transport"
+}
+
+func zeekBool(b bool) string {
+	if b {
+		return "T"
+	}
+	return "F"
+}
+
+func zeekTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', 6, 64)
+}
+
+// ports returns the orig and resp ports: for ICMP, the type and code.
+func (c *zeekConn) ports() (orig, resp uint16) {
+	if c.Protocol == 1 || c.Protocol == 58 {
+		return uint16(c.ICMPType), uint16(c.ICMPCode)
+	}
+	return c.SrcPort, c.DstPort
+}
+
+// tsvRecord returns the TSV columns, in the order of zeekFields.
+func (c *zeekConn) tsvRecord() []string {
+	origPort, respPort := c.ports()
+	return []string{
+		zeekTime(c.Start),
+		c.uid,
+		c.SrcIP.String(),
+		strconv.Itoa(int(origPort)),
+		c.DstIP.String(),
+		strconv.Itoa(int(respPort)),
+		c.protoName(),
+		"-",
+		strconv.FormatFloat(c.End.Sub(c.Start).Seconds(), 'f', 6, 64),
+		"-",
+		"-",
+		"OTH",
+		zeekBool(packets.IsLocal(c.SrcIP)),
+		zeekBool(packets.IsLocal(c.DstIP)),
+		"0",
+		"-",
+		strconv.FormatUint(c.SrcPackets, 10),
+		strconv.FormatUint(c.SrcBytes, 10),
+		strconv.FormatUint(c.DstPackets, 10),
+		strconv.FormatUint(c.DstBytes, 10),
+		"-",
+	}
+}
+
+// jsonRecord returns the record as Zeek writes it in JSON, leaving out
+// unset fields.
+func (c *zeekConn) jsonRecord(tf TimeFormat) map[string]interface{} {
+	var ts interface{}
+	switch tf {
+	case Milliseconds:
+		ts = json.Number(tf.AppendTime(nil, c.Start))
+	case RFC3339:
+		ts = string(tf.AppendTime(nil, c.Start))
+	default:
+		ts = json.Number(zeekTime(c.Start))
+	}
+	origPort, respPort := c.ports()
+	return map[string]interface{}{
+		"ts":            ts,
+		"uid":           c.uid,
+		"id.orig_h":     c.SrcIP.String(),
+		"id.orig_p":     origPort,
+		"id.resp_h":     c.DstIP.String(),
+		"id.resp_p":     respPort,
+		"proto":         c.protoName(),
+		"duration":      json.Number(strconv.FormatFloat(c.End.Sub(c.Start).Seconds(), 'f', 6, 64)),
+		"conn_state":    "OTH",
+		"local_orig":    packets.IsLocal(c.SrcIP),
+		"local_resp":    packets.IsLocal(c.DstIP),
+		"missed_bytes":  0,
+		"orig_pkts":     c.SrcPackets,
+		"orig_ip_bytes": c.SrcBytes,
+		"resp_pkts":     c.DstPackets,
+		"resp_ip_bytes": c.DstBytes,
+	}
+}
+
+// Close tries once more to write the records left from a write that
+// failed.
+func (w *ZeekConnWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writePending()
+}
@@ -0,0 +1,163 @@
+//go:build !nosinks
+
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"packets"
+)
+
+var zeekUIDPattern = regexp.MustCompile(`C[0-9A-Za-z]{17}`)
+
+// testFlow is a DNS query and its answer.
+var testFlow = packets.Flow{
+	Start:      time.Unix(1434055562, 0),
+	End:        time.Unix(1434055562, 20e6),
+	SrcIP:      net.ParseIP("10.0.0.2"),
+	DstIP:      net.ParseIP("8.8.8.8"),
+	SrcPort:    5353,
+	DstPort:    53,
+	Protocol:   17,
+	SrcPackets: 1,
+	SrcBytes:   74,
+	DstPackets: 1,
+	DstBytes:   90,
+	Reason:     packets.FlowIdle,
+}
+
+// zeekRecords reads the records (not the header) from a conn.log, with
+// their UIDs replaced by UID.
+func zeekRecords(t *testing.T, path string) []string {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var records []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			records = append(records, zeekUIDPattern.ReplaceAllString(line, "UID"))
+		}
+	}
+	return records
+}
+
+func TestZeekConnWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zeek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ping := packets.Flow{
+		Start:      testFlow.Start,
+		End:        testFlow.Start,
+		SrcIP:      testFlow.SrcIP,
+		DstIP:      testFlow.DstIP,
+		Protocol:   1,
+		ICMPType:   8,
+		SrcPackets: 1,
+		SrcBytes:   98,
+	}
+
+	tests := []struct {
+		json bool
+		tf   TimeFormat
+		want []string
+	}{
+		{false, Seconds, []string{
+			"1434055562.000000\tUID\t10.0.0.2\t5353\t8.8.8.8\t53\tudp\t-\t0.020000\t-\t-\tOTH\tT\tF\t0\t-\t1\t74\t1\t90\t-",
+			"1434055562.000000\tUID\t10.0.0.2\t8\t8.8.8.8\t0\ticmp\t-\t0.000000\t-\t-\tOTH\tT\tF\t0\t-\t1\t98\t0\t0\t-",
+		}},
+		{true, Seconds, []string{
+			`{"conn_state":"OTH","duration":0.020000,"id.orig_h":"10.0.0.2","id.orig_p":5353,"id.resp_h":"8.8.8.8","id.resp_p":53,"local_orig":true,"local_resp":false,"missed_bytes":0,"orig_ip_bytes":74,"orig_pkts":1,"proto":"udp","resp_ip_bytes":90,"resp_pkts":1,"ts":1434055562.000000,"uid":"UID"}`,
+			`{"conn_state":"OTH","duration":0.000000,"id.orig_h":"10.0.0.2","id.orig_p":8,"id.resp_h":"8.8.8.8","id.resp_p":0,"local_orig":true,"local_resp":false,"missed_bytes":0,"orig_ip_bytes":98,"orig_pkts":1,"proto":"icmp","resp_ip_bytes":0,"resp_pkts":0,"ts":1434055562.000000,"uid":"UID"}`,
+		}},
+		{true, Milliseconds, []string{
+			`{"conn_state":"OTH","duration":0.020000,"id.orig_h":"10.0.0.2","id.orig_p":5353,"id.resp_h":"8.8.8.8","id.resp_p":53,"local_orig":true,"local_resp":false,"missed_bytes":0,"orig_ip_bytes":74,"orig_pkts":1,"proto":"udp","resp_ip_bytes":90,"resp_pkts":1,"ts":1434055562000,"uid":"UID"}`,
+			`{"conn_state":"OTH","duration":0.000000,"id.orig_h":"10.0.0.2","id.orig_p":8,"id.resp_h":"8.8.8.8","id.resp_p":0,"local_orig":true,"local_resp":false,"missed_bytes":0,"orig_ip_bytes":98,"orig_pkts":1,"proto":"icmp","resp_ip_bytes":0,"resp_pkts":0,"ts":1434055562000,"uid":"UID"}`,
+		}},
+		{true, RFC3339, []string{
+			`{"conn_state":"OTH","duration":0.020000,"id.orig_h":"10.0.0.2","id.orig_p":5353,"id.resp_h":"8.8.8.8","id.resp_p":53,"local_orig":true,"local_resp":false,"missed_bytes":0,"orig_ip_bytes":74,"orig_pkts":1,"proto":"udp","resp_ip_bytes":90,"resp_pkts":1,"ts":"2015-06-11T20:46:02Z","uid":"UID"}`,
+			`{"conn_state":"OTH","duration":0.000000,"id.orig_h":"10.0.0.2","id.orig_p":8,"id.resp_h":"8.8.8.8","id.resp_p":0,"local_orig":true,"local_resp":false,"missed_bytes":0,"orig_ip_bytes":98,"orig_pkts":1,"proto":"icmp","resp_ip_bytes":0,"resp_pkts":0,"ts":"2015-06-11T20:46:02Z","uid":"UID"}`,
+		}},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, "conn.log")
+		os.Remove(path)
+		w := &ZeekConnWriter{Path: path, JSON: test.json, TimeFormat: test.tf}
+		if err := w.Write([]packets.Flow{testFlow, ping}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		b, _ := ioutil.ReadFile(path)
+		if !test.json && !strings.Contains(string(b), "#fields\tts\tuid\tid.orig_h") {
+			t.Errorf("TSV header missing:\n%s", b)
+		}
+		got := zeekRecords(t, path)
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("JSON %v records:\ngot  %q\nwant %q", test.json, got, test.want)
+		}
+	}
+}
+
+func TestZeekConnWriterFailedWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zeek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	later := testFlow
+	later.SrcPort = 5354
+	later.Start = testFlow.Start.Add(2 * time.Minute)
+	later.End = later.Start
+
+	// The directory doesn't exist yet, so the first write fails, and its
+	// record is written with the next.
+	sub := filepath.Join(dir, "logs")
+	w := &ZeekConnWriter{Path: filepath.Join(sub, "conn.log")}
+	if err := w.Write([]packets.Flow{testFlow}); err == nil {
+		t.Fatal("Write to a missing directory: got nil error")
+	}
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]packets.Flow{later}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	want := []string{
+		"1434055562.000000\tUID\t10.0.0.2\t5353\t8.8.8.8\t53\tudp\t-\t0.020000\t-\t-\tOTH\tT\tF\t0\t-\t1\t74\t1\t90\t-",
+		"1434055682.000000\tUID\t10.0.0.2\t5354\t8.8.8.8\t53\tudp\t-\t0.000000\t-\t-\tOTH\tT\tF\t0\t-\t1\t74\t1\t90\t-",
+	}
+	if got := zeekRecords(t, w.Path); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("records:\ngot  %q\nwant %q", got, want)
+	}
+}
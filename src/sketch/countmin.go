@@ -0,0 +1,82 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sketch
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// CountMin estimates per-key totals in fixed memory (Cormode and
+// Muthukrishnan's count-min sketch). Estimates are never too low, and are
+// too high by at most ε × the grand total with probability 1-δ. It is not
+// safe for concurrent use.
+type CountMin struct {
+	width  uint64
+	counts [][]uint64
+}
+
+// NewCountMin returns a sketch with error bound epsilon (relative to the
+// grand total) and failure probability delta, e.g. 0.001 and 0.01.
+func NewCountMin(epsilon, delta float64) *CountMin {
+	width := uint64(math.Ceil(math.E / epsilon))
+	depth := int(math.Ceil(math.Log(1 / delta)))
+	if depth < 1 {
+		depth = 1
+	}
+	cm := &CountMin{
+		width:  width,
+		counts: make([][]uint64, depth),
+	}
+	for i := range cm.counts {
+		cm.counts[i] = make([]uint64, width)
+	}
+	return cm
+}
+
+// cells calls f with the cell of key in each row.
+func (cm *CountMin) cells(key string, f func(row []uint64, i uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	// Kirsch-Mitzenmacher: two hashes are as good as depth independent ones.
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	for d, row := range cm.counts {
+		f(row, (h1+uint64(d)*h2)%cm.width)
+	}
+}
+
+// Add adds n to key's total and returns the new estimate.
+func (cm *CountMin) Add(key string, n uint64) uint64 {
+	est := uint64(math.MaxUint64)
+	cm.cells(key, func(row []uint64, i uint64) {
+		row[i] += n
+		if row[i] < est {
+			est = row[i]
+		}
+	})
+	return est
+}
+
+// Estimate returns the estimated total for key.
+func (cm *CountMin) Estimate(key string) uint64 {
+	est := uint64(math.MaxUint64)
+	cm.cells(key, func(row []uint64, i uint64) {
+		if row[i] < est {
+			est = row[i]
+		}
+	})
+	return est
+}
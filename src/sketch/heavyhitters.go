@@ -0,0 +1,121 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sketch
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// Item is a key and its (estimated) count.
+type Item struct {
+	Key   string
+	Count uint64
+}
+
+// HeavyHitters finds the k keys with the largest totals, using a CountMin
+// for the totals and a min-heap of the current top k. Memory is bounded no
+// matter how many distinct keys there are. It is not safe for concurrent use.
+type HeavyHitters struct {
+	k   int
+	cm  *CountMin
+	top itemHeap
+	pos map[string]int // index in top
+}
+
+// NewHeavyHitters returns a HeavyHitters tracking the top k keys.
+func NewHeavyHitters(k int) *HeavyHitters {
+	hh := &HeavyHitters{
+		k:   k,
+		cm:  NewCountMin(heavyHittersEpsilon(k), 0.01),
+		pos: make(map[string]int, k),
+	}
+	hh.top.pos = hh.pos
+	return hh
+}
+
+// heavyHittersEpsilon returns the CountMin error bound for finding the top
+// k keys: a tenth of the share each would have if the total were split
+// evenly among them, so the sketch grows with k (about 109KB for the
+// default 100, and 22KB for lite's 20) rather than always taking a
+// megabyte.
+func heavyHittersEpsilon(k int) float64 {
+	const min, max = 0.0001, 0.01
+	if k < 1 {
+		return max
+	}
+	return math.Max(min, math.Min(max, 1/(10*float64(k))))
+}
+
+// Add adds n to key's total.
+func (hh *HeavyHitters) Add(key string, n uint64) {
+	est := hh.cm.Add(key, n)
+	if i, ok := hh.pos[key]; ok {
+		hh.top.items[i].Count = est
+		heap.Fix(&hh.top, i)
+		return
+	}
+	if len(hh.top.items) < hh.k {
+		heap.Push(&hh.top, Item{Key: key, Count: est})
+		return
+	}
+	if est > hh.top.items[0].Count {
+		delete(hh.pos, hh.top.items[0].Key)
+		hh.top.items[0] = Item{Key: key, Count: est}
+		hh.pos[key] = 0
+		heap.Fix(&hh.top, 0)
+	}
+}
+
+// Top returns the heavy hitters, largest first.
+func (hh *HeavyHitters) Top() []Item {
+	items := append([]Item(nil), hh.top.items...)
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Key < items[j].Key
+	})
+	return items
+}
+
+// itemHeap is a min-heap of items by count, which keeps pos up to date.
+type itemHeap struct {
+	items []Item
+	pos   map[string]int
+}
+
+func (h *itemHeap) Len() int           { return len(h.items) }
+func (h *itemHeap) Less(i, j int) bool { return h.items[i].Count < h.items[j].Count }
+
+func (h *itemHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.pos[h.items[i].Key] = i
+	h.pos[h.items[j].Key] = j
+}
+
+func (h *itemHeap) Push(x interface{}) {
+	it := x.(Item)
+	h.pos[it.Key] = len(h.items)
+	h.items = append(h.items, it)
+}
+
+func (h *itemHeap) Pop() interface{} {
+	it := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.pos, it.Key)
+	return it
+}
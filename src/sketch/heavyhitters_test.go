@@ -0,0 +1,86 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sketch
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCountMinNeverUnderestimates(t *testing.T) {
+	cm := NewCountMin(0.01, 0.01)
+	want := make(map[string]uint64)
+	for i := 0; i < 5000; i++ {
+		k := fmt.Sprintf("host%d", i%700)
+		cm.Add(k, uint64(i%13))
+		want[k] += uint64(i % 13)
+	}
+	var total uint64
+	for _, n := range want {
+		total += n
+	}
+	for k, n := range want {
+		got := cm.Estimate(k)
+		if got < n {
+			t.Errorf("Estimate(%q): got %d, less than the true %d", k, got, n)
+		}
+		if got > n+total/10 {
+			t.Errorf("Estimate(%q): got %d, far above the true %d", k, got, n)
+		}
+	}
+}
+
+func TestHeavyHitters(t *testing.T) {
+	hh := NewHeavyHitters(3)
+	// Lots of mice, interleaved with three elephants.
+	for i := 0; i < 10000; i++ {
+		hh.Add(fmt.Sprintf("mouse%d", i), 10)
+		switch i % 100 {
+		case 0:
+			hh.Add("elephant-a", 3000)
+		case 1:
+			hh.Add("elephant-b", 2000)
+		case 2:
+			hh.Add("elephant-c", 1000)
+		}
+	}
+	var got []string
+	for _, it := range hh.Top() {
+		got = append(got, it.Key)
+	}
+	if want := []string{"elephant-a", "elephant-b", "elephant-c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Top: got %v, want %v", got, want)
+	}
+}
+
+func TestHeavyHittersSize(t *testing.T) {
+	tests := []struct {
+		k         int
+		wantWidth uint64
+	}{
+		{0, 272},
+		{3, 272},
+		{20, 544},
+		{100, 2719},
+		{5000, 27183},
+	}
+	for _, test := range tests {
+		hh := NewHeavyHitters(test.k)
+		if got := hh.cm.width; got != test.wantWidth {
+			t.Errorf("NewHeavyHitters(%d): got width %d, want %d", test.k, got, test.wantWidth)
+		}
+	}
+}
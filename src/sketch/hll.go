@@ -0,0 +1,101 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sketch
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// HLL estimates the number of distinct keys added (Flajolet et al.'s
+// HyperLogLog), in 2^precision bytes. The standard error is about
+// 1.04/sqrt(2^precision): 6.5% at precision 8. It is not safe for concurrent
+// use.
+type HLL struct {
+	p   uint8
+	reg []uint8
+}
+
+// NewHLL returns an empty HLL. Precision must be between 4 and 16.
+func NewHLL(precision uint8) *HLL {
+	if precision < 4 {
+		precision = 4
+	}
+	if precision > 16 {
+		precision = 16
+	}
+	return &HLL{
+		p:   precision,
+		reg: make([]uint8, 1<<precision),
+	}
+}
+
+// hash64 hashes a key, mixing the bits well enough for HLL (which needs
+// the high and low bits to be independent).
+func hash64(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	// splitmix64's finaliser.
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Add adds a key.
+func (h *HLL) Add(key string) {
+	x := hash64(key)
+	i := x >> (64 - h.p)
+	// The rank is the position of the first 1 bit in the rest of the hash.
+	// The guard bit bounds it when the rest is all zero.
+	rank := uint8(bits.LeadingZeros64(x<<h.p|1<<(h.p-1))) + 1
+	if rank > h.reg[i] {
+		h.reg[i] = rank
+	}
+}
+
+// Count estimates the number of distinct keys added.
+func (h *HLL) Count() uint64 {
+	m := float64(len(h.reg))
+	var sum float64
+	zeros := 0
+	for _, r := range h.reg {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	var alpha float64
+	switch len(h.reg) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	est := alpha * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		// Small range correction: linear counting is better here.
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(est + 0.5)
+}
@@ -0,0 +1,37 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sketch
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHLL(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 100, 1000, 50000} {
+		h := NewHLL(10)
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+			h.Add(key)
+			h.Add(key) // duplicates don't count
+		}
+		got := float64(h.Count())
+		// 3 standard errors at precision 10 is about 10%.
+		if math.Abs(got-float64(n)) > 0.1*float64(n)+1 {
+			t.Errorf("Count after %d distinct keys: got %v", n, got)
+		}
+	}
+}
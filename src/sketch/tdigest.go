@@ -0,0 +1,141 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sketch has small streaming summaries of large data: they answer
+// approximately, in bounded memory.
+package sketch
+
+import (
+	"math"
+	"sort"
+)
+
+type centroid struct {
+	mean, weight float64
+}
+
+// TDigest estimates quantiles of a stream of values (Dunning's t-digest, the
+// merging variant). It is accurate near the tails, which is where the
+// interesting flows are. It is not safe for concurrent use.
+type TDigest struct {
+	compression float64
+	centroids   []centroid // sorted by mean
+	buf         []centroid // not yet merged
+	count       float64
+	min, max    float64
+}
+
+// NewTDigest returns an empty digest. Higher compression is more accurate
+// and uses more memory; 100 is a good default.
+func NewTDigest(compression float64) *TDigest {
+	if compression <= 0 {
+		compression = 100
+	}
+	return &TDigest{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// Add adds a value.
+func (t *TDigest) Add(x float64) {
+	if math.IsNaN(x) {
+		return
+	}
+	t.buf = append(t.buf, centroid{x, 1})
+	t.count++
+	if x < t.min {
+		t.min = x
+	}
+	if x > t.max {
+		t.max = x
+	}
+	if len(t.buf) >= int(5*t.compression) {
+		t.compress()
+	}
+}
+
+// Count returns the number of values added.
+func (t *TDigest) Count() uint64 {
+	return uint64(t.count)
+}
+
+// compress merges the buffer into the centroids.
+func (t *TDigest) compress() {
+	if len(t.buf) == 0 {
+		return
+	}
+	all := append(t.centroids, t.buf...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+	out := make([]centroid, 0, int(2*t.compression))
+	cur, soFar := all[0], 0.0
+	for _, c := range all[1:] {
+		// Centroids may only be as big as the scale function allows at
+		// their quantile: small near 0 and 1, larger in the middle.
+		q := (soFar + (cur.weight+c.weight)/2) / t.count
+		if cur.weight+c.weight <= 4*t.count*q*(1-q)/t.compression {
+			w := cur.weight + c.weight
+			cur.mean += (c.mean - cur.mean) * c.weight / w
+			cur.weight = w
+			continue
+		}
+		soFar += cur.weight
+		out = append(out, cur)
+		cur = c
+	}
+	t.centroids = append(out, cur)
+	t.buf = t.buf[:0]
+}
+
+// Quantile estimates the value at quantile q (0 <= q <= 1). It returns NaN
+// if the digest is empty.
+func (t *TDigest) Quantile(q float64) float64 {
+	t.compress()
+	if len(t.centroids) == 0 {
+		return math.NaN()
+	}
+	switch {
+	case q <= 0:
+		return t.min
+	case q >= 1:
+		return t.max
+	}
+	target := q * t.count
+	// Each centroid's mean is taken to sit at the middle of its weight, and
+	// values are interpolated linearly between neighbouring centres.
+	prevMean, prevPos := t.min, 0.0
+	soFar := 0.0
+	for _, c := range t.centroids {
+		pos := soFar + c.weight/2
+		if target < pos {
+			return interpolate(prevMean, t.clamp(c.mean), prevPos, pos, target)
+		}
+		prevMean, prevPos = t.clamp(c.mean), pos
+		soFar += c.weight
+	}
+	return interpolate(prevMean, t.max, prevPos, t.count, target)
+}
+
+func (t *TDigest) clamp(x float64) float64 {
+	return math.Max(t.min, math.Min(t.max, x))
+}
+
+// interpolate returns the value at pos between (pos0, x0) and (pos1, x1).
+func interpolate(x0, x1, pos0, pos1, pos float64) float64 {
+	if pos1 <= pos0 {
+		return x1
+	}
+	return x0 + (x1-x0)*(pos-pos0)/(pos1-pos0)
+}
@@ -0,0 +1,61 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sketch
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestTDigestUniform(t *testing.T) {
+	td := NewTDigest(100)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		td.Add(r.Float64() * 1000)
+	}
+	if got, want := td.Count(), uint64(100000); got != want {
+		t.Errorf("Count: got %d, want %d", got, want)
+	}
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.99} {
+		if got, want := td.Quantile(q), q*1000; math.Abs(got-want) > 10 {
+			t.Errorf("Quantile(%v): got %v, want %v ± 10", q, got, want)
+		}
+	}
+}
+
+func TestTDigestSkewed(t *testing.T) {
+	// Mostly mice, a few elephants: the mean says nothing useful, but p99
+	// should find the elephants.
+	td := NewTDigest(100)
+	for i := 0; i < 9800; i++ {
+		td.Add(100)
+	}
+	for i := 0; i < 200; i++ {
+		td.Add(1e9)
+	}
+	if got := td.Quantile(0.5); got != 100 {
+		t.Errorf("Quantile(0.5): got %v, want 100", got)
+	}
+	if got := td.Quantile(0.99); got != 1e9 {
+		t.Errorf("Quantile(0.99): got %v, want 1e9", got)
+	}
+}
+
+func TestTDigestEmpty(t *testing.T) {
+	if got := NewTDigest(100).Quantile(0.5); !math.IsNaN(got) {
+		t.Errorf("Quantile of empty digest: got %v, want NaN", got)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package snmp is a minimal SNMPv2c agent, so that network management
+// systems that only speak SNMP can poll caplog.
+package snmp
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"time"
+)
+
+// Var is a variable binding. Value is a uint64 (a Counter64), string (an
+// OCTET STRING), OID, or time.Duration (TimeTicks).
+type Var struct {
+	OID   OID
+	Value interface{}
+}
+
+const (
+	version2c = 1
+
+	// maxResponse keeps responses within a typical UDP datagram.
+	maxResponse = 1400
+
+	maxBulkRepetitions = 50
+)
+
+// Agent answers Get, GetNext and GetBulk requests for a fixed community.
+// Set requests, SNMPv1 and SNMPv3 are not supported.
+type Agent struct {
+	Community string
+
+	// Vars returns the current variables.
+	Vars func() []Var
+}
+
+// Serve answers requests on conn until it fails.
+func (a *Agent) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		resp, err := a.handle(buf[:n])
+		if err != nil {
+			// Malformed or unauthorised; say nothing, as agents do.
+			continue
+		}
+		if _, err := conn.WriteTo(resp, addr); err != nil {
+			log.Printf("snmp: %v", err)
+		}
+	}
+}
+
+// ListenAndServe listens on the UDP address and serves requests.
+func (a *Agent) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return a.Serve(conn)
+}
+
+// handle answers one request message.
+func (a *Agent) handle(msg []byte) ([]byte, error) {
+	r := reader(msg)
+	body, err := r.expect(tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	r = reader(body)
+	version, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	if version != version2c {
+		return nil, errors.New("snmp: only v2c is supported")
+	}
+	community, err := r.expect(tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+	if string(community) != a.Community {
+		return nil, errors.New("snmp: wrong community")
+	}
+	pduTag, pdu, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	r = reader(pdu)
+	reqID, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	// For GetBulk, these are non-repeaters and max-repetitions.
+	p1, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	p2, err := r.int()
+	if err != nil {
+		return nil, err
+	}
+	vbs, err := r.expect(tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	var oids []OID
+	for vr := reader(vbs); len(vr) > 0; {
+		vb, err := vr.expect(tagSequence)
+		if err != nil {
+			return nil, err
+		}
+		o, err := (*reader)(&vb).oid()
+		if err != nil {
+			return nil, err
+		}
+		oids = append(oids, o)
+	}
+
+	vars := a.Vars()
+	sort.Slice(vars, func(i, j int) bool { return vars[i].OID.Compare(vars[j].OID) < 0 })
+	var out []Var
+	switch pduTag {
+	case tagGetRequest:
+		for _, o := range oids {
+			out = append(out, get(vars, o))
+		}
+	case tagGetNext:
+		for _, o := range oids {
+			out = append(out, getNext(vars, o))
+		}
+	case tagGetBulk:
+		nonRep, maxRep := int(p1), int(p2)
+		if nonRep < 0 {
+			nonRep = 0
+		}
+		if nonRep > len(oids) {
+			nonRep = len(oids)
+		}
+		if maxRep > maxBulkRepetitions {
+			maxRep = maxBulkRepetitions
+		}
+		for _, o := range oids[:nonRep] {
+			out = append(out, getNext(vars, o))
+		}
+		rep := oids[nonRep:]
+		for i := 0; i < maxRep && len(rep) > 0; i++ {
+			for j, o := range rep {
+				v := getNext(vars, o)
+				out = append(out, v)
+				rep[j] = v.OID
+			}
+		}
+	default:
+		return nil, errors.New("snmp: unsupported PDU")
+	}
+	return encodeResponse(community, reqID, out), nil
+}
+
+// get returns the variable o, or noSuchObject.
+func get(vars []Var, o OID) Var {
+	i := sort.Search(len(vars), func(i int) bool { return vars[i].OID.Compare(o) >= 0 })
+	if i < len(vars) && vars[i].OID.Compare(o) == 0 {
+		return vars[i]
+	}
+	return Var{OID: o, Value: noSuchObject{}}
+}
+
+// getNext returns the variable after o, or endOfMibView.
+func getNext(vars []Var, o OID) Var {
+	i := sort.Search(len(vars), func(i int) bool { return vars[i].OID.Compare(o) > 0 })
+	if i < len(vars) {
+		return vars[i]
+	}
+	return Var{OID: o, Value: endOfMIBView{}}
+}
+
+type noSuchObject struct{}
+type endOfMIBView struct{}
+
+// encodeResponse makes a Response message, leaving out trailing variables
+// (GetBulk results may be truncated) if it would be too big.
+func encodeResponse(community []byte, reqID int64, vars []Var) []byte {
+	for {
+		var vbs []byte
+		for _, v := range vars {
+			vb := appendOID(nil, v.OID)
+			switch x := v.Value.(type) {
+			case uint64:
+				vb = appendUint(vb, tagCounter64, x)
+			case string:
+				vb = appendTLV(vb, tagOctetString, []byte(x))
+			case OID:
+				vb = appendOID(vb, x)
+			case time.Duration:
+				vb = appendUint(vb, tagTimeTicks, uint64(x/(10*time.Millisecond))&0xffffffff)
+			case noSuchObject:
+				vb = appendTLV(vb, tagNoSuchObject, nil)
+			case endOfMIBView:
+				vb = appendTLV(vb, tagEndOfMIBView, nil)
+			default:
+				vb = appendTLV(vb, tagNull, nil)
+			}
+			vbs = appendTLV(vbs, tagSequence, vb)
+		}
+		pdu := appendInt(nil, tagInteger, reqID)
+		pdu = appendInt(pdu, tagInteger, 0) // error-status
+		pdu = appendInt(pdu, tagInteger, 0) // error-index
+		pdu = appendTLV(pdu, tagSequence, vbs)
+		msg := appendInt(nil, tagInteger, version2c)
+		msg = appendTLV(msg, tagOctetString, community)
+		msg = appendTLV(msg, tagResponse, pdu)
+		msg = appendTLV(nil, tagSequence, msg)
+		if len(msg) <= maxResponse || len(vars) <= 1 {
+			return msg
+		}
+		vars = vars[:len(vars)/2]
+	}
+}
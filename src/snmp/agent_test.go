@@ -0,0 +1,172 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snmp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// request builds a request message.
+func request(version int64, community string, pdu byte, p1, p2 int64, oids ...OID) []byte {
+	var vbs []byte
+	for _, o := range oids {
+		vbs = appendTLV(vbs, tagSequence, appendTLV(appendOID(nil, o), tagNull, nil))
+	}
+	body := appendInt(nil, tagInteger, 42)
+	body = appendInt(body, tagInteger, p1)
+	body = appendInt(body, tagInteger, p2)
+	body = appendTLV(body, tagSequence, vbs)
+	msg := appendInt(nil, tagInteger, version)
+	msg = appendTLV(msg, tagOctetString, []byte(community))
+	msg = appendTLV(msg, pdu, body)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+// parseResponse decodes a response into OIDs and raw values.
+func parseResponse(t *testing.T, msg []byte) (reqID int64, oids []string, tags []byte, values [][]byte) {
+	r := reader(msg)
+	body, err := r.expect(tagSequence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = reader(body)
+	r.int()
+	r.expect(tagOctetString)
+	pdu, err := r.expect(tagResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = reader(pdu)
+	reqID, _ = r.int()
+	r.int()
+	r.int()
+	vbs, _ := r.expect(tagSequence)
+	for vr := reader(vbs); len(vr) > 0; {
+		vb, _ := vr.expect(tagSequence)
+		br := reader(vb)
+		o, err := br.oid()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tag, v, _ := br.next()
+		oids, tags, values = append(oids, o.String()), append(tags, tag), append(values, v)
+	}
+	return
+}
+
+func TestAgent(t *testing.T) {
+	a := &Agent{
+		Community: "public",
+		Vars: func() []Var {
+			return []Var{
+				{MustParseOID("1.3.6.1.4.1.11129.10.1.1.2"), uint64(300)},
+				{MustParseOID("1.3.6.1.2.1.1.3.0"), 2 * time.Second},
+				{MustParseOID("1.3.6.1.4.1.11129.10.1.1.1"), uint64(1) << 40},
+			}
+		},
+	}
+	base := MustParseOID("1.3.6.1.4.1.11129.10")
+
+	tests := []struct {
+		name  string
+		req   []byte
+		oids  []string
+		tags  []byte
+		value []byte // of the first
+	}{
+		{
+			name:  "get",
+			req:   request(1, "public", tagGetRequest, 0, 0, MustParseOID("1.3.6.1.4.1.11129.10.1.1.1")),
+			oids:  []string{"1.3.6.1.4.1.11129.10.1.1.1"},
+			tags:  []byte{tagCounter64},
+			value: []byte{1, 0, 0, 0, 0, 0},
+		},
+		{
+			name: "get missing",
+			req:  request(1, "public", tagGetRequest, 0, 0, base),
+			oids: []string{"1.3.6.1.4.1.11129.10"},
+			tags: []byte{tagNoSuchObject},
+		},
+		{
+			name:  "get next",
+			req:   request(1, "public", tagGetNext, 0, 0, MustParseOID("1.3.6.1")),
+			oids:  []string{"1.3.6.1.2.1.1.3.0"},
+			tags:  []byte{tagTimeTicks},
+			value: []byte{0, 200},
+		},
+		{
+			name: "get bulk",
+			req:  request(1, "public", tagGetBulk, 0, 5, base),
+			oids: []string{"1.3.6.1.4.1.11129.10.1.1.1", "1.3.6.1.4.1.11129.10.1.1.2", "1.3.6.1.4.1.11129.10.1.1.2", "1.3.6.1.4.1.11129.10.1.1.2", "1.3.6.1.4.1.11129.10.1.1.2"},
+			tags: []byte{tagCounter64, tagCounter64, tagEndOfMIBView, tagEndOfMIBView, tagEndOfMIBView},
+		},
+	}
+	for _, test := range tests {
+		resp, err := a.handle(test.req)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		id, oids, tags, values := parseResponse(t, resp)
+		if id != 42 {
+			t.Errorf("%s: request ID: got %d, want 42", test.name, id)
+		}
+		if !reflect.DeepEqual(oids, test.oids) || !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("%s: got %v %x, want %v %x", test.name, oids, tags, test.oids, test.tags)
+			continue
+		}
+		if test.value != nil && !reflect.DeepEqual(values[0], test.value) {
+			t.Errorf("%s: value: got %x, want %x", test.name, values[0], test.value)
+		}
+	}
+
+	for _, req := range [][]byte{
+		request(1, "private", tagGetRequest, 0, 0, base),
+		request(0, "public", tagGetRequest, 0, 0, base),
+		{0x30, 0x05, 0x02},
+	} {
+		if _, err := a.handle(req); err == nil {
+			t.Errorf("handle(%x): got nil error, want error", req)
+		}
+	}
+}
+
+func TestAppendInt(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want []byte
+	}{
+		{0, []byte{0}},
+		{127, []byte{0x7f}},
+		{128, []byte{0, 0x80}},
+		{-1, []byte{0xff}},
+		{-129, []byte{0xff, 0x7f}},
+		{1 << 31, []byte{0, 0x80, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		b := appendInt(nil, tagInteger, test.n)
+		r := reader(b)
+		v, err := r.expect(tagInteger)
+		if err != nil || !reflect.DeepEqual(v, test.want) {
+			t.Errorf("appendInt(%d): got %x (%v), want %x", test.n, v, err, test.want)
+		}
+		r = reader(b)
+		if got, _ := r.int(); got != test.n {
+			t.Errorf("int() of appendInt(%d): got %d", test.n, got)
+		}
+	}
+}
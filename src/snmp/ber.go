@@ -0,0 +1,258 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snmp
+
+// This file encodes and decodes the small part of BER that SNMP uses.
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tags.
+const (
+	tagInteger       = 0x02
+	tagOctetString   = 0x04
+	tagNull          = 0x05
+	tagOID           = 0x06
+	tagSequence      = 0x30
+	tagTimeTicks     = 0x43
+	tagCounter64     = 0x46
+	tagNoSuchObject  = 0x80
+	tagEndOfMIBView  = 0x82
+	tagGetRequest    = 0xa0
+	tagGetNext       = 0xa1
+	tagResponse      = 0xa2
+	tagGetBulk       = 0xa5
+	maxLengthOctets  = 4
+	maxOIDComponents = 128
+)
+
+var errTruncated = errors.New("snmp: truncated message")
+
+// OID is an object identifier, such as 1.3.6.1.2.1.1.1.0.
+type OID []uint32
+
+// ParseOID parses a dotted OID.
+func ParseOID(s string) (OID, error) {
+	var o OID
+	for _, p := range strings.Split(strings.TrimPrefix(s, "."), ".") {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad OID %q: %v", s, err)
+		}
+		o = append(o, uint32(n))
+	}
+	return o, nil
+}
+
+// MustParseOID is ParseOID, panicking on error.
+func MustParseOID(s string) OID {
+	o, err := ParseOID(s)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+func (o OID) String() string {
+	ps := make([]string, len(o))
+	for i, n := range o {
+		ps[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(ps, ".")
+}
+
+// Append returns o with more components.
+func (o OID) Append(ns ...uint32) OID {
+	return append(append(OID(nil), o...), ns...)
+}
+
+// Compare orders OIDs lexicographically, as GetNext walks them.
+func (o OID) Compare(p OID) int {
+	for i := 0; i < len(o) && i < len(p); i++ {
+		switch {
+		case o[i] < p[i]:
+			return -1
+		case o[i] > p[i]:
+			return 1
+		}
+	}
+	switch {
+	case len(o) < len(p):
+		return -1
+	case len(o) > len(p):
+		return 1
+	}
+	return 0
+}
+
+// appendTLV appends a tag, length and value.
+func appendTLV(b []byte, tag byte, v []byte) []byte {
+	b = append(b, tag)
+	switch n := len(v); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	case n <= 0xffff:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, v...)
+}
+
+func appendInt(b []byte, tag byte, n int64) []byte {
+	var v []byte
+	for i := 7; i > 0; i-- {
+		// Drop leading bytes that are only sign extension.
+		hi, next := byte(n>>(8*uint(i))), byte(n>>(8*uint(i-1)))
+		if (hi == 0 && next&0x80 == 0) || (hi == 0xff && next&0x80 != 0) {
+			continue
+		}
+		for ; i >= 0; i-- {
+			v = append(v, byte(n>>(8*uint(i))))
+		}
+		break
+	}
+	if v == nil {
+		v = []byte{byte(n)}
+	}
+	return appendTLV(b, tag, v)
+}
+
+func appendUint(b []byte, tag byte, n uint64) []byte {
+	v := []byte{0} // room for a leading zero, so it isn't negative
+	for i := 7; i >= 0; i-- {
+		v = append(v, byte(n>>(8*uint(i))))
+	}
+	for len(v) > 1 && v[0] == 0 && v[1]&0x80 == 0 {
+		v = v[1:]
+	}
+	return appendTLV(b, tag, v)
+}
+
+func appendOID(b []byte, o OID) []byte {
+	var v []byte
+	if len(o) >= 2 {
+		v = appendBase128(v, o[0]*40+o[1])
+		for _, n := range o[2:] {
+			v = appendBase128(v, n)
+		}
+	}
+	return appendTLV(b, tagOID, v)
+}
+
+func appendBase128(b []byte, n uint32) []byte {
+	var tmp [5]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(n & 0x7f)
+	for n >>= 7; n > 0; n >>= 7 {
+		i--
+		tmp[i] = byte(n&0x7f) | 0x80
+	}
+	return append(b, tmp[i:]...)
+}
+
+// reader reads BER TLVs from a buffer.
+type reader []byte
+
+// next reads a TLV, returning its tag and value.
+func (r *reader) next() (byte, []byte, error) {
+	b := *r
+	if len(b) < 2 {
+		return 0, nil, errTruncated
+	}
+	tag, n := b[0], int(b[1])
+	b = b[2:]
+	if n&0x80 != 0 {
+		octets := n & 0x7f
+		if octets == 0 || octets > maxLengthOctets || len(b) < octets {
+			return 0, nil, errTruncated
+		}
+		n = 0
+		for _, c := range b[:octets] {
+			n = n<<8 | int(c)
+		}
+		b = b[octets:]
+	}
+	if n < 0 || len(b) < n {
+		return 0, nil, errTruncated
+	}
+	*r = b[n:]
+	return tag, b[:n], nil
+}
+
+// expect reads a TLV, which must have the tag.
+func (r *reader) expect(tag byte) ([]byte, error) {
+	t, v, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	if t != tag {
+		return nil, fmt.Errorf("snmp: got tag %#x, want %#x", t, tag)
+	}
+	return v, nil
+}
+
+func (r *reader) int() (int64, error) {
+	v, err := r.expect(tagInteger)
+	if err != nil {
+		return 0, err
+	}
+	if len(v) == 0 || len(v) > 8 {
+		return 0, fmt.Errorf("snmp: bad integer length %d", len(v))
+	}
+	n := int64(int8(v[0]))
+	for _, c := range v[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+func (r *reader) oid() (OID, error) {
+	v, err := r.expect(tagOID)
+	if err != nil {
+		return nil, err
+	}
+	var o OID
+	var n uint32
+	for i, c := range v {
+		n = n<<7 | uint32(c&0x7f)
+		if c&0x80 != 0 {
+			if i == len(v)-1 {
+				return nil, errTruncated
+			}
+			continue
+		}
+		if o == nil {
+			if n < 80 {
+				o = OID{n / 40, n % 40}
+			} else {
+				o = OID{2, n - 80}
+			}
+		} else {
+			o = append(o, n)
+		}
+		if len(o) > maxOIDComponents {
+			return nil, errors.New("snmp: OID too long")
+		}
+		n = 0
+	}
+	return o, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snmp
+
+// This file defines the variables caplog exposes: the MIB-2 system group,
+// and the top-level counters under Google's enterprise number.
+//
+//	1.3.6.1.4.1.11129.10.1.<counter>.1   bytes (Counter64)
+//	1.3.6.1.4.1.11129.10.1.<counter>.2   packets (Counter64)
+//
+// where <counter> is 1 total, 2 up, 3 down, 4 internal, 5 external, 6 IPv4,
+// 7 IPv6.
+
+import (
+	"os"
+	"time"
+
+	"dashboard"
+)
+
+var (
+	sysDescr    = MustParseOID("1.3.6.1.2.1.1.1.0")
+	sysObjectID = MustParseOID("1.3.6.1.2.1.1.2.0")
+	sysUpTime   = MustParseOID("1.3.6.1.2.1.1.3.0")
+	sysName     = MustParseOID("1.3.6.1.2.1.1.5.0")
+
+	// CaplogOID is the root of caplog's own variables.
+	CaplogOID = MustParseOID("1.3.6.1.4.1.11129.10")
+)
+
+var started = time.Now()
+
+// CaplogVars returns the system group and the dashboard's top-level
+// counters.
+func CaplogVars() []Var {
+	host, _ := os.Hostname()
+	vars := []Var{
+		{sysDescr, "caplog network monitor"},
+		{sysObjectID, CaplogOID},
+		{sysUpTime, time.Since(started)},
+		{sysName, host},
+	}
+	v := dashboard.State()
+	counters := CaplogOID.Append(1)
+	for i, a := range []dashboard.Aggregation{v.Total, v.Up, v.Down, v.Internal, v.External, v.V4, v.V6} {
+		vars = append(vars,
+			Var{counters.Append(uint32(i+1), 1), a.Bytes},
+			Var{counters.Append(uint32(i+1), 2), a.Packets},
+		)
+	}
+	return vars
+}
@@ -0,0 +1,155 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package suricata reads alerts from Suricata's EVE JSON log.
+package suricata
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// Alert is a Suricata alert.
+type Alert struct {
+	Time             time.Time
+	FlowID           uint64 `json:",omitempty"`
+	SrcIP, DstIP     net.IP
+	SrcPort, DstPort uint16
+	Proto            string
+
+	Signature   string
+	SignatureID int
+	Category    string `json:",omitempty"`
+	Severity    int    // 1 (high) to 3 (low)
+	Action      string // "allowed" or "blocked"
+}
+
+// eveTime is the format of EVE timestamps.
+const eveTime = "2006-01-02T15:04:05.999999-0700"
+
+// eveRecord is the part of an EVE record needed for alerts.
+type eveRecord struct {
+	Timestamp string `json:"timestamp"`
+	EventType string `json:"event_type"`
+	FlowID    uint64 `json:"flow_id"`
+	SrcIP     string `json:"src_ip"`
+	SrcPort   uint16 `json:"src_port"`
+	DestIP    string `json:"dest_ip"`
+	DestPort  uint16 `json:"dest_port"`
+	Proto     string `json:"proto"`
+	Alert     *struct {
+		Action      string `json:"action"`
+		SignatureID int    `json:"signature_id"`
+		Signature   string `json:"signature"`
+		Category    string `json:"category"`
+		Severity    int    `json:"severity"`
+	} `json:"alert"`
+}
+
+// ParseAlert parses a line of EVE JSON. It returns false for records that
+// aren't alerts.
+func ParseAlert(line []byte) (Alert, bool, error) {
+	var r eveRecord
+	if err := json.Unmarshal(line, &r); err != nil {
+		return Alert{}, false, err
+	}
+	if r.EventType != "alert" || r.Alert == nil {
+		return Alert{}, false, nil
+	}
+	t, err := time.Parse(eveTime, r.Timestamp)
+	if err != nil {
+		return Alert{}, false, err
+	}
+	return Alert{
+		Time:        t,
+		FlowID:      r.FlowID,
+		SrcIP:       net.ParseIP(r.SrcIP),
+		DstIP:       net.ParseIP(r.DestIP),
+		SrcPort:     r.SrcPort,
+		DstPort:     r.DestPort,
+		Proto:       r.Proto,
+		Signature:   r.Alert.Signature,
+		SignatureID: r.Alert.SignatureID,
+		Category:    r.Alert.Category,
+		Severity:    r.Alert.Severity,
+		Action:      r.Alert.Action,
+	}, true, nil
+}
+
+// pollInterval is how often Follow checks for new lines.
+const pollInterval = time.Second
+
+// Follow reads alerts appended to an EVE log, like tail -F: it starts at the
+// end of the file, and starts again from the top when the file is rotated
+// or truncated. It calls f with each alert, and never returns.
+func Follow(path string, f func(Alert)) {
+	var (
+		file   *os.File
+		r      *bufio.Reader
+		offset int64
+		whence = io.SeekEnd // the first time, skip the alerts already there
+		logged bool         // an error opening the file has been logged
+	)
+	for ; ; time.Sleep(pollInterval) {
+		if file == nil {
+			var err error
+			if file, err = os.Open(path); err != nil {
+				if !logged {
+					log.Printf("suricata: %v", err)
+					logged = true
+				}
+				// Everything in it will be new.
+				whence = io.SeekStart
+				continue
+			}
+			logged = false
+			if offset, err = file.Seek(0, whence); err != nil {
+				log.Printf("suricata: %v", err)
+			}
+			r = bufio.NewReader(file)
+		}
+		for {
+			line, err := r.ReadBytes('\n')
+			if err != nil {
+				// At the end, perhaps part way through a line: read it
+				// again once it's finished.
+				file.Seek(offset, io.SeekStart)
+				r.Reset(file)
+				break
+			}
+			offset += int64(len(line))
+			a, ok, err := ParseAlert(line)
+			if err != nil {
+				log.Printf("suricata: %v", err)
+				continue
+			}
+			if ok {
+				f(a)
+			}
+		}
+		// Rotated or truncated?
+		st, err1 := file.Stat()
+		cur, err2 := os.Stat(path)
+		if err1 != nil || err2 != nil || !os.SameFile(st, cur) || cur.Size() < offset {
+			file.Close()
+			file = nil
+			whence = io.SeekStart
+		}
+	}
+}
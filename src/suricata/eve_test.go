@@ -0,0 +1,118 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suricata
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testAlert = `{"timestamp":"2016-03-01T12:00:00.250000+0000","flow_id":1234,"event_type":"alert","src_ip":"192.168.1.10","src_port":50000,"dest_ip":"203.0.113.9","dest_port":80,"proto":"TCP","alert":{"action":"allowed","gid":1,"signature_id":2013028,"rev":5,"signature":"ET POLICY curl User-Agent Outbound","category":"Attempted Information Leak","severity":2}}`
+
+func TestParseAlert(t *testing.T) {
+	a, ok, err := ParseAlert([]byte(testAlert))
+	if err != nil || !ok {
+		t.Fatalf("ParseAlert: got %v, %v, want an alert", ok, err)
+	}
+	want := Alert{
+		Time:        time.Date(2016, 3, 1, 12, 0, 0, 250000000, time.UTC),
+		FlowID:      1234,
+		SrcIP:       net.ParseIP("192.168.1.10"),
+		DstIP:       net.ParseIP("203.0.113.9"),
+		SrcPort:     50000,
+		DstPort:     80,
+		Proto:       "TCP",
+		Signature:   "ET POLICY curl User-Agent Outbound",
+		SignatureID: 2013028,
+		Category:    "Attempted Information Leak",
+		Severity:    2,
+		Action:      "allowed",
+	}
+	if !a.Time.Equal(want.Time) {
+		t.Errorf("Time: got %v, want %v", a.Time, want.Time)
+	}
+	a.Time = want.Time
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("ParseAlert:\ngot  %+v\nwant %+v", a, want)
+	}
+
+	if _, ok, err := ParseAlert([]byte(`{"timestamp":"2016-03-01T12:00:00.250000+0000","event_type":"flow"}`)); ok || err != nil {
+		t.Errorf("ParseAlert(flow): got %v, %v, want false, nil", ok, err)
+	}
+	if _, _, err := ParseAlert([]byte(`{`)); err == nil {
+		t.Error("ParseAlert(bad JSON): got nil error")
+	}
+}
+
+func TestFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "suricata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "eve.json")
+	// Alerts already there are skipped.
+	if err := ioutil.WriteFile(path, []byte(testAlert+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan Alert, 10)
+	go Follow(path, func(a Alert) { got <- a })
+	time.Sleep(2 * pollInterval)
+
+	appendLine := func(s string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(s)
+		f.Close()
+	}
+	// A line written in two parts is read once it's finished.
+	appendLine(testAlert[:50])
+	time.Sleep(2 * pollInterval)
+	appendLine(testAlert[50:] + "\n")
+	select {
+	case a := <-got:
+		if a.FlowID != 1234 {
+			t.Errorf("Follow: got flow %d, want 1234", a.FlowID)
+		}
+	case <-time.After(5 * pollInterval):
+		t.Fatal("Follow: no alert after appending")
+	}
+
+	// After rotation, the new file is read from the top.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(testAlert+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-got:
+	case <-time.After(5 * pollInterval):
+		t.Fatal("Follow: no alert after rotation")
+	}
+	select {
+	case a := <-got:
+		t.Errorf("Follow: unexpected alert %+v", a)
+	default:
+	}
+}
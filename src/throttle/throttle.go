@@ -0,0 +1,118 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package throttle watches caplog's own CPU use, and asks for less work to
+// be done when it is too high: the monitor should never be the thing that
+// melts the router.
+package throttle
+
+import (
+	"log"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"vars"
+)
+
+// Monitor samples CPU use every Interval. While it is above Limit, the
+// throttle level goes up one step per interval (to at most MaxLevel); once it
+// is below half the Limit, it comes down one step per interval.
+type Monitor struct {
+	// Limit is the fraction of all CPUs caplog may use, e.g. 0.5.
+	Limit float64
+
+	Interval time.Duration
+	MaxLevel int
+
+	// SetLevel is called whenever the level changes. Level 0 is
+	// unthrottled.
+	SetLevel func(level int)
+
+	level int32 // accessed atomically
+}
+
+// ShedEnrichers are the packet enrichers given up at level 1 and above:
+// reverse DNS names, ASNs and QUIC names.
+var ShedEnrichers = []string{"revdns", "asn", "quic"}
+
+// Enrichers is what turns enrichers on and off, such as a packets.Capture.
+type Enrichers interface {
+	SetEnricher(name string, on bool) bool
+}
+
+// SetEnrichers turns off the ShedEnrichers above level 0, and at level 0
+// turns them back on if configured(name) says they are on without
+// throttling.
+func SetEnrichers(e Enrichers, level int, configured func(name string) bool) {
+	for _, name := range ShedEnrichers {
+		e.SetEnricher(name, level == 0 && configured(name))
+	}
+}
+
+// cpuTime returns the CPU time used by the process so far.
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// Level returns the current throttle level.
+func (m *Monitor) Level() int {
+	return int(atomic.LoadInt32(&m.level))
+}
+
+// step decides the next level given the CPU use (a fraction of all CPUs).
+func (m *Monitor) step(level int, use float64) int {
+	switch {
+	case use > m.Limit && level < m.MaxLevel:
+		return level + 1
+	case use < m.Limit/2 && level > 0:
+		return level - 1
+	}
+	return level
+}
+
+// Run monitors CPU use forever.
+func (m *Monitor) Run() {
+	if m.Interval <= 0 {
+		m.Interval = 5 * time.Second
+	}
+	vars.Register("throttle-level", vars.IntEval(m.Level).String)
+	cpus := float64(runtime.NumCPU())
+	lastCPU, lastWall := cpuTime(), time.Now()
+	for range time.Tick(m.Interval) {
+		cpu, wall := cpuTime(), time.Now()
+		use := float64(cpu-lastCPU) / float64(wall.Sub(lastWall)) / cpus
+		lastCPU, lastWall = cpu, wall
+
+		level := m.Level()
+		next := m.step(level, use)
+		if next == level {
+			continue
+		}
+		if next > level {
+			log.Printf("throttle: using %.0f%% of CPU (limit %.0f%%), throttling to level %d", use*100, m.Limit*100, next)
+		} else {
+			log.Printf("throttle: using %.0f%% of CPU, easing off to level %d", use*100, next)
+		}
+		atomic.StoreInt32(&m.level, int32(next))
+		if m.SetLevel != nil {
+			m.SetLevel(next)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package throttle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStep(t *testing.T) {
+	m := &Monitor{Limit: 0.5, MaxLevel: 2}
+	tests := []struct {
+		level int
+		use   float64
+		want  int
+	}{
+		{0, 0.1, 0},
+		{0, 0.6, 1},
+		{1, 0.9, 2},
+		{2, 0.9, 2}, // already at max
+		{2, 0.4, 2}, // below the limit, but not by enough to ease off
+		{2, 0.2, 1},
+		{0, 0.0, 0},
+	}
+	for _, test := range tests {
+		if got := m.step(test.level, test.use); got != test.want {
+			t.Errorf("step(%d, %v): got %d, want %d", test.level, test.use, got, test.want)
+		}
+	}
+}
+
+func TestCPUTime(t *testing.T) {
+	before := cpuTime()
+	x := 0
+	for i := 0; i < 1e7; i++ {
+		x += i
+	}
+	if cpuTime() < before {
+		t.Errorf("cpuTime went backwards (x = %d)", x)
+	}
+}
+
+// enrichers records which enrichers are on.
+type enrichers map[string]bool
+
+func (e enrichers) SetEnricher(name string, on bool) bool {
+	e[name] = on
+	return true
+}
+
+func TestSetEnrichers(t *testing.T) {
+	e := enrichers{}
+	all := func(string) bool { return true }
+	SetEnrichers(e, 1, all)
+	for _, name := range []string{"revdns", "asn", "quic"} {
+		if on, ok := e[name]; !ok || on {
+			t.Errorf("at level 1, enricher %s: got on %v (set %v), want off", name, on, ok)
+		}
+	}
+	SetEnrichers(e, 0, func(name string) bool { return name != "revdns" })
+	if want := (enrichers{"revdns": false, "asn": true, "quic": true}); !reflect.DeepEqual(e, want) {
+		t.Errorf("back at level 0: got %v, want %v", e, want)
+	}
+}